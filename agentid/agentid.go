@@ -16,7 +16,8 @@ package agentid
 
 import (
 	"github.com/google/uuid"
-	"ubbagent/persistence"
+
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
 )
 
 const agentIdKey = "agentid"
@@ -25,20 +26,28 @@ type idHolder struct {
 	AgentId string
 }
 
-func CreateOrGet(p persistence.Persistence) (string, error) {
+// CreateOrGet returns this agent's persisted ID, generating and storing one if it doesn't exist
+// yet. fallback, if non-empty, is used as the newly-generated ID instead of a random UUID - for
+// example, a GCE instance ID, which is stable across restarts of an agent running with no
+// persistent state directory. An empty fallback always falls back to a random UUID.
+func CreateOrGet(p persistence.Persistence, fallback string) (string, error) {
+	v := p.Value(agentIdKey)
 	holder := idHolder{}
-	err := p.Load(agentIdKey, &holder)
+	err := v.Load(&holder)
 	if err != nil && err != persistence.ErrNotFound {
 		return "", err
 	}
 	if err == persistence.ErrNotFound {
-		id, err := uuid.NewRandom()
-		if err != nil {
-			return "", err
+		id := fallback
+		if id == "" {
+			generated, err := uuid.NewRandom()
+			if err != nil {
+				return "", err
+			}
+			id = generated.String()
 		}
-		holder.AgentId = id.String()
-		err = p.Store(agentIdKey, &holder)
-		if err != nil {
+		holder.AgentId = id
+		if err := v.Store(&holder); err != nil {
 			return "", err
 		}
 	}
@@ -25,15 +25,15 @@ func TestCreateOrGet(t *testing.T) {
 	p1 := persistence.NewMemoryPersistence()
 	p2 := persistence.NewMemoryPersistence()
 
-	id1, err := agentid.CreateOrGet(p1)
+	id1, err := agentid.CreateOrGet(p1, "")
 	if err != nil {
 		t.Fatalf("error creating agentid: %+v", err)
 	}
-	id2, err := agentid.CreateOrGet(p2)
+	id2, err := agentid.CreateOrGet(p2, "")
 	if err != nil {
 		t.Fatalf("error creating agentid: %+v", err)
 	}
-	id1Again, err := agentid.CreateOrGet(p1)
+	id1Again, err := agentid.CreateOrGet(p1, "")
 	if err != nil {
 		t.Fatalf("error creating agentid: %+v", err)
 	}
@@ -45,3 +45,24 @@ func TestCreateOrGet(t *testing.T) {
 		t.Fatalf("agentid.CreateOrGet returned same ID for same persistence, but got different IDs: %v, %v", id1, id1Again)
 	}
 }
+
+func TestCreateOrGet_Fallback(t *testing.T) {
+	p := persistence.NewMemoryPersistence()
+
+	id, err := agentid.CreateOrGet(p, "instance-1234")
+	if err != nil {
+		t.Fatalf("error creating agentid: %+v", err)
+	}
+	if id != "instance-1234" {
+		t.Fatalf("agentid.CreateOrGet = %v, want fallback %v", id, "instance-1234")
+	}
+
+	// A later call with a different fallback must still return the already-persisted ID.
+	idAgain, err := agentid.CreateOrGet(p, "instance-5678")
+	if err != nil {
+		t.Fatalf("error creating agentid: %+v", err)
+	}
+	if idAgain != "instance-1234" {
+		t.Fatalf("agentid.CreateOrGet = %v, want previously persisted %v", idAgain, "instance-1234")
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auditlog records a tamper-evident trail of every outbound send attempt the agent makes,
+// for marketplace vendor compliance scenarios where every billed unit must be reconstructible
+// after the fact. Entries are appended as JSON Lines, each one's Hash chained from the previous
+// entry's Hash, so any modification, deletion, or reordering of existing entries is detectable by
+// Verify. Hash is an HMAC keyed from the key passed to NewLog, rather than a plain digest, so that
+// an entry can't be regenerated by someone with only filesystem write access to the log's own
+// directory - they'd also need the key, which is expected to live elsewhere (e.g. a mounted
+// Secret or a KMS-wrapped file; see config.AuditLog.KeyFile).
+package auditlog
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+)
+
+const (
+	fileMode      = 0644
+	directoryMode = 0755
+
+	// fileName is the live (currently being appended to) log file, under the directory passed to
+	// NewLog.
+	fileName = "audit.jsonl"
+
+	// rotatedGlob matches both the live file and any rotated-aside ones. Rotated names sort
+	// lexically before the live name ('-' < '.'), and their timestamp suffix sorts chronologically,
+	// so entryFiles can recover chain order with a plain string sort.
+	rotatedGlob = "audit*.jsonl"
+
+	// maxFileBytes bounds the size of the live log file before it's rotated aside and a fresh one
+	// is started, so a long-running agent doesn't accumulate a single unbounded file.
+	maxFileBytes = 10 * 1024 * 1024
+
+	// genesisHash seeds the chain for a log's very first entry.
+	genesisHash = ""
+)
+
+// Outcome describes the result of a single send attempt.
+type Outcome string
+
+const (
+	OutcomeSuccess   Outcome = "success"
+	OutcomeTransient Outcome = "transient"
+	OutcomePermanent Outcome = "permanent"
+)
+
+// Entry is a single, hash-chained record of an outbound send attempt.
+type Entry struct {
+	Sequence  int64     `json:"sequence"`
+	Timestamp time.Time `json:"timestamp"`
+	Endpoint  string    `json:"endpoint"`
+	ReportId  string    `json:"reportId"`
+	Outcome   Outcome   `json:"outcome"`
+
+	// Detail carries the error message when Outcome isn't OutcomeSuccess. It's empty on success.
+	Detail string `json:"detail,omitempty"`
+
+	// PrevHash is the Hash of the previous Entry in the chain, or genesisHash for the first entry
+	// in a log.
+	PrevHash string `json:"prevHash"`
+
+	// Hash is the HMAC-SHA256, hex-encoded, of this Entry's other fields (PrevHash included),
+	// keyed so that it also authenticates the entry - not just checksums it - binding it to
+	// everything that came before it.
+	Hash string `json:"hash"`
+}
+
+// hash computes the HMAC-SHA256 of e's fields other than Hash itself, keyed with key.
+func (e *Entry) hash(key []byte) string {
+	tmp := *e
+	tmp.Hash = ""
+	b, _ := json.Marshal(tmp)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuditLog records outbound send attempts. See Log for the real, disk-backed implementation and
+// NewNoopLog for a no-op stand-in used when the agent has no persistent state directory.
+type AuditLog interface {
+	// Append records a single send attempt, chaining it to the current Head, and returns the
+	// written Entry.
+	Append(endpoint, reportId string, outcome Outcome, detail string) (Entry, error)
+
+	// Head returns the Hash of the most recently appended Entry, or the empty string if none have
+	// been appended yet.
+	Head() string
+}
+
+// Log is an AuditLog that persists its chain as rotated JSON-Lines files under a directory.
+type Log struct {
+	dir   string
+	clock clock.Clock
+	key   []byte
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	seq  int64
+	head string
+}
+
+// NewLog creates or resumes an audit Log under dir, which is created if it doesn't already
+// exist, authenticating every entry's Hash with key. If dir already contains log files from a
+// previous run, they're replayed (and re-authenticated with key) to recover the current sequence
+// number and chain head, so the chain continues seamlessly across restarts.
+func NewLog(dir string, key []byte) (*Log, error) {
+	return newLog(dir, clock.NewClock(), key)
+}
+
+func newLog(dir string, clk clock.Clock, key []byte) (*Log, error) {
+	if err := os.MkdirAll(dir, directoryMode); err != nil {
+		return nil, fmt.Errorf("auditlog: creating directory: %v", err)
+	}
+	seq, head, err := recoverChain(dir)
+	if err != nil {
+		return nil, err
+	}
+	livePath := filepath.Join(dir, fileName)
+	var size int64
+	if fi, err := os.Stat(livePath); err == nil {
+		size = fi.Size()
+	}
+	f, err := os.OpenFile(livePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("auditlog: opening log file: %v", err)
+	}
+	return &Log{dir: dir, clock: clk, key: key, file: f, size: size, seq: seq, head: head}, nil
+}
+
+// Append records a new Entry describing a single send attempt, chaining it to the current head.
+func (l *Log) Append(endpoint, reportId string, outcome Outcome, detail string) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.maybeRotate(); err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{
+		Sequence:  l.seq + 1,
+		Timestamp: l.clock.Now().UTC(),
+		Endpoint:  endpoint,
+		ReportId:  reportId,
+		Outcome:   outcome,
+		Detail:    detail,
+		PrevHash:  l.head,
+	}
+	e.Hash = e.hash(l.key)
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return Entry{}, err
+	}
+	line = append(line, '\n')
+	if _, err := l.file.Write(line); err != nil {
+		return Entry{}, fmt.Errorf("auditlog: writing entry: %v", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return Entry{}, fmt.Errorf("auditlog: syncing entry: %v", err)
+	}
+
+	l.seq = e.Sequence
+	l.head = e.Hash
+	l.size += int64(len(line))
+	return e, nil
+}
+
+// maybeRotate renames the live file aside under a timestamped name and starts a fresh one, if the
+// live file has grown past maxFileBytes. The chain itself (sequence and head hash) is unaffected;
+// only the file it's appended to changes.
+func (l *Log) maybeRotate() error {
+	if l.size < maxFileBytes {
+		return nil
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("auditlog: closing log file for rotation: %v", err)
+	}
+	livePath := filepath.Join(l.dir, fileName)
+	rotatedPath := filepath.Join(l.dir, fmt.Sprintf("audit-%v.jsonl", l.clock.Now().UTC().Format("20060102T150405.000000000Z")))
+	if err := os.Rename(livePath, rotatedPath); err != nil {
+		return fmt.Errorf("auditlog: rotating log file: %v", err)
+	}
+	f, err := os.OpenFile(livePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		return fmt.Errorf("auditlog: creating rotated log file: %v", err)
+	}
+	l.file = f
+	l.size = 0
+	return nil
+}
+
+// Head returns the Hash of the most recently appended Entry, or the empty string if the log is
+// empty.
+func (l *Log) Head() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// Close closes the live log file. The Log must not be used afterward.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// recoverChain replays every log file under dir, in chain order, and returns the sequence number
+// and head hash of the last Entry found, or (0, genesisHash) if dir has no log files yet.
+func recoverChain(dir string) (seq int64, head string, err error) {
+	head = genesisHash
+	files, err := entryFiles(dir)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, file := range files {
+		entries, err := readEntries(file)
+		if err != nil {
+			return 0, "", err
+		}
+		for _, e := range entries {
+			seq = e.Sequence
+			head = e.Hash
+		}
+	}
+	return seq, head, nil
+}
+
+// entryFiles returns the audit log files under dir in chain order: oldest rotated file first, the
+// live file (fileName) last.
+func entryFiles(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, rotatedGlob))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// readEntries reads every Entry from file, in order.
+func readEntries(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("auditlog: reading %v: %v", file, err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("auditlog: parsing %v: %v", file, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("auditlog: reading %v: %v", file, err)
+	}
+	return entries, nil
+}
+
+// NewNoopLog returns an AuditLog that discards every entry and always reports an empty Head. It's
+// used when the agent has no persistent state directory to anchor a tamper-evident chain to.
+func NewNoopLog() AuditLog {
+	return &noopLog{}
+}
+
+type noopLog struct{}
+
+func (*noopLog) Append(endpoint, reportId string, outcome Outcome, detail string) (Entry, error) {
+	return Entry{}, nil
+}
+
+func (*noopLog) Head() string {
+	return ""
+}
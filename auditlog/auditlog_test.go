@@ -0,0 +1,268 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/testlib"
+)
+
+// testKey is the HMAC key used by tests that don't specifically exercise key handling.
+var testKey = []byte("test-hmac-key")
+
+func TestLog_AppendAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mc := testlib.NewMockClock()
+	mc.SetNow(time.Unix(1000, 0))
+	log, err := newLog(dir, mc, testKey)
+	if err != nil {
+		t.Fatalf("newLog: %+v", err)
+	}
+
+	e1, err := log.Append("servicecontrol-main", "report1", OutcomeSuccess, "")
+	if err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+	if e1.PrevHash != genesisHash {
+		t.Errorf("expected first entry's PrevHash to be the genesis hash, got: %v", e1.PrevHash)
+	}
+	if e1.Hash == "" {
+		t.Error("expected a non-empty Hash")
+	}
+	if log.Head() != e1.Hash {
+		t.Errorf("Head() = %v, expected %v", log.Head(), e1.Hash)
+	}
+
+	e2, err := log.Append("servicecontrol-main", "report2", OutcomeTransient, "connection reset")
+	if err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+	if e2.PrevHash != e1.Hash {
+		t.Errorf("expected second entry's PrevHash to chain from the first, got: %v", e2.PrevHash)
+	}
+	if log.Head() != e2.Hash {
+		t.Errorf("Head() = %v, expected %v", log.Head(), e2.Hash)
+	}
+
+	result, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatalf("Verify: %+v", err)
+	}
+	if result.Broken() {
+		t.Fatalf("expected an intact chain, got broken: %v", result.Reason)
+	}
+	if result.EntryCount != 2 {
+		t.Errorf("expected 2 verified entries, got: %v", result.EntryCount)
+	}
+	if result.Head != e2.Hash {
+		t.Errorf("result.Head = %v, expected %v", result.Head, e2.Hash)
+	}
+}
+
+func TestLog_Resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mc := testlib.NewMockClock()
+	log1, err := newLog(dir, mc, testKey)
+	if err != nil {
+		t.Fatalf("newLog: %+v", err)
+	}
+	first, err := log1.Append("disk-archive", "report1", OutcomeSuccess, "")
+	if err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+	if err := log1.Close(); err != nil {
+		t.Fatalf("Close: %+v", err)
+	}
+
+	log2, err := newLog(dir, mc, testKey)
+	if err != nil {
+		t.Fatalf("newLog (resume): %+v", err)
+	}
+	second, err := log2.Append("disk-archive", "report2", OutcomeSuccess, "")
+	if err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+	if second.PrevHash != first.Hash {
+		t.Errorf("expected the resumed log to chain from the prior run's head, got: %v", second.PrevHash)
+	}
+
+	result, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatalf("Verify: %+v", err)
+	}
+	if result.Broken() {
+		t.Fatalf("expected an intact chain, got broken: %v", result.Reason)
+	}
+	if result.EntryCount != 2 {
+		t.Errorf("expected 2 verified entries, got: %v", result.EntryCount)
+	}
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mc := testlib.NewMockClock()
+	log, err := newLog(dir, mc, testKey)
+	if err != nil {
+		t.Fatalf("newLog: %+v", err)
+	}
+	if _, err := log.Append("servicecontrol-main", "report1", OutcomeSuccess, ""); err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+	if _, err := log.Append("servicecontrol-main", "report2", OutcomePermanent, "invalid consumer ID"); err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close: %+v", err)
+	}
+
+	// Tamper with the second entry's recorded outcome without recomputing its hash.
+	path := filepath.Join(dir, fileName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %+v", err)
+	}
+	tampered := []byte(replaceOnce(string(data), `"outcome":"permanent"`, `"outcome":"success"`))
+	if err := ioutil.WriteFile(path, tampered, fileMode); err != nil {
+		t.Fatalf("WriteFile: %+v", err)
+	}
+
+	result, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatalf("Verify: %+v", err)
+	}
+	if !result.Broken() {
+		t.Fatal("expected tampering to be detected, but the chain verified as intact")
+	}
+	if result.BrokenSequence != 2 {
+		t.Errorf("expected the break to be reported at sequence 2, got: %v", result.BrokenSequence)
+	}
+	if result.EntryCount != 1 {
+		t.Errorf("expected 1 entry to verify before the break, got: %v", result.EntryCount)
+	}
+}
+
+func TestVerify_WrongKeyDetectsForgedChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mc := testlib.NewMockClock()
+	log, err := newLog(dir, mc, testKey)
+	if err != nil {
+		t.Fatalf("newLog: %+v", err)
+	}
+	if _, err := log.Append("servicecontrol-main", "report1", OutcomeSuccess, ""); err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close: %+v", err)
+	}
+
+	// Verifying with a different key must fail even though the chain itself, and every entry's
+	// Sequence and PrevHash, are perfectly intact - only someone holding testKey can produce a
+	// Hash that verifies.
+	result, err := Verify(dir, []byte("not-the-real-key"))
+	if err != nil {
+		t.Fatalf("Verify: %+v", err)
+	}
+	if !result.Broken() {
+		t.Fatal("expected verification with the wrong key to fail, but the chain verified as intact")
+	}
+	if result.BrokenSequence != 1 {
+		t.Errorf("expected the break to be reported at sequence 1, got: %v", result.BrokenSequence)
+	}
+}
+
+// replaceOnce replaces the first occurrence of old in s with new.
+func replaceOnce(s, old, new string) string {
+	i := indexOf(s, old)
+	if i < 0 {
+		return s
+	}
+	return s[:i] + new + s[i+len(old):]
+}
+
+func indexOf(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if s[i:i+len(substr)] == substr {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestLog_Rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mc := testlib.NewMockClock()
+	log, err := newLog(dir, mc, testKey)
+	if err != nil {
+		t.Fatalf("newLog: %+v", err)
+	}
+
+	// Force a rotation on the next Append by pretending the live file has already grown past the
+	// size threshold.
+	log.size = maxFileBytes
+
+	mc.SetNow(mc.Now().Add(time.Second))
+	if _, err := log.Append("servicecontrol-main", "report1", OutcomeSuccess, ""); err != nil {
+		t.Fatalf("Append: %+v", err)
+	}
+
+	files, err := entryFiles(dir)
+	if err != nil {
+		t.Fatalf("entryFiles: %+v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 log files after rotation (rotated + live), got: %v", files)
+	}
+
+	result, err := Verify(dir, testKey)
+	if err != nil {
+		t.Fatalf("Verify: %+v", err)
+	}
+	if result.Broken() {
+		t.Fatalf("expected an intact chain across the rotation, got broken: %v", result.Reason)
+	}
+	if result.EntryCount != 1 {
+		t.Errorf("expected 1 verified entry, got: %v", result.EntryCount)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"context"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/golang/glog"
+)
+
+// AuditingEndpoint wraps a pipeline.Endpoint, appending one Entry to an AuditLog for every Send
+// attempt it makes, whether it ultimately succeeds, fails transiently, or fails permanently.
+// Everything other than Send and SendContext is delegated directly to the wrapped endpoint.
+type AuditingEndpoint struct {
+	endpoint pipeline.Endpoint
+	log      AuditLog
+}
+
+// NewAuditingEndpoint creates an AuditingEndpoint that records every Send attempt made through
+// endpoint to log.
+func NewAuditingEndpoint(endpoint pipeline.Endpoint, log AuditLog) *AuditingEndpoint {
+	return &AuditingEndpoint{endpoint: endpoint, log: log}
+}
+
+func (ep *AuditingEndpoint) Name() string {
+	return ep.endpoint.Name()
+}
+
+func (ep *AuditingEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return ep.endpoint.BuildReport(r)
+}
+
+func (ep *AuditingEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *AuditingEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	err := ep.endpoint.SendContext(ctx, r)
+
+	outcome := OutcomeSuccess
+	detail := ""
+	if err != nil {
+		if ep.endpoint.IsTransient(err) {
+			outcome = OutcomeTransient
+		} else {
+			outcome = OutcomePermanent
+		}
+		detail = err.Error()
+	}
+	if _, logerr := ep.log.Append(ep.endpoint.Name(), r.Id, outcome, detail); logerr != nil {
+		glog.Errorf("AuditingEndpoint: failed to append audit log entry: %+v", logerr)
+	}
+
+	return err
+}
+
+// Use is delegated directly to the wrapped endpoint. See pipeline.Component.Use.
+func (ep *AuditingEndpoint) Use() {
+	ep.endpoint.Use()
+}
+
+// Release is delegated directly to the wrapped endpoint. See pipeline.Component.Release.
+func (ep *AuditingEndpoint) Release() error {
+	return ep.endpoint.Release()
+}
+
+// IsTransient is delegated directly to the wrapped endpoint.
+func (ep *AuditingEndpoint) IsTransient(err error) bool {
+	return ep.endpoint.IsTransient(err)
+}
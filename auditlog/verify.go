@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auditlog
+
+import (
+	"crypto/hmac"
+	"fmt"
+)
+
+// VerifyResult describes the outcome of walking an audit log's chain with Verify.
+type VerifyResult struct {
+	// EntryCount is the number of entries successfully verified before either reaching the end of
+	// the chain or encountering a break.
+	EntryCount int64
+
+	// Head is the Hash of the last successfully verified entry.
+	Head string
+
+	// BrokenFile and BrokenSequence identify the first entry where the chain breaks. Both are zero
+	// values if Broken returns false.
+	BrokenFile     string
+	BrokenSequence int64
+
+	// Reason describes why the entry at BrokenFile/BrokenSequence failed verification. It's empty
+	// if the chain is intact.
+	Reason string
+}
+
+// Broken reports whether Verify found a break in the chain.
+func (r *VerifyResult) Broken() bool {
+	return r.Reason != ""
+}
+
+// Verify walks every log file under dir, in chain order, checking that each entry's Sequence
+// immediately follows the last, its PrevHash matches the previous entry's Hash, and its own Hash
+// is a valid HMAC of its contents under key - the same key the log was created with (see
+// NewLog). It stops at the first entry that fails one of these checks. A wrong key makes every
+// entry look broken, since none of their Hash fields will recompute correctly.
+func Verify(dir string, key []byte) (*VerifyResult, error) {
+	files, err := entryFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{Head: genesisHash}
+	prevHash := genesisHash
+	var prevSeq int64
+	for _, file := range files {
+		entries, err := readEntries(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if result.EntryCount > 0 && e.Sequence != prevSeq+1 {
+				result.BrokenFile = file
+				result.BrokenSequence = e.Sequence
+				result.Reason = fmt.Sprintf("expected sequence %v, found %v", prevSeq+1, e.Sequence)
+				return result, nil
+			}
+			if e.PrevHash != prevHash {
+				result.BrokenFile = file
+				result.BrokenSequence = e.Sequence
+				result.Reason = "prevHash does not match the preceding entry's hash"
+				return result, nil
+			}
+			if !hmac.Equal([]byte(e.hash(key)), []byte(e.Hash)) {
+				result.BrokenFile = file
+				result.BrokenSequence = e.Sequence
+				result.Reason = "hash does not match entry contents"
+				return result, nil
+			}
+			prevHash = e.Hash
+			prevSeq = e.Sequence
+			result.EntryCount++
+			result.Head = e.Hash
+		}
+	}
+	return result, nil
+}
@@ -30,6 +30,9 @@ type Clock interface {
 
 	// NewTimerAt creates a new Timer that fires at or after the given time.
 	NewTimerAt(at time.Time) Timer
+
+	// NewTicker creates a new Ticker that fires at or after every d interval, starting d after Now().
+	NewTicker(d time.Duration) Ticker
 }
 
 // Timer mimics a time.Timer, providing a channel that delivers a signal after a certain amount of
@@ -46,6 +49,21 @@ type Timer interface {
 	Stop() bool
 }
 
+// Ticker mimics a time.Ticker, delivering the current time on a channel at regular intervals. When
+// associated with a MockClock, a Ticker delivers a tick each time the MockClock's time is
+// programmatically advanced across an interval boundary, catching up to the next boundary after
+// the new time rather than accumulating missed ticks - matching the delivery semantics of a real
+// time.Ticker, whose single-slot buffered channel drops ticks that the receiver doesn't keep up
+// with.
+type Ticker interface {
+	// Chan returns this Ticker's signal channel. For real clocks, this simply returns a
+	// time.Ticker.C.
+	Chan() <-chan time.Time
+
+	// Stop stops the ticker. It does not close or drain the channel returned by Chan.
+	Stop()
+}
+
 // NewClock creates a new Clock instance that returns the current time.
 func NewClock() Clock {
 	return &realClock{}
@@ -83,6 +101,10 @@ func (rc *realClock) NewTimerAt(at time.Time) Timer {
 	return &realTimer{t: time.NewTimer(duration)}
 }
 
+func (rc *realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{t: time.NewTicker(d)}
+}
+
 type realTimer struct {
 	t *time.Timer
 }
@@ -94,3 +116,15 @@ func (t *realTimer) GetC() <-chan time.Time {
 func (t *realTimer) Stop() bool {
 	return t.t.Stop()
 }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t *realTicker) Chan() <-chan time.Time {
+	return t.t.C
+}
+
+func (t *realTicker) Stop() {
+	t.t.Stop()
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "errors"
+
+// AuditLog authenticates the agent's audit log chain with an HMAC key, so that an entry's
+// recorded hash can't be regenerated by someone who has only filesystem write access to the
+// audit log's own directory - they'd also need KeyFile's contents, which should live outside
+// that directory (e.g. a mounted Secret or a KMS-wrapped file). It's optional; a nil
+// Config.AuditLog leaves the chain tamper-evident only, not tamper-proof - see
+// auditlog.Entry.
+type AuditLog struct {
+	// KeyFile is the path to a file containing the HMAC key used to authenticate every audit log
+	// entry. The file's contents (trimmed of surrounding whitespace) are read once, at startup.
+	KeyFile string `json:"keyFile"`
+}
+
+func (a *AuditLog) Validate(c *Config) error {
+	if a.KeyFile == "" {
+		return errors.New("auditLog: keyFile must be set")
+	}
+	return nil
+}
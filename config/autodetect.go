@@ -0,0 +1,26 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// Autodetect configures startup-time environment detection, used to fill in configuration that
+// would otherwise have to be supplied by hand per-deployment.
+type Autodetect struct {
+	// GCE, when true, queries the GCE metadata server at startup to populate any
+	// ServiceControlEndpoint with an empty ConsumerId, default UserLabels (instance_id, zone, and -
+	// when running as a GKE node - cluster_name/cluster_location), and the agent identity fallback
+	// otherwise generated randomly. It has no effect, beyond a short delay, when the agent isn't
+	// running on GCE.
+	GCE bool `json:"gce"`
+}
@@ -0,0 +1,166 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// ChangeSet describes, by name, what changed between two Configs, as computed by Diff. A name
+// is "changed" when it exists in both old and new but its Hash differs; it's unchanged (and
+// omitted entirely) when its Hash is identical.
+type ChangeSet struct {
+	AddedIdentities   []string
+	RemovedIdentities []string
+	ChangedIdentities []string
+
+	AddedMetrics   []string
+	RemovedMetrics []string
+	ChangedMetrics []string
+
+	// MetricTypeChanges names metrics whose Type (int/double) differs between old and new. Such a
+	// change would corrupt an Aggregator's existing buffer for that metric, so callers should
+	// reject a reload whose ChangeSet has any.
+	MetricTypeChanges []string
+
+	AddedEndpoints   []string
+	RemovedEndpoints []string
+	ChangedEndpoints []string
+}
+
+// Empty returns true if the ChangeSet describes no differences at all.
+func (cs *ChangeSet) Empty() bool {
+	return len(cs.AddedIdentities) == 0 &&
+		len(cs.RemovedIdentities) == 0 &&
+		len(cs.ChangedIdentities) == 0 &&
+		len(cs.AddedMetrics) == 0 &&
+		len(cs.RemovedMetrics) == 0 &&
+		len(cs.ChangedMetrics) == 0 &&
+		len(cs.MetricTypeChanges) == 0 &&
+		len(cs.AddedEndpoints) == 0 &&
+		len(cs.RemovedEndpoints) == 0 &&
+		len(cs.ChangedEndpoints) == 0
+}
+
+// Diff compares old and new, returning a ChangeSet naming the identities, metrics, and endpoints
+// that were added, removed, or mutated. An error is returned only if hashing a configuration
+// subtree fails, which can't happen for any Config that has already passed Validate.
+func Diff(old, new *Config) (ChangeSet, error) {
+	var cs ChangeSet
+
+	addedI, removedI, changedI, err := diffIdentities(old.Identities, new.Identities)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	cs.AddedIdentities, cs.RemovedIdentities, cs.ChangedIdentities = addedI, removedI, changedI
+
+	addedM, removedM, changedM, typeChanges, err := diffMetrics(old.Metrics, new.Metrics)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	cs.AddedMetrics, cs.RemovedMetrics, cs.ChangedMetrics, cs.MetricTypeChanges = addedM, removedM, changedM, typeChanges
+
+	addedE, removedE, changedE, err := diffEndpoints(old.Endpoints, new.Endpoints)
+	if err != nil {
+		return ChangeSet{}, err
+	}
+	cs.AddedEndpoints, cs.RemovedEndpoints, cs.ChangedEndpoints = addedE, removedE, changedE
+
+	return cs, nil
+}
+
+func diffIdentities(old, new Identities) (added, removed, changed []string, err error) {
+	for _, n := range new {
+		o := old.Get(n.Name)
+		if o == nil {
+			added = append(added, n.Name)
+			continue
+		}
+		same, err := sameHash(o, &n)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !same {
+			changed = append(changed, n.Name)
+		}
+	}
+	for _, o := range old {
+		if new.Get(o.Name) == nil {
+			removed = append(removed, o.Name)
+		}
+	}
+	return added, removed, changed, nil
+}
+
+func diffMetrics(old, new Metrics) (added, removed, changed, typeChanges []string, err error) {
+	for _, n := range new {
+		o := old.Get(n.Name)
+		if o == nil {
+			added = append(added, n.Name)
+			continue
+		}
+		if o.Type != n.Type {
+			typeChanges = append(typeChanges, n.Name)
+		}
+		same, err := sameHash(o, &n)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if !same {
+			changed = append(changed, n.Name)
+		}
+	}
+	for _, o := range old {
+		if new.Get(o.Name) == nil {
+			removed = append(removed, o.Name)
+		}
+	}
+	return added, removed, changed, typeChanges, nil
+}
+
+func diffEndpoints(old, new Endpoints) (added, removed, changed []string, err error) {
+	for _, n := range new {
+		o := old.Get(n.Name)
+		if o == nil {
+			added = append(added, n.Name)
+			continue
+		}
+		same, err := sameHash(o, &n)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !same {
+			changed = append(changed, n.Name)
+		}
+	}
+	for _, o := range old {
+		if new.Get(o.Name) == nil {
+			removed = append(removed, o.Name)
+		}
+	}
+	return added, removed, changed, nil
+}
+
+type hasher interface {
+	Hash() (string, error)
+}
+
+func sameHash(old, new hasher) (bool, error) {
+	oh, err := old.Hash()
+	if err != nil {
+		return false, err
+	}
+	nh, err := new.Hash()
+	if err != nil {
+		return false, err
+	}
+	return oh == nh, nil
+}
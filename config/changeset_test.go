@@ -0,0 +1,133 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+func TestDiff_NoChanges(t *testing.T) {
+	cfg := &config.Config{
+		Identities: config.Identities{
+			{Name: "gcp", GCP: &config.GCPIdentity{ApplicationDefault: true}},
+		},
+		Metrics: config.Metrics{
+			{Definition: metrics.Definition{Name: "requests", Type: "int"}, Passthrough: &config.Passthrough{}},
+		},
+		Endpoints: config.Endpoints{
+			{Name: "disk", Disk: &config.DiskEndpoint{ReportDir: "/tmp/reports"}},
+		},
+	}
+
+	cs, err := config.Diff(cfg, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !cs.Empty() {
+		t.Fatalf("Expected an empty ChangeSet, got %+v", cs)
+	}
+}
+
+func TestDiff_AddedRemovedChanged(t *testing.T) {
+	old := &config.Config{
+		Identities: config.Identities{
+			{Name: "gcp", GCP: &config.GCPIdentity{ApplicationDefault: true}},
+			{Name: "aws", AWS: &config.AWSIdentity{AccessKeyId: "a", SecretAccessKey: "b", Region: "us-east-1"}},
+		},
+		Metrics: config.Metrics{
+			{Definition: metrics.Definition{Name: "requests", Type: "int"}, Passthrough: &config.Passthrough{}},
+			{Definition: metrics.Definition{Name: "errors", Type: "int"}, Passthrough: &config.Passthrough{}},
+		},
+		Endpoints: config.Endpoints{
+			{Name: "disk", Disk: &config.DiskEndpoint{ReportDir: "/tmp/reports"}},
+			{Name: "otlp", OTLP: &config.OTLPEndpoint{Endpoint: "collector:4317"}},
+		},
+	}
+	new := &config.Config{
+		Identities: config.Identities{
+			{Name: "gcp", GCP: &config.GCPIdentity{ApplicationDefault: true}},
+			{Name: "azure", Azure: &config.AzureIdentity{TenantId: "t", ClientId: "c", ClientSecret: "s"}},
+		},
+		Metrics: config.Metrics{
+			{Definition: metrics.Definition{Name: "requests", Type: "int"}, Passthrough: &config.Passthrough{}},
+			{Definition: metrics.Definition{Name: "errors", Type: "int", Kind: metrics.KindMax}, Passthrough: &config.Passthrough{}},
+		},
+		Endpoints: config.Endpoints{
+			{Name: "disk", Disk: &config.DiskEndpoint{ReportDir: "/tmp/reports"}},
+			{Name: "otlp", OTLP: &config.OTLPEndpoint{Endpoint: "collector2:4317"}},
+		},
+	}
+
+	cs, err := config.Diff(old, new)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if want, got := []string{"azure"}, cs.AddedIdentities; !reflect.DeepEqual(want, got) {
+		t.Errorf("AddedIdentities: want=%v, got=%v", want, got)
+	}
+	if want, got := []string{"aws"}, cs.RemovedIdentities; !reflect.DeepEqual(want, got) {
+		t.Errorf("RemovedIdentities: want=%v, got=%v", want, got)
+	}
+	if len(cs.ChangedIdentities) != 0 {
+		t.Errorf("ChangedIdentities: want=[], got=%v", cs.ChangedIdentities)
+	}
+
+	if want, got := []string{"errors"}, cs.ChangedMetrics; !reflect.DeepEqual(want, got) {
+		t.Errorf("ChangedMetrics: want=%v, got=%v", want, got)
+	}
+	if len(cs.AddedMetrics) != 0 || len(cs.RemovedMetrics) != 0 {
+		t.Errorf("expected no added/removed metrics, got added=%v removed=%v", cs.AddedMetrics, cs.RemovedMetrics)
+	}
+	if len(cs.MetricTypeChanges) != 0 {
+		t.Errorf("MetricTypeChanges: want=[], got=%v", cs.MetricTypeChanges)
+	}
+
+	if want, got := []string{"otlp"}, cs.ChangedEndpoints; !reflect.DeepEqual(want, got) {
+		t.Errorf("ChangedEndpoints: want=%v, got=%v", want, got)
+	}
+	if len(cs.AddedEndpoints) != 0 || len(cs.RemovedEndpoints) != 0 {
+		t.Errorf("expected no added/removed endpoints, got added=%v removed=%v", cs.AddedEndpoints, cs.RemovedEndpoints)
+	}
+
+	if cs.Empty() {
+		t.Fatal("expected a non-empty ChangeSet")
+	}
+}
+
+func TestDiff_MetricTypeChange(t *testing.T) {
+	old := &config.Config{
+		Metrics: config.Metrics{
+			{Definition: metrics.Definition{Name: "requests", Type: "int"}, Passthrough: &config.Passthrough{}},
+		},
+	}
+	new := &config.Config{
+		Metrics: config.Metrics{
+			{Definition: metrics.Definition{Name: "requests", Type: "double"}, Passthrough: &config.Passthrough{}},
+		},
+	}
+
+	cs, err := config.Diff(old, new)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want, got := []string{"requests"}, cs.MetricTypeChanges; !reflect.DeepEqual(want, got) {
+		t.Errorf("MetricTypeChanges: want=%v, got=%v", want, got)
+	}
+}
@@ -28,6 +28,26 @@ type Config struct {
 	Endpoints  Endpoints  `json:"endpoints"`
 	Sources    Sources    `json:"sources"`
 	Filters    Filters    `json:"filters"`
+	Routes     Routes     `json:"routes"`
+
+	// Server configures agent-internal HTTP endpoints, such as a Prometheus /metrics server. It's
+	// optional.
+	Server *Server `json:"server"`
+
+	// Persistence configures the backend used to durably store pipeline state. It's optional; see
+	// Persistence for the default when unset.
+	Persistence *Persistence `json:"persistence"`
+
+	// Autodetect configures startup-time environment detection. It's optional.
+	Autodetect *Autodetect `json:"autodetect"`
+
+	// Tracing configures an OpenTelemetry trace exporter for the pipeline. It's optional; tracing
+	// is disabled when unset.
+	Tracing *Tracing `json:"tracing"`
+
+	// AuditLog configures HMAC authentication of the audit log's hash chain. It's optional; see
+	// AuditLog.
+	AuditLog *AuditLog `json:"auditLog"`
 }
 
 // Validation
@@ -43,9 +63,37 @@ func Load(path string) (*Config, error) {
 	return Parse(data)
 }
 
+// LoadStrict behaves like Load, but parses with ParseStrict.
+func LoadStrict(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStrict(data)
+}
+
+// Parse unmarshals the given YAML config data into a Config. Before unmarshalling, any
+// ${ENV:VAR}, ${FILE:/path}, or ${GCP_SECRET:resource} reference in data is resolved and
+// substituted in place, letting secrets such as serviceAccountKey be kept out of the config file
+// itself. A reference to a missing environment variable, unreadable file, or inaccessible secret
+// is silently replaced with an empty string; use ParseStrict to treat that as an error instead.
 func Parse(data []byte) (*Config, error) {
+	return parse(data, false)
+}
+
+// ParseStrict behaves like Parse, but fails if any ${ENV:...}, ${FILE:...}, or ${GCP_SECRET:...}
+// reference cannot be resolved, rather than substituting an empty string.
+func ParseStrict(data []byte) (*Config, error) {
+	return parse(data, true)
+}
+
+func parse(data []byte, strict bool) (*Config, error) {
+	interpolated, err := interpolate(data, strict)
+	if err != nil {
+		return nil, err
+	}
 	c := &Config{}
-	if err := yaml.Unmarshal(data, c); err != nil {
+	if err := yaml.Unmarshal(interpolated, c); err != nil {
 		return nil, err
 	}
 	return c, nil
@@ -73,6 +121,29 @@ func (c *Config) Validate() error {
 	if err := c.Filters.Validate(c); err != nil {
 		return err
 	}
+	if err := c.Routes.Validate(c); err != nil {
+		return err
+	}
+	if c.Server != nil {
+		if err := c.Server.Validate(c); err != nil {
+			return err
+		}
+	}
+	if c.Persistence != nil {
+		if err := c.Persistence.Validate(c); err != nil {
+			return err
+		}
+	}
+	if c.Tracing != nil {
+		if err := c.Tracing.Validate(c); err != nil {
+			return err
+		}
+	}
+	if c.AuditLog != nil {
+		if err := c.AuditLog.Validate(c); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
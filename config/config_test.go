@@ -374,6 +374,27 @@ func TestConfig_Validate(t *testing.T) {
 		}
 	})
 
+	t.Run("multiple prometheus endpoints with the same port", func(t *testing.T) {
+		c := &config.Config{
+			Identities: goodIdentities,
+			Metrics: goodMetrics,
+			Endpoints: []config.Endpoint{
+				{
+					Name:       "foo",
+					Prometheus: &config.PrometheusEndpoint{Port: 9090},
+				},
+				{
+					Name:       "bar",
+					Prometheus: &config.PrometheusEndpoint{Port: 9090},
+				},
+			},
+		}
+
+		if want, got := "endpoint bar: prometheus port 9090 already used by endpoint foo", c.Validate(); got == nil || want != got.Error() {
+			t.Fatalf("wanted: %+v, got: %+v", want, got)
+		}
+	})
+
 	t.Run("missing identity name", func(t *testing.T) {
 		c := &config.Config{
 			Identities: goodIdentities,
@@ -15,10 +15,15 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
 	"strings"
+	"text/template"
 )
 
 // Type Endpoints is a Validatable collection of Endpoint objects.
@@ -26,6 +31,7 @@ type Endpoints []Endpoint
 
 func (endpoints Endpoints) Validate(c *Config) error {
 	usedNames := make(map[string]bool)
+	usedPrometheusPorts := make(map[int]string)
 	for _, e := range endpoints {
 		if usedNames[e.Name] {
 			return fmt.Errorf("endpoint %v: multiple endpoints with the same name", e.Name)
@@ -33,6 +39,12 @@ func (endpoints Endpoints) Validate(c *Config) error {
 		if err := e.Validate(c); err != nil {
 			return err
 		}
+		if e.Prometheus != nil {
+			if other, ok := usedPrometheusPorts[e.Prometheus.Port]; ok {
+				return fmt.Errorf("endpoint %v: prometheus port %v already used by endpoint %v", e.Name, e.Prometheus.Port, other)
+			}
+			usedPrometheusPorts[e.Prometheus.Port] = e.Name
+		}
 		usedNames[e.Name] = true
 	}
 	return nil
@@ -48,12 +60,45 @@ func (endpoints Endpoints) exists(name string) bool {
 	return false
 }
 
+// Get returns the Endpoint with the given name, or nil if it does not exist.
+func (endpoints Endpoints) Get(name string) *Endpoint {
+	for i := range endpoints {
+		if endpoints[i].Name == name {
+			return &endpoints[i]
+		}
+	}
+	return nil
+}
+
 // Endpoint describes a single remote endpoint used for sending aggregated metrics.
 type Endpoint struct {
-	Name           string                  `json:"name"`
-	Disk           *DiskEndpoint           `json:"disk"`
-	ServiceControl *ServiceControlEndpoint `json:"servicecontrol"`
-	PubSub         *PubSubEndpoint         `json:"pubsub"`
+	Name             string                    `json:"name"`
+	Disk             *DiskEndpoint             `json:"disk"`
+	ServiceControl   *ServiceControlEndpoint   `json:"servicecontrol"`
+	PubSub           *PubSubEndpoint           `json:"pubsub"`
+	AzureMarketplace *AzureMarketplaceEndpoint `json:"azureMarketplace"`
+	OTLP             *OTLPEndpoint             `json:"otlp"`
+	CloudEvents      *CloudEventsEndpoint      `json:"cloudEvents"`
+	PromRemoteWrite  *PromRemoteWriteEndpoint  `json:"promRemoteWrite"`
+	Prometheus       *PrometheusEndpoint       `json:"prometheus"`
+	Stackdriver      *StackdriverEndpoint      `json:"stackdriver"`
+	CloudWatch       *CloudWatchEndpoint       `json:"cloudWatch"`
+	AzureMonitor     *AzureMonitorEndpoint     `json:"azureMonitor"`
+	StructuredLog    *StructuredLogEndpoint    `json:"structuredLog"`
+	Custom           *CustomEndpoint           `json:"custom"`
+
+	// Retry configures the backoff and retry-queue behavior used when sending to this endpoint.
+	// It's optional; a nil value causes the endpoint's RetryingSender to use its built-in defaults.
+	Retry *RetryPolicy `json:"retry"`
+
+	// DeadLetter configures where this endpoint's RetryingSender hands off reports it gives up on.
+	// It's optional; a nil value means such reports are only recorded via stats.Recorder.SendFailed,
+	// as before.
+	DeadLetter *DeadLetterPolicy `json:"deadLetter"`
+
+	// Filters names filters, defined in Config.Filters, to apply, in order, to every report sent to
+	// this endpoint, independent of any filters applied at the Metric level.
+	Filters []string `json:"filters"`
 }
 
 func (e *Endpoint) Validate(c *Config) error {
@@ -63,7 +108,7 @@ func (e *Endpoint) Validate(c *Config) error {
 	// TODO(volkman): determine other Name requirements (no '/'?)
 
 	types := 0
-	for _, v := range []Validatable{e.Disk, e.PubSub, e.ServiceControl} {
+	for _, v := range []Validatable{e.Disk, e.PubSub, e.ServiceControl, e.AzureMarketplace, e.OTLP, e.CloudEvents, e.Prometheus, e.Stackdriver, e.CloudWatch, e.AzureMonitor, e.StructuredLog, e.Custom} {
 		if reflect.ValueOf(v).IsNil() {
 			continue
 		}
@@ -81,12 +126,350 @@ func (e *Endpoint) Validate(c *Config) error {
 		return errors.New(fmt.Sprintf("endpoint %v: multiple type configurations", e.Name))
 	}
 
+	if e.Retry != nil {
+		if err := e.Retry.Validate(c); err != nil {
+			return fmt.Errorf("endpoint %v: %v", e.Name, err)
+		}
+	}
+
+	if e.DeadLetter != nil {
+		if err := e.DeadLetter.Validate(c); err != nil {
+			return fmt.Errorf("endpoint %v: %v", e.Name, err)
+		}
+	}
+
+	if err := validateFilterNames(c, e.Filters); err != nil {
+		return fmt.Errorf("endpoint %v: %v", e.Name, err)
+	}
+
+	return nil
+}
+
+// Hash returns a stable content hash of e's configuration subtree, excluding Name. A config reload
+// that finds an endpoint with the same name and the same Hash can safely reuse that endpoint's
+// already-constructed pipeline.Sender rather than recreating it, preserving its in-flight buffers
+// and disk-persisted queue.
+func (e *Endpoint) Hash() (string, error) {
+	unnamed := *e
+	unnamed.Name = ""
+	data, err := json.Marshal(unnamed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// HashWithoutRetry returns the same hash as Hash, but with Retry also excluded. A config reload
+// that finds an endpoint whose HashWithoutRetry is unchanged, even though its Hash differs, knows
+// the only thing that changed is the endpoint's RetryPolicy - so it can try applying that change to
+// the existing pipeline.Sender in place (see pipeline.Reconfigurable) instead of rebuilding the
+// endpoint from scratch.
+func (e *Endpoint) HashWithoutRetry() (string, error) {
+	unnamed := *e
+	unnamed.Name = ""
+	unnamed.Retry = nil
+	data, err := json.Marshal(unnamed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RetryPolicy configures the exponential backoff, circuit breaker, retry budget, and retry-queue
+// limits that a RetryingSender applies when an endpoint's Send repeatedly fails. Any field left at
+// zero falls back to the RetryingSender's built-in default.
+type RetryPolicy struct {
+	// MinDelaySeconds is the initial (and minimum) delay between retry attempts.
+	MinDelaySeconds int64 `json:"minDelaySeconds"`
+
+	// MaxDelaySeconds caps the exponential backoff delay between retry attempts.
+	MaxDelaySeconds int64 `json:"maxDelaySeconds"`
+
+	// MaxQueueSeconds is the maximum amount of time an entry may remain in the retry queue before
+	// it's dropped and recorded as a failure.
+	MaxQueueSeconds int64 `json:"maxQueueSeconds"`
+
+	// MaxAttempts, if non-zero, caps the number of consecutive send attempts made for the entry at
+	// the head of the retry queue before it's dropped and recorded as a failure, regardless of
+	// MaxQueueSeconds. Zero (the default) leaves attempts uncapped; only MaxQueueSeconds and the
+	// retry budget bound how long an entry is retried.
+	MaxAttempts int `json:"maxAttempts"`
+
+	// CircuitBreakerThreshold is the number of consecutive transient send failures after which a
+	// RetryingSender opens its circuit breaker for this endpoint, short-circuiting further attempts
+	// without touching the endpoint until CircuitBreakerCooldownSeconds elapses.
+	CircuitBreakerThreshold int `json:"circuitBreakerThreshold"`
+
+	// CircuitBreakerCooldownSeconds is how long the circuit breaker stays open before allowing a
+	// half-open probe attempt. A failed probe reopens the breaker immediately; a successful one
+	// either closes it or, if CircuitBreakerProbeCount allows more than one, permits another probe
+	// right away, without waiting out another cooldown.
+	CircuitBreakerCooldownSeconds int64 `json:"circuitBreakerCooldownSeconds"`
+
+	// CircuitBreakerProbeCount is the number of consecutive successful half-open probes required to
+	// close the circuit breaker again. Zero (the default) requires just one.
+	CircuitBreakerProbeCount int `json:"circuitBreakerProbeCount"`
+
+	// RetryBudgetRatio and RetryBudgetConstant configure a token-bucket retry budget: the sender
+	// starts with RetryBudgetConstant tokens and credits RetryBudgetRatio tokens per successful send,
+	// spending one token per failed send attempt that would otherwise be retried. Once the budget is
+	// exhausted, such a failure is instead failed immediately, so a single pathological endpoint
+	// can't retry forever and monopolize the persistent retry queue.
+	RetryBudgetRatio    float64 `json:"retryBudgetRatio"`
+	RetryBudgetConstant float64 `json:"retryBudgetConstant"`
+
+	// BatchMaxReports, if greater than 1, enables batched sending for endpoints that implement
+	// pipeline.BatchEndpoint: up to this many queued reports are dequeued and sent together in a
+	// single SendBatch call, instead of one Send call per report. It has no effect on endpoints that
+	// don't implement BatchEndpoint. Zero or one (the default) keeps the traditional
+	// one-report-per-Send behavior.
+	BatchMaxReports int `json:"batchMaxReports"`
+
+	// BatchMaxBytes, if non-zero, caps the total serialized size of a single batch formed under
+	// BatchMaxReports: reports are dequeued up to this limit, always including at least one even if
+	// it alone exceeds the cap. Zero disables the byte cap.
+	BatchMaxBytes int64 `json:"batchMaxBytes"`
+
+	// MaxInFlight configures how many batches may be sent concurrently. Only the default of 1 is
+	// currently supported; RetryingSender sends strictly one batch (or report) at a time so the
+	// persisted queue's FIFO ordering and durability guarantees hold.
+	MaxInFlight int `json:"maxInFlight"`
+
+	// MaxQueueItems and MaxQueueBytes bound the size of the persistent retry queue, in entry count
+	// and total serialized bytes respectively. Zero (the default) leaves the queue unbounded, as
+	// before: it grows until MaxQueueSeconds expires entries one-by-one during send attempts.
+	MaxQueueItems int   `json:"maxQueueItems"`
+	MaxQueueBytes int64 `json:"maxQueueBytes"`
+
+	// QueueFullPolicy selects how Send behaves once MaxQueueItems or MaxQueueBytes is reached: one
+	// of QueueFullBlock (the default) or QueueFullDropOldest. It has no effect unless one of those
+	// limits is set.
+	QueueFullPolicy string `json:"queueFullPolicy"`
+
+	// EnqueueTimeoutSeconds bounds how long Send blocks under QueueFullBlock before giving up and
+	// returning an error. Zero (the default) fails immediately rather than blocking at all.
+	EnqueueTimeoutSeconds int64 `json:"enqueueTimeoutSeconds"`
+
+	// SendTimeoutSeconds bounds how long a single Endpoint.SendContext call is allowed to run
+	// before its context is canceled and the attempt is treated as a failure. It has no effect on
+	// SendBatch, which doesn't take a context. Zero (the default) leaves an attempt to run
+	// indefinitely, other than being canceled immediately if the sender is released mid-send.
+	SendTimeoutSeconds int64 `json:"sendTimeoutSeconds"`
+
+	// BackoffPolicy selects how a RetryingSender randomizes the delay between retry attempts: one of
+	// BackoffFullJitter (the default), BackoffNone, BackoffEqualJitter, or
+	// BackoffDecorrelatedJitter. Randomizing retry delays, rather than using a strictly deterministic
+	// exponential backoff, spreads retries across the backoff window so that many agents hitting the
+	// same transient endpoint outage don't all retry in lockstep.
+	BackoffPolicy string `json:"backoffPolicy"`
+
+	// MaxShards, if greater than 1, builds this endpoint's sender as a senders.ShardedSender
+	// instead of a single RetryingSender: reports are routed across up to MaxShards persisted
+	// sub-queues, each with its own retry worker, so a slow endpoint's latency no longer serializes
+	// every report behind a single in-flight send. Zero or one (the default) keeps the traditional
+	// single-queue RetryingSender behavior.
+	MaxShards int `json:"maxShards"`
+
+	// MinShards bounds how far a ShardedSender's autoscaling is allowed to shrink the number of
+	// shards actively sending at once. It has no effect unless MaxShards is also greater than 1.
+	// Zero (the default) floors it at 1.
+	MinShards int `json:"minShards"`
+
+	// MaxSamplesPerSend, if set, overrides BatchMaxReports for the RetryingSenders backing a
+	// ShardedSender's individual shards. It has no effect unless MaxShards is also greater than 1;
+	// a non-sharded endpoint is configured via BatchMaxReports directly.
+	MaxSamplesPerSend int `json:"maxSamplesPerSend"`
+}
+
+const (
+	// QueueFullBlock makes Send block (subject to EnqueueTimeoutSeconds) until the retry queue has
+	// room, once MaxQueueItems or MaxQueueBytes is reached. This is the default QueueFullPolicy.
+	QueueFullBlock = "block"
+
+	// QueueFullDropOldest makes Send succeed immediately once MaxQueueItems or MaxQueueBytes is
+	// reached, by first evicting the oldest queued entry (recording it as a failed send) to make
+	// room for the new one.
+	QueueFullDropOldest = "dropOldest"
+)
+
+const (
+	// BackoffFullJitter picks each retry delay uniformly at random between the sender's minimum
+	// delay and the doubling, capped backoff ceiling. This is the default BackoffPolicy.
+	BackoffFullJitter = "fullJitter"
+
+	// BackoffNone disables jitter entirely: each retry delay is exactly the doubling, capped backoff
+	// ceiling, with no randomization.
+	BackoffNone = "none"
+
+	// BackoffEqualJitter picks each retry delay uniformly at random between half the backoff ceiling
+	// and the full ceiling, trading some of full jitter's spread for a delay that never drops below
+	// half the ceiling.
+	BackoffEqualJitter = "equalJitter"
+
+	// BackoffDecorrelatedJitter picks each retry delay uniformly at random between the sender's
+	// minimum delay and three times the previous delay, capped at the sender's maximum delay. Unlike
+	// the other policies, it doesn't reference the doubling ceiling at all, so consecutive delays
+	// stay decorrelated from one another rather than climbing a fixed schedule.
+	BackoffDecorrelatedJitter = "decorrelatedJitter"
+
+	// BackoffConstant retries at a fixed delay, equal to the sender's minimum delay, with no growth
+	// and no jitter.
+	BackoffConstant = "constant"
+
+	// BackoffLinear grows the retry delay by the sender's minimum delay on every attempt, capped at
+	// its maximum delay, rather than doubling it.
+	BackoffLinear = "linear"
+)
+
+func (r *RetryPolicy) Validate(c *Config) error {
+	if r.MinDelaySeconds < 0 {
+		return errors.New("retry: minDelaySeconds must not be negative")
+	}
+	if r.MaxDelaySeconds < 0 {
+		return errors.New("retry: maxDelaySeconds must not be negative")
+	}
+	if r.MinDelaySeconds > 0 && r.MaxDelaySeconds > 0 && r.MinDelaySeconds > r.MaxDelaySeconds {
+		return errors.New("retry: minDelaySeconds must not exceed maxDelaySeconds")
+	}
+	if r.MaxQueueSeconds < 0 {
+		return errors.New("retry: maxQueueSeconds must not be negative")
+	}
+	if r.MaxAttempts < 0 {
+		return errors.New("retry: maxAttempts must not be negative")
+	}
+	if r.CircuitBreakerThreshold < 0 {
+		return errors.New("retry: circuitBreakerThreshold must not be negative")
+	}
+	if r.CircuitBreakerCooldownSeconds < 0 {
+		return errors.New("retry: circuitBreakerCooldownSeconds must not be negative")
+	}
+	if r.CircuitBreakerProbeCount < 0 {
+		return errors.New("retry: circuitBreakerProbeCount must not be negative")
+	}
+	if r.RetryBudgetRatio < 0 {
+		return errors.New("retry: retryBudgetRatio must not be negative")
+	}
+	if r.RetryBudgetConstant < 0 {
+		return errors.New("retry: retryBudgetConstant must not be negative")
+	}
+	if r.BatchMaxReports < 0 {
+		return errors.New("retry: batchMaxReports must not be negative")
+	}
+	if r.BatchMaxBytes < 0 {
+		return errors.New("retry: batchMaxBytes must not be negative")
+	}
+	if r.MaxInFlight < 0 {
+		return errors.New("retry: maxInFlight must not be negative")
+	}
+	if r.MaxInFlight > 1 {
+		return errors.New("retry: maxInFlight greater than 1 is not yet supported")
+	}
+	if r.MaxQueueItems < 0 {
+		return errors.New("retry: maxQueueItems must not be negative")
+	}
+	if r.MaxQueueBytes < 0 {
+		return errors.New("retry: maxQueueBytes must not be negative")
+	}
+	if r.EnqueueTimeoutSeconds < 0 {
+		return errors.New("retry: enqueueTimeoutSeconds must not be negative")
+	}
+	if r.SendTimeoutSeconds < 0 {
+		return errors.New("retry: sendTimeoutSeconds must not be negative")
+	}
+	switch r.QueueFullPolicy {
+	case "", QueueFullBlock, QueueFullDropOldest:
+	default:
+		return fmt.Errorf("retry: queueFullPolicy must be %q or %q", QueueFullBlock, QueueFullDropOldest)
+	}
+	switch r.BackoffPolicy {
+	case "", BackoffFullJitter, BackoffNone, BackoffEqualJitter, BackoffDecorrelatedJitter, BackoffConstant, BackoffLinear:
+	default:
+		return fmt.Errorf("retry: backoffPolicy must be one of %q, %q, %q, %q, %q, or %q", BackoffFullJitter, BackoffNone, BackoffEqualJitter, BackoffDecorrelatedJitter, BackoffConstant, BackoffLinear)
+	}
+	if r.MaxShards < 0 {
+		return errors.New("retry: maxShards must not be negative")
+	}
+	if r.MinShards < 0 {
+		return errors.New("retry: minShards must not be negative")
+	}
+	if r.MinShards > 0 && r.MaxShards > 0 && r.MinShards > r.MaxShards {
+		return errors.New("retry: minShards must not exceed maxShards")
+	}
+	if r.MaxSamplesPerSend < 0 {
+		return errors.New("retry: maxSamplesPerSend must not be negative")
+	}
+	return nil
+}
+
+// DeadLetterPolicy configures where a RetryingSender hands off reports it gives up on - either
+// because they expired past MaxQueueSeconds or because the endpoint's Retryer classified the
+// failure as non-retryable - so operators can recover them for offline reprocessing instead of
+// losing them silently. Exactly one of Disk or HTTP must be set.
+type DeadLetterPolicy struct {
+	Disk *DeadLetterDiskSink `json:"disk"`
+	HTTP *DeadLetterHTTPSink `json:"http"`
+}
+
+func (d *DeadLetterPolicy) Validate(c *Config) error {
+	types := 0
+	for _, v := range []Validatable{d.Disk, d.HTTP} {
+		if reflect.ValueOf(v).IsNil() {
+			continue
+		}
+		if err := v.Validate(c); err != nil {
+			return err
+		}
+		types++
+	}
+	if types == 0 {
+		return errors.New("deadLetter: missing sink configuration")
+	}
+	if types > 1 {
+		return errors.New("deadLetter: multiple sink configurations")
+	}
+	return nil
+}
+
+// DeadLetterDiskSink appends dead-lettered reports as JSON Lines to a file under Dir.
+type DeadLetterDiskSink struct {
+	Dir string `json:"dir"`
+}
+
+func (d *DeadLetterDiskSink) Validate(c *Config) error {
+	if d.Dir == "" {
+		return errors.New("deadLetter.disk: missing directory")
+	}
+	return nil
+}
+
+// DeadLetterHTTPSink POSTs each dead-lettered report as JSON to Endpoint.
+type DeadLetterHTTPSink struct {
+	Endpoint string            `json:"endpoint"`
+	Headers  map[string]string `json:"headers"`
+}
+
+func (d *DeadLetterHTTPSink) Validate(c *Config) error {
+	if d.Endpoint == "" {
+		return errors.New("deadLetter.http: missing endpoint")
+	}
 	return nil
 }
 
 type DiskEndpoint struct {
 	ReportDir     string `json:"reportDir"`
 	ExpireSeconds int64  `json:"expireSeconds"`
+
+	// Format selects how reports are written to ReportDir: "json" (the default) writes one file per
+	// report, as before. "ndjson" appends each report as a line to a rotating NDJSON log, making the
+	// directory viable as a durable audit sink that a log shipper can tail without enumerating
+	// thousands of tiny files.
+	Format string `json:"format"`
+
+	// Rotation configures segment rotation for Format "ndjson". It's an error to set Rotation when
+	// Format isn't "ndjson".
+	Rotation *DiskRotation `json:"rotation"`
 }
 
 func (e *DiskEndpoint) Validate(c *Config) error {
@@ -96,13 +479,123 @@ func (e *DiskEndpoint) Validate(c *Config) error {
 	if e.ReportDir == "" {
 		return errors.New("disk: missing report directory")
 	}
+	switch e.Format {
+	case "", "json", "ndjson":
+	default:
+		return fmt.Errorf("disk: unsupported format: %v", e.Format)
+	}
+	if e.Rotation != nil {
+		if e.Format != "ndjson" {
+			return errors.New("disk: rotation requires format: ndjson")
+		}
+		if err := e.Rotation.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiskRotation configures size- and time-based rotation of a DiskEndpoint's NDJSON segments.
+type DiskRotation struct {
+	// MaxBytes rotates the active segment once it reaches this size. Zero disables size-based
+	// rotation.
+	MaxBytes int64 `json:"maxBytes"`
+
+	// MaxAgeSeconds rotates the active segment once it's been open this long. Zero disables
+	// age-based rotation.
+	MaxAgeSeconds int64 `json:"maxAgeSeconds"`
+
+	// MaxFiles caps the number of rotated segments retained in ReportDir; the oldest are removed as
+	// new segments are rotated in. Zero disables this limit (segments are only removed by the usual
+	// expiration cleanup).
+	MaxFiles int `json:"maxFiles"`
+
+	// Compress gzips each segment as it's rotated out of the active position.
+	Compress bool `json:"compress"`
+
+	// Destination, if set, is an object-store URL each segment is uploaded to as it's rotated out
+	// of the active position, under a key derived from the segment's rotation timestamp. A segment
+	// is only removed from ReportDir once its upload succeeds, giving it a durable home off disk
+	// even if MaxFiles or the endpoint's expiration would otherwise have deleted it first. One of:
+	//   - "gs://bucket/prefix" (Cloud Storage; Identity must name a GCP identity)
+	//   - "s3://bucket/prefix" (S3; Identity must name an AWS identity)
+	//   - an "http://" or "https://" URL accepting PUT (an arbitrary object store; Identity unused)
+	// Empty disables upload.
+	Destination string `json:"destination"`
+
+	// Identity names the identity (see Config.Identities) used to authenticate to Destination.
+	// Required for a gs:// or s3:// Destination; ignored otherwise.
+	Identity string `json:"identity"`
+}
+
+func (r *DiskRotation) Validate(c *Config) error {
+	if r.MaxBytes < 0 {
+		return errors.New("disk: rotation.maxBytes must not be negative")
+	}
+	if r.MaxAgeSeconds < 0 {
+		return errors.New("disk: rotation.maxAgeSeconds must not be negative")
+	}
+	if r.MaxFiles < 0 {
+		return errors.New("disk: rotation.maxFiles must not be negative")
+	}
+	if r.Destination != "" {
+		u, err := url.Parse(r.Destination)
+		if err != nil {
+			return fmt.Errorf("disk: rotation.destination: %v", err)
+		}
+		switch u.Scheme {
+		case "gs":
+			if u.Host == "" {
+				return errors.New("disk: rotation.destination: missing bucket")
+			}
+			if err := validateGcpKey(c.Identities, "disk", r.Identity); err != nil {
+				return err
+			}
+		case "s3":
+			if u.Host == "" {
+				return errors.New("disk: rotation.destination: missing bucket")
+			}
+			if err := validateAwsKey(c.Identities, "disk", r.Identity); err != nil {
+				return err
+			}
+		case "http", "https":
+		default:
+			return fmt.Errorf("disk: rotation.destination: unsupported scheme: %v", u.Scheme)
+		}
+	}
 	return nil
 }
 
 type ServiceControlEndpoint struct {
 	Identity    string `json:"identity"`
 	ServiceName string `json:"serviceName"`
-	ConsumerId  string `json:"consumerId"`
+
+	// ConsumerId may be left empty if Config.Autodetect.GCE is enabled, in which case it's filled
+	// in at startup from the GCE metadata server's project ID. Otherwise it's required.
+	ConsumerId string `json:"consumerId"`
+
+	// UserLabels, if set, are merged into every Operation's labels alongside the per-report labels
+	// and agent ID label ServiceControlEndpoint always attaches; a per-report label with the same
+	// key takes precedence. Config.Autodetect.GCE fills in instance_id/zone/cluster_name/
+	// cluster_location entries here that aren't already present.
+	UserLabels map[string]string `json:"userLabels"`
+
+	// MaxQPS caps the rate at which this endpoint sends Report requests to the Service Control API.
+	// It's optional; a zero value disables client-side rate limiting.
+	MaxQPS float64 `json:"maxQPS"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// Report call should be retried immediately and how long to wait before doing so. It's optional;
+	// a nil value causes the endpoint to use its built-in defaults. This is independent of, and sits
+	// in front of, the outer Retry policy used by a RetryingSender's persisted retry queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+
+	// Transport selects how reports are sent to the Service Control API: "http" (the default) uses
+	// the REST/JSON client, and "grpc" dials the v1 gRPC API directly, reusing the same connection
+	// across calls instead of opening one per request. Both speak the same Report RPC, so this is
+	// purely a transport-level choice; it doesn't change what's reported or how failures are
+	// retried.
+	Transport string `json:"transport"`
 }
 
 func (e *ServiceControlEndpoint) Validate(c *Config) error {
@@ -112,24 +605,92 @@ func (e *ServiceControlEndpoint) Validate(c *Config) error {
 	if e.ServiceName == "" {
 		return errors.New("servicecontrol: missing service name")
 	}
-	if e.ConsumerId == "" {
+	autodetecting := c.Autodetect != nil && c.Autodetect.GCE
+	if e.ConsumerId == "" && !autodetecting {
 		return errors.New("servicecontrol: missing consumer ID")
 	}
-	if !(strings.HasPrefix(e.ConsumerId, "project:") ||
+	if e.ConsumerId != "" && !(strings.HasPrefix(e.ConsumerId, "project:") ||
 		strings.HasPrefix(e.ConsumerId, "project_number:") ||
 		strings.HasPrefix(e.ConsumerId, "apiKey:")) {
 		return errors.New(`servicecontrol: invalid consumer ID (must start with "project:", "projectNumber:", or "apiKey:")`)
 	}
+	if e.MaxQPS < 0 {
+		return errors.New("servicecontrol: maxQPS must not be negative")
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	switch e.Transport {
+	case "", "http", "grpc":
+	default:
+		return fmt.Errorf("servicecontrol: unsupported transport: %v", e.Transport)
+	}
+	return nil
+}
+
+// BackoffPolicy configures a pluggable per-call retry policy, independent of the endpoint type.
+// Any field left at zero falls back to the policy's built-in default.
+type BackoffPolicy struct {
+	// BaseMillis is the minimum (and initial) backoff delay.
+	BaseMillis int64 `json:"baseMillis"`
+
+	// CapMillis caps the backoff delay.
+	CapMillis int64 `json:"capMillis"`
+
+	// MaxAttempts is the maximum number of attempts, including the first, before giving up. Zero
+	// means unlimited.
+	MaxAttempts int `json:"maxAttempts"`
+}
+
+func (b *BackoffPolicy) Validate(c *Config) error {
+	if b.BaseMillis < 0 {
+		return errors.New("backoff: baseMillis must not be negative")
+	}
+	if b.CapMillis < 0 {
+		return errors.New("backoff: capMillis must not be negative")
+	}
+	if b.BaseMillis > 0 && b.CapMillis > 0 && b.BaseMillis > b.CapMillis {
+		return errors.New("backoff: baseMillis must not exceed capMillis")
+	}
+	if b.MaxAttempts < 0 {
+		return errors.New("backoff: maxAttempts must not be negative")
+	}
 	return nil
 }
 
 type PubSubEndpoint struct {
 	Identity string `json:"identity"`
-	Topic    string `json:"topic"`
+
+	// ProjectId is the GCP project the topic lives in.
+	ProjectId string `json:"projectId"`
+
+	// Topic is the topic ID to publish to (not the fully-qualified "projects/.../topics/..." name).
+	Topic string `json:"topic"`
+
+	// OrderingKeyTemplate is a text/template expanded, for each report, over its
+	// metrics.StampedMetricReport (so "{{.Labels.tenant}}" is a valid reference) to produce the
+	// message's Pub/Sub ordering key. It's optional; an empty value (the default) orders messages
+	// by report ID, so retries of the same report always publish with the same key.
+	OrderingKeyTemplate string `json:"orderingKeyTemplate"`
 }
 
 func (e *PubSubEndpoint) Validate(c *Config) error {
-	// TODO(volkman): implement
+	if err := validateGcpKey(c.Identities, "pubsub", e.Identity); err != nil {
+		return err
+	}
+	if e.ProjectId == "" {
+		return errors.New("pubsub: missing projectId")
+	}
+	if e.Topic == "" {
+		return errors.New("pubsub: missing topic")
+	}
+	if e.OrderingKeyTemplate != "" {
+		if _, err := template.New("pubsub-ordering-key").Parse(e.OrderingKeyTemplate); err != nil {
+			return fmt.Errorf("pubsub: invalid orderingKeyTemplate: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -141,8 +702,637 @@ func validateGcpKey(identities Identities, endpointType, identity string) error
 	if i == nil {
 		return fmt.Errorf("%v: nonexistent identity: %v", endpointType, identity)
 	}
-	if i.GCP == nil {
+	if i.GCP == nil && i.Impersonate == nil {
 		return fmt.Errorf("%v: %v is not a GCP identity", endpointType, identity)
 	}
 	return nil
 }
+
+// AzureMarketplaceEndpoint reports usage events for a Azure Marketplace SaaS/managed-app plan to
+// the Azure Marketplace Metering Service.
+type AzureMarketplaceEndpoint struct {
+	Identity string `json:"identity"`
+
+	// PlanId identifies the marketplace plan being metered. It's attached to every usage event
+	// alongside the resourceId, quantity, dimension, and effectiveStartTime carried by the report.
+	PlanId string `json:"planId"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// usage event call should be retried immediately and how long to wait before doing so. It's
+	// optional; a nil value causes the endpoint to use its built-in defaults. This is independent
+	// of, and sits in front of, the outer Retry policy used by a RetryingSender's persisted retry
+	// queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+}
+
+func (e *AzureMarketplaceEndpoint) Validate(c *Config) error {
+	if err := validateAzureKey(c.Identities, "azureMarketplace", e.Identity); err != nil {
+		return err
+	}
+	if e.PlanId == "" {
+		return errors.New("azureMarketplace: missing plan ID")
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateAzureKey(identities Identities, endpointType, identity string) error {
+	if identity == "" {
+		return fmt.Errorf("%v: missing identity name", endpointType)
+	}
+	i := identities.Get(identity)
+	if i == nil {
+		return fmt.Errorf("%v: nonexistent identity: %v", endpointType, identity)
+	}
+	if i.Azure == nil {
+		return fmt.Errorf("%v: %v is not an Azure identity", endpointType, identity)
+	}
+	return nil
+}
+
+func validateAwsKey(identities Identities, endpointType, identity string) error {
+	if identity == "" {
+		return fmt.Errorf("%v: missing identity name", endpointType)
+	}
+	i := identities.Get(identity)
+	if i == nil {
+		return fmt.Errorf("%v: nonexistent identity: %v", endpointType, identity)
+	}
+	if i.AWS == nil {
+		return fmt.Errorf("%v: %v is not an AWS identity", endpointType, identity)
+	}
+	return nil
+}
+
+// OTLPEndpoint ships aggregated metrics to an OpenTelemetry collector (or any backend that speaks
+// OTLP, such as Honeycomb or Grafana Cloud) over gRPC or HTTP/protobuf.
+type OTLPEndpoint struct {
+	// Endpoint is the collector address: "host:port" for Protocol "grpc", or a full URL for
+	// Protocol "http".
+	Endpoint string `json:"endpoint"`
+
+	// Protocol selects the OTLP transport: "grpc" (the default when empty) or "http".
+	Protocol string `json:"protocol"`
+
+	// Insecure disables TLS. Defaults to false (TLS is used).
+	Insecure bool `json:"insecure"`
+
+	// ServerName overrides the TLS server name used to verify the collector's certificate, when
+	// Endpoint's host isn't appropriate (e.g. behind a load balancer or SNI proxy).
+	ServerName string `json:"serverName"`
+
+	// Headers are attached to every export request - for example, the API key header expected by
+	// Honeycomb or Grafana Cloud's OTLP ingest.
+	Headers map[string]string `json:"headers"`
+
+	// Compression selects the wire compression used for export requests: "gzip" or "" (none).
+	Compression string `json:"compression"`
+
+	// Temporality selects the aggregation temporality reported for Sum data points: "cumulative"
+	// (the default when empty) or "delta".
+	Temporality string `json:"temporality"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// export call should be retried immediately and how long to wait before doing so. It's
+	// optional; a nil value causes the endpoint to use its built-in defaults. This is independent
+	// of, and sits in front of, the outer Retry policy used by a RetryingSender's persisted retry
+	// queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+}
+
+func (e *OTLPEndpoint) Validate(c *Config) error {
+	if e.Endpoint == "" {
+		return errors.New("otlp: missing endpoint")
+	}
+	switch e.Protocol {
+	case "", "grpc", "http":
+	default:
+		return fmt.Errorf("otlp: unsupported protocol: %v", e.Protocol)
+	}
+	switch e.Compression {
+	case "", "gzip":
+	default:
+		return fmt.Errorf("otlp: unsupported compression: %v", e.Compression)
+	}
+	switch e.Temporality {
+	case "", "cumulative", "delta":
+	default:
+		return fmt.Errorf("otlp: unsupported temporality: %v", e.Temporality)
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloudEventsEndpoint forwards each aggregated report as a CNCF CloudEvents 1.0 structured-mode
+// event, POSTed to an HTTP sink.
+type CloudEventsEndpoint struct {
+	// Endpoint is the target sink address: an HTTP URL when Transport is "http" (the default), or
+	// an MQTT broker address (host:port) when Transport is "mqtt".
+	Endpoint string `json:"endpoint"`
+
+	// Source is the CloudEvents "source" attribute attached to every event.
+	Source string `json:"source"`
+
+	// TypePrefix is prepended to the report's metric name to form the CloudEvents "type" attribute.
+	TypePrefix string `json:"typePrefix"`
+
+	// Headers are attached to every POST request - for example, an API key expected by the sink.
+	// It's used only by the "http" Transport.
+	Headers map[string]string `json:"headers"`
+
+	// Transport selects how events are delivered to Endpoint: "" or "http" (the default) POSTs
+	// each event as a structured-mode CloudEvents request; "mqtt" PUBLISHes it to the broker and
+	// topic configured in MQTT.
+	Transport string `json:"transport"`
+
+	// MQTT configures the "mqtt" Transport. It's required when Transport is "mqtt" and ignored
+	// otherwise.
+	MQTT *MQTTTransport `json:"mqtt"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// send should be retried immediately and how long to wait before doing so. It's optional; a nil
+	// value causes the endpoint to use its built-in defaults. This is independent of, and sits in
+	// front of, the outer Retry policy used by a RetryingSender's persisted retry queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+}
+
+func (e *CloudEventsEndpoint) Validate(c *Config) error {
+	if e.Endpoint == "" {
+		return errors.New("cloudEvents: missing endpoint")
+	}
+	if e.Source == "" {
+		return errors.New("cloudEvents: missing source")
+	}
+	switch e.Transport {
+	case "", "http":
+		// No transport-specific requirements.
+	case "mqtt":
+		if e.MQTT == nil {
+			return errors.New("cloudEvents: mqtt transport requires mqtt configuration")
+		}
+		if err := e.MQTT.Validate(c); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cloudEvents: unknown transport %q", e.Transport)
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MQTTTransport configures delivery of CloudEvents over MQTT PUBLISH, at QoS 0
+// (fire-and-forget).
+type MQTTTransport struct {
+	// Topic is the MQTT topic each event is published to.
+	Topic string `json:"topic"`
+
+	// ClientId is the client identifier presented in the MQTT CONNECT packet. It's optional; a
+	// blank value lets the broker assign one.
+	ClientId string `json:"clientId"`
+
+	// Username and Password authenticate the CONNECT packet. Both are optional; if Username is
+	// blank, no credentials are sent.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (m *MQTTTransport) Validate(c *Config) error {
+	if m.Topic == "" {
+		return errors.New("cloudEvents: mqtt: missing topic")
+	}
+	return nil
+}
+
+// PromRemoteWriteEndpoint pushes aggregated metrics to a Prometheus remote-write receiver - for
+// example, Mimir, Cortex, or Prometheus itself - as a snappy-compressed protobuf WriteRequest with
+// one TimeSeries per report.
+type PromRemoteWriteEndpoint struct {
+	// Endpoint is the remote-write URL, e.g. "https://mimir.example.com/api/v1/push".
+	Endpoint string `json:"endpoint"`
+
+	// MetricPrefix is prepended to the report's metric name to form the pushed series' "__name__"
+	// label.
+	MetricPrefix string `json:"metricPrefix"`
+
+	// Headers are attached to every push request - for example, a multi-tenant "X-Scope-OrgID"
+	// header required by Mimir or Cortex.
+	Headers map[string]string `json:"headers"`
+
+	// BearerToken, if set, is sent as an "Authorization: Bearer <token>" header on every push
+	// request.
+	BearerToken string `json:"bearerToken"`
+
+	// TLS configures the push request's HTTP client, optionally with a client certificate for
+	// mutual TLS. It's optional; a nil value uses the system's default root CAs and presents no
+	// client certificate.
+	TLS *ClientTLSConfig `json:"tls"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// push should be retried immediately and how long to wait before doing so. It's optional; a
+	// nil value causes the endpoint to use its built-in defaults. This is independent of, and sits
+	// in front of, the outer Retry policy used by a RetryingSender's persisted retry queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+}
+
+func (e *PromRemoteWriteEndpoint) Validate(c *Config) error {
+	if e.Endpoint == "" {
+		return errors.New("promRemoteWrite: missing endpoint")
+	}
+	if e.TLS != nil {
+		if err := e.TLS.Validate(c); err != nil {
+			return err
+		}
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClientTLSConfig configures TLS for an outbound HTTP client connection, optionally presenting a
+// client certificate for mutual TLS. Unlike TLSConfig, which secures an ingestion listener, this
+// secures a connection this agent itself initiates.
+type ClientTLSConfig struct {
+	// CAFile, if set, is a PEM-encoded CA bundle used instead of the system's root CAs to verify
+	// the server's certificate.
+	CAFile string `json:"caFile"`
+
+	// CertFile and KeyFile, if both set, are a PEM-encoded client certificate and private key
+	// presented to the server for mutual TLS.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// ServerName overrides the TLS server name used to verify the server's certificate, when
+	// Endpoint's host isn't appropriate (e.g. behind a load balancer or SNI proxy).
+	ServerName string `json:"serverName"`
+}
+
+func (t *ClientTLSConfig) Validate(c *Config) error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("clientTls: certFile and keyFile must both be specified, or neither")
+	}
+	return nil
+}
+
+// defaultPrometheusPath mirrors endpoint/prometheus's own default, so Validate can reject a
+// RemoteWritePath that collides with an unset (defaulted) Path.
+const defaultPrometheusPath = "/metrics"
+
+// PrometheusEndpoint exposes accumulated reports as a Prometheus/OpenMetrics text scrape target,
+// rather than forwarding them to a remote service: each send is a no-op transport that folds the
+// report into an in-memory, per-series store, which an HTTP server exposes on Path for a scraper
+// to pull.
+type PrometheusEndpoint struct {
+	// Port is the local TCP port the scrape server listens on.
+	Port int `json:"port"`
+
+	// Path is the HTTP path metrics are served on. Defaults to "/metrics" when empty.
+	Path string `json:"path"`
+
+	// RemoteWritePath, if set, additionally accepts Prometheus remote_write snapshots on this
+	// HTTP path: a snappy-compressed protobuf WriteRequest, as a real Prometheus server or agent
+	// would send. Each received series is folded directly into this endpoint's in-memory store
+	// under its own "__name__" label, alongside (and served the same way as) metrics reported
+	// through the normal pipeline. Empty disables remote_write ingestion.
+	RemoteWritePath string `json:"remoteWritePath"`
+
+	// Metrics describes the exposition metadata - HELP text and Prometheus kind - for the ubbagent
+	// metrics this endpoint serves. A metric reported to this endpoint without an entry here is
+	// still served, using its own name as HELP text and PrometheusKindCounter as its kind.
+	Metrics []PrometheusEndpointMetric `json:"metrics"`
+}
+
+// PrometheusEndpointMetric describes one ubbagent metric's Prometheus/OpenMetrics exposition
+// metadata.
+type PrometheusEndpointMetric struct {
+	// Metric is the ubbagent metric name this entry describes.
+	Metric string `json:"metric"`
+
+	// Help is the human-readable HELP text shown for this series.
+	Help string `json:"help"`
+
+	// Kind is PrometheusKindCounter (the default) or PrometheusKindGauge. A counter accumulates
+	// each report's value as a delta into a monotonic sum; a gauge reports the most recently sent
+	// value directly.
+	Kind string `json:"kind"`
+
+	// IncludeLabels restricts this series' exposed labels to this list, dropping everything else
+	// a report carries. A nil IncludeLabels exposes every label a report carries, unfiltered.
+	IncludeLabels []string `json:"includeLabels"`
+}
+
+func (e *PrometheusEndpoint) Validate(c *Config) error {
+	if e.Port <= 0 {
+		return errors.New("prometheus: port must be > 0")
+	}
+	path := e.Path
+	if path == "" {
+		path = defaultPrometheusPath
+	}
+	if e.RemoteWritePath != "" && e.RemoteWritePath == path {
+		return errors.New("prometheus: remoteWritePath must differ from path")
+	}
+	for _, m := range e.Metrics {
+		if m.Metric == "" {
+			return errors.New("prometheus: missing metric")
+		}
+		if m.Kind != "" && m.Kind != PrometheusKindCounter && m.Kind != PrometheusKindGauge {
+			return fmt.Errorf("prometheus: %v: kind must be %q or %q", m.Metric, PrometheusKindCounter, PrometheusKindGauge)
+		}
+		if c.Metrics.GetMetricDefinition(m.Metric) == nil {
+			return fmt.Errorf("prometheus: unknown metric: %v", m.Metric)
+		}
+	}
+	return nil
+}
+
+// StackdriverEndpoint pushes aggregated reports to Cloud (Stackdriver) Monitoring as
+// CreateTimeSeries calls, under a custom metric type formed from MetricTypePrefix and attached to
+// Resource.
+type StackdriverEndpoint struct {
+	Identity string `json:"identity"`
+
+	// ProjectId is the GCP project the time series are written to.
+	ProjectId string `json:"projectId"`
+
+	// MetricTypePrefix is prepended to a report's metric name to form the Stackdriver metric type,
+	// e.g. "custom.googleapis.com/mysvc/" + "requests". It must start with
+	// "custom.googleapis.com/" or "external.googleapis.com/", per the Monitoring API's restrictions
+	// on where custom metric types may live.
+	MetricTypePrefix string `json:"metricTypePrefix"`
+
+	// Resource describes the monitored resource every time series from this endpoint is attached to.
+	Resource StackdriverResource `json:"resource"`
+
+	// Metrics describes, per ubbagent metric, whether it's written as a GAUGE or CUMULATIVE time
+	// series. A metric reported to this endpoint without an entry here defaults to
+	// StackdriverKindCumulative.
+	Metrics []StackdriverEndpointMetric `json:"metrics"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// CreateTimeSeries call should be retried immediately and how long to wait before doing so. It's
+	// optional; a nil value causes the endpoint to use its built-in defaults. This is independent
+	// of, and sits in front of, the outer Retry policy used by a RetryingSender's persisted retry
+	// queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+}
+
+// StackdriverResource identifies the monitored resource type and labels attached to every time
+// series an endpoint writes, e.g. {Type: "gce_instance", Labels: {"project_id": "...", ...}}.
+type StackdriverResource struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+}
+
+// StackdriverEndpointMetric describes one ubbagent metric's Stackdriver metric kind.
+type StackdriverEndpointMetric struct {
+	// Metric is the ubbagent metric name this entry describes.
+	Metric string `json:"metric"`
+
+	// Kind is StackdriverKindCumulative (the default) or StackdriverKindGauge. A cumulative metric
+	// reports each point as an accumulation since the series' start time; a gauge reports each
+	// point as an instantaneous measurement.
+	Kind string `json:"kind"`
+}
+
+const (
+	StackdriverKindGauge      = "gauge"
+	StackdriverKindCumulative = "cumulative"
+)
+
+func (e *StackdriverEndpoint) Validate(c *Config) error {
+	if err := validateGcpKey(c.Identities, "stackdriver", e.Identity); err != nil {
+		return err
+	}
+	if e.ProjectId == "" {
+		return errors.New("stackdriver: missing project ID")
+	}
+	if !(strings.HasPrefix(e.MetricTypePrefix, "custom.googleapis.com/") ||
+		strings.HasPrefix(e.MetricTypePrefix, "external.googleapis.com/")) {
+		return errors.New(`stackdriver: metricTypePrefix must start with "custom.googleapis.com/" or "external.googleapis.com/"`)
+	}
+	if e.Resource.Type == "" {
+		return errors.New("stackdriver: missing resource type")
+	}
+	for _, m := range e.Metrics {
+		if m.Metric == "" {
+			return errors.New("stackdriver: missing metric")
+		}
+		if m.Kind != "" && m.Kind != StackdriverKindGauge && m.Kind != StackdriverKindCumulative {
+			return fmt.Errorf("stackdriver: %v: kind must be %q or %q", m.Metric, StackdriverKindGauge, StackdriverKindCumulative)
+		}
+		if c.Metrics.GetMetricDefinition(m.Metric) == nil {
+			return fmt.Errorf("stackdriver: unknown metric: %v", m.Metric)
+		}
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloudWatchEndpoint pushes aggregated reports to Amazon CloudWatch as PutMetricData calls, under
+// Namespace.
+type CloudWatchEndpoint struct {
+	Identity string `json:"identity"`
+
+	// Namespace is the CloudWatch metric namespace every report from this endpoint is published
+	// under, e.g. "MyCompany/MyService".
+	Namespace string `json:"namespace"`
+
+	// Metrics describes, per ubbagent metric, the CloudWatch unit its values are published with. A
+	// metric reported to this endpoint without an entry here defaults to "None".
+	Metrics []CloudWatchEndpointMetric `json:"metrics"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// PutMetricData call should be retried immediately and how long to wait before doing so. It's
+	// optional; a nil value causes the endpoint to use its built-in defaults. This is independent
+	// of, and sits in front of, the outer Retry policy used by a RetryingSender's persisted retry
+	// queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+}
+
+// CloudWatchEndpointMetric describes one ubbagent metric's CloudWatch exposition metadata.
+type CloudWatchEndpointMetric struct {
+	// Metric is the ubbagent metric name this entry describes.
+	Metric string `json:"metric"`
+
+	// Unit is the CloudWatch unit values are published with, e.g. "Count" or "Seconds". Defaults
+	// to "None" when empty.
+	Unit string `json:"unit"`
+}
+
+func (e *CloudWatchEndpoint) Validate(c *Config) error {
+	if err := validateAwsKey(c.Identities, "cloudWatch", e.Identity); err != nil {
+		return err
+	}
+	if e.Namespace == "" {
+		return errors.New("cloudWatch: missing namespace")
+	}
+	for _, m := range e.Metrics {
+		if m.Metric == "" {
+			return errors.New("cloudWatch: missing metric")
+		}
+		if c.Metrics.GetMetricDefinition(m.Metric) == nil {
+			return fmt.Errorf("cloudWatch: unknown metric: %v", m.Metric)
+		}
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AzureMonitorEndpoint pushes aggregated reports to Azure Monitor as custom-metric data points,
+// attached to ResourceId.
+type AzureMonitorEndpoint struct {
+	Identity string `json:"identity"`
+
+	// ResourceId is the full Azure Resource Manager ID of the resource metrics are attached to,
+	// e.g. "/subscriptions/.../resourceGroups/.../providers/.../...".
+	ResourceId string `json:"resourceId"`
+
+	// Region is the Azure region of the Azure Monitor custom-metrics ingestion endpoint to publish
+	// to, e.g. "eastus".
+	Region string `json:"region"`
+
+	// Namespace is the custom metric namespace every report from this endpoint is published
+	// under.
+	Namespace string `json:"namespace"`
+
+	// Backoff configures the endpoint's in-process retry policy, used to decide whether a failed
+	// ingestion call should be retried immediately and how long to wait before doing so. It's
+	// optional; a nil value causes the endpoint to use its built-in defaults. This is independent
+	// of, and sits in front of, the outer Retry policy used by a RetryingSender's persisted retry
+	// queue.
+	Backoff *BackoffPolicy `json:"backoff"`
+}
+
+func (e *AzureMonitorEndpoint) Validate(c *Config) error {
+	if err := validateAzureKey(c.Identities, "azureMonitor", e.Identity); err != nil {
+		return err
+	}
+	if e.ResourceId == "" {
+		return errors.New("azureMonitor: missing resourceId")
+	}
+	if e.Region == "" {
+		return errors.New("azureMonitor: missing region")
+	}
+	if e.Namespace == "" {
+		return errors.New("azureMonitor: missing namespace")
+	}
+	if e.Backoff != nil {
+		if err := e.Backoff.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CustomEndpoint configures an endpoint kind that isn't built into ubbagent. Kind must match a
+// kind string some package - third-party or otherwise - has registered with endpoints.Register,
+// typically from that package's own init function; Params is handed to that kind's factory
+// unparsed, for the factory to decode itself. This is the extension point that lets a proprietary
+// sink (a Kafka topic, Kinesis stream, internal billing API, ...) be wired in without forking
+// ubbagent's config schema to add a dedicated field.
+type CustomEndpoint struct {
+	Kind   string          `json:"kind"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (e *CustomEndpoint) Validate(c *Config) error {
+	if e.Kind == "" {
+		return errors.New("custom: missing kind")
+	}
+	return nil
+}
+
+// StructuredLogEndpoint writes each report as a newline-delimited JSON record to Stdout, a
+// rotating File, or Syslog, so an external log pipeline (Fluent Bit, promtail, the Cloud Logging
+// agent, ...) can scrape the agent's output instead of requiring a push integration.
+type StructuredLogEndpoint struct {
+	// oneof - where each JSON record is written
+	Stdout *StructuredLogStdout `json:"stdout"`
+	File   *StructuredLogFile   `json:"file"`
+	Syslog *StructuredLogSyslog `json:"syslog"`
+}
+
+func (e *StructuredLogEndpoint) Validate(c *Config) error {
+	types := 0
+	for _, v := range []Validatable{e.Stdout, e.File, e.Syslog} {
+		if reflect.ValueOf(v).IsNil() {
+			continue
+		}
+		if err := v.Validate(c); err != nil {
+			return err
+		}
+		types++
+	}
+	if types == 0 {
+		return errors.New("structuredLog: missing destination configuration")
+	}
+	if types > 1 {
+		return errors.New("structuredLog: multiple destination configurations")
+	}
+	return nil
+}
+
+// StructuredLogStdout writes records to the agent's standard output.
+type StructuredLogStdout struct{}
+
+func (s *StructuredLogStdout) Validate(c *Config) error { return nil }
+
+// StructuredLogFile writes records to a rotating file on disk.
+type StructuredLogFile struct {
+	// Path is the file records are appended to.
+	Path string `json:"path"`
+
+	// Rotation configures size- and time-based rotation of Path. It's optional; a nil value means
+	// records are appended to Path forever, with no rotation.
+	Rotation *DiskRotation `json:"rotation"`
+}
+
+func (s *StructuredLogFile) Validate(c *Config) error {
+	if s.Path == "" {
+		return errors.New("structuredLog.file: missing path")
+	}
+	if s.Rotation != nil {
+		if err := s.Rotation.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StructuredLogSyslog writes records to a syslog daemon.
+type StructuredLogSyslog struct {
+	// Network and Addr dial a remote syslog daemon, e.g. Network: "tcp", Addr: "localhost:514".
+	// Leaving both empty connects to the local syslog daemon instead, as with the standard
+	// log/syslog package's Dial.
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+
+	// Tag identifies this agent in each syslog entry. It's optional; the empty string uses the
+	// executable's own name, per log/syslog's default.
+	Tag string `json:"tag"`
+}
+
+func (s *StructuredLogSyslog) Validate(c *Config) error { return nil }
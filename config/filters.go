@@ -18,31 +18,48 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 )
 
+// Filter describes a single named report-processing step that can be attached, by name, to a
+// Metric or an Endpoint. Filters attached to the same Metric or Endpoint chain in declared order;
+// any filter in the chain may drop a report, short-circuiting the rest of the chain.
 type Filter struct {
+	// Name identifies this filter so it can be referenced from a Metric's or Endpoint's Filters
+	// list. It must be unique among all configured filters.
+	Name string `json:"name"`
+
 	// oneof
-	AddLabels *AddLabels `json:"addLabels"`
+	AddLabels    *AddLabels    `json:"addLabels"`
+	DropLabels   *DropLabels   `json:"dropLabels"`
+	RenameLabels *RenameLabels `json:"renameLabels"`
+	Relabel      *Relabel      `json:"relabel"`
+	DropIf       *Match        `json:"dropIf"`
+	KeepIf       *Match        `json:"keepIf"`
+	Sample       *Sample       `json:"sample"`
 }
 
 func (f *Filter) Validate(c *Config) error {
+	if f.Name == "" {
+		return errors.New("filter: missing name")
+	}
 	types := 0
-	for _, v := range []Validatable{f.AddLabels} {
+	for _, v := range []Validatable{f.AddLabels, f.DropLabels, f.RenameLabels, f.Relabel, f.DropIf, f.KeepIf, f.Sample} {
 		if reflect.ValueOf(v).IsNil() {
 			continue
 		}
 		if err := v.Validate(c); err != nil {
-			return err
+			return fmt.Errorf("filter %v: %v", f.Name, err)
 		}
 		types++
 	}
 
 	if types == 0 {
-		return errors.New("missing filter configuration")
+		return fmt.Errorf("filter %v: missing filter configuration", f.Name)
 	}
 
 	if types > 1 {
-		return fmt.Errorf("multiple filter configurations")
+		return fmt.Errorf("filter %v: multiple filter configurations", f.Name)
 	}
 
 	return nil
@@ -51,10 +68,36 @@ func (f *Filter) Validate(c *Config) error {
 type Filters []Filter
 
 func (m Filters) Validate(c *Config) error {
+	usedNames := make(map[string]bool)
 	for _, def := range m {
 		if err := def.Validate(c); err != nil {
 			return err
 		}
+		if usedNames[def.Name] {
+			return fmt.Errorf("filter %v: duplicate name", def.Name)
+		}
+		usedNames[def.Name] = true
+	}
+	return nil
+}
+
+// Get returns the Filter with the given name, or nil if it does not exist.
+func (m Filters) Get(name string) *Filter {
+	for i := range m {
+		if m[i].Name == name {
+			return &m[i]
+		}
+	}
+	return nil
+}
+
+// validateFilterNames returns an error if any of names does not refer to a Filter defined in
+// c.Filters.
+func validateFilterNames(c *Config, names []string) error {
+	for _, name := range names {
+		if c.Filters.Get(name) == nil {
+			return fmt.Errorf("unknown filter: %v", name)
+		}
 	}
 	return nil
 }
@@ -83,3 +126,185 @@ func (f *AddLabels) IncludedLabels() map[string]string {
 	}
 	return included
 }
+
+// DropLabels removes labels from a report, if present: those named explicitly in Labels, plus any
+// whose key matches one of Patterns. At least one of Labels or Patterns must be set.
+type DropLabels struct {
+	Labels   []string `json:"labels"`
+	Patterns []string `json:"patterns"`
+}
+
+func (f *DropLabels) Validate(c *Config) error {
+	if len(f.Labels) == 0 && len(f.Patterns) == 0 {
+		return errors.New("dropLabels: at least one of labels or patterns is required")
+	}
+	for _, pattern := range f.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("dropLabels: invalid pattern %v: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// RenameLabels renames report labels, replacing each key present in Renames with its corresponding
+// value. If a renamed key collides with a label the report already has - or with another renamed
+// label - OnCollision determines which value wins.
+type RenameLabels struct {
+	// Renames maps an existing label key to the key it should be renamed to.
+	Renames map[string]string `json:"renames"`
+
+	// OnCollision is one of "overwrite" (the default) or "keep". "overwrite" lets a renamed label
+	// replace a colliding label's value; "keep" leaves the colliding label's existing value in
+	// place and discards the renamed one.
+	OnCollision string `json:"onCollision"`
+}
+
+func (f *RenameLabels) Validate(c *Config) error {
+	if len(f.Renames) == 0 {
+		return errors.New("renameLabels: missing renames")
+	}
+	for old, renamed := range f.Renames {
+		if old == "" || renamed == "" {
+			return errors.New("renameLabels: renames keys and values must not be empty")
+		}
+	}
+	switch f.OnCollision {
+	case "", RenameLabelsOverwrite, RenameLabelsKeep:
+	default:
+		return fmt.Errorf("renameLabels: unsupported onCollision: %v", f.OnCollision)
+	}
+	return nil
+}
+
+const (
+	// RenameLabelsOverwrite lets a renamed label replace a colliding label's existing value. It's
+	// the default when RenameLabels.OnCollision is unset.
+	RenameLabelsOverwrite = "overwrite"
+
+	// RenameLabelsKeep leaves a colliding label's existing value in place, discarding the renamed
+	// one.
+	RenameLabelsKeep = "keep"
+)
+
+// Relabel applies a sequence of Prometheus-style relabeling rules to a report's labels.
+type Relabel struct {
+	Rules []RelabelRule `json:"rules"`
+}
+
+func (r *Relabel) Validate(c *Config) error {
+	if len(r.Rules) == 0 {
+		return errors.New("relabel: missing rules")
+	}
+	for i := range r.Rules {
+		if err := r.Rules[i].Validate(c); err != nil {
+			return fmt.Errorf("relabel: rule %v: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// RelabelRule mirrors a single Prometheus relabel_config entry. SourceLabels are joined with
+// Separator and matched against Regex; what happens next depends on Action:
+//
+//   - "replace" (the default): if the joined value matches Regex, TargetLabel is set to
+//     Replacement, which may reference Regex's capture groups (e.g. "$1").
+//   - "keep": the report is kept only if the joined value matches Regex.
+//   - "drop": the report is dropped if the joined value matches Regex.
+//   - "hashmod": TargetLabel is set to the joined value's hash, modulo Modulus, as a decimal
+//     string. Typically paired with a KeepIf/DropIf filter to shard reports deterministically.
+type RelabelRule struct {
+	SourceLabels []string `json:"sourceLabels"`
+	Separator    string   `json:"separator"`
+	Regex        string   `json:"regex"`
+	TargetLabel  string   `json:"targetLabel"`
+	Replacement  string   `json:"replacement"`
+	Action       string   `json:"action"`
+	Modulus      uint64   `json:"modulus"`
+}
+
+func (r *RelabelRule) Validate(c *Config) error {
+	switch r.Action {
+	case "", "replace":
+		if r.TargetLabel == "" {
+			return errors.New("replace action requires targetLabel")
+		}
+	case "keep", "drop":
+	case "hashmod":
+		if r.TargetLabel == "" {
+			return errors.New("hashmod action requires targetLabel")
+		}
+		if r.Modulus == 0 {
+			return errors.New("hashmod action requires modulus > 0")
+		}
+	default:
+		return fmt.Errorf("unsupported action: %v", r.Action)
+	}
+	if r.Regex != "" {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+	return nil
+}
+
+// Match describes a predicate over a report's metric name and label values, used by DropIf and
+// KeepIf. At least one of Metric, Labels, or LabelRegex must be set. A report matches if Metric
+// (when set) equals the report's metric name, every entry in Labels equals the report's
+// corresponding label value, and every entry in LabelRegex matches the report's corresponding
+// label value.
+type Match struct {
+	Metric     string            `json:"metric"`
+	Labels     map[string]string `json:"labels"`
+	LabelRegex map[string]string `json:"labelRegex"`
+}
+
+func (m *Match) Validate(c *Config) error {
+	if m.Metric == "" && len(m.Labels) == 0 && len(m.LabelRegex) == 0 {
+		return errors.New("match: at least one of metric, labels, or labelRegex is required")
+	}
+	for k, pattern := range m.LabelRegex {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("match: invalid regex for label %v: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// Sample keeps a fraction of reports, either probabilistically (Rate) or deterministically by
+// hashing the report ID (HashMod). Exactly one of Rate or HashMod must be set.
+type Sample struct {
+	// Rate keeps each report independently with this probability, in [0, 1].
+	Rate *float64 `json:"rate"`
+
+	// HashMod keeps a report if the hash of its ID, modulo Modulus, is one of Remainders. Unlike
+	// Rate, the same report ID always produces the same keep/drop decision.
+	HashMod *HashMod `json:"hashMod"`
+}
+
+func (s *Sample) Validate(c *Config) error {
+	if (s.Rate == nil) == (s.HashMod == nil) {
+		return errors.New("sample: exactly one of rate or hashMod is required")
+	}
+	if s.Rate != nil && (*s.Rate < 0 || *s.Rate > 1) {
+		return errors.New("sample: rate must be between 0 and 1")
+	}
+	if s.HashMod != nil {
+		if s.HashMod.Modulus == 0 {
+			return errors.New("sample: hashMod: modulus must be > 0")
+		}
+		if len(s.HashMod.Remainders) == 0 {
+			return errors.New("sample: hashMod: missing remainders")
+		}
+		for _, rem := range s.HashMod.Remainders {
+			if rem >= s.HashMod.Modulus {
+				return fmt.Errorf("sample: hashMod: remainder %v must be less than modulus %v", rem, s.HashMod.Modulus)
+			}
+		}
+	}
+	return nil
+}
+
+type HashMod struct {
+	Modulus    uint64   `json:"modulus"`
+	Remainders []uint64 `json:"remainders"`
+}
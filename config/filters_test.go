@@ -15,11 +15,11 @@
 package config_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/GoogleCloudPlatform/ubbagent/config"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
-	"reflect"
 )
 
 func TestFilters_Validate(t *testing.T) {
@@ -46,6 +46,7 @@ func TestFilters_Validate(t *testing.T) {
 		c := conf
 		c.Filters = config.Filters{
 			{
+				Name: "add-labels",
 				AddLabels: &config.AddLabels{
 					Labels: map[string]string{
 						"foo1": "bar1",
@@ -60,13 +61,62 @@ func TestFilters_Validate(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid: missing name", func(t *testing.T) {
+		c := conf
+		c.Filters = config.Filters{
+			{
+				AddLabels: &config.AddLabels{Labels: map[string]string{"foo": "bar"}},
+			},
+		}
+
+		expected := "filter: missing name"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected validate error, got nil")
+		} else if err.Error() != expected {
+			t.Fatalf("validate error: want=%v, got=%v", expected, err.Error())
+		}
+	})
+
 	t.Run("invalid: missing filter config", func(t *testing.T) {
 		c := conf
 		c.Filters = config.Filters{
-			{},
+			{Name: "empty"},
+		}
+
+		expected := "filter empty: missing filter configuration"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected validate error, got nil")
+		} else if err.Error() != expected {
+			t.Fatalf("validate error: want=%v, got=%v", expected, err.Error())
+		}
+	})
+
+	t.Run("invalid: multiple filter configs", func(t *testing.T) {
+		c := conf
+		c.Filters = config.Filters{
+			{
+				Name:       "both",
+				AddLabels:  &config.AddLabels{Labels: map[string]string{"foo": "bar"}},
+				DropLabels: &config.DropLabels{Labels: []string{"foo"}},
+			},
+		}
+
+		expected := "filter both: multiple filter configurations"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected validate error, got nil")
+		} else if err.Error() != expected {
+			t.Fatalf("validate error: want=%v, got=%v", expected, err.Error())
+		}
+	})
+
+	t.Run("invalid: duplicate names", func(t *testing.T) {
+		c := conf
+		c.Filters = config.Filters{
+			{Name: "dup", AddLabels: &config.AddLabels{Labels: map[string]string{"foo": "bar"}}},
+			{Name: "dup", DropLabels: &config.DropLabels{Labels: []string{"foo"}}},
 		}
 
-		expected := "missing filter configuration"
+		expected := "filter dup: duplicate name"
 		if err := c.Validate(); err == nil {
 			t.Fatal("expected validate error, got nil")
 		} else if err.Error() != expected {
@@ -77,12 +127,50 @@ func TestFilters_Validate(t *testing.T) {
 	t.Run("invalid: missing labels", func(t *testing.T) {
 		c := conf
 		c.Filters = config.Filters{
+			{Name: "add-labels", AddLabels: &config.AddLabels{}},
+		}
+
+		expected := "filter add-labels: addLabels: missing labels"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected validate error, got nil")
+		} else if err.Error() != expected {
+			t.Fatalf("validate error: want=%v, got=%v", expected, err.Error())
+		}
+	})
+
+	t.Run("invalid: metric references unknown filter", func(t *testing.T) {
+		c := conf
+		c.Metrics = config.Metrics{
+			{
+				Definition:  metrics.Definition{Name: "int-metric", Type: "int"},
+				Endpoints:   []config.MetricEndpoint{{Name: "disk"}},
+				Aggregation: &config.Aggregation{BufferSeconds: 10},
+				Filters:     []string{"nonexistent"},
+			},
+		}
+
+		expected := "metric int-metric: unknown filter: nonexistent"
+		if err := c.Validate(); err == nil {
+			t.Fatal("expected validate error, got nil")
+		} else if err.Error() != expected {
+			t.Fatalf("validate error: want=%v, got=%v", expected, err.Error())
+		}
+	})
+
+	t.Run("invalid: endpoint references unknown filter", func(t *testing.T) {
+		c := conf
+		c.Endpoints = config.Endpoints{
 			{
-				AddLabels: &config.AddLabels{},
+				Name: "disk",
+				Disk: &config.DiskEndpoint{
+					ReportDir:     "/tmp/foo1",
+					ExpireSeconds: 3600,
+				},
+				Filters: []string{"nonexistent"},
 			},
 		}
 
-		expected := "addLabels: missing labels"
+		expected := "endpoint disk: unknown filter: nonexistent"
 		if err := c.Validate(); err == nil {
 			t.Fatal("expected validate error, got nil")
 		} else if err.Error() != expected {
@@ -129,3 +217,148 @@ func TestAddLabels_IncludedLabels(t *testing.T) {
 		}
 	})
 }
+
+func TestRelabel_Validate(t *testing.T) {
+	t.Run("valid replace", func(t *testing.T) {
+		r := config.Relabel{Rules: []config.RelabelRule{{SourceLabels: []string{"a"}, Regex: "(.*)", TargetLabel: "b", Replacement: "$1"}}}
+		if err := r.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("invalid: replace missing targetLabel", func(t *testing.T) {
+		r := config.Relabel{Rules: []config.RelabelRule{{SourceLabels: []string{"a"}}}}
+		if err := r.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: hashmod missing modulus", func(t *testing.T) {
+		r := config.Relabel{Rules: []config.RelabelRule{{Action: "hashmod", TargetLabel: "shard"}}}
+		if err := r.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: bad regex", func(t *testing.T) {
+		r := config.Relabel{Rules: []config.RelabelRule{{TargetLabel: "b", Regex: "("}}}
+		if err := r.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+}
+
+func TestDropLabels_Validate(t *testing.T) {
+	t.Run("valid labels only", func(t *testing.T) {
+		d := config.DropLabels{Labels: []string{"secret"}}
+		if err := d.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("valid patterns only", func(t *testing.T) {
+		d := config.DropLabels{Patterns: []string{"^internal_.*$"}}
+		if err := d.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("invalid: missing labels and patterns", func(t *testing.T) {
+		d := config.DropLabels{}
+		if err := d.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: bad pattern", func(t *testing.T) {
+		d := config.DropLabels{Patterns: []string{"("}}
+		if err := d.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+}
+
+func TestRenameLabels_Validate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		r := config.RenameLabels{Renames: map[string]string{"old": "new"}}
+		if err := r.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("valid with onCollision", func(t *testing.T) {
+		r := config.RenameLabels{Renames: map[string]string{"old": "new"}, OnCollision: config.RenameLabelsKeep}
+		if err := r.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("invalid: missing renames", func(t *testing.T) {
+		r := config.RenameLabels{}
+		if err := r.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: unsupported onCollision", func(t *testing.T) {
+		r := config.RenameLabels{Renames: map[string]string{"old": "new"}, OnCollision: "explode"}
+		if err := r.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+}
+
+func TestMatch_Validate(t *testing.T) {
+	t.Run("invalid: no conditions", func(t *testing.T) {
+		m := config.Match{}
+		if err := m.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("valid: metric only", func(t *testing.T) {
+		m := config.Match{Metric: "int-metric"}
+		if err := m.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("invalid: bad label regex", func(t *testing.T) {
+		m := config.Match{LabelRegex: map[string]string{"foo": "("}}
+		if err := m.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+}
+
+func TestSample_Validate(t *testing.T) {
+	rate := 0.5
+	t.Run("valid rate", func(t *testing.T) {
+		s := config.Sample{Rate: &rate}
+		if err := s.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("invalid: rate out of range", func(t *testing.T) {
+		bad := 1.5
+		s := config.Sample{Rate: &bad}
+		if err := s.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: both rate and hashMod", func(t *testing.T) {
+		s := config.Sample{Rate: &rate, HashMod: &config.HashMod{Modulus: 10, Remainders: []uint64{0}}}
+		if err := s.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: hashMod remainder out of range", func(t *testing.T) {
+		s := config.Sample{HashMod: &config.HashMod{Modulus: 10, Remainders: []uint64{10}}}
+		if err := s.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "fmt"
+
+const (
+	// AuthModeToken requires every request to present the contents of Auth.TokenFile as an
+	// "Authorization: Bearer <token>" header.
+	AuthModeToken = "token"
+
+	// AuthModeMTLS requires every request to present a client certificate verified against
+	// Server.TLS.ClientCAFile, which the TLS handshake itself already enforces - this mode exists
+	// so Auth.Validate can confirm a client-CA bundle is actually configured, rather than an
+	// operator believing "mtls" is enabled when it silently isn't.
+	AuthModeMTLS = "mtls"
+
+	// AuthModeK8s requires every request to present a Kubernetes service account token as an
+	// "Authorization: Bearer <token>" header, authenticated against K8s's TokenReview API.
+	AuthModeK8s = "k8sTokenReview"
+)
+
+// HttpAuth configures authentication for the agent's /report and /status HTTP interface. Exactly
+// one Mode is active at a time.
+type HttpAuth struct {
+	// Mode selects the authentication scheme: one of AuthModeToken, AuthModeMTLS, or AuthModeK8s.
+	Mode string `json:"mode"`
+
+	// TokenFile is the path to a file containing the bearer token required by AuthModeToken. The
+	// file's contents (trimmed of surrounding whitespace) are read once, at startup.
+	TokenFile string `json:"tokenFile"`
+
+	// K8sTokenReview configures AuthModeK8s. Required when Mode is AuthModeK8s; ignored otherwise.
+	K8sTokenReview *K8sTokenReviewAuth `json:"k8sTokenReview"`
+
+	// AllowAnonymousStatus, if true, exempts /status (but never /report) from authentication, so a
+	// liveness or readiness probe doesn't need credentials while reports still require them.
+	AllowAnonymousStatus bool `json:"allowAnonymousStatus"`
+}
+
+// K8sTokenReviewAuth configures authenticating a caller's bearer token against a Kubernetes API
+// server's authentication.k8s.io/v1 TokenReview endpoint - the same mechanism the kubelet and
+// other in-cluster components use to validate a service account token presented to them.
+type K8sTokenReviewAuth struct {
+	// APIServerURL is the base URL of the Kubernetes API server, e.g.
+	// "https://kubernetes.default.svc".
+	APIServerURL string `json:"apiServerUrl"`
+
+	// CAFile, if set, is a PEM-encoded CA bundle used instead of the system's root CAs to verify
+	// the API server's certificate - typically
+	// "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt" when running in-cluster.
+	CAFile string `json:"caFile"`
+
+	// ServiceAccountTokenFile is the path to the bearer token ubbagent itself presents to the API
+	// server to authenticate the TokenReview call - typically
+	// "/var/run/secrets/kubernetes.io/serviceaccount/token" when running in-cluster. The file's
+	// contents are read fresh for every TokenReview call, so a projected token that's rotated out
+	// from under the process is picked up without a restart.
+	ServiceAccountTokenFile string `json:"serviceAccountTokenFile"`
+
+	// Audiences, if non-empty, are passed as the TokenReview's spec.audiences, restricting
+	// acceptance to a caller's token that was minted for one of these audiences.
+	Audiences []string `json:"audiences"`
+}
+
+func (a *HttpAuth) Validate(c *Config) error {
+	switch a.Mode {
+	case AuthModeToken:
+		if a.TokenFile == "" {
+			return fmt.Errorf("auth: mode %v requires tokenFile", AuthModeToken)
+		}
+	case AuthModeMTLS:
+		if c.Server == nil || c.Server.TLS == nil || c.Server.TLS.ClientCAFile == "" {
+			return fmt.Errorf("auth: mode %v requires server.tls.clientCaFile", AuthModeMTLS)
+		}
+	case AuthModeK8s:
+		if a.K8sTokenReview == nil || a.K8sTokenReview.APIServerURL == "" {
+			return fmt.Errorf("auth: mode %v requires k8sTokenReview.apiServerUrl", AuthModeK8s)
+		}
+		if a.K8sTokenReview.ServiceAccountTokenFile == "" {
+			return fmt.Errorf("auth: mode %v requires k8sTokenReview.serviceAccountTokenFile", AuthModeK8s)
+		}
+	default:
+		return fmt.Errorf("auth: unknown mode: %v", a.Mode)
+	}
+	return nil
+}
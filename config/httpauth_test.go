@@ -0,0 +1,71 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+func TestHttpAuth_Validate(t *testing.T) {
+	t.Run("rejects an unknown mode", func(t *testing.T) {
+		a := config.HttpAuth{Mode: "bogus"}
+		if err := a.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with an unknown mode")
+		}
+	})
+
+	t.Run("token mode requires tokenFile", func(t *testing.T) {
+		a := config.HttpAuth{Mode: config.AuthModeToken}
+		if err := a.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with a missing tokenFile")
+		}
+		a.TokenFile = "token.txt"
+		if err := a.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("mtls mode requires server.tls.clientCaFile", func(t *testing.T) {
+		a := config.HttpAuth{Mode: config.AuthModeMTLS}
+		if err := a.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with no Server.TLS at all")
+		}
+		c := &config.Config{Server: &config.Server{TLS: &config.TLSConfig{CertFile: "c", KeyFile: "k"}}}
+		if err := a.Validate(c); err == nil {
+			t.Fatal("expected an error with no clientCaFile")
+		}
+		c.Server.TLS.ClientCAFile = "ca.pem"
+		if err := a.Validate(c); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("k8sTokenReview mode requires apiServerUrl and serviceAccountTokenFile", func(t *testing.T) {
+		a := config.HttpAuth{Mode: config.AuthModeK8s}
+		if err := a.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with no K8sTokenReview at all")
+		}
+		a.K8sTokenReview = &config.K8sTokenReviewAuth{APIServerURL: "https://kubernetes.default.svc"}
+		if err := a.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with no serviceAccountTokenFile")
+		}
+		a.K8sTokenReview.ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+		if err := a.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
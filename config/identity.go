@@ -15,7 +15,9 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -50,9 +52,44 @@ func (identities Identities) Get(name string) *Identity {
 	return nil
 }
 
+// ResolveGCP returns the effective GCPIdentity for the named identity: itself, if it's a direct
+// GCP identity, or a copy of its base identity's GCPIdentity with the Impersonate configuration
+// applied, if it's an Impersonate identity. It's the entry point endpoint/source constructors
+// should use instead of identities.Get(name).GCP, so that Impersonate identities are transparent
+// to them.
+func (identities Identities) ResolveGCP(name string) (*GCPIdentity, error) {
+	i := identities.Get(name)
+	if i == nil {
+		return nil, fmt.Errorf("identity: nonexistent identity: %v", name)
+	}
+	if i.GCP != nil {
+		return i.GCP, nil
+	}
+	if i.Impersonate == nil {
+		return nil, fmt.Errorf("identity: %v is not a GCP identity", name)
+	}
+	base := identities.Get(i.Impersonate.BaseIdentity)
+	if base == nil || base.GCP == nil {
+		return nil, fmt.Errorf("identity: %v: base identity %v is not a GCP identity", name, i.Impersonate.BaseIdentity)
+	}
+	resolved := *base.GCP
+	resolved.ImpersonateServiceAccount = i.Impersonate.TargetServiceAccount
+	resolved.ImpersonateDelegates = i.Impersonate.Delegates
+	resolved.ImpersonateScopes = i.Impersonate.Scopes
+	return &resolved, nil
+}
+
 type Identity struct {
-	Name string       `json:"name"`
-	GCP  *GCPIdentity `json:"gcp"`
+	Name  string         `json:"name"`
+	GCP   *GCPIdentity   `json:"gcp"`
+	Azure *AzureIdentity `json:"azure"`
+	AWS   *AWSIdentity   `json:"aws"`
+
+	// Impersonate, if set, makes this identity resolve to an impersonated token minted from an
+	// existing GCP identity, rather than a credential of its own. This lets a single downloaded key
+	// (or external account) configured once act as several distinct ServiceControl/PubSub
+	// consumers, each declared as its own named Impersonate identity.
+	Impersonate *ImpersonateIdentity `json:"impersonate"`
 }
 
 func (i *Identity) Validate(c *Config) error {
@@ -61,7 +98,7 @@ func (i *Identity) Validate(c *Config) error {
 	}
 
 	types := 0
-	for _, v := range []Validatable{i.GCP} {
+	for _, v := range []Validatable{i.GCP, i.Azure, i.AWS, i.Impersonate} {
 		if reflect.ValueOf(v).IsNil() {
 			continue
 		}
@@ -82,10 +119,62 @@ func (i *Identity) Validate(c *Config) error {
 	return nil
 }
 
+// Hash returns a stable content hash of i's configuration subtree, excluding Name.
+func (i *Identity) Hash() (string, error) {
+	unnamed := *i
+	unnamed.Name = ""
+	data, err := json.Marshal(unnamed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 // GCPIdentity holds configuration for identifying to Google Cloud Platform services.
 type GCPIdentity struct {
 	ServiceAccountKey        *LiteralServiceAccountKey `json:"serviceAccountKey"`
 	EncodedServiceAccountKey *EncodedServiceAccountKey `json:"encodedServiceAccountKey"`
+
+	// ExternalAccount configures workload identity federation: credentials are obtained by
+	// exchanging a platform-native token (e.g. an AWS IMDS identity or an OIDC token) for a
+	// short-lived GCP access token at Secure Token Service, instead of a long-lived key. It's
+	// mutually exclusive with ServiceAccountKey and EncodedServiceAccountKey.
+	ExternalAccount *ExternalAccountConfig `json:"externalAccount"`
+
+	// ApplicationDefault, when true, causes credentials to be obtained via Application Default
+	// Credentials: the GOOGLE_APPLICATION_CREDENTIALS file, the gcloud user credentials, or (most
+	// commonly, for marketplace agents) the GCE/GKE/Cloud Run instance metadata service. It's
+	// mutually exclusive with the other key types and requires no key to be baked into the config.
+	ApplicationDefault bool `json:"applicationDefault"`
+
+	// SelfSignedJWT, when true, causes credentials to be obtained by locally signing a JWT with
+	// ServiceAccountKey or EncodedServiceAccountKey and presenting it directly as a bearer token,
+	// rather than exchanging the key for an access token at Google's OAuth token endpoint. This
+	// saves a network round-trip per token refresh, at the cost of being usable only against APIs
+	// (such as Service Control) that accept self-signed JWTs as bearer tokens. Requires exactly one
+	// of ServiceAccountKey or EncodedServiceAccountKey and is mutually exclusive with
+	// ImpersonateServiceAccount, since impersonation needs an OAuth2 token to call the IAM API.
+	SelfSignedJWT bool `json:"selfSignedJwt"`
+
+	// ImpersonateServiceAccount, if set, is the email address of a service account to impersonate.
+	// The credential configured above (key, external account, or application default) is used only
+	// as the bootstrap identity; the token actually presented to the API being called is minted by
+	// impersonating this service account. This lets operators keep a single bootstrap key while
+	// billing as a different service account.
+	ImpersonateServiceAccount string `json:"impersonateServiceAccount"`
+
+	// ImpersonateDelegates names an optional chain of intermediate service accounts to impersonate
+	// on the way to ImpersonateServiceAccount, in order: the bootstrap identity must be able to
+	// impersonate ImpersonateDelegates[0], which must be able to impersonate
+	// ImpersonateDelegates[1], and so on, with the last entry able to impersonate
+	// ImpersonateServiceAccount. It's ignored unless ImpersonateServiceAccount is set.
+	ImpersonateDelegates []string `json:"impersonateDelegates"`
+
+	// ImpersonateScopes, if set, are the OAuth scopes requested for the impersonated token in place
+	// of the scopes the calling endpoint would otherwise request. It's ignored unless
+	// ImpersonateServiceAccount is set.
+	ImpersonateScopes []string `json:"impersonateScopes"`
 }
 
 func (c *GCPIdentity) GetServiceAccountKey() []byte {
@@ -108,6 +197,12 @@ func (i *GCPIdentity) Validate(c *Config) error {
 	if i.EncodedServiceAccountKey != nil {
 		count += 1
 	}
+	if i.ExternalAccount != nil {
+		count += 1
+	}
+	if i.ApplicationDefault {
+		count += 1
+	}
 
 	if count == 0 {
 		return errors.New("identity: missing service account key")
@@ -116,6 +211,328 @@ func (i *GCPIdentity) Validate(c *Config) error {
 		return errors.New("identity: too many service account keys")
 	}
 
+	if i.SelfSignedJWT {
+		if i.ServiceAccountKey == nil && i.EncodedServiceAccountKey == nil {
+			return errors.New("identity: selfSignedJwt requires serviceAccountKey or encodedServiceAccountKey")
+		}
+		if i.ImpersonateServiceAccount != "" {
+			return errors.New("identity: selfSignedJwt cannot be combined with impersonateServiceAccount")
+		}
+	}
+
+	if len(i.ImpersonateDelegates) > 0 && i.ImpersonateServiceAccount == "" {
+		return errors.New("identity: impersonateDelegates requires impersonateServiceAccount")
+	}
+
+	if i.ExternalAccount != nil {
+		return i.ExternalAccount.Validate(c)
+	}
+
+	return nil
+}
+
+// ExternalAccountConfig mirrors the standard external-account credential JSON used for workload
+// identity federation (see https://cloud.google.com/iam/docs/how-to#using-workload-identity-federation).
+type ExternalAccountConfig struct {
+	// Audience is the full STS audience, e.g.
+	// "//iam.googleapis.com/projects/.../workloadIdentityPools/.../providers/...".
+	Audience string `json:"audience"`
+
+	// SubjectTokenType is the STS token type of the platform-native credential, e.g.
+	// "urn:ietf:params:oauth:token-type:jwt" or "urn:ietf:params:aws:token-type:aws4_request".
+	SubjectTokenType string `json:"subjectTokenType"`
+
+	// TokenURL is the STS token exchange endpoint. Defaults to https://sts.googleapis.com/v1/token.
+	TokenURL string `json:"tokenUrl"`
+
+	// ServiceAccountImpersonationURL, if set, is called with the STS-issued token to mint a further
+	// access token for the named service account.
+	ServiceAccountImpersonationURL string `json:"serviceAccountImpersonationUrl"`
+
+	// CredentialSource describes where the platform-native subject token comes from.
+	CredentialSource CredentialSource `json:"credentialSource"`
+}
+
+func (e *ExternalAccountConfig) Validate(c *Config) error {
+	if e.Audience == "" {
+		return errors.New("identity: externalAccount: missing audience")
+	}
+	if e.SubjectTokenType == "" {
+		return errors.New("identity: externalAccount: missing subjectTokenType")
+	}
+	return e.CredentialSource.Validate(c)
+}
+
+// CredentialSource is a oneof describing where the platform-native subject token is retrieved
+// from, to be exchanged at the STS endpoint.
+type CredentialSource struct {
+	File       *FileCredentialSource       `json:"file"`
+	URL        *URLCredentialSource        `json:"url"`
+	AWS        *AWSCredentialSource        `json:"aws"`
+	Executable *ExecutableCredentialSource `json:"executable"`
+}
+
+func (s *CredentialSource) Validate(c *Config) error {
+	types := 0
+	for _, v := range []Validatable{s.File, s.URL, s.AWS, s.Executable} {
+		if reflect.ValueOf(v).IsNil() {
+			continue
+		}
+		if err := v.Validate(c); err != nil {
+			return err
+		}
+		types++
+	}
+	if types == 0 {
+		return errors.New("identity: externalAccount: missing credential source")
+	}
+	if types > 1 {
+		return errors.New("identity: externalAccount: multiple credential sources")
+	}
+	return nil
+}
+
+// CredentialFormat describes how to extract the subject token from a file or HTTP response body.
+type CredentialFormat struct {
+	// Type is "text" or "json"; "text" is assumed when empty.
+	Type string `json:"type"`
+
+	// SubjectTokenFieldName names the field holding the token when Type is "json".
+	SubjectTokenFieldName string `json:"subjectTokenFieldName"`
+}
+
+// FileCredentialSource reads the subject token from a local file.
+type FileCredentialSource struct {
+	Path   string           `json:"path"`
+	Format CredentialFormat `json:"format"`
+}
+
+func (f *FileCredentialSource) Validate(c *Config) error {
+	if f.Path == "" {
+		return errors.New("identity: externalAccount: file: missing path")
+	}
+	return nil
+}
+
+// URLCredentialSource reads the subject token from the body of an HTTP GET.
+type URLCredentialSource struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Format  CredentialFormat  `json:"format"`
+}
+
+func (u *URLCredentialSource) Validate(c *Config) error {
+	if u.URL == "" {
+		return errors.New("identity: externalAccount: url: missing url")
+	}
+	return nil
+}
+
+// AWSCredentialSource derives the subject token from a signed AWS GetCallerIdentity request,
+// built from the EC2 instance metadata service's region, role, and signing-credentials endpoints.
+type AWSCredentialSource struct {
+	RegionURL                   string `json:"regionUrl"`
+	RegionalCredVerificationURL string `json:"regionalCredVerificationUrl"`
+	CredVerificationURL         string `json:"credVerificationUrl"`
+	IMDSv2SessionTokenURL       string `json:"imdsv2SessionTokenUrl"`
+}
+
+func (a *AWSCredentialSource) Validate(c *Config) error {
+	if a.RegionURL == "" {
+		return errors.New("identity: externalAccount: aws: missing regionUrl")
+	}
+	if a.RegionalCredVerificationURL == "" {
+		return errors.New("identity: externalAccount: aws: missing regionalCredVerificationUrl")
+	}
+	return nil
+}
+
+// ExecutableCredentialSource retrieves the subject token by running a local command that prints a
+// token-response JSON document to stdout (see
+// https://google.aip.dev/auth/4117#executable-sourced-credentials).
+type ExecutableCredentialSource struct {
+	Command string `json:"command"`
+
+	// TimeoutMillis bounds how long the command may run. Defaults to 30 seconds when zero.
+	TimeoutMillis int64 `json:"timeoutMillis"`
+}
+
+func (e *ExecutableCredentialSource) Validate(c *Config) error {
+	if e.Command == "" {
+		return errors.New("identity: externalAccount: executable: missing command")
+	}
+	if e.TimeoutMillis < 0 {
+		return errors.New("identity: externalAccount: executable: timeoutMillis must not be negative")
+	}
+	return nil
+}
+
+// AzureIdentity holds configuration for authenticating to Azure Active Directory as the
+// confidential client backing an AzureMarketplaceEndpoint.
+type AzureIdentity struct {
+	TenantId string `json:"tenantId"`
+	ClientId string `json:"clientId"`
+
+	// ClientSecret authenticates via the standard AAD client-credentials flow. Mutually exclusive
+	// with Certificate and UseManagedIdentity.
+	ClientSecret string `json:"clientSecret"`
+
+	// Certificate authenticates via a signed JWT client assertion instead of a shared secret.
+	// Mutually exclusive with ClientSecret, FederatedTokenFile, and UseManagedIdentity.
+	Certificate *AzureCertificateCredential `json:"certificate"`
+
+	// FederatedTokenFile authenticates via AAD workload identity federation: the token read from
+	// this path (e.g. a Kubernetes projected service account token) is presented directly as the
+	// client assertion, with no local signing. Mutually exclusive with ClientSecret, Certificate,
+	// and UseManagedIdentity.
+	FederatedTokenFile string `json:"federatedTokenFile"`
+
+	// UseManagedIdentity, when true, causes credentials to be obtained from the Azure Instance
+	// Metadata Service rather than AAD client-credentials. TenantId and ClientId are ignored. It's
+	// mutually exclusive with ClientSecret, Certificate, and FederatedTokenFile.
+	UseManagedIdentity bool `json:"useManagedIdentity"`
+}
+
+func (i *AzureIdentity) Validate(c *Config) error {
+	count := 0
+	if i.ClientSecret != "" {
+		count += 1
+	}
+	if i.Certificate != nil {
+		count += 1
+	}
+	if i.FederatedTokenFile != "" {
+		count += 1
+	}
+	if i.UseManagedIdentity {
+		count += 1
+	}
+
+	if count == 0 {
+		return errors.New("identity: azure: missing credential (clientSecret, certificate, federatedTokenFile, or useManagedIdentity)")
+	}
+	if count > 1 {
+		return errors.New("identity: azure: too many credentials")
+	}
+
+	if !i.UseManagedIdentity {
+		if i.TenantId == "" {
+			return errors.New("identity: azure: missing tenantId")
+		}
+		if i.ClientId == "" {
+			return errors.New("identity: azure: missing clientId")
+		}
+	}
+
+	if i.Certificate != nil {
+		return i.Certificate.Validate(c)
+	}
+
+	return nil
+}
+
+// AzureCertificateCredential authenticates to AAD by signing a JWT client assertion with a
+// private key, rather than presenting a shared client secret.
+type AzureCertificateCredential struct {
+	// PrivateKey is a PEM-encoded PKCS#1 or PKCS#8 RSA private key.
+	PrivateKey string `json:"privateKey"`
+
+	// Certificate is the PEM-encoded X.509 certificate corresponding to PrivateKey. Its thumbprint
+	// identifies the key to AAD.
+	Certificate string `json:"certificate"`
+}
+
+func (a *AzureCertificateCredential) Validate(c *Config) error {
+	if a.PrivateKey == "" {
+		return errors.New("identity: azure: certificate: missing privateKey")
+	}
+	if a.Certificate == "" {
+		return errors.New("identity: azure: certificate: missing certificate")
+	}
+	return nil
+}
+
+// AWSIdentity holds configuration for authenticating to AWS services, such as CloudWatch.
+type AWSIdentity struct {
+	// AccessKeyId and SecretAccessKey authenticate with a long-lived IAM user access key. Mutually
+	// exclusive with RoleArn.
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+
+	// RoleArn, when set, causes credentials to be obtained by assuming this IAM role via STS
+	// AssumeRole, using the ambient credentials of the environment ubbagent runs in (e.g. an EC2
+	// instance profile or EKS service account) as the caller identity. Mutually exclusive with
+	// AccessKeyId and SecretAccessKey.
+	RoleArn string `json:"roleArn"`
+
+	// Region is the AWS region API calls are signed and sent for. Required.
+	Region string `json:"region"`
+}
+
+func (i *AWSIdentity) Validate(c *Config) error {
+	count := 0
+	if i.AccessKeyId != "" || i.SecretAccessKey != "" {
+		count += 1
+	}
+	if i.RoleArn != "" {
+		count += 1
+	}
+
+	if count == 0 {
+		return errors.New("identity: aws: missing credential (accessKeyId/secretAccessKey or roleArn)")
+	}
+	if count > 1 {
+		return errors.New("identity: aws: too many credentials")
+	}
+
+	if i.AccessKeyId != "" && i.SecretAccessKey == "" {
+		return errors.New("identity: aws: missing secretAccessKey")
+	}
+	if i.SecretAccessKey != "" && i.AccessKeyId == "" {
+		return errors.New("identity: aws: missing accessKeyId")
+	}
+
+	if i.Region == "" {
+		return errors.New("identity: aws: missing region")
+	}
+
+	return nil
+}
+
+// ImpersonateIdentity makes an Identity resolve to an impersonated token minted from an existing
+// GCP identity, rather than holding a credential of its own. See Identities.ResolveGCP.
+type ImpersonateIdentity struct {
+	// BaseIdentity names the GCP identity whose credential is used to call IAM Credentials'
+	// generateAccessToken on TargetServiceAccount's behalf. It must refer to a direct GCP identity,
+	// not another Impersonate identity.
+	BaseIdentity string `json:"baseIdentity"`
+
+	// TargetServiceAccount is the email address of the service account this identity impersonates.
+	TargetServiceAccount string `json:"targetServiceAccount"`
+
+	// Delegates names an optional chain of intermediate service accounts to impersonate on the way
+	// to TargetServiceAccount, as with GCPIdentity.ImpersonateDelegates.
+	Delegates []string `json:"delegates"`
+
+	// Scopes, if set, are the OAuth scopes requested for the impersonated token in place of the
+	// scopes the calling endpoint would otherwise request.
+	Scopes []string `json:"scopes"`
+}
+
+func (i *ImpersonateIdentity) Validate(c *Config) error {
+	if i.BaseIdentity == "" {
+		return errors.New("identity: impersonate: missing baseIdentity")
+	}
+	if i.TargetServiceAccount == "" {
+		return errors.New("identity: impersonate: missing targetServiceAccount")
+	}
+	base := c.Identities.Get(i.BaseIdentity)
+	if base == nil {
+		return fmt.Errorf("identity: impersonate: nonexistent base identity: %v", i.BaseIdentity)
+	}
+	if base.GCP == nil {
+		return fmt.Errorf("identity: impersonate: base identity %v is not a GCP identity", i.BaseIdentity)
+	}
 	return nil
 }
 
@@ -0,0 +1,101 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// referencePattern matches a ${ENV:VAR}, ${FILE:/path}, or ${GCP_SECRET:resource} reference
+// anywhere within the raw config text, including inside a quoted YAML scalar.
+var referencePattern = regexp.MustCompile(`\$\{(ENV|FILE|GCP_SECRET):([^}]+)\}`)
+
+// interpolate replaces every reference matched by referencePattern in data with its resolved
+// value. It runs before YAML is unmarshalled, so a reference may appear anywhere a scalar string
+// value is allowed, e.g. encodedServiceAccountKey: ${FILE:/var/run/secrets/sa.json.b64}.
+//
+// In strict mode, a reference to a missing environment variable, unreadable file, or inaccessible
+// secret is an error. Otherwise it's silently replaced with an empty string.
+func interpolate(data []byte, strict bool) ([]byte, error) {
+	var firstErr error
+	result := referencePattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		if firstErr != nil {
+			return match
+		}
+		sub := referencePattern.FindSubmatch(match)
+		value, err := resolveReference(string(sub[1]), string(sub[2]))
+		if err != nil {
+			if strict {
+				firstErr = err
+			}
+			return nil
+		}
+		return []byte(value)
+	})
+	if firstErr != nil {
+		return nil, fmt.Errorf("config: %v", firstErr)
+	}
+	return result, nil
+}
+
+func resolveReference(kind, ref string) (string, error) {
+	switch kind {
+	case "ENV":
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable not set: %v", ref)
+		}
+		return value, nil
+	case "FILE":
+		data, err := ioutil.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("reading file %v: %v", ref, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case "GCP_SECRET":
+		value, err := resolveGCPSecret(ref)
+		if err != nil {
+			return "", fmt.Errorf("accessing secret %v: %v", ref, err)
+		}
+		return value, nil
+	default:
+		// Unreachable: referencePattern only captures these three prefixes.
+		return "", fmt.Errorf("unknown reference kind: %v", kind)
+	}
+}
+
+// resolveGCPSecret fetches the latest-accessible payload of the Secret Manager secret version
+// named by resource, e.g. "projects/p/secrets/s/versions/latest". It's a var so tests can stub out
+// the network call.
+var resolveGCPSecret = func(resource string) (string, error) {
+	ctx := context.Background()
+	svc, err := secretmanager.NewService(ctx, option.WithScopes(secretmanager.CloudPlatformScope))
+	if err != nil {
+		return "", err
+	}
+	resp, err := svc.Projects.Secrets.Versions.Access(resource).Do()
+	if err != nil {
+		return "", err
+	}
+	return string(resp.Payload.Data), nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/ghodss/yaml"
+)
+
+// TestParse_Interpolation is a round-trip test analogous to TestParse: it parses a config whose
+// serviceAccountKey is supplied via a ${FILE:...} reference and asserts the resulting
+// LiteralServiceAccountKey equals the one produced by unmarshalling jsonKeyText directly.
+func TestParse_Interpolation(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "sa.json")
+	if err := ioutil.WriteFile(keyPath, []byte(jsonKeyText), 0600); err != nil {
+		t.Fatalf("error writing key file: %v", err)
+	}
+
+	text := `
+identities:
+  - name: gcp
+    gcp:
+      serviceAccountKey: ${FILE:` + keyPath + `}
+`
+	cfg, err := config.Parse([]byte(text))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var want config.LiteralServiceAccountKey
+	if err := yaml.Unmarshal([]byte(jsonKeyText), &want); err != nil {
+		t.Fatalf("error unmarshalling jsonKeyText: %v", err)
+	}
+
+	got := cfg.Identities.Get("gcp").GCP.ServiceAccountKey
+	if got == nil {
+		t.Fatalf("ServiceAccountKey is nil")
+	}
+	if !reflect.DeepEqual(want, *got) {
+		t.Errorf("ServiceAccountKey mismatch:\nwant=%s\ngot=%s", want, *got)
+	}
+}
+
+func TestParse_EnvInterpolation(t *testing.T) {
+	os.Setenv("UBBAGENT_TEST_CONSUMER_ID", "project_number:123456")
+	defer os.Unsetenv("UBBAGENT_TEST_CONSUMER_ID")
+
+	text := `
+identities:
+  - name: gcp
+    gcp:
+      applicationDefault: true
+metrics:
+- name: requests
+  type: int
+endpoints:
+- name: servicecontrol
+  servicecontrol:
+    identity: gcp
+    serviceName: test-service.bogus.com
+    consumerId: ${ENV:UBBAGENT_TEST_CONSUMER_ID}
+`
+	cfg, err := config.Parse([]byte(text))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := "project_number:123456", cfg.Endpoints[0].ServiceControl.ConsumerId; want != got {
+		t.Errorf("ConsumerId: want=%v, got=%v", want, got)
+	}
+}
+
+func TestParse_MissingEnvNonStrict(t *testing.T) {
+	text := `
+identities:
+- name: gcp
+  gcp:
+    applicationDefault: true
+metrics:
+- name: requests
+  type: int
+endpoints:
+- name: servicecontrol
+  servicecontrol:
+    identity: gcp
+    serviceName: test-service.bogus.com
+    consumerId: ${ENV:UBBAGENT_TEST_UNSET_VAR}
+`
+	cfg, err := config.Parse([]byte(text))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := "", cfg.Endpoints[0].ServiceControl.ConsumerId; want != got {
+		t.Errorf("ConsumerId: want=%q, got=%q", want, got)
+	}
+}
+
+func TestParseStrict_MissingEnv(t *testing.T) {
+	text := `
+identities:
+- name: gcp
+  gcp:
+    applicationDefault: true
+metrics:
+- name: requests
+  type: int
+endpoints:
+- name: servicecontrol
+  servicecontrol:
+    identity: gcp
+    serviceName: test-service.bogus.com
+    consumerId: ${ENV:UBBAGENT_TEST_UNSET_VAR}
+`
+	if _, err := config.ParseStrict([]byte(text)); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
+
+func TestParseStrict_MissingFile(t *testing.T) {
+	text := `
+identities:
+- name: gcp
+  gcp:
+    serviceAccountKey: ${FILE:/nonexistent/path/to/key.json}
+`
+	if _, err := config.ParseStrict([]byte(text)); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
@@ -15,6 +15,9 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -30,15 +33,53 @@ type Metric struct {
 	metrics.Definition `json:",inline"`
 	Endpoints          []MetricEndpoint `json:"endpoints"`
 
+	// Filters names filters, defined in Config.Filters, to apply, in order, to every report for
+	// this metric before it's dispatched to its endpoints.
+	Filters []string `json:"filters"`
+
 	// oneof - buffering configuration
 	Aggregation *Aggregation `json:"aggregation"`
 	Passthrough *Passthrough `json:"passthrough"`
+
+	// Mode controls how this metric's aggregated reports are dispatched, letting an integrator
+	// validate a new metric or endpoint change against production traffic before fully enforcing
+	// it. It's optional; the empty string is equivalent to ModeEnforce. One of:
+	//   - ModeEnforce: reports are dispatched to every configured endpoint normally (the default).
+	//   - ModeDryRun: reports are dispatched only to Disk endpoints; other endpoints are skipped
+	//     entirely, so nothing leaves the agent while the pipeline is validated end-to-end.
+	//   - ModeWarn: reports are dispatched to every endpoint as usual, but a failed send is
+	//     recorded as a stats.Snapshot warning instead of counting toward CurrentFailureCount.
+	Mode string `json:"mode"`
+
+	// Dispatch controls how this metric's reports are fanned out across its configured endpoints.
+	// It's optional; a nil Dispatch is equivalent to DispatchBroadcast.
+	Dispatch *DispatchPolicy `json:"dispatch"`
+}
+
+// Enforcement modes a Metric's Mode may specify.
+const (
+	ModeEnforce = "enforce"
+	ModeDryRun  = "dryrun"
+	ModeWarn    = "warn"
+)
+
+// EffectiveMode returns m's Mode, defaulting to ModeEnforce when unset.
+func (m *Metric) EffectiveMode() string {
+	if m.Mode == "" {
+		return ModeEnforce
+	}
+	return m.Mode
 }
 
 func (m *Metric) Validate(c *Config) error {
 	if err := m.Definition.Validate(); err != nil {
 		return err
 	}
+	switch m.Mode {
+	case "", ModeEnforce, ModeDryRun, ModeWarn:
+	default:
+		return fmt.Errorf("metric %v: invalid mode: %v", m.Name, m.Mode)
+	}
 	types := 0
 	for _, v := range []metricValidator{m.Aggregation, m.Passthrough} {
 		if reflect.ValueOf(v).IsNil() {
@@ -62,6 +103,10 @@ func (m *Metric) Validate(c *Config) error {
 		return fmt.Errorf("metric %v: no endpoints defined", m.Name)
 	}
 
+	if err := validateFilterNames(c, m.Filters); err != nil {
+		return fmt.Errorf("metric %v: %v", m.Name, err)
+	}
+
 	usedEndpoints := make(map[string]bool)
 	for _, e := range m.Endpoints {
 		if e.Name == "" {
@@ -76,9 +121,93 @@ func (m *Metric) Validate(c *Config) error {
 		usedEndpoints[e.Name] = true
 	}
 
+	if m.Dispatch != nil {
+		if err := m.Dispatch.Validate(m); err != nil {
+			return fmt.Errorf("metric %v: %v", m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Dispatch modes a Metric's DispatchPolicy.Mode may specify.
+const (
+	// DispatchBroadcast sends every report to every one of the metric's endpoints in parallel. This
+	// is the default.
+	DispatchBroadcast = "broadcast"
+	// DispatchFailover tries the metric's endpoints in the order listed, moving on to the next only
+	// if the previous one returns an error.
+	DispatchFailover = "failover"
+	// DispatchLoadBalance sends each report to exactly one of the metric's endpoints, chosen by
+	// weighted round-robin. Useful when the same backend is reachable via multiple equivalent
+	// endpoints, e.g. several regional instances of the same billing API.
+	DispatchLoadBalance = "loadBalance"
+	// DispatchShard sends each report to exactly one of the metric's endpoints, chosen by hashing
+	// the report's Id. Unlike DispatchLoadBalance, the same report always maps to the same
+	// endpoint, which matters for endpoints that dedup by report Id themselves.
+	DispatchShard = "shard"
+)
+
+// DispatchPolicy configures how a Metric's reports are fanned out across its endpoints.
+type DispatchPolicy struct {
+	// Mode selects the fan-out strategy. One of DispatchBroadcast (the default), DispatchFailover,
+	// DispatchLoadBalance, or DispatchShard.
+	Mode string `json:"mode"`
+
+	// Weights maps an endpoint name, from the metric's Endpoints, to its relative weight under
+	// DispatchLoadBalance. An endpoint present in Endpoints but absent from Weights gets weight 1.
+	// Ignored by other modes.
+	Weights map[string]int `json:"weights"`
+}
+
+func (d *DispatchPolicy) Validate(m *Metric) error {
+	switch d.Mode {
+	case "", DispatchBroadcast, DispatchFailover, DispatchLoadBalance, DispatchShard:
+	default:
+		return fmt.Errorf("dispatch: invalid mode: %v", d.Mode)
+	}
+	for name, weight := range d.Weights {
+		if d.Mode != DispatchLoadBalance {
+			return fmt.Errorf("dispatch: weights only apply to mode %v", DispatchLoadBalance)
+		}
+		if weight <= 0 {
+			return fmt.Errorf("dispatch: endpoint %v: weight must be positive: %v", name, weight)
+		}
+		found := false
+		for _, e := range m.Endpoints {
+			if e.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("dispatch: weight given for endpoint not in metric: %v", name)
+		}
+	}
 	return nil
 }
 
+// EffectiveMode returns d's Mode, defaulting to DispatchBroadcast when unset. It's safe to call on
+// a nil DispatchPolicy.
+func (d *DispatchPolicy) EffectiveMode() string {
+	if d == nil || d.Mode == "" {
+		return DispatchBroadcast
+	}
+	return d.Mode
+}
+
+// Hash returns a stable content hash of m's configuration subtree, excluding Name.
+func (m *Metric) Hash() (string, error) {
+	unnamed := *m
+	unnamed.Name = ""
+	data, err := json.Marshal(unnamed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 type Metrics []Metric
 
 // GetMetricDefinition returns the metrics.Definition with the given name, or nil if it does not
@@ -92,6 +221,16 @@ func (m Metrics) GetMetricDefinition(name string) *metrics.Definition {
 	return nil
 }
 
+// Get returns the Metric with the given name, or nil if it does not exist.
+func (m Metrics) Get(name string) *Metric {
+	for i := range m {
+		if m[i].Name == name {
+			return &m[i]
+		}
+	}
+	return nil
+}
+
 // Validate checks validity of metric configuration. Specifically, it must not contain duplicate
 // metric definitions, and metric definitions must specify valid type names.
 func (m Metrics) Validate(c *Config) error {
@@ -115,12 +254,87 @@ type MetricEndpoint struct {
 type Aggregation struct {
 	// The number of seconds that metrics should be aggregated prior to forwarding
 	BufferSeconds int64 `json:"bufferSeconds"`
+
+	// MaxBucketEntries bounds the number of distinct label combinations the Aggregator will buffer
+	// for this metric before forcing an early flush of the current bucket. It's optional; a value
+	// of 0 means unbounded.
+	MaxBucketEntries int64 `json:"maxBucketEntries"`
+
+	// MaxPersistIntervalSeconds bounds how long the Aggregator may defer persisting a bucket after
+	// merging a report into it. Rather than calling its Persistence backend on every AddReport, it
+	// marks the bucket dirty and coalesces writes to at most once per this many seconds. It's
+	// optional; a value of 0 (the default) persists synchronously on every AddReport, exactly as
+	// before this field existed. A bucket flush (early, scheduled, or on Release) always persists
+	// immediately regardless of this setting, so a dirty bucket is never left unpersisted past it.
+	MaxPersistIntervalSeconds int64 `json:"maxPersistIntervalSeconds"`
+
+	// LatenessSeconds tolerates reports whose EndTime precedes the current bucket's creation time,
+	// merging them in rather than rejecting them, as long as they're no older than this many
+	// seconds. It's optional; a value of 0 means no out-of-order reports are tolerated.
+	LatenessSeconds int64 `json:"latenessSeconds"`
+
+	// OnTimeConflict controls what the Aggregator does with a report whose EndTime precedes the
+	// current bucket's creation time by more than LatenessSeconds tolerates. It's optional; the
+	// empty string is equivalent to OnTimeConflictReject. One of:
+	//   - OnTimeConflictReject: the report is rejected with an error (the default).
+	//   - OnTimeConflictMerge: the report is tolerated and merged into the current bucket as if it
+	//     had arrived on time.
+	//   - OnTimeConflictSplit: the report is tolerated, but always starts a new aggregated entry
+	//     rather than merging into one that might already cover the same labels.
+	//   - OnTimeConflictDeadLetter: the report is persisted, via the Aggregator's own
+	//     persistence.Persistence, for later recovery instead of being merged or rejected.
+	OnTimeConflict string `json:"onTimeConflict"`
+
+	// TenantLabel, if set, names a report label whose value multiplexes this metric's buffering
+	// across independent per-tenant buckets, each aggregated and flushed on its own: two reports
+	// are only ever combined if they resolve to the same tenant, in addition to the usual name and
+	// label match. It's optional; the empty string (the default) disables multiplexing, so every
+	// report shares a single implicit tenant, exactly as before TenantLabel existed. A report whose
+	// TenantLabel value is a reserved tenant ID (see IsReservedTenantId) is rejected.
+	TenantLabel string `json:"tenantLabel"`
+}
+
+const (
+	OnTimeConflictReject     = "reject"
+	OnTimeConflictMerge      = "merge"
+	OnTimeConflictSplit      = "split"
+	OnTimeConflictDeadLetter = "dead-letter"
+)
+
+// SystemTenantId is a reserved tenant ID set aside for the agent's own internal self-metrics. It
+// must never be supplied, via a metric's configured TenantLabel, by an external reporting client.
+const SystemTenantId = "__ubbagent_system"
+
+// reservedTenantIds lists every tenant ID an Aggregator configured with TenantLabel refuses to
+// accept from an incoming report; see IsReservedTenantId.
+var reservedTenantIds = map[string]bool{
+	SystemTenantId: true,
+}
+
+// IsReservedTenantId reports whether id is reserved for the agent's own internal use and must not
+// be supplied by a reporting client.
+func IsReservedTenantId(id string) bool {
+	return reservedTenantIds[id]
 }
 
 func (rm *Aggregation) Validate(m *Metric, c *Config) error {
 	if rm.BufferSeconds <= 0 {
 		return fmt.Errorf("bufferSeconds must be > 0")
 	}
+	if rm.MaxBucketEntries < 0 {
+		return fmt.Errorf("maxBucketEntries must not be negative")
+	}
+	if rm.MaxPersistIntervalSeconds < 0 {
+		return fmt.Errorf("maxPersistIntervalSeconds must not be negative")
+	}
+	if rm.LatenessSeconds < 0 {
+		return fmt.Errorf("latenessSeconds must not be negative")
+	}
+	switch rm.OnTimeConflict {
+	case "", OnTimeConflictReject, OnTimeConflictMerge, OnTimeConflictSplit, OnTimeConflictDeadLetter:
+	default:
+		return fmt.Errorf("onTimeConflict: unknown value %q", rm.OnTimeConflict)
+	}
 	return nil
 }
 
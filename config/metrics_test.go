@@ -66,6 +66,18 @@ func TestMetrics_Validate(t *testing.T) {
 				Endpoints:   goodEndpoints,
 				Aggregation: goodAggregation,
 			},
+			{
+				Definition: metrics.Definition{
+					Name: "distribution-metric",
+					Type: metrics.DistributionType,
+					Distribution: &metrics.DistributionOptions{
+						Base:       2,
+						NumBuckets: 20,
+					},
+				},
+				Endpoints:   goodEndpoints,
+				Aggregation: goodAggregation,
+			},
 		}
 
 		err := validConfig.Validate(&conf)
@@ -74,6 +86,21 @@ func TestMetrics_Validate(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid: distribution metric missing distribution options", func(t *testing.T) {
+		invalidType := config.Metrics{
+			{
+				Definition:  metrics.Definition{Name: "distribution-metric", Type: metrics.DistributionType},
+				Endpoints:   goodEndpoints,
+				Aggregation: goodAggregation,
+			},
+		}
+
+		err := invalidType.Validate(&conf)
+		if err == nil || err.Error() != "metric distribution-metric: type distribution requires distribution options" {
+			t.Fatalf("Expected error, got: %s", err)
+		}
+	})
+
 	t.Run("invalid: duplicate metric", func(t *testing.T) {
 		duplicateName := config.Metrics{
 			{
@@ -109,6 +136,38 @@ func TestMetrics_Validate(t *testing.T) {
 		}
 	})
 
+	t.Run("mode: valid values", func(t *testing.T) {
+		for _, mode := range []string{"", config.ModeEnforce, config.ModeDryRun, config.ModeWarn} {
+			validConfig := config.Metrics{
+				{
+					Definition:  metrics.Definition{Name: "int-metric", Type: "int"},
+					Endpoints:   goodEndpoints,
+					Aggregation: goodAggregation,
+					Mode:        mode,
+				},
+			}
+			if err := validConfig.Validate(&conf); err != nil {
+				t.Fatalf("mode %q: expected no error, got: %v", mode, err)
+			}
+		}
+	})
+
+	t.Run("invalid: invalid mode", func(t *testing.T) {
+		invalidType := config.Metrics{
+			{
+				Definition:  metrics.Definition{Name: "int-metric", Type: "int"},
+				Endpoints:   goodEndpoints,
+				Aggregation: goodAggregation,
+				Mode:        "bogus",
+			},
+		}
+
+		err := invalidType.Validate(&conf)
+		if err == nil || err.Error() != "metric int-metric: invalid mode: bogus" {
+			t.Fatalf("Expected error, got: %s", err)
+		}
+	})
+
 	t.Run("invalid: missing buffering configuration", func(t *testing.T) {
 		invalidType := config.Metrics{
 			{
@@ -221,6 +280,37 @@ func TestMetrics_Validate(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("aggregation: latenessSeconds must not be negative", func(t *testing.T) {
+		cases := []struct {
+			val int64
+			msg string
+		}{
+			{-1, "metric int-metric: latenessSeconds must not be negative"},
+			{0, ""},
+			{30, ""},
+		}
+		for _, c := range cases {
+			invalidType := config.Metrics{
+				{
+					Definition: metrics.Definition{Name: "int-metric", Type: "int"},
+					Endpoints:  goodEndpoints,
+					Aggregation: &config.Aggregation{
+						BufferSeconds:   10,
+						LatenessSeconds: c.val,
+					},
+				},
+			}
+
+			err := invalidType.Validate(&conf)
+			if c.msg == "" && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if c.msg != "" && (err == nil || err.Error() != c.msg) {
+				t.Fatalf("Expected error, got: %v", err)
+			}
+		}
+	})
 }
 
 func TestMetrics_GetMetricDefinition(t *testing.T) {
@@ -244,3 +334,43 @@ func TestMetrics_GetMetricDefinition(t *testing.T) {
 		t.Fatalf("Expected: nil, got: %s", actual)
 	}
 }
+
+func TestMetrics_Get(t *testing.T) {
+	m := config.Metrics{
+		{Definition: metrics.Definition{Name: "requests", Type: "int"}, Passthrough: &config.Passthrough{}},
+		{Definition: metrics.Definition{Name: "latency", Type: "double"}, Passthrough: &config.Passthrough{}},
+	}
+
+	if got := m.Get("latency"); got == nil || got.Name != "latency" {
+		t.Fatalf("Get(%q): got %v", "latency", got)
+	}
+	if got := m.Get("bogus"); got != nil {
+		t.Fatalf("Get(%q): expected nil, got %v", "bogus", got)
+	}
+}
+
+func TestMetric_Hash(t *testing.T) {
+	a := config.Metric{Definition: metrics.Definition{Name: "a", Type: "int"}, Passthrough: &config.Passthrough{}}
+	b := config.Metric{Definition: metrics.Definition{Name: "b", Type: "int"}, Passthrough: &config.Passthrough{}}
+	c := config.Metric{Definition: metrics.Definition{Name: "a", Type: "double"}, Passthrough: &config.Passthrough{}}
+
+	ah, err := a.Hash()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bh, err := b.Hash()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ch, err := c.Hash()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if ah != bh {
+		t.Errorf("expected identical Hash for metrics differing only by Name, got %v != %v", ah, bh)
+	}
+	if ah == ch {
+		t.Errorf("expected different Hash for metrics with different Type, got %v == %v", ah, ch)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// PersistenceMemory keeps state in memory only; it's lost on restart.
+	PersistenceMemory = "memory"
+
+	// PersistenceDisk stores state as one json file per name under Directory, rewritten whole on
+	// every change.
+	PersistenceDisk = "disk"
+
+	// PersistenceKV stores state as entries in a single append-only log file under Directory,
+	// giving per-key crash consistency without a per-name file rewrite. It suits workloads that
+	// touch many names cheaply, such as a high-cardinality aggregator.
+	PersistenceKV = "kv"
+
+	// PersistenceBolt stores state as keys in a single embedded BoltDB file at Path, avoiding the
+	// per-name file churn "disk" has under high report rates.
+	PersistenceBolt = "bolt"
+
+	// PersistenceRedis stores state as keys in a Redis server at Addr, shared by every ubbagent
+	// instance pointed at it. It suits horizontally scaled deployments that aggregate behind a
+	// load balancer, where any instance may need to pick up state another instance started.
+	PersistenceRedis = "redis"
+)
+
+// Persistence configures the backend an agent uses to durably store pipeline state, such as
+// aggregation buffers and retry queues. It's optional; NewAgent falls back to its own default
+// (memory if no state directory is given, otherwise disk) when unset.
+type Persistence struct {
+	// Type selects the backend: "memory", "disk", "kv", "bolt", or "redis". Required.
+	Type string `json:"type"`
+
+	// Directory is the filesystem directory the "disk" and "kv" backends store state under. It's
+	// created if it doesn't already exist. Required for those types; ignored otherwise.
+	Directory string `json:"directory"`
+
+	// Path is the BoltDB file the "bolt" backend stores state in. It's created if it doesn't
+	// already exist. Required for "bolt"; ignored otherwise.
+	Path string `json:"path"`
+
+	// Addr is the "host:port" of the Redis server the "redis" backend stores state in. Required
+	// for "redis"; ignored otherwise.
+	Addr string `json:"addr"`
+
+	// AutoCreate, when true, lets the "disk", "kv", and "bolt" backends create their backing
+	// directory or file if it doesn't already exist. When false, NewAgent fails at startup if it's
+	// missing rather than silently creating it. Ignored for "redis", which never creates anything
+	// locally.
+	AutoCreate bool `json:"autoCreate"`
+}
+
+func (p *Persistence) Validate(c *Config) error {
+	switch p.Type {
+	case "":
+		return errors.New("persistence: missing type")
+	case PersistenceMemory:
+	case PersistenceDisk, PersistenceKV:
+		if p.Directory == "" {
+			return fmt.Errorf("persistence: %v: missing directory", p.Type)
+		}
+	case PersistenceBolt:
+		if p.Path == "" {
+			return errors.New("persistence: bolt: missing path")
+		}
+	case PersistenceRedis:
+		if p.Addr == "" {
+			return errors.New("persistence: redis: missing addr")
+		}
+	default:
+		return fmt.Errorf("persistence: unsupported type: %v", p.Type)
+	}
+	return nil
+}
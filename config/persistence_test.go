@@ -0,0 +1,72 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+func TestPersistence_Validate(t *testing.T) {
+	t.Run("valid memory", func(t *testing.T) {
+		p := config.Persistence{Type: config.PersistenceMemory}
+		if err := p.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("valid disk", func(t *testing.T) {
+		p := config.Persistence{Type: config.PersistenceDisk, Directory: "/tmp/foo"}
+		if err := p.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("valid kv", func(t *testing.T) {
+		p := config.Persistence{Type: config.PersistenceKV, Directory: "/tmp/foo"}
+		if err := p.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("invalid: missing type", func(t *testing.T) {
+		p := config.Persistence{}
+		if err := p.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: unsupported type", func(t *testing.T) {
+		p := config.Persistence{Type: "redis"}
+		if err := p.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: disk missing directory", func(t *testing.T) {
+		p := config.Persistence{Type: config.PersistenceDisk}
+		if err := p.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: kv missing directory", func(t *testing.T) {
+		p := config.Persistence{Type: config.PersistenceKV}
+		if err := p.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+}
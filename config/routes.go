@@ -0,0 +1,124 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// MatchType selects how Route.MetricPattern is compared against a report's metric name.
+type MatchType string
+
+const (
+	// MatchGlob matches MetricPattern as a glob, per path.Match (e.g. "http.*"). This is the default
+	// when MatchType is left empty, preserving the behavior Route had before MatchType existed.
+	MatchGlob MatchType = "glob"
+
+	// MatchExact matches MetricPattern only against an identical metric name.
+	MatchExact MatchType = "exact"
+
+	// MatchRegex matches MetricPattern as a regular expression, per regexp.MatchString.
+	MatchRegex MatchType = "regex"
+)
+
+// Route matches incoming reports against a metric-name pattern and a set of required labels,
+// sending matches to one or more target Metric pipelines. Routes are evaluated in declared order.
+// A matching Route delivers the report to every Metric named in Metric and Metrics, and - unless
+// Stop is set - evaluation continues to the next Route, letting one report fan out across several
+// Routes (for example, both an aggregated "requests" metric and a raw disk-archive passthrough).
+//
+// If Config.Routes is empty, reports are routed directly by exact metric name, as if a single
+// Route with no pattern or labels existed per Metric.
+type Route struct {
+	// MetricPattern is matched, according to MatchType, against a report's metric name. Empty
+	// matches any metric name.
+	MetricPattern string `json:"metricPattern"`
+
+	// MatchType selects how MetricPattern is interpreted. It defaults to MatchGlob.
+	MatchType MatchType `json:"matchType"`
+
+	// Labels, if non-empty, requires every entry here to equal the report's corresponding label.
+	Labels map[string]string `json:"labels"`
+
+	// Metric is the name of a single Metric, in Config.Metrics, that matching reports are sent to.
+	// It may be used alongside Metrics; at least one of the two must name a Metric.
+	Metric string `json:"metric"`
+
+	// Metrics lists additional Metric names, in Config.Metrics, that matching reports fan out to.
+	Metrics []string `json:"metrics"`
+
+	// Stop, if true, halts route evaluation after this Route matches, so later Routes aren't also
+	// considered for the same report. It has no effect on a Route that doesn't match.
+	Stop bool `json:"stop"`
+}
+
+// TargetMetrics returns the de-duplicated union of r.Metric and r.Metrics: every Metric name this
+// Route fans out to.
+func (r *Route) TargetMetrics() []string {
+	var metrics []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			metrics = append(metrics, name)
+		}
+	}
+	add(r.Metric)
+	for _, m := range r.Metrics {
+		add(m)
+	}
+	return metrics
+}
+
+func (r *Route) Validate(c *Config) error {
+	targets := r.TargetMetrics()
+	if len(targets) == 0 {
+		return errors.New("route: missing metric")
+	}
+	for _, name := range targets {
+		if c.Metrics.GetMetricDefinition(name) == nil {
+			return fmt.Errorf("route: unknown metric: %v", name)
+		}
+	}
+	switch r.MatchType {
+	case "", MatchGlob:
+		if _, err := path.Match(r.MetricPattern, ""); err != nil {
+			return fmt.Errorf("route: invalid metricPattern: %v", err)
+		}
+	case MatchExact:
+		// Any string is a valid exact match.
+	case MatchRegex:
+		if _, err := regexp.Compile(r.MetricPattern); err != nil {
+			return fmt.Errorf("route: invalid metricPattern: %v", err)
+		}
+	default:
+		return fmt.Errorf("route: invalid matchType: %v", r.MatchType)
+	}
+	return nil
+}
+
+type Routes []Route
+
+func (m Routes) Validate(c *Config) error {
+	for _, r := range m {
+		if err := r.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
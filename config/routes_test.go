@@ -0,0 +1,101 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+func TestRoutes_Validate(t *testing.T) {
+	conf := config.Config{
+		Metrics: config.Metrics{
+			{
+				Definition:  metrics.Definition{Name: "requests", Type: "int"},
+				Endpoints:   []config.MetricEndpoint{{Name: "disk"}},
+				Aggregation: &config.Aggregation{BufferSeconds: 10},
+			},
+		},
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		routes := config.Routes{
+			{MetricPattern: "req*", Labels: map[string]string{"tenant": "a"}, Metric: "requests"},
+			{Metric: "requests"},
+		}
+		if err := routes.Validate(&conf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid: missing metric", func(t *testing.T) {
+		routes := config.Routes{{MetricPattern: "req*"}}
+		if err := routes.Validate(&conf); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("invalid: unknown metric", func(t *testing.T) {
+		routes := config.Routes{{Metric: "nonexistent"}}
+		if err := routes.Validate(&conf); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("invalid: bad glob", func(t *testing.T) {
+		routes := config.Routes{{MetricPattern: "[", Metric: "requests"}}
+		if err := routes.Validate(&conf); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("valid: fan-out via Metrics", func(t *testing.T) {
+		routes := config.Routes{
+			{MetricPattern: "req*", Metric: "requests", Metrics: []string{"requests"}, Stop: true},
+		}
+		if err := routes.Validate(&conf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("valid: regex matchType", func(t *testing.T) {
+		routes := config.Routes{
+			{MetricPattern: `^req`, MatchType: config.MatchRegex, Metric: "requests"},
+		}
+		if err := routes.Validate(&conf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid: bad regex", func(t *testing.T) {
+		routes := config.Routes{
+			{MetricPattern: "(", MatchType: config.MatchRegex, Metric: "requests"},
+		}
+		if err := routes.Validate(&conf); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("invalid: unknown matchType", func(t *testing.T) {
+		routes := config.Routes{
+			{MetricPattern: "req*", MatchType: "bogus", Metric: "requests"},
+		}
+		if err := routes.Validate(&conf); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+}
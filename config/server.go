@@ -0,0 +1,76 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "errors"
+
+// Server configures agent-internal HTTP endpoints. It's optional; an empty Server disables all of
+// them.
+//
+// A Kubernetes ServiceMonitor (prometheus-operator) can scrape the metrics server with:
+//
+//	apiVersion: monitoring.coreos.com/v1
+//	kind: ServiceMonitor
+//	metadata:
+//	  name: ubbagent
+//	spec:
+//	  selector:
+//	    matchLabels:
+//	      app: ubbagent
+//	  endpoints:
+//	  - port: metrics
+//	    path: /metrics
+//	    interval: 30s
+type Server struct {
+	// MetricsAddress, if non-empty, is the "host:port" (or ":port") address a Prometheus-format
+	// /metrics endpoint listens on, exposing agent-internal telemetry such as send success/failure
+	// counts and persistence queue depth. Empty disables the metrics server.
+	MetricsAddress string `json:"metricsAddress"`
+
+	// BindHost is the host portion of the address the agent's /report and /status HTTP interface
+	// listens on, combined with the standalone agent's --local-port flag. Empty preserves the
+	// interface's traditional "localhost" default, so it only accepts requests from the local
+	// host unless an operator opts into a wider bind address - e.g. "0.0.0.0" to be reachable from
+	// other pods as a sidecar, or a pod IP to run as a node-level daemon.
+	BindHost string `json:"bindHost"`
+
+	// TLS, if set, serves the /report and /status interface over TLS - optionally mutual TLS, via
+	// TLS.ClientCAFile - instead of plaintext.
+	TLS *TLSConfig `json:"tls"`
+
+	// Auth, if set, requires every /report request (and, unless Auth.AllowAnonymousStatus is set,
+	// every /status request) to authenticate. It's independent of TLS: Auth.Mode "mtls" relies on
+	// TLS.ClientCAFile to verify the client certificate, but TLS can also be configured without
+	// Auth, or Auth configured with TLS left unset (e.g. "token" mode behind a TLS-terminating
+	// proxy).
+	Auth *HttpAuth `json:"auth"`
+}
+
+func (s *Server) Validate(c *Config) error {
+	if s.MetricsAddress == "" && s.BindHost == "" && s.TLS == nil && s.Auth == nil {
+		return errors.New("server: at least one of metricsAddress, bindHost, tls, or auth must be set")
+	}
+	if s.TLS != nil {
+		if err := s.TLS.Validate(c); err != nil {
+			return err
+		}
+	}
+	if s.Auth != nil {
+		if err := s.Auth.Validate(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+func TestServer_Validate(t *testing.T) {
+	t.Run("rejects an empty Server", func(t *testing.T) {
+		s := config.Server{}
+		if err := s.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with nothing configured")
+		}
+	})
+
+	t.Run("accepts metricsAddress alone", func(t *testing.T) {
+		s := config.Server{MetricsAddress: ":9090"}
+		if err := s.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("accepts bindHost alone", func(t *testing.T) {
+		s := config.Server{BindHost: "0.0.0.0"}
+		if err := s.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("propagates a TLS validation error", func(t *testing.T) {
+		s := config.Server{TLS: &config.TLSConfig{KeyFile: "key.pem"}}
+		if err := s.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error from the invalid TLSConfig")
+		}
+	})
+
+	t.Run("propagates an Auth validation error", func(t *testing.T) {
+		s := config.Server{Auth: &config.HttpAuth{Mode: "bogus"}}
+		if err := s.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error from the invalid HttpAuth")
+		}
+	})
+}
@@ -15,10 +15,12 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 
+	"github.com/GoogleCloudPlatform/ubbagent/cron"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 )
 
@@ -26,7 +28,13 @@ type Source struct {
 	Name string `json:"name"`
 
 	// oneof
-	Heartbeat *Heartbeat `json:"heartbeat"`
+	Heartbeat        *Heartbeat         `json:"heartbeat"`
+	PrometheusScrape *PrometheusScrape  `json:"prometheusScrape"`
+	Scheduled        *Scheduled         `json:"scheduled"`
+	CloudEvents      *CloudEventsSource `json:"cloudEvents"`
+	Statsd           *StatsdSource      `json:"statsd"`
+	PubSub           *PubSubSource      `json:"pubSub"`
+	Custom           *CustomSource      `json:"custom"`
 }
 
 func (s *Source) Validate(c *Config) error {
@@ -34,7 +42,7 @@ func (s *Source) Validate(c *Config) error {
 		return errors.New("missing source name")
 	}
 	types := 0
-	for _, v := range []Validatable{s.Heartbeat} {
+	for _, v := range []Validatable{s.Heartbeat, s.PrometheusScrape, s.Scheduled, s.CloudEvents, s.Statsd, s.PubSub, s.Custom} {
 		if reflect.ValueOf(v).IsNil() {
 			continue
 		}
@@ -94,3 +102,361 @@ func (h *Heartbeat) Validate(c *Config) error {
 	}
 	return nil
 }
+
+// Scheduled emits a report on a cron-style schedule, rather than at a fixed interval. This allows
+// usage-metering windows to align to wall-clock boundaries (e.g. hourly at :00, daily at 00:00
+// UTC) instead of drifting from whenever the agent happened to start.
+type Scheduled struct {
+	Metric string `json:"metric"`
+
+	// Schedule is a cron expression: standard 5-field syntax (minute hour day-of-month month
+	// day-of-week), or one of the "@hourly", "@daily", "@monthly" shorthand aliases.
+	Schedule string              `json:"schedule"`
+	Value    metrics.MetricValue `json:"value"`
+	Labels   map[string]string   `json:"labels"`
+}
+
+func (s *Scheduled) Validate(c *Config) error {
+	if s.Metric == "" {
+		return fmt.Errorf("scheduled: metric must be specified")
+	}
+	d := c.Metrics.GetMetricDefinition(s.Metric)
+	if d == nil {
+		return fmt.Errorf("scheduled: unknown metric: %v", s.Metric)
+	}
+	if err := s.Value.Validate(*d); err != nil {
+		return err
+	}
+	if _, err := cron.Parse(s.Schedule); err != nil {
+		return fmt.Errorf("scheduled: %v", err)
+	}
+	return nil
+}
+
+// PrometheusScrape periodically scrapes a Prometheus text-exposition-format (or OpenMetrics)
+// endpoint and maps selected series to ubbagent MetricReports.
+type PrometheusScrape struct {
+	URL             string                   `json:"url"`
+	IntervalSeconds int64                    `json:"intervalSeconds"`
+	Metrics         []PrometheusScrapeMetric `json:"metrics"`
+
+	// TimeoutSeconds bounds how long a single scrape request may take, independent of
+	// IntervalSeconds. Defaults to 10 seconds when zero.
+	TimeoutSeconds int64 `json:"timeoutSeconds"`
+
+	// InsecureSkipVerify disables TLS certificate verification when URL is an https address.
+	// Defaults to false (certificates are verified).
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+
+	// BearerToken, if set, is sent as an "Authorization: Bearer <token>" header on every scrape
+	// request. Mutually exclusive with BasicAuth.
+	BearerToken string `json:"bearerToken"`
+
+	// BasicAuth, if set, is sent as an HTTP Basic Authorization header on every scrape request.
+	// Mutually exclusive with BearerToken.
+	BasicAuth *PrometheusBasicAuth `json:"basicAuth"`
+
+	// ClientTLS configures client-certificate (mutual TLS) authentication to the scrape target, in
+	// addition to InsecureSkipVerify's effect on server certificate verification.
+	ClientTLS *PrometheusClientTLS `json:"tls"`
+}
+
+// PrometheusBasicAuth carries the credentials for an HTTP Basic Authorization header.
+type PrometheusBasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// PrometheusClientTLS configures the client certificate (and, optionally, a non-system CA bundle)
+// presented when scraping a target over https.
+type PrometheusClientTLS struct {
+	// CertFile and KeyFile, if set, are PEM-encoded and presented as a client certificate.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// CAFile, if set, is a PEM-encoded CA bundle used in place of the system trust store to verify
+	// the scrape target's certificate.
+	CAFile string `json:"caFile"`
+}
+
+// PrometheusScrapeMetric maps a single scraped Prometheus series to an ubbagent metric.
+type PrometheusScrapeMetric struct {
+	// SourceMetric is the name of the series in the scraped output.
+	SourceMetric string `json:"sourceMetric"`
+
+	// LabelMatchers, if non-empty, restricts matches of SourceMetric to series whose labels
+	// contain every entry here with an equal value. A SourceMetric exposed with multiple label
+	// combinations produces one report per matching series, each carrying that series' labels.
+	LabelMatchers map[string]string `json:"labelMatchers"`
+
+	// Metric is the name of the ubbagent metric this series reports as.
+	Metric string `json:"metric"`
+
+	// Kind determines how successive scrapes of SourceMetric become a MetricReport value.
+	// "counter" treats the series as ever-increasing and reports the delta between scrapes,
+	// treating a decrease as a counter reset; "gauge" reports the series' current value directly;
+	// "rate" reports the counter's delta divided by the elapsed time between scrapes, in units per
+	// second.
+	Kind string `json:"kind"`
+
+	// LabelsToReport, if non-empty, restricts a matching series' labels to this set when building
+	// the MetricReport. An empty value (the default) reports every label the series carries.
+	LabelsToReport []string `json:"labelsToReport"`
+}
+
+const (
+	PrometheusKindCounter = "counter"
+	PrometheusKindGauge   = "gauge"
+	PrometheusKindRate    = "rate"
+)
+
+// CloudEventsSource runs an HTTP receiver that accepts CNCF CloudEvents 1.0 events - structured,
+// binary, or a batch of structured events as a JSON array - and maps each one to a MetricReport.
+type CloudEventsSource struct {
+	// Port is the local TCP port the receiver listens on.
+	Port int `json:"port"`
+
+	// Path is the HTTP path events are POSTed to. Defaults to "/" when empty.
+	Path string `json:"path"`
+
+	// TypePrefix, if set, is stripped from an incoming CloudEvent's "type" attribute to get the
+	// ubbagent metric name; an event whose type doesn't carry this prefix is rejected. Ignored for
+	// a type present in TypeToMetric.
+	TypePrefix string `json:"typePrefix"`
+
+	// AllowedTypes, if non-empty, restricts accepted event types (after TypePrefix is stripped) to
+	// this set. Every entry must also name a configured metric.
+	AllowedTypes []string `json:"allowedTypes"`
+
+	// TypeToMetric, if non-empty, maps an incoming CloudEvent's "type" attribute directly to a
+	// ubbagent metric name, taking precedence over TypePrefix/AllowedTypes for any type it lists. A
+	// type not present here falls back to the TypePrefix/AllowedTypes handling above.
+	TypeToMetric map[string]string `json:"typeToMetric"`
+
+	// StartTimeExtension, if set, names a CloudEvents extension attribute carrying the report's
+	// StartTime; otherwise StartTime is taken from the event's "data.start_time" field, falling
+	// back to the event's own "time" when neither is present.
+	StartTimeExtension string `json:"startTimeExtension"`
+
+	// AuthSecret, if set, requires every request to carry an "Authorization: Bearer <token>" header
+	// whose token HMAC-SHA256-verifies against AuthSecret; a request without a valid header is
+	// rejected with 401. Leave empty to accept unauthenticated requests.
+	AuthSecret string `json:"authSecret"`
+
+	// TLSConfig, if set, serves this receiver over TLS - optionally mutual TLS - instead of
+	// plaintext HTTP.
+	TLSConfig *TLSConfig `json:"tlsConfig"`
+}
+
+func (ce *CloudEventsSource) Validate(c *Config) error {
+	if ce.Port <= 0 {
+		return errors.New("cloudEvents: port must be > 0")
+	}
+	if ce.TLSConfig != nil {
+		if err := ce.TLSConfig.Validate(c); err != nil {
+			return fmt.Errorf("cloudEvents: %v", err)
+		}
+	}
+	for _, t := range ce.AllowedTypes {
+		if c.Metrics.GetMetricDefinition(t) == nil {
+			return fmt.Errorf("cloudEvents: unknown metric: %v", t)
+		}
+	}
+	for ceType, metric := range ce.TypeToMetric {
+		if ceType == "" {
+			return errors.New("cloudEvents: typeToMetric: empty event type")
+		}
+		if c.Metrics.GetMetricDefinition(metric) == nil {
+			return fmt.Errorf("cloudEvents: typeToMetric: unknown metric: %v", metric)
+		}
+	}
+	return nil
+}
+
+func (p *PrometheusScrape) Validate(c *Config) error {
+	if p.URL == "" {
+		return errors.New("prometheusScrape: missing url")
+	}
+	if p.IntervalSeconds <= 0 {
+		return errors.New("prometheusScrape: intervalSeconds must be > 0")
+	}
+	if p.TimeoutSeconds < 0 {
+		return errors.New("prometheusScrape: timeoutSeconds must not be negative")
+	}
+	if p.BearerToken != "" && p.BasicAuth != nil {
+		return errors.New("prometheusScrape: bearerToken and basicAuth are mutually exclusive")
+	}
+	if p.BasicAuth != nil && (p.BasicAuth.Username == "" || p.BasicAuth.Password == "") {
+		return errors.New("prometheusScrape: basicAuth requires both username and password")
+	}
+	if p.ClientTLS != nil {
+		if (p.ClientTLS.CertFile == "") != (p.ClientTLS.KeyFile == "") {
+			return errors.New("prometheusScrape: tls: certFile and keyFile must both be specified")
+		}
+	}
+	if len(p.Metrics) == 0 {
+		return errors.New("prometheusScrape: no metrics defined")
+	}
+	for _, m := range p.Metrics {
+		if m.SourceMetric == "" {
+			return errors.New("prometheusScrape: missing sourceMetric")
+		}
+		if m.Kind != PrometheusKindCounter && m.Kind != PrometheusKindGauge && m.Kind != PrometheusKindRate {
+			return fmt.Errorf("prometheusScrape: %v: kind must be %q, %q, or %q", m.SourceMetric, PrometheusKindCounter, PrometheusKindGauge, PrometheusKindRate)
+		}
+		d := c.Metrics.GetMetricDefinition(m.Metric)
+		if d == nil {
+			return fmt.Errorf("prometheusScrape: %v: unknown metric: %v", m.SourceMetric, m.Metric)
+		}
+	}
+	return nil
+}
+
+// StatsdSource runs a StatsD protocol listener - UDP, and optionally TCP - and maps selected
+// samples to ubbagent MetricReports. Unlike PrometheusScrape, a sample's kind (counter, gauge, or
+// timer) doesn't need to be configured: the StatsD line protocol carries it directly, and this
+// source maps it onto a MetricValue accordingly, leaving the aggregation semantics (e.g. summing
+// vs. keeping the most recent value) to the target metric's own Aggregation.Kind.
+type StatsdSource struct {
+	// ListenAddress is the UDP address to listen on, e.g. ":8125".
+	ListenAddress string `json:"listenAddress"`
+
+	// TCPListenAddress, if set, additionally accepts newline-delimited StatsD samples over a TCP
+	// stream at this address.
+	TCPListenAddress string `json:"tcpListenAddress"`
+
+	// BufferSizeBytes bounds the size of a single UDP packet read from the socket; a packet larger
+	// than this is truncated. Defaults to 65536 when 0.
+	BufferSizeBytes int `json:"bufferSizeBytes"`
+
+	Metrics []StatsdMetric `json:"metrics"`
+}
+
+// StatsdMetric maps a single StatsD sample name to an ubbagent metric.
+type StatsdMetric struct {
+	// SourceMetric is the name a client reports the sample under.
+	SourceMetric string `json:"sourceMetric"`
+
+	// Metric is the name of the ubbagent metric this sample reports as.
+	Metric string `json:"metric"`
+}
+
+func (s *StatsdSource) Validate(c *Config) error {
+	if s.ListenAddress == "" {
+		return errors.New("statsd: listenAddress must be specified")
+	}
+	if s.BufferSizeBytes < 0 {
+		return errors.New("statsd: bufferSizeBytes must not be negative")
+	}
+	if len(s.Metrics) == 0 {
+		return errors.New("statsd: no metrics defined")
+	}
+	for _, m := range s.Metrics {
+		if m.SourceMetric == "" {
+			return errors.New("statsd: missing sourceMetric")
+		}
+		if c.Metrics.GetMetricDefinition(m.Metric) == nil {
+			return fmt.Errorf("statsd: %v: unknown metric: %v", m.SourceMetric, m.Metric)
+		}
+	}
+	return nil
+}
+
+// PubSubSource pulls messages from a Google Cloud Pub/Sub subscription and maps each one to a
+// MetricReport via Mapping. A message is acked only once the resulting report's AddReport call
+// succeeds; any other outcome - an unparseable message or a failed AddReport - nacks it so Pub/Sub
+// redelivers it, rather than silently dropping usage data.
+type PubSubSource struct {
+	Identity string `json:"identity"`
+
+	// ProjectId is the GCP project the subscription lives in.
+	ProjectId string `json:"projectId"`
+
+	// Subscription is the subscription ID to pull from (not the fully-qualified
+	// "projects/.../subscriptions/..." name).
+	Subscription string `json:"subscription"`
+
+	// MaxOutstandingMessages bounds how many messages are held in memory awaiting ack/nack at
+	// once. Defaults to the underlying client library's own default (1000) when 0.
+	MaxOutstandingMessages int `json:"maxOutstandingMessages"`
+
+	// Mapping describes how to turn an incoming message into a MetricReport.
+	Mapping PubSubMapping `json:"mapping"`
+}
+
+// PubSubMapping maps a Pub/Sub message onto a MetricReport. ValueField, the time fields, and
+// LabelFields all read from the message body, which must be a JSON object; LabelAttributes and,
+// when MetricAttribute is used instead of Metric, MetricAttribute read from the message's
+// attributes.
+type PubSubMapping struct {
+	// Metric is the ubbagent metric name every message on this subscription reports as. Mutually
+	// exclusive with MetricAttribute.
+	Metric string `json:"metric"`
+
+	// MetricAttribute, if set, names the message attribute holding the ubbagent metric name,
+	// letting a single subscription carry reports for more than one metric. Mutually exclusive
+	// with Metric.
+	MetricAttribute string `json:"metricAttribute"`
+
+	// ValueField names the body field holding the report's value.
+	ValueField string `json:"valueField"`
+
+	// EndTimeField and StartTimeField name body fields holding the report's end/start time,
+	// formatted as RFC 3339. EndTimeField defaults to the message's Pub/Sub publish time when
+	// empty; StartTimeField defaults to the resolved end time when empty.
+	EndTimeField   string `json:"endTimeField"`
+	StartTimeField string `json:"startTimeField"`
+
+	// LabelFields names body fields to copy onto the report's Labels, keyed by their own field
+	// name.
+	LabelFields []string `json:"labelFields"`
+
+	// LabelAttributes names message attributes to copy onto the report's Labels, keyed by their
+	// own attribute name.
+	LabelAttributes []string `json:"labelAttributes"`
+}
+
+func (p *PubSubSource) Validate(c *Config) error {
+	if err := validateGcpKey(c.Identities, "pubsub", p.Identity); err != nil {
+		return err
+	}
+	if p.ProjectId == "" {
+		return errors.New("pubsub: missing project ID")
+	}
+	if p.Subscription == "" {
+		return errors.New("pubsub: missing subscription")
+	}
+	if p.MaxOutstandingMessages < 0 {
+		return errors.New("pubsub: maxOutstandingMessages must not be negative")
+	}
+	return p.Mapping.Validate(c)
+}
+
+func (m *PubSubMapping) Validate(c *Config) error {
+	if (m.Metric == "") == (m.MetricAttribute == "") {
+		return errors.New("pubsub: exactly one of mapping.metric or mapping.metricAttribute must be set")
+	}
+	if m.Metric != "" && c.Metrics.GetMetricDefinition(m.Metric) == nil {
+		return fmt.Errorf("pubsub: unknown metric: %v", m.Metric)
+	}
+	if m.ValueField == "" {
+		return errors.New("pubsub: missing mapping.valueField")
+	}
+	return nil
+}
+
+// CustomSource configures a source kind that isn't built into ubbagent. Kind must match a kind
+// string some package - third-party or otherwise - has registered with sources.Register, typically
+// from that package's own init function; Params is handed to that kind's factory unparsed, for the
+// factory to decode itself. This is the source-side counterpart of CustomEndpoint.
+type CustomSource struct {
+	Kind   string          `json:"kind"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (s *CustomSource) Validate(c *Config) error {
+	if s.Kind == "" {
+		return errors.New("custom: missing kind")
+	}
+	return nil
+}
@@ -138,3 +138,92 @@ func TestSources_Validate(t *testing.T) {
 		}
 	})
 }
+
+func TestPrometheusScrape_Validate(t *testing.T) {
+	conf := &config.Config{
+		Metrics: config.Metrics{
+			{
+				Definition:  metrics.Definition{Name: "requests", Type: "int"},
+				Endpoints:   []config.MetricEndpoint{{Name: "disk"}},
+				Aggregation: &config.Aggregation{BufferSeconds: 10},
+			},
+		},
+	}
+
+	goodMetric := config.PrometheusScrapeMetric{
+		SourceMetric: "http_requests_total",
+		Metric:       "requests",
+		Kind:         config.PrometheusKindCounter,
+	}
+
+	t.Run("valid: rate kind", func(t *testing.T) {
+		p := &config.PrometheusScrape{
+			URL:             "http://localhost:9090/metrics",
+			IntervalSeconds: 10,
+			Metrics:         []config.PrometheusScrapeMetric{{SourceMetric: "http_requests_total", Metric: "requests", Kind: config.PrometheusKindRate}},
+		}
+		if err := p.Validate(conf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid: unknown kind", func(t *testing.T) {
+		p := &config.PrometheusScrape{
+			URL:             "http://localhost:9090/metrics",
+			IntervalSeconds: 10,
+			Metrics:         []config.PrometheusScrapeMetric{{SourceMetric: "http_requests_total", Metric: "requests", Kind: "total"}},
+		}
+		if err := p.Validate(conf); err == nil {
+			t.Fatal("Expected error, got none")
+		}
+	})
+
+	t.Run("invalid: bearerToken and basicAuth both set", func(t *testing.T) {
+		p := &config.PrometheusScrape{
+			URL:             "http://localhost:9090/metrics",
+			IntervalSeconds: 10,
+			Metrics:         []config.PrometheusScrapeMetric{goodMetric},
+			BearerToken:     "token",
+			BasicAuth:       &config.PrometheusBasicAuth{Username: "user", Password: "pass"},
+		}
+		if err := p.Validate(conf); err == nil {
+			t.Fatal("Expected error, got none")
+		}
+	})
+
+	t.Run("invalid: basicAuth missing password", func(t *testing.T) {
+		p := &config.PrometheusScrape{
+			URL:             "http://localhost:9090/metrics",
+			IntervalSeconds: 10,
+			Metrics:         []config.PrometheusScrapeMetric{goodMetric},
+			BasicAuth:       &config.PrometheusBasicAuth{Username: "user"},
+		}
+		if err := p.Validate(conf); err == nil {
+			t.Fatal("Expected error, got none")
+		}
+	})
+
+	t.Run("invalid: tls certFile without keyFile", func(t *testing.T) {
+		p := &config.PrometheusScrape{
+			URL:             "https://localhost:9090/metrics",
+			IntervalSeconds: 10,
+			Metrics:         []config.PrometheusScrapeMetric{goodMetric},
+			ClientTLS:       &config.PrometheusClientTLS{CertFile: "/tmp/cert.pem"},
+		}
+		if err := p.Validate(conf); err == nil {
+			t.Fatal("Expected error, got none")
+		}
+	})
+
+	t.Run("invalid: negative timeoutSeconds", func(t *testing.T) {
+		p := &config.PrometheusScrape{
+			URL:             "http://localhost:9090/metrics",
+			IntervalSeconds: 10,
+			TimeoutSeconds:  -1,
+			Metrics:         []config.PrometheusScrapeMetric{goodMetric},
+		}
+		if err := p.Validate(conf); err == nil {
+			t.Fatal("Expected error, got none")
+		}
+	})
+}
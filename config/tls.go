@@ -0,0 +1,120 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSConfig enables TLS - optionally mutual TLS - on an HTTP ingestion listener.
+type TLSConfig struct {
+	// CertFile and KeyFile are paths to the PEM-encoded server certificate and private key.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// ClientCAFile, if set, is a PEM-encoded CA bundle; the listener requires and verifies a client
+	// certificate signed by it (tls.RequireAndVerifyClientCert). Leave empty to not require client
+	// certificates.
+	ClientCAFile string `json:"clientCaFile"`
+
+	// MinVersion names the minimum accepted TLS protocol version: "VersionTLS10", "VersionTLS11",
+	// "VersionTLS12", or "VersionTLS13". Defaults to "VersionTLS12" when empty.
+	MinVersion string `json:"minVersion"`
+
+	// CipherSuites names the accepted cipher suites by their Go constant name, e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Empty accepts Go's default suites for MinVersion.
+	// Every name must be one of tls.CipherSuites()'s secure suites unless
+	// AllowInsecureCipherSuites is set.
+	CipherSuites []string `json:"cipherSuites"`
+
+	// AllowInsecureCipherSuites permits naming a suite from tls.InsecureCipherSuites() in
+	// CipherSuites. Defaults to false.
+	AllowInsecureCipherSuites bool `json:"allowInsecureCipherSuites"`
+}
+
+var tlsVersionsByName = map[string]uint16{
+	"VersionTLS10": tls.VersionTLS10,
+	"VersionTLS11": tls.VersionTLS11,
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+func (t *TLSConfig) Validate(c *Config) error {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return fmt.Errorf("tlsConfig: certFile and keyFile must both be specified")
+	}
+	if t.MinVersion != "" {
+		if _, ok := tlsVersionsByName[t.MinVersion]; !ok {
+			return fmt.Errorf("tlsConfig: unknown minVersion: %v", t.MinVersion)
+		}
+	}
+	for _, name := range t.CipherSuites {
+		secure, insecure := cipherSuiteByName(name)
+		if secure == nil && insecure == nil {
+			return fmt.Errorf("tlsConfig: unknown cipherSuite: %v", name)
+		}
+		if secure == nil && !t.AllowInsecureCipherSuites {
+			return fmt.Errorf("tlsConfig: cipherSuite %v is insecure; set allowInsecureCipherSuites to permit it", name)
+		}
+	}
+	return nil
+}
+
+// MinVersionOrDefault returns the tls.VersionTLSxx constant MinVersion names, or tls.VersionTLS12
+// if MinVersion is empty. Validate must have already confirmed MinVersion names a known version.
+func (t *TLSConfig) MinVersionOrDefault() uint16 {
+	if t.MinVersion == "" {
+		return tls.VersionTLS12
+	}
+	return tlsVersionsByName[t.MinVersion]
+}
+
+// CipherSuiteIDs resolves CipherSuites to their Go cipher suite IDs, or nil if CipherSuites is
+// empty - matching tls.Config.CipherSuites' own convention that nil (as opposed to an empty, non-
+// nil slice) selects Go's default suites. Validate must have already confirmed every name is known
+// and, unless AllowInsecureCipherSuites is set, secure.
+func (t *TLSConfig) CipherSuiteIDs() []uint16 {
+	if len(t.CipherSuites) == 0 {
+		return nil
+	}
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		secure, insecure := cipherSuiteByName(name)
+		switch {
+		case secure != nil:
+			ids = append(ids, secure.ID)
+		case insecure != nil:
+			ids = append(ids, insecure.ID)
+		}
+	}
+	return ids
+}
+
+// cipherSuiteByName looks up name among tls.CipherSuites() (secure) and tls.InsecureCipherSuites().
+// Exactly one of the two return values is non-nil if name is recognized; both are nil otherwise.
+func cipherSuiteByName(name string) (secure, insecure *tls.CipherSuite) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return nil, s
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+func TestTLSConfig_Validate(t *testing.T) {
+	t.Run("requires certFile and keyFile", func(t *testing.T) {
+		tc := config.TLSConfig{KeyFile: "key.pem"}
+		if err := tc.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with a missing certFile")
+		}
+	})
+
+	t.Run("accepts an empty minVersion and cipherSuites", func(t *testing.T) {
+		tc := config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}
+		if err := tc.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("rejects an unknown minVersion", func(t *testing.T) {
+		tc := config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "VersionSSL3"}
+		if err := tc.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with an unknown minVersion")
+		}
+	})
+
+	t.Run("accepts a known secure cipherSuite", func(t *testing.T) {
+		tc := config.TLSConfig{
+			CertFile:     "cert.pem",
+			KeyFile:      "key.pem",
+			CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		}
+		if err := tc.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("rejects an unknown cipherSuite", func(t *testing.T) {
+		tc := config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", CipherSuites: []string{"NOT_A_REAL_SUITE"}}
+		if err := tc.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with an unknown cipherSuite")
+		}
+	})
+
+	t.Run("rejects an insecure cipherSuite by default", func(t *testing.T) {
+		tc := config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}
+		if err := tc.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected an error with an insecure cipherSuite and allowInsecureCipherSuites unset")
+		}
+	})
+
+	t.Run("accepts an insecure cipherSuite when explicitly allowed", func(t *testing.T) {
+		tc := config.TLSConfig{
+			CertFile:                  "cert.pem",
+			KeyFile:                   "key.pem",
+			CipherSuites:              []string{"TLS_RSA_WITH_RC4_128_SHA"},
+			AllowInsecureCipherSuites: true,
+		}
+		if err := tc.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestTLSConfig_MinVersionOrDefault(t *testing.T) {
+	if v := (&config.TLSConfig{}).MinVersionOrDefault(); v != 0x0303 { // tls.VersionTLS12
+		t.Fatalf("expected the default to be VersionTLS12 (0x0303), got %#x", v)
+	}
+	if v := (&config.TLSConfig{MinVersion: "VersionTLS13"}).MinVersionOrDefault(); v != 0x0304 { // tls.VersionTLS13
+		t.Fatalf("expected VersionTLS13 (0x0304), got %#x", v)
+	}
+}
+
+func TestTLSConfig_CipherSuiteIDs(t *testing.T) {
+	tc := config.TLSConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}
+	ids := tc.CipherSuiteIDs()
+	if len(ids) != 1 {
+		t.Fatalf("expected 1 cipher suite ID, got %v", len(ids))
+	}
+}
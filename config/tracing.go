@@ -0,0 +1,48 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "errors"
+
+// Tracing configures an OpenTelemetry exporter that the agent's pipeline reports spans to,
+// letting an operator follow a single report's Id from ingestion through filtering, dispatch, and
+// each endpoint's retry attempts. It's optional; a nil Tracing disables tracing entirely, and every
+// component falls back to a no-op tracing.Tracer that never exports.
+type Tracing struct {
+	// Endpoint is the "host:port" of an OTLP/HTTP trace collector, such as a local otel-collector
+	// sidecar. Required.
+	Endpoint string `json:"endpoint"`
+
+	// Insecure disables TLS when dialing Endpoint. Defaults to false (TLS is used).
+	Insecure bool `json:"insecure"`
+
+	// ServiceName is reported as the "service.name" resource attribute on every exported span.
+	// Defaults to "ubbagent" when empty.
+	ServiceName string `json:"serviceName"`
+
+	// SampleRatio is the fraction, in [0, 1], of traces to sample. Defaults to 1 (sample
+	// everything) when zero.
+	SampleRatio float64 `json:"sampleRatio"`
+}
+
+func (t *Tracing) Validate(c *Config) error {
+	if t.Endpoint == "" {
+		return errors.New("tracing: missing endpoint")
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return errors.New("tracing: sampleRatio must be between 0 and 1")
+	}
+	return nil
+}
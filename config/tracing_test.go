@@ -0,0 +1,44 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+func TestTracing_Validate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		tr := config.Tracing{Endpoint: "otel-collector:4318", SampleRatio: 0.5}
+		if err := tr.Validate(&config.Config{}); err != nil {
+			t.Fatalf("unexpected validate error: %v", err)
+		}
+	})
+
+	t.Run("invalid: missing endpoint", func(t *testing.T) {
+		tr := config.Tracing{}
+		if err := tr.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+
+	t.Run("invalid: sampleRatio out of range", func(t *testing.T) {
+		tr := config.Tracing{Endpoint: "otel-collector:4318", SampleRatio: 1.5}
+		if err := tr.Validate(&config.Config{}); err == nil {
+			t.Fatal("expected validate error, got nil")
+		}
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFile starts an inotify watch on path's containing directory, rather than path itself, so a
+// reload is still triggered after the common "write a temp file, then rename over the original"
+// pattern many config management tools use - a direct watch on path would be silently orphaned by
+// the rename. The returned channel receives a value (non-blocking; a burst of events coalesces
+// into one pending reload) whenever path is written in place or (re)created by a rename. Call stop
+// to release the inotify file descriptor; events is not closed, since the watcher goroutine may
+// still be mid-send.
+func watchFile(path string) (events chan struct{}, stop func(), err error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, err
+	}
+	wd, err := unix.InotifyAddWatch(fd, dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO|unix.IN_CREATE)
+	if err != nil {
+		unix.Close(fd)
+		return nil, nil, err
+	}
+
+	events = make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := unix.Read(fd, buf)
+			if err != nil || n <= 0 {
+				return
+			}
+			var offset int
+			for offset+unix.SizeofInotifyEvent <= n {
+				raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameLen := int(raw.Len)
+				evName := ""
+				if nameLen > 0 {
+					start := offset + unix.SizeofInotifyEvent
+					evName = strings.TrimRight(string(buf[start:start+nameLen]), "\x00")
+				}
+				offset += unix.SizeofInotifyEvent + nameLen
+				if evName == name {
+					select {
+					case events <- struct{}{}:
+					default:
+					}
+				}
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	stop = func() {
+		unix.InotifyRmWatch(fd, uint32(wd))
+		unix.Close(fd)
+		close(done)
+	}
+	return events, stop, nil
+}
@@ -0,0 +1,25 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package config
+
+import "errors"
+
+// watchFile is unimplemented outside of Linux; NewWatcher falls back to its SIGHUP-only reload
+// path on these platforms.
+func watchFile(path string) (events chan struct{}, stop func(), err error) {
+	return nil, nil, errors.New("config: inotify-based watching is only supported on linux")
+}
@@ -0,0 +1,208 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+const watcherTestConfigV1 = `
+metrics:
+- name: requests
+  type: int
+  passthrough: {}
+  endpoints:
+  - name: disk
+endpoints:
+- name: disk
+  disk:
+    reportDir: /tmp/reports
+`
+
+const watcherTestConfigV2 = `
+metrics:
+- name: requests
+  type: int
+  passthrough: {}
+  endpoints:
+  - name: disk
+- name: errors
+  type: int
+  passthrough: {}
+  endpoints:
+  - name: disk
+endpoints:
+- name: disk
+  disk:
+    reportDir: /tmp/reports
+`
+
+const watcherTestConfigTypeChange = `
+metrics:
+- name: requests
+  type: double
+  passthrough: {}
+  endpoints:
+  - name: disk
+endpoints:
+- name: disk
+  disk:
+    reportDir: /tmp/reports
+`
+
+func writeWatcherTestConfig(t *testing.T, path, text string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeWatcherTestConfig(t, path, watcherTestConfigV1)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("error loading initial config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("error validating initial config: %v", err)
+	}
+
+	var handled *config.Config
+	var handledChangeSet config.ChangeSet
+	w := config.NewWatcher(path, cfg, func(newCfg *config.Config, cs config.ChangeSet) error {
+		handled = newCfg
+		handledChangeSet = cs
+		return nil
+	})
+	defer w.Close()
+
+	writeWatcherTestConfig(t, path, watcherTestConfigV2)
+	if _, err := w.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if handled == nil {
+		t.Fatal("handler was not invoked")
+	}
+	if want, got := []string{"errors"}, handledChangeSet.AddedMetrics; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("AddedMetrics: want=%v, got=%v", want, got)
+	}
+}
+
+func TestWatcher_RejectsMetricTypeChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeWatcherTestConfig(t, path, watcherTestConfigV1)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("error loading initial config: %v", err)
+	}
+
+	handlerCalled := false
+	w := config.NewWatcher(path, cfg, func(newCfg *config.Config, cs config.ChangeSet) error {
+		handlerCalled = true
+		return nil
+	})
+	defer w.Close()
+
+	writeWatcherTestConfig(t, path, watcherTestConfigTypeChange)
+	cs, err := w.Reload()
+	if err == nil {
+		t.Fatal("expected an error, got none")
+	}
+	if want, got := []string{"requests"}, cs.MetricTypeChanges; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("MetricTypeChanges: want=%v, got=%v", want, got)
+	}
+	if handlerCalled {
+		t.Error("handler should not be invoked when a reload is rejected")
+	}
+}
+
+func TestWatcher_FileChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeWatcherTestConfig(t, path, watcherTestConfigV1)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("error loading initial config: %v", err)
+	}
+
+	done := make(chan struct{})
+	var handledChangeSet config.ChangeSet
+	w := config.NewWatcher(path, cfg, func(newCfg *config.Config, cs config.ChangeSet) error {
+		handledChangeSet = cs
+		close(done)
+		return nil
+	})
+	defer w.Close()
+
+	// Mimic the write-temp-then-rename pattern used by many config management tools, rather than
+	// editing path in place, since that's the case a naive single-file inotify watch would miss.
+	tmp := filepath.Join(dir, ".config.yaml.tmp")
+	writeWatcherTestConfig(t, tmp, watcherTestConfigV2)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("error renaming new config into place: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for file-change-triggered reload")
+	}
+	if want, got := []string{"errors"}, handledChangeSet.AddedMetrics; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("AddedMetrics: want=%v, got=%v", want, got)
+	}
+}
+
+func TestWatcher_SIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeWatcherTestConfig(t, path, watcherTestConfigV1)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("error loading initial config: %v", err)
+	}
+
+	done := make(chan struct{})
+	w := config.NewWatcher(path, cfg, func(newCfg *config.Config, cs config.ChangeSet) error {
+		close(done)
+		return nil
+	})
+	defer w.Close()
+
+	writeWatcherTestConfig(t, path, watcherTestConfigV2)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("error sending SIGHUP: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for SIGHUP-triggered reload")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cron implements a minimal parser and evaluator for cron-style schedule expressions, used
+// to compute wall-clock-aligned fire times (e.g. hourly at :00, daily at 00:00 UTC).
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aliases maps the supported "@"-prefixed shorthand expressions to their standard 5-field
+// equivalents.
+var aliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@monthly": "0 0 1 * *",
+}
+
+// Schedule is a parsed cron expression that can compute successive fire times.
+type Schedule struct {
+	minute fieldSet
+	hour   fieldSet
+	dom    fieldSet
+	month  fieldSet
+	dow    fieldSet
+}
+
+// fieldSet is the set of values a single cron field matches. A nil fieldSet matches everything
+// (the "*" wildcard).
+type fieldSet map[int]bool
+
+func (f fieldSet) matches(v int) bool {
+	return f == nil || f[v]
+}
+
+// Parse parses a standard 5-field cron expression (minute hour day-of-month month day-of-week), or
+// one of the "@hourly", "@daily", "@monthly" shorthand aliases.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := aliases[expr]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %v: %q", len(fields), expr)
+	}
+	minute, err := parseField(fields[0], 0, 59)
+	if err != nil {
+		return nil, fmt.Errorf("cron: minute: %v", err)
+	}
+	hour, err := parseField(fields[1], 0, 23)
+	if err != nil {
+		return nil, fmt.Errorf("cron: hour: %v", err)
+	}
+	dom, err := parseField(fields[2], 1, 31)
+	if err != nil {
+		return nil, fmt.Errorf("cron: day-of-month: %v", err)
+	}
+	month, err := parseField(fields[3], 1, 12)
+	if err != nil {
+		return nil, fmt.Errorf("cron: month: %v", err)
+	}
+	dow, err := parseField(fields[4], 0, 7)
+	if err != nil {
+		return nil, fmt.Errorf("cron: day-of-week: %v", err)
+	}
+	if dow != nil && dow[7] {
+		// Both 0 and 7 mean Sunday.
+		dow[0] = true
+		delete(dow, 7)
+	}
+	if err := checkDayMonthFeasible(dom, month, dow); err != nil {
+		return nil, fmt.Errorf("cron: %v: %q", err, expr)
+	}
+	return &Schedule{minute: minute, hour: hour, dom: dom, month: month, dow: dow}, nil
+}
+
+// maxDayOfMonth is the greatest day a given month can ever have, taking Feb's leap-year maximum
+// rather than its common-year one.
+var maxDayOfMonth = [...]int{31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// checkDayMonthFeasible rejects a day-of-month/month/day-of-week combination that can never match
+// any real calendar date, such as "0 0 31 2 *" (February never has a 31st). If dow is restricted,
+// a day-of-month/month mismatch is never fatal on its own: per dayMatches, a restricted dow is
+// enough to fire on its own, so only a restricted dom with a wildcard dow needs checking here.
+func checkDayMonthFeasible(dom, month, dow fieldSet) error {
+	if dom == nil || dow != nil {
+		return nil
+	}
+	for m := 1; m <= 12; m++ {
+		if !month.matches(m) {
+			continue
+		}
+		for day := range dom {
+			if day <= maxDayOfMonth[m-1] {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("day-of-month can never occur in any matching month")
+}
+
+// parseField parses a single cron field: "*", a bare number, a "a-b" range, or a comma-separated
+// list of any mix of those, each optionally followed by a "/step".
+func parseField(field string, min, max int) (fieldSet, error) {
+	if field == "*" {
+		return nil, nil
+	}
+	set := make(fieldSet)
+	for _, part := range strings.Split(field, ",") {
+		rangeExpr, step, err := splitStep(part)
+		if err != nil {
+			return nil, err
+		}
+		lo, hi := min, max
+		if rangeExpr != "*" {
+			lo, hi, err = parseRange(rangeExpr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("value out of range in %q", part)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// splitStep splits "expr/step" into its range expression and step, defaulting step to 1 when
+// absent.
+func splitStep(part string) (rangeExpr string, step int, err error) {
+	pieces := strings.SplitN(part, "/", 2)
+	if len(pieces) == 1 {
+		return pieces[0], 1, nil
+	}
+	step, err = strconv.Atoi(pieces[1])
+	if err != nil || step <= 0 {
+		return "", 0, fmt.Errorf("invalid step in %q", part)
+	}
+	return pieces[0], step, nil
+}
+
+// parseRange parses "a-b" into its bounds, or a single value "a" into the range [a, a].
+func parseRange(expr string) (lo, hi int, err error) {
+	pieces := strings.SplitN(expr, "-", 2)
+	lo, err = strconv.Atoi(pieces[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value %q", pieces[0])
+	}
+	if len(pieces) == 1 {
+		return lo, lo, nil
+	}
+	hi, err = strconv.Atoi(pieces[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value %q", pieces[1])
+	}
+	return lo, hi, nil
+}
+
+// Next returns the first instant strictly after t at which the schedule fires, truncated to whole
+// minutes in t's location. An error is returned if no such instant exists within the search bound
+// below; Parse already rejects day-of-month/month combinations that could cause this, so it should
+// only happen in practice if that validation has a gap.
+func (s *Schedule) Next(t time.Time) (time.Time, error) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// A minute's worth of granularity over a 4-year span (to clear Feb 29 schedules) bounds the
+	// search; any valid cron expression fires well within that.
+	for limit := 0; limit < 4*366*24*60; limit++ {
+		if s.month.matches(int(t.Month())) && s.dayMatches(t) &&
+			s.hour.matches(t.Hour()) && s.minute.matches(t.Minute()) {
+			return t, nil
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, fmt.Errorf("cron: no matching fire time found within search bound")
+}
+
+// dayMatches applies the standard cron rule for combining day-of-month and day-of-week: if both
+// are restricted (not "*"), a match on either is sufficient; otherwise both must match (which, since
+// an unrestricted field always matches, reduces to requiring only the restricted one).
+func (s *Schedule) dayMatches(t time.Time) bool {
+	if s.dom != nil && s.dow != nil {
+		return s.dom.matches(t.Day()) || s.dow.matches(int(t.Weekday()))
+	}
+	return s.dom.matches(t.Day()) && s.dow.matches(int(t.Weekday()))
+}
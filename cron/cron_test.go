@@ -0,0 +1,74 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_InvalidExpressions(t *testing.T) {
+	cases := []string{"", "* * * *", "60 * * * *", "* * * 13 *", "* * * * 8"}
+	for _, expr := range cases {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): expected error, got none", expr)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	cases := []struct {
+		expr string
+		from string
+		want string
+	}{
+		{"@hourly", "2026-07-29T10:15:00Z", "2026-07-29T11:00:00Z"},
+		{"@daily", "2026-07-29T10:15:00Z", "2026-07-30T00:00:00Z"},
+		{"@monthly", "2026-07-29T10:15:00Z", "2026-08-01T00:00:00Z"},
+		{"0 * * * *", "2026-07-29T10:00:00Z", "2026-07-29T11:00:00Z"},
+		{"*/15 * * * *", "2026-07-29T10:16:00Z", "2026-07-29T10:30:00Z"},
+		{"0 0 1 * *", "2026-02-15T00:00:00Z", "2026-03-01T00:00:00Z"},
+		{"0 9 * * 1", "2026-07-29T00:00:00Z", "2026-08-03T09:00:00Z"},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.expr, err)
+		}
+		from, _ := time.Parse(time.RFC3339, c.from)
+		want, _ := time.Parse(time.RFC3339, c.want)
+		got, err := s.Next(from)
+		if err != nil {
+			t.Fatalf("Parse(%q).Next(%v): %v", c.expr, c.from, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("Parse(%q).Next(%v) = %v, want %v", c.expr, c.from, got, want)
+		}
+	}
+}
+
+func TestParse_InfeasibleDayMonth(t *testing.T) {
+	cases := []string{"0 0 31 2 *", "0 0 30-31 2 *", "0 0 31 4,6,9,11 *"}
+	for _, expr := range cases {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): expected error, got none", expr)
+		}
+	}
+	// A restricted day-of-week makes the day-of-month/month combination's infeasibility moot, since
+	// dayMatches ORs them together.
+	if _, err := Parse("0 0 31 2 1"); err != nil {
+		t.Errorf("Parse(%q): unexpected error: %v", "0 0 31 2 1", err)
+	}
+}
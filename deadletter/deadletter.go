@@ -0,0 +1,45 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deadletter provides pipeline.DeadLetterSink implementations that a RetryingSender hands
+// reports off to once it's given up on them, so operators can recover and reprocess lost usage
+// events instead of losing them silently.
+package deadletter
+
+import (
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+// record is the JSON representation written by both Sink implementations.
+type record struct {
+	Timestamp time.Time               `json:"timestamp"`
+	Endpoint  string                  `json:"endpoint"`
+	Report    pipeline.EndpointReport `json:"report"`
+	FirstSeen time.Time               `json:"firstSeen"`
+	LastError string                  `json:"lastError"`
+	Attempts  int                     `json:"attempts"`
+}
+
+func newRecord(entry pipeline.DeadLetterEntry, now time.Time) record {
+	return record{
+		Timestamp: now,
+		Endpoint:  entry.Endpoint,
+		Report:    entry.Report,
+		FirstSeen: entry.FirstSeen,
+		LastError: entry.LastError,
+		Attempts:  entry.Attempts,
+	}
+}
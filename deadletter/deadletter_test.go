@@ -0,0 +1,133 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deadletter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/testlib"
+)
+
+var _ pipeline.DeadLetterSink = (*FileSink)(nil)
+var _ pipeline.DeadLetterSink = (*HTTPSink)(nil)
+
+func testEntry() pipeline.DeadLetterEntry {
+	report, _ := pipeline.NewEndpointReport(metrics.StampedMetricReport{
+		Id: "report1",
+		MetricReport: metrics.MetricReport{
+			Name:      "int-metric",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+		},
+	}, nil)
+	return pipeline.DeadLetterEntry{
+		Endpoint:  "mockep",
+		Report:    report,
+		FirstSeen: time.Unix(100, 0),
+		LastError: "send failure",
+		Attempts:  3,
+	}
+}
+
+func TestFileSink_DeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mc := testlib.NewMockClock()
+	mc.SetNow(time.Unix(200, 0))
+	sink, err := newFileSink(dir, mc)
+	if err != nil {
+		t.Fatalf("newFileSink: %+v", err)
+	}
+	sink.Use()
+
+	if err := sink.DeadLetter(testEntry()); err != nil {
+		t.Fatalf("DeadLetter: %+v", err)
+	}
+	if err := sink.Release(); err != nil {
+		t.Fatalf("Release: %+v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, deadLetterFileName))
+	if err != nil {
+		t.Fatalf("reading deadletter file: %+v", err)
+	}
+	var rec record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("unmarshaling deadletter record: %+v, content: %s", err, b)
+	}
+	if rec.Endpoint != "mockep" {
+		t.Errorf("expected endpoint mockep, got: %v", rec.Endpoint)
+	}
+	if rec.Attempts != 3 {
+		t.Errorf("expected 3 attempts, got: %v", rec.Attempts)
+	}
+	if rec.LastError != "send failure" {
+		t.Errorf("unexpected LastError: %v", rec.LastError)
+	}
+	if !rec.Timestamp.Equal(time.Unix(200, 0)) {
+		t.Errorf("expected timestamp 200, got: %v", rec.Timestamp)
+	}
+}
+
+func TestHTTPSink_DeadLetter(t *testing.T) {
+	var gotBody []byte
+	var gotHeader string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		gotHeader = r.Header.Get("X-Api-Key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	sink := NewHTTPSink(ts.URL, map[string]string{"X-Api-Key": "secret"})
+	if err := sink.DeadLetter(testEntry()); err != nil {
+		t.Fatalf("DeadLetter: %+v", err)
+	}
+	if gotHeader != "secret" {
+		t.Errorf("expected X-Api-Key header secret, got: %v", gotHeader)
+	}
+	var rec record
+	if err := json.Unmarshal(gotBody, &rec); err != nil {
+		t.Fatalf("unmarshaling posted body: %+v, content: %s", err, gotBody)
+	}
+	if rec.Endpoint != "mockep" {
+		t.Errorf("expected endpoint mockep, got: %v", rec.Endpoint)
+	}
+}
+
+func TestHTTPSink_DeadLetter_ErrorStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	sink := NewHTTPSink(ts.URL, nil)
+	if err := sink.DeadLetter(testEntry()); err == nil {
+		t.Fatal("expected an error from a 500 response, got nil")
+	}
+}
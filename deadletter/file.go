@@ -0,0 +1,93 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+const (
+	fileMode      = 0644
+	directoryMode = 0755
+
+	// deadLetterFileName is the JSON-Lines file each entry is appended to, under the directory
+	// passed to NewFileSink.
+	deadLetterFileName = "deadletter.jsonl"
+)
+
+// FileSink is a pipeline.DeadLetterSink that appends entries as JSON Lines to a file, so an
+// operator (or a log shipper) can tail or batch-process reports the agent gave up on.
+type FileSink struct {
+	clock   clock.Clock
+	tracker pipeline.UsageTracker
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink creates or resumes a FileSink appending to deadletter.jsonl under dir, which is
+// created if it doesn't already exist.
+func NewFileSink(dir string) (*FileSink, error) {
+	return newFileSink(dir, clock.NewClock())
+}
+
+func newFileSink(dir string, clk clock.Clock) (*FileSink, error) {
+	if err := os.MkdirAll(dir, directoryMode); err != nil {
+		return nil, fmt.Errorf("deadletter: creating directory: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, deadLetterFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("deadletter: opening file: %v", err)
+	}
+	return &FileSink{clock: clk, file: f}, nil
+}
+
+// DeadLetter appends entry to the sink's file as a single line of JSON.
+func (s *FileSink) DeadLetter(entry pipeline.DeadLetterEntry) error {
+	b, err := json.Marshal(newRecord(entry, s.clock.Now()))
+	if err != nil {
+		return fmt.Errorf("deadletter: marshaling entry: %v", err)
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(b)
+	return err
+}
+
+// Use increments the FileSink's usage count.
+// See pipeline.Component.Use.
+func (s *FileSink) Use() {
+	s.tracker.Use()
+}
+
+// Release decrements the FileSink's usage count. If it reaches 0, Release closes the underlying
+// file.
+// See pipeline.Component.Release.
+func (s *FileSink) Release() error {
+	return s.tracker.Release(func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.file.Close()
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deadletter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+// httpTimeout bounds a single POST to the configured endpoint.
+const httpTimeout = 30 * time.Second
+
+// HTTPSink is a pipeline.DeadLetterSink that POSTs each entry as JSON to a configured endpoint, so
+// an operator can feed reports the agent gave up on into an external processing pipeline.
+type HTTPSink struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	clock    clock.Clock
+	tracker  pipeline.UsageTracker
+}
+
+// NewHTTPSink creates an HTTPSink that POSTs to endpoint, attaching headers to every request.
+func NewHTTPSink(endpoint string, headers map[string]string) *HTTPSink {
+	return &HTTPSink{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: httpTimeout},
+		clock:    clock.NewClock(),
+	}
+}
+
+// DeadLetter POSTs entry to the sink's endpoint as JSON.
+func (s *HTTPSink) DeadLetter(entry pipeline.DeadLetterEntry) error {
+	body, err := json.Marshal(newRecord(entry, s.clock.Now()))
+	if err != nil {
+		return fmt.Errorf("deadletter: marshaling entry: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("deadletter: http sink: unexpected status: %v", resp.Status)
+	}
+	return nil
+}
+
+// Use increments the HTTPSink's usage count.
+// See pipeline.Component.Use.
+func (s *HTTPSink) Use() {
+	s.tracker.Use()
+}
+
+// Release decrements the HTTPSink's usage count. It has no other resources to release.
+// See pipeline.Component.Release.
+func (s *HTTPSink) Release() error {
+	return s.tracker.Release(func() error { return nil })
+}
@@ -0,0 +1,66 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/hashicorp/go-multierror"
+)
+
+// ReplayFile reads path - a JSON-Lines file previously written by a FileSink - and resends each
+// record's report through endpoint, the same way a RetryingSender would have if the original send
+// had eventually succeeded. It's meant to be run offline, against a stopped or freshly started
+// agent, to recover reports that were given up on.
+//
+// A record whose Endpoint doesn't match endpoint.Name() is skipped and reported as an error,
+// rather than silently resent to the wrong place. ReplayFile keeps going after an individual
+// record fails to resend, folding every failure into the returned error so a partial replay still
+// reports which records still need attention; it returns the number of records resent
+// successfully.
+func ReplayFile(path string, endpoint pipeline.Endpoint) (replayed int, err error) {
+	f, ferr := os.Open(path)
+	if ferr != nil {
+		return 0, fmt.Errorf("deadletter: opening %v: %v", path, ferr)
+	}
+	defer f.Close()
+
+	var errs *multierror.Error
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec record
+		if uerr := json.Unmarshal(scanner.Bytes(), &rec); uerr != nil {
+			errs = multierror.Append(errs, fmt.Errorf("deadletter: unmarshaling record: %v", uerr))
+			continue
+		}
+		if rec.Endpoint != endpoint.Name() {
+			errs = multierror.Append(errs, fmt.Errorf("deadletter: record %v is for endpoint %q, not replay target %q", rec.Report.Id, rec.Endpoint, endpoint.Name()))
+			continue
+		}
+		if serr := endpoint.Send(rec.Report); serr != nil {
+			errs = multierror.Append(errs, fmt.Errorf("deadletter: resending report %v: %v", rec.Report.Id, serr))
+			continue
+		}
+		replayed++
+	}
+	if serr := scanner.Err(); serr != nil {
+		errs = multierror.Append(errs, fmt.Errorf("deadletter: scanning %v: %v", path, serr))
+	}
+	return replayed, errs.ErrorOrNil()
+}
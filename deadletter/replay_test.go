@@ -0,0 +1,162 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deadletter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/testlib"
+)
+
+var _ pipeline.Endpoint = (*replayMockEndpoint)(nil)
+
+// replayMockEndpoint is a minimal pipeline.Endpoint that records every report handed to it, used
+// to assert what ReplayFile resends without depending on a real network endpoint.
+type replayMockEndpoint struct {
+	name string
+	err  error
+	sent []pipeline.EndpointReport
+}
+
+func (e *replayMockEndpoint) Name() string { return e.name }
+
+func (e *replayMockEndpoint) Send(report pipeline.EndpointReport) error {
+	return e.SendContext(context.Background(), report)
+}
+
+func (e *replayMockEndpoint) SendContext(ctx context.Context, report pipeline.EndpointReport) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.sent = append(e.sent, report)
+	return nil
+}
+
+func (e *replayMockEndpoint) BuildReport(report metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(report, nil)
+}
+
+func (e *replayMockEndpoint) IsTransient(error) bool { return false }
+func (e *replayMockEndpoint) Use()                   {}
+func (e *replayMockEndpoint) Release() error         { return nil }
+
+func TestReplayFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter_replay_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mc := testlib.NewMockClock()
+	mc.SetNow(time.Unix(200, 0))
+	sink, err := newFileSink(dir, mc)
+	if err != nil {
+		t.Fatalf("newFileSink: %+v", err)
+	}
+	sink.Use()
+
+	entry1 := testEntry()
+	entry2 := testEntry()
+	entry2.Report.Id = "report2"
+	if err := sink.DeadLetter(entry1); err != nil {
+		t.Fatalf("DeadLetter(entry1): %+v", err)
+	}
+	if err := sink.DeadLetter(entry2); err != nil {
+		t.Fatalf("DeadLetter(entry2): %+v", err)
+	}
+	if err := sink.Release(); err != nil {
+		t.Fatalf("Release: %+v", err)
+	}
+
+	ep := &replayMockEndpoint{name: "mockep"}
+	replayed, err := ReplayFile(filepath.Join(dir, deadLetterFileName), ep)
+	if err != nil {
+		t.Fatalf("ReplayFile: %+v", err)
+	}
+	if replayed != 2 {
+		t.Errorf("expected 2 records replayed, got: %v", replayed)
+	}
+	if len(ep.sent) != 2 || ep.sent[0].Id != "report1" || ep.sent[1].Id != "report2" {
+		t.Errorf("unexpected resent reports: %+v", ep.sent)
+	}
+}
+
+func TestReplayFile_MismatchedEndpointIsReportedAndSkipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter_replay_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := newFileSink(dir, testlib.NewMockClock())
+	if err != nil {
+		t.Fatalf("newFileSink: %+v", err)
+	}
+	sink.Use()
+	if err := sink.DeadLetter(testEntry()); err != nil {
+		t.Fatalf("DeadLetter: %+v", err)
+	}
+	if err := sink.Release(); err != nil {
+		t.Fatalf("Release: %+v", err)
+	}
+
+	ep := &replayMockEndpoint{name: "some-other-endpoint"}
+	replayed, err := ReplayFile(filepath.Join(dir, deadLetterFileName), ep)
+	if err == nil {
+		t.Fatal("expected an error for a record whose endpoint doesn't match the replay target")
+	}
+	if replayed != 0 {
+		t.Errorf("expected 0 records replayed, got: %v", replayed)
+	}
+	if len(ep.sent) != 0 {
+		t.Errorf("expected no reports sent to the mismatched endpoint, got: %+v", ep.sent)
+	}
+}
+
+func TestReplayFile_SendErrorIsReported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter_replay_test")
+	if err != nil {
+		t.Fatalf("TempDir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := newFileSink(dir, testlib.NewMockClock())
+	if err != nil {
+		t.Fatalf("newFileSink: %+v", err)
+	}
+	sink.Use()
+	if err := sink.DeadLetter(testEntry()); err != nil {
+		t.Fatalf("DeadLetter: %+v", err)
+	}
+	if err := sink.Release(); err != nil {
+		t.Fatalf("Release: %+v", err)
+	}
+
+	ep := &replayMockEndpoint{name: "mockep", err: os.ErrClosed}
+	replayed, err := ReplayFile(filepath.Join(dir, deadLetterFileName), ep)
+	if err == nil {
+		t.Fatal("expected an error from a failed resend")
+	}
+	if replayed != 0 {
+		t.Errorf("expected 0 records replayed, got: %v", replayed)
+	}
+}
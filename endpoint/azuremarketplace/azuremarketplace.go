@@ -0,0 +1,353 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package azuremarketplace implements a pipeline.Endpoint that reports usage events to the Azure
+// Marketplace Metering Service, letting the agent meter SaaS/managed-app marketplace plans on
+// Azure alongside Service Control on GCP.
+package azuremarketplace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/identity"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// marketplaceResourceID identifies the Azure Marketplace Metering Service to AAD.
+	marketplaceResourceID = "20e940b3-4c77-4b0b-9a53-9e16a1b010a7"
+
+	usageEventURL      = "https://marketplaceapi.microsoft.com/api/usageEvent?api-version=2018-08-31"
+	batchUsageEventURL = "https://marketplaceapi.microsoft.com/api/batchUsageEvent?api-version=2018-08-31"
+	timeout            = 60 * time.Second
+	resourceIDLabel    = "resourceId"
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 250 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
+)
+
+// AzureMarketplaceEndpoint reports usage events for a single marketplace plan to the Azure
+// Marketplace Metering Service.
+type AzureMarketplaceEndpoint struct {
+	name   string
+	planId string
+	client *http.Client
+	tokens oauth2.TokenSource
+	retry  retrypolicy.RetryPolicy
+	clock  clock.Clock
+}
+
+// NewAzureMarketplaceEndpoint creates a new AzureMarketplaceEndpoint that reports usage events for
+// planId, authenticating to AAD as azure (via client secret, signed certificate assertion, or the
+// Azure Instance Metadata Service, depending on how azure is configured). backoff configures the
+// endpoint's in-process retry policy; a nil value selects the built-in defaults.
+func NewAzureMarketplaceEndpoint(name string, azure *config.AzureIdentity, planId string, backoff *config.BackoffPolicy) *AzureMarketplaceEndpoint {
+	return &AzureMarketplaceEndpoint{
+		name:   name,
+		planId: planId,
+		client: &http.Client{Timeout: timeout},
+		tokens: identity.NewAzureTokenSource(azure, marketplaceResourceID),
+		retry:  retry.NewPolicy(backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:  clock.NewClock(),
+	}
+}
+
+func (ep *AzureMarketplaceEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *AzureMarketplaceEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *AzureMarketplaceEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *AzureMarketplaceEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	body, err := json.Marshal(ep.format(r))
+	if err != nil {
+		return err
+	}
+	return retry.Do(ctx, ep.clock, ep.retry, classifyUsageEventError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("AzureMarketplaceEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		return ep.sendUsageEvent(ctx, body)
+	})
+}
+
+func (ep *AzureMarketplaceEndpoint) sendUsageEvent(ctx context.Context, body []byte) error {
+	token, err := ep.tokens.Token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, usageEventURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	token.SetAuthHeader(req)
+
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusConflict:
+		// The usage event was already accepted for this resourceId/dimension/effectiveStartTime
+		// combination. Treat a retried send as a successful one.
+		glog.V(2).Infoln("AzureMarketplaceEndpoint:Send(): duplicate usage event accepted as success")
+		return nil
+	default:
+		return &usageEventError{statusCode: resp.StatusCode, body: string(respBody), header: resp.Header}
+	}
+}
+
+// SendBatch sends every report in reports in a single batchUsageEvent call, and is used instead of
+// repeated Send calls when a RetryPolicy configures BatchMaxReports. It implements
+// pipeline.BatchEndpoint.
+func (ep *AzureMarketplaceEndpoint) SendBatch(reports []pipeline.EndpointReport) error {
+	events := make([]usageEvent, len(reports))
+	for i, r := range reports {
+		events[i] = ep.format(r)
+	}
+	body, err := json.Marshal(usageEventBatchRequest{Request: events})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var results []usageEventResult
+	err = retry.Do(ctx, ep.clock, ep.retry, classifyUsageEventError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("AzureMarketplaceEndpoint:SendBatch(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		r, err := ep.sendBatchUsageEvent(ctx, body)
+		if err != nil {
+			return err
+		}
+		results = r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	glog.V(2).Infoln("AzureMarketplaceEndpoint:SendBatch(): success")
+	if errs := endpointErrorsFrom(results); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (ep *AzureMarketplaceEndpoint) sendBatchUsageEvent(ctx context.Context, body []byte) ([]usageEventResult, error) {
+	token, err := ep.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, batchUsageEventURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	token.SetAuthHeader(req)
+
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &usageEventError{statusCode: resp.StatusCode, body: string(respBody), header: resp.Header}
+	}
+	var batchResp usageEventBatchResponse
+	if err := json.Unmarshal(respBody, &batchResp); err != nil {
+		return nil, fmt.Errorf("azuremarketplace: invalid batchUsageEvent response: %v", err)
+	}
+	return batchResp.Result, nil
+}
+
+// usageEvent is the request body expected by the Azure Marketplace Metering Service's usageEvent
+// API.
+type usageEvent struct {
+	ResourceID         string  `json:"resourceId"`
+	Quantity           float64 `json:"quantity"`
+	Dimension          string  `json:"dimension"`
+	EffectiveStartTime string  `json:"effectiveStartTime"`
+	PlanID             string  `json:"planId"`
+}
+
+// usageEventBatchRequest is the request body expected by the batchUsageEvent API: the same
+// per-event fields as usageEvent, submitted together so a rejected line doesn't discard the rest.
+type usageEventBatchRequest struct {
+	Request []usageEvent `json:"request"`
+}
+
+// usageEventResult is one line of a batchUsageEvent response, echoing back enough of the request
+// to correlate it with the usageEvent that produced it. Status is "Accepted" or "Duplicate" on
+// success; any other value means the line was rejected, with Code/Message describing why.
+type usageEventResult struct {
+	ResourceID string `json:"resourceId"`
+	Dimension  string `json:"dimension"`
+	Status     string `json:"status"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+type usageEventBatchResponse struct {
+	Result []usageEventResult `json:"result"`
+}
+
+// endpointErrorsFrom converts the rejected lines in a batchUsageEvent response into an
+// EndpointErrors error. "Accepted" and "Duplicate" (a retried send landing on an event the service
+// already recorded) are treated as success; every other status is a rejection.
+func endpointErrorsFrom(results []usageEventResult) EndpointErrors {
+	var out EndpointErrors
+	for _, r := range results {
+		switch r.Status {
+		case "Accepted", "Duplicate":
+			continue
+		default:
+			out = append(out, &EndpointError{ResourceID: r.ResourceID, Dimension: r.Dimension, Code: r.Code, Message: r.Message})
+		}
+	}
+	return out
+}
+
+// EndpointError describes one usage event's rejection within an otherwise successful
+// batchUsageEvent response. ResourceID and Dimension identify which request line it corresponds
+// to, matching the ordering batchUsageEvent guarantees between its request and result arrays.
+type EndpointError struct {
+	ResourceID string
+	Dimension  string
+	Code       string
+	Message    string
+}
+
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("resource %v/%v: %v: %v", e.ResourceID, e.Dimension, e.Code, e.Message)
+}
+
+// EndpointErrors collects the per-event rejections from a single batchUsageEvent call's response.
+// Azure documents no way to resend only the rejected lines within a batch - a batchUsageEvent
+// response only tells us which lines were rejected, not a way to retry them in isolation - so
+// isTransientUsageEventError never treats an EndpointErrors as transient, meaning a single bad
+// line fails the whole batch rather than retrying it forever.
+type EndpointErrors []*EndpointError
+
+func (e EndpointErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, ee := range e {
+		msgs[i] = ee.Error()
+	}
+	return fmt.Sprintf("azuremarketplace: %v usage event(s) rejected: %v", len(e), strings.Join(msgs, "; "))
+}
+
+func (ep *AzureMarketplaceEndpoint) format(r pipeline.EndpointReport) usageEvent {
+	var quantity float64
+	if r.Value.Int64Value != nil {
+		quantity = float64(*r.Value.Int64Value)
+	} else if r.Value.DoubleValue != nil {
+		quantity = *r.Value.DoubleValue
+	}
+	return usageEvent{
+		ResourceID:         r.Labels[resourceIDLabel],
+		Quantity:           quantity,
+		Dimension:          r.Name,
+		EffectiveStartTime: r.StartTime.UTC().Format(time.RFC3339),
+		PlanID:             ep.planId,
+	}
+}
+
+// usageEventError is returned for a non-2xx, non-409 usageEvent response.
+type usageEventError struct {
+	statusCode int
+	body       string
+	header     http.Header
+}
+
+func (e *usageEventError) Error() string {
+	return fmt.Sprintf("azuremarketplace: usage event rejected: status %v: %v", e.statusCode, e.body)
+}
+
+// Use is a no-op. AzureMarketplaceEndpoint doesn't track usage.
+func (ep *AzureMarketplaceEndpoint) Use() {}
+
+// Release is a no-op. AzureMarketplaceEndpoint doesn't track usage.
+func (ep *AzureMarketplaceEndpoint) Release() error {
+	return nil
+}
+
+// IsTransient reports true for 429 and 5xx usageEvent responses, and for any error that isn't a
+// recognized usageEventError or EndpointErrors (e.g. a connection-level failure). 400/401/403 are
+// treated as terminal, since retrying them can't succeed without a configuration change, as is an
+// EndpointErrors from a rejected batchUsageEvent line.
+func (ep *AzureMarketplaceEndpoint) IsTransient(err error) bool {
+	return isTransientUsageEventError(err)
+}
+
+func isTransientUsageEventError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if _, ok := err.(EndpointErrors); ok {
+		return false
+	}
+	ue, ok := err.(*usageEventError)
+	if !ok {
+		return true
+	}
+	return ue.statusCode == http.StatusTooManyRequests || (ue.statusCode >= 500 && ue.statusCode < 600)
+}
+
+// classifyUsageEventError is a retry.Classifier for usageEvent responses: the same status-code
+// classification IsTransient uses, plus any Retry-After header on the rejected response, which
+// overrides the policy's own backoff delay.
+func classifyUsageEventError(err error) (bool, time.Duration) {
+	if !isTransientUsageEventError(err) {
+		return false, 0
+	}
+	if ue, ok := err.(*usageEventError); ok {
+		if d, ok := retry.RetryAfter(ue.header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azuremarketplace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+func TestAzureMarketplaceEndpoint_Format(t *testing.T) {
+	ep := &AzureMarketplaceEndpoint{name: "azure", planId: "plan1"}
+	quantity := int64(5)
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		Id: "report1",
+		MetricReport: metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			EndTime:   time.Date(2020, 1, 2, 4, 4, 5, 0, time.UTC),
+			Labels:    map[string]string{resourceIDLabel: "resource1"},
+			Value:     metrics.MetricValue{Int64Value: &quantity},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+
+	event := ep.format(report)
+	if event.ResourceID != "resource1" {
+		t.Errorf("expected resourceId 'resource1', got: %v", event.ResourceID)
+	}
+	if event.Dimension != "requests" {
+		t.Errorf("expected dimension 'requests', got: %v", event.Dimension)
+	}
+	if event.Quantity != 5 {
+		t.Errorf("expected quantity 5, got: %v", event.Quantity)
+	}
+	if event.PlanID != "plan1" {
+		t.Errorf("expected planId 'plan1', got: %v", event.PlanID)
+	}
+	if event.EffectiveStartTime != "2020-01-02T03:04:05Z" {
+		t.Errorf("expected effectiveStartTime '2020-01-02T03:04:05Z', got: %v", event.EffectiveStartTime)
+	}
+}
+
+func TestAzureMarketplaceEndpoint_IsTransient(t *testing.T) {
+	ep := &AzureMarketplaceEndpoint{}
+
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"unrecognized error", errors.New("connection refused"), true},
+		{"400", &usageEventError{statusCode: http.StatusBadRequest}, false},
+		{"401", &usageEventError{statusCode: http.StatusUnauthorized}, false},
+		{"403", &usageEventError{statusCode: http.StatusForbidden}, false},
+		{"429", &usageEventError{statusCode: http.StatusTooManyRequests}, true},
+		{"500", &usageEventError{statusCode: http.StatusInternalServerError}, true},
+		{"503", &usageEventError{statusCode: http.StatusServiceUnavailable}, true},
+		{"EndpointErrors", EndpointErrors{{ResourceID: "resource1", Dimension: "requests", Code: "BadArgument"}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ep.IsTransient(c.err); got != c.transient {
+				t.Errorf("IsTransient(%v) = %v, expected %v", c.err, got, c.transient)
+			}
+		})
+	}
+}
+
+func TestEndpointErrorsFrom(t *testing.T) {
+	errs := endpointErrorsFrom([]usageEventResult{
+		{ResourceID: "resource1", Dimension: "requests", Status: "Accepted"},
+		{ResourceID: "resource2", Dimension: "requests", Status: "Duplicate"},
+		{ResourceID: "resource3", Dimension: "requests", Status: "Error", Code: "BadArgument", Message: "quantity must be positive"},
+	})
+
+	if len(errs) != 1 {
+		t.Fatalf("len(errs) = %v, want 1", len(errs))
+	}
+	if errs[0].ResourceID != "resource3" || errs[0].Code != "BadArgument" {
+		t.Errorf("errs[0] = %+v, want rejected resource3/BadArgument", errs[0])
+	}
+}
+
+var _ pipeline.Endpoint = (*AzureMarketplaceEndpoint)(nil)
+var _ pipeline.BatchEndpoint = (*AzureMarketplaceEndpoint)(nil)
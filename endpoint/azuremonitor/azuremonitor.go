@@ -0,0 +1,246 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package azuremonitor implements a pipeline.Endpoint that pushes aggregated reports to Azure
+// Monitor as custom-metric data points, letting the agent meter workloads running on Azure
+// alongside Azure Marketplace metering and Cloud Monitoring on GCP.
+package azuremonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/identity"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// monitorResourceID identifies the Azure Monitor custom-metrics ingestion API to AAD.
+	monitorResourceID = "https://monitor.azure.com/"
+
+	// ingestionURLTemplate is the regional Azure Monitor custom-metrics ingestion endpoint.
+	ingestionURLTemplate = "https://%v.monitoring.azure.com%v/metrics"
+
+	timeout = 60 * time.Second
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 250 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
+)
+
+// AzureMonitorEndpoint is a pipeline.Endpoint that publishes each report as a custom-metric data
+// point attached to a fixed Azure resource.
+type AzureMonitorEndpoint struct {
+	name         string
+	ingestionURL string
+	namespace    string
+	client       *http.Client
+	tokens       oauth2.TokenSource
+	retry        retrypolicy.RetryPolicy
+	clock        clock.Clock
+}
+
+// NewAzureMonitorEndpoint creates a new AzureMonitorEndpoint, authenticating to AAD as azure (via
+// client secret, signed certificate assertion, federated token file, or the Azure Instance
+// Metadata Service, depending on how azure is configured). backoff configures the endpoint's
+// in-process retry policy; a nil value selects the built-in defaults.
+func NewAzureMonitorEndpoint(name string, cfg config.AzureMonitorEndpoint, azure *config.AzureIdentity) *AzureMonitorEndpoint {
+	url := fmt.Sprintf(ingestionURLTemplate, cfg.Region, cfg.ResourceId)
+	return newAzureMonitorEndpoint(name, cfg, url, identity.NewAzureTokenSource(azure, monitorResourceID), &http.Client{Timeout: timeout}, clock.NewClock())
+}
+
+func newAzureMonitorEndpoint(name string, cfg config.AzureMonitorEndpoint, url string, tokens oauth2.TokenSource, client *http.Client, clk clock.Clock) *AzureMonitorEndpoint {
+	return &AzureMonitorEndpoint{
+		name:         name,
+		ingestionURL: url,
+		namespace:    cfg.Namespace,
+		client:       client,
+		tokens:       tokens,
+		retry:        retry.NewPolicy(cfg.Backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:        clk,
+	}
+}
+
+func (ep *AzureMonitorEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *AzureMonitorEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *AzureMonitorEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *AzureMonitorEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	body, err := json.Marshal(ep.format(r))
+	if err != nil {
+		return err
+	}
+	return retry.Do(ctx, ep.clock, ep.retry, classifyIngestionError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("AzureMonitorEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		return ep.sendMetric(ctx, body)
+	})
+}
+
+func (ep *AzureMonitorEndpoint) sendMetric(ctx context.Context, body []byte) error {
+	token, err := ep.tokens.Token()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ep.ingestionURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	token.SetAuthHeader(req)
+
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return &ingestionError{statusCode: resp.StatusCode, body: string(respBody), header: resp.Header}
+}
+
+// metricData is the request body expected by the Azure Monitor custom-metrics ingestion API.
+type metricData struct {
+	Time string          `json:"time"`
+	Data metricDataInner `json:"data"`
+}
+
+type metricDataInner struct {
+	BaseData metricBaseData `json:"baseData"`
+}
+
+type metricBaseData struct {
+	Metric    string         `json:"metric"`
+	Namespace string         `json:"namespace"`
+	DimNames  []string       `json:"dimNames,omitempty"`
+	Series    []metricSeries `json:"series"`
+}
+
+type metricSeries struct {
+	DimValues []string `json:"dimValues,omitempty"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Sum       float64  `json:"sum"`
+	Count     int      `json:"count"`
+}
+
+func (ep *AzureMonitorEndpoint) format(r pipeline.EndpointReport) metricData {
+	var value float64
+	if r.Value.Int64Value != nil {
+		value = float64(*r.Value.Int64Value)
+	} else if r.Value.DoubleValue != nil {
+		value = *r.Value.DoubleValue
+	}
+
+	var dimNames, dimValues []string
+	for k, v := range r.Labels {
+		dimNames = append(dimNames, k)
+		dimValues = append(dimValues, v)
+	}
+
+	return metricData{
+		Time: r.EndTime.UTC().Format(time.RFC3339),
+		Data: metricDataInner{
+			BaseData: metricBaseData{
+				Metric:    r.Name,
+				Namespace: ep.namespace,
+				DimNames:  dimNames,
+				Series: []metricSeries{
+					{DimValues: dimValues, Min: value, Max: value, Sum: value, Count: 1},
+				},
+			},
+		},
+	}
+}
+
+// ingestionError is returned for a non-2xx custom-metrics ingestion response.
+type ingestionError struct {
+	statusCode int
+	body       string
+	header     http.Header
+}
+
+func (e *ingestionError) Error() string {
+	return fmt.Sprintf("azuremonitor: metric ingestion rejected: status %v: %v", e.statusCode, e.body)
+}
+
+// Use is a no-op. AzureMonitorEndpoint doesn't track usage.
+func (ep *AzureMonitorEndpoint) Use() {}
+
+// Release is a no-op. AzureMonitorEndpoint doesn't track usage.
+func (ep *AzureMonitorEndpoint) Release() error {
+	return nil
+}
+
+// IsTransient reports true for 429 and 5xx ingestion responses, and for any error that isn't a
+// recognized ingestionError (e.g. a connection-level failure). 400/401/403 are treated as
+// terminal, since retrying them can't succeed without a configuration change.
+func (ep *AzureMonitorEndpoint) IsTransient(err error) bool {
+	return isTransientIngestionError(err)
+}
+
+func isTransientIngestionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	ie, ok := err.(*ingestionError)
+	if !ok {
+		return true
+	}
+	return ie.statusCode == http.StatusTooManyRequests || (ie.statusCode >= 500 && ie.statusCode < 600)
+}
+
+// classifyIngestionError is a retry.Classifier for custom-metrics ingestion responses: the same
+// status-code classification IsTransient uses, plus any Retry-After header on the rejected
+// response, which overrides the policy's own backoff delay.
+func classifyIngestionError(err error) (bool, time.Duration) {
+	if !isTransientIngestionError(err) {
+		return false, 0
+	}
+	if ie, ok := err.(*ingestionError); ok {
+		if d, ok := retry.RetryAfter(ie.header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
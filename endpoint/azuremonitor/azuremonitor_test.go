@@ -0,0 +1,119 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azuremonitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"golang.org/x/oauth2"
+)
+
+type recordingHandler struct {
+	body metricData
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(body, &h.body)
+	w.WriteHeader(http.StatusOK)
+}
+
+type staticTokenSource struct{}
+
+func (staticTokenSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "test-token"}, nil
+}
+
+func newTestEndpoint(handler http.Handler, cfg config.AzureMonitorEndpoint) (*AzureMonitorEndpoint, *httptest.Server) {
+	ts := httptest.NewServer(handler)
+	cfg.Namespace = "MyApp/Usage"
+	return newAzureMonitorEndpoint("azuremonitor", cfg, ts.URL, staticTokenSource{}, ts.Client(), clock.NewClock()), ts
+}
+
+func TestAzureMonitorEndpoint_SendContents(t *testing.T) {
+	handler := &recordingHandler{}
+	ep, ts := newTestEndpoint(handler, config.AzureMonitorEndpoint{})
+	defer ts.Close()
+
+	value := int64(42)
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"region": "westus"},
+			Value:     metrics.MetricValue{Int64Value: &value},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+	if err := ep.Send(report); err != nil {
+		t.Fatalf("error sending report: %+v", err)
+	}
+
+	base := handler.body.Data.BaseData
+	if want, got := "MyApp/Usage", base.Namespace; want != got {
+		t.Errorf("Namespace: want=%v, got=%v", want, got)
+	}
+	if want, got := "requests", base.Metric; want != got {
+		t.Errorf("Metric: want=%v, got=%v", want, got)
+	}
+	if len(base.Series) != 1 {
+		t.Fatalf("expected 1 series, got %v", len(base.Series))
+	}
+	series := base.Series[0]
+	if want, got := 42.0, series.Sum; want != got {
+		t.Errorf("Sum: want=%v, got=%v", want, got)
+	}
+	if want, got := 1, series.Count; want != got {
+		t.Errorf("Count: want=%v, got=%v", want, got)
+	}
+	if len(base.DimNames) != 1 || base.DimNames[0] != "region" {
+		t.Errorf("DimNames: want=[region], got=%v", base.DimNames)
+	}
+}
+
+func TestIsTransientIngestionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"non-ingestionError", errors.New("connection refused"), true},
+		{"http 429", &ingestionError{statusCode: http.StatusTooManyRequests}, true},
+		{"http 503", &ingestionError{statusCode: http.StatusServiceUnavailable}, true},
+		{"http 400", &ingestionError{statusCode: http.StatusBadRequest}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientIngestionError(tt.err); got != tt.want {
+				t.Errorf("isTransientIngestionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
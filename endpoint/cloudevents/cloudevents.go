@@ -0,0 +1,248 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cloudevents implements a pipeline.Endpoint that forwards aggregated metrics as CNCF
+// CloudEvents 1.0 structured-mode events, delivered via a pluggable transport - an HTTP POST or an
+// MQTT PUBLISH.
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+	"github.com/golang/glog"
+)
+
+const (
+	timeout         = 60 * time.Second
+	specVersion     = "1.0"
+	contentType     = "application/cloudevents+json"
+	dataContentType = "application/json"
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 250 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
+)
+
+// CloudEventsEndpoint forwards aggregated metrics as CloudEvents 1.0 structured-mode events,
+// delivered via its configured transport.
+type CloudEventsEndpoint struct {
+	name      string
+	cfg       config.CloudEventsEndpoint
+	transport transport
+	retry     retrypolicy.RetryPolicy
+	clock     clock.Clock
+}
+
+// transport delivers a single formatted CloudEvents event body to a sink.
+type transport interface {
+	send(ctx context.Context, body []byte) error
+}
+
+// NewCloudEventsEndpoint creates a new CloudEventsEndpoint from cfg.
+func NewCloudEventsEndpoint(name string, cfg config.CloudEventsEndpoint) *CloudEventsEndpoint {
+	return &CloudEventsEndpoint{
+		name:      name,
+		cfg:       cfg,
+		transport: newTransport(cfg),
+		retry:     retry.NewPolicy(cfg.Backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:     clock.NewClock(),
+	}
+}
+
+// newTransport builds the transport selected by cfg.Transport.
+func newTransport(cfg config.CloudEventsEndpoint) transport {
+	if cfg.Transport == "mqtt" {
+		mqtt := cfg.MQTT
+		if mqtt == nil {
+			mqtt = &config.MQTTTransport{}
+		}
+		return &mqttTransport{
+			broker:   cfg.Endpoint,
+			topic:    mqtt.Topic,
+			clientId: mqtt.ClientId,
+			username: mqtt.Username,
+			password: mqtt.Password,
+		}
+	}
+	return &httpTransport{
+		client:  &http.Client{Timeout: timeout},
+		url:     cfg.Endpoint,
+		headers: cfg.Headers,
+	}
+}
+
+func (ep *CloudEventsEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *CloudEventsEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *CloudEventsEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *CloudEventsEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	body, err := json.Marshal(ep.format(r))
+	if err != nil {
+		return err
+	}
+	return retry.Do(ctx, ep.clock, ep.retry, classifySendError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("CloudEventsEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		return ep.transport.send(ctx, body)
+	})
+}
+
+// httpTransport delivers events with an HTTP POST, structured mode.
+type httpTransport struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+}
+
+func (t *httpTransport) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return &sendError{statusCode: resp.StatusCode, body: string(respBody), header: resp.Header}
+	}
+	return nil
+}
+
+// cloudEvent is the CNCF CloudEvents 1.0 structured-mode JSON envelope this endpoint sends.
+type cloudEvent struct {
+	Id              string         `json:"id"`
+	Source          string         `json:"source"`
+	SpecVersion     string         `json:"specversion"`
+	Type            string         `json:"type"`
+	Time            string         `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            cloudEventData `json:"data"`
+}
+
+// cloudEventData is the payload carried by every event this endpoint sends.
+type cloudEventData struct {
+	Value     interface{}       `json:"value"`
+	StartTime string            `json:"start_time"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// format maps a single EndpointReport onto a CloudEvents 1.0 envelope. The event's id is taken
+// from the report's own Id, which is stable across retries, rather than minted fresh on each
+// send, so that a downstream consumer can dedupe retried deliveries.
+func (ep *CloudEventsEndpoint) format(r pipeline.EndpointReport) cloudEvent {
+	var value interface{}
+	if r.Value.Int64Value != nil {
+		value = *r.Value.Int64Value
+	} else if r.Value.DoubleValue != nil {
+		value = *r.Value.DoubleValue
+	}
+	return cloudEvent{
+		Id:              r.Id,
+		Source:          ep.cfg.Source,
+		SpecVersion:     specVersion,
+		Type:            ep.cfg.TypePrefix + r.Name,
+		Time:            r.EndTime.UTC().Format(time.RFC3339Nano),
+		DataContentType: dataContentType,
+		Data: cloudEventData{
+			Value:     value,
+			StartTime: r.StartTime.UTC().Format(time.RFC3339Nano),
+			Labels:    r.Labels,
+		},
+	}
+}
+
+// sendError is returned for a non-2xx event delivery response.
+type sendError struct {
+	statusCode int
+	body       string
+	header     http.Header
+}
+
+func (e *sendError) Error() string {
+	return fmt.Sprintf("cloudevents: event rejected: status %v: %v", e.statusCode, e.body)
+}
+
+// Use is a no-op. CloudEventsEndpoint doesn't track usage.
+func (ep *CloudEventsEndpoint) Use() {}
+
+// Release is a no-op. CloudEventsEndpoint doesn't track usage.
+func (ep *CloudEventsEndpoint) Release() error {
+	return nil
+}
+
+// IsTransient reports true for 429 and 5xx event delivery responses, and for any error that isn't
+// a recognized sendError (e.g. a connection-level failure).
+func (ep *CloudEventsEndpoint) IsTransient(err error) bool {
+	return isTransientSendError(err)
+}
+
+func isTransientSendError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	se, ok := err.(*sendError)
+	if !ok {
+		return true
+	}
+	return se.statusCode == http.StatusTooManyRequests || (se.statusCode >= 500 && se.statusCode < 600)
+}
+
+// classifySendError is a retry.Classifier for event delivery responses: the same classification
+// IsTransient uses, plus any Retry-After header on a rejected response, which overrides the
+// policy's own backoff delay.
+func classifySendError(err error) (bool, time.Duration) {
+	if !isTransientSendError(err) {
+		return false, 0
+	}
+	if se, ok := err.(*sendError); ok {
+		if d, ok := retry.RetryAfter(se.header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
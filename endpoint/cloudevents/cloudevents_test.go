@@ -0,0 +1,91 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+var _ pipeline.Endpoint = (*CloudEventsEndpoint)(nil)
+
+func TestCloudEventsEndpoint_Format(t *testing.T) {
+	ep := NewCloudEventsEndpoint("test", config.CloudEventsEndpoint{
+		Source:     "urn:ubbagent:test",
+		TypePrefix: "com.example.",
+	})
+	quantity := int64(42)
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		Id: "report1",
+		MetricReport: metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"region": "us-east1"},
+			Value:     metrics.MetricValue{Int64Value: &quantity},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+
+	ev := ep.format(report)
+	if ev.Id != "report1" {
+		t.Errorf("expected id report1, got: %v", ev.Id)
+	}
+	if ev.Source != "urn:ubbagent:test" {
+		t.Errorf("unexpected source: %v", ev.Source)
+	}
+	if ev.Type != "com.example.requests" {
+		t.Errorf("unexpected type: %v", ev.Type)
+	}
+	if ev.Data.Value != int64(42) {
+		t.Errorf("unexpected value: %v", ev.Data.Value)
+	}
+	if ev.Data.Labels["region"] != "us-east1" {
+		t.Errorf("unexpected labels: %+v", ev.Data.Labels)
+	}
+}
+
+func TestCloudEventsEndpoint_IsTransient(t *testing.T) {
+	ep := &CloudEventsEndpoint{}
+
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"non-sendError", errors.New("connection refused"), true},
+		{"http 429", &sendError{statusCode: http.StatusTooManyRequests}, true},
+		{"http 503", &sendError{statusCode: http.StatusServiceUnavailable}, true},
+		{"http 400", &sendError{statusCode: http.StatusBadRequest}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ep.IsTransient(c.err); got != c.transient {
+				t.Errorf("IsTransient(%v) = %v, expected %v", c.err, got, c.transient)
+			}
+		})
+	}
+}
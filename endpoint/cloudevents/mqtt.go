@@ -0,0 +1,172 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// mqttTransport delivers events with an MQTT PUBLISH at QoS 0 (fire-and-forget). It speaks just
+// enough of MQTT 3.1.1 (CONNECT, CONNACK, PUBLISH, DISCONNECT) to hand an event to a broker,
+// without pulling in a full client library.
+type mqttTransport struct {
+	broker   string
+	topic    string
+	clientId string
+	username string
+	password string
+}
+
+func (t *mqttTransport) send(ctx context.Context, body []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.broker)
+	if err != nil {
+		return fmt.Errorf("cloudevents: mqtt: dial: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := mqttConnect(conn, t.clientId, t.username, t.password); err != nil {
+		return fmt.Errorf("cloudevents: mqtt: connect: %v", err)
+	}
+	if err := mqttPublish(conn, t.topic, body); err != nil {
+		return fmt.Errorf("cloudevents: mqtt: publish: %v", err)
+	}
+	// Best-effort: a failure to cleanly disconnect doesn't mean the publish above was lost.
+	_ = mqttDisconnect(conn)
+	return nil
+}
+
+// MQTT 3.1.1 fixed-header packet types, shifted into the high nibble of the first header byte.
+const (
+	mqttPktConnect     = 1 << 4
+	mqttPktConnAck     = 2 << 4
+	mqttPktPublish     = 3 << 4
+	mqttPktDisconnect  = 14 << 4
+	mqttProtocolLevel4 = 4
+
+	mqttConnectFlagUsername = 1 << 7
+	mqttConnectFlagPassword = 1 << 6
+	mqttConnectFlagClean    = 1 << 1
+)
+
+// mqttConnect sends a CONNECT packet and waits for the broker's CONNACK, returning an error if the
+// broker refuses the connection.
+func mqttConnect(conn net.Conn, clientId, username, password string) error {
+	var varHeader []byte
+	varHeader = append(varHeader, mqttEncodeString("MQTT")...)
+	varHeader = append(varHeader, mqttProtocolLevel4)
+
+	var flags byte = mqttConnectFlagClean
+	if username != "" {
+		flags |= mqttConnectFlagUsername
+		if password != "" {
+			flags |= mqttConnectFlagPassword
+		}
+	}
+	varHeader = append(varHeader, flags)
+	varHeader = append(varHeader, 0, 0) // Keep-alive: none. This connection is used once.
+
+	var payload []byte
+	payload = append(payload, mqttEncodeString(clientId)...)
+	if username != "" {
+		payload = append(payload, mqttEncodeString(username)...)
+		if password != "" {
+			payload = append(payload, mqttEncodeString(password)...)
+		}
+	}
+
+	if err := mqttWritePacket(conn, mqttPktConnect, append(varHeader, payload...)); err != nil {
+		return err
+	}
+
+	ack := make([]byte, 4)
+	if _, err := readFull(conn, ack); err != nil {
+		return fmt.Errorf("reading CONNACK: %v", err)
+	}
+	if ack[0] != mqttPktConnAck {
+		return fmt.Errorf("unexpected packet type %#x waiting for CONNACK", ack[0])
+	}
+	if returnCode := ack[3]; returnCode != 0 {
+		return fmt.Errorf("broker refused connection, return code %v", returnCode)
+	}
+	return nil
+}
+
+// mqttPublish sends a QoS-0 PUBLISH packet carrying body to topic. QoS 0 has no acknowledgement,
+// so this returns as soon as the packet is written.
+func mqttPublish(conn net.Conn, topic string, body []byte) error {
+	varHeader := mqttEncodeString(topic) // No packet identifier: QoS 0 doesn't use one.
+	return mqttWritePacket(conn, mqttPktPublish, append(varHeader, body...))
+}
+
+// mqttDisconnect sends a DISCONNECT packet, the graceful way to end an MQTT session.
+func mqttDisconnect(conn net.Conn) error {
+	return mqttWritePacket(conn, mqttPktDisconnect, nil)
+}
+
+// mqttWritePacket writes a fixed header (packet type plus encoded remaining length) followed by
+// the given variable header and payload, already concatenated by the caller.
+func mqttWritePacket(conn net.Conn, packetType byte, rest []byte) error {
+	packet := append([]byte{packetType}, mqttEncodeRemainingLength(len(rest))...)
+	packet = append(packet, rest...)
+	_, err := conn.Write(packet)
+	return err
+}
+
+// mqttEncodeString encodes s as an MQTT UTF-8 string: a two-byte big-endian length prefix
+// followed by the bytes of s.
+func mqttEncodeString(s string) []byte {
+	b := make([]byte, 2+len(s))
+	b[0] = byte(len(s) >> 8)
+	b[1] = byte(len(s))
+	copy(b[2:], s)
+	return b
+}
+
+// mqttEncodeRemainingLength encodes n using the MQTT variable-length integer scheme (up to four
+// 7-bit-per-byte groups, continuation bit set on all but the last).
+func mqttEncodeRemainingLength(n int) []byte {
+	var b []byte
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return b
+}
+
+// readFull reads exactly len(buf) bytes from conn.
+func readFull(conn net.Conn, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := conn.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeBroker is a minimal MQTT 3.1.1 broker stand-in: it accepts one connection, replies to
+// CONNECT with the given CONNACK return code, and - if the connection is accepted - captures the
+// topic and payload of the first PUBLISH it receives.
+type fakeBroker struct {
+	listener   net.Listener
+	returnCode byte
+
+	published chan publishedMessage
+}
+
+type publishedMessage struct {
+	topic   string
+	payload []byte
+}
+
+func newFakeBroker(t *testing.T, returnCode byte) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake broker: %v", err)
+	}
+	b := &fakeBroker{listener: l, returnCode: returnCode, published: make(chan publishedMessage, 1)}
+	go b.serveOne()
+	return b
+}
+
+func (b *fakeBroker) serveOne() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Read and discard the CONNECT packet: fixed header (type + remaining length) then that many
+	// bytes of variable header/payload.
+	if _, err := readFull(conn, make([]byte, 1)); err != nil {
+		return
+	}
+	remaining, err := readRemainingLength(conn)
+	if err != nil {
+		return
+	}
+	if _, err := readFull(conn, make([]byte, remaining)); err != nil {
+		return
+	}
+
+	if _, err := conn.Write([]byte{mqttPktConnAck, 2, 0, b.returnCode}); err != nil {
+		return
+	}
+	if b.returnCode != 0 {
+		return
+	}
+
+	// Read the PUBLISH packet.
+	header := make([]byte, 1)
+	if _, err := readFull(conn, header); err != nil {
+		return
+	}
+	remaining, err = readRemainingLength(conn)
+	if err != nil {
+		return
+	}
+	rest := make([]byte, remaining)
+	if _, err := readFull(conn, rest); err != nil {
+		return
+	}
+	topicLen := int(rest[0])<<8 | int(rest[1])
+	b.published <- publishedMessage{topic: string(rest[2 : 2+topicLen]), payload: rest[2+topicLen:]}
+}
+
+func readRemainingLength(conn net.Conn) (int, error) {
+	multiplier := 1
+	value := 0
+	for {
+		b := make([]byte, 1)
+		if _, err := readFull(conn, b); err != nil {
+			return 0, err
+		}
+		value += int(b[0]&0x7f) * multiplier
+		if b[0]&0x80 == 0 {
+			return value, nil
+		}
+		multiplier *= 128
+	}
+}
+
+func TestMQTTTransport_Send(t *testing.T) {
+	broker := newFakeBroker(t, 0)
+	defer broker.listener.Close()
+
+	tr := &mqttTransport{broker: broker.listener.Addr().String(), topic: "ubbagent/test", clientId: "ubbagent"}
+	if err := tr.send(context.Background(), []byte(`{"id":"report1"}`)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	select {
+	case msg := <-broker.published:
+		if msg.topic != "ubbagent/test" {
+			t.Errorf("unexpected topic: %v", msg.topic)
+		}
+		if string(msg.payload) != `{"id":"report1"}` {
+			t.Errorf("unexpected payload: %v", string(msg.payload))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for broker to receive PUBLISH")
+	}
+}
+
+func TestMQTTTransport_Send_ConnectionRefused(t *testing.T) {
+	broker := newFakeBroker(t, 5) // 5: "not authorized", per the MQTT 3.1.1 spec.
+	defer broker.listener.Close()
+
+	tr := &mqttTransport{broker: broker.listener.Addr().String(), topic: "ubbagent/test"}
+	if err := tr.send(context.Background(), []byte("{}")); err == nil {
+		t.Fatal("expected an error from a refused CONNECT, got nil")
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cloudwatch implements a pipeline.Endpoint that pushes aggregated reports to Amazon
+// CloudWatch as PutMetricData calls, letting the agent meter workloads running on AWS alongside
+// Cloud Monitoring on GCP and Azure Monitor on Azure.
+package cloudwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+	"github.com/golang/glog"
+)
+
+const (
+	timeout = 60 * time.Second
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 250 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
+)
+
+// CloudWatchEndpoint is a pipeline.Endpoint that writes each report as a PutMetricData call under
+// a fixed namespace.
+type CloudWatchEndpoint struct {
+	name      string
+	namespace string
+	region    string
+	url       string
+	units     map[string]string
+	creds     credentialsSource
+	client    *http.Client
+	retry     retrypolicy.RetryPolicy
+	clock     clock.Clock
+}
+
+// NewCloudWatchEndpoint creates a new CloudWatchEndpoint, authenticating to AWS as aws (via a
+// static access key pair, or by assuming aws.RoleArn using an ambient bootstrap identity).
+// backoff configures the endpoint's in-process retry policy; a nil value selects the built-in
+// defaults.
+func NewCloudWatchEndpoint(name string, cfg config.CloudWatchEndpoint, aws *config.AWSIdentity) *CloudWatchEndpoint {
+	client := &http.Client{Timeout: timeout}
+	url := fmt.Sprintf("https://monitoring.%v.amazonaws.com/", aws.Region)
+	return newCloudWatchEndpoint(name, cfg, aws.Region, url, newCredentialsSource(aws, client), client, clock.NewClock())
+}
+
+func newCloudWatchEndpoint(name string, cfg config.CloudWatchEndpoint, region, url string, creds credentialsSource, client *http.Client, clk clock.Clock) *CloudWatchEndpoint {
+	units := make(map[string]string, len(cfg.Metrics))
+	for _, m := range cfg.Metrics {
+		if m.Unit != "" {
+			units[m.Metric] = m.Unit
+		}
+	}
+	return &CloudWatchEndpoint{
+		name:      name,
+		namespace: cfg.Namespace,
+		region:    region,
+		url:       url,
+		units:     units,
+		creds:     creds,
+		client:    client,
+		retry:     retry.NewPolicy(cfg.Backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:     clk,
+	}
+}
+
+func (ep *CloudWatchEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *CloudWatchEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *CloudWatchEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *CloudWatchEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	values := ep.format(r)
+	return retry.Do(ctx, ep.clock, ep.retry, classifyCloudWatchError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("CloudWatchEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		return ep.putMetricData(ctx, values)
+	})
+}
+
+func (ep *CloudWatchEndpoint) putMetricData(ctx context.Context, values url.Values) error {
+	creds, err := ep.creds.Credentials()
+	if err != nil {
+		return err
+	}
+	body := []byte(values.Encode())
+	req, err := http.NewRequest(http.MethodPost, ep.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signSigV4(req, body, creds, "monitoring", ep.region, time.Now())
+
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return &cloudWatchError{statusCode: resp.StatusCode, body: string(respBody), header: resp.Header}
+}
+
+// format builds the PutMetricData query parameters for r, under this endpoint's namespace.
+func (ep *CloudWatchEndpoint) format(r pipeline.EndpointReport) url.Values {
+	var value float64
+	if r.Value.Int64Value != nil {
+		value = float64(*r.Value.Int64Value)
+	} else if r.Value.DoubleValue != nil {
+		value = *r.Value.DoubleValue
+	}
+
+	values := url.Values{
+		"Action":                         {"PutMetricData"},
+		"Version":                        {"2010-08-01"},
+		"Namespace":                      {ep.namespace},
+		"MetricData.member.1.MetricName": {r.Name},
+		"MetricData.member.1.Value":      {strconv.FormatFloat(value, 'g', -1, 64)},
+		"MetricData.member.1.Timestamp":  {r.EndTime.UTC().Format(time.RFC3339)},
+		"MetricData.member.1.Unit":       {ep.unitFor(r.Name)},
+	}
+
+	i := 1
+	for k, v := range r.Labels {
+		values.Set(fmt.Sprintf("MetricData.member.1.Dimensions.member.%d.Name", i), k)
+		values.Set(fmt.Sprintf("MetricData.member.1.Dimensions.member.%d.Value", i), v)
+		i++
+	}
+	return values
+}
+
+// unitFor returns the configured CloudWatch unit for metric, defaulting to "None" when it has no
+// configured metadata.
+func (ep *CloudWatchEndpoint) unitFor(metric string) string {
+	if u, ok := ep.units[metric]; ok {
+		return u
+	}
+	return "None"
+}
+
+// cloudWatchErrorResponse mirrors the XML error body CloudWatch's Query API returns.
+type cloudWatchErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Error   struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+// cloudWatchError is returned for a non-2xx PutMetricData response.
+type cloudWatchError struct {
+	statusCode int
+	body       string
+	header     http.Header
+}
+
+func (e *cloudWatchError) Error() string {
+	var er cloudWatchErrorResponse
+	if xml.Unmarshal([]byte(e.body), &er) == nil && er.Error.Code != "" {
+		return fmt.Sprintf("cloudwatch: PutMetricData rejected: %v: %v", er.Error.Code, er.Error.Message)
+	}
+	return fmt.Sprintf("cloudwatch: PutMetricData rejected: status %v: %v", e.statusCode, e.body)
+}
+
+// Use is a no-op. CloudWatchEndpoint doesn't track usage.
+func (ep *CloudWatchEndpoint) Use() {}
+
+// Release is a no-op. CloudWatchEndpoint doesn't track usage.
+func (ep *CloudWatchEndpoint) Release() error {
+	return nil
+}
+
+// IsTransient reports true for throttling and 5xx PutMetricData responses, and for any error
+// that isn't a recognized cloudWatchError (e.g. a connection-level failure).
+func (ep *CloudWatchEndpoint) IsTransient(err error) bool {
+	return isTransientCloudWatchError(err)
+}
+
+func isTransientCloudWatchError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	ce, ok := err.(*cloudWatchError)
+	if !ok {
+		return true
+	}
+	return ce.statusCode == http.StatusTooManyRequests || (ce.statusCode >= 500 && ce.statusCode < 600)
+}
+
+// classifyCloudWatchError is a retry.Classifier for PutMetricData responses: the same status-code
+// classification IsTransient uses, plus any Retry-After header on the rejected response, which
+// overrides the policy's own backoff delay.
+func classifyCloudWatchError(err error) (bool, time.Duration) {
+	if !isTransientCloudWatchError(err) {
+		return false, 0
+	}
+	if ce, ok := err.(*cloudWatchError); ok {
+		if d, ok := retry.RetryAfter(ce.header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
@@ -0,0 +1,138 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+type recordingHandler struct {
+	form url.Values
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	h.form, _ = url.ParseQuery(string(body))
+	w.Write([]byte("<PutMetricDataResponse/>"))
+}
+
+func newTestEndpoint(handler http.Handler, cfg config.CloudWatchEndpoint) (*CloudWatchEndpoint, *httptest.Server) {
+	ts := httptest.NewServer(handler)
+	cfg.Namespace = "MyCompany/MyService"
+	creds := staticCredentialsSource{accessKeyId: "AKIATEST", secretAccessKey: "secret"}
+	return newCloudWatchEndpoint("cloudwatch", cfg, "us-west-2", ts.URL, creds, ts.Client(), clock.NewClock()), ts
+}
+
+func TestCloudWatchEndpoint_SendContents(t *testing.T) {
+	handler := &recordingHandler{}
+	ep, ts := newTestEndpoint(handler, config.CloudWatchEndpoint{
+		Metrics: []config.CloudWatchEndpointMetric{{Metric: "requests", Unit: "Count"}},
+	})
+	defer ts.Close()
+
+	value := int64(10)
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"region": "us"},
+			Value:     metrics.MetricValue{Int64Value: &value},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+	if err := ep.Send(report); err != nil {
+		t.Fatalf("error sending report: %+v", err)
+	}
+
+	if want, got := "PutMetricData", handler.form.Get("Action"); want != got {
+		t.Errorf("Action: want=%v, got=%v", want, got)
+	}
+	if want, got := "MyCompany/MyService", handler.form.Get("Namespace"); want != got {
+		t.Errorf("Namespace: want=%v, got=%v", want, got)
+	}
+	if want, got := "requests", handler.form.Get("MetricData.member.1.MetricName"); want != got {
+		t.Errorf("MetricName: want=%v, got=%v", want, got)
+	}
+	if want, got := "10", handler.form.Get("MetricData.member.1.Value"); want != got {
+		t.Errorf("Value: want=%v, got=%v", want, got)
+	}
+	if want, got := "Count", handler.form.Get("MetricData.member.1.Unit"); want != got {
+		t.Errorf("Unit: want=%v, got=%v", want, got)
+	}
+	if want, got := "region", handler.form.Get("MetricData.member.1.Dimensions.member.1.Name"); want != got {
+		t.Errorf("Dimensions.member.1.Name: want=%v, got=%v", want, got)
+	}
+}
+
+func TestCloudWatchEndpoint_UnitDefaultsToNone(t *testing.T) {
+	handler := &recordingHandler{}
+	ep, ts := newTestEndpoint(handler, config.CloudWatchEndpoint{})
+	defer ts.Close()
+
+	value := 5.0
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{
+			Name:      "latency",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Value:     metrics.MetricValue{DoubleValue: &value},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+	if err := ep.Send(report); err != nil {
+		t.Fatalf("error sending report: %+v", err)
+	}
+	if want, got := "None", handler.form.Get("MetricData.member.1.Unit"); want != got {
+		t.Errorf("Unit: want=%v, got=%v", want, got)
+	}
+}
+
+func TestIsTransientCloudWatchError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"non-cloudWatchError", errors.New("connection refused"), true},
+		{"http 429", &cloudWatchError{statusCode: http.StatusTooManyRequests}, true},
+		{"http 503", &cloudWatchError{statusCode: http.StatusServiceUnavailable}, true},
+		{"http 400", &cloudWatchError{statusCode: http.StatusBadRequest}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientCloudWatchError(tt.err); got != tt.want {
+				t.Errorf("isTransientCloudWatchError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudwatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+// imdsRoleURL is the EC2 Instance Metadata Service endpoint listing the role attached to the
+// instance profile, used to obtain bootstrap credentials when aws.RoleArn is set and no static
+// key is configured.
+const imdsRoleURL = "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+
+// credentialsSource supplies the credentials used to sign a CloudWatch request, refreshing them
+// as needed.
+type credentialsSource interface {
+	Credentials() (credentials, error)
+}
+
+// newCredentialsSource returns the credentialsSource appropriate for aws: a static
+// staticCredentialsSource when AccessKeyId/SecretAccessKey are configured, or an
+// assumeRoleCredentialsSource (wrapping an ambient bootstrap identity) when RoleArn is configured.
+func newCredentialsSource(aws *config.AWSIdentity, client *http.Client) credentialsSource {
+	if aws.RoleArn != "" {
+		return &assumeRoleCredentialsSource{aws: aws, client: client}
+	}
+	return staticCredentialsSource{
+		accessKeyId:     aws.AccessKeyId,
+		secretAccessKey: aws.SecretAccessKey,
+	}
+}
+
+// staticCredentialsSource always returns the same long-lived key pair.
+type staticCredentialsSource struct {
+	accessKeyId     string
+	secretAccessKey string
+}
+
+func (s staticCredentialsSource) Credentials() (credentials, error) {
+	return credentials{accessKeyId: s.accessKeyId, secretAccessKey: s.secretAccessKey}, nil
+}
+
+// assumeRoleCredentialsSource obtains temporary credentials for aws.RoleArn via STS AssumeRole,
+// signed with an ambient bootstrap identity (the AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/
+// AWS_SESSION_TOKEN environment variables, or failing that, the EC2 instance profile's
+// credentials). It caches the assumed-role credentials until shortly before they expire.
+type assumeRoleCredentialsSource struct {
+	aws    *config.AWSIdentity
+	client *http.Client
+
+	mu      sync.Mutex
+	cached  credentials
+	expires time.Time
+}
+
+func (s *assumeRoleCredentialsSource) Credentials() (credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().Before(s.expires) {
+		return s.cached, nil
+	}
+	bootstrap, err := bootstrapCredentials(s.client)
+	if err != nil {
+		return credentials{}, fmt.Errorf("cloudwatch: resolving bootstrap credentials: %v", err)
+	}
+	creds, expires, err := assumeRole(s.client, bootstrap, s.aws.RoleArn, s.aws.Region)
+	if err != nil {
+		return credentials{}, err
+	}
+	s.cached = creds
+	// Refresh a minute before expiry to avoid signing a request with a token that expires in
+	// flight.
+	s.expires = expires.Add(-time.Minute)
+	return creds, nil
+}
+
+// bootstrapCredentials resolves the ambient AWS identity ubbagent itself runs as: the standard
+// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY/AWS_SESSION_TOKEN environment variables, or, when those
+// aren't set, the EC2 instance metadata service's attached instance profile.
+func bootstrapCredentials(client *http.Client) (credentials, error) {
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		return credentials{
+			accessKeyId:     key,
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	return instanceProfileCredentials(client)
+}
+
+func instanceProfileCredentials(client *http.Client) (credentials, error) {
+	roleResp, err := client.Get(imdsRoleURL)
+	if err != nil {
+		return credentials{}, err
+	}
+	defer roleResp.Body.Close()
+	role, err := ioutil.ReadAll(roleResp.Body)
+	if err != nil {
+		return credentials{}, err
+	}
+	if roleResp.StatusCode != http.StatusOK {
+		return credentials{}, fmt.Errorf("instance metadata service: status %v", roleResp.StatusCode)
+	}
+
+	credResp, err := client.Get(imdsRoleURL + string(role))
+	if err != nil {
+		return credentials{}, err
+	}
+	defer credResp.Body.Close()
+	body, err := ioutil.ReadAll(credResp.Body)
+	if err != nil {
+		return credentials{}, err
+	}
+	if credResp.StatusCode != http.StatusOK {
+		return credentials{}, fmt.Errorf("instance metadata service: status %v", credResp.StatusCode)
+	}
+
+	var cr struct {
+		AccessKeyId     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal(body, &cr); err != nil {
+		return credentials{}, fmt.Errorf("instance metadata service: invalid credentials response: %v", err)
+	}
+	return credentials{accessKeyId: cr.AccessKeyId, secretAccessKey: cr.SecretAccessKey, sessionToken: cr.Token}, nil
+}
+
+// assumeRoleResponse mirrors the XML body returned by STS's AssumeRole action.
+type assumeRoleResponse struct {
+	XMLName xml.Name `xml:"AssumeRoleResponse"`
+	Result  struct {
+		Credentials struct {
+			AccessKeyId     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+			Expiration      string `xml:"Expiration"`
+		} `xml:"Credentials"`
+	} `xml:"AssumeRoleResult"`
+}
+
+// assumeRole calls STS's AssumeRole action for roleArn, signed with bootstrap, returning the
+// resulting temporary credentials and their expiration time.
+func assumeRole(client *http.Client, bootstrap credentials, roleArn, region string) (credentials, time.Time, error) {
+	values := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {roleArn},
+		"RoleSessionName": {"ubbagent"},
+	}
+	body := []byte(values.Encode())
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://sts.%v.amazonaws.com/", region), bytes.NewReader(body))
+	if err != nil {
+		return credentials{}, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signSigV4(req, body, bootstrap, "sts", region, time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return credentials{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return credentials{}, time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return credentials{}, time.Time{}, fmt.Errorf("cloudwatch: sts AssumeRole failed: status %v: %v", resp.StatusCode, string(respBody))
+	}
+
+	var ar assumeRoleResponse
+	if err := xml.Unmarshal(respBody, &ar); err != nil {
+		return credentials{}, time.Time{}, fmt.Errorf("cloudwatch: invalid sts AssumeRole response: %v", err)
+	}
+	expires, err := time.Parse(time.RFC3339, ar.Result.Credentials.Expiration)
+	if err != nil {
+		return credentials{}, time.Time{}, fmt.Errorf("cloudwatch: invalid sts AssumeRole expiration: %v", err)
+	}
+	return credentials{
+		accessKeyId:     ar.Result.Credentials.AccessKeyId,
+		secretAccessKey: ar.Result.Credentials.SecretAccessKey,
+		sessionToken:    ar.Result.Credentials.SessionToken,
+	}, expires, nil
+}
@@ -15,17 +15,21 @@
 package disk
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/ubbagent/clock"
-	"github.com/GoogleCloudPlatform/ubbagent/endpoint"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
 	"github.com/golang/glog"
@@ -38,18 +42,41 @@ const (
 	reportPrefix    = "report"
 	reportSuffix    = ".json"
 	randomLength    = 5
+
+	formatNDJSON = "ndjson"
+
+	ndjsonSuffix      = ".ndjson"
+	gzSuffix          = ".gz"
+	segmentTimeLayout = "2006-01-02T15-04-05Z"
+
+	// activeSegmentName is the file an ndjson-format DiskEndpoint appends to between rotations. Its
+	// name deliberately doesn't match isExpired's patterns, so cleanup never touches it while it's
+	// active.
+	activeSegmentName = reportPrefix + ndjsonSuffix + ".tmp"
 )
 
 type DiskEndpoint struct {
 	name       string
 	path       string
 	expiration time.Duration
+	format     string
+	rotation   config.DiskRotation
+	uploader   Uploader // non-nil iff rotation.Destination is set
 	quit       chan bool
 	closeOnce  sync.Once
 	clock      clock.Clock
 	wait       sync.WaitGroup
 	tracker    pipeline.UsageTracker
 	closed     bool // used for testing
+
+	watchers  []pipeline.SendWatcher
+	watcherMu sync.Mutex
+
+	// writeMu guards active, activeOpened, and activeBytes, used only in ndjson mode.
+	writeMu      sync.Mutex
+	active       *os.File
+	activeOpened time.Time
+	activeBytes  int64
 }
 
 type diskContext struct {
@@ -57,36 +84,51 @@ type diskContext struct {
 }
 
 // NewDiskEndpoint creates a new DiskEndpoint and starts a goroutine that cleans up expired reports
-// on disk.
-func NewDiskEndpoint(name string, path string, expiration time.Duration) *DiskEndpoint {
-	return newDiskEndpoint(name, path, expiration, clock.NewRealClock())
+// on disk. format is "" or "json" for the original one-file-per-report layout, or "ndjson" to
+// append reports to rotating segments in path per rotation. ident authenticates rotation's
+// Destination, if any; it's unused if rotation is nil or has no Destination.
+func NewDiskEndpoint(name string, path string, expiration time.Duration, format string, rotation *config.DiskRotation, ident *config.Identity) (*DiskEndpoint, error) {
+	return newDiskEndpoint(name, path, expiration, format, rotation, ident, clock.NewRealClock())
 }
 
-func newDiskEndpoint(name string, path string, expiration time.Duration, clock clock.Clock) *DiskEndpoint {
+func newDiskEndpoint(name string, path string, expiration time.Duration, format string, rotation *config.DiskRotation, ident *config.Identity, clock clock.Clock) (*DiskEndpoint, error) {
 	ep := &DiskEndpoint{
 		name:       name,
 		path:       path,
 		expiration: expiration,
+		format:     format,
 		clock:      clock,
 		quit:       make(chan bool, 1),
 	}
+	if rotation != nil {
+		ep.rotation = *rotation
+	}
+	if ep.rotation.Destination != "" {
+		uploader, err := newUploader(ep.rotation.Destination, ident)
+		if err != nil {
+			return nil, err
+		}
+		ep.uploader = uploader
+	}
 	ep.wait.Add(1)
 	go ep.run(clock.Now())
-	return ep
+	return ep, nil
 }
 
 func (ep *DiskEndpoint) Name() string {
 	return ep.name
 }
 
-func (ep *DiskEndpoint) BuildReport(r metrics.StampedMetricReport) (endpoint.EndpointReport, error) {
-	return endpoint.NewEndpointReport(r, diskContext{Name: reportName(r, ep.clock.Now())})
+func (ep *DiskEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, diskContext{Name: reportName(r, ep.clock.Now())})
 }
 
-func (ep *DiskEndpoint) Send(r endpoint.EndpointReport) error {
-	dctx := diskContext{}
-	err := r.UnmarshalContext(&dctx)
-	if err != nil {
+func (ep *DiskEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *DiskEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 	jsontext, err := json.Marshal(r.StampedMetricReport)
@@ -96,14 +138,178 @@ func (ep *DiskEndpoint) Send(r endpoint.EndpointReport) error {
 	if err := os.MkdirAll(ep.path, directoryMode); err != nil {
 		return err
 	}
+	if ep.format == formatNDJSON {
+		return ep.appendNDJSON(jsontext)
+	}
+	dctx := diskContext{}
+	if err := r.UnmarshalContext(&dctx); err != nil {
+		return err
+	}
 	file := path.Join(ep.path, dctx.Name)
+	return ioutil.WriteFile(file, jsontext, fileMode)
+}
+
+// appendNDJSON appends jsontext as a line to the active ndjson segment, rotating it first if it's
+// grown past rotation.MaxBytes or lived past rotation.MaxAgeSeconds.
+func (ep *DiskEndpoint) appendNDJSON(jsontext []byte) error {
+	ep.writeMu.Lock()
+	defer ep.writeMu.Unlock()
+	if ep.active == nil {
+		if err := ep.openActiveLocked(); err != nil {
+			return err
+		}
+	}
+	line := append(jsontext, '\n')
+	n, err := ep.active.Write(line)
+	if err != nil {
+		return err
+	}
+	ep.activeBytes += int64(n)
+	if ep.rotation.MaxBytes > 0 && ep.activeBytes >= ep.rotation.MaxBytes {
+		return ep.rotateLocked()
+	}
+	maxAge := time.Duration(ep.rotation.MaxAgeSeconds) * time.Second
+	if maxAge > 0 && ep.clock.Now().Sub(ep.activeOpened) >= maxAge {
+		return ep.rotateLocked()
+	}
+	return nil
+}
+
+func (ep *DiskEndpoint) activeSegmentPath() string {
+	return path.Join(ep.path, activeSegmentName)
+}
 
-	if err := ioutil.WriteFile(file, jsontext, fileMode); err != nil {
+func (ep *DiskEndpoint) openActiveLocked() error {
+	f, err := os.OpenFile(ep.activeSegmentPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	ep.active = f
+	ep.activeOpened = ep.clock.Now()
+	ep.activeBytes = info.Size()
+	return nil
+}
+
+// rotateLocked closes the active segment and finalizes it under its permanent, timestamped name -
+// compressing it first if rotation.Compress is set - then, if rotation.Destination is set, uploads
+// it before enforcing rotation.MaxFiles. The caller must hold writeMu.
+func (ep *DiskEndpoint) rotateLocked() error {
+	if ep.active == nil {
+		return nil
+	}
+	tmpPath := ep.activeSegmentPath()
+	syncErr := ep.active.Sync()
+	closeErr := ep.active.Close()
+	ep.active = nil
+	ep.activeBytes = 0
+	if syncErr != nil {
+		return syncErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	segment := segmentName(ep.activeOpened, ep.rotation.Compress)
+	finalPath := path.Join(ep.path, segment)
+	var err error
+	if ep.rotation.Compress {
+		err = compressSegment(tmpPath, finalPath)
+	} else {
+		err = os.Rename(tmpPath, finalPath)
+	}
+	if err != nil {
 		return err
 	}
+	if ep.rotation.Destination != "" {
+		if err := ep.uploadSegmentLocked(finalPath, segment); err != nil {
+			return err
+		}
+	}
+	ep.enforceMaxFilesLocked()
 	return nil
 }
 
+// uploadSegmentLocked uploads the segment just finalized at finalPath, named segment, removing its
+// local copy only once the upload succeeds - so a failed upload leaves the segment in place,
+// subject to the usual MaxFiles/expiration cleanup, rather than losing it. The caller must hold
+// writeMu.
+func (ep *DiskEndpoint) uploadSegmentLocked(finalPath, segment string) error {
+	data, err := ioutil.ReadFile(finalPath)
+	if err != nil {
+		return err
+	}
+	if err := ep.uploader.Upload(context.Background(), segment, data); err != nil {
+		return err
+	}
+	if err := os.Remove(finalPath); err != nil {
+		glog.Warningf("error removing uploaded disk segment: %v", finalPath)
+	}
+	return nil
+}
+
+// compressSegment gzips the segment written at src into dst, removing src once dst is safely on
+// disk.
+func compressSegment(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		out.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// enforceMaxFilesLocked removes the oldest rotated segments in ep.path beyond rotation.MaxFiles.
+// The caller must hold writeMu.
+func (ep *DiskEndpoint) enforceMaxFilesLocked() {
+	if ep.rotation.MaxFiles <= 0 {
+		return
+	}
+	files, err := ioutil.ReadDir(ep.path)
+	if err != nil {
+		return
+	}
+	var segments []string
+	for _, f := range files {
+		if _, ok := parseSegmentTime(f.Name()); ok {
+			segments = append(segments, f.Name())
+		}
+	}
+	if len(segments) <= ep.rotation.MaxFiles {
+		return
+	}
+	sort.Strings(segments)
+	for _, name := range segments[:len(segments)-ep.rotation.MaxFiles] {
+		if err := os.Remove(path.Join(ep.path, name)); err != nil {
+			glog.Warningf("error removing rotated disk segment: %v", name)
+		}
+	}
+}
+
 // Use increments the DiskEndpoint's usage count.
 // See pipeline.Component.Use.
 func (ep *DiskEndpoint) Use() {
@@ -121,6 +327,11 @@ func (ep *DiskEndpoint) Release() error {
 			ep.closed = true
 		})
 		ep.wait.Wait()
+		if ep.format == formatNDJSON {
+			ep.writeMu.Lock()
+			defer ep.writeMu.Unlock()
+			return ep.rotateLocked()
+		}
 		return nil
 	})
 }
@@ -147,6 +358,7 @@ func (ep *DiskEndpoint) cleanup() {
 	files, _ := ioutil.ReadDir(ep.path)
 	for _, f := range files {
 		if isExpired(f.Name(), cutoff) {
+			ep.notifyExpired(filepath.Join(ep.path, f.Name()))
 			if err := os.Remove(filepath.Join(ep.path, f.Name())); err != nil {
 				glog.Warningf("error removing expired disk report: %v", f)
 			}
@@ -154,6 +366,36 @@ func (ep *DiskEndpoint) cleanup() {
 	}
 }
 
+// AddWatcher registers w to observe reports this DiskEndpoint expires off disk via cleanup.
+// See pipeline.Sender.AddWatcher.
+func (ep *DiskEndpoint) AddWatcher(w pipeline.SendWatcher) {
+	ep.watcherMu.Lock()
+	ep.watchers = append(ep.watchers, w)
+	ep.watcherMu.Unlock()
+}
+
+// notifyExpired reads the report stored at file and fires OnExpired for every registered watcher.
+// Watchers aren't notified if the file can't be read back, but it's still removed by the caller.
+func (ep *DiskEndpoint) notifyExpired(file string) {
+	ep.watcherMu.Lock()
+	watchers := ep.watchers
+	ep.watcherMu.Unlock()
+	if len(watchers) == 0 {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	var report metrics.StampedMetricReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return
+	}
+	for _, w := range watchers {
+		w.OnExpired(report, ep.name)
+	}
+}
+
 func reportName(report metrics.StampedMetricReport, reportTime time.Time) string {
 	var random string
 	if len(report.Id) < randomLength {
@@ -164,7 +406,37 @@ func reportName(report metrics.StampedMetricReport, reportTime time.Time) string
 	return reportPrefix + "_" + reportTime.UTC().Format(time.RFC3339) + "_" + random + reportSuffix
 }
 
+// segmentName returns the permanent filename a rotated ndjson segment opened at t is given, e.g.
+// "report-2024-01-02T15-04-05Z.ndjson" or, if compressed, "report-2024-01-02T15-04-05Z.ndjson.gz".
+func segmentName(t time.Time, compressed bool) string {
+	name := reportPrefix + "-" + t.UTC().Format(segmentTimeLayout) + ndjsonSuffix
+	if compressed {
+		name += gzSuffix
+	}
+	return name
+}
+
+// parseSegmentTime extracts the rotation time from a rotated ndjson segment's filename, as
+// produced by segmentName. It returns false for any other filename, including the active,
+// not-yet-rotated segment.
+func parseSegmentTime(name string) (time.Time, bool) {
+	base := strings.TrimSuffix(name, gzSuffix)
+	if !strings.HasPrefix(base, reportPrefix+"-") || !strings.HasSuffix(base, ndjsonSuffix) {
+		return time.Time{}, false
+	}
+	ts := strings.TrimSuffix(strings.TrimPrefix(base, reportPrefix+"-"), ndjsonSuffix)
+	t, err := time.Parse(segmentTimeLayout, ts)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
 func isExpired(name string, cutoff time.Time) bool {
+	if t, ok := parseSegmentTime(name); ok {
+		return t.Before(cutoff)
+	}
+
 	if !strings.HasPrefix(name, reportPrefix) {
 		return false
 	}
@@ -15,12 +15,17 @@
 package disk
 
 import (
+	"compress/gzip"
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/ubbagent/config"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 	"github.com/GoogleCloudPlatform/ubbagent/testlib"
 )
@@ -34,7 +39,10 @@ func TestDiskEndpoint(t *testing.T) {
 
 	mc := testlib.NewMockClock()
 	mc.SetNow(parseTime("2017-06-19T12:00:00Z"))
-	ep := newDiskEndpoint("disk", tmpdir, 10*time.Minute, mc)
+	ep, err := newDiskEndpoint("disk", tmpdir, 10*time.Minute, "", nil, nil, mc)
+	if err != nil {
+		t.Fatalf("error creating disk endpoint: %+v", err)
+	}
 
 	// Make sure we start with an empty dir
 	if files, err := ioutil.ReadDir(tmpdir); err != nil {
@@ -114,6 +122,129 @@ func TestDiskEndpoint(t *testing.T) {
 	}
 }
 
+func TestDiskEndpoint_SendContextCancelled(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "disk_endpoint_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	mc := testlib.NewMockClock()
+	mc.SetNow(parseTime("2017-06-19T12:00:00Z"))
+	ep, err := newDiskEndpoint("disk", tmpdir, 10*time.Minute, "", nil, nil, mc)
+	if err != nil {
+		t.Fatalf("error creating disk endpoint: %+v", err)
+	}
+
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		Id: "report1",
+		MetricReport: metrics.MetricReport{
+			Name:      "int-metric1",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Value: metrics.MetricValue{
+				Int64Value: 10,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ep.SendContext(ctx, report); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %+v", err)
+	}
+	if files, err := ioutil.ReadDir(tmpdir); err != nil {
+		t.Fatalf("error listing output directory: %+v", err)
+	} else if len(files) != 0 {
+		t.Fatalf("output directory contains %v files, expected 0 since the report should not have been sent", len(files))
+	}
+}
+
+func TestDiskEndpoint_NDJSONRotation(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "disk_endpoint_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	mc := testlib.NewMockClock()
+	mc.SetNow(parseTime("2017-06-19T12:00:00Z"))
+	rotation := &config.DiskRotation{MaxBytes: 1, MaxFiles: 1, Compress: true}
+	ep, err := newDiskEndpoint("disk", tmpdir, time.Hour, "ndjson", rotation, nil, mc)
+	if err != nil {
+		t.Fatalf("error creating disk endpoint: %+v", err)
+	}
+
+	send := func(id string) {
+		report, err := ep.BuildReport(metrics.StampedMetricReport{
+			Id:           id,
+			MetricReport: metrics.MetricReport{Name: "int-metric1"},
+		})
+		if err != nil {
+			t.Fatalf("error building report: %+v", err)
+		}
+		if err := ep.Send(report); err != nil {
+			t.Fatalf("error sending report: %+v", err)
+		}
+	}
+
+	// Every report exceeds MaxBytes, so each Send immediately rotates its own segment; MaxFiles: 1
+	// keeps only the most recently rotated one around.
+	send("report1")
+	mc.SetNow(parseTime("2017-06-19T12:01:00Z"))
+	send("report2")
+	mc.SetNow(parseTime("2017-06-19T12:02:00Z"))
+	send("report3")
+
+	files, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatalf("error listing output directory: %+v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 retained rotated segment, got %v", len(files))
+	}
+	segment := files[0].Name()
+	if !strings.HasSuffix(segment, ".ndjson.gz") {
+		t.Fatalf("expected the retained segment to be gzip-compressed, got: %v", segment)
+	}
+
+	gf, err := os.Open(filepath.Join(tmpdir, segment))
+	if err != nil {
+		t.Fatalf("error opening rotated segment: %+v", err)
+	}
+	defer gf.Close()
+	gr, err := gzip.NewReader(gf)
+	if err != nil {
+		t.Fatalf("error opening gzip reader: %+v", err)
+	}
+	data, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("error reading gzip contents: %+v", err)
+	}
+	if !strings.Contains(string(data), `"report3"`) {
+		t.Fatalf("expected the retained rotated segment to hold report3, got: %v", string(data))
+	}
+
+	ep.Use()
+	ep.Release()
+	if !ep.closed {
+		t.Fatal("ep.closed expected to be true")
+	}
+
+	files, err = ioutil.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatalf("error listing output directory: %+v", err)
+	}
+	for _, f := range files {
+		if f.Name() == activeSegmentName {
+			t.Fatal("expected the active segment to be finalized on Release")
+		}
+	}
+}
+
 func parseTime(ts string) time.Time {
 	t, err := time.Parse(time.RFC3339, ts)
 	if err != nil {
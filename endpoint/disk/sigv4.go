@@ -0,0 +1,115 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// signS3 signs req per the AWS Signature Version 4 scheme
+// (https://docs.aws.amazon.com/general/latest/gr/sigv4-signing-process.html) for the S3 service,
+// as of t. req's body must already be set; req.Header's Host and X-Amz-Date are set by this
+// function. This mirrors endpoint/cloudwatch's signSigV4, trimmed to the static-credential case
+// s3Uploader needs.
+func signS3(req *http.Request, body []byte, accessKeyId, secretAccessKey, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	dateStamp := t.UTC().Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	canonicalHeaders, signedHeaders := canonicalizeHeaders(req.Header)
+	payloadHash := sha256Hex(body)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := strings.Join([]string{dateStamp, region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := sigv4Key(secretAccessKey, dateStamp, region, "s3")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 "+
+		"Credential="+accessKeyId+"/"+credentialScope+", "+
+		"SignedHeaders="+signedHeaders+", "+
+		"Signature="+signature)
+}
+
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// canonicalizeHeaders returns header formatted as SigV4's CanonicalHeaders and SignedHeaders,
+// including the mandatory host header.
+func canonicalizeHeaders(header http.Header) (canonical, signed string) {
+	names := make([]string, 0, len(header))
+	lower := make(map[string]string, len(header))
+	for k := range header {
+		l := strings.ToLower(k)
+		names = append(names, l)
+		lower[l] = k
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	signedNames := make([]string, len(names))
+	for i, l := range names {
+		b.WriteString(l)
+		b.WriteByte(':')
+		b.WriteString(strings.TrimSpace(strings.Join(header[lower[l]], ",")))
+		b.WriteByte('\n')
+		signedNames[i] = l
+	}
+	return b.String(), strings.Join(signedNames, ";")
+}
+
+func sigv4Key(secretKey, dateStamp, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), dateStamp)
+	kRegion := hmacSHA256(kDate, region)
+	kService := hmacSHA256(kRegion, service)
+	return hmacSHA256(kService, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
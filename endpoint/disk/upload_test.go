@@ -0,0 +1,119 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/testlib"
+)
+
+func TestDiskEndpoint_NDJSONRotation_Upload(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "disk_endpoint_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	mc := testlib.NewMockClock()
+	mc.SetNow(parseTime("2017-06-19T12:00:00Z"))
+	rotation := &config.DiskRotation{MaxBytes: 1, Destination: "https://example.invalid/reports"}
+	ep, err := newDiskEndpoint("disk", tmpdir, time.Hour, "ndjson", rotation, nil, mc)
+	if err != nil {
+		t.Fatalf("error creating disk endpoint: %+v", err)
+	}
+	uploader := &fakeUploader{}
+	ep.uploader = uploader
+
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		Id:           "report1",
+		MetricReport: metrics.MetricReport{Name: "int-metric1"},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+
+	// The report exceeds MaxBytes, so Send immediately rotates its segment - uploading it and, only
+	// on a successful upload, removing its local copy.
+	if err := ep.Send(report); err != nil {
+		t.Fatalf("error sending report: %+v", err)
+	}
+
+	uploads := uploader.Uploads()
+	if len(uploads) != 1 {
+		t.Fatalf("expected 1 upload, got %v", len(uploads))
+	}
+	if !strings.Contains(string(uploads[0]), `"report1"`) {
+		t.Fatalf("expected the uploaded segment to hold report1, got: %v", string(uploads[0]))
+	}
+	if files, err := ioutil.ReadDir(tmpdir); err != nil {
+		t.Fatalf("error listing output directory: %+v", err)
+	} else if len(files) != 0 {
+		t.Fatalf("expected the uploaded segment to be removed from disk, got %v files", len(files))
+	}
+
+	uploader.failNext = true
+	send2, err := ep.BuildReport(metrics.StampedMetricReport{
+		Id:           "report2",
+		MetricReport: metrics.MetricReport{Name: "int-metric1"},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+
+	// A failed upload must leave the segment on disk rather than silently losing it.
+	if err := ep.Send(send2); err == nil {
+		t.Fatal("expected Send to return the upload error")
+	}
+	if files, err := ioutil.ReadDir(tmpdir); err != nil {
+		t.Fatalf("error listing output directory: %+v", err)
+	} else if len(files) != 1 {
+		t.Fatalf("expected the failed segment to remain on disk, got %v files", len(files))
+	}
+}
+
+// fakeUploader is an Uploader that records every upload it's given instead of shipping it
+// anywhere, so tests can assert upload-then-delete ordering without a real object store.
+type fakeUploader struct {
+	mu       sync.Mutex
+	uploads  [][]byte
+	failNext bool
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, key string, data []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.failNext {
+		u.failNext = false
+		return errors.New("fakeUploader: simulated upload failure")
+	}
+	u.uploads = append(u.uploads, append([]byte(nil), data...))
+	return nil
+}
+
+func (u *fakeUploader) Uploads() [][]byte {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([][]byte(nil), u.uploads...)
+}
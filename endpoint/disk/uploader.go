@@ -0,0 +1,178 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package disk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/identity"
+	"golang.org/x/oauth2"
+	storage "google.golang.org/api/storage/v1"
+)
+
+const uploadTimeout = 60 * time.Second
+
+// gcsBasePath is the audience presented when gcp.SelfSignedJWT is set; it must match the base URL
+// storage.New dials, since Cloud Storage validates self-signed JWTs against it.
+const gcsBasePath = "https://storage.googleapis.com/"
+
+// Uploader ships a rotated segment's bytes to an object store under key, a filename unique to that
+// segment (see segmentName). rotateLocked only removes a segment's local copy once its Upload
+// succeeds, so a failed upload just leaves the segment on disk, retried on the endpoint's next
+// Send (like any other transient DiskEndpoint error).
+type Uploader interface {
+	Upload(ctx context.Context, key string, data []byte) error
+}
+
+// newUploader returns the Uploader destination describes, as already validated by
+// config.DiskRotation.Validate: a gs:// or s3:// URL authenticates using ident, while an http(s)://
+// URL is PUT to directly and ident is ignored.
+func newUploader(destination string, ident *config.Identity) (Uploader, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, err
+	}
+	bucket := u.Host
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "gs":
+		return newGCSUploader(bucket, prefix, ident.GCP)
+	case "s3":
+		return newS3Uploader(bucket, prefix, ident.AWS)
+	case "http", "https":
+		return &httpUploader{baseURL: destination, client: &http.Client{Timeout: uploadTimeout}}, nil
+	default:
+		return nil, fmt.Errorf("disk: unsupported rotation destination scheme: %v", u.Scheme)
+	}
+}
+
+// objectName joins prefix (which may be empty) and key into the object name uploaded to a bucket.
+func objectName(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// httpUploader PUTs a segment directly to baseURL + "/" + key, for an arbitrary object store (or
+// webhook) that accepts an unauthenticated or presigned-URL PUT.
+type httpUploader struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (u *httpUploader) Upload(ctx context.Context, key string, data []byte) error {
+	reqURL := strings.TrimSuffix(u.baseURL, "/") + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("disk: upload to %v: %v: %s", reqURL, resp.Status, body)
+	}
+	return nil
+}
+
+// gcsUploader uploads a segment as an object in a Cloud Storage bucket, via a simple (non-resumable)
+// media upload.
+type gcsUploader struct {
+	service *storage.Service
+	bucket  string
+	prefix  string
+}
+
+// newGCSUploader authenticates to Cloud Storage the same way NewStackdriverEndpoint authenticates
+// to Cloud Monitoring: via identity.NewTokenSource rather than a bare JWT client.
+func newGCSUploader(bucket, prefix string, gcp *config.GCPIdentity) (Uploader, error) {
+	ctx := context.Background()
+	ts, err := identity.NewTokenSource(ctx, gcp, gcsBasePath, storage.DevstorageReadWriteScope)
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, ts)
+	client.Timeout = uploadTimeout
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsUploader{service: service, bucket: bucket, prefix: prefix}, nil
+}
+
+func (u *gcsUploader) Upload(ctx context.Context, key string, data []byte) error {
+	object := &storage.Object{Name: objectName(u.prefix, key)}
+	_, err := u.service.Objects.Insert(u.bucket, object).Media(bytes.NewReader(data)).Context(ctx).Do()
+	return err
+}
+
+// s3Uploader uploads a segment as an object in an S3 bucket, signed with a static IAM user access
+// key. A RoleArn-based AWS identity - which requires assuming a role via STS, as
+// endpoint/cloudwatch's credentialsSource does - isn't supported here; that's more machinery than
+// a disk rotation upload warrants, so newS3Uploader requires an AccessKeyId/SecretAccessKey pair.
+type s3Uploader struct {
+	bucket          string
+	prefix          string
+	region          string
+	accessKeyId     string
+	secretAccessKey string
+	client          *http.Client
+}
+
+func newS3Uploader(bucket, prefix string, aws *config.AWSIdentity) (Uploader, error) {
+	if aws.AccessKeyId == "" || aws.SecretAccessKey == "" {
+		return nil, errors.New("disk: rotation.destination: s3 upload requires a static AWS access key; roleArn-based identities aren't supported for disk rotation")
+	}
+	return &s3Uploader{
+		bucket:          bucket,
+		prefix:          prefix,
+		region:          aws.Region,
+		accessKeyId:     aws.AccessKeyId,
+		secretAccessKey: aws.SecretAccessKey,
+		client:          &http.Client{Timeout: uploadTimeout},
+	}, nil
+}
+
+func (u *s3Uploader) Upload(ctx context.Context, key string, data []byte) error {
+	reqURL := fmt.Sprintf("https://%v.s3.%v.amazonaws.com/%v", u.bucket, u.region, objectName(u.prefix, key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	signS3(req, data, u.accessKeyId, u.secretAccessKey, u.region, time.Now())
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("disk: upload to %v: %v: %s", reqURL, resp.Status, body)
+	}
+	return nil
+}
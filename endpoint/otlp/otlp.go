@@ -0,0 +1,307 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp implements a pipeline.Endpoint that ships aggregated metrics to an OpenTelemetry
+// collector, or any other backend that speaks OTLP (e.g. Honeycomb or Grafana Cloud), over gRPC or
+// HTTP/protobuf.
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+	"github.com/golang/glog"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	httpTimeout     = 60 * time.Second
+	compressionGzip = "gzip"
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 250 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
+)
+
+// OTLPEndpoint ships aggregated metrics to an OTLP collector, over either gRPC or HTTP/protobuf.
+type OTLPEndpoint struct {
+	name        string
+	cfg         config.OTLPEndpoint
+	temporality metricspb.AggregationTemporality
+	tracker     pipeline.UsageTracker
+	retry       retrypolicy.RetryPolicy
+	clock       clock.Clock
+
+	// Set when cfg.Protocol is "grpc" (the default).
+	conn   *grpc.ClientConn
+	client colmetricspb.MetricsServiceClient
+
+	// Set when cfg.Protocol is "http".
+	httpClient *http.Client
+}
+
+// NewOTLPEndpoint creates a new OTLPEndpoint from cfg. For the (default) gRPC protocol, this
+// dials cfg.Endpoint eagerly; the connection itself is lazy, so a temporarily unreachable
+// collector doesn't cause an error here.
+func NewOTLPEndpoint(name string, cfg config.OTLPEndpoint) (*OTLPEndpoint, error) {
+	ep := &OTLPEndpoint{
+		name:        name,
+		cfg:         cfg,
+		temporality: temporalityFromConfig(cfg.Temporality),
+		retry:       retry.NewPolicy(cfg.Backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:       clock.NewClock(),
+	}
+	if cfg.Protocol == "http" {
+		ep.httpClient = &http.Client{Timeout: httpTimeout}
+		return ep, nil
+	}
+
+	var opts []grpc.DialOption
+	if cfg.Insecure {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{ServerName: cfg.ServerName})))
+	}
+	if cfg.Compression == compressionGzip {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcgzip.Name)))
+	}
+	conn, err := grpc.Dial(cfg.Endpoint, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ep.conn = conn
+	ep.client = colmetricspb.NewMetricsServiceClient(conn)
+	return ep, nil
+}
+
+func temporalityFromConfig(t string) metricspb.AggregationTemporality {
+	if t == "delta" {
+		return metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	}
+	return metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+}
+
+func (ep *OTLPEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *OTLPEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *OTLPEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *OTLPEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	req := ep.format(r)
+	return retry.Do(ctx, ep.clock, ep.retry, classifyExportError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("OTLPEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		if ep.httpClient != nil {
+			return ep.sendHTTP(ctx, req)
+		}
+		return ep.sendGRPC(ctx, req)
+	})
+}
+
+func (ep *OTLPEndpoint) sendGRPC(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	if len(ep.cfg.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(ep.cfg.Headers))
+	}
+	_, err := ep.client.Export(ctx, req)
+	return err
+}
+
+func (ep *OTLPEndpoint) sendHTTP(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	var contentEncoding string
+	if ep.cfg.Compression == compressionGzip {
+		gw := gzip.NewWriter(&payload)
+		if _, err := gw.Write(body); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		contentEncoding = compressionGzip
+	} else {
+		payload.Write(body)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, ep.cfg.Endpoint, &payload)
+	if err != nil {
+		return err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if contentEncoding != "" {
+		httpReq.Header.Set("Content-Encoding", contentEncoding)
+	}
+	for k, v := range ep.cfg.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := ep.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return &httpExportError{statusCode: resp.StatusCode, body: string(respBody), header: resp.Header}
+	}
+	return nil
+}
+
+// format maps a single EndpointReport onto an OTLP ExportMetricsServiceRequest containing one
+// Sum metric with a single data point: the report's Labels become point attributes, and its
+// StartTime/EndTime become start_time_unix_nano/time_unix_nano.
+func (ep *OTLPEndpoint) format(r pipeline.EndpointReport) *colmetricspb.ExportMetricsServiceRequest {
+	dp := &metricspb.NumberDataPoint{
+		Attributes:        attributesFromLabels(r.Labels),
+		StartTimeUnixNano: uint64(r.StartTime.UnixNano()),
+		TimeUnixNano:      uint64(r.EndTime.UnixNano()),
+	}
+	if r.Value.Int64Value != nil {
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: *r.Value.Int64Value}
+	} else if r.Value.DoubleValue != nil {
+		dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: *r.Value.DoubleValue}
+	}
+
+	metric := &metricspb.Metric{
+		Name: r.Name,
+		Data: &metricspb.Metric_Sum{
+			Sum: &metricspb.Sum{
+				AggregationTemporality: ep.temporality,
+				DataPoints:             []*metricspb.NumberDataPoint{dp},
+			},
+		},
+	}
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{metric}}}},
+		},
+	}
+}
+
+func attributesFromLabels(labels map[string]string) []*commonpb.KeyValue {
+	if len(labels) == 0 {
+		return nil
+	}
+	attrs := make([]*commonpb.KeyValue, 0, len(labels))
+	for k, v := range labels {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}},
+		})
+	}
+	return attrs
+}
+
+// httpExportError is returned for a non-2xx HTTP/protobuf export response.
+type httpExportError struct {
+	statusCode int
+	body       string
+	header     http.Header
+}
+
+func (e *httpExportError) Error() string {
+	return fmt.Sprintf("otlp: export rejected: status %v: %v", e.statusCode, e.body)
+}
+
+// Use increments the OTLPEndpoint's usage count. See pipeline.Component.Use.
+func (ep *OTLPEndpoint) Use() {
+	ep.tracker.Use()
+}
+
+// Release decrements the OTLPEndpoint's usage count. If it reaches 0, the underlying gRPC
+// connection (if any) is closed. See pipeline.Component.Release.
+func (ep *OTLPEndpoint) Release() error {
+	return ep.tracker.Release(func() error {
+		if ep.conn != nil {
+			return ep.conn.Close()
+		}
+		return nil
+	})
+}
+
+// IsTransient reports true for the gRPC codes that indicate a retryable Export failure
+// (Unavailable, DeadlineExceeded, ResourceExhausted) and for HTTP 429/5xx responses.
+func (ep *OTLPEndpoint) IsTransient(err error) bool {
+	return isTransientExportError(err)
+}
+
+func isTransientExportError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if he, ok := err.(*httpExportError); ok {
+		return he.statusCode == http.StatusTooManyRequests || (he.statusCode >= 500 && he.statusCode < 600)
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// classifyExportError is a retry.Classifier for Export responses: the same classification
+// IsTransient uses, plus any Retry-After header on a rejected HTTP/protobuf response, which
+// overrides the policy's own backoff delay.
+func classifyExportError(err error) (bool, time.Duration) {
+	if !isTransientExportError(err) {
+		return false, 0
+	}
+	if he, ok := err.(*httpExportError); ok {
+		if d, ok := retry.RetryAfter(he.header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ pipeline.Endpoint = (*OTLPEndpoint)(nil)
+
+func TestOTLPEndpoint_Format(t *testing.T) {
+	ep := &OTLPEndpoint{temporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA}
+	quantity := int64(42)
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		Id: "report1",
+		MetricReport: metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(0, 100),
+			EndTime:   time.Unix(0, 200),
+			Labels:    map[string]string{"region": "us-east1"},
+			Value:     metrics.MetricValue{Int64Value: &quantity},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+
+	req := ep.format(report)
+	if len(req.ResourceMetrics) != 1 || len(req.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("unexpected request shape: %+v", req)
+	}
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 1 || metrics[0].Name != "requests" {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+	sum := metrics[0].GetSum()
+	if sum == nil {
+		t.Fatal("expected a Sum metric")
+	}
+	if sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		t.Errorf("expected DELTA temporality, got: %v", sum.AggregationTemporality)
+	}
+	if len(sum.DataPoints) != 1 {
+		t.Fatalf("expected 1 data point, got: %v", len(sum.DataPoints))
+	}
+	dp := sum.DataPoints[0]
+	if dp.GetAsInt() != 42 {
+		t.Errorf("expected value 42, got: %v", dp.GetAsInt())
+	}
+	if dp.StartTimeUnixNano != 100 || dp.TimeUnixNano != 200 {
+		t.Errorf("unexpected timestamps: start=%v, end=%v", dp.StartTimeUnixNano, dp.TimeUnixNano)
+	}
+	if len(dp.Attributes) != 1 || dp.Attributes[0].Key != "region" {
+		t.Errorf("unexpected attributes: %+v", dp.Attributes)
+	}
+}
+
+func TestOTLPEndpoint_IsTransient(t *testing.T) {
+	ep := &OTLPEndpoint{}
+
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"grpc unavailable", status.Error(codes.Unavailable, "down"), true},
+		{"grpc deadline exceeded", status.Error(codes.DeadlineExceeded, "timeout"), true},
+		{"grpc resource exhausted", status.Error(codes.ResourceExhausted, "overloaded"), true},
+		{"grpc invalid argument", status.Error(codes.InvalidArgument, "bad request"), false},
+		{"non-grpc error", errors.New("connection refused"), false},
+		{"http 429", &httpExportError{statusCode: http.StatusTooManyRequests}, true},
+		{"http 503", &httpExportError{statusCode: http.StatusServiceUnavailable}, true},
+		{"http 400", &httpExportError{statusCode: http.StatusBadRequest}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ep.IsTransient(c.err); got != c.transient {
+				t.Errorf("IsTransient(%v) = %v, expected %v", c.err, got, c.transient)
+			}
+		})
+	}
+}
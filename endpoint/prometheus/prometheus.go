@@ -0,0 +1,362 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prometheus implements a pipeline.Endpoint that exposes accumulated reports as a
+// Prometheus/OpenMetrics text scrape target, rather than forwarding them to a remote service.
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/promremotewrite"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/golang/glog"
+)
+
+const defaultPath = "/metrics"
+
+// series holds the accumulated value of one exposed time series, identified by a metric name plus
+// a label set.
+type series struct {
+	labels map[string]string
+	isInt  bool
+	sum    float64 // counter: accumulated sum of reported deltas.
+	last   float64 // gauge: most recently reported value.
+}
+
+// PrometheusEndpoint is a pipeline.Endpoint whose "send" is a no-op transport: instead of making a
+// remote call, it folds each report into an in-memory, per-series store, which its HTTP server
+// exposes in OpenMetrics text format for a scraper to pull.
+type PrometheusEndpoint struct {
+	name string
+	cfg  config.PrometheusEndpoint
+	meta map[string]config.PrometheusEndpointMetric
+
+	tracker pipeline.UsageTracker
+	server  *http.Server
+
+	mu     sync.Mutex
+	series map[string]map[string]*series // metric name -> series key -> series
+}
+
+// NewPrometheusEndpoint creates a new PrometheusEndpoint named name, configured by cfg. Its scrape
+// server is started as soon as this function returns.
+func NewPrometheusEndpoint(name string, cfg config.PrometheusEndpoint) *PrometheusEndpoint {
+	meta := make(map[string]config.PrometheusEndpointMetric, len(cfg.Metrics))
+	for _, m := range cfg.Metrics {
+		meta[m.Metric] = m
+	}
+	ep := &PrometheusEndpoint{
+		name:   name,
+		cfg:    cfg,
+		meta:   meta,
+		series: make(map[string]map[string]*series),
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = defaultPath
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, ep)
+	if cfg.RemoteWritePath != "" {
+		mux.HandleFunc(cfg.RemoteWritePath, ep.handleRemoteWrite)
+	}
+	ep.server = &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: mux}
+	go func() {
+		if err := ep.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("prometheus: server error: %+v", err)
+		}
+	}()
+	return ep
+}
+
+func (ep *PrometheusEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *PrometheusEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *PrometheusEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+// SendContext folds r into this endpoint's in-memory store. It never makes a remote call, so it
+// never fails or blocks on ctx.
+func (ep *PrometheusEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	value, isInt := reportValue(r.Value)
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	labels := ep.filterLabels(r.Name, r.Labels)
+
+	byLabels, ok := ep.series[r.Name]
+	if !ok {
+		byLabels = make(map[string]*series)
+		ep.series[r.Name] = byLabels
+	}
+	key := seriesKey(labels)
+	s, ok := byLabels[key]
+	if !ok {
+		s = &series{labels: labels}
+		byLabels[key] = s
+	}
+	s.isInt = isInt
+	s.last = value
+	if ep.kindFor(r.Name) == config.PrometheusKindGauge {
+		return nil
+	}
+	s.sum += value
+	return nil
+}
+
+// IsTransient always returns false: SendContext never makes a remote call, so it never fails with
+// a retryable error.
+func (ep *PrometheusEndpoint) IsTransient(err error) bool {
+	return false
+}
+
+func (ep *PrometheusEndpoint) Use() {
+	ep.tracker.Use()
+}
+
+func (ep *PrometheusEndpoint) Release() error {
+	return ep.tracker.Release(func() error {
+		return ep.server.Shutdown(context.Background())
+	})
+}
+
+// kindFor returns the configured PrometheusKind for metric, defaulting to PrometheusKindCounter
+// when it has no configured metadata.
+func (ep *PrometheusEndpoint) kindFor(metric string) string {
+	if m, ok := ep.meta[metric]; ok && m.Kind != "" {
+		return m.Kind
+	}
+	return config.PrometheusKindCounter
+}
+
+// helpFor returns the configured HELP text for metric, defaulting to its own name when it has no
+// configured metadata.
+func (ep *PrometheusEndpoint) helpFor(metric string) string {
+	if m, ok := ep.meta[metric]; ok && m.Help != "" {
+		return m.Help
+	}
+	return metric
+}
+
+// filterLabels returns the subset of labels named in metric's configured IncludeLabels, or labels
+// unchanged if metric has no configured metadata or an empty IncludeLabels.
+func (ep *PrometheusEndpoint) filterLabels(metric string, labels map[string]string) map[string]string {
+	m, ok := ep.meta[metric]
+	if !ok || len(m.IncludeLabels) == 0 {
+		return labels
+	}
+	filtered := make(map[string]string, len(m.IncludeLabels))
+	for _, k := range m.IncludeLabels {
+		if v, ok := labels[k]; ok {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+// ServeHTTP writes this endpoint's current series in OpenMetrics text format.
+func (ep *PrometheusEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+
+	ep.mu.Lock()
+	names := make([]string, 0, len(ep.series))
+	for name := range ep.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	snapshot := make(map[string][]*series, len(names))
+	for _, name := range names {
+		byLabels := ep.series[name]
+		keys := make([]string, 0, len(byLabels))
+		for key := range byLabels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		list := make([]*series, 0, len(keys))
+		for _, key := range keys {
+			s := *byLabels[key]
+			list = append(list, &s)
+		}
+		snapshot[name] = list
+	}
+	ep.mu.Unlock()
+
+	for _, name := range names {
+		kind := ep.kindFor(name)
+		fmt.Fprintf(w, "# HELP %s %s\n", name, ep.helpFor(name))
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		for _, s := range snapshot[name] {
+			value := s.last
+			if kind == config.PrometheusKindCounter {
+				value = s.sum
+			}
+			fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(s.labels), formatValue(value, s.isInt))
+		}
+	}
+	fmt.Fprintln(w, "# EOF")
+}
+
+// handleRemoteWrite accepts a Prometheus remote_write snapshot: a snappy-compressed protobuf
+// WriteRequest, as a real Prometheus server or agent configured with a remote_write target would
+// send. Each contained series is folded directly into this endpoint's series store alongside
+// anything reported through the normal pipeline, so both can be scraped from the same ServeHTTP.
+func (ep *PrometheusEndpoint) handleRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, err := promremotewrite.DecodeSnappy(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid snappy payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	series, err := promremotewrite.UnmarshalWriteRequest(raw)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid write request: %v", err), http.StatusBadRequest)
+		return
+	}
+	for _, ts := range series {
+		ep.applyRemoteWriteSeries(ts)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyRemoteWriteSeries sets ts's series to its last sample's value, rather than accumulating it
+// the way SendContext does: a remote_write snapshot already carries the sender's own accumulated
+// counter or current gauge, so treating it as an additional delta would double-count it.
+func (ep *PrometheusEndpoint) applyRemoteWriteSeries(ts promremotewrite.TimeSeries) {
+	if len(ts.Samples) == 0 {
+		return
+	}
+	name, labels := splitSeriesLabels(ts.Labels)
+	if name == "" {
+		return
+	}
+	value := ts.Samples[len(ts.Samples)-1].Value
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	labels = ep.filterLabels(name, labels)
+	byLabels, ok := ep.series[name]
+	if !ok {
+		byLabels = make(map[string]*series)
+		ep.series[name] = byLabels
+	}
+	key := seriesKey(labels)
+	s, ok := byLabels[key]
+	if !ok {
+		s = &series{labels: labels}
+		byLabels[key] = s
+	}
+	s.isInt = false
+	s.last = value
+	s.sum = value
+}
+
+// splitSeriesLabels pulls the "__name__" label (a remote-write series' metric name) out of
+// labels, returning the name and the remaining labels.
+func splitSeriesLabels(labels []promremotewrite.Label) (string, map[string]string) {
+	var name string
+	out := make(map[string]string, len(labels))
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		out[l.Name] = l.Value
+	}
+	return name, out
+}
+
+// reportValue extracts the float64 representation of v's reported value, and whether it was
+// reported as an integer.
+func reportValue(v metrics.MetricValue) (float64, bool) {
+	if v.Int64Value != nil {
+		return float64(*v.Int64Value), true
+	}
+	if v.DoubleValue != nil {
+		return *v.DoubleValue, false
+	}
+	return 0, true
+}
+
+func formatValue(v float64, isInt bool) string {
+	if isInt {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// seriesKey flattens labels into a stable string suitable for use as a map key, independent of the
+// order the caller's map iterates in.
+func seriesKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// formatLabels renders labels as an OpenMetrics label set, e.g. `{a="1",b="2"}`, or the empty
+// string if labels is empty.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%q", k, labels[k])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
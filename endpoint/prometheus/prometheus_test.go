@@ -0,0 +1,201 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/promremotewrite"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+var _ pipeline.Endpoint = (*PrometheusEndpoint)(nil)
+
+func intReport(name string, labels map[string]string, value int64) pipeline.EndpointReport {
+	r, _ := pipeline.NewEndpointReport(metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{
+			Name:      name,
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    labels,
+			Value:     metrics.MetricValue{Int64Value: &value},
+		},
+	}, nil)
+	return r
+}
+
+func scrape(t *testing.T, ep *PrometheusEndpoint) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ep.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestPrometheusEndpoint_CounterAccumulates(t *testing.T) {
+	ep := NewPrometheusEndpoint("test", config.PrometheusEndpoint{
+		Port: 0,
+		Metrics: []config.PrometheusEndpointMetric{
+			{Metric: "requests", Help: "Total requests.", Kind: config.PrometheusKindCounter},
+		},
+	})
+	defer ep.Release()
+
+	if err := ep.Send(intReport("requests", nil, 3)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := ep.Send(intReport("requests", nil, 4)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	body := scrape(t, ep)
+	if !strings.Contains(body, "# HELP requests Total requests.\n") {
+		t.Errorf("missing HELP line:\n%s", body)
+	}
+	if !strings.Contains(body, "# TYPE requests counter\n") {
+		t.Errorf("missing TYPE line:\n%s", body)
+	}
+	if !strings.Contains(body, "requests 7\n") {
+		t.Errorf("expected accumulated sum of 7:\n%s", body)
+	}
+}
+
+func TestPrometheusEndpoint_GaugeReportsLastValue(t *testing.T) {
+	ep := NewPrometheusEndpoint("test", config.PrometheusEndpoint{
+		Port: 0,
+		Metrics: []config.PrometheusEndpointMetric{
+			{Metric: "queue_depth", Kind: config.PrometheusKindGauge},
+		},
+	})
+	defer ep.Release()
+
+	if err := ep.Send(intReport("queue_depth", nil, 5)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := ep.Send(intReport("queue_depth", nil, 9)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	body := scrape(t, ep)
+	if !strings.Contains(body, "# TYPE queue_depth gauge\n") {
+		t.Errorf("missing TYPE line:\n%s", body)
+	}
+	if !strings.Contains(body, "queue_depth 9\n") {
+		t.Errorf("expected last value of 9, not an accumulated sum:\n%s", body)
+	}
+}
+
+func TestPrometheusEndpoint_LabelsTrackedAsSeparateSeries(t *testing.T) {
+	ep := NewPrometheusEndpoint("test", config.PrometheusEndpoint{Port: 0})
+	defer ep.Release()
+
+	if err := ep.Send(intReport("requests", map[string]string{"region": "us"}, 1)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := ep.Send(intReport("requests", map[string]string{"region": "eu"}, 2)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	body := scrape(t, ep)
+	if !strings.Contains(body, `requests{region="us"} 1`) {
+		t.Errorf("missing us series:\n%s", body)
+	}
+	if !strings.Contains(body, `requests{region="eu"} 2`) {
+		t.Errorf("missing eu series:\n%s", body)
+	}
+	// No configured metadata: defaults are the metric's own name as HELP and counter as TYPE.
+	if !strings.Contains(body, "# HELP requests requests\n") {
+		t.Errorf("missing default HELP line:\n%s", body)
+	}
+	if !strings.Contains(body, "# TYPE requests counter\n") {
+		t.Errorf("missing default TYPE line:\n%s", body)
+	}
+}
+
+func TestPrometheusEndpoint_IncludeLabelsDropsUnlistedLabels(t *testing.T) {
+	ep := NewPrometheusEndpoint("test", config.PrometheusEndpoint{
+		Port: 0,
+		Metrics: []config.PrometheusEndpointMetric{
+			{Metric: "requests", IncludeLabels: []string{"region"}},
+		},
+	})
+	defer ep.Release()
+
+	labels := map[string]string{"region": "us", "pod": "abc123"}
+	if err := ep.Send(intReport("requests", labels, 1)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	body := scrape(t, ep)
+	if !strings.Contains(body, `requests{region="us"} 1`) {
+		t.Errorf("missing filtered series:\n%s", body)
+	}
+	if strings.Contains(body, "pod") {
+		t.Errorf("expected pod label to be dropped:\n%s", body)
+	}
+}
+
+func TestPrometheusEndpoint_RemoteWriteSnapshotSetsValue(t *testing.T) {
+	ep := NewPrometheusEndpoint("test", config.PrometheusEndpoint{
+		Port:            0,
+		RemoteWritePath: "/api/v1/write",
+		Metrics: []config.PrometheusEndpointMetric{
+			{Metric: "requests_total", Kind: config.PrometheusKindCounter},
+		},
+	})
+	defer ep.Release()
+
+	ts := promremotewrite.TimeSeries{
+		Labels:  []promremotewrite.Label{{Name: "__name__", Value: "requests_total"}, {Name: "region", Value: "us"}},
+		Samples: []promremotewrite.Sample{{Value: 41}, {Value: 99}},
+	}
+	body := promremotewrite.EncodeSnappy(promremotewrite.MarshalWriteRequest(ts))
+
+	req := httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	ep.handleRemoteWrite(w, req)
+	if w.Code != 204 {
+		t.Fatalf("handleRemoteWrite: status = %v, body = %q", w.Code, w.Body.String())
+	}
+
+	scraped := scrape(t, ep)
+	if !strings.Contains(scraped, `requests_total{region="us"} 99`) {
+		t.Errorf("expected the snapshot's last sample (99), not a sum with an earlier one:\n%s", scraped)
+	}
+}
+
+func TestPrometheusEndpoint_RemoteWriteRejectsInvalidBody(t *testing.T) {
+	ep := NewPrometheusEndpoint("test", config.PrometheusEndpoint{Port: 0, RemoteWritePath: "/api/v1/write"})
+	defer ep.Release()
+
+	req := httptest.NewRequest("POST", "/api/v1/write", bytes.NewReader([]byte("not a valid write request")))
+	w := httptest.NewRecorder()
+	ep.handleRemoteWrite(w, req)
+	if w.Code != 400 {
+		t.Errorf("handleRemoteWrite: status = %v, want 400", w.Code)
+	}
+}
+
+func TestPrometheusEndpoint_IsTransient(t *testing.T) {
+	ep := &PrometheusEndpoint{}
+	if ep.IsTransient(nil) {
+		t.Errorf("IsTransient should always be false")
+	}
+}
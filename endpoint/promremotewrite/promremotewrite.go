@@ -0,0 +1,234 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package promremotewrite implements a pipeline.Endpoint that pushes aggregated metrics to a
+// Prometheus remote-write receiver (e.g. Mimir, Cortex, or Prometheus itself) as a
+// snappy-compressed protobuf WriteRequest, one TimeSeries per report.
+package promremotewrite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+	"github.com/golang/glog"
+)
+
+const (
+	httpTimeout = 60 * time.Second
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 250 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
+)
+
+// PromRemoteWriteEndpoint pushes aggregated metrics to a Prometheus remote-write receiver.
+type PromRemoteWriteEndpoint struct {
+	name   string
+	cfg    config.PromRemoteWriteEndpoint
+	client *http.Client
+	retry  retrypolicy.RetryPolicy
+	clock  clock.Clock
+}
+
+// NewPromRemoteWriteEndpoint creates a new PromRemoteWriteEndpoint from cfg. cfg must have already
+// passed config.PromRemoteWriteEndpoint.Validate.
+func NewPromRemoteWriteEndpoint(name string, cfg config.PromRemoteWriteEndpoint) (*PromRemoteWriteEndpoint, error) {
+	tlsConfig, err := buildClientTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &PromRemoteWriteEndpoint{
+		name:   name,
+		cfg:    cfg,
+		client: &http.Client{Timeout: httpTimeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		retry:  retry.NewPolicy(cfg.Backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:  clock.NewClock(),
+	}, nil
+}
+
+// buildClientTLSConfig constructs a *tls.Config implementing tc, loading its client certificate
+// and (if set) CA bundle from disk. tc must have already passed config.ClientTLSConfig.Validate. A
+// nil tc returns a nil *tls.Config, leaving http.Transport's own defaults in effect.
+func buildClientTLSConfig(tc *config.ClientTLSConfig) (*tls.Config, error) {
+	if tc == nil {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{ServerName: tc.ServerName}
+	if tc.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if tc.CAFile != "" {
+		ca, err := ioutil.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("promRemoteWrite: no certificates found in %v", tc.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+func (ep *PromRemoteWriteEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *PromRemoteWriteEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *PromRemoteWriteEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *PromRemoteWriteEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	body := EncodeSnappy(MarshalWriteRequest(ep.timeSeries(r)))
+	return retry.Do(ctx, ep.clock, ep.retry, classifyPushError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("PromRemoteWriteEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		return ep.push(ctx, body)
+	})
+}
+
+func (ep *PromRemoteWriteEndpoint) push(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, ep.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if ep.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ep.cfg.BearerToken)
+	}
+	for k, v := range ep.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return &pushError{statusCode: resp.StatusCode, body: string(respBody), header: resp.Header}
+	}
+	return nil
+}
+
+// timeSeries maps a single EndpointReport onto a remote-write TimeSeries: one sample, stamped at
+// the report's EndTime, with a "__name__" label formed from the configured MetricPrefix and the
+// report's metric name, plus the report's own labels sorted by name.
+func (ep *PromRemoteWriteEndpoint) timeSeries(r pipeline.EndpointReport) TimeSeries {
+	var value float64
+	if r.Value.Int64Value != nil {
+		value = float64(*r.Value.Int64Value)
+	} else if r.Value.DoubleValue != nil {
+		value = *r.Value.DoubleValue
+	}
+
+	names := make([]string, 0, len(r.Labels))
+	for k := range r.Labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	labels := make([]Label, 0, len(names)+1)
+	labels = append(labels, Label{Name: "__name__", Value: ep.cfg.MetricPrefix + r.Name})
+	for _, k := range names {
+		labels = append(labels, Label{Name: k, Value: r.Labels[k]})
+	}
+
+	return TimeSeries{
+		Labels:  labels,
+		Samples: []Sample{{Value: value, TimestampMillis: r.EndTime.UnixNano() / int64(time.Millisecond)}},
+	}
+}
+
+// pushError is returned for a non-2xx remote-write response.
+type pushError struct {
+	statusCode int
+	body       string
+	header     http.Header
+}
+
+func (e *pushError) Error() string {
+	return fmt.Sprintf("promRemoteWrite: push rejected: status %v: %v", e.statusCode, e.body)
+}
+
+// Use is a no-op. PromRemoteWriteEndpoint doesn't track usage.
+func (ep *PromRemoteWriteEndpoint) Use() {}
+
+// Release is a no-op. PromRemoteWriteEndpoint doesn't track usage.
+func (ep *PromRemoteWriteEndpoint) Release() error {
+	return nil
+}
+
+// IsTransient reports true for 429 and 5xx push responses, and for any error that isn't a
+// recognized pushError (e.g. a connection-level failure).
+func (ep *PromRemoteWriteEndpoint) IsTransient(err error) bool {
+	return isTransientPushError(err)
+}
+
+func isTransientPushError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	pe, ok := err.(*pushError)
+	if !ok {
+		return true
+	}
+	return pe.statusCode == http.StatusTooManyRequests || (pe.statusCode >= 500 && pe.statusCode < 600)
+}
+
+// classifyPushError is a retry.Classifier for push responses: the same classification
+// IsTransient uses, plus any Retry-After header on a rejected response, which overrides the
+// policy's own backoff delay.
+func classifyPushError(err error) (bool, time.Duration) {
+	if !isTransientPushError(err) {
+		return false, 0
+	}
+	if pe, ok := err.(*pushError); ok {
+		if d, ok := retry.RetryAfter(pe.header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
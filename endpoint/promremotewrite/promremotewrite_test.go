@@ -0,0 +1,116 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promremotewrite
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+var _ pipeline.Endpoint = (*PromRemoteWriteEndpoint)(nil)
+
+func TestPromRemoteWriteEndpoint_Push(t *testing.T) {
+	var gotHeaders http.Header
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeaders = r.Header
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ep, err := NewPromRemoteWriteEndpoint("test", config.PromRemoteWriteEndpoint{
+		Endpoint:     server.URL,
+		MetricPrefix: "ubbagent_",
+		Headers:      map[string]string{"X-Scope-OrgID": "tenant1"},
+		BearerToken:  "secret-token",
+	})
+	if err != nil {
+		t.Fatalf("NewPromRemoteWriteEndpoint: %v", err)
+	}
+
+	quantity := int64(42)
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		Id: "report1",
+		MetricReport: metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"region": "us-east1"},
+			Value:     metrics.MetricValue{Int64Value: &quantity},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildReport: %+v", err)
+	}
+	if err := ep.SendContext(context.Background(), report); err != nil {
+		t.Fatalf("SendContext: %+v", err)
+	}
+
+	if got := gotHeaders.Get("Content-Encoding"); got != "snappy" {
+		t.Errorf("Content-Encoding = %v, want snappy", got)
+	}
+	if got := gotHeaders.Get("Content-Type"); got != "application/x-protobuf" {
+		t.Errorf("Content-Type = %v, want application/x-protobuf", got)
+	}
+	if got := gotHeaders.Get("Authorization"); got != "Bearer secret-token" {
+		t.Errorf("Authorization = %v, want Bearer secret-token", got)
+	}
+	if got := gotHeaders.Get("X-Scope-OrgID"); got != "tenant1" {
+		t.Errorf("X-Scope-OrgID = %v, want tenant1", got)
+	}
+
+	src := decodeSnappyLiteral(t, gotBody)
+	ts := decodeWriteRequest(t, src)
+	if len(ts.labels) != 2 || ts.labels[0] != (Label{Name: "__name__", Value: "ubbagent_requests"}) {
+		t.Errorf("unexpected labels: %+v", ts.labels)
+	}
+	if len(ts.samples) != 1 || ts.samples[0].Value != 42 {
+		t.Errorf("unexpected samples: %+v", ts.samples)
+	}
+}
+
+func TestPromRemoteWriteEndpoint_IsTransient(t *testing.T) {
+	ep := &PromRemoteWriteEndpoint{}
+
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"non-pushError", errors.New("connection refused"), true},
+		{"http 429", &pushError{statusCode: http.StatusTooManyRequests}, true},
+		{"http 503", &pushError{statusCode: http.StatusServiceUnavailable}, true},
+		{"http 400", &pushError{statusCode: http.StatusBadRequest}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ep.IsTransient(c.err); got != c.transient {
+				t.Errorf("IsTransient(%v) = %v, expected %v", c.err, got, c.transient)
+			}
+		})
+	}
+}
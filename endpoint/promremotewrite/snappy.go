@@ -0,0 +1,120 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promremotewrite
+
+import "errors"
+
+// EncodeSnappy encodes src as a valid Snappy block (https://github.com/google/snappy/blob/main/format_description.txt):
+// a varint-encoded uncompressed length followed by one literal element holding all of src. It
+// doesn't attempt to find or encode back-references, so it never shrinks src - remote-write
+// payloads here are small, and a real compressor would add complexity this endpoint doesn't need
+// while still producing a stream any Snappy decoder (including the receivers this endpoint talks
+// to) accepts.
+func EncodeSnappy(src []byte) []byte {
+	dst := appendVarint(nil, uint64(len(src)))
+	if len(src) == 0 {
+		return dst
+	}
+	dst = appendLiteralTag(dst, len(src))
+	return append(dst, src...)
+}
+
+// appendLiteralTag appends a Snappy literal element's tag, encoding length per the element's tag
+// byte: the low two bits are 0 (EL_LITERAL); if length-1 fits in six bits it's stored directly in
+// the tag byte, otherwise the tag byte names how many following little-endian bytes hold it.
+func appendLiteralTag(dst []byte, length int) []byte {
+	n := length - 1
+	if n < 60 {
+		return append(dst, byte(n<<2))
+	}
+	var lenBytes []byte
+	for v := n; v > 0; v >>= 8 {
+		lenBytes = append(lenBytes, byte(v))
+	}
+	dst = append(dst, byte((59+len(lenBytes))<<2))
+	return append(dst, lenBytes...)
+}
+
+// DecodeSnappy decodes a Snappy block - the varint-length-prefixed literal/copy element stream
+// snappyEncode produces, and the general format any conforming Snappy encoder may produce. It's
+// exported for endpoint/prometheus's remote_write receiver, which must accept payloads from real
+// Prometheus clients, not just this package's own literal-only encoder.
+func DecodeSnappy(src []byte) ([]byte, error) {
+	uncompressedLen, n, err := decodeUvarint(src)
+	if err != nil {
+		return nil, err
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, uncompressedLen)
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0: // EL_LITERAL
+			lengthCode := int(tag >> 2)
+			src = src[1:]
+			if lengthCode < 60 {
+				length = lengthCode + 1
+			} else {
+				extra := lengthCode - 59
+				if extra < 1 || extra > 4 || len(src) < extra {
+					return nil, errors.New("promremotewrite: invalid snappy literal length")
+				}
+				var v int
+				for i := 0; i < extra; i++ {
+					v |= int(src[i]) << (8 * i)
+				}
+				length = v + 1
+				src = src[extra:]
+			}
+			if len(src) < length {
+				return nil, errors.New("promremotewrite: truncated snappy literal")
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1: // EL_COPY_1
+			if len(src) < 2 {
+				return nil, errors.New("promremotewrite: truncated snappy copy")
+			}
+			length = int((tag>>2)&0x7) + 4
+			offset = int(src[1])
+			src = src[2:]
+		case 2: // EL_COPY_2
+			if len(src) < 3 {
+				return nil, errors.New("promremotewrite: truncated snappy copy")
+			}
+			length = int(tag>>2) + 1
+			offset = int(src[1]) | int(src[2])<<8
+			src = src[3:]
+		case 3: // EL_COPY_4
+			if len(src) < 5 {
+				return nil, errors.New("promremotewrite: truncated snappy copy")
+			}
+			length = int(tag>>2) + 1
+			offset = int(src[1]) | int(src[2])<<8 | int(src[3])<<16 | int(src[4])<<24
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errors.New("promremotewrite: invalid snappy copy offset")
+		}
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promremotewrite
+
+import (
+	"bytes"
+	"testing"
+)
+
+// decodeSnappyLiteral decodes a block snappyEncode produces: a varint uncompressed length
+// followed by exactly one literal element.
+func decodeSnappyLiteral(t *testing.T, b []byte) []byte {
+	t.Helper()
+	uncompressedLen, n := decodeVarint(t, b)
+	b = b[n:]
+	if uncompressedLen == 0 {
+		return nil
+	}
+
+	tag := b[0]
+	if tag&3 != 0 {
+		t.Fatalf("expected a literal element, got tag %#x", tag)
+	}
+	lengthCode := int(tag >> 2)
+	b = b[1:]
+
+	var length int
+	if lengthCode < 60 {
+		length = lengthCode + 1
+	} else {
+		extraBytes := lengthCode - 59
+		var n int
+		for i := 0; i < extraBytes; i++ {
+			n |= int(b[i]) << (8 * i)
+		}
+		length = n + 1
+		b = b[extraBytes:]
+	}
+
+	if length != int(uncompressedLen) {
+		t.Fatalf("literal length %v doesn't match uncompressed length %v", length, uncompressedLen)
+	}
+	return b[:length]
+}
+
+func TestSnappyEncode_RoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"short", 10},
+		{"exactly sixty", 60},
+		{"just over sixty", 61},
+		{"large", 1000},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			src := make([]byte, c.size)
+			for i := range src {
+				src[i] = byte(i)
+			}
+			got := decodeSnappyLiteral(t, EncodeSnappy(src))
+			if !bytes.Equal(got, src) {
+				t.Errorf("decodeSnappyLiteral(EncodeSnappy(src)) = %v, want %v", got, src)
+			}
+		})
+	}
+}
+
+// TestDecodeSnappy_RoundTrip asserts that DecodeSnappy, used by this package's remote_write
+// receiver, recovers exactly what EncodeSnappy produced.
+func TestDecodeSnappy_RoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"short", 10},
+		{"exactly sixty", 60},
+		{"just over sixty", 61},
+		{"large", 1000},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			src := make([]byte, c.size)
+			for i := range src {
+				src[i] = byte(i)
+			}
+			got, err := DecodeSnappy(EncodeSnappy(src))
+			if err != nil {
+				t.Fatalf("DecodeSnappy: %v", err)
+			}
+			if !bytes.Equal(got, src) {
+				t.Errorf("DecodeSnappy(EncodeSnappy(src)) = %v, want %v", got, src)
+			}
+		})
+	}
+}
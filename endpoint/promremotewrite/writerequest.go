@@ -0,0 +1,272 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promremotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Label is a remote-write label, mirroring prompb.Label.
+type Label struct {
+	Name, Value string
+}
+
+// Sample is a remote-write sample: a value stamped with a Unix millisecond timestamp, mirroring
+// prompb.Sample.
+type Sample struct {
+	Value           float64
+	TimestampMillis int64
+}
+
+// TimeSeries is a remote-write series: a label set (including a "__name__" label naming its
+// metric) and the samples reported for it, mirroring prompb.TimeSeries.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// MarshalWriteRequest encodes a prometheus/prompb WriteRequest containing a single TimeSeries, in
+// protobuf wire format, without depending on a generated prompb package:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func MarshalWriteRequest(ts TimeSeries) []byte {
+	return appendLengthDelimitedField(nil, 1, marshalTimeSeries(ts))
+}
+
+func marshalTimeSeries(ts TimeSeries) []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		b = appendLengthDelimitedField(b, 1, marshalLabel(l))
+	}
+	for _, s := range ts.Samples {
+		b = appendLengthDelimitedField(b, 2, marshalSample(s))
+	}
+	return b
+}
+
+func marshalLabel(l Label) []byte {
+	var b []byte
+	b = appendStringField(b, 1, l.Name)
+	b = appendStringField(b, 2, l.Value)
+	return b
+}
+
+func marshalSample(s Sample) []byte {
+	var b []byte
+	b = appendFixed64Field(b, 1, math.Float64bits(s.Value))
+	b = appendVarintField(b, 2, uint64(s.TimestampMillis))
+	return b
+}
+
+// Protobuf wire types, as used in a field's tag byte: (fieldNumber<<3)|wireType.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendTag(b []byte, fieldNumber int, wireType int) []byte {
+	return appendVarint(b, uint64(fieldNumber)<<3|uint64(wireType))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, fieldNumber int, v uint64) []byte {
+	b = appendTag(b, fieldNumber, wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendFixed64Field(b []byte, fieldNumber int, v uint64) []byte {
+	b = appendTag(b, fieldNumber, wireFixed64)
+	for i := 0; i < 8; i++ {
+		b = append(b, byte(v))
+		v >>= 8
+	}
+	return b
+}
+
+func appendLengthDelimitedField(b []byte, fieldNumber int, data []byte) []byte {
+	b = appendTag(b, fieldNumber, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendStringField(b []byte, fieldNumber int, s string) []byte {
+	return appendLengthDelimitedField(b, fieldNumber, []byte(s))
+}
+
+// UnmarshalWriteRequest decodes a prometheus/prompb WriteRequest - the same wire format
+// MarshalWriteRequest produces, per its documented schema - into its constituent TimeSeries. b
+// must already be snappy-decompressed; see DecodeSnappy.
+func UnmarshalWriteRequest(b []byte) ([]TimeSeries, error) {
+	fields, err := decodeProtoFields(b)
+	if err != nil {
+		return nil, err
+	}
+	var out []TimeSeries
+	for _, f := range fields {
+		if f.num != 1 || f.wireType != wireBytes {
+			continue
+		}
+		ts, err := unmarshalTimeSeries(f.bytesVal)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ts)
+	}
+	return out, nil
+}
+
+func unmarshalTimeSeries(b []byte) (TimeSeries, error) {
+	fields, err := decodeProtoFields(b)
+	if err != nil {
+		return TimeSeries{}, err
+	}
+	var ts TimeSeries
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			l, err := unmarshalLabel(f.bytesVal)
+			if err != nil {
+				return TimeSeries{}, err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case 2:
+			s, err := unmarshalSample(f.bytesVal)
+			if err != nil {
+				return TimeSeries{}, err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+	}
+	return ts, nil
+}
+
+func unmarshalLabel(b []byte) (Label, error) {
+	fields, err := decodeProtoFields(b)
+	if err != nil {
+		return Label{}, err
+	}
+	var l Label
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			l.Name = string(f.bytesVal)
+		case 2:
+			l.Value = string(f.bytesVal)
+		}
+	}
+	return l, nil
+}
+
+func unmarshalSample(b []byte) (Sample, error) {
+	fields, err := decodeProtoFields(b)
+	if err != nil {
+		return Sample{}, err
+	}
+	var s Sample
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			s.Value = math.Float64frombits(f.fixed64Val)
+		case 2:
+			s.TimestampMillis = int64(f.varintVal)
+		}
+	}
+	return s, nil
+}
+
+// protoField is one decoded top-level protobuf field: its field number and wire type, with only
+// the payload matching that wire type populated.
+type protoField struct {
+	num        int
+	wireType   int
+	varintVal  uint64
+	fixed64Val uint64
+	bytesVal   []byte
+}
+
+// decodeProtoFields parses b as a sequence of protobuf fields, covering the varint, fixed64, and
+// length-delimited wire types this package's own messages use.
+func decodeProtoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		tag, n, err := decodeUvarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+		f := protoField{num: int(tag >> 3), wireType: int(tag & 7)}
+		switch f.wireType {
+		case wireVarint:
+			f.varintVal, n, err = decodeUvarint(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, errors.New("promremotewrite: truncated fixed64 field")
+			}
+			f.fixed64Val = uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+				uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+			b = b[8:]
+		case wireBytes:
+			length, ln, err := decodeUvarint(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[ln:]
+			if uint64(len(b)) < length {
+				return nil, errors.New("promremotewrite: truncated length-delimited field")
+			}
+			f.bytesVal = b[:length]
+			b = b[length:]
+		default:
+			return nil, fmt.Errorf("promremotewrite: unsupported wire type %v", f.wireType)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// decodeUvarint decodes a protobuf varint from the start of b, returning its value and the number
+// of bytes consumed.
+func decodeUvarint(b []byte) (uint64, int, error) {
+	var v uint64
+	var shift uint
+	for i, c := range b {
+		if shift >= 64 {
+			return 0, 0, errors.New("promremotewrite: varint overflow")
+		}
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, i + 1, nil
+		}
+		shift += 7
+	}
+	return 0, 0, errors.New("promremotewrite: truncated varint")
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promremotewrite
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// decodedTimeSeries is the product of decodeWriteRequest, used by tests to assert on the
+// WriteRequest this package's encoder produces without depending on a generated prompb package.
+type decodedTimeSeries struct {
+	labels  []Label
+	samples []Sample
+}
+
+// decodeWriteRequest parses the bytes MarshalWriteRequest produces, asserting on the same
+// WriteRequest/TimeSeries/Label/Sample schema documented in MarshalWriteRequest.
+func decodeWriteRequest(t *testing.T, b []byte) decodedTimeSeries {
+	t.Helper()
+	var out decodedTimeSeries
+	fields := decodeFields(t, b)
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		for _, tsField := range decodeFields(t, f.bytesVal) {
+			switch tsField.num {
+			case 1:
+				lblFields := decodeFields(t, tsField.bytesVal)
+				out.labels = append(out.labels, Label{Name: string(fieldBytes(t, lblFields, 1)), Value: string(fieldBytes(t, lblFields, 2))})
+			case 2:
+				smplFields := decodeFields(t, tsField.bytesVal)
+				out.samples = append(out.samples, Sample{
+					Value:           math.Float64frombits(fieldFixed64(t, smplFields, 1)),
+					TimestampMillis: int64(fieldVarint(t, smplFields, 2)),
+				})
+			}
+		}
+	}
+	return out
+}
+
+type decodedField struct {
+	num       int
+	wireType  int
+	varintVal uint64
+	bytesVal  []byte
+}
+
+func decodeFields(t *testing.T, b []byte) []decodedField {
+	t.Helper()
+	var fields []decodedField
+	for len(b) > 0 {
+		tag, n := decodeVarint(t, b)
+		b = b[n:]
+		f := decodedField{num: int(tag >> 3), wireType: int(tag & 7)}
+		switch f.wireType {
+		case wireVarint:
+			f.varintVal, n = decodeVarint(t, b)
+			b = b[n:]
+		case wireFixed64:
+			f.varintVal = uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+				uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+			b = b[8:]
+		case wireBytes:
+			length, ln := decodeVarint(t, b)
+			b = b[ln:]
+			f.bytesVal = b[:length]
+			b = b[length:]
+		default:
+			t.Fatalf("unexpected wire type %v", f.wireType)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func decodeVarint(t *testing.T, b []byte) (uint64, int) {
+	t.Helper()
+	var v uint64
+	var shift uint
+	for i, c := range b {
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+		shift += 7
+	}
+	t.Fatalf("truncated varint")
+	return 0, 0
+}
+
+func fieldBytes(t *testing.T, fields []decodedField, num int) []byte {
+	t.Helper()
+	for _, f := range fields {
+		if f.num == num {
+			return f.bytesVal
+		}
+	}
+	t.Fatalf("missing field %v", num)
+	return nil
+}
+
+func fieldFixed64(t *testing.T, fields []decodedField, num int) uint64 {
+	t.Helper()
+	for _, f := range fields {
+		if f.num == num {
+			return f.varintVal
+		}
+	}
+	t.Fatalf("missing field %v", num)
+	return 0
+}
+
+func fieldVarint(t *testing.T, fields []decodedField, num int) uint64 {
+	return fieldFixed64(t, fields, num)
+}
+
+func TestMarshalWriteRequest_RoundTrip(t *testing.T) {
+	ts := TimeSeries{
+		Labels:  []Label{{Name: "__name__", Value: "requests_total"}, {Name: "region", Value: "us-east1"}},
+		Samples: []Sample{{Value: 42.5, TimestampMillis: 1000}},
+	}
+	got := decodeWriteRequest(t, MarshalWriteRequest(ts))
+	want := decodedTimeSeries{labels: ts.Labels, samples: ts.Samples}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeWriteRequest(MarshalWriteRequest(ts)) = %+v, want %+v", got, want)
+	}
+}
+
+// TestUnmarshalWriteRequest_RoundTrip asserts that UnmarshalWriteRequest, the decoder this
+// package's remote_write receiver uses, recovers the same TimeSeries MarshalWriteRequest encoded,
+// including multiple series in one request.
+func TestUnmarshalWriteRequest_RoundTrip(t *testing.T) {
+	a := TimeSeries{
+		Labels:  []Label{{Name: "__name__", Value: "requests_total"}, {Name: "region", Value: "us-east1"}},
+		Samples: []Sample{{Value: 42.5, TimestampMillis: 1000}},
+	}
+	b := TimeSeries{
+		Labels:  []Label{{Name: "__name__", Value: "queue_depth"}},
+		Samples: []Sample{{Value: 3, TimestampMillis: 2000}},
+	}
+	body := append(MarshalWriteRequest(a), MarshalWriteRequest(b)...)
+
+	got, err := UnmarshalWriteRequest(body)
+	if err != nil {
+		t.Fatalf("UnmarshalWriteRequest: %v", err)
+	}
+	want := []TimeSeries{a, b}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalWriteRequest(...) = %+v, want %+v", got, want)
+	}
+}
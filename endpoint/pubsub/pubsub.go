@@ -0,0 +1,154 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pubsub implements a pipeline.Endpoint that publishes each report as a Cloud Pub/Sub
+// message, letting downstream consumers (Dataflow, a custom subscriber, ...) fan a single stream
+// of usage reports out to their own systems.
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/identity"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pubsubScope is the OAuth2 scope requested for Pub/Sub publisher calls.
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+
+// pubsubBasePath is the audience presented when the configured identity's SelfSignedJWT is set;
+// it must match the base URL the client library dials.
+const pubsubBasePath = "https://pubsub.googleapis.com/"
+
+// reportContext is persisted in an EndpointReport's Context so that a RetryingSender's retries of
+// the same report publish identical message bytes under the identical ordering key, rather than
+// recomputing either of them (and potentially producing a different key, which would defeat
+// Pub/Sub's exactly-once-per-key ordering guarantee) on every attempt.
+type reportContext struct {
+	Data        []byte `json:"data"`
+	OrderingKey string `json:"orderingKey"`
+}
+
+// PubSubEndpoint is a pipeline.Endpoint that publishes each report it's sent to a Pub/Sub topic.
+type PubSubEndpoint struct {
+	name     string
+	tracker  pipeline.UsageTracker
+	orderKey *template.Template
+
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+// NewPubSubEndpoint creates a new PubSubEndpoint from cfg, authenticating as gcp.
+func NewPubSubEndpoint(name string, cfg config.PubSubEndpoint, gcp *config.GCPIdentity) (*PubSubEndpoint, error) {
+	orderKey, err := parseOrderingKeyTemplate(cfg.OrderingKeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	ts, err := identity.NewTokenSource(ctx, gcp, pubsubBasePath, pubsubScope)
+	if err != nil {
+		return nil, err
+	}
+	client, err := pubsub.NewClient(ctx, cfg.ProjectId, option.WithTokenSource(ts))
+	if err != nil {
+		return nil, err
+	}
+	topic := client.Topic(cfg.Topic)
+	topic.EnableMessageOrdering = true
+	return &PubSubEndpoint{name: name, orderKey: orderKey, client: client, topic: topic}, nil
+}
+
+func parseOrderingKeyTemplate(tmpl string) (*template.Template, error) {
+	if tmpl == "" {
+		return nil, nil
+	}
+	return template.New("pubsub-ordering-key").Parse(tmpl)
+}
+
+func (ep *PubSubEndpoint) Name() string {
+	return ep.name
+}
+
+// BuildReport serializes r as JSON and computes its ordering key by expanding the configured
+// OrderingKeyTemplate over r, defaulting to r.Id if no template was configured. Both are persisted
+// in the returned EndpointReport's Context so that Send publishes identical bytes under an
+// identical key on every retry of the same report.
+func (ep *PubSubEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return pipeline.EndpointReport{}, err
+	}
+	key := r.Id
+	if ep.orderKey != nil {
+		var buf bytes.Buffer
+		if err := ep.orderKey.Execute(&buf, r); err != nil {
+			return pipeline.EndpointReport{}, fmt.Errorf("pubsub: evaluating orderingKeyTemplate: %v", err)
+		}
+		key = buf.String()
+	}
+	return pipeline.NewEndpointReport(r, reportContext{Data: data, OrderingKey: key})
+}
+
+func (ep *PubSubEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *PubSubEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	var rc reportContext
+	if err := r.UnmarshalContext(&rc); err != nil {
+		return fmt.Errorf("pubsub: %v", err)
+	}
+	result := ep.topic.Publish(ctx, &pubsub.Message{Data: rc.Data, OrderingKey: rc.OrderingKey})
+	_, err := result.Get(ctx)
+	return err
+}
+
+// IsTransient reports true for the gRPC codes that indicate a retryable Publish failure
+// (Unavailable, DeadlineExceeded, ResourceExhausted, Internal, Aborted), and false for the codes
+// that indicate the message itself (or the topic it names) is the problem and retrying as-is would
+// never succeed (InvalidArgument, PermissionDenied, NotFound, FailedPrecondition).
+func (ep *PubSubEndpoint) IsTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Use increments the PubSubEndpoint's usage count. See pipeline.Component.Use.
+func (ep *PubSubEndpoint) Use() {
+	ep.tracker.Use()
+}
+
+// Release decrements the PubSubEndpoint's usage count. If it reaches 0, Release stops the
+// publisher (flushing any outstanding Publish calls) and closes the underlying client. See
+// pipeline.Component.Release.
+func (ep *PubSubEndpoint) Release() error {
+	return ep.tracker.Release(func() error {
+		ep.topic.Stop()
+		return ep.client.Close()
+	})
+}
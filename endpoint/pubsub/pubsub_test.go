@@ -0,0 +1,125 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ pipeline.Endpoint = (*PubSubEndpoint)(nil)
+
+func TestPubSubEndpoint_BuildReport(t *testing.T) {
+	report := metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{
+			Name:   "requests",
+			Labels: map[string]string{"tenant": "acme"},
+		},
+		Id: "report1",
+	}
+
+	t.Run("defaults to the report id", func(t *testing.T) {
+		ep := &PubSubEndpoint{name: "test"}
+		er, err := ep.BuildReport(report)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		rc := unmarshalContext(t, er)
+		if rc.OrderingKey != "report1" {
+			t.Errorf("expected ordering key %q, got %q", "report1", rc.OrderingKey)
+		}
+		var gotReport metrics.StampedMetricReport
+		if err := json.Unmarshal(rc.Data, &gotReport); err != nil {
+			t.Fatalf("unexpected error unmarshaling data: %+v", err)
+		}
+		if gotReport.Id != report.Id {
+			t.Errorf("expected serialized report id %q, got %q", report.Id, gotReport.Id)
+		}
+	})
+
+	t.Run("expands the configured template", func(t *testing.T) {
+		tmpl, err := parseOrderingKeyTemplate("{{.Labels.tenant}}")
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		ep := &PubSubEndpoint{name: "test", orderKey: tmpl}
+		er, err := ep.BuildReport(report)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		rc := unmarshalContext(t, er)
+		if rc.OrderingKey != "acme" {
+			t.Errorf("expected ordering key %q, got %q", "acme", rc.OrderingKey)
+		}
+	})
+
+	t.Run("retrying BuildReport produces identical bytes and key", func(t *testing.T) {
+		tmpl, err := parseOrderingKeyTemplate("{{.Labels.tenant}}")
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		ep := &PubSubEndpoint{name: "test", orderKey: tmpl}
+		first, err := ep.BuildReport(report)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		second, err := ep.BuildReport(report)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if string(first.Context) != string(second.Context) {
+			t.Errorf("expected identical context across retries, got %q and %q", first.Context, second.Context)
+		}
+	})
+}
+
+func unmarshalContext(t *testing.T, er pipeline.EndpointReport) reportContext {
+	t.Helper()
+	var rc reportContext
+	if err := er.UnmarshalContext(&rc); err != nil {
+		t.Fatalf("unexpected error unmarshaling context: %+v", err)
+	}
+	return rc
+}
+
+func TestPubSubEndpoint_IsTransient(t *testing.T) {
+	ep := &PubSubEndpoint{name: "test"}
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{status.Error(codes.ResourceExhausted, "resource exhausted"), true},
+		{status.Error(codes.Internal, "internal"), true},
+		{status.Error(codes.Aborted, "aborted"), true},
+		{status.Error(codes.InvalidArgument, "invalid argument"), false},
+		{status.Error(codes.PermissionDenied, "permission denied"), false},
+		{status.Error(codes.NotFound, "not found"), false},
+		{status.Error(codes.FailedPrecondition, "failed precondition"), false},
+		{errors.New("some other error"), false},
+	}
+	for _, c := range cases {
+		if got := ep.IsTransient(c.err); got != c.transient {
+			t.Errorf("IsTransient(%v) = %v, want %v", c.err, got, c.transient)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retry provides a retry loop that endpoint implementations use to wrap a single outbound
+// call with their own in-process backoff, independent of the outer retry queue a RetryingSender
+// applies around the endpoint's Send as a whole. It's shared so that every endpoint classifies and
+// waits between attempts the same way; only the per-protocol error classification differs.
+package retry
+
+import (
+	"context"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+)
+
+// Classifier reports whether err indicates a call wrapped by Do can be retried, and how long the
+// caller was explicitly told to wait before trying again - for example, a Retry-After response
+// header. A zero retryAfter means the policy's own backoff delay applies unmodified.
+type Classifier func(err error) (retriable bool, retryAfter time.Duration)
+
+// NewPolicy builds the retrypolicy.RetryPolicy a Do loop uses for backoff timing, applying
+// defaultBase, defaultCap, and defaultMaxAttempts for any field left at zero in cfg (including a
+// nil cfg).
+func NewPolicy(cfg *config.BackoffPolicy, defaultBase, defaultCap time.Duration, defaultMaxAttempts int) *retrypolicy.DecorrelatedJitter {
+	base := defaultBase
+	max := defaultCap
+	maxAttempts := defaultMaxAttempts
+	if cfg != nil {
+		if cfg.BaseMillis > 0 {
+			base = time.Duration(cfg.BaseMillis) * time.Millisecond
+		}
+		if cfg.CapMillis > 0 {
+			max = time.Duration(cfg.CapMillis) * time.Millisecond
+		}
+		if cfg.MaxAttempts > 0 {
+			maxAttempts = cfg.MaxAttempts
+		}
+	}
+	return retrypolicy.NewDecorrelatedJitter(base, max, maxAttempts)
+}
+
+// Do calls attempt repeatedly, starting at attempt number 1, until it succeeds, classify reports
+// its error as non-retriable, policy's attempt budget is exhausted, or ctx is canceled. Between
+// attempts, Do waits for the longer of policy's own backoff and any retryAfter classify returns;
+// onRetry, if non-nil, is called with that wait before it begins.
+func Do(ctx context.Context, clk clock.Clock, policy retrypolicy.RetryPolicy, classify Classifier, onRetry func(attempt int, err error, delay time.Duration), attempt func(attempt int) error) error {
+	for n := 1; ; n++ {
+		err := attempt(n)
+		if err == nil {
+			return nil
+		}
+
+		retriable, retryAfter := classify(err)
+		if !retriable {
+			return err
+		}
+		delay, retry := policy.NextBackoff(n, err)
+		if !retry {
+			return err
+		}
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+
+		if onRetry != nil {
+			onRetry(n, err, delay)
+		}
+
+		timer := clk.NewTimerAt(clk.Now().Add(delay))
+		select {
+		case <-timer.GetC():
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"absent", "", 0, false},
+		{"delta-seconds", "120", 120 * time.Second, true},
+		{"zero delta-seconds", "0", 0, true},
+		{"negative delta-seconds", "-5", 0, false},
+		{"future http-date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{"past http-date", now.Add(-90 * time.Second).Format(http.TimeFormat), 0, true},
+		{"unparseable", "not-a-value", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := make(http.Header)
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			got, ok := RetryAfter(header, now)
+			if ok != tt.wantOK {
+				t.Fatalf("RetryAfter() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && got != tt.want {
+				t.Errorf("RetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
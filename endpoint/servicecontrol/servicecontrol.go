@@ -16,22 +16,52 @@ package servicecontrol
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
-	"github.com/GoogleCloudPlatform/ubbagent/endpoint"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/identity"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/ratelimit"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
 	"github.com/golang/glog"
-	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2"
 	"google.golang.org/api/googleapi"
 	servicecontrol "google.golang.org/api/servicecontrol/v1"
+	scpb "google.golang.org/genproto/googleapis/api/servicecontrol/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	agentIdLabel = "goog-ubb-agent-id"
 	timeout      = 60 * time.Second
+
+	// serviceControlGRPCTarget is dialed when a ServiceControlEndpoint is configured with
+	// transport "grpc", in place of the REST API's servicecontrol.New client.
+	serviceControlGRPCTarget = "servicecontrol.googleapis.com:443"
+
+	// rateLimitMaxSlack bounds how much unused capacity a ServiceControlEndpoint's rate limiter
+	// accumulates during idle periods, so a burst of queued reports after a quiet spell isn't sent
+	// all at once.
+	rateLimitMaxSlack = 10 * time.Second
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 500 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
 )
 
 type ServiceControlEndpoint struct {
@@ -40,7 +70,12 @@ type ServiceControlEndpoint struct {
 	consumerId  string
 	agentId     string
 	keyData     string
+	userLabels  map[string]string
 	service     *servicecontrol.Service
+	grpcClient  scpb.ServiceControllerClient
+	limiter     ratelimit.Limiter
+	retry       retrypolicy.RetryPolicy
+	clock       clock.Clock
 	tracker     pipeline.UsageTracker
 }
 
@@ -53,66 +88,351 @@ func (r serviceControlReport) Id() string {
 	return r.ReportId
 }
 
-// NewServiceControlEndpoint creates a new ServiceControlEndpoint.
-func NewServiceControlEndpoint(name, serviceName, agentId string, consumerId string, jsonKey []byte) (*ServiceControlEndpoint, error) {
-	config, err := google.JWTConfigFromJSON(jsonKey, servicecontrol.ServicecontrolScope)
+// serviceControlBasePath is the audience presented when gcp.SelfSignedJWT is set; it must match
+// the base URL servicecontrol.New dials, since Service Control validates self-signed JWTs against it.
+const serviceControlBasePath = "https://servicecontrol.googleapis.com/"
+
+// NewServiceControlEndpoint creates a new ServiceControlEndpoint. gcp may hold a literal service
+// account key, a workload identity federation (external account) configuration, Application
+// Default Credentials, or a self-signed JWT configuration; either way, credentials are obtained
+// via identity.NewTokenSource rather than a bare JWT client. If maxQPS is greater than zero, Send
+// calls are paced to no more than maxQPS requests per second. backoff configures the endpoint's
+// in-process retry policy; a nil value selects the built-in defaults. userLabels, if non-empty,
+// are merged into every Operation's labels; a per-report label with the same key takes precedence.
+// transport selects how reports are sent: "http" (or "") uses the REST client, and "grpc" dials
+// the v1 gRPC API directly, reusing the same connection across calls.
+func NewServiceControlEndpoint(name, serviceName, agentId string, consumerId string, userLabels map[string]string, gcp *config.GCPIdentity, maxQPS float64, backoff *config.BackoffPolicy, transport string) (*ServiceControlEndpoint, error) {
+	ctx := context.Background()
+	ts, err := identity.NewTokenSource(ctx, gcp, serviceControlBasePath, servicecontrol.ServicecontrolScope)
 	if err != nil {
 		return nil, err
 	}
-	client := config.Client(context.Background())
+	if transport == "grpc" {
+		conn, err := grpc.Dial(serviceControlGRPCTarget,
+			grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})),
+			grpc.WithPerRPCCredentials(oauth.TokenSource{TokenSource: ts}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return newServiceControlEndpoint(name, serviceName, agentId, consumerId, userLabels, nil, scpb.NewServiceControllerClient(conn), maxQPS, backoff, clock.NewClock()), nil
+	}
+	client := oauth2.NewClient(ctx, ts)
 	client.Timeout = timeout
 	service, err := servicecontrol.New(client)
 	if err != nil {
 		return nil, err
 	}
-	return newServiceControlEndpoint(name, serviceName, agentId, consumerId, service), nil
+	return newServiceControlEndpoint(name, serviceName, agentId, consumerId, userLabels, service, nil, maxQPS, backoff, clock.NewClock()), nil
 }
 
-func newServiceControlEndpoint(name, serviceName, agentId, consumerId string, service *servicecontrol.Service) *ServiceControlEndpoint {
+func newServiceControlEndpoint(name, serviceName, agentId, consumerId string, userLabels map[string]string, service *servicecontrol.Service, grpcClient scpb.ServiceControllerClient, maxQPS float64, backoff *config.BackoffPolicy, clk clock.Clock) *ServiceControlEndpoint {
 	ep := &ServiceControlEndpoint{
 		name:        name,
 		serviceName: serviceName,
 		agentId:     agentId,
 		consumerId:  consumerId,
+		userLabels:  userLabels,
 		service:     service,
+		grpcClient:  grpcClient,
+		retry:       retry.NewPolicy(backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:       clk,
+	}
+	if maxQPS > 0 {
+		ep.limiter = ratelimit.NewLimiter(maxQPS, rateLimitMaxSlack)
 	}
 	return ep
 }
 
+// classifyServiceControlError is a retry.Classifier for Service Control API errors: a context
+// deadline, a connection-level error, a 429 (rate-limited), a 5xx response, or a response carrying
+// one of the RESOURCE_EXHAUSTED/UNAVAILABLE/INTERNAL reasons is retriable; everything else -
+// including a context.Canceled error - is terminal. A Retry-After header on the response, if
+// present, overrides the policy's own backoff delay.
+func classifyServiceControlError(err error) (bool, time.Duration) {
+	if !isTransientServiceControlError(err) {
+		return false, 0
+	}
+	if ae, ok := err.(*googleapi.Error); ok {
+		if d, ok := retry.RetryAfter(ae.Header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
+
+// isTransientServiceControlError reports whether err indicates a Service Control API call can be
+// retried: a context deadline, a connection-level error, a 429/UNAVAILABLE/DEADLINE_EXCEEDED
+// response (REST or gRPC), an EndpointErrors whose every entry is itself transient, or an error
+// item carrying a RESOURCE_EXHAUSTED/UNAVAILABLE/INTERNAL reason. An error already wrapped with
+// pipeline.NewPermanentError - see sendOperations/sendOperationsGRPC - is never transient.
+func isTransientServiceControlError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if pipeline.IsPermanent(err) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		// The caller abandoned the operation; retrying serves no purpose.
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// The call didn't complete within its deadline, but may succeed given more time.
+		return true
+	}
+	if reportErrs, ok := err.(EndpointErrors); ok {
+		return reportErrs.transient()
+	}
+	if st, ok := grpcstatus.FromError(err); ok {
+		return isTransientCode(int64(st.Code()))
+	}
+	ae, ok := err.(*googleapi.Error)
+	if !ok {
+		// Some non-http error (perhaps a connection refused or timeout?)
+		// We'll retry.
+		return true
+	}
+	if ae.Code == http.StatusTooManyRequests || (ae.Code >= 500 && ae.Code < 600) {
+		return true
+	}
+	for _, item := range ae.Errors {
+		switch item.Reason {
+		case "RESOURCE_EXHAUSTED", "UNAVAILABLE", "INTERNAL":
+			return true
+		}
+	}
+	return false
+}
+
 func (ep *ServiceControlEndpoint) Name() string {
 	return ep.name
 }
 
-func (ep *ServiceControlEndpoint) Send(report endpoint.EndpointReport) error {
-	req := &servicecontrol.ReportRequest{
-		Operations: []*servicecontrol.Operation{ep.format(report)},
+func (ep *ServiceControlEndpoint) Send(report pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), report)
+}
+
+func (ep *ServiceControlEndpoint) SendContext(ctx context.Context, report pipeline.EndpointReport) error {
+	return ep.sendOperations(ctx, []pipeline.EndpointReport{report})
+}
+
+// SendBatch sends every report in reports in a single Report call, and is used instead of
+// repeated Send calls when a RetryPolicy configures BatchMaxReports. It implements
+// pipeline.BatchEndpoint.
+func (ep *ServiceControlEndpoint) SendBatch(reports []pipeline.EndpointReport) error {
+	return ep.sendOperations(context.Background(), reports)
+}
+
+// sendOperations issues a single Report call carrying one Operation per entry in reports. A
+// request-level failure (a non-2xx response, a transport error) is retried in-process by
+// retry.Do, the same as a single-report Send always has been. A request that otherwise succeeds
+// but carries per-operation failures in its response - see EndpointErrors - returns those as an
+// EndpointErrors error instead of silently dropping them.
+func (ep *ServiceControlEndpoint) sendOperations(ctx context.Context, reports []pipeline.EndpointReport) error {
+	if ep.grpcClient != nil {
+		return ep.sendOperationsGRPC(ctx, reports)
 	}
+	ops := make([]*servicecontrol.Operation, len(reports))
+	for i, r := range reports {
+		ops[i] = ep.format(r)
+	}
+	req := &servicecontrol.ReportRequest{Operations: ops}
 	glog.V(2).Infoln("ServiceControlEndpoint:Send(): serviceName: ", ep.serviceName, " body: ", func() string {
 		r_json, _ := req.MarshalJSON()
 		return string(r_json)
 	}())
-	_, err := ep.service.Services.Report(ep.serviceName, req).Do()
-	if err != nil && !googleapi.IsNotModified(err) {
-		return err
+
+	var resp *servicecontrol.ReportResponse
+	err := retry.Do(ctx, ep.clock, ep.retry, classifyServiceControlError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("ServiceControlEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		if ep.limiter != nil {
+			ep.limiter.Take()
+		}
+		r, err := ep.service.Services.Report(ep.serviceName, req).Context(ctx).Do()
+		if err != nil && !googleapi.IsNotModified(err) {
+			return err
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return finalSendError(err)
 	}
 	glog.V(2).Infoln("ServiceControlEndpoint:Send(): success")
-	// TODO(volkman): Handle potential per-operation errors in response body
+	if resp != nil && len(resp.ReportErrors) > 0 {
+		return finalSendError(endpointErrorsFrom(resp.ReportErrors))
+	}
 	return nil
 }
 
-func (ep *ServiceControlEndpoint) BuildReport(r metrics.StampedMetricReport) (endpoint.EndpointReport, error) {
-	return endpoint.NewEndpointReport(r, nil)
+// sendOperationsGRPC is sendOperations' counterpart for a ServiceControlEndpoint configured with
+// transport "grpc": the same Report RPC, issued through ep.grpcClient instead of the REST client,
+// with Operations built by formatGRPC rather than format.
+func (ep *ServiceControlEndpoint) sendOperationsGRPC(ctx context.Context, reports []pipeline.EndpointReport) error {
+	ops := make([]*scpb.Operation, len(reports))
+	for i, r := range reports {
+		ops[i] = ep.formatGRPC(r)
+	}
+	req := &scpb.ReportRequest{ServiceName: ep.serviceName, Operations: ops}
+
+	var resp *scpb.ReportResponse
+	err := retry.Do(ctx, ep.clock, ep.retry, classifyServiceControlError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("ServiceControlEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		if ep.limiter != nil {
+			ep.limiter.Take()
+		}
+		r, err := ep.grpcClient.Report(ctx, req)
+		if err != nil {
+			return err
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return finalSendError(err)
+	}
+	glog.V(2).Infoln("ServiceControlEndpoint:Send(): success")
+	if resp != nil && len(resp.ReportErrors) > 0 {
+		return finalSendError(endpointErrorsFromGRPC(resp.ReportErrors))
+	}
+	return nil
+}
+
+// finalSendError marks err as permanent - via pipeline.NewPermanentError - if it's not transient,
+// so that a RetryingSender (or anything else inspecting the error) doesn't need to repeat this
+// endpoint's own classification to learn that retrying it would be pointless. err can be the error
+// retry.Do gave up on (already known non-retriable, since classifyServiceControlError agreed) or
+// an EndpointErrors built from a response that otherwise succeeded.
+func finalSendError(err error) error {
+	if err != nil && !isTransientServiceControlError(err) {
+		return pipeline.NewPermanentError(err)
+	}
+	return err
 }
 
-func (ep *ServiceControlEndpoint) format(r endpoint.EndpointReport) *servicecontrol.Operation {
+// endpointErrorsFrom converts the per-operation failures in a ReportResponse into an EndpointErrors
+// error, classifying each one transient or permanent from its Status code.
+func endpointErrorsFrom(errs []*servicecontrol.ReportError) EndpointErrors {
+	out := make(EndpointErrors, len(errs))
+	for i, re := range errs {
+		out[i] = &EndpointError{
+			OperationId: re.OperationId,
+			Message:     statusMessage(re.Status),
+			Transient:   isTransientEndpointErrorStatus(re.Status),
+		}
+	}
+	return out
+}
+
+func statusMessage(s *servicecontrol.Status) string {
+	if s == nil {
+		return "unknown error"
+	}
+	return s.Message
+}
+
+// endpointErrorsFromGRPC is endpointErrorsFrom's counterpart for a gRPC ReportResponse.
+func endpointErrorsFromGRPC(errs []*scpb.ReportResponse_ReportError) EndpointErrors {
+	out := make(EndpointErrors, len(errs))
+	for i, re := range errs {
+		var message string
+		var transient bool
+		if re.Status != nil {
+			message = re.Status.Message
+			transient = isTransientCode(int64(re.Status.Code))
+		} else {
+			message = "unknown error"
+		}
+		out[i] = &EndpointError{
+			OperationId: re.OperationId,
+			Message:     message,
+			Transient:   transient,
+		}
+	}
+	return out
+}
+
+// isTransientEndpointErrorStatus reports whether a per-operation EndpointError's status indicates
+// the operation can be retried, using the same classification isTransientServiceControlError
+// applies to request-level failures.
+func isTransientEndpointErrorStatus(s *servicecontrol.Status) bool {
+	if s == nil {
+		return false
+	}
+	return isTransientCode(s.Code)
+}
+
+// isTransientCode reports whether a google.rpc.Code value - shared by the REST API's Status.Code,
+// the gRPC API's ReportResponse_ReportError.Status.Code, and grpc's own status codes - indicates a
+// retriable failure: UNAVAILABLE, DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED, or INTERNAL. Everything
+// else, notably INVALID_ARGUMENT, is terminal.
+func isTransientCode(code int64) bool {
+	switch codes.Code(code) {
+	case codes.ResourceExhausted, codes.Unavailable, codes.Internal, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// EndpointError describes one Operation's failure within an otherwise successful Report response.
+// OperationId matches the ReportId of the pipeline.EndpointReport that failed (see
+// serviceControlReport.Id), so a caller that tracks reports by ID can correlate failures back to
+// specific reports.
+type EndpointError struct {
+	OperationId string
+	Message     string
+	Transient   bool
+}
+
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("operation %v: %v", e.OperationId, e.Message)
+}
+
+// EndpointErrors collects the per-operation failures from a single Report call's response.
+// ServiceControl's Report RPC has no way to resend only the failed operations within a batch - a
+// ReportResponse only tells us which operations within the request failed, not a way to retry them
+// in isolation - so IsTransient treats an EndpointErrors as transient only when every entry in it is,
+// meaning a single permanent failure fails the whole batch rather than retrying it forever.
+type EndpointErrors []*EndpointError
+
+func (e EndpointErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, re := range e {
+		msgs[i] = re.Error()
+	}
+	return fmt.Sprintf("servicecontrol: %v operation(s) failed: %v", len(e), strings.Join(msgs, "; "))
+}
+
+func (e EndpointErrors) transient() bool {
+	if len(e) == 0 {
+		return false
+	}
+	for _, re := range e {
+		if !re.Transient {
+			return false
+		}
+	}
+	return true
+}
+
+func (ep *ServiceControlEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *ServiceControlEndpoint) format(r pipeline.EndpointReport) *servicecontrol.Operation {
 	value := servicecontrol.MetricValue{
 		StartTime: r.StartTime.UTC().Format(time.RFC3339Nano),
 		EndTime:   r.EndTime.UTC().Format(time.RFC3339Nano),
 	}
-	if r.Value.Int64Value != 0 {
-		value.Int64Value = &r.Value.Int64Value
-	} else if r.Value.DoubleValue != 0 {
-		value.DoubleValue = &r.Value.DoubleValue
+	if r.Value.Int64Value != nil {
+		v := *r.Value.Int64Value
+		value.Int64Value = &v
+	} else if r.Value.DoubleValue != nil {
+		v := *r.Value.DoubleValue
+		value.DoubleValue = &v
 	}
 
 	op := &servicecontrol.Operation{
@@ -135,12 +455,65 @@ func (ep *ServiceControlEndpoint) format(r endpoint.EndpointReport) *servicecont
 		op.UserLabels = make(map[string]string)
 	}
 
+	// Fill in any configured default labels not already present in the per-report labels.
+	for k, v := range ep.userLabels {
+		if _, ok := op.UserLabels[k]; !ok {
+			op.UserLabels[k] = v
+		}
+	}
+
 	// Add the agent ID label
 	op.UserLabels[agentIdLabel] = ep.agentId
 
 	return op
 }
 
+// formatGRPC is format's counterpart for the gRPC API: the same Operation, built from the
+// generated proto types instead of the REST client's JSON-tagged structs.
+func (ep *ServiceControlEndpoint) formatGRPC(r pipeline.EndpointReport) *scpb.Operation {
+	value := &scpb.MetricValue{
+		StartTime: timestamppb.New(r.StartTime.UTC()),
+		EndTime:   timestamppb.New(r.EndTime.UTC()),
+	}
+	if r.Value.Int64Value != nil {
+		value.Value = &scpb.MetricValue_Int64Value{Int64Value: *r.Value.Int64Value}
+	} else if r.Value.DoubleValue != nil {
+		value.Value = &scpb.MetricValue_DoubleValue{DoubleValue: *r.Value.DoubleValue}
+	}
+
+	op := &scpb.Operation{
+		OperationId: r.Id,
+		// ServiceControl requires this field but doesn't indicate what it's supposed to be.
+		OperationName: fmt.Sprintf("%v/report", ep.serviceName),
+		StartTime:     timestamppb.New(r.StartTime.UTC()),
+		EndTime:       timestamppb.New(r.EndTime.UTC()),
+		ConsumerId:    ep.consumerId,
+		Labels:        r.Labels,
+		MetricValueSets: []*scpb.MetricValueSet{
+			{
+				MetricName:   fmt.Sprintf("%v/%v", ep.serviceName, r.Name),
+				MetricValues: []*scpb.MetricValue{value},
+			},
+		},
+	}
+
+	if op.Labels == nil {
+		op.Labels = make(map[string]string)
+	}
+
+	// Fill in any configured default labels not already present in the per-report labels.
+	for k, v := range ep.userLabels {
+		if _, ok := op.Labels[k]; !ok {
+			op.Labels[k] = v
+		}
+	}
+
+	// Add the agent ID label
+	op.Labels[agentIdLabel] = ep.agentId
+
+	return op
+}
+
 // Use is a no-op. ServiceControlEndpoint doesn't track usage.
 func (ep *ServiceControlEndpoint) Use() {}
 
@@ -149,16 +522,9 @@ func (ep *ServiceControlEndpoint) Release() error {
 	return nil
 }
 
+// IsTransient delegates to the same status-code classification used by the endpoint's own backoff
+// policy, so a RetryingSender's outer retry queue treats errors consistently with the in-process
+// retries already attempted by SendContext.
 func (ep *ServiceControlEndpoint) IsTransient(err error) bool {
-	if err == nil {
-		return false
-	}
-	ae, ok := err.(*googleapi.Error)
-	if !ok {
-		// Some non-http error (perhaps a connection refused or timeout?)
-		// We'll retry.
-		return true
-	}
-	// Return true if this is an http error with a 5xx code.
-	return ae.Code >= 500 && ae.Code < 600
+	return isTransientServiceControlError(err)
 }
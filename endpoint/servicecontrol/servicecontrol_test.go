@@ -15,15 +15,27 @@
 package servicecontrol
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/servicecontrol/v1"
+	scpb "google.golang.org/genproto/googleapis/api/servicecontrol/v1"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstatus "google.golang.org/grpc/status"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
-	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 )
 
 type recordingHandler struct {
@@ -59,7 +71,7 @@ func TestServiceControlEndpoint(t *testing.T) {
 	// Point the service's path at our mock HTTP instance.
 	svc.BasePath = ts.URL
 
-	ep := newServiceControlEndpoint("servicecontrol", "test-service.appspot.com", "unique-agent-id", "project_number:1234567", svc)
+	ep := newServiceControlEndpoint("servicecontrol", "test-service.appspot.com", "unique-agent-id", "project_number:1234567", nil, svc, nil, 0, nil, clock.NewClock())
 
 	t.Run("Report idempotence", func(t *testing.T) {
 		// Test a single report write
@@ -205,3 +217,255 @@ func TestServiceControlEndpoint(t *testing.T) {
 	// Test that close returns successfully.
 	ep.Close()
 }
+
+func TestIsTransientServiceControlError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"context deadline exceeded", context.DeadlineExceeded, true},
+		{"non-http error", errors.New("connection refused"), true},
+		{"http 429", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"http 503", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"http 400", &googleapi.Error{Code: http.StatusBadRequest}, false},
+		{
+			"reason RESOURCE_EXHAUSTED",
+			&googleapi.Error{Code: http.StatusOK, Errors: []googleapi.ErrorItem{{Reason: "RESOURCE_EXHAUSTED"}}},
+			true,
+		},
+		{
+			"reason UNAVAILABLE",
+			&googleapi.Error{Code: http.StatusOK, Errors: []googleapi.ErrorItem{{Reason: "UNAVAILABLE"}}},
+			true,
+		},
+		{
+			"reason INTERNAL",
+			&googleapi.Error{Code: http.StatusOK, Errors: []googleapi.ErrorItem{{Reason: "INTERNAL"}}},
+			true,
+		},
+		{
+			"reason PERMISSION_DENIED",
+			&googleapi.Error{Code: http.StatusOK, Errors: []googleapi.ErrorItem{{Reason: "PERMISSION_DENIED"}}},
+			false,
+		},
+		{
+			"EndpointErrors all transient",
+			EndpointErrors{{OperationId: "a", Transient: true}, {OperationId: "b", Transient: true}},
+			true,
+		},
+		{
+			"EndpointErrors one permanent",
+			EndpointErrors{{OperationId: "a", Transient: true}, {OperationId: "b", Transient: false}},
+			false,
+		},
+		{
+			"EndpointErrors empty",
+			EndpointErrors{},
+			false,
+		},
+		{"grpc UNAVAILABLE", grpcstatus.Error(codes.Unavailable, "backend down"), true},
+		{"grpc DEADLINE_EXCEEDED", grpcstatus.Error(codes.DeadlineExceeded, "timed out"), true},
+		{"grpc RESOURCE_EXHAUSTED", grpcstatus.Error(codes.ResourceExhausted, "quota exceeded"), true},
+		{"grpc INVALID_ARGUMENT", grpcstatus.Error(codes.InvalidArgument, "bad metric value"), false},
+		{"grpc PERMISSION_DENIED", grpcstatus.Error(codes.PermissionDenied, "no access"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientServiceControlError(tt.err); got != tt.want {
+				t.Errorf("isTransientServiceControlError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointErrorsFromGRPC(t *testing.T) {
+	errs := endpointErrorsFromGRPC([]*scpb.ReportResponse_ReportError{
+		{OperationId: "op-1", Status: &status.Status{Code: int32(codes.ResourceExhausted), Message: "quota exceeded"}},
+		{OperationId: "op-2", Status: &status.Status{Code: int32(codes.InvalidArgument), Message: "bad metric value"}},
+	})
+
+	if len(errs) != 2 {
+		t.Fatalf("len(errs) = %v, want 2", len(errs))
+	}
+	if errs[0].OperationId != "op-1" || !errs[0].Transient {
+		t.Errorf("errs[0] = %+v, want transient op-1", errs[0])
+	}
+	if errs[1].OperationId != "op-2" || errs[1].Transient {
+		t.Errorf("errs[1] = %+v, want permanent op-2", errs[1])
+	}
+	if errs.transient() {
+		t.Error("errs.transient() = true, want false (one permanent entry)")
+	}
+}
+
+// fakeServiceControllerServer is a minimal scpb.ServiceControllerServer used to validate the gRPC
+// transport's request/response mapping without dialing the real Service Control API.
+type fakeServiceControllerServer struct {
+	scpb.UnimplementedServiceControllerServer
+
+	req  *scpb.ReportRequest
+	resp *scpb.ReportResponse
+	err  error
+}
+
+func (s *fakeServiceControllerServer) Report(ctx context.Context, req *scpb.ReportRequest) (*scpb.ReportResponse, error) {
+	s.req = req
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.resp, nil
+}
+
+func startFakeServiceControllerServer(t *testing.T, fake *fakeServiceControllerServer) scpb.ServiceControllerClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("error starting listener: %+v", err)
+	}
+	server := grpc.NewServer()
+	scpb.RegisterServiceControllerServer(server, fake)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("error dialing fake server: %+v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return scpb.NewServiceControllerClient(conn)
+}
+
+func TestServiceControlEndpoint_GRPC(t *testing.T) {
+	t.Run("sends mapped request and parses response", func(t *testing.T) {
+		fake := &fakeServiceControllerServer{resp: &scpb.ReportResponse{}}
+		client := startFakeServiceControllerServer(t, fake)
+		ep := newServiceControlEndpoint("servicecontrol", "test-service.appspot.com", "unique-agent-id", "project_number:1234567", nil, nil, client, 0, nil, clock.NewClock())
+
+		report, err := ep.BuildReport(metrics.StampedMetricReport{
+			Id: "report1",
+			MetricReport: metrics.MetricReport{
+				Name:      "requests",
+				StartTime: time.Unix(0, 0),
+				EndTime:   time.Unix(1, 0),
+				Value:     metrics.MetricValue{Int64Value: int64Ptr(5)},
+			},
+		})
+		if err != nil {
+			t.Fatalf("error building report: %+v", err)
+		}
+		if err := ep.Send(report); err != nil {
+			t.Fatalf("error sending report: %+v", err)
+		}
+
+		if fake.req.ServiceName != "test-service.appspot.com" {
+			t.Errorf("ServiceName = %v, want test-service.appspot.com", fake.req.ServiceName)
+		}
+		if len(fake.req.Operations) != 1 {
+			t.Fatalf("len(Operations) = %v, want 1", len(fake.req.Operations))
+		}
+		op := fake.req.Operations[0]
+		if op.ConsumerId != "project_number:1234567" {
+			t.Errorf("ConsumerId = %v, want project_number:1234567", op.ConsumerId)
+		}
+		if op.MetricValueSets[0].MetricName != "test-service.appspot.com/requests" {
+			t.Errorf("MetricName = %v, want test-service.appspot.com/requests", op.MetricValueSets[0].MetricName)
+		}
+		if op.MetricValueSets[0].MetricValues[0].GetInt64Value() != 5 {
+			t.Errorf("Int64Value = %v, want 5", op.MetricValueSets[0].MetricValues[0].GetInt64Value())
+		}
+	})
+
+	t.Run("per-operation failure becomes an EndpointErrors", func(t *testing.T) {
+		fake := &fakeServiceControllerServer{resp: &scpb.ReportResponse{
+			ReportErrors: []*scpb.ReportResponse_ReportError{
+				{OperationId: "report1", Status: &status.Status{Code: int32(codes.InvalidArgument), Message: "bad metric value"}},
+			},
+		}}
+		client := startFakeServiceControllerServer(t, fake)
+		ep := newServiceControlEndpoint("servicecontrol", "test-service.appspot.com", "unique-agent-id", "project_number:1234567", nil, nil, client, 0, nil, clock.NewClock())
+
+		report, err := ep.BuildReport(metrics.StampedMetricReport{
+			Id: "report1",
+			MetricReport: metrics.MetricReport{
+				Name:      "requests",
+				StartTime: time.Unix(0, 0),
+				EndTime:   time.Unix(1, 0),
+				Value:     metrics.MetricValue{Int64Value: int64Ptr(5)},
+			},
+		})
+		if err != nil {
+			t.Fatalf("error building report: %+v", err)
+		}
+		err = ep.Send(report)
+		var endpointErrs EndpointErrors
+		if !errors.As(err, &endpointErrs) {
+			t.Fatalf("Send() error = %v (%T), want EndpointErrors", err, err)
+		}
+		if len(endpointErrs) != 1 || endpointErrs[0].Transient {
+			t.Errorf("Send() errors = %+v, want one permanent error", endpointErrs)
+		}
+		if ep.IsTransient(err) {
+			t.Error("IsTransient(Send() error) = true, want false")
+		}
+	})
+
+	t.Run("UNAVAILABLE is retried, INVALID_ARGUMENT is not", func(t *testing.T) {
+		cases := []struct {
+			code      codes.Code
+			transient bool
+		}{
+			{codes.Unavailable, true},
+			{codes.DeadlineExceeded, true},
+			{codes.InvalidArgument, false},
+		}
+		for _, c := range cases {
+			t.Run(c.code.String(), func(t *testing.T) {
+				fake := &fakeServiceControllerServer{err: grpcstatus.Error(c.code, "failed")}
+				client := startFakeServiceControllerServer(t, fake)
+				ep := newServiceControlEndpoint("servicecontrol", "test-service.appspot.com", "unique-agent-id", "project_number:1234567", nil, nil, client, 0, &config.BackoffPolicy{MaxAttempts: 1}, clock.NewClock())
+
+				report, err := ep.BuildReport(metrics.StampedMetricReport{
+					Id: "report1",
+					MetricReport: metrics.MetricReport{
+						Name:      "requests",
+						StartTime: time.Unix(0, 0),
+						EndTime:   time.Unix(1, 0),
+						Value:     metrics.MetricValue{Int64Value: int64Ptr(5)},
+					},
+				})
+				if err != nil {
+					t.Fatalf("error building report: %+v", err)
+				}
+				sendErr := ep.Send(report)
+				if got := ep.IsTransient(sendErr); got != c.transient {
+					t.Errorf("IsTransient(Send() error) = %v, want %v", got, c.transient)
+				}
+			})
+		}
+	})
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestEndpointErrorsFrom(t *testing.T) {
+	errs := endpointErrorsFrom([]*servicecontrol.ReportError{
+		{OperationId: "op-1", Status: &servicecontrol.Status{Code: int64(codes.ResourceExhausted), Message: "quota exceeded"}},
+		{OperationId: "op-2", Status: &servicecontrol.Status{Code: int64(codes.InvalidArgument), Message: "bad metric value"}},
+	})
+
+	if len(errs) != 2 {
+		t.Fatalf("len(errs) = %v, want 2", len(errs))
+	}
+	if errs[0].OperationId != "op-1" || !errs[0].Transient {
+		t.Errorf("errs[0] = %+v, want transient op-1", errs[0])
+	}
+	if errs[1].OperationId != "op-2" || errs[1].Transient {
+		t.Errorf("errs[1] = %+v, want permanent op-2", errs[1])
+	}
+	if errs.transient() {
+		t.Error("errs.transient() = true, want false (one permanent entry)")
+	}
+}
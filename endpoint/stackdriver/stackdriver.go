@@ -0,0 +1,224 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stackdriver implements a pipeline.Endpoint that pushes aggregated reports to Cloud
+// (Stackdriver) Monitoring as CreateTimeSeries calls.
+package stackdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/retry"
+	"github.com/GoogleCloudPlatform/ubbagent/identity"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/retrypolicy"
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+const (
+	timeout = 60 * time.Second
+
+	// Defaults used when a config.BackoffPolicy isn't supplied, or leaves a field at zero.
+	defaultBackoffBase        = 500 * time.Millisecond
+	defaultBackoffCap         = 30 * time.Second
+	defaultBackoffMaxAttempts = 5
+)
+
+// StackdriverEndpoint is a pipeline.Endpoint that writes each report as a CreateTimeSeries call
+// under a custom metric type, attached to a fixed monitored resource.
+type StackdriverEndpoint struct {
+	name             string
+	projectId        string
+	metricTypePrefix string
+	resource         *monitoring.MonitoredResource
+	kinds            map[string]string
+	service          *monitoring.Service
+	retry            retrypolicy.RetryPolicy
+	clock            clock.Clock
+	tracker          pipeline.UsageTracker
+}
+
+// stackdriverBasePath is the audience presented when gcp.SelfSignedJWT is set; it must match the
+// base URL monitoring.New dials, since Cloud Monitoring validates self-signed JWTs against it.
+const stackdriverBasePath = "https://monitoring.googleapis.com/"
+
+// NewStackdriverEndpoint creates a new StackdriverEndpoint. gcp may hold either a literal service
+// account key or a workload identity federation (external account) configuration; either way,
+// credentials are obtained via identity.NewTokenSource rather than a bare JWT client. backoff
+// configures the endpoint's in-process retry policy; a nil value selects the built-in defaults.
+func NewStackdriverEndpoint(name string, cfg config.StackdriverEndpoint, gcp *config.GCPIdentity) (*StackdriverEndpoint, error) {
+	ctx := context.Background()
+	ts, err := identity.NewTokenSource(ctx, gcp, stackdriverBasePath, monitoring.MonitoringScope)
+	if err != nil {
+		return nil, err
+	}
+	client := oauth2.NewClient(ctx, ts)
+	client.Timeout = timeout
+	service, err := monitoring.New(client)
+	if err != nil {
+		return nil, err
+	}
+	return newStackdriverEndpoint(name, cfg, service, clock.NewClock()), nil
+}
+
+func newStackdriverEndpoint(name string, cfg config.StackdriverEndpoint, service *monitoring.Service, clk clock.Clock) *StackdriverEndpoint {
+	kinds := make(map[string]string, len(cfg.Metrics))
+	for _, m := range cfg.Metrics {
+		if m.Kind != "" {
+			kinds[m.Metric] = m.Kind
+		}
+	}
+	return &StackdriverEndpoint{
+		name:             name,
+		projectId:        cfg.ProjectId,
+		metricTypePrefix: cfg.MetricTypePrefix,
+		resource: &monitoring.MonitoredResource{
+			Type:   cfg.Resource.Type,
+			Labels: cfg.Resource.Labels,
+		},
+		kinds:   kinds,
+		service: service,
+		retry:   retry.NewPolicy(cfg.Backoff, defaultBackoffBase, defaultBackoffCap, defaultBackoffMaxAttempts),
+		clock:   clk,
+	}
+}
+
+// classifyStackdriverError is a retry.Classifier for Cloud Monitoring API errors: a context
+// deadline, a connection-level error, a 429 (rate-limited), or a 5xx response is retriable;
+// everything else - including a context.Canceled error - is terminal. A Retry-After header on the
+// response, if present, overrides the policy's own backoff delay.
+func classifyStackdriverError(err error) (bool, time.Duration) {
+	if !isTransientStackdriverError(err) {
+		return false, 0
+	}
+	if ae, ok := err.(*googleapi.Error); ok {
+		if d, ok := retry.RetryAfter(ae.Header, time.Now()); ok {
+			return true, d
+		}
+	}
+	return true, 0
+}
+
+// isTransientStackdriverError reports whether err indicates a Cloud Monitoring API call can be
+// retried: a context deadline, a connection-level error, a 429 (rate-limited), or a 5xx response.
+func isTransientStackdriverError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		// The caller abandoned the operation; retrying serves no purpose.
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// The call didn't complete within its deadline, but may succeed given more time.
+		return true
+	}
+	ae, ok := err.(*googleapi.Error)
+	if !ok {
+		// Some non-http error (perhaps a connection refused or timeout?)
+		// We'll retry.
+		return true
+	}
+	return ae.Code == http.StatusTooManyRequests || (ae.Code >= 500 && ae.Code < 600)
+}
+
+func (ep *StackdriverEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *StackdriverEndpoint) Send(report pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), report)
+}
+
+func (ep *StackdriverEndpoint) SendContext(ctx context.Context, report pipeline.EndpointReport) error {
+	req := &monitoring.CreateTimeSeriesRequest{TimeSeries: []*monitoring.TimeSeries{ep.format(report)}}
+	name := fmt.Sprintf("projects/%v", ep.projectId)
+
+	err := retry.Do(ctx, ep.clock, ep.retry, classifyStackdriverError, func(attempt int, err error, delay time.Duration) {
+		glog.Warningf("StackdriverEndpoint:Send(): attempt %v failed, retrying in %v: %v", attempt, delay, err)
+	}, func(attempt int) error {
+		_, err := ep.service.Projects.TimeSeries.Create(name, req).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	glog.V(2).Infoln("StackdriverEndpoint:Send(): success")
+	return nil
+}
+
+func (ep *StackdriverEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+// format builds the CreateTimeSeries entry for r: a CUMULATIVE series (the default) carries both
+// the report's StartTime and EndTime, reporting the value as accumulated since StartTime; a GAUGE
+// series carries only EndTime, reporting the value as an instantaneous measurement.
+func (ep *StackdriverEndpoint) format(r pipeline.EndpointReport) *monitoring.TimeSeries {
+	kind := ep.kinds[r.Name]
+	metricKind := "CUMULATIVE"
+	if kind == config.StackdriverKindGauge {
+		metricKind = "GAUGE"
+	}
+
+	interval := &monitoring.TimeInterval{
+		EndTime: r.EndTime.UTC().Format(time.RFC3339Nano),
+	}
+	if metricKind == "CUMULATIVE" {
+		interval.StartTime = r.StartTime.UTC().Format(time.RFC3339Nano)
+	}
+
+	return &monitoring.TimeSeries{
+		Metric: &monitoring.Metric{
+			Type:   ep.metricTypePrefix + r.Name,
+			Labels: r.Labels,
+		},
+		Resource:   ep.resource,
+		MetricKind: metricKind,
+		Points: []*monitoring.Point{
+			{
+				Interval: interval,
+				Value: &monitoring.TypedValue{
+					Int64Value:  r.Value.Int64Value,
+					DoubleValue: r.Value.DoubleValue,
+				},
+			},
+		},
+	}
+}
+
+// Use is a no-op. StackdriverEndpoint doesn't track usage.
+func (ep *StackdriverEndpoint) Use() {}
+
+// Release is a no-op. StackdriverEndpoint doesn't track usage.
+func (ep *StackdriverEndpoint) Release() error {
+	return nil
+}
+
+// IsTransient delegates to the same status-code classification used by the endpoint's own backoff
+// policy, so a RetryingSender's outer retry queue treats errors consistently with the in-process
+// retries already attempted by SendContext.
+func (ep *StackdriverEndpoint) IsTransient(err error) bool {
+	return isTransientStackdriverError(err)
+}
@@ -0,0 +1,163 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stackdriver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"google.golang.org/api/googleapi"
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+type recordingHandler struct {
+	body []byte
+}
+
+func (h *recordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.body, _ = ioutil.ReadAll(r.Body)
+	w.Write([]byte("{}"))
+}
+
+func newTestEndpoint(t *testing.T, handler http.Handler, cfg config.StackdriverEndpoint) (*StackdriverEndpoint, *httptest.Server) {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	svc, err := monitoring.New(http.DefaultClient)
+	if err != nil {
+		t.Fatalf("Error creating client: %+v", err)
+	}
+	svc.BasePath = ts.URL
+	cfg.ProjectId = "my-proj"
+	cfg.MetricTypePrefix = "custom.googleapis.com/mysvc/"
+	cfg.Resource = config.StackdriverResource{Type: "gce_instance", Labels: map[string]string{"project_id": "my-proj"}}
+	return newStackdriverEndpoint("stackdriver", cfg, svc, clock.NewClock()), ts
+}
+
+func TestStackdriverEndpoint_SendContents(t *testing.T) {
+	handler := &recordingHandler{}
+	ep, ts := newTestEndpoint(t, handler, config.StackdriverEndpoint{
+		Metrics: []config.StackdriverEndpointMetric{
+			{Metric: "int-metric", Kind: config.StackdriverKindGauge},
+		},
+	})
+	defer ts.Close()
+
+	value := int64(10)
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{
+			Name:      "int-metric",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"foo": "bar"},
+			Value:     metrics.MetricValue{Int64Value: &value},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+	if err := ep.Send(report); err != nil {
+		t.Fatalf("error sending report: %+v", err)
+	}
+
+	var req monitoring.CreateTimeSeriesRequest
+	if err := json.Unmarshal(handler.body, &req); err != nil {
+		t.Fatalf("unmarshalling request: %+v", err)
+	}
+	if len(req.TimeSeries) != 1 {
+		t.Fatalf("expected 1 time series, got: %v", len(req.TimeSeries))
+	}
+	ts0 := req.TimeSeries[0]
+	if want, got := "custom.googleapis.com/mysvc/int-metric", ts0.Metric.Type; want != got {
+		t.Errorf("Metric.Type: want=%v, got=%v", want, got)
+	}
+	if want, got := "GAUGE", ts0.MetricKind; want != got {
+		t.Errorf("MetricKind: want=%v, got=%v", want, got)
+	}
+	if want, got := "gce_instance", ts0.Resource.Type; want != got {
+		t.Errorf("Resource.Type: want=%v, got=%v", want, got)
+	}
+	if len(ts0.Points) != 1 || ts0.Points[0].Value.Int64Value == nil || *ts0.Points[0].Value.Int64Value != 10 {
+		t.Fatalf("unexpected points: %+v", ts0.Points)
+	}
+	if ts0.Points[0].Interval.StartTime != "" {
+		t.Errorf("GAUGE point should not carry a StartTime, got: %v", ts0.Points[0].Interval.StartTime)
+	}
+}
+
+func TestStackdriverEndpoint_CumulativeDefaultCarriesStartTime(t *testing.T) {
+	handler := &recordingHandler{}
+	ep, ts := newTestEndpoint(t, handler, config.StackdriverEndpoint{})
+	defer ts.Close()
+
+	value := 5.0
+	report, err := ep.BuildReport(metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{
+			Name:      "double-metric",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Value:     metrics.MetricValue{DoubleValue: &value},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error building report: %+v", err)
+	}
+	if err := ep.Send(report); err != nil {
+		t.Fatalf("error sending report: %+v", err)
+	}
+
+	var req monitoring.CreateTimeSeriesRequest
+	if err := json.Unmarshal(handler.body, &req); err != nil {
+		t.Fatalf("unmarshalling request: %+v", err)
+	}
+	ts0 := req.TimeSeries[0]
+	if want, got := "CUMULATIVE", ts0.MetricKind; want != got {
+		t.Errorf("MetricKind: want=%v, got=%v", want, got)
+	}
+	if ts0.Points[0].Interval.StartTime == "" {
+		t.Errorf("CUMULATIVE point should carry a StartTime")
+	}
+}
+
+func TestIsTransientStackdriverError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"context canceled", context.Canceled, false},
+		{"context deadline exceeded", context.DeadlineExceeded, true},
+		{"non-http error", errors.New("connection refused"), true},
+		{"http 429", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"http 503", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"http 400", &googleapi.Error{Code: http.StatusBadRequest}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientStackdriverError(tt.err); got != tt.want {
+				t.Errorf("isTransientStackdriverError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
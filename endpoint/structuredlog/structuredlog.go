@@ -0,0 +1,341 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package structuredlog implements a pipeline.Endpoint that writes each report as a
+// newline-delimited JSON record to stdout, a rotating file, or syslog, so an external log
+// pipeline (Fluent Bit, promtail, the Cloud Logging agent, ...) can scrape the agent's output
+// instead of requiring a push integration.
+package structuredlog
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+const (
+	fileMode       = 0644
+	segmentPrefix  = "structuredlog"
+	segmentSuffix  = ".ndjson"
+	gzSuffix       = ".gz"
+	activeName     = segmentPrefix + segmentSuffix + ".tmp"
+	segmentTimeFmt = "2006-01-02T15-04-05Z"
+)
+
+// record is the JSON shape written for each report: the report's identity, its metric name and
+// labels, its start/end time (time.Time's default JSON encoding is RFC3339Nano), its value, and -
+// as the endpoint-fan-out metadata available to an individual Endpoint - the name of the
+// StructuredLogEndpoint instance that wrote it, so a record can be attributed to its source when
+// multiple structured-log endpoints are configured.
+type record struct {
+	ReportId  string              `json:"reportId"`
+	Metric    string              `json:"metric"`
+	Labels    map[string]string   `json:"labels,omitempty"`
+	StartTime time.Time           `json:"startTime"`
+	EndTime   time.Time           `json:"endTime"`
+	Value     metrics.MetricValue `json:"value"`
+	Endpoint  string              `json:"endpoint"`
+}
+
+// StructuredLogEndpoint writes each report it's sent as a JSON record to its configured writer.
+type StructuredLogEndpoint struct {
+	name    string
+	tracker pipeline.UsageTracker
+
+	writeMu sync.Mutex
+	out     writeCloser
+}
+
+// writeCloser is the destination a StructuredLogEndpoint writes records to: os.Stdout wrapped to
+// be non-closing, a rotatingFile, or a syslog.Writer.
+type writeCloser interface {
+	io.Writer
+	Close() error
+}
+
+type nopCloseWriter struct{ io.Writer }
+
+func (nopCloseWriter) Close() error { return nil }
+
+// NewStructuredLogEndpoint creates a new StructuredLogEndpoint from cfg.
+func NewStructuredLogEndpoint(name string, cfg config.StructuredLogEndpoint) (*StructuredLogEndpoint, error) {
+	out, err := newWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &StructuredLogEndpoint{name: name, out: out}, nil
+}
+
+func newWriter(cfg config.StructuredLogEndpoint) (writeCloser, error) {
+	switch {
+	case cfg.Stdout != nil:
+		return nopCloseWriter{os.Stdout}, nil
+	case cfg.File != nil:
+		return newRotatingFile(cfg.File.Path, cfg.File.Rotation, clock.NewClock())
+	case cfg.Syslog != nil:
+		w, err := syslog.Dial(cfg.Syslog.Network, cfg.Syslog.Addr, syslog.LOG_INFO|syslog.LOG_USER, cfg.Syslog.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("structuredlog: dialing syslog: %v", err)
+		}
+		return w, nil
+	default:
+		return nil, fmt.Errorf("structuredlog: missing destination configuration")
+	}
+}
+
+func (ep *StructuredLogEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *StructuredLogEndpoint) BuildReport(r metrics.StampedMetricReport) (pipeline.EndpointReport, error) {
+	return pipeline.NewEndpointReport(r, nil)
+}
+
+func (ep *StructuredLogEndpoint) Send(r pipeline.EndpointReport) error {
+	return ep.SendContext(context.Background(), r)
+}
+
+func (ep *StructuredLogEndpoint) SendContext(ctx context.Context, r pipeline.EndpointReport) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rec := record{
+		ReportId:  r.Id,
+		Metric:    r.Name,
+		Labels:    r.Labels,
+		StartTime: r.StartTime,
+		EndTime:   r.EndTime,
+		Value:     r.Value,
+		Endpoint:  ep.name,
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	ep.writeMu.Lock()
+	defer ep.writeMu.Unlock()
+	_, err = ep.out.Write(line)
+	return err
+}
+
+// IsTransient reports true for every error, consistent with disk.DiskEndpoint: a failure to
+// write a log line is assumed to be a transient local-I/O hiccup worth retrying, rather than a
+// permanent rejection of the report's content.
+func (ep *StructuredLogEndpoint) IsTransient(err error) bool {
+	return true
+}
+
+// Use increments the StructuredLogEndpoint's usage count. See pipeline.Component.Use.
+func (ep *StructuredLogEndpoint) Use() {
+	ep.tracker.Use()
+}
+
+// Release decrements the StructuredLogEndpoint's usage count. If it reaches 0, Release closes the
+// underlying writer. See pipeline.Component.Release.
+func (ep *StructuredLogEndpoint) Release() error {
+	return ep.tracker.Release(func() error {
+		ep.writeMu.Lock()
+		defer ep.writeMu.Unlock()
+		return ep.out.Close()
+	})
+}
+
+// rotatingFile is a writeCloser that appends lines to an active file, rotating it out under a
+// timestamped name - compressing it first if configured - once it exceeds the configured size or
+// age, and enforcing a cap on the number of retained rotated segments. It mirrors
+// disk.DiskEndpoint's ndjson rotation, scoped to a single log stream rather than a directory of
+// per-report files.
+type rotatingFile struct {
+	path     string
+	rotation config.DiskRotation
+	clock    clock.Clock
+
+	mu     sync.Mutex
+	active *os.File
+	opened time.Time
+	bytes  int64
+}
+
+func newRotatingFile(path string, rotation *config.DiskRotation, clock clock.Clock) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{path: path, clock: clock}
+	if rotation != nil {
+		rf.rotation = *rotation
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.active == nil {
+		if err := rf.openActiveLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.active.Write(p)
+	if err != nil {
+		return n, err
+	}
+	rf.bytes += int64(n)
+	if rf.rotation.MaxBytes > 0 && rf.bytes >= rf.rotation.MaxBytes {
+		if err := rf.rotateLocked(); err != nil {
+			return n, err
+		}
+	} else if maxAge := time.Duration(rf.rotation.MaxAgeSeconds) * time.Second; maxAge > 0 && rf.clock.Now().Sub(rf.opened) >= maxAge {
+		if err := rf.rotateLocked(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotateLocked()
+}
+
+func (rf *rotatingFile) activePath() string {
+	return filepath.Join(filepath.Dir(rf.path), activeName)
+}
+
+func (rf *rotatingFile) openActiveLocked() error {
+	f, err := os.OpenFile(rf.activePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.active = f
+	rf.opened = rf.clock.Now()
+	rf.bytes = info.Size()
+	return nil
+}
+
+// rotateLocked closes the active segment and finalizes it under its permanent, timestamped name,
+// then enforces rotation.MaxFiles. The caller must hold mu.
+func (rf *rotatingFile) rotateLocked() error {
+	if rf.active == nil {
+		return nil
+	}
+	tmpPath := rf.activePath()
+	syncErr := rf.active.Sync()
+	closeErr := rf.active.Close()
+	rf.active = nil
+	rf.bytes = 0
+	if syncErr != nil {
+		return syncErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	finalPath := rf.segmentName(rf.opened)
+	var err error
+	if rf.rotation.Compress {
+		err = compressSegment(tmpPath, finalPath)
+	} else {
+		err = os.Rename(tmpPath, finalPath)
+	}
+	if err != nil {
+		return err
+	}
+	rf.enforceMaxFilesLocked()
+	return nil
+}
+
+func (rf *rotatingFile) segmentName(t time.Time) string {
+	dir := filepath.Dir(rf.path)
+	base := strings.TrimSuffix(filepath.Base(rf.path), segmentSuffix)
+	name := base + "-" + t.UTC().Format(segmentTimeFmt) + segmentSuffix
+	if rf.rotation.Compress {
+		name += gzSuffix
+	}
+	return filepath.Join(dir, name)
+}
+
+func compressSegment(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		out.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// enforceMaxFilesLocked removes the oldest rotated segments beyond rotation.MaxFiles. The caller
+// must hold mu.
+func (rf *rotatingFile) enforceMaxFilesLocked() {
+	if rf.rotation.MaxFiles <= 0 {
+		return
+	}
+	dir := filepath.Dir(rf.path)
+	base := strings.TrimSuffix(filepath.Base(rf.path), segmentSuffix)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var segments []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, base+"-") && (strings.HasSuffix(name, segmentSuffix) || strings.HasSuffix(name, segmentSuffix+gzSuffix)) {
+			segments = append(segments, name)
+		}
+	}
+	sort.Strings(segments)
+	if len(segments) <= rf.rotation.MaxFiles {
+		return
+	}
+	for _, name := range segments[:len(segments)-rf.rotation.MaxFiles] {
+		os.Remove(filepath.Join(dir, name))
+	}
+}
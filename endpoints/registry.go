@@ -0,0 +1,69 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package endpoints is a global registry of endpoint kinds, keyed by the same string used in a
+// config.Endpoint's oneof JSON field (e.g. "disk", "servicecontrol") or, for a kind not built into
+// ubbagent, a config.CustomEndpoint.Kind. pipeline/builder registers every built-in kind at init
+// time and looks factories up by kind rather than switching on them directly, so a package that
+// imports ubbagent can add its own endpoint kind - a Kafka topic, a proprietary billing API, ... -
+// by registering a Factory from its own init function, without forking ubbagent itself.
+package endpoints
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+// Factory builds the pipeline.Endpoint described by cfgep, which has already been matched to the
+// kind the Factory was registered under. cfg is the endpoint's full agent configuration, for
+// factories that need to look up something else by name from it, such as an identity from
+// cfg.Identities.
+type Factory func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error)
+
+var (
+	mu    sync.Mutex
+	kinds = make(map[string]Factory)
+)
+
+// Register adds factory under kind, so a later Get(kind) returns it. It returns an error if kind
+// is already registered, rather than letting one registration silently shadow another.
+func Register(kind string, factory Factory) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := kinds[kind]; exists {
+		return fmt.Errorf("endpoints: kind already registered: %v", kind)
+	}
+	kinds[kind] = factory
+	return nil
+}
+
+// MustRegister is like Register, but panics on error. It's meant to be called from a package's
+// init function, where a duplicate kind is a programming error rather than something to recover
+// from at runtime.
+func MustRegister(kind string, factory Factory) {
+	if err := Register(kind, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Get returns the Factory registered under kind, or false if none has been registered.
+func Get(kind string) (Factory, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	factory, ok := kinds[kind]
+	return factory, ok
+}
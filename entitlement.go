@@ -37,6 +37,7 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/servicecontrol/v1"
 
@@ -82,6 +83,10 @@ func (c *entitlementID) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// reportingKey holds the decoded credential JSON from the reporting secret. It's no longer
+// necessarily a service account key: google.CredentialsFromJSON also recognizes the standard
+// "external_account" (workload identity federation) and "impersonated_service_account" types, so
+// operators can hand out either without a long-lived key.
 type reportingKey config.EncodedServiceAccountKey
 
 func (c *reportingKey) UnmarshalJSON(data []byte) error {
@@ -171,11 +176,12 @@ func check(err error) {
 }
 
 func newServiceControl(jsonKey []byte) (*servicecontrol.Service, error) {
-	config, err := google.JWTConfigFromJSON(jsonKey, servicecontrol.ServicecontrolScope)
+	ctx := context.Background()
+	creds, err := google.CredentialsFromJSON(ctx, jsonKey, servicecontrol.ServicecontrolScope)
 	if err != nil {
 		return nil, err
 	}
-	client := config.Client(context.Background())
+	client := oauth2.NewClient(ctx, creds.TokenSource)
 	client.Timeout = 30 * time.Second
 	service, err := servicecontrol.New(client)
 	if err != nil {
@@ -0,0 +1,122 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events provides a lightweight in-process event bus that components along the reporting
+// pipeline - an Aggregator, an endpoint, a Sender - can publish typed lifecycle events to, so an
+// operator can plug in auditing, metrics, or webhook subscribers without modifying the components
+// themselves.
+package events
+
+import (
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// Kind identifies the stage of a report's lifecycle an Event describes.
+type Kind string
+
+const (
+	// ReportAccepted is published when a report is accepted by an Aggregator's AddReport, before
+	// it's merged into a bucket.
+	ReportAccepted Kind = "report_accepted"
+
+	// ReportAggregated is published when a report is successfully merged into, or started, a
+	// bucket entry.
+	ReportAggregated Kind = "report_aggregated"
+
+	// ReportPersisted is published when an Aggregator's bucket state is written to persistence.
+	ReportPersisted Kind = "report_persisted"
+
+	// ReportSent is published when a finished, aggregated report is successfully forwarded to a
+	// downstream Input or Endpoint.
+	ReportSent Kind = "report_sent"
+
+	// ReportFailed is published when a report fails somewhere in the pipeline - e.g. a downstream
+	// Input's AddReport returns an error, or an endpoint fails to send.
+	ReportFailed Kind = "report_failed"
+
+	// EndpointReleased is published when an endpoint or sender finishes shutting down in response
+	// to Release.
+	EndpointReleased Kind = "endpoint_released"
+)
+
+// Event is a single typed occurrence in a report's lifecycle, published to a Bus. Metric and
+// Report are set whenever Kind concerns a specific report; Endpoint is set whenever Kind concerns
+// a specific named endpoint or sender; Err is set for ReportFailed.
+type Event struct {
+	Kind     Kind
+	Metric   string
+	Report   *metrics.MetricReport
+	Endpoint string
+	Err      error
+}
+
+// Handler is called once per Event a Bus delivers to a subscriber.
+type Handler func(Event)
+
+// Bus is a lightweight in-process publish/subscribe bus for Events. A nil *Bus is valid and its
+// Publish is a no-op, so components can accept a caller-supplied Bus without having to special-case
+// an unconfigured one.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []subscription
+}
+
+type subscription struct {
+	handler Handler
+	async   bool
+}
+
+// New creates an empty Bus with no subscribers.
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers handler to be called, synchronously and in the order subscribed, for every
+// Event subsequently published to b. A synchronous handler that blocks delays Publish, and in turn
+// whatever pipeline stage called it - see SubscribeAsync for a handler that shouldn't.
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, subscription{handler: handler})
+}
+
+// SubscribeAsync registers handler to be called in its own goroutine for every Event subsequently
+// published to b, so a slow or blocking handler - such as one that calls an external webhook -
+// never delays Publish or the pipeline stage that called it. Asynchronous handlers for the same
+// Event may run concurrently with each other and aren't guaranteed to observe events in order.
+func (b *Bus) SubscribeAsync(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, subscription{handler: handler, async: true})
+}
+
+// Publish delivers e to every subscriber of b, synchronous handlers first, in subscription order.
+// It's a no-op if b is nil, so a component with an unconfigured Bus field can call Publish
+// unconditionally.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.handlers {
+		if s.async {
+			go s.handler(e)
+		} else {
+			s.handler(e)
+		}
+	}
+}
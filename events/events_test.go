@@ -0,0 +1,110 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBus_Publish(t *testing.T) {
+	t.Run("delivers events to every synchronous subscriber in order", func(t *testing.T) {
+		b := New()
+		var got []Kind
+		b.Subscribe(func(e Event) { got = append(got, e.Kind) })
+		b.Subscribe(func(e Event) { got = append(got, e.Kind) })
+
+		b.Publish(Event{Kind: ReportAccepted, Metric: "requests"})
+
+		if len(got) != 2 || got[0] != ReportAccepted || got[1] != ReportAccepted {
+			t.Fatalf("expected both subscribers to observe ReportAccepted, got %v", got)
+		}
+	})
+
+	t.Run("a synchronous subscriber sees Publish's effects before Publish returns", func(t *testing.T) {
+		b := New()
+		var seen Event
+		b.Subscribe(func(e Event) { seen = e })
+
+		b.Publish(Event{Kind: ReportSent, Metric: "bytes"})
+
+		if seen.Kind != ReportSent || seen.Metric != "bytes" {
+			t.Fatalf("expected the subscriber to have already run, got %+v", seen)
+		}
+	})
+
+	t.Run("an asynchronous subscriber is eventually delivered the event without blocking Publish", func(t *testing.T) {
+		b := New()
+		delivered := make(chan Event, 1)
+		unblock := make(chan struct{})
+		b.SubscribeAsync(func(e Event) {
+			<-unblock
+			delivered <- e
+		})
+
+		done := make(chan struct{})
+		go func() {
+			b.Publish(Event{Kind: ReportFailed, Metric: "errors"})
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Publish blocked on a slow asynchronous subscriber")
+		}
+
+		close(unblock)
+		select {
+		case e := <-delivered:
+			if e.Kind != ReportFailed {
+				t.Fatalf("expected the async subscriber to observe ReportFailed, got %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("asynchronous subscriber was never called")
+		}
+	})
+
+	t.Run("a nil Bus is a no-op", func(t *testing.T) {
+		var b *Bus
+		b.Publish(Event{Kind: ReportAccepted})
+	})
+}
+
+func TestBus_ConcurrentPublish(t *testing.T) {
+	b := New()
+	var mu sync.Mutex
+	count := 0
+	b.Subscribe(func(Event) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Publish(Event{Kind: ReportPersisted})
+		}()
+	}
+	wg.Wait()
+
+	if count != 50 {
+		t.Fatalf("expected 50 deliveries, got %v", count)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filter turns config.Filter definitions into runtime processors that can be chained
+// together and applied to a metrics.StampedMetricReport as it flows through the pipeline.
+package filter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// Filter processes a single report, returning the (possibly modified) report and whether it
+// should continue on through the pipeline. A false return means the report is dropped.
+type Filter interface {
+	Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool)
+}
+
+// Chain is a Filter that applies a sequence of Filters in order, stopping as soon as one of them
+// drops the report.
+type Chain []Filter
+
+func (c Chain) Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	for _, f := range c {
+		var keep bool
+		report, keep = f.Apply(report)
+		if !keep {
+			return report, false
+		}
+	}
+	return report, true
+}
+
+// NewChain builds the Chain of filters named in names, looked up by name in all. It returns an
+// error if any name doesn't refer to a filter in all.
+func NewChain(all config.Filters, names []string) (Chain, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	chain := make(Chain, len(names))
+	for i, name := range names {
+		cfg := all.Get(name)
+		if cfg == nil {
+			return nil, fmt.Errorf("filter: unknown filter: %v", name)
+		}
+		f, err := Build(cfg)
+		if err != nil {
+			return nil, err
+		}
+		chain[i] = f
+	}
+	return chain, nil
+}
+
+// Build creates the runtime Filter described by cfg.
+func Build(cfg *config.Filter) (Filter, error) {
+	switch {
+	case cfg.AddLabels != nil:
+		return &addLabelsFilter{cfg.AddLabels}, nil
+	case cfg.DropLabels != nil:
+		return newDropLabelsFilter(cfg.DropLabels)
+	case cfg.RenameLabels != nil:
+		return newRenameLabelsFilter(cfg.RenameLabels), nil
+	case cfg.Relabel != nil:
+		return newRelabelFilter(cfg.Relabel)
+	case cfg.DropIf != nil:
+		return newMatchFilter(cfg.DropIf, false)
+	case cfg.KeepIf != nil:
+		return newMatchFilter(cfg.KeepIf, true)
+	case cfg.Sample != nil:
+		return newSampleFilter(cfg.Sample), nil
+	default:
+		return nil, errors.New("filter: missing filter configuration")
+	}
+}
+
+// setLabel returns a copy of report with label key set to value, leaving report's own Labels map
+// untouched.
+func setLabel(report metrics.StampedMetricReport, key, value string) metrics.StampedMetricReport {
+	labels := make(map[string]string, len(report.Labels)+1)
+	for k, v := range report.Labels {
+		labels[k] = v
+	}
+	labels[key] = value
+	report.Labels = labels
+	return report
+}
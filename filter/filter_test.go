@@ -0,0 +1,383 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/filter"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+func report(name string, labels map[string]string) metrics.StampedMetricReport {
+	return metrics.StampedMetricReport{
+		MetricReport: metrics.MetricReport{Name: name, Labels: labels},
+		Id:           "report-id",
+	}
+}
+
+func TestNewChain(t *testing.T) {
+	all := config.Filters{
+		{Name: "add", AddLabels: &config.AddLabels{Labels: map[string]string{"env": "prod"}}},
+	}
+
+	t.Run("empty names returns nil chain", func(t *testing.T) {
+		chain, err := filter.NewChain(all, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(chain) != 0 {
+			t.Fatalf("expected empty chain, got %+v", chain)
+		}
+	})
+
+	t.Run("unknown name is an error", func(t *testing.T) {
+		if _, err := filter.NewChain(all, []string{"nonexistent"}); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("builds chain in order", func(t *testing.T) {
+		chain, err := filter.NewChain(all, []string{"add"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(chain) != 1 {
+			t.Fatalf("expected chain of length 1, got %v", len(chain))
+		}
+		r, keep := chain.Apply(report("m", nil))
+		if !keep {
+			t.Fatal("expected report to be kept")
+		}
+		if r.Labels["env"] != "prod" {
+			t.Fatalf("expected env label to be set, got %+v", r.Labels)
+		}
+	})
+}
+
+func TestChain_ShortCircuitsOnDrop(t *testing.T) {
+	all := config.Filters{
+		{Name: "drop-all", DropIf: &config.Match{Metric: "m"}},
+		{Name: "add", AddLabels: &config.AddLabels{Labels: map[string]string{"env": "prod"}}},
+	}
+	chain, err := filter.NewChain(all, []string{"drop-all", "add"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, keep := chain.Apply(report("m", nil))
+	if keep {
+		t.Fatal("expected report to be dropped")
+	}
+	if _, ok := r.Labels["env"]; ok {
+		t.Fatal("expected the add filter to never run after the drop")
+	}
+}
+
+func TestBuild_UnsupportedConfig(t *testing.T) {
+	if _, err := filter.Build(&config.Filter{Name: "empty"}); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestAddLabelsFilter(t *testing.T) {
+	f, err := filter.Build(&config.Filter{
+		Name:      "add",
+		AddLabels: &config.AddLabels{Labels: map[string]string{"env": "prod", "existing": "new"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, keep := f.Apply(report("m", map[string]string{"existing": "old"}))
+	if !keep {
+		t.Fatal("expected report to be kept")
+	}
+	expected := map[string]string{"env": "prod", "existing": "old"}
+	if !reflect.DeepEqual(expected, r.Labels) {
+		t.Fatalf("Labels: want=%+v, got=%+v", expected, r.Labels)
+	}
+}
+
+func TestDropLabelsFilter(t *testing.T) {
+	f, err := filter.Build(&config.Filter{
+		Name:       "drop",
+		DropLabels: &config.DropLabels{Labels: []string{"secret"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, keep := f.Apply(report("m", map[string]string{"secret": "x", "other": "y"}))
+	if !keep {
+		t.Fatal("expected report to be kept")
+	}
+	expected := map[string]string{"other": "y"}
+	if !reflect.DeepEqual(expected, r.Labels) {
+		t.Fatalf("Labels: want=%+v, got=%+v", expected, r.Labels)
+	}
+}
+
+func TestDropLabelsFilter_Patterns(t *testing.T) {
+	f, err := filter.Build(&config.Filter{
+		Name:       "drop",
+		DropLabels: &config.DropLabels{Labels: []string{"secret"}, Patterns: []string{"^internal_.*$"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, keep := f.Apply(report("m", map[string]string{"secret": "x", "internal_foo": "y", "other": "z"}))
+	if !keep {
+		t.Fatal("expected report to be kept")
+	}
+	expected := map[string]string{"other": "z"}
+	if !reflect.DeepEqual(expected, r.Labels) {
+		t.Fatalf("Labels: want=%+v, got=%+v", expected, r.Labels)
+	}
+}
+
+func TestRenameLabelsFilter(t *testing.T) {
+	t.Run("renames a present label", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:         "rename",
+			RenameLabels: &config.RenameLabels{Renames: map[string]string{"old": "new"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, keep := f.Apply(report("m", map[string]string{"old": "value", "other": "x"}))
+		if !keep {
+			t.Fatal("expected report to be kept")
+		}
+		expected := map[string]string{"new": "value", "other": "x"}
+		if !reflect.DeepEqual(expected, r.Labels) {
+			t.Fatalf("Labels: want=%+v, got=%+v", expected, r.Labels)
+		}
+	})
+
+	t.Run("missing source label is a no-op", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:         "rename",
+			RenameLabels: &config.RenameLabels{Renames: map[string]string{"old": "new"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, keep := f.Apply(report("m", map[string]string{"other": "x"}))
+		if !keep {
+			t.Fatal("expected report to be kept")
+		}
+		expected := map[string]string{"other": "x"}
+		if !reflect.DeepEqual(expected, r.Labels) {
+			t.Fatalf("Labels: want=%+v, got=%+v", expected, r.Labels)
+		}
+	})
+
+	t.Run("onCollision overwrite (the default) replaces the existing value", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:         "rename",
+			RenameLabels: &config.RenameLabels{Renames: map[string]string{"old": "new"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, _ := f.Apply(report("m", map[string]string{"old": "renamed-value", "new": "existing-value"}))
+		if r.Labels["new"] != "renamed-value" {
+			t.Fatalf("expected new=renamed-value, got %+v", r.Labels)
+		}
+	})
+
+	t.Run("onCollision keep preserves the existing value", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:         "rename",
+			RenameLabels: &config.RenameLabels{Renames: map[string]string{"old": "new"}, OnCollision: config.RenameLabelsKeep},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, _ := f.Apply(report("m", map[string]string{"old": "renamed-value", "new": "existing-value"}))
+		if r.Labels["new"] != "existing-value" {
+			t.Fatalf("expected new=existing-value, got %+v", r.Labels)
+		}
+		if _, ok := r.Labels["old"]; ok {
+			t.Fatalf("expected old label to be removed even when its rename is discarded, got %+v", r.Labels)
+		}
+	})
+}
+
+func TestRelabelFilter(t *testing.T) {
+	t.Run("replace", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name: "relabel",
+			Relabel: &config.Relabel{Rules: []config.RelabelRule{
+				{SourceLabels: []string{"region"}, Regex: "us-(.*)", TargetLabel: "short_region", Replacement: "$1"},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, keep := f.Apply(report("m", map[string]string{"region": "us-east1"}))
+		if !keep {
+			t.Fatal("expected report to be kept")
+		}
+		if r.Labels["short_region"] != "east1" {
+			t.Fatalf("expected short_region=east1, got %+v", r.Labels)
+		}
+	})
+
+	t.Run("keep", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name: "relabel",
+			Relabel: &config.Relabel{Rules: []config.RelabelRule{
+				{SourceLabels: []string{"env"}, Regex: "prod", Action: "keep"},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"env": "prod"})); !keep {
+			t.Fatal("expected matching report to be kept")
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"env": "dev"})); keep {
+			t.Fatal("expected non-matching report to be dropped")
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name: "relabel",
+			Relabel: &config.Relabel{Rules: []config.RelabelRule{
+				{SourceLabels: []string{"env"}, Regex: "test", Action: "drop"},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"env": "test"})); keep {
+			t.Fatal("expected matching report to be dropped")
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"env": "prod"})); !keep {
+			t.Fatal("expected non-matching report to be kept")
+		}
+	})
+
+	t.Run("hashmod is deterministic", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name: "relabel",
+			Relabel: &config.Relabel{Rules: []config.RelabelRule{
+				{SourceLabels: []string{"env"}, Action: "hashmod", TargetLabel: "shard", Modulus: 10},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r1, _ := f.Apply(report("m", map[string]string{"env": "prod"}))
+		r2, _ := f.Apply(report("m", map[string]string{"env": "prod"}))
+		if r1.Labels["shard"] != r2.Labels["shard"] {
+			t.Fatalf("expected deterministic shard, got %v and %v", r1.Labels["shard"], r2.Labels["shard"])
+		}
+	})
+}
+
+func TestMatchFilter(t *testing.T) {
+	t.Run("dropIf", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:   "drop-internal",
+			DropIf: &config.Match{Labels: map[string]string{"internal": "true"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"internal": "true"})); keep {
+			t.Fatal("expected matching report to be dropped")
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"internal": "false"})); !keep {
+			t.Fatal("expected non-matching report to be kept")
+		}
+	})
+
+	t.Run("keepIf with label regex", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:   "keep-us",
+			KeepIf: &config.Match{LabelRegex: map[string]string{"region": "us-.*"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"region": "us-east1"})); !keep {
+			t.Fatal("expected matching report to be kept")
+		}
+		if _, keep := f.Apply(report("m", map[string]string{"region": "eu-west1"})); keep {
+			t.Fatal("expected non-matching report to be dropped")
+		}
+	})
+
+	t.Run("metric name scoping", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:   "keep-metric",
+			KeepIf: &config.Match{Metric: "wanted"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, keep := f.Apply(report("wanted", nil)); !keep {
+			t.Fatal("expected matching metric to be kept")
+		}
+		if _, keep := f.Apply(report("other", nil)); keep {
+			t.Fatal("expected non-matching metric to be dropped")
+		}
+	})
+}
+
+func TestSampleFilter(t *testing.T) {
+	t.Run("rate 0 always drops", func(t *testing.T) {
+		rate := 0.0
+		f, err := filter.Build(&config.Filter{Name: "sample", Sample: &config.Sample{Rate: &rate}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, keep := f.Apply(report("m", nil)); keep {
+			t.Fatal("expected report to be dropped with rate 0")
+		}
+	})
+
+	t.Run("rate 1 always keeps", func(t *testing.T) {
+		rate := 1.0
+		f, err := filter.Build(&config.Filter{Name: "sample", Sample: &config.Sample{Rate: &rate}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, keep := f.Apply(report("m", nil)); !keep {
+			t.Fatal("expected report to be kept with rate 1")
+		}
+	})
+
+	t.Run("hashmod is deterministic by report ID", func(t *testing.T) {
+		f, err := filter.Build(&config.Filter{
+			Name:   "sample",
+			Sample: &config.Sample{HashMod: &config.HashMod{Modulus: 2, Remainders: []uint64{0, 1}}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, keep1 := f.Apply(report("m", nil))
+		_, keep2 := f.Apply(report("m", nil))
+		if keep1 != keep2 {
+			t.Fatal("expected the same report ID to produce the same keep/drop decision")
+		}
+		if !keep1 {
+			t.Fatal("expected report to be kept since all remainders are included")
+		}
+	})
+}
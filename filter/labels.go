@@ -0,0 +1,133 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// addLabelsFilter adds config.AddLabels.IncludedLabels to a report, never overwriting a label the
+// report already has.
+type addLabelsFilter struct {
+	cfg *config.AddLabels
+}
+
+func (f *addLabelsFilter) Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	included := f.cfg.IncludedLabels()
+	if len(included) == 0 {
+		return report, true
+	}
+	labels := make(map[string]string, len(report.Labels)+len(included))
+	for k, v := range report.Labels {
+		labels[k] = v
+	}
+	for k, v := range included {
+		if _, exists := labels[k]; exists {
+			continue
+		}
+		labels[k] = v
+	}
+	report.Labels = labels
+	return report, true
+}
+
+// dropLabelsFilter removes a fixed set of labels from a report, plus any label whose key matches
+// one of a set of compiled patterns, if present.
+type dropLabelsFilter struct {
+	labels   []string
+	patterns []*regexp.Regexp
+}
+
+func newDropLabelsFilter(cfg *config.DropLabels) (Filter, error) {
+	patterns := make([]*regexp.Regexp, len(cfg.Patterns))
+	for i, pattern := range cfg.Patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("dropLabels: invalid pattern %v: %v", pattern, err)
+		}
+		patterns[i] = re
+	}
+	return &dropLabelsFilter{labels: cfg.Labels, patterns: patterns}, nil
+}
+
+func (f *dropLabelsFilter) Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	if len(report.Labels) == 0 {
+		return report, true
+	}
+	labels := make(map[string]string, len(report.Labels))
+	for k, v := range report.Labels {
+		labels[k] = v
+	}
+	for _, k := range f.labels {
+		delete(labels, k)
+	}
+	for k := range labels {
+		if f.matchesPattern(k) {
+			delete(labels, k)
+		}
+	}
+	report.Labels = labels
+	return report, true
+}
+
+func (f *dropLabelsFilter) matchesPattern(key string) bool {
+	for _, re := range f.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// renameLabelsFilter renames report labels according to config.RenameLabels.Renames, resolving any
+// collision with config.RenameLabels.OnCollision.
+type renameLabelsFilter struct {
+	renames   map[string]string
+	overwrite bool
+}
+
+func newRenameLabelsFilter(cfg *config.RenameLabels) *renameLabelsFilter {
+	return &renameLabelsFilter{
+		renames:   cfg.Renames,
+		overwrite: cfg.OnCollision != config.RenameLabelsKeep,
+	}
+}
+
+func (f *renameLabelsFilter) Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	if len(report.Labels) == 0 {
+		return report, true
+	}
+	labels := make(map[string]string, len(report.Labels))
+	for k, v := range report.Labels {
+		labels[k] = v
+	}
+	for old, renamed := range f.renames {
+		v, ok := labels[old]
+		if !ok {
+			continue
+		}
+		delete(labels, old)
+		if _, collides := labels[renamed]; collides && !f.overwrite {
+			continue
+		}
+		labels[renamed] = v
+	}
+	report.Labels = labels
+	return report, true
+}
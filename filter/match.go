@@ -0,0 +1,74 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// matchFilter drops or keeps a report depending on whether it matches a config.Match predicate.
+// It implements both DropIf (dropOnMatch == true) and KeepIf (dropOnMatch == false).
+type matchFilter struct {
+	metric      string
+	labels      map[string]string
+	labelRegex  map[string]*regexp.Regexp
+	dropOnMatch bool
+}
+
+func newMatchFilter(cfg *config.Match, keep bool) (Filter, error) {
+	labelRegex := make(map[string]*regexp.Regexp, len(cfg.LabelRegex))
+	for k, pattern := range cfg.LabelRegex {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("match: invalid regex for label %v: %v", k, err)
+		}
+		labelRegex[k] = re
+	}
+	return &matchFilter{
+		metric:      cfg.Metric,
+		labels:      cfg.Labels,
+		labelRegex:  labelRegex,
+		dropOnMatch: !keep,
+	}, nil
+}
+
+func (f *matchFilter) Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	matches := f.matches(report)
+	if f.dropOnMatch {
+		return report, !matches
+	}
+	return report, matches
+}
+
+func (f *matchFilter) matches(report metrics.StampedMetricReport) bool {
+	if f.metric != "" && report.Name != f.metric {
+		return false
+	}
+	for k, v := range f.labels {
+		if report.Labels[k] != v {
+			return false
+		}
+	}
+	for k, re := range f.labelRegex {
+		if !re.MatchString(report.Labels[k]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// relabelFilter applies a sequence of relabelRules, in order. See config.Relabel.
+type relabelFilter struct {
+	rules []relabelRule
+}
+
+func newRelabelFilter(cfg *config.Relabel) (Filter, error) {
+	rules := make([]relabelRule, len(cfg.Rules))
+	for i, rc := range cfg.Rules {
+		rule, err := newRelabelRule(&rc)
+		if err != nil {
+			return nil, fmt.Errorf("relabel: rule %v: %v", i, err)
+		}
+		rules[i] = rule
+	}
+	return &relabelFilter{rules}, nil
+}
+
+func (f *relabelFilter) Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	for _, rule := range f.rules {
+		var keep bool
+		report, keep = rule.apply(report)
+		if !keep {
+			return report, false
+		}
+	}
+	return report, true
+}
+
+type relabelRule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	targetLabel  string
+	replacement  string
+	action       string
+	modulus      uint64
+}
+
+func newRelabelRule(cfg *config.RelabelRule) (relabelRule, error) {
+	separator := cfg.Separator
+	if separator == "" {
+		separator = ";"
+	}
+	regexStr := cfg.Regex
+	if regexStr == "" {
+		regexStr = "(.*)"
+	}
+	re, err := regexp.Compile("^(?:" + regexStr + ")$")
+	if err != nil {
+		return relabelRule{}, fmt.Errorf("invalid regex %q: %v", cfg.Regex, err)
+	}
+	action := cfg.Action
+	if action == "" {
+		action = "replace"
+	}
+	return relabelRule{
+		sourceLabels: cfg.SourceLabels,
+		separator:    separator,
+		regex:        re,
+		targetLabel:  cfg.TargetLabel,
+		replacement:  cfg.Replacement,
+		action:       action,
+		modulus:      cfg.Modulus,
+	}, nil
+}
+
+func (rule *relabelRule) apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	values := make([]string, len(rule.sourceLabels))
+	for i, l := range rule.sourceLabels {
+		values[i] = report.Labels[l]
+	}
+	joined := strings.Join(values, rule.separator)
+	idx := rule.regex.FindStringSubmatchIndex(joined)
+	matched := idx != nil
+
+	switch rule.action {
+	case "keep":
+		return report, matched
+	case "drop":
+		return report, !matched
+	case "hashmod":
+		sum := sha256.Sum256([]byte(joined))
+		mod := binary.BigEndian.Uint64(sum[:8]) % rule.modulus
+		return setLabel(report, rule.targetLabel, strconv.FormatUint(mod, 10)), true
+	default: // "replace"
+		if !matched {
+			return report, true
+		}
+		expanded := rule.regex.ExpandString(nil, rule.replacement, joined, idx)
+		return setLabel(report, rule.targetLabel, string(expanded)), true
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// sampleFilter keeps a fraction of reports, either probabilistically or by hashing the report ID.
+// See config.Sample.
+type sampleFilter struct {
+	useRate bool
+	rate    float64
+
+	modulus    uint64
+	remainders map[uint64]bool
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newSampleFilter(cfg *config.Sample) Filter {
+	if cfg.Rate != nil {
+		return &sampleFilter{
+			useRate: true,
+			rate:    *cfg.Rate,
+			rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+	}
+	remainders := make(map[uint64]bool, len(cfg.HashMod.Remainders))
+	for _, rem := range cfg.HashMod.Remainders {
+		remainders[rem] = true
+	}
+	return &sampleFilter{modulus: cfg.HashMod.Modulus, remainders: remainders}
+}
+
+func (f *sampleFilter) Apply(report metrics.StampedMetricReport) (metrics.StampedMetricReport, bool) {
+	if f.useRate {
+		f.mu.Lock()
+		keep := f.rnd.Float64() < f.rate
+		f.mu.Unlock()
+		return report, keep
+	}
+	sum := sha256.Sum256([]byte(report.Id))
+	mod := binary.BigEndian.Uint64(sum[:8]) % f.modulus
+	return report, f.remainders[mod]
+}
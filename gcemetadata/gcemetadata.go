@@ -0,0 +1,103 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcemetadata resolves instance identity from the GCE metadata server, for agents running
+// as a GCE instance or GKE pod that would otherwise need these values configured by hand.
+package gcemetadata
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// baseURL is the well-known address of the GCE metadata server. It's only reachable from within a
+// GCE VM (or a GKE pod running on one), so requests against it naturally fail fast off GCE.
+const baseURL = "http://metadata.google.internal/computeMetadata/v1/"
+
+// Info holds the instance identity values Detect can resolve from the metadata server.
+// ClusterName and ClusterLocation are populated only when the instance is a GKE node; they're
+// empty on a plain GCE VM.
+type Info struct {
+	ProjectId       string `json:"projectId,omitempty"`
+	InstanceId      string `json:"instanceId,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+	ClusterName     string `json:"clusterName,omitempty"`
+	ClusterLocation string `json:"clusterLocation,omitempty"`
+}
+
+// Detect queries the metadata server for Info, bounding the whole operation to timeout. It
+// returns ok=false - logging why, rather than returning an error - if the metadata server isn't
+// reachable at all, which is expected both off GCE and in sandboxed GCE environments (e.g. a GKE
+// pod whose network policy blocks the metadata IP). Callers use Detect only to fill in defaults
+// and must never block startup on it, so a failure here is routine, not exceptional. A response
+// that resolves the project ID but not, say, the GKE cluster attributes (because the instance
+// isn't a GKE node) is still ok=true, with those fields left empty.
+func Detect(timeout time.Duration) (Info, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	projectId, err := get(ctx, "project/project-id")
+	if err != nil {
+		glog.Warningf("gcemetadata: not running on GCE, or metadata server unreachable: %v", err)
+		return Info{}, false
+	}
+
+	info := Info{ProjectId: projectId}
+	info.InstanceId, _ = get(ctx, "instance/id")
+	if zone, err := get(ctx, "instance/zone"); err == nil {
+		info.Zone = lastPathSegment(zone)
+	}
+	info.ClusterName, _ = get(ctx, "instance/attributes/cluster-name")
+	info.ClusterLocation, _ = get(ctx, "instance/attributes/cluster-location")
+	return info, true
+}
+
+// get fetches a single metadata path, presenting the "Metadata-Flavor: Google" header the
+// metadata server requires of every request.
+func get(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gcemetadata: %v: unexpected status %v", path, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// lastPathSegment returns the portion of s after its final "/", or s unchanged if it has none.
+// instance/zone and similar attributes are returned as a full resource path (e.g.
+// "projects/123/zones/us-central1-a"); callers generally want just the final segment.
+func lastPathSegment(s string) string {
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcemetadata
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetect_Unreachable(t *testing.T) {
+	// There's no metadata server in the test environment, so Detect must fail fast rather than
+	// blocking for the full timeout.
+	start := time.Now()
+	info, ok := Detect(3 * time.Second)
+	if ok {
+		t.Fatalf("Detect() = %+v, true; want ok=false off GCE", info)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Detect() took %v, want well under the 3s timeout", elapsed)
+	}
+}
+
+func TestLastPathSegment(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"projects/123/zones/us-central1-a", "us-central1-a"},
+		{"us-central1-a", "us-central1-a"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := lastPathSegment(tt.in); got != tt.want {
+			t.Errorf("lastPathSegment(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
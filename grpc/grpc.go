@@ -0,0 +1,168 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package grpc exposes an agent's AddReport and GetStatus over gRPC, parallel to the http
+// package's JSON-over-HTTP interface. Its service messages (see messages.go) are plain Go structs
+// encoded with a JSON codec rather than protobuf, so this interface needs no separate .proto
+// build step.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/GoogleCloudPlatform/ubbagent/sdk"
+	"google.golang.org/grpc"
+)
+
+// serviceDesc describes the Agent service's methods to the grpc.Server registered in
+// NewGRPCInterface.Start.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ubbagent.Agent",
+	HandlerType: (*GRPCInterface)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "AddReport", Handler: addReportHandler},
+		{MethodName: "GetStatus", Handler: getStatusHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "AddReportStream", Handler: addReportStreamHandler, ClientStreams: true},
+	},
+	Metadata: "ubbagent/grpc",
+}
+
+// GRPCInterface is an agent interface that exposes AddReport (unary and client-streaming) and
+// GetStatus RPCs on a gRPC server. It lets sidecar or embedded callers push reports without
+// HTTP-per-report overhead, and gives Kubernetes operators a first-class RPC surface.
+type GRPCInterface struct {
+	agent *sdk.Agent
+	port  int
+	srv   *grpc.Server
+}
+
+// NewGRPCInterface creates a new agent interface that listens on the given port. The interface
+// must be started with a call to Start.
+func NewGRPCInterface(agent *sdk.Agent, port int) *GRPCInterface {
+	return &GRPCInterface{agent: agent, port: port}
+}
+
+// Start starts the GRPCInterface in the background. It returns an error immediately if background
+// starting fails, but otherwise returns nil. The errHandler callback receives any error returned
+// by the underlying call to Serve.
+func (g *GRPCInterface) Start(errHandler func(error)) error {
+	if g.srv != nil {
+		return errors.New("already started")
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%v", g.port))
+	if err != nil {
+		return err
+	}
+	g.srv = grpc.NewServer(
+		grpc.ForceServerCodec(jsonCodec{}),
+		grpc.ChainUnaryInterceptor(recoveryUnaryInterceptor),
+		grpc.ChainStreamInterceptor(recoveryStreamInterceptor, streamCounterInterceptor(g.agent.Recorder())),
+	)
+	g.srv.RegisterService(&serviceDesc, g)
+	go func() {
+		errHandler(g.srv.Serve(lis))
+	}()
+	return nil
+}
+
+// Shutdown initiates a graceful shutdown of the GRPCInterface and blocks until the operation
+// finishes.
+func (g *GRPCInterface) Shutdown() error {
+	if g.srv == nil {
+		return errors.New("not started")
+	}
+	g.srv.GracefulStop()
+	g.srv = nil
+	return nil
+}
+
+// addReport adds a single report, as the HTTP interface's AddReportJson does.
+func (g *GRPCInterface) addReport(req *AddReportRequest) (*AddReportResponse, error) {
+	if err := g.agent.AddReport(req.Report); err != nil {
+		return nil, err
+	}
+	return &AddReportResponse{}, nil
+}
+
+// addReportStream adds every report a client sends over stream, until the client half-closes it,
+// then replies with how many were accepted.
+func (g *GRPCInterface) addReportStream(stream grpc.ServerStream) error {
+	var accepted int
+	for {
+		var req AddReportRequest
+		if err := stream.RecvMsg(&req); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if err := g.agent.AddReport(req.Report); err != nil {
+			return err
+		}
+		accepted++
+	}
+	return stream.SendMsg(&AddReportStreamResponse{Accepted: accepted})
+}
+
+// getStatus returns the agent's current stats.Snapshot, as the HTTP interface's GetStatusJson
+// does.
+func (g *GRPCInterface) getStatus() (*GetStatusResponse, error) {
+	return &GetStatusResponse{Status: g.agent.GetStatus()}, nil
+}
+
+// addReportHandler adapts GRPCInterface.addReport to grpc's unary method handler signature.
+func addReportHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	g := srv.(*GRPCInterface)
+	if interceptor == nil {
+		return g.addReport(in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/ubbagent.Agent/AddReport"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.addReport(req.(*AddReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// getStatusHandler adapts GRPCInterface.getStatus to grpc's unary method handler signature.
+func getStatusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	g := srv.(*GRPCInterface)
+	if interceptor == nil {
+		return g.getStatus()
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/ubbagent.Agent/GetStatus"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.getStatus()
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// addReportStreamHandler adapts GRPCInterface.addReportStream to grpc's streaming method handler
+// signature.
+func addReportStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(*GRPCInterface).addReportStream(stream)
+}
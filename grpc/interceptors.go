@@ -0,0 +1,65 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/GoogleCloudPlatform/ubbagent/stats"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recoverAndLog converts a panic, if any, recovered from rec into a gRPC Internal error assigned
+// to *err, logging the panic and its stack so it isn't silently swallowed. It's meant to be
+// deferred directly: `defer recoverAndLog(fullMethod, &err)`.
+func recoverAndLog(fullMethod string, err *error) {
+	if r := recover(); r != nil {
+		glog.Errorf("grpc: panic handling %v: %v\n%s", fullMethod, r, debug.Stack())
+		*err = status.Errorf(codes.Internal, "internal error")
+	}
+}
+
+// recoveryUnaryInterceptor converts a panic in handler into a gRPC Internal error rather than
+// crashing the agent.
+func recoveryUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer recoverAndLog(info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+// recoveryStreamInterceptor converts a panic in handler into a gRPC Internal error rather than
+// crashing the agent.
+func recoveryStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverAndLog(info.FullMethod, &err)
+	return handler(srv, ss)
+}
+
+// streamCounterInterceptor reports a stream's start and end to recorder, if recorder implements
+// stats.StreamObserver, so stats.Provider's snapshot reflects in-flight streams such as
+// AddReportStream.
+func streamCounterInterceptor(recorder stats.Recorder) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		obs, ok := recorder.(stats.StreamObserver)
+		if !ok {
+			return handler(srv, ss)
+		}
+		obs.ObserveStreamStarted(info.FullMethod)
+		defer obs.ObserveStreamEnded(info.FullMethod)
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/stats"
+)
+
+// AddReportRequest carries a single usage report, identical to the body accepted by the HTTP
+// interface's /report endpoint.
+type AddReportRequest struct {
+	Report metrics.MetricReport `json:"report"`
+}
+
+// AddReportResponse is empty; a nil error from AddReport means the report was accepted.
+type AddReportResponse struct{}
+
+// AddReportStreamResponse is sent once, after the client half-closes its AddReportStream, and
+// reports how many of the reports it sent were accepted.
+type AddReportStreamResponse struct {
+	Accepted int `json:"accepted"`
+}
+
+// GetStatusRequest is empty; GetStatus takes no parameters.
+type GetStatusRequest struct{}
+
+// GetStatusResponse carries the same stats.Snapshot returned by the HTTP interface's /status
+// endpoint.
+type GetStatusResponse struct {
+	Status stats.Snapshot `json:"status"`
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+// authenticator validates an incoming request's credentials, returning an error describing why
+// the request is rejected if it doesn't authenticate.
+type authenticator interface {
+	authenticate(r *http.Request) error
+}
+
+// buildAuthenticator constructs the authenticator described by cfg. cfg must have already passed
+// config.HttpAuth.Validate.
+func buildAuthenticator(cfg *config.HttpAuth) (authenticator, error) {
+	switch cfg.Mode {
+	case config.AuthModeToken:
+		return newTokenAuthenticator(cfg.TokenFile)
+	case config.AuthModeMTLS:
+		return mtlsAuthenticator{}, nil
+	case config.AuthModeK8s:
+		return newK8sTokenReviewAuthenticator(cfg.K8sTokenReview)
+	default:
+		return nil, fmt.Errorf("auth: unknown mode: %v", cfg.Mode)
+	}
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header, or returns an
+// error if the header is missing or doesn't use the Bearer scheme.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return "", fmt.Errorf("auth: missing or malformed Authorization header")
+	}
+	return strings.TrimPrefix(header, prefix), nil
+}
+
+// tokenAuthenticator implements config.AuthModeToken: it requires the request's bearer token to
+// match a static token read from disk once, at construction time.
+type tokenAuthenticator struct {
+	token string
+}
+
+func newTokenAuthenticator(tokenFile string) (*tokenAuthenticator, error) {
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading tokenFile: %v", err)
+	}
+	return &tokenAuthenticator{token: strings.TrimSpace(string(data))}, nil
+}
+
+func (a *tokenAuthenticator) authenticate(r *http.Request) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+	// subtle.ConstantTimeCompare requires equal-length inputs; a length mismatch is itself not a
+	// match, so it's safe to special-case rather than pad.
+	if len(token) != len(a.token) || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return fmt.Errorf("auth: invalid token")
+	}
+	return nil
+}
+
+// mtlsAuthenticator implements config.AuthModeMTLS. The actual certificate verification already
+// happened during the TLS handshake, via Server.TLS.ClientCAFile (see buildTLSConfig); this just
+// confirms the connection that reached the handler actually presented and verified one, rather
+// than having arrived over plaintext or an improperly configured listener.
+type mtlsAuthenticator struct{}
+
+func (mtlsAuthenticator) authenticate(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return fmt.Errorf("auth: no verified client certificate")
+	}
+	return nil
+}
+
+// k8sTokenReviewAuthenticator implements config.AuthModeK8s: it authenticates a caller's bearer
+// token by submitting it to a Kubernetes API server's authentication.k8s.io/v1 TokenReview
+// endpoint, the same mechanism in-cluster components use to validate a service account token
+// presented to them.
+type k8sTokenReviewAuthenticator struct {
+	client       *http.Client
+	apiServerURL string
+	tokenFile    string
+	audiences    []string
+}
+
+func newK8sTokenReviewAuthenticator(cfg *config.K8sTokenReviewAuth) (*k8sTokenReviewAuthenticator, error) {
+	transport := &http.Transport{}
+	if cfg.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: reading k8sTokenReview caFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("auth: no certificates found in k8sTokenReview caFile: %v", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &k8sTokenReviewAuthenticator{
+		client:       &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		apiServerURL: strings.TrimSuffix(cfg.APIServerURL, "/"),
+		tokenFile:    cfg.ServiceAccountTokenFile,
+		audiences:    cfg.Audiences,
+	}, nil
+}
+
+// tokenReviewRequest and tokenReviewResponse are the minimal subset of the
+// authentication.k8s.io/v1 TokenReview API this authenticator needs - just enough to submit a
+// token and read back whether it authenticated, without depending on k8s.io/api.
+type tokenReviewRequest struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Spec       tokenReviewSpec `json:"spec"`
+}
+
+type tokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type tokenReviewResponse struct {
+	Status tokenReviewStatus `json:"status"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool   `json:"authenticated"`
+	Error         string `json:"error"`
+}
+
+func (a *k8sTokenReviewAuthenticator) authenticate(r *http.Request) error {
+	callerToken, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+	saToken, err := ioutil.ReadFile(a.tokenFile)
+	if err != nil {
+		return fmt.Errorf("auth: reading service account token: %v", err)
+	}
+
+	reqBody, err := json.Marshal(tokenReviewRequest{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       tokenReviewSpec{Token: callerToken, Audiences: a.audiences},
+	})
+	if err != nil {
+		return fmt.Errorf("auth: marshaling TokenReview request: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", a.apiServerURL+"/apis/authentication.k8s.io/v1/tokenreviews", bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("auth: building TokenReview request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(saToken)))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("auth: TokenReview request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: TokenReview request: unexpected status %v", resp.Status)
+	}
+
+	var tr tokenReviewResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return fmt.Errorf("auth: decoding TokenReview response: %v", err)
+	}
+	if !tr.Status.Authenticated {
+		if tr.Status.Error != "" {
+			return fmt.Errorf("auth: token not authenticated: %v", tr.Status.Error)
+		}
+		return fmt.Errorf("auth: token not authenticated")
+	}
+	return nil
+}
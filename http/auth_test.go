@@ -0,0 +1,192 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+func writeTokenFile(t *testing.T, token string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token.txt")
+	// A trailing newline is typical of a file created with an editor or echo; newTokenAuthenticator
+	// should trim it rather than require a caller's bearer token to include it.
+	if err := os.WriteFile(path, []byte(token+"\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	a, err := newTokenAuthenticator(writeTokenFile(t, "s3cr3t"))
+	if err != nil {
+		t.Fatalf("newTokenAuthenticator: %v", err)
+	}
+
+	t.Run("accepts the matching token", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Authorization", "Bearer s3cr3t")
+		if err := a.authenticate(r); err != nil {
+			t.Errorf("authenticate: %v", err)
+		}
+	})
+
+	t.Run("rejects a missing Authorization header", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		if err := a.authenticate(r); err == nil {
+			t.Error("expected an error with no Authorization header")
+		}
+	})
+
+	t.Run("rejects a non-Bearer scheme", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Authorization", "Basic s3cr3t")
+		if err := a.authenticate(r); err == nil {
+			t.Error("expected an error with a non-Bearer Authorization header")
+		}
+	})
+
+	t.Run("rejects a same-length but wrong token", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Authorization", "Bearer s3cr3T")
+		if err := a.authenticate(r); err == nil {
+			t.Error("expected an error with a wrong token of the same length")
+		}
+	})
+
+	t.Run("rejects a different-length token", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Authorization", "Bearer short")
+		if err := a.authenticate(r); err == nil {
+			t.Error("expected an error with a wrong-length token")
+		}
+	})
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	a := mtlsAuthenticator{}
+
+	t.Run("accepts a request with a verified client certificate", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+		if err := a.authenticate(r); err != nil {
+			t.Errorf("authenticate: %v", err)
+		}
+	})
+
+	t.Run("rejects a plaintext request", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		if err := a.authenticate(r); err == nil {
+			t.Error("expected an error with no TLS connection state")
+		}
+	})
+
+	t.Run("rejects a TLS request with no verified chains", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.TLS = &tls.ConnectionState{}
+		if err := a.authenticate(r); err == nil {
+			t.Error("expected an error with no verified client certificate")
+		}
+	})
+}
+
+func TestK8sTokenReviewAuthenticator(t *testing.T) {
+	newFakeAPIServer := func(t *testing.T, respond func(tokenReviewRequest) tokenReviewResponse, status int) *httptest.Server {
+		t.Helper()
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req tokenReviewRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decoding TokenReview request: %v", err)
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(respond(req))
+		}))
+	}
+	newAuthenticator := func(t *testing.T, srv *httptest.Server) *k8sTokenReviewAuthenticator {
+		t.Helper()
+		a, err := newK8sTokenReviewAuthenticator(&config.K8sTokenReviewAuth{
+			APIServerURL:            srv.URL,
+			ServiceAccountTokenFile: writeTokenFile(t, "sa-token"),
+		})
+		if err != nil {
+			t.Fatalf("newK8sTokenReviewAuthenticator: %v", err)
+		}
+		return a
+	}
+
+	t.Run("accepts an authenticated caller token", func(t *testing.T) {
+		srv := newFakeAPIServer(t, func(req tokenReviewRequest) tokenReviewResponse {
+			if req.Spec.Token != "caller-token" {
+				t.Errorf("TokenReview request carried token %q, want %q", req.Spec.Token, "caller-token")
+			}
+			return tokenReviewResponse{Status: tokenReviewStatus{Authenticated: true}}
+		}, http.StatusCreated)
+		defer srv.Close()
+
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Authorization", "Bearer caller-token")
+		if err := newAuthenticator(t, srv).authenticate(r); err != nil {
+			t.Errorf("authenticate: %v", err)
+		}
+	})
+
+	t.Run("rejects a caller token the API server doesn't authenticate", func(t *testing.T) {
+		srv := newFakeAPIServer(t, func(tokenReviewRequest) tokenReviewResponse {
+			return tokenReviewResponse{Status: tokenReviewStatus{Authenticated: false, Error: "invalid bearer token"}}
+		}, http.StatusCreated)
+		defer srv.Close()
+
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Authorization", "Bearer caller-token")
+		if err := newAuthenticator(t, srv).authenticate(r); err == nil {
+			t.Error("expected an error for an unauthenticated token")
+		}
+	})
+
+	t.Run("rejects an unexpected API server status", func(t *testing.T) {
+		srv := newFakeAPIServer(t, func(tokenReviewRequest) tokenReviewResponse {
+			return tokenReviewResponse{}
+		}, http.StatusInternalServerError)
+		defer srv.Close()
+
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Authorization", "Bearer caller-token")
+		if err := newAuthenticator(t, srv).authenticate(r); err == nil {
+			t.Error("expected an error for a non-2xx API server response")
+		}
+	})
+
+	t.Run("rejects a request with no bearer token before contacting the API server", func(t *testing.T) {
+		srv := newFakeAPIServer(t, func(tokenReviewRequest) tokenReviewResponse {
+			t.Fatal("the API server should not have been contacted")
+			return tokenReviewResponse{}
+		}, http.StatusCreated)
+		defer srv.Close()
+
+		r := httptest.NewRequest("POST", "/report", nil)
+		if err := newAuthenticator(t, srv).authenticate(r); err == nil {
+			t.Error("expected an error with no Authorization header")
+		}
+	})
+}
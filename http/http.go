@@ -16,28 +16,193 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/GoogleCloudPlatform/ubbagent/config"
 	"github.com/GoogleCloudPlatform/ubbagent/sdk"
+	"github.com/golang/glog"
 )
 
 type HttpInterface struct {
-	agent *sdk.Agent
-	port  int
-	mux   http.ServeMux
-	srv   *http.Server
+	agent     *sdk.Agent
+	port      int
+	bindHost  string
+	tlsConfig *tls.Config
+	cert      *reloadingCertificate
+
+	// auth, if non-nil, is required to accept every /report request (and, unless
+	// allowAnonymousStatus is set, every /status request) before it's handled.
+	auth                 authenticator
+	allowAnonymousStatus bool
+
+	mux http.ServeMux
+	srv *http.Server
 }
 
 // NewHttpInterface creates a new agent interface that listens on the given port. The interface
-// must be started with a call to ListenAndServe().
-func NewHttpInterface(agent *sdk.Agent, port int) *HttpInterface {
-	h := &HttpInterface{agent: agent, port: port}
-	h.mux.HandleFunc("/report", h.handleAdd)
-	h.mux.HandleFunc("/status", h.handleStatus)
-	return h
+// must be started with a call to Start(). If agent's recorder exposes a Prometheus /metrics
+// handler (see stats.PrometheusRecorder), it's mounted here too, so ops teams get a scrape target
+// on the same port as /report and /status instead of having to stand up a separate one.
+//
+// server, if non-nil, configures the interface's bind host, TLS, and authentication - see
+// config.Server - letting it be reached from other pods as a sidecar or run as a node-level
+// daemon instead of only from the local host. A nil server preserves the interface's traditional
+// plaintext, unauthenticated, localhost-only behavior.
+func NewHttpInterface(agent *sdk.Agent, port int, server *config.Server) (*HttpInterface, error) {
+	h := &HttpInterface{agent: agent, port: port, bindHost: "localhost"}
+	h.mux.HandleFunc("/report", h.authenticated(h.handleAdd, false))
+	h.mux.HandleFunc("/status", h.authenticated(h.handleStatus, true))
+	if metrics, ok := agent.Recorder().(http.Handler); ok {
+		h.mux.Handle("/metrics", metrics)
+	}
+
+	if server == nil {
+		return h, nil
+	}
+	if server.BindHost != "" {
+		h.bindHost = server.BindHost
+	}
+	if server.TLS != nil {
+		tlsConfig, cert, err := buildTLSConfig(*server.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("http: %v", err)
+		}
+		h.tlsConfig = tlsConfig
+		h.cert = cert
+	}
+	if server.Auth != nil {
+		auth, err := buildAuthenticator(server.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("http: %v", err)
+		}
+		h.auth = auth
+		h.allowAnonymousStatus = server.Auth.AllowAnonymousStatus
+	}
+	return h, nil
+}
+
+// authenticated wraps next so that it's only invoked once h.auth (if configured) accepts the
+// request's credentials. allowAnonymous lets a request through without credentials when h's
+// configured Auth.AllowAnonymousStatus is set - used only for /status, so a liveness-style caller
+// doesn't need credentials while /report still requires them.
+func (h *HttpInterface) authenticated(next http.HandlerFunc, allowAnonymous bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.auth != nil && !(allowAnonymous && h.allowAnonymousStatus) {
+			if err := h.auth.authenticate(r); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// buildTLSConfig constructs a *tls.Config implementing tc, loading its certificate, key, and
+// (if set) client CA bundle from disk. tc must have already passed config.TLSConfig.Validate. The
+// returned *reloadingCertificate periodically re-reads the certificate and key files in the
+// background, so a rotated pair is picked up without a process restart; callers must Close it
+// once the *tls.Config is no longer in use.
+func buildTLSConfig(tc config.TLSConfig) (*tls.Config, *reloadingCertificate, error) {
+	cert, err := newReloadingCertificate(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tlsConfig: error loading certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: cert.getCertificate,
+		MinVersion:     tc.MinVersionOrDefault(),
+		CipherSuites:   tc.CipherSuiteIDs(),
+	}
+	if tc.ClientCAFile != "" {
+		caBytes, err := ioutil.ReadFile(tc.ClientCAFile)
+		if err != nil {
+			cert.Close()
+			return nil, nil, fmt.Errorf("tlsConfig: error reading clientCaFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			cert.Close()
+			return nil, nil, fmt.Errorf("tlsConfig: no certificates found in clientCaFile: %v", tc.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, cert, nil
+}
+
+// certReloadInterval is how often a reloadingCertificate re-reads its certificate and key files
+// from disk.
+const certReloadInterval = 5 * time.Minute
+
+// reloadingCertificate serves a tls.Config.GetCertificate certificate that's periodically re-read
+// from certFile/keyFile, so a cert/key pair rotated on disk is picked up without restarting the
+// process. A reload that fails - e.g. a half-written file mid-rotation - is logged and the
+// previous certificate keeps serving, rather than breaking the listener.
+type reloadingCertificate struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	stop chan struct{}
+	wait sync.WaitGroup
+}
+
+func newReloadingCertificate(certFile, keyFile string) (*reloadingCertificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	rc := &reloadingCertificate{certFile: certFile, keyFile: keyFile, cert: &cert, stop: make(chan struct{})}
+	rc.wait.Add(1)
+	go rc.run()
+	return rc, nil
+}
+
+func (rc *reloadingCertificate) run() {
+	defer rc.wait.Done()
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rc.reload()
+		case <-rc.stop:
+			return
+		}
+	}
+}
+
+func (rc *reloadingCertificate) reload() {
+	cert, err := tls.LoadX509KeyPair(rc.certFile, rc.keyFile)
+	if err != nil {
+		glog.Errorf("http: reloading TLS certificate: %v", err)
+		return
+	}
+	rc.mu.Lock()
+	rc.cert = &cert
+	rc.mu.Unlock()
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+func (rc *reloadingCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.cert, nil
+}
+
+// Close stops rc's background reload loop. It doesn't affect any *tls.Config already serving
+// rc.getCertificate; they simply stop picking up future rotations.
+func (rc *reloadingCertificate) Close() {
+	close(rc.stop)
+	rc.wait.Wait()
 }
 
 func (h *HttpInterface) handleAdd(w http.ResponseWriter, r *http.Request) {
@@ -80,9 +245,17 @@ func (h *HttpInterface) Start(errHandler func(error)) error {
 	if h.srv != nil {
 		return errors.New("already started")
 	}
-	h.srv = &http.Server{Addr: fmt.Sprintf("localhost:%v", h.port), Handler: &h.mux}
+	h.srv = &http.Server{Addr: fmt.Sprintf("%v:%v", h.bindHost, h.port), Handler: &h.mux, TLSConfig: h.tlsConfig}
 	go func() {
-		errHandler(h.srv.ListenAndServe())
+		var err error
+		if h.tlsConfig != nil {
+			// The certificate is served via h.srv.TLSConfig.GetCertificate, so no cert/key file paths
+			// are needed here.
+			err = h.srv.ListenAndServeTLS("", "")
+		} else {
+			err = h.srv.ListenAndServe()
+		}
+		errHandler(err)
 	}()
 	return nil
 }
@@ -95,5 +268,8 @@ func (h *HttpInterface) Shutdown() error {
 	}
 	err := h.srv.Shutdown(context.Background())
 	h.srv = nil
+	if h.cert != nil {
+		h.cert.Close()
+	}
 	return err
 }
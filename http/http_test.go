@@ -0,0 +1,268 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeAuthenticator accepts or rejects every request according to ok, recording whether it was
+// consulted at all.
+type fakeAuthenticator struct {
+	ok     bool
+	called bool
+}
+
+func (f *fakeAuthenticator) authenticate(*http.Request) error {
+	f.called = true
+	if f.ok {
+		return nil
+	}
+	return errors.New("fake: rejected")
+}
+
+func TestHttpInterface_Authenticated(t *testing.T) {
+	newNext := func(called *bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			*called = true
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	t.Run("with no auth configured, every request passes through", func(t *testing.T) {
+		h := &HttpInterface{}
+		var nextCalled bool
+		handler := h.authenticated(newNext(&nextCalled), false)
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/report", nil))
+		if !nextCalled {
+			t.Error("expected next to be called")
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("rejects a request that fails authentication", func(t *testing.T) {
+		auth := &fakeAuthenticator{ok: false}
+		h := &HttpInterface{auth: auth}
+		var nextCalled bool
+		handler := h.authenticated(newNext(&nextCalled), false)
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/report", nil))
+		if nextCalled {
+			t.Error("expected next not to be called")
+		}
+		if !auth.called {
+			t.Error("expected the authenticator to be consulted")
+		}
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("accepts a request that passes authentication", func(t *testing.T) {
+		auth := &fakeAuthenticator{ok: true}
+		h := &HttpInterface{auth: auth}
+		var nextCalled bool
+		handler := h.authenticated(newNext(&nextCalled), false)
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/report", nil))
+		if !nextCalled {
+			t.Error("expected next to be called")
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("allowAnonymous lets an unauthenticated request through only when AllowAnonymousStatus is set", func(t *testing.T) {
+		auth := &fakeAuthenticator{ok: false}
+		h := &HttpInterface{auth: auth, allowAnonymousStatus: true}
+		var nextCalled bool
+		handler := h.authenticated(newNext(&nextCalled), true)
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/status", nil))
+		if auth.called {
+			t.Error("expected the authenticator not to be consulted for an anonymous-allowed request")
+		}
+		if !nextCalled {
+			t.Error("expected next to be called")
+		}
+	})
+
+	t.Run("allowAnonymous on the handler doesn't exempt a request unless AllowAnonymousStatus is also set", func(t *testing.T) {
+		auth := &fakeAuthenticator{ok: false}
+		h := &HttpInterface{auth: auth, allowAnonymousStatus: false}
+		var nextCalled bool
+		handler := h.authenticated(newNext(&nextCalled), true)
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/status", nil))
+		if nextCalled {
+			t.Error("expected next not to be called")
+		}
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("/report is never exempted by AllowAnonymousStatus", func(t *testing.T) {
+		auth := &fakeAuthenticator{ok: false}
+		h := &HttpInterface{auth: auth, allowAnonymousStatus: true}
+		var nextCalled bool
+		// allowAnonymous=false, as NewHttpInterface wires it for "/report".
+		handler := h.authenticated(newNext(&nextCalled), false)
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/report", nil))
+		if nextCalled {
+			t.Error("expected next not to be called")
+		}
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+// writeSelfSignedCert generates a self-signed ECDSA certificate for "localhost", serialized with
+// serial, and writes its PEM cert and key to certFile/keyFile.
+func writeSelfSignedCert(t *testing.T, certFile, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	writePEMFile(t, certFile, "CERTIFICATE", der)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshaling key: %v", err)
+	}
+	writePEMFile(t, keyFile, "EC PRIVATE KEY", keyBytes)
+}
+
+func writePEMFile(t *testing.T, path, blockType string, bytes []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("error creating %v: %v", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: bytes}); err != nil {
+		t.Fatalf("error writing %v: %v", path, err)
+	}
+}
+
+func TestReloadingCertificate_PicksUpRotatedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, 1)
+
+	rc, err := newReloadingCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newReloadingCertificate: %v", err)
+	}
+	defer rc.Close()
+
+	first, err := rc.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("getCertificate: %v", err)
+	}
+	firstLeaf, err := x509.ParseCertificate(first.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	writeSelfSignedCert(t, certFile, keyFile, 2)
+	rc.reload()
+
+	second, err := rc.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("getCertificate: %v", err)
+	}
+	secondLeaf, err := x509.ParseCertificate(second.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	if firstLeaf.SerialNumber.Cmp(secondLeaf.SerialNumber) == 0 {
+		t.Fatal("expected getCertificate to return the rotated certificate after reload")
+	}
+	if secondLeaf.SerialNumber.Int64() != 2 {
+		t.Errorf("reloaded certificate serial = %v, want 2", secondLeaf.SerialNumber.Int64())
+	}
+}
+
+func TestReloadingCertificate_KeepsServingPreviousCertOnReloadFailure(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, 1)
+
+	rc, err := newReloadingCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newReloadingCertificate: %v", err)
+	}
+	defer rc.Close()
+
+	// Simulate a half-written file mid-rotation.
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	rc.reload()
+
+	cert, err := rc.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("getCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	if leaf.SerialNumber.Int64() != 1 {
+		t.Errorf("expected the previous certificate to keep serving after a failed reload, got serial %v", leaf.SerialNumber.Int64())
+	}
+}
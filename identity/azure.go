@@ -0,0 +1,264 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package identity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// azureLoginURLTemplate is AAD's v2.0 token endpoint, used for the client-secret,
+	// certificate-assertion, and federated-token client-credentials flows.
+	azureLoginURLTemplate = "https://login.microsoftonline.com/%v/oauth2/v2.0/token"
+
+	// azureIMDSTokenURL is the Azure Instance Metadata Service's managed-identity token endpoint.
+	azureIMDSTokenURL   = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureIMDSAPIVersion = "2018-02-01"
+
+	azureAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	azureAssertionTTL  = 10 * time.Minute
+
+	azureTokenTimeout = 60 * time.Second
+)
+
+// NewAzureTokenSource returns an oauth2.TokenSource that authenticates to Azure Active Directory
+// as azure (via client secret, signed certificate assertion, federated token file, or the Azure
+// Instance Metadata Service, depending on how azure is configured) and requests tokens scoped to
+// resource, e.g. an AAD application ID URI or GUID. Tokens are cached until shortly before expiry
+// and refreshed transparently.
+func NewAzureTokenSource(azure *config.AzureIdentity, resource string) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &aadTokenSource{
+		azure:    azure,
+		resource: resource,
+		client:   &http.Client{Timeout: azureTokenTimeout},
+	})
+}
+
+// aadTokenSource obtains AAD bearer tokens scoped to resource, via client-credentials (client
+// secret, signed certificate assertion, or federated token file) or, when azure specifies
+// UseManagedIdentity, the Azure Instance Metadata Service.
+type aadTokenSource struct {
+	azure    *config.AzureIdentity
+	resource string
+	client   *http.Client
+}
+
+func (s *aadTokenSource) Token() (*oauth2.Token, error) {
+	if s.azure.UseManagedIdentity {
+		return s.managedIdentityToken()
+	}
+	if s.azure.Certificate != nil {
+		return s.certificateToken()
+	}
+	if s.azure.FederatedTokenFile != "" {
+		return s.federatedToken()
+	}
+	return s.clientSecretToken()
+}
+
+func (s *aadTokenSource) clientSecretToken() (*oauth2.Token, error) {
+	values := url.Values{
+		"client_id":     {s.azure.ClientId},
+		"client_secret": {s.azure.ClientSecret},
+		"scope":         {s.resource + "/.default"},
+		"grant_type":    {"client_credentials"},
+	}
+	return s.requestToken(fmt.Sprintf(azureLoginURLTemplate, s.azure.TenantId), values)
+}
+
+func (s *aadTokenSource) certificateToken() (*oauth2.Token, error) {
+	assertion, err := buildClientAssertion(s.azure)
+	if err != nil {
+		return nil, err
+	}
+	return s.assertionToken(assertion)
+}
+
+// federatedToken authenticates via AAD workload identity federation: the token read from
+// FederatedTokenFile (e.g. a Kubernetes projected service account token) is presented directly as
+// the client assertion, with no local signing.
+func (s *aadTokenSource) federatedToken() (*oauth2.Token, error) {
+	token, err := ioutil.ReadFile(s.azure.FederatedTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("identity: azure: reading federatedTokenFile: %v", err)
+	}
+	return s.assertionToken(string(bytes.TrimSpace(token)))
+}
+
+func (s *aadTokenSource) assertionToken(assertion string) (*oauth2.Token, error) {
+	values := url.Values{
+		"client_id":             {s.azure.ClientId},
+		"scope":                 {s.resource + "/.default"},
+		"client_assertion_type": {azureAssertionType},
+		"client_assertion":      {assertion},
+		"grant_type":            {"client_credentials"},
+	}
+	return s.requestToken(fmt.Sprintf(azureLoginURLTemplate, s.azure.TenantId), values)
+}
+
+func (s *aadTokenSource) requestToken(tokenURL string, values url.Values) (*oauth2.Token, error) {
+	resp, err := s.client.PostForm(tokenURL, values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity: azure: aad token request failed: status %v: %v", resp.StatusCode, string(body))
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("identity: azure: invalid aad token response: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: tr.AccessToken,
+		TokenType:   tr.TokenType,
+		Expiry:      time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (s *aadTokenSource) managedIdentityToken() (*oauth2.Token, error) {
+	q := url.Values{
+		"api-version": {azureIMDSAPIVersion},
+		"resource":    {s.resource},
+	}
+	req, err := http.NewRequest(http.MethodGet, azureIMDSTokenURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity: azure: imds token request failed: status %v: %v", resp.StatusCode, string(body))
+	}
+	// The IMDS token endpoint encodes expires_in as a string, unlike AAD's own token endpoint.
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("identity: azure: invalid imds token response: %v", err)
+	}
+	var expiresIn int64
+	fmt.Sscanf(tr.ExpiresIn, "%d", &expiresIn)
+	return &oauth2.Token{
+		AccessToken: tr.AccessToken,
+		TokenType:   tr.TokenType,
+		Expiry:      time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+// buildClientAssertion signs a JWT client assertion with azure.Certificate's private key, for use
+// in the AAD certificate-based client-credentials flow.
+func buildClientAssertion(azure *config.AzureIdentity) (string, error) {
+	keyBlock, _ := pem.Decode([]byte(azure.Certificate.PrivateKey))
+	if keyBlock == nil {
+		return "", errors.New("identity: azure: invalid certificate privateKey PEM")
+	}
+	key, err := parseRSAPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return "", err
+	}
+	certBlock, _ := pem.Decode([]byte(azure.Certificate.Certificate))
+	if certBlock == nil {
+		return "", errors.New("identity: azure: invalid certificate PEM")
+	}
+	thumbprint := sha1.Sum(certBlock.Bytes)
+
+	jti, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	tokenURL := fmt.Sprintf(azureLoginURLTemplate, azure.TenantId)
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": tokenURL,
+		"iss": azure.ClientId,
+		"sub": azure.ClientId,
+		"jti": jti.String(),
+		"nbf": now.Unix(),
+		"exp": now.Add(azureAssertionTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("identity: azure: signing client assertion: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("identity: azure: invalid certificate privateKey: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("identity: azure: certificate privateKey is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package identity turns a config.GCPIdentity into a usable oauth2.TokenSource, covering both
+// long-lived service account keys and workload identity federation (external account) credentials.
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// defaultTokenURL is used when an ExternalAccountConfig doesn't specify one.
+const defaultTokenURL = "https://sts.googleapis.com/v1/token"
+
+// defaultExecutableTimeout bounds how long an ExecutableCredentialSource's command may run when
+// config.ExecutableCredentialSource.TimeoutMillis is left at zero.
+const defaultExecutableTimeout = 30 * time.Second
+
+// NewTokenSource returns an oauth2.TokenSource that authenticates as gcp for the given scopes.
+// audience is used only when gcp.SelfSignedJWT is set, and should be the base URL of the API
+// being called (e.g. "https://servicecontrol.googleapis.com/"). If gcp.ImpersonateServiceAccount
+// is set, the credential it otherwise resolves to is used only as the bootstrap identity, and the
+// returned TokenSource instead mints tokens for that service account - by way of
+// gcp.ImpersonateDelegates, if set, a chain of intermediate service accounts are impersonated in
+// turn first. Tokens are cached until shortly before expiry and refreshed transparently.
+func NewTokenSource(ctx context.Context, gcp *config.GCPIdentity, audience string, scopes ...string) (oauth2.TokenSource, error) {
+	ts, err := bootstrapTokenSource(ctx, gcp, audience, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if gcp.ImpersonateServiceAccount == "" {
+		return ts, nil
+	}
+	impersonateScopes := scopes
+	if len(gcp.ImpersonateScopes) > 0 {
+		impersonateScopes = gcp.ImpersonateScopes
+	}
+	return impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: gcp.ImpersonateServiceAccount,
+		Delegates:       gcp.ImpersonateDelegates,
+		Scopes:          impersonateScopes,
+	}, option.WithTokenSource(ts))
+}
+
+// bootstrapTokenSource resolves the credential configured directly on gcp, before any
+// ImpersonateServiceAccount wrapping is applied.
+func bootstrapTokenSource(ctx context.Context, gcp *config.GCPIdentity, audience string, scopes []string) (oauth2.TokenSource, error) {
+	if gcp.SelfSignedJWT {
+		// Self-signed JWTs are minted locally from the key and presented directly as a bearer
+		// token, so there's no OAuth token endpoint round-trip to make on first use or refresh.
+		return google.JWTAccessTokenSourceFromJSON(gcp.GetServiceAccountKey(), audience)
+	}
+	if gcp.ExternalAccount != nil {
+		return newExternalAccountTokenSource(ctx, gcp.ExternalAccount, scopes)
+	}
+	if gcp.ApplicationDefault {
+		// On GCE/GKE/Cloud Run this resolves to the instance metadata service; elsewhere it falls
+		// back to GOOGLE_APPLICATION_CREDENTIALS or the gcloud user credentials. Either way, the
+		// returned TokenSource refreshes itself automatically.
+		creds, err := google.FindDefaultCredentials(ctx, scopes...)
+		if err != nil {
+			return nil, err
+		}
+		return creds.TokenSource, nil
+	}
+	creds, err := google.CredentialsFromJSON(ctx, gcp.GetServiceAccountKey(), scopes...)
+	if err != nil {
+		return nil, err
+	}
+	return creds.TokenSource, nil
+}
+
+func newExternalAccountTokenSource(ctx context.Context, ea *config.ExternalAccountConfig, scopes []string) (oauth2.TokenSource, error) {
+	if ea.CredentialSource.Executable != nil {
+		// The vendored golang.org/x/oauth2/google release this binary builds against can exchange
+		// file, URL, and AWS subject tokens natively, but has no notion of an executable credential
+		// source. We bridge the gap by running the command ourselves and handing the resulting
+		// subject token to the library as a (temporary) file source.
+		return oauth2.ReuseTokenSource(nil, &executableTokenSource{ea: ea, scopes: scopes}), nil
+	}
+	jsonKey, err := externalAccountJSON(ea, ea.CredentialSource)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := google.CredentialsFromJSON(ctx, jsonKey, scopes...)
+	if err != nil {
+		return nil, err
+	}
+	return creds.TokenSource, nil
+}
+
+// externalAccountCredentialsFile is the subset of the standard external-account credentials JSON
+// schema that config.ExternalAccountConfig maps onto.
+type externalAccountCredentialsFile struct {
+	Type                           string                    `json:"type"`
+	Audience                       string                    `json:"audience"`
+	SubjectTokenType               string                    `json:"subject_token_type"`
+	TokenURL                       string                    `json:"token_url"`
+	ServiceAccountImpersonationURL string                    `json:"service_account_impersonation_url,omitempty"`
+	CredentialSource               externalAccountCredSource `json:"credential_source"`
+}
+
+type externalAccountCredSource struct {
+	File                        string                `json:"file,omitempty"`
+	URL                         string                `json:"url,omitempty"`
+	Headers                     map[string]string     `json:"headers,omitempty"`
+	EnvironmentID               string                `json:"environment_id,omitempty"`
+	RegionURL                   string                `json:"region_url,omitempty"`
+	RegionalCredVerificationURL string                `json:"regional_cred_verification_url,omitempty"`
+	IMDSv2SessionTokenURL       string                `json:"imdsv2_session_token_url,omitempty"`
+	Format                      externalAccountFormat `json:"format,omitempty"`
+}
+
+type externalAccountFormat struct {
+	Type                  string `json:"type,omitempty"`
+	SubjectTokenFieldName string `json:"subject_token_field_name,omitempty"`
+}
+
+// externalAccountJSON builds the raw external-account credentials JSON for ea, sourcing the
+// subject token as described by cs (which may differ from ea.CredentialSource, e.g. when an
+// executable source has already been resolved to a temporary file).
+func externalAccountJSON(ea *config.ExternalAccountConfig, cs config.CredentialSource) ([]byte, error) {
+	out := externalAccountCredSource{}
+	switch {
+	case cs.File != nil:
+		out.File = cs.File.Path
+		out.Format = externalAccountFormat{Type: cs.File.Format.Type, SubjectTokenFieldName: cs.File.Format.SubjectTokenFieldName}
+	case cs.URL != nil:
+		out.URL = cs.URL.URL
+		out.Headers = cs.URL.Headers
+		out.Format = externalAccountFormat{Type: cs.URL.Format.Type, SubjectTokenFieldName: cs.URL.Format.SubjectTokenFieldName}
+	case cs.AWS != nil:
+		// The underlying library repurposes the "url" field for the AWS signing-credentials
+		// verification URL rather than giving it a name of its own.
+		out.EnvironmentID = "aws1"
+		out.RegionURL = cs.AWS.RegionURL
+		out.RegionalCredVerificationURL = cs.AWS.RegionalCredVerificationURL
+		out.URL = cs.AWS.CredVerificationURL
+		out.IMDSv2SessionTokenURL = cs.AWS.IMDSv2SessionTokenURL
+	default:
+		return nil, errors.New("identity: externalAccount: no credential source configured")
+	}
+	tokenURL := ea.TokenURL
+	if tokenURL == "" {
+		tokenURL = defaultTokenURL
+	}
+	f := externalAccountCredentialsFile{
+		Type:                           "external_account",
+		Audience:                       ea.Audience,
+		SubjectTokenType:               ea.SubjectTokenType,
+		TokenURL:                       tokenURL,
+		ServiceAccountImpersonationURL: ea.ServiceAccountImpersonationURL,
+		CredentialSource:               out,
+	}
+	return json.Marshal(f)
+}
+
+// executableTokenSource obtains a subject token by running a config.ExecutableCredentialSource's
+// command, then performs the usual STS (and optional impersonation) exchange for it.
+type executableTokenSource struct {
+	ea     *config.ExternalAccountConfig
+	scopes []string
+}
+
+func (e *executableTokenSource) Token() (*oauth2.Token, error) {
+	subjectToken, err := e.runExecutable()
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile("", "ubbagent-subject-token")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(subjectToken); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	jsonKey, err := externalAccountJSON(e.ea, config.CredentialSource{
+		File: &config.FileCredentialSource{Path: tmp.Name(), Format: config.CredentialFormat{Type: "text"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	creds, err := google.CredentialsFromJSON(context.Background(), jsonKey, e.scopes...)
+	if err != nil {
+		return nil, err
+	}
+	return creds.TokenSource.Token()
+}
+
+// executableTokenResponse is the subset of the executable-sourced-credential response format
+// (https://google.aip.dev/auth/4117#executable-sourced-credentials) this agent understands.
+type executableTokenResponse struct {
+	Success      bool   `json:"success"`
+	TokenType    string `json:"token_type"`
+	IdToken      string `json:"id_token"`
+	SamlResponse string `json:"saml_response"`
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+}
+
+// allowExecutablesEnvVar, when set to "1", permits an ExecutableCredentialSource's command to run.
+// Without it, runExecutable refuses: an agent config compromised by an attacker (or mistakenly
+// pointed at one from an untrusted source) shouldn't be able to run an arbitrary command just by
+// naming it in an identity's credential_source, matching the equivalent safeguard in Google's own
+// external-account credential libraries.
+const allowExecutablesEnvVar = "GOOGLE_EXTERNAL_ACCOUNT_ALLOW_EXECUTABLES"
+
+func (e *executableTokenSource) runExecutable() (string, error) {
+	if os.Getenv(allowExecutablesEnvVar) != "1" {
+		return "", fmt.Errorf("identity: executable credential source: executables are disabled; set %v=1 to enable", allowExecutablesEnvVar)
+	}
+	timeout := time.Duration(e.ea.CredentialSource.Executable.TimeoutMillis) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultExecutableTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "/bin/sh", "-c", e.ea.CredentialSource.Executable.Command).Output()
+	if err != nil {
+		return "", fmt.Errorf("identity: executable credential source: %v", err)
+	}
+	var resp executableTokenResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return "", fmt.Errorf("identity: executable credential source: invalid response: %v", err)
+	}
+	if !resp.Success {
+		return "", fmt.Errorf("identity: executable credential source: %v: %v", resp.Code, resp.Message)
+	}
+	switch resp.TokenType {
+	case "urn:ietf:params:oauth:token-type:jwt", "urn:ietf:params:oauth:token-type:id_token":
+		if resp.IdToken == "" {
+			return "", errors.New("identity: executable credential source: missing id_token")
+		}
+		return resp.IdToken, nil
+	case "urn:ietf:params:oauth:token-type:saml2":
+		if resp.SamlResponse == "" {
+			return "", errors.New("identity: executable credential source: missing saml_response")
+		}
+		return resp.SamlResponse, nil
+	default:
+		return "", fmt.Errorf("identity: executable credential source: unsupported token_type: %v", resp.TokenType)
+	}
+}
@@ -15,6 +15,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -22,7 +24,11 @@ import (
 	"os"
 	"os/signal"
 
+	"github.com/GoogleCloudPlatform/ubbagent/auditlog"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/grpc"
 	"github.com/GoogleCloudPlatform/ubbagent/http"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
 	"github.com/GoogleCloudPlatform/ubbagent/sdk"
 	"github.com/golang/glog"
 )
@@ -32,6 +38,11 @@ var stateDir = flag.String("state-dir", "", "persistent state directory")
 var noState = flag.Bool("no-state", false, "do not store persistent state")
 var localPort = flag.Int("local-port", 0, "local HTTP daemon port")
 var noHttp = flag.Bool("no-http", false, "do not start the HTTP daemon")
+var grpcPort = flag.Int("grpc-port", 0, "local gRPC daemon port; unset disables the gRPC daemon")
+var watchConfig = flag.Bool("watch-config", false, "reload the configuration file on SIGHUP instead of requiring a restart")
+var verifyAuditLog = flag.String("verify-audit-log", "", "verify the audit log under the given state directory's audit subdirectory and exit, without starting the agent")
+var verifyAuditLogKeyFile = flag.String("verify-audit-log-key-file", "", "path to the HMAC key file used to authenticate the audit log (see config.AuditLog.KeyFile); required whenever the agent was run with an auditLog.keyFile configured")
+var inspectQueue = flag.String("inspect-queue", "", "print the pending entries of the named persistence queue under --state-dir and exit, without starting the agent or draining the queue (e.g. \"epqueue/<endpoint-name>\" for an endpoint's retry queue)")
 
 // main is the entry point to the standalone agent. It constructs a new app.App with the config file
 // specified using the --config flag, and it starts the http interface. SIGINT will initiate a
@@ -39,6 +50,14 @@ var noHttp = flag.Bool("no-http", false, "do not start the HTTP daemon")
 func main() {
 	flag.Parse()
 
+	if *verifyAuditLog != "" {
+		verifyAuditLogAndExit(*verifyAuditLog, *verifyAuditLogKeyFile)
+	}
+
+	if *inspectQueue != "" {
+		inspectQueueAndExit(*stateDir, *inspectQueue)
+	}
+
 	if *configPath == "" {
 		fmt.Fprintln(os.Stderr, "configuration file must be specified")
 		flag.Usage()
@@ -67,9 +86,18 @@ func main() {
 		exitf("startup: failed to create agent: %+v", err)
 	}
 
+	var watcher *config.Watcher
+	if *watchConfig {
+		watcher = agent.WatchConfigFile(*configPath)
+		infof("Watching %v for config changes (SIGHUP)", *configPath)
+	}
+
 	var rest *http.HttpInterface
 	if *localPort > 0 {
-		rest = http.NewHttpInterface(agent, *localPort)
+		rest, err = http.NewHttpInterface(agent, *localPort, agent.Config().Server)
+		if err != nil {
+			exitf("startup: %+v", err)
+		}
 		if err := rest.Start(func(err error) {
 			// Process async http errors (which may be an immediate port in use error).
 			if err != httplib.ErrServerClosed {
@@ -83,20 +111,92 @@ func main() {
 		infof("Not starting HTTP daemon")
 	}
 
+	var grpcIface *grpc.GRPCInterface
+	if *grpcPort > 0 {
+		grpcIface = grpc.NewGRPCInterface(agent, *grpcPort)
+		if err := grpcIface.Start(func(err error) {
+			if err != nil {
+				exitf("grpc: %+v", err)
+			}
+		}); err != nil {
+			exitf("startup: %+v", err)
+		}
+		infof("Listening locally on gRPC port %v", *grpcPort)
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
 
 	infof("Shutting down...")
+	if watcher != nil {
+		watcher.Close()
+	}
 	if rest != nil {
 		rest.Shutdown()
 	}
+	if grpcIface != nil {
+		grpcIface.Shutdown()
+	}
 	if err := agent.Shutdown(); err != nil {
 		glog.Warningf("shutdown: %+v", err)
 	}
 	glog.Flush()
 }
 
+// verifyAuditLogAndExit verifies the audit log under dir against the HMAC key in keyFile (see
+// --verify-audit-log-key-file; empty if the log was created with no key), prints the result, and
+// exits the process: status 0 if the chain is intact, 1 if it's broken or couldn't be read.
+func verifyAuditLogAndExit(dir, keyFile string) {
+	var key []byte
+	if keyFile != "" {
+		var err error
+		key, err = ioutil.ReadFile(keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read audit log key file: %+v\n", err)
+			os.Exit(1)
+		}
+		key = bytes.TrimSpace(key)
+	}
+	result, err := auditlog.Verify(dir, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify audit log: %+v\n", err)
+		os.Exit(1)
+	}
+	if result.Broken() {
+		fmt.Fprintf(os.Stderr, "audit log is broken: %v (file: %v, sequence: %v)\n", result.Reason, result.BrokenFile, result.BrokenSequence)
+		os.Exit(1)
+	}
+	fmt.Printf("audit log is intact: %v entries verified, head %v\n", result.EntryCount, result.Head)
+	os.Exit(0)
+}
+
+// inspectQueueAndExit prints each pending entry of the named persistence queue under dir, one per
+// line, without removing them, then exits: status 0 on success, 1 on failure.
+func inspectQueueAndExit(dir string, name string) {
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "state directory must be specified (--state-dir) to use --inspect-queue")
+		os.Exit(2)
+	}
+	p, err := persistence.NewDiskPersistence(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open state directory: %+v\n", err)
+		os.Exit(1)
+	}
+	count := 0
+	err = p.Queue(name).RangeUnderLock(func(raw json.RawMessage) error {
+		count++
+		fmt.Println(string(raw))
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read queue %v: %+v\n", name, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%v entries in queue %v\n", count, name)
+	os.Exit(0)
+}
+
 // infof prints a message to stdout and also logs it to the INFO log.
 func infof(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
@@ -20,22 +20,99 @@ import (
 )
 
 const (
-	IntType    = "int"
-	DoubleType = "double"
+	IntType          = "int"
+	DoubleType       = "double"
+	DistributionType = "distribution"
 )
 
-// Definition describes a single reportable metric's name and type.
+// maxDistributionBuckets bounds DistributionOptions.NumBuckets, keeping a metric's persisted and
+// wire-format histogram size reasonable.
+const maxDistributionBuckets = 200
+
+// DistributionOptions configures the fixed-base logarithmic histogram used by a Definition with
+// Type DistributionType. Bucket i covers the range [Base^(Offset+i), Base^(Offset+i+1)); values
+// below the first bucket or at or above the last are counted in Distribution's Underflow and
+// Overflow instead.
+type DistributionOptions struct {
+	// Base is the histogram's bucket growth factor, e.g. 2 or 1.15. It must be greater than 1.
+	Base float64
+
+	// NumBuckets is the number of buckets to maintain, bounded by maxDistributionBuckets.
+	NumBuckets int
+
+	// Offset shifts the exponent of the first bucket's lower bound, allowing the histogram's range
+	// to be centered on the metric's expected scale rather than starting at Base^0.
+	Offset int
+}
+
+func (d *DistributionOptions) Validate() error {
+	if d.Base <= 1 {
+		return fmt.Errorf("distribution: base must be greater than 1: %v", d.Base)
+	}
+	if d.NumBuckets <= 0 || d.NumBuckets > maxDistributionBuckets {
+		return fmt.Errorf("distribution: numBuckets must be between 1 and %v: %v", maxDistributionBuckets, d.NumBuckets)
+	}
+	return nil
+}
+
+// Aggregation kinds that a Definition's Kind may specify. These determine how the Aggregator
+// combines multiple reports for the same metric and labels within a single bucket.
+const (
+	// KindSum adds values together. This is the default when Kind is empty, preserving ubbagent's
+	// original counter-only behavior.
+	KindSum = "sum"
+	// KindMax keeps the largest value seen.
+	KindMax = "max"
+	// KindMin keeps the smallest value seen.
+	KindMin = "min"
+	// KindLast keeps the most recently reported value, discarding earlier ones.
+	KindLast = "last"
+)
+
+// Definition describes a single reportable metric's name, type, and how values from multiple
+// reports should be combined during aggregation.
 type Definition struct {
 	Name string
 	Type string
+	Kind string
+
+	// Distribution configures the histogram used to aggregate values when Type is
+	// DistributionType. It must be set if and only if Type is DistributionType; Kind is ignored for
+	// such a Definition, since a distribution is always combined by merging histograms.
+	Distribution *DistributionOptions
 }
 
 func (m *Definition) Validate() error {
 	if m.Name == "" {
 		return errors.New("missing metric name")
 	}
-	if m.Type != IntType && m.Type != DoubleType {
+	switch m.Type {
+	case IntType, DoubleType:
+		if m.Distribution != nil {
+			return fmt.Errorf("metric %v: distribution may only be set for type %v", m.Name, DistributionType)
+		}
+	case DistributionType:
+		if m.Distribution == nil {
+			return fmt.Errorf("metric %v: type %v requires distribution options", m.Name, DistributionType)
+		}
+		if err := m.Distribution.Validate(); err != nil {
+			return fmt.Errorf("metric %v: %v", m.Name, err)
+		}
+	default:
 		return fmt.Errorf("metric %v: invalid value type: %v", m.Name, m.Type)
 	}
+	switch m.Kind {
+	case "", KindSum, KindMax, KindMin, KindLast:
+	default:
+		return fmt.Errorf("metric %v: invalid aggregation kind: %v", m.Name, m.Kind)
+	}
 	return nil
 }
+
+// EffectiveKind returns the Definition's Kind, defaulting to KindSum when it's unset.
+func (m *Definition) EffectiveKind() string {
+	if m.Kind == "" {
+		return KindSum
+	}
+	return m.Kind
+}
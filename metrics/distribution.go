@@ -0,0 +1,95 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"math"
+)
+
+// Distribution is an approximate histogram of observed values, bucketed according to a
+// Definition's DistributionOptions. It's the value representation for a Definition with Type
+// DistributionType, and also streams the exact Sum, Count, Min, and Max of every observation
+// merged into it, for use cases that don't need full bucket resolution.
+type Distribution struct {
+	// Buckets holds a count of observations per bucket, indexed per DistributionOptions' doc
+	// comment. len(Buckets) always equals the defining Definition's Distribution.NumBuckets.
+	Buckets []uint32 `json:"buckets"`
+
+	// Underflow counts observations below the first bucket's lower bound.
+	Underflow uint32 `json:"underflow"`
+
+	// Overflow counts observations at or above the last bucket's upper bound.
+	Overflow uint32 `json:"overflow"`
+
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// NewDistribution creates a Distribution containing a single observation of value, bucketed
+// according to opts.
+func NewDistribution(opts *DistributionOptions, value float64) *Distribution {
+	d := &Distribution{
+		Buckets: make([]uint32, opts.NumBuckets),
+		Count:   1,
+		Sum:     value,
+		Min:     value,
+		Max:     value,
+	}
+	switch idx := bucketIndex(opts, value); {
+	case idx < 0:
+		d.Underflow++
+	case idx >= len(d.Buckets):
+		d.Overflow++
+	default:
+		d.Buckets[idx]++
+	}
+	return d
+}
+
+// bucketIndex returns the index, into a Distribution built with opts, of the bucket that value
+// falls into. The result may be negative (value belongs in Underflow) or >= opts.NumBuckets
+// (value belongs in Overflow).
+func bucketIndex(opts *DistributionOptions, value float64) int {
+	if value <= 0 {
+		return -1
+	}
+	return int(math.Floor(math.Log(value)/math.Log(opts.Base))) - opts.Offset
+}
+
+// Merge combines other into d: bucket counts, Underflow, Overflow, Count, and Sum are added
+// together, and Min/Max are expanded to cover both. d and other must have been built with the same
+// DistributionOptions; Merge returns an error if their bucket counts don't match.
+func (d *Distribution) Merge(other *Distribution) error {
+	if len(d.Buckets) != len(other.Buckets) {
+		return fmt.Errorf("distribution: bucket count mismatch: %v != %v", len(d.Buckets), len(other.Buckets))
+	}
+	for i, c := range other.Buckets {
+		d.Buckets[i] += c
+	}
+	d.Underflow += other.Underflow
+	d.Overflow += other.Overflow
+	d.Count += other.Count
+	d.Sum += other.Sum
+	if other.Min < d.Min {
+		d.Min = other.Min
+	}
+	if other.Max > d.Max {
+		d.Max = other.Max
+	}
+	return nil
+}
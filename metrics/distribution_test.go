@@ -0,0 +1,109 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+func TestDistributionOptions_Validate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		opts := metrics.DistributionOptions{Base: 2, NumBuckets: 50}
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid: base not greater than 1", func(t *testing.T) {
+		opts := metrics.DistributionOptions{Base: 1, NumBuckets: 50}
+		if err := opts.Validate(); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("invalid: numBuckets too small", func(t *testing.T) {
+		opts := metrics.DistributionOptions{Base: 2, NumBuckets: 0}
+		if err := opts.Validate(); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("invalid: numBuckets too large", func(t *testing.T) {
+		opts := metrics.DistributionOptions{Base: 2, NumBuckets: 201}
+		if err := opts.Validate(); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+}
+
+func TestNewDistribution(t *testing.T) {
+	opts := &metrics.DistributionOptions{Base: 2, NumBuckets: 10}
+
+	t.Run("bucketed observation", func(t *testing.T) {
+		// 4 falls in bucket 2: [2^2, 2^3).
+		d := metrics.NewDistribution(opts, 4)
+		if d.Count != 1 || d.Sum != 4 || d.Min != 4 || d.Max != 4 {
+			t.Fatalf("unexpected distribution: %+v", d)
+		}
+		if d.Buckets[2] != 1 {
+			t.Fatalf("expected bucket 2 to have count 1, got %+v", d.Buckets)
+		}
+	})
+
+	t.Run("underflow", func(t *testing.T) {
+		d := metrics.NewDistribution(opts, 0)
+		if d.Underflow != 1 {
+			t.Fatalf("expected Underflow == 1, got %+v", d)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		// 2^10 is outside the last bucket, [2^9, 2^10).
+		d := metrics.NewDistribution(opts, 1024)
+		if d.Overflow != 1 {
+			t.Fatalf("expected Overflow == 1, got %+v", d)
+		}
+	})
+}
+
+func TestDistribution_Merge(t *testing.T) {
+	opts := &metrics.DistributionOptions{Base: 2, NumBuckets: 10}
+
+	t.Run("combines counts and extremes", func(t *testing.T) {
+		a := metrics.NewDistribution(opts, 4)
+		b := metrics.NewDistribution(opts, 16)
+
+		if err := a.Merge(b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a.Count != 2 || a.Sum != 20 || a.Min != 4 || a.Max != 16 {
+			t.Fatalf("unexpected merged distribution: %+v", a)
+		}
+		if a.Buckets[2] != 1 || a.Buckets[4] != 1 {
+			t.Fatalf("unexpected merged buckets: %+v", a.Buckets)
+		}
+	})
+
+	t.Run("bucket count mismatch", func(t *testing.T) {
+		a := metrics.NewDistribution(opts, 4)
+		b := metrics.NewDistribution(&metrics.DistributionOptions{Base: 2, NumBuckets: 5}, 4)
+
+		if err := a.Merge(b); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+}
@@ -26,8 +26,9 @@ import (
 // MetricValue holds a single named metric value. Only one of the individual type fields should
 // be non-nil.
 type MetricValue struct {
-	Int64Value  *int64   `json:"int64Value,omitempty"`
-	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	Int64Value        *int64        `json:"int64Value,omitempty"`
+	DoubleValue       *float64      `json:"doubleValue,omitempty"`
+	DistributionValue *Distribution `json:"distributionValue,omitempty"`
 }
 
 // Validate returns an error if the metric value does not match its definition.
@@ -37,12 +38,23 @@ func (mv MetricValue) Validate(def Definition) error {
 		if mv.DoubleValue != nil {
 			return fmt.Errorf("double value specified for integer metric: %v", *mv.DoubleValue)
 		}
+		if mv.DistributionValue != nil {
+			return fmt.Errorf("distribution value specified for integer metric")
+		}
 		break
 	case DoubleType:
 		if mv.Int64Value != nil {
 			return fmt.Errorf("integer value specified for double metric: %v", *mv.Int64Value)
 		}
+		if mv.DistributionValue != nil {
+			return fmt.Errorf("distribution value specified for double metric")
+		}
 		break
+	case DistributionType:
+		if mv.DistributionValue != nil && len(mv.DistributionValue.Buckets) != def.Distribution.NumBuckets {
+			return fmt.Errorf("distribution value has %v buckets, metric %v defines %v",
+				len(mv.DistributionValue.Buckets), def.Name, def.Distribution.NumBuckets)
+		}
 	}
 
 	return nil
@@ -55,6 +67,14 @@ type MetricReport struct {
 	EndTime   time.Time         `json:"endTime"`
 	Labels    map[string]string `json:"labels"`
 	Value     MetricValue       `json:"value"`
+
+	// ClientId, if set, is a caller-supplied idempotency key identifying this specific observation.
+	// An Aggregator uses it to recognize and drop an exact replay of a report it has already
+	// aggregated - for example, one resubmitted by a client that retried after losing the response
+	// to a successful AddReport call. Unlike StampedMetricReport.Id, which an Aggregator's downstream
+	// pipeline assigns once a report is finished, ClientId is set by the report's originator before
+	// aggregation.
+	ClientId string `json:"clientId,omitempty"`
 }
 
 // Equal returns if the two MetricReports are the same.
@@ -66,7 +86,8 @@ func (mr MetricReport) Equal(other MetricReport) bool {
 		mr.StartTime.Equal(other.StartTime) &&
 		mr.EndTime.Equal(other.EndTime) &&
 		reflect.DeepEqual(mr.Labels, other.Labels) &&
-		reflect.DeepEqual(mr.Value, other.Value)
+		reflect.DeepEqual(mr.Value, other.Value) &&
+		mr.ClientId == other.ClientId
 }
 
 // Copy returns a deep copy of the MetricReport
@@ -78,6 +99,11 @@ func (mr MetricReport) Copy() MetricReport {
 	if mr.Value.DoubleValue != nil {
 		dup.Value.DoubleValue = util.NewFloat64(*mr.Value.DoubleValue)
 	}
+	if mr.Value.DistributionValue != nil {
+		d := *mr.Value.DistributionValue
+		d.Buckets = append([]uint32(nil), mr.Value.DistributionValue.Buckets...)
+		dup.Value.DistributionValue = &d
+	}
 	return dup
 }
 
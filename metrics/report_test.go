@@ -108,4 +108,65 @@ func TestMetricReport_Validate(t *testing.T) {
 			t.Fatalf("Expected error containing \"integer value specified\", got: %+v", err)
 		}
 	})
+
+	t.Run("Invalid type: distribution specified for int metric", func(t *testing.T) {
+		m := metrics.MetricReport{
+			Name:      "int-metric",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"Key": "Value"},
+			Value: metrics.MetricValue{
+				DistributionValue: metrics.NewDistribution(&metrics.DistributionOptions{Base: 2, NumBuckets: 10}, 1),
+			},
+		}
+		if err := m.Validate(int_metric); err == nil || !strings.Contains(err.Error(), "distribution value specified") {
+			t.Fatalf("Expected error containing \"distribution value specified\", got: %+v", err)
+		}
+	})
+
+	t.Run("Valid: distribution metric with pre-bucketed value", func(t *testing.T) {
+		distribution_metric := metrics.Definition{
+			Name: "distribution-metric",
+			Type: metrics.DistributionType,
+			Distribution: &metrics.DistributionOptions{
+				Base:       2,
+				NumBuckets: 10,
+			},
+		}
+		m := metrics.MetricReport{
+			Name:      "distribution-metric",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"Key": "Value"},
+			Value: metrics.MetricValue{
+				DistributionValue: metrics.NewDistribution(distribution_metric.Distribution, 4),
+			},
+		}
+		if err := m.Validate(distribution_metric); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+	})
+
+	t.Run("Invalid: distribution metric with mismatched bucket count", func(t *testing.T) {
+		distribution_metric := metrics.Definition{
+			Name: "distribution-metric",
+			Type: metrics.DistributionType,
+			Distribution: &metrics.DistributionOptions{
+				Base:       2,
+				NumBuckets: 10,
+			},
+		}
+		m := metrics.MetricReport{
+			Name:      "distribution-metric",
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+			Labels:    map[string]string{"Key": "Value"},
+			Value: metrics.MetricValue{
+				DistributionValue: metrics.NewDistribution(&metrics.DistributionOptions{Base: 2, NumBuckets: 5}, 4),
+			},
+		}
+		if err := m.Validate(distribution_metric); err == nil || !strings.Contains(err.Error(), "buckets") {
+			t.Fatalf("Expected error containing \"buckets\", got: %+v", err)
+		}
+	})
 }
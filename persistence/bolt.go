@@ -0,0 +1,85 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// boltBucket is the single bbolt bucket every name is stored under.
+var boltBucket = []byte("ubbagent")
+
+// NewBoltPersistence creates a Persistence backed by a single embedded BoltDB file at path,
+// created if it doesn't already exist. Unlike diskPersistence's one-json-file-per-name layout,
+// every name lives in one bucket within this one file, committed via a bbolt transaction on each
+// Store and Remove - avoiding the per-name file churn "disk" has under high report rates.
+func NewBoltPersistence(path string) (Persistence, error) {
+	if err := os.MkdirAll(filepath.Dir(path), directoryMode); err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(path, fileMode, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newDriverPersistence(&boltDriver{db: db}), nil
+}
+
+type boltDriver struct {
+	db *bbolt.DB
+}
+
+func (d *boltDriver) get(name string) ([]byte, bool, error) {
+	var data []byte
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(boltBucket).Get([]byte(name)); v != nil {
+			data = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return data, data != nil, nil
+}
+
+func (d *boltDriver) put(name string, data []byte) error {
+	return d.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(name), data)
+	})
+}
+
+func (d *boltDriver) delete(name string) (bool, error) {
+	var existed bool
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		existed = b.Get([]byte(name)) != nil
+		if !existed {
+			return nil
+		}
+		return b.Delete([]byte(name))
+	})
+	return existed, err
+}
@@ -23,23 +23,75 @@ import (
 	"sync"
 )
 
+// walCheckpointThreshold is the number of WAL entries a diskPersistence in WAL mode accumulates
+// before it checkpoints: writing every touched name's current state to its per-name json file and
+// truncating the log. This bounds how large the log - and the replay work at the next startup -
+// can grow.
+const walCheckpointThreshold = 100
+
 // Type diskPersistence is a Persistence implementation that stores values and queues as json text
 // files in a hierarchy under a specified filesystem directory. It utilizes a memory persistence
 // for normal operations: stored values are written to both memory and disk; values are loaded
 // from memory except for the first time where a load from disk is attempted.
+//
+// In WAL mode (see NewDiskPersistenceWithWAL), a Store or Remove is additionally appended to a
+// write-ahead log and fsynced before it returns, and its per-name json file is updated only at the
+// next checkpoint. This trades a slower per-name file update for a cheaper durability guarantee:
+// an append is a single sequential write, rather than the temp-file-write/fsync/rename/fsync-dir
+// sequence a per-name file update requires.
 type diskPersistence struct {
 	directory string
 	memory    *memoryPersistence
 	mutex     sync.RWMutex
+
+	// wal and dirty are nil unless this diskPersistence is in WAL mode.
+	wal   *wal
+	dirty map[string]bool // names touched since the last checkpoint
 }
 
 // NewDiskPersistence creates a diskPersistence that stores data under the given filesystem
-// directory.
+// directory. Every Store and Remove is synchronously and atomically written to its per-name json
+// file before returning.
 func NewDiskPersistence(directory string) (Persistence, error) {
+	return newDiskPersistence(directory, false)
+}
+
+// NewDiskPersistenceWithWAL creates a diskPersistence, as NewDiskPersistence does, but in WAL
+// mode: Store and Remove are made durable via a write-ahead log rather than updating their
+// per-name json file immediately. The log is replayed into memory here, before
+// NewDiskPersistenceWithWAL returns, recovering any mutation that hadn't yet been checkpointed
+// when the process last exited.
+func NewDiskPersistenceWithWAL(directory string) (Persistence, error) {
+	return newDiskPersistence(directory, true)
+}
+
+func newDiskPersistence(directory string, useWAL bool) (*diskPersistence, error) {
 	if err := os.MkdirAll(directory, directoryMode); err != nil {
 		return nil, errors.New("persistence: could not create directory: " + directory + ": " + err.Error())
 	}
-	return &diskPersistence{directory: directory, memory: newMemoryPersistence()}, nil
+	p := &diskPersistence{directory: directory, memory: newMemoryPersistence()}
+	if !useWAL {
+		return p, nil
+	}
+	w, err := openWAL(path.Join(directory, walFileName))
+	if err != nil {
+		return nil, err
+	}
+	p.wal = w
+	p.dirty = make(map[string]bool)
+	err = w.replay(func(entry walEntry) {
+		switch entry.Op {
+		case walOpStore:
+			p.memory.items[entry.Name] = entry.Data
+		case walOpRemove:
+			delete(p.memory.items, entry.Name)
+		}
+		p.dirty[entry.Name] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
 func (p *diskPersistence) Value(name string) Value {
@@ -50,6 +102,56 @@ func (p *diskPersistence) Queue(name string) Queue {
 	return &valueQueue{&diskValue{p: p, name: name, memValue: &lockingValue{p.memory.value(name)}}}
 }
 
+// Sync checkpoints the write-ahead log - if this diskPersistence is in WAL mode - by writing every
+// touched name's current state to its per-name json file and truncating the log. It's a no-op
+// otherwise, since every Store and Remove already durably updates its per-name json file before
+// returning.
+func (p *diskPersistence) Sync() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.wal == nil {
+		return nil
+	}
+	return p.checkpointLocked()
+}
+
+// checkpointLocked writes every name in p.dirty to its per-name json file and truncates the WAL.
+// The caller must hold p.mutex.
+func (p *diskPersistence) checkpointLocked() error {
+	for name := range p.dirty {
+		filename := p.jsonFile(name)
+		data, exists := p.memory.items[name]
+		var err error
+		if exists {
+			err = atomicWriteFile(filename, data)
+		} else if err = os.Remove(filename); err != nil && os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.wal.truncate(); err != nil {
+		return err
+	}
+	p.dirty = make(map[string]bool)
+	return nil
+}
+
+// noteDirtyLocked records that name was just mutated via the WAL, checkpointing once
+// walCheckpointThreshold entries have accumulated. The caller must hold p.mutex.
+func (p *diskPersistence) noteDirtyLocked(name string) error {
+	p.dirty[name] = true
+	if p.wal.entries >= walCheckpointThreshold {
+		return p.checkpointLocked()
+	}
+	return nil
+}
+
+func (p *diskPersistence) jsonFile(name string) string {
+	return path.Join(p.directory, name+".json")
+}
+
 type diskValue struct {
 	p        *diskPersistence
 	name     string
@@ -93,20 +195,18 @@ func (v *diskValue) store(obj interface{}) error {
 		return err
 	}
 
-	var jsontext []byte
-	if jsontext, err = json.Marshal(obj); err != nil {
+	jsontext, err := json.Marshal(obj)
+	if err != nil {
 		return err
 	}
-	filename := v.jsonFile(v.name)
-	dirname := path.Dir(filename)
 
-	if err = os.MkdirAll(dirname, directoryMode); err != nil {
-		return err
-	}
-	if err = ioutil.WriteFile(filename, jsontext, fileMode); err != nil {
-		return err
+	if v.p.wal != nil {
+		if err := v.p.wal.append(walEntry{Op: walOpStore, Name: v.name, Data: jsontext}); err != nil {
+			return err
+		}
+		return v.p.noteDirtyLocked(v.name)
 	}
-	return nil
+	return atomicWriteFile(v.jsonFile(v.name), jsontext)
 }
 
 func (v *diskValue) remove() error {
@@ -115,6 +215,13 @@ func (v *diskValue) remove() error {
 		return err
 	}
 
+	if v.p.wal != nil {
+		if err := v.p.wal.append(walEntry{Op: walOpRemove, Name: v.name}); err != nil {
+			return err
+		}
+		return v.p.noteDirtyLocked(v.name)
+	}
+
 	filename := v.jsonFile(v.name)
 	if err := os.Remove(filename); err != nil {
 		if os.IsNotExist(err) {
@@ -122,7 +229,7 @@ func (v *diskValue) remove() error {
 		}
 		return err
 	}
-	return nil
+	return syncDir(path.Dir(filename))
 }
 
 func (v *diskValue) loadBytes(name string) ([]byte, error) {
@@ -139,5 +246,45 @@ func (v *diskValue) loadBytes(name string) ([]byte, error) {
 }
 
 func (v *diskValue) jsonFile(name string) string {
-	return path.Join(v.p.directory, name+".json")
+	return v.p.jsonFile(name)
+}
+
+// atomicWriteFile durably replaces filename's contents with data: it writes to a temporary file in
+// the same directory, fsyncs it, renames it over filename, then fsyncs the directory - so a crash
+// at any point leaves either the old or the new contents intact, never a partial write.
+func atomicWriteFile(filename string, data []byte) error {
+	dirname := path.Dir(filename)
+	if err := os.MkdirAll(dirname, directoryMode); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	return syncDir(dirname)
+}
+
+// syncDir fsyncs dirname, so that a preceding create, rename, or remove within it is durable.
+func syncDir(dirname string) error {
+	d, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
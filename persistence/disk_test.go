@@ -15,6 +15,7 @@
 package persistence
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path"
@@ -98,6 +99,124 @@ func TestRestoredFromFile(t *testing.T) {
 	}
 }
 
+func TestDiskPersistence_StoreIsAtomic(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "disk_endpoint_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p, err := NewDiskPersistence(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to create new disk persistence: %+v", err)
+	}
+	if err := p.Value("key").Store(testStruct{Value: 10}); err != nil {
+		t.Fatalf("Failed to store value: %+v", err)
+	}
+
+	files, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatalf("error listing directory: %+v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "key.json" {
+		t.Fatalf("expected exactly one file, key.json; got: %+v", files)
+	}
+}
+
+func TestDiskPersistenceWAL_RecoversUncheckpointedMutationsAfterRestart(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "disk_endpoint_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p, err := NewDiskPersistenceWithWAL(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to create new disk persistence: %+v", err)
+	}
+	if err := p.Value("a").Store(testStruct{Value: 1}); err != nil {
+		t.Fatalf("Failed to store value: %+v", err)
+	}
+	if err := p.Value("b").Store(testStruct{Value: 2}); err != nil {
+		t.Fatalf("Failed to store value: %+v", err)
+	}
+	if err := p.Value("b").Remove(); err != nil {
+		t.Fatalf("Failed to remove value: %+v", err)
+	}
+
+	// Below walCheckpointThreshold, so neither a.json nor b.json has been written yet - only the
+	// WAL holds the durable record of these mutations.
+	if files, err := ioutil.ReadDir(tmpdir); err != nil {
+		t.Fatalf("error listing directory: %+v", err)
+	} else if len(files) != 1 || files[0].Name() != walFileName {
+		t.Fatalf("expected only the WAL file to exist before a checkpoint, got: %+v", files)
+	}
+
+	// Simulate a crash-and-restart: construct a fresh Persistence over the same directory without
+	// ever calling Sync or otherwise checkpointing the first instance.
+	restarted, err := NewDiskPersistenceWithWAL(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to reopen disk persistence: %+v", err)
+	}
+
+	var a testStruct
+	if err := restarted.Value("a").Load(&a); err != nil {
+		t.Fatalf("Failed to load value a: %+v", err)
+	}
+	if a.Value != 1 {
+		t.Fatalf("expected recovered value a.Value == 1, got %v", a.Value)
+	}
+
+	var b testStruct
+	if err := restarted.Value("b").Load(&b); err != ErrNotFound {
+		t.Fatalf("expected b to have been removed (ErrNotFound), got: %+v", err)
+	}
+}
+
+func TestDiskPersistenceWAL_SyncCheckpointsToJsonFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "disk_endpoint_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p, err := NewDiskPersistenceWithWAL(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to create new disk persistence: %+v", err)
+	}
+	if err := p.Value("key").Store(testStruct{Value: 10}); err != nil {
+		t.Fatalf("Failed to store value: %+v", err)
+	}
+	if err := p.Sync(); err != nil {
+		t.Fatalf("Failed to sync: %+v", err)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(tmpdir, "key.json"))
+	if err != nil {
+		t.Fatalf("expected key.json to exist after Sync: %+v", err)
+	}
+	var v testStruct
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("error unmarshaling checkpointed file: %+v", err)
+	}
+	if v.Value != 10 {
+		t.Fatalf("expected checkpointed value 10, got %v", v.Value)
+	}
+
+	// A fresh instance should now be able to recover purely from key.json, with an empty WAL.
+	restarted, err := NewDiskPersistenceWithWAL(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to reopen disk persistence: %+v", err)
+	}
+	var actual testStruct
+	if err := restarted.Value("key").Load(&actual); err != nil {
+		t.Fatalf("Failed to load checkpointed value: %+v", err)
+	}
+	if actual.Value != 10 {
+		t.Fatalf("expected loaded value 10, got %v", actual.Value)
+	}
+}
+
 func testBrandNewDiskPersistenceCanStoreAndRetrieve(t *testing.T, tmpdir string, expectedKey string, expectedValue testStruct) (p Persistence) {
 	var actualValue testStruct
 
@@ -0,0 +1,101 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// driver is the durable get/put/delete operations over raw bytes that a driverPersistence backend
+// must implement for a single named value. driverPersistence layers the JSON encoding, ErrNotFound
+// semantics, and locking shared by every backend built on top of a driver.
+//
+// Unlike diskPersistence, a driverPersistence does not cache loaded values in memory: its backing
+// store (in particular Redis) may be mutated by another ubbagent instance between calls, so every
+// Load must go all the way to the driver to stay consistent.
+type driver interface {
+	// get returns the stored bytes for name, and whether name exists.
+	get(name string) ([]byte, bool, error)
+
+	// put durably stores data under name, overwriting any existing value.
+	put(name string, data []byte) error
+
+	// delete removes name, reporting whether it existed.
+	delete(name string) (bool, error)
+}
+
+// driverPersistence is a Persistence implementation backed by a driver, such as BoltDB or Redis.
+type driverPersistence struct {
+	d     driver
+	mutex sync.RWMutex
+}
+
+func newDriverPersistence(d driver) *driverPersistence {
+	return &driverPersistence{d: d}
+}
+
+func (p *driverPersistence) Value(name string) Value {
+	return &lockingValue{&driverValue{p: p, name: name}}
+}
+
+func (p *driverPersistence) Queue(name string) Queue {
+	return &valueQueue{&driverValue{p: p, name: name}}
+}
+
+// Sync is a no-op: every Store and Remove is already durably written to the driver before it
+// returns.
+func (p *driverPersistence) Sync() error {
+	return nil
+}
+
+type driverValue struct {
+	p    *driverPersistence
+	name string
+}
+
+func (v *driverValue) mutex() *sync.RWMutex {
+	return &v.p.mutex
+}
+
+func (v *driverValue) load(obj interface{}) error {
+	data, exists, err := v.p.d.get(v.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, obj)
+}
+
+func (v *driverValue) store(obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return v.p.d.put(v.name, data)
+}
+
+func (v *driverValue) remove() error {
+	existed, err := v.p.d.delete(v.name)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return ErrNotFound
+	}
+	return nil
+}
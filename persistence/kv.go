@@ -0,0 +1,172 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync"
+)
+
+// kvFileName is the name of a kvPersistence's single backing log file.
+const kvFileName = "kv.log"
+
+// kvCompactionThreshold is the number of log entries a kvPersistence accumulates before it
+// compacts: rewriting the log to hold only each touched name's current value. This bounds how
+// large the log - and the replay work at the next startup - can grow.
+const kvCompactionThreshold = 100
+
+// Type kvPersistence is a Persistence implementation that stores every value and queue as entries
+// in a single append-only log file, replayed into an in-memory index at startup. Unlike
+// diskPersistence, whose Store rewrites a whole per-name json file via a
+// temp-file-write/fsync/rename/fsync-dir sequence, a kvPersistence Store is a single sequential
+// append, fsynced before it returns. That gives per-key crash consistency without a full-file
+// rewrite per key, at the cost of periodic compaction and a full log replay at startup.
+type kvPersistence struct {
+	directory string
+	memory    *memoryPersistence
+	wal       *wal
+	mutex     sync.RWMutex
+}
+
+// NewKVPersistence creates a kvPersistence that stores data in a single log file under the given
+// filesystem directory, replaying any existing log into memory before returning.
+func NewKVPersistence(directory string) (Persistence, error) {
+	if err := os.MkdirAll(directory, directoryMode); err != nil {
+		return nil, errors.New("persistence: could not create directory: " + directory + ": " + err.Error())
+	}
+	p := &kvPersistence{directory: directory, memory: newMemoryPersistence()}
+	w, err := openWAL(path.Join(directory, kvFileName))
+	if err != nil {
+		return nil, err
+	}
+	p.wal = w
+	err = w.replay(func(entry walEntry) {
+		switch entry.Op {
+		case walOpStore:
+			p.memory.items[entry.Name] = entry.Data
+		case walOpRemove:
+			delete(p.memory.items, entry.Name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.wal.entries >= kvCompactionThreshold {
+		if err := p.compactLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *kvPersistence) Value(name string) Value {
+	return &lockingValue{&kvValue{p: p, name: name, memValue: &lockingValue{p.memory.value(name)}}}
+}
+
+func (p *kvPersistence) Queue(name string) Queue {
+	return &valueQueue{&kvValue{p: p, name: name, memValue: &lockingValue{p.memory.value(name)}}}
+}
+
+// Sync is a no-op: every Store and Remove is already appended and fsynced to the log before it
+// returns.
+func (p *kvPersistence) Sync() error {
+	return nil
+}
+
+// noteDirtyLocked compacts the log once kvCompactionThreshold entries have accumulated. The
+// caller must hold p.mutex.
+func (p *kvPersistence) noteDirtyLocked() error {
+	if p.wal.entries >= kvCompactionThreshold {
+		return p.compactLocked()
+	}
+	return nil
+}
+
+// compactLocked rewrites the log to hold only the current value of each name in p.memory.items,
+// dropping whatever store/remove history made it obsolete, then reopens the log in append mode.
+// The caller must hold p.mutex.
+func (p *kvPersistence) compactLocked() error {
+	filename := path.Join(p.directory, kvFileName)
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	tmpWAL := &wal{file: f}
+	for name, data := range p.memory.items {
+		if err := tmpWAL.append(walEntry{Op: walOpStore, Name: name, Data: data}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := p.wal.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	if err := syncDir(p.directory); err != nil {
+		return err
+	}
+	reopened, err := openWAL(filename)
+	if err != nil {
+		return err
+	}
+	reopened.entries = len(p.memory.items)
+	p.wal = reopened
+	return nil
+}
+
+type kvValue struct {
+	p        *kvPersistence
+	name     string
+	memValue *lockingValue
+}
+
+func (v *kvValue) mutex() *sync.RWMutex {
+	return &v.p.mutex
+}
+
+// load reads purely from memory: the log is fully replayed into memory before NewKVPersistence
+// returns, so memory is never stale with respect to the log.
+func (v *kvValue) load(obj interface{}) error {
+	return v.memValue.Load(obj)
+}
+
+func (v *kvValue) store(obj interface{}) error {
+	if err := v.memValue.Store(obj); err != nil {
+		return err
+	}
+	data := v.p.memory.items[v.name]
+	if err := v.p.wal.append(walEntry{Op: walOpStore, Name: v.name, Data: data}); err != nil {
+		return err
+	}
+	return v.p.noteDirtyLocked()
+}
+
+func (v *kvValue) remove() error {
+	if err := v.memValue.Remove(); err != nil {
+		return err
+	}
+	if err := v.p.wal.append(walEntry{Op: walOpRemove, Name: v.name}); err != nil {
+		return err
+	}
+	return v.p.noteDirtyLocked()
+}
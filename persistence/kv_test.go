@@ -0,0 +1,136 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestKVPersistence_StoreAndRetrieve(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "kv_persistence_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p, err := NewKVPersistence(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to create new kv persistence: %+v", err)
+	}
+	if err := p.Value("key").Load(&testStruct{}); err != ErrNotFound {
+		t.Fatalf("Expected NotFound error but found %+v", err)
+	}
+	if err := p.Value("key").Store(testStruct{Value: 10}); err != nil {
+		t.Fatalf("Failed to store value: %+v", err)
+	}
+	var actual testStruct
+	if err := p.Value("key").Load(&actual); err != nil {
+		t.Fatalf("Failed to load value: %+v", err)
+	}
+	if actual.Value != 10 {
+		t.Fatalf("expected loaded value 10, got %v", actual.Value)
+	}
+
+	// A single log file, not a per-name file, should back this persistence.
+	files, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		t.Fatalf("error listing directory: %+v", err)
+	}
+	if len(files) != 1 || files[0].Name() != kvFileName {
+		t.Fatalf("expected exactly one file, %v; got: %+v", kvFileName, files)
+	}
+}
+
+func TestKVPersistence_RecoversAfterRestart(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "kv_persistence_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p, err := NewKVPersistence(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to create new kv persistence: %+v", err)
+	}
+	if err := p.Value("a").Store(testStruct{Value: 1}); err != nil {
+		t.Fatalf("Failed to store value: %+v", err)
+	}
+	if err := p.Value("b").Store(testStruct{Value: 2}); err != nil {
+		t.Fatalf("Failed to store value: %+v", err)
+	}
+	if err := p.Value("b").Remove(); err != nil {
+		t.Fatalf("Failed to remove value: %+v", err)
+	}
+
+	// Simulate a crash-and-restart: construct a fresh Persistence over the same directory.
+	restarted, err := NewKVPersistence(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to reopen kv persistence: %+v", err)
+	}
+
+	var a testStruct
+	if err := restarted.Value("a").Load(&a); err != nil {
+		t.Fatalf("Failed to load value a: %+v", err)
+	}
+	if a.Value != 1 {
+		t.Fatalf("expected recovered value a.Value == 1, got %v", a.Value)
+	}
+
+	var b testStruct
+	if err := restarted.Value("b").Load(&b); err != ErrNotFound {
+		t.Fatalf("expected b to have been removed (ErrNotFound), got: %+v", err)
+	}
+}
+
+func TestKVPersistence_CompactsAfterThreshold(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "kv_persistence_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p, err := NewKVPersistence(tmpdir)
+	if err != nil {
+		t.Fatalf("Failed to create new kv persistence: %+v", err)
+	}
+	kv := p.(*kvPersistence)
+	for i := 0; i < kvCompactionThreshold; i++ {
+		if err := p.Value("key").Store(testStruct{Value: i}); err != nil {
+			t.Fatalf("Failed to store value: %+v", err)
+		}
+	}
+	if kv.wal.entries != 1 {
+		t.Fatalf("expected log to have compacted down to 1 entry, got %v", kv.wal.entries)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(tmpdir, kvFileName))
+	if err != nil {
+		t.Fatalf("error reading log file: %+v", err)
+	}
+	if len(data) == 0 {
+		t.Fatalf("expected compacted log file to be non-empty")
+	}
+
+	var actual testStruct
+	if err := p.Value("key").Load(&actual); err != nil {
+		t.Fatalf("Failed to load value after compaction: %+v", err)
+	}
+	if actual.Value != kvCompactionThreshold-1 {
+		t.Fatalf("expected loaded value %v, got %v", kvCompactionThreshold-1, actual.Value)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import "time"
+
+// Lease is a time-bounded, named mutual-exclusion primitive built on a Persistence, used to
+// coordinate which of several ubbagent replicas sharing a persistence backend is currently
+// responsible for an activity - such as flushing a metric's aggregated buckets downstream (see
+// inputs.Aggregator). At most one holder owns a given Lease at a time; ownership expires ttl after
+// the holder's last successful TryAcquire, so another replica can take over if the holder crashes,
+// or is partitioned from the backend, without calling Release.
+type Lease interface {
+	// TryAcquire attempts to acquire, or renew, this Lease as of now for ttl. It returns true if
+	// this Lease's holder owns it after the call - either because it already held an unexpired
+	// lease and renewed it, or because no other holder currently holds an unexpired one - and
+	// false if a different holder's lease is still unexpired.
+	TryAcquire(now time.Time, ttl time.Duration) (bool, error)
+
+	// Release gives up the lease immediately, if this Lease's holder currently owns it, so another
+	// replica doesn't have to wait out the rest of ttl before taking over. It's a no-op otherwise.
+	Release() error
+}
+
+// leaseState is the value a valueLease persists. Generation increases by one on every successful
+// TryAcquire (whether that's a fresh acquisition or a renewal), so a confirming re-Load can tell
+// whether the write it just made is still the most recent one.
+type leaseState struct {
+	Holder     string
+	Expiry     time.Time
+	Generation int64
+}
+
+// valueLease is a Lease implemented on top of a Persistence Value, making it available on every
+// backend this package provides - memory, disk, kv, bolt, redis - without a backend-specific
+// implementation. It isn't linearizable across concurrent acquirers sharing a networked backend
+// like redis: two replicas racing TryAcquire right as a lease expires could both observe the
+// expired state and both write themselves as holder before either's write lands. TryAcquire
+// narrows that window by re-reading the value immediately after writing it and refusing to report
+// success unless its own write is still the one on record; the remaining window - between a
+// replica's Store and its own confirming Load - is small, but callers for whom even a brief double
+// hold is unacceptable (e.g. anything that triggers an irreversible side effect, like forwarding a
+// report downstream) should still treat a Lease as best-effort and deduplicate independently.
+type valueLease struct {
+	value  Value
+	holder string
+}
+
+// NewLease creates a Lease named name - backed by p.Value(name) - for the caller identified as
+// holder. Distinct Lease instances constructed with the same p and name, but different holder
+// IDs, contend for the same underlying lease.
+func NewLease(p Persistence, name string, holder string) Lease {
+	return &valueLease{value: p.Value(name), holder: holder}
+}
+
+func (l *valueLease) TryAcquire(now time.Time, ttl time.Duration) (bool, error) {
+	var s leaseState
+	err := l.value.Load(&s)
+	if err != nil && err != ErrNotFound {
+		return false, err
+	}
+	if err == nil && s.Holder != l.holder && now.Before(s.Expiry) {
+		return false, nil
+	}
+	next := leaseState{
+		Holder:     l.holder,
+		Expiry:     now.Add(ttl),
+		Generation: s.Generation + 1,
+	}
+	if err := l.value.Store(&next); err != nil {
+		return false, err
+	}
+	// Confirm this write is still the one on record. A concurrent TryAcquire from another holder,
+	// racing the Load above, could have stored its own, later generation in between; if so, that
+	// replica - not this one - actually holds the lease.
+	var confirm leaseState
+	if err := l.value.Load(&confirm); err != nil {
+		return false, err
+	}
+	if confirm.Holder != l.holder || confirm.Generation != next.Generation {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (l *valueLease) Release() error {
+	var s leaseState
+	if err := l.value.Load(&s); err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if s.Holder != l.holder {
+		return nil
+	}
+	if err := l.value.Remove(); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLease_TryAcquireGrantsUncontended(t *testing.T) {
+	p := NewMemoryPersistence()
+	l := NewLease(p, "mylease", "holder1")
+
+	ok, err := l.TryAcquire(time.Unix(0, 0), time.Minute)
+	if err != nil {
+		t.Fatalf("TryAcquire: %+v", err)
+	}
+	if !ok {
+		t.Fatal("expected an uncontended TryAcquire to succeed")
+	}
+}
+
+func TestLease_TryAcquireDeniedWhileUnexpired(t *testing.T) {
+	p := NewMemoryPersistence()
+	l1 := NewLease(p, "mylease", "holder1")
+	l2 := NewLease(p, "mylease", "holder2")
+
+	if ok, err := l1.TryAcquire(time.Unix(0, 0), time.Minute); err != nil || !ok {
+		t.Fatalf("TryAcquire(l1) = %v, %+v, want true, nil", ok, err)
+	}
+	ok, err := l2.TryAcquire(time.Unix(0, 0), time.Minute)
+	if err != nil {
+		t.Fatalf("TryAcquire(l2): %+v", err)
+	}
+	if ok {
+		t.Fatal("expected a second holder's TryAcquire to be denied while the first holder's lease is unexpired")
+	}
+}
+
+func TestLease_TryAcquireGrantedAfterExpiry(t *testing.T) {
+	p := NewMemoryPersistence()
+	l1 := NewLease(p, "mylease", "holder1")
+	l2 := NewLease(p, "mylease", "holder2")
+
+	if ok, err := l1.TryAcquire(time.Unix(0, 0), time.Minute); err != nil || !ok {
+		t.Fatalf("TryAcquire(l1) = %v, %+v, want true, nil", ok, err)
+	}
+	ok, err := l2.TryAcquire(time.Unix(0, 0).Add(time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("TryAcquire(l2): %+v", err)
+	}
+	if !ok {
+		t.Fatal("expected a second holder's TryAcquire to succeed once the first holder's lease has expired")
+	}
+}
+
+func TestLease_TryAcquireConfirmsItsOwnWriteIsCurrent(t *testing.T) {
+	p := NewMemoryPersistence()
+	l1 := NewLease(p, "mylease", "holder1")
+
+	if ok, err := l1.TryAcquire(time.Unix(0, 0), time.Minute); err != nil || !ok {
+		t.Fatalf("TryAcquire(l1) = %v, %+v, want true, nil", ok, err)
+	}
+
+	// Simulate a second holder ("holder2") winning a race against l1's renewal: it stores a later
+	// generation directly,
+	// as if its own TryAcquire's Store landed after l1's Load but before l1's confirming re-Load.
+	v := p.Value("mylease")
+	var s leaseState
+	if err := v.Load(&s); err != nil {
+		t.Fatalf("Load: %+v", err)
+	}
+	s.Holder = "holder2"
+	s.Generation++
+	if err := v.Store(&s); err != nil {
+		t.Fatalf("Store: %+v", err)
+	}
+
+	// l1's renewal should now see that holder2's write is the one on record, and refuse to report
+	// itself as the holder even though its own Store earlier in the call didn't error.
+	ok, err := l1.TryAcquire(time.Unix(0, 30), time.Minute)
+	if err != nil {
+		t.Fatalf("TryAcquire(l1) renewal: %+v", err)
+	}
+	if ok {
+		t.Fatal("expected l1's TryAcquire to fail once holder2's write superseded it")
+	}
+}
+
+func TestLease_Release(t *testing.T) {
+	p := NewMemoryPersistence()
+	l1 := NewLease(p, "mylease", "holder1")
+	l2 := NewLease(p, "mylease", "holder2")
+
+	if ok, err := l1.TryAcquire(time.Unix(0, 0), time.Minute); err != nil || !ok {
+		t.Fatalf("TryAcquire(l1) = %v, %+v, want true, nil", ok, err)
+	}
+	if err := l1.Release(); err != nil {
+		t.Fatalf("Release: %+v", err)
+	}
+	ok, err := l2.TryAcquire(time.Unix(0, 0), time.Minute)
+	if err != nil {
+		t.Fatalf("TryAcquire(l2): %+v", err)
+	}
+	if !ok {
+		t.Fatal("expected TryAcquire to succeed immediately after the prior holder released")
+	}
+}
+
+func TestLease_ReleaseIsNoOpForNonHolder(t *testing.T) {
+	p := NewMemoryPersistence()
+	l1 := NewLease(p, "mylease", "holder1")
+	l2 := NewLease(p, "mylease", "holder2")
+
+	if ok, err := l1.TryAcquire(time.Unix(0, 0), time.Minute); err != nil || !ok {
+		t.Fatalf("TryAcquire(l1) = %v, %+v, want true, nil", ok, err)
+	}
+	if err := l2.Release(); err != nil {
+		t.Fatalf("Release(l2): %+v", err)
+	}
+	ok, err := l2.TryAcquire(time.Unix(0, 0), time.Minute)
+	if err != nil {
+		t.Fatalf("TryAcquire(l2): %+v", err)
+	}
+	if ok {
+		t.Fatal("expected l1's lease to still be held after l2's no-op Release")
+	}
+}
@@ -45,6 +45,11 @@ func (p *memoryPersistence) Queue(name string) Queue {
 	return &valueQueue{p.value(name)}
 }
 
+// Sync is a no-op: every Store and Remove is already reflected in memory before it returns.
+func (p *memoryPersistence) Sync() error {
+	return nil
+}
+
 func (p *memoryPersistence) value(name string) *memoryValue {
 	return &memoryValue{p: p, name: name}
 }
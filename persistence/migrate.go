@@ -0,0 +1,49 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import "fmt"
+
+// MigrateMemoryToBolt copies every name currently held by mem into a BoltDB file at path, creating
+// it via NewBoltPersistence, and returns the resulting Persistence. It's meant for a one-time
+// upgrade from an agent that's been running with "memory" persistence (losing its queues and
+// aggregation state on every restart) to "bolt": run it once, with the agent's live
+// memoryPersistence, before switching the config's persistence.type to "bolt" and restarting.
+//
+// mem must be a Persistence returned by NewMemoryPersistence; any other implementation returns an
+// error, since there would be nothing in-process to copy from.
+func MigrateMemoryToBolt(mem Persistence, path string) (Persistence, error) {
+	src, ok := mem.(*memoryPersistence)
+	if !ok {
+		return nil, fmt.Errorf("persistence: MigrateMemoryToBolt: mem is not a memoryPersistence")
+	}
+	dst, err := NewBoltPersistence(path)
+	if err != nil {
+		return nil, err
+	}
+	bolt, ok := dst.(*driverPersistence)
+	if !ok {
+		return nil, fmt.Errorf("persistence: MigrateMemoryToBolt: unexpected bolt Persistence type")
+	}
+
+	src.mutex.RLock()
+	defer src.mutex.RUnlock()
+	for name, data := range src.items {
+		if err := bolt.d.put(name, data); err != nil {
+			return nil, fmt.Errorf("persistence: MigrateMemoryToBolt: copying %v: %w", name, err)
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrateMemoryToBolt(t *testing.T) {
+	mem := NewMemoryPersistence()
+	if err := mem.Value("a").Store("hello"); err != nil {
+		t.Fatalf("Unexpected error storing value: %+v", err)
+	}
+	q := mem.Queue("q")
+	if err := q.Enqueue("one"); err != nil {
+		t.Fatalf("Unexpected error enqueuing: %+v", err)
+	}
+	if err := q.Enqueue("two"); err != nil {
+		t.Fatalf("Unexpected error enqueuing: %+v", err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "migrate_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	bolt, err := MigrateMemoryToBolt(mem, tmpdir+"/bolt.db")
+	if err != nil {
+		t.Fatalf("Unexpected error migrating to bolt: %+v", err)
+	}
+
+	var s string
+	if err := bolt.Value("a").Load(&s); err != nil {
+		t.Fatalf("Unexpected error loading migrated value: %+v", err)
+	}
+	if s != "hello" {
+		t.Fatalf("expected migrated value \"hello\", got %q", s)
+	}
+
+	bq := bolt.Queue("q")
+	n, err := bq.Len()
+	if err != nil {
+		t.Fatalf("Unexpected error getting migrated queue length: %+v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 migrated queue entries, got %v", n)
+	}
+}
+
+func TestMigrateMemoryToBolt_WrongSourceType(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "migrate_test")
+	if err != nil {
+		t.Fatalf("Unable to create temp directory: %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	disk, err := NewDiskPersistence(tmpdir)
+	if err != nil {
+		t.Fatalf("Unexpected error creating DiskPersistence: %+v", err)
+	}
+	if _, err := MigrateMemoryToBolt(disk, tmpdir+"/bolt.db"); err == nil {
+		t.Fatal("expected an error migrating from a non-memory Persistence")
+	}
+}
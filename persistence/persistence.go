@@ -41,6 +41,11 @@ type Persistence interface {
 	// times with the same name and all returned instances will operate on the same data in a
 	// threadsafe manner.
 	Queue(name string) Queue
+
+	// Sync durably flushes any mutation that a prior Store or Remove hasn't yet guaranteed survives
+	// a crash. Implementations that already guarantee this on every Store/Remove - the in-memory
+	// Persistence, and a disk Persistence not in WAL mode - implement Sync as a no-op.
+	Sync() error
 }
 
 // Value stores and loads a single value.
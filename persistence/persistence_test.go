@@ -15,6 +15,7 @@
 package persistence
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -31,24 +32,71 @@ type Inner struct {
 	ValueMap map[string]string
 }
 
-func TestMemoryPersistence(t *testing.T) {
-	p := NewMemoryPersistence()
-	testPersistence(p, t)
-	testQueue(p.Queue("test_queue"), t)
-}
-
-func TestDiskPersistence(t *testing.T) {
-	tmpdir, err := ioutil.TempDir("", "persistence_test")
-	if err != nil {
-		t.Fatalf("Unable to create temp directory: %+v", err)
+// TestPersistenceDrivers runs testPersistence and testQueue against every Persistence
+// implementation this package provides, so each exercises exactly the same suite.
+func TestPersistenceDrivers(t *testing.T) {
+	drivers := []struct {
+		name string
+		new  func(t *testing.T) Persistence
+	}{
+		{"memory", func(t *testing.T) Persistence {
+			return NewMemoryPersistence()
+		}},
+		{"disk", func(t *testing.T) Persistence {
+			tmpdir, err := ioutil.TempDir("", "persistence_test")
+			if err != nil {
+				t.Fatalf("Unable to create temp directory: %+v", err)
+			}
+			t.Cleanup(func() { os.RemoveAll(tmpdir) })
+			p, err := NewDiskPersistence(tmpdir)
+			if err != nil {
+				t.Fatalf("Unexpected error creating DiskPersistence: %+v", err)
+			}
+			return p
+		}},
+		{"kv", func(t *testing.T) Persistence {
+			tmpdir, err := ioutil.TempDir("", "persistence_test")
+			if err != nil {
+				t.Fatalf("Unable to create temp directory: %+v", err)
+			}
+			t.Cleanup(func() { os.RemoveAll(tmpdir) })
+			p, err := NewKVPersistence(tmpdir)
+			if err != nil {
+				t.Fatalf("Unexpected error creating KVPersistence: %+v", err)
+			}
+			return p
+		}},
+		{"bolt", func(t *testing.T) Persistence {
+			tmpdir, err := ioutil.TempDir("", "persistence_test")
+			if err != nil {
+				t.Fatalf("Unable to create temp directory: %+v", err)
+			}
+			t.Cleanup(func() { os.RemoveAll(tmpdir) })
+			p, err := NewBoltPersistence(tmpdir + "/bolt.db")
+			if err != nil {
+				t.Fatalf("Unexpected error creating BoltPersistence: %+v", err)
+			}
+			return p
+		}},
+		{"redis", func(t *testing.T) Persistence {
+			p, err := NewRedisPersistence("127.0.0.1:6379")
+			if err != nil {
+				t.Skipf("no Redis server available at 127.0.0.1:6379, skipping: %v", err)
+			}
+			for _, name := range []string{"test/input1", "test/input2", "test_queue"} {
+				p.Value(name).Remove()
+			}
+			return p
+		}},
 	}
-	defer os.RemoveAll(tmpdir)
-	p, err := NewDiskPersistence(tmpdir)
-	if err != nil {
-		t.Fatalf("Unexpected error creating DiskPersistence: %+v", err)
+
+	for _, d := range drivers {
+		t.Run(d.name, func(t *testing.T) {
+			p := d.new(t)
+			testPersistence(p, t)
+			testQueue(p.Queue("test_queue"), t)
+		})
 	}
-	testPersistence(p, t)
-	testQueue(p.Queue("test_queue"), t)
 }
 
 func testPersistence(p Persistence, t *testing.T) {
@@ -212,4 +260,103 @@ func testQueue(q Queue, t *testing.T) {
 	if err := q.Peek(&v); err != ErrNotFound {
 		t.Fatalf("Expected ErrNotFound, got %+v", err)
 	}
+	if n, err := q.Len(); err != nil || n != 0 {
+		t.Fatalf("Expected empty queue to have length 0, got %v, %+v", n, err)
+	}
+
+	testQueueBatch(q, t)
+}
+
+// testQueueBatch exercises PeekBatch, DequeueN, EnqueueWithLimit, Len, and RangeUnderLock against
+// an empty Queue.
+func testQueueBatch(q Queue, t *testing.T) {
+	type value struct {
+		A int
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := q.Enqueue(&value{A: i}); err != nil {
+			t.Fatalf("Unexpected error adding queue value %v: %+v", i, err)
+		}
+	}
+	if n, err := q.Len(); err != nil || n != 3 {
+		t.Fatalf("Expected length 3, got %v, %+v", n, err)
+	}
+
+	var batch []value
+	n, err := q.PeekBatch(2, &batch)
+	if err != nil {
+		t.Fatalf("Unexpected error from PeekBatch: %+v", err)
+	}
+	if n != 2 || !reflect.DeepEqual(batch, []value{{A: 1}, {A: 2}}) {
+		t.Fatalf("Unexpected PeekBatch result: n=%v, batch=%+v", n, batch)
+	}
+
+	// max larger than the queue should return every entry.
+	var all []value
+	n, err = q.PeekBatch(10, &all)
+	if err != nil {
+		t.Fatalf("Unexpected error from PeekBatch: %+v", err)
+	}
+	if n != 3 || !reflect.DeepEqual(all, []value{{A: 1}, {A: 2}, {A: 3}}) {
+		t.Fatalf("Unexpected PeekBatch result: n=%v, all=%+v", n, all)
+	}
+
+	var seen []value
+	if err := q.RangeUnderLock(func(raw json.RawMessage) error {
+		var v value
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		seen = append(seen, v)
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error from RangeUnderLock: %+v", err)
+	}
+	if !reflect.DeepEqual(seen, []value{{A: 1}, {A: 2}, {A: 3}}) {
+		t.Fatalf("Unexpected RangeUnderLock result: %+v", seen)
+	}
+
+	if err := q.DequeueN(2); err != nil {
+		t.Fatalf("Unexpected error from DequeueN: %+v", err)
+	}
+	if n, err := q.Len(); err != nil || n != 1 {
+		t.Fatalf("Expected length 1 after DequeueN(2), got %v, %+v", n, err)
+	}
+
+	// DequeueN with n greater than the remaining length removes everything.
+	if err := q.Enqueue(&value{A: 4}); err != nil {
+		t.Fatalf("Unexpected error adding queue value 4: %+v", err)
+	}
+	if err := q.DequeueN(10); err != nil {
+		t.Fatalf("Unexpected error from DequeueN: %+v", err)
+	}
+	if n, err := q.Len(); err != nil || n != 0 {
+		t.Fatalf("Expected empty queue after DequeueN(10), got %v, %+v", n, err)
+	}
+	if err := q.DequeueN(1); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %+v", err)
+	}
+	if _, err := q.PeekBatch(1, &[]value{}); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %+v", err)
+	}
+
+	// EnqueueWithLimit respects maxItems and maxBytes.
+	if err := q.EnqueueWithLimit(&value{A: 1}, 1, 0); err != nil {
+		t.Fatalf("Unexpected error from EnqueueWithLimit: %+v", err)
+	}
+	err = q.EnqueueWithLimit(&value{A: 2}, 1, 0)
+	if _, ok := err.(*ErrQueueFull); !ok {
+		t.Fatalf("Expected *ErrQueueFull from EnqueueWithLimit over maxItems, got %+v", err)
+	}
+	if err := q.DequeueN(1); err != nil {
+		t.Fatalf("Unexpected error from DequeueN: %+v", err)
+	}
+	err = q.EnqueueWithLimit(&value{A: 1}, 0, 1)
+	if _, ok := err.(*ErrQueueFull); !ok {
+		t.Fatalf("Expected *ErrQueueFull from EnqueueWithLimit over maxBytes, got %+v", err)
+	}
+	if err := q.DequeueN(10); err != nil && err != ErrNotFound {
+		t.Fatalf("Unexpected error from DequeueN: %+v", err)
+	}
 }
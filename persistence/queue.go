@@ -16,6 +16,7 @@ package persistence
 
 import (
 	"encoding/json"
+	"fmt"
 )
 
 // Queue implements a simple persistent queue. Each Queue function is threadsafe and atomic within
@@ -36,6 +37,49 @@ type Queue interface {
 	// Enqueue stores obj at the back of this Queue. Returns nil if the object was stored, or an error
 	// if something failed.
 	Enqueue(obj interface{}) error
+
+	// EnqueueWithLimit is equivalent to Enqueue, but first checks obj against maxItems and maxBytes:
+	// if storing obj would bring this Queue's entry count above maxItems, or its total serialized
+	// size above maxBytes, a *ErrQueueFull is returned and obj is not stored. A zero maxItems or
+	// maxBytes disables that particular limit.
+	EnqueueWithLimit(obj interface{}, maxItems int, maxBytes int64) error
+
+	// PeekBatch loads up to max entries from the front of this Queue into out, which must be a
+	// pointer to a slice. It returns the number of entries loaded. ErrNotFound is returned if the
+	// queue is empty or does not exist.
+	PeekBatch(max int, out interface{}) (int, error)
+
+	// DequeueN removes up to n entries from the front of this Queue. If the Queue holds fewer than n
+	// entries, all of them are removed. ErrNotFound is returned if the queue is empty or does not
+	// exist.
+	DequeueN(n int) error
+
+	// Len returns the number of entries currently in this Queue. A nonexistent queue has a length
+	// of 0.
+	Len() (int, error)
+
+	// RangeUnderLock calls fn once for each entry currently in this Queue, front to back, holding the
+	// Queue's read lock for the duration of the call. Iteration stops at the first error returned by
+	// fn, which RangeUnderLock then returns.
+	RangeUnderLock(fn func(raw json.RawMessage) error) error
+
+	// Compact loads this Queue's entire backing array and replaces it with the result of calling fn
+	// on it, all under the Queue's write lock. It's intended for maintenance operations - such as
+	// merging adjacent entries - that need to rewrite the queue's contents wholesale rather than one
+	// entry at a time. ErrNotFound is returned, without calling fn, if the queue is empty or does not
+	// exist.
+	Compact(fn func(entries []json.RawMessage) ([]json.RawMessage, error)) error
+}
+
+// ErrQueueFull is returned by Queue.EnqueueWithLimit when storing an entry would exceed the
+// caller-supplied maxItems or maxBytes limit.
+type ErrQueueFull struct {
+	MaxItems int
+	MaxBytes int64
+}
+
+func (e *ErrQueueFull) Error() string {
+	return fmt.Sprintf("persistence: queue full (maxItems=%d, maxBytes=%d)", e.MaxItems, e.MaxBytes)
 }
 
 // Type valueQueue is a Queue that stores its state within a single value. Queue state is stored as
@@ -119,3 +163,127 @@ func (vq *valueQueue) Enqueue(obj interface{}) error {
 	}
 	return nil
 }
+
+func (vq *valueQueue) EnqueueWithLimit(obj interface{}, maxItems int, maxBytes int64) error {
+	var queue []json.RawMessage
+	var err error
+	var bytes []byte
+	if bytes, err = json.Marshal(obj); err != nil {
+		return err
+	}
+	vq.value.mutex().Lock()
+	defer vq.value.mutex().Unlock()
+	if err = vq.value.load(&queue); err != nil && err != ErrNotFound {
+		return err
+	}
+	if maxItems > 0 && len(queue)+1 > maxItems {
+		return &ErrQueueFull{MaxItems: maxItems, MaxBytes: maxBytes}
+	}
+	if maxBytes > 0 {
+		var total int64
+		for _, raw := range queue {
+			total += int64(len(raw))
+		}
+		if total+int64(len(bytes)) > maxBytes {
+			return &ErrQueueFull{MaxItems: maxItems, MaxBytes: maxBytes}
+		}
+	}
+	queue = append(queue, bytes)
+	return vq.value.store(queue)
+}
+
+func (vq *valueQueue) PeekBatch(max int, out interface{}) (int, error) {
+	var queue []json.RawMessage
+	vq.value.mutex().RLock()
+	err := vq.value.load(&queue)
+	vq.value.mutex().RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	if len(queue) == 0 {
+		return 0, ErrNotFound
+	}
+	if max > 0 && max < len(queue) {
+		queue = queue[:max]
+	}
+	batch, err := json.Marshal(queue)
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(batch, out); err != nil {
+		return 0, err
+	}
+	return len(queue), nil
+}
+
+func (vq *valueQueue) DequeueN(n int) error {
+	var queue []json.RawMessage
+	vq.value.mutex().Lock()
+	defer vq.value.mutex().Unlock()
+	if err := vq.value.load(&queue); err != nil {
+		return err
+	}
+	if len(queue) == 0 {
+		return ErrNotFound
+	}
+	if n >= len(queue) {
+		return vq.value.remove()
+	}
+	return vq.value.store(queue[n:])
+}
+
+func (vq *valueQueue) Len() (int, error) {
+	var queue []json.RawMessage
+	vq.value.mutex().RLock()
+	err := vq.value.load(&queue)
+	vq.value.mutex().RUnlock()
+	if err != nil {
+		if err == ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return len(queue), nil
+}
+
+// RangeUnderLock calls fn once for each entry in this Queue's backing array, front to back, while
+// holding the Queue's read lock. See Queue.RangeUnderLock.
+func (vq *valueQueue) RangeUnderLock(fn func(raw json.RawMessage) error) error {
+	var queue []json.RawMessage
+	vq.value.mutex().RLock()
+	defer vq.value.mutex().RUnlock()
+	if err := vq.value.load(&queue); err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	for _, raw := range queue {
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact loads this Queue's backing array, passes it to fn, and stores the result in its place.
+// See Queue.Compact.
+func (vq *valueQueue) Compact(fn func(entries []json.RawMessage) ([]json.RawMessage, error)) error {
+	var queue []json.RawMessage
+	vq.value.mutex().Lock()
+	defer vq.value.mutex().Unlock()
+	if err := vq.value.load(&queue); err != nil {
+		return err
+	}
+	if len(queue) == 0 {
+		return ErrNotFound
+	}
+	newq, err := fn(queue)
+	if err != nil {
+		return err
+	}
+	if len(newq) == 0 {
+		return vq.value.remove()
+	}
+	return vq.value.store(newq)
+}
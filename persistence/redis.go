@@ -0,0 +1,64 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisPersistence creates a Persistence backed by a Redis server at addr ("host:port"),
+// storing each name as its own Redis key. Unlike the other backends, its durable state is shared
+// by every ubbagent instance pointed at the same server, so it suits a horizontally scaled
+// deployment behind a load balancer: any instance can pick up aggregation state another instance
+// started.
+func NewRedisPersistence(addr string) (Persistence, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return newDriverPersistence(&redisDriver{client: client, ctx: ctx}), nil
+}
+
+type redisDriver struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+func (d *redisDriver) get(name string) ([]byte, bool, error) {
+	data, err := d.client.Get(d.ctx, name).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (d *redisDriver) put(name string, data []byte) error {
+	return d.client.Set(d.ctx, name, data, 0).Err()
+}
+
+func (d *redisDriver) delete(name string) (bool, error) {
+	n, err := d.client.Del(d.ctx, name).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+const walFileName = "wal.log"
+
+// walOp identifies the kind of mutation a walEntry records.
+type walOp string
+
+const (
+	walOpStore  walOp = "store"
+	walOpRemove walOp = "remove"
+)
+
+// walEntry is a single record appended to the write-ahead log: enough to replay a Value's store
+// or remove against recovered memory state after a crash.
+type walEntry struct {
+	Op   walOp           `json:"op"`
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// wal is an append-only, length-prefixed log of value mutations. A diskPersistence in WAL mode
+// appends a walEntry here, and fsyncs the log, before a Store or Remove returns - so the mutation
+// is guaranteed durable even though it's applied to the value's per-name json file only later, at
+// the next checkpoint.
+type wal struct {
+	file    *os.File
+	entries int
+}
+
+// openWAL opens (creating if necessary) the write-ahead log at path.
+func openWAL(path string) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, fileMode)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{file: f}, nil
+}
+
+// append writes entry to the log and fsyncs it.
+func (w *wal) append(entry walEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	var lenbuf [4]byte
+	binary.BigEndian.PutUint32(lenbuf[:], uint32(len(data)))
+	if _, err := w.file.Write(lenbuf[:]); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(data); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.entries++
+	return nil
+}
+
+// replay reads every entry written to the log, in order, calling apply for each. It's intended to
+// be called once at startup, before the log is appended to again.
+func (w *wal) replay(apply func(entry walEntry)) error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(w.file)
+	for {
+		var lenbuf [4]byte
+		if _, err := io.ReadFull(r, lenbuf[:]); err != nil {
+			// A missing or partial trailing record means the process crashed mid-append; the log's
+			// fsync-before-return contract means nothing durable was lost, so it's simply dropped.
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(lenbuf[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		var entry walEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		apply(entry)
+	}
+	_, err := w.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// truncate resets the log to empty. It's called once a checkpoint has durably written every
+// touched name's current state to its per-name json file, making the log's entries redundant.
+func (w *wal) truncate() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.entries = 0
+	return nil
+}
@@ -15,104 +15,569 @@
 package builder
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/GoogleCloudPlatform/ubbagent/agentid"
+	"github.com/GoogleCloudPlatform/ubbagent/auditlog"
 	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/deadletter"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/azuremarketplace"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/azuremonitor"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/cloudevents"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/cloudwatch"
 	"github.com/GoogleCloudPlatform/ubbagent/endpoint/disk"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/otlp"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/prometheus"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/promremotewrite"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/pubsub"
 	"github.com/GoogleCloudPlatform/ubbagent/endpoint/servicecontrol"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/stackdriver"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoint/structuredlog"
+	"github.com/GoogleCloudPlatform/ubbagent/endpoints"
+	"github.com/GoogleCloudPlatform/ubbagent/filter"
+	"github.com/GoogleCloudPlatform/ubbagent/gcemetadata"
 	"github.com/GoogleCloudPlatform/ubbagent/persistence"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline/inputs"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline/senders"
-	"github.com/GoogleCloudPlatform/ubbagent/sources"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline/sources"
 	"github.com/GoogleCloudPlatform/ubbagent/stats"
+	"github.com/GoogleCloudPlatform/ubbagent/tracing"
+	"github.com/golang/glog"
 	"github.com/hashicorp/go-multierror"
 )
 
-// Build builds pipeline containing a configured Aggregator and all of the resources
+// init registers every endpoint and source kind ubbagent ships with against the endpoints and
+// sources packages' registries, the same way a third party registers a proprietary kind of its
+// own. createEndpoint and Rebuild's source-construction loop look factories up by kind rather than
+// switching on them directly, so this is the only place that needs to know the full built-in set.
+func init() {
+	endpoints.MustRegister("disk", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return disk.NewDiskEndpoint(
+			cfgep.Name,
+			cfgep.Disk.ReportDir,
+			time.Duration(cfgep.Disk.ExpireSeconds)*time.Second,
+			cfgep.Disk.Format,
+			cfgep.Disk.Rotation,
+			diskRotationIdentity(cfg, cfgep.Disk.Rotation),
+		)
+	})
+	endpoints.MustRegister("servicecontrol", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		gcp, err := cfg.Identities.ResolveGCP(cfgep.ServiceControl.Identity)
+		if err != nil {
+			return nil, err
+		}
+		return servicecontrol.NewServiceControlEndpoint(
+			cfgep.Name,
+			cfgep.ServiceControl.ServiceName,
+			agentId,
+			cfgep.ServiceControl.ConsumerId,
+			cfgep.ServiceControl.UserLabels,
+			gcp,
+			cfgep.ServiceControl.MaxQPS,
+			cfgep.ServiceControl.Backoff,
+			cfgep.ServiceControl.Transport,
+		)
+	})
+	endpoints.MustRegister("azureMarketplace", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return azuremarketplace.NewAzureMarketplaceEndpoint(
+			cfgep.Name,
+			cfg.Identities.Get(cfgep.AzureMarketplace.Identity).Azure,
+			cfgep.AzureMarketplace.PlanId,
+			cfgep.AzureMarketplace.Backoff,
+		), nil
+	})
+	endpoints.MustRegister("otlp", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return otlp.NewOTLPEndpoint(cfgep.Name, *cfgep.OTLP)
+	})
+	endpoints.MustRegister("cloudEvents", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return cloudevents.NewCloudEventsEndpoint(cfgep.Name, *cfgep.CloudEvents), nil
+	})
+	endpoints.MustRegister("promRemoteWrite", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return promremotewrite.NewPromRemoteWriteEndpoint(cfgep.Name, *cfgep.PromRemoteWrite)
+	})
+	endpoints.MustRegister("prometheus", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return prometheus.NewPrometheusEndpoint(cfgep.Name, *cfgep.Prometheus), nil
+	})
+	endpoints.MustRegister("stackdriver", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		gcp, err := cfg.Identities.ResolveGCP(cfgep.Stackdriver.Identity)
+		if err != nil {
+			return nil, err
+		}
+		return stackdriver.NewStackdriverEndpoint(
+			cfgep.Name,
+			*cfgep.Stackdriver,
+			gcp,
+		)
+	})
+	endpoints.MustRegister("cloudWatch", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return cloudwatch.NewCloudWatchEndpoint(
+			cfgep.Name,
+			*cfgep.CloudWatch,
+			cfg.Identities.Get(cfgep.CloudWatch.Identity).AWS,
+		), nil
+	})
+	endpoints.MustRegister("azureMonitor", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return azuremonitor.NewAzureMonitorEndpoint(
+			cfgep.Name,
+			*cfgep.AzureMonitor,
+			cfg.Identities.Get(cfgep.AzureMonitor.Identity).Azure,
+		), nil
+	})
+	endpoints.MustRegister("structuredLog", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		return structuredlog.NewStructuredLogEndpoint(cfgep.Name, *cfgep.StructuredLog)
+	})
+	endpoints.MustRegister("pubsub", func(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+		gcp, err := cfg.Identities.ResolveGCP(cfgep.PubSub.Identity)
+		if err != nil {
+			return nil, err
+		}
+		return pubsub.NewPubSubEndpoint(
+			cfgep.Name,
+			*cfgep.PubSub,
+			gcp,
+		)
+	})
+
+	sources.MustRegister("heartbeat", func(cfg *config.Config, src *config.Source, selector pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+		return sources.NewHeartbeat(*src.Heartbeat, selector), nil
+	})
+	sources.MustRegister("prometheusScrape", func(cfg *config.Config, src *config.Source, selector pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+		return sources.NewPrometheusScrape(*src.PrometheusScrape, selector)
+	})
+	sources.MustRegister("scheduled", func(cfg *config.Config, src *config.Source, selector pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+		return sources.NewScheduled(*src.Scheduled, selector)
+	})
+	sources.MustRegister("cloudEvents", func(cfg *config.Config, src *config.Source, selector pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+		return sources.NewCloudEventsSource(*src.CloudEvents, selector, p)
+	})
+	sources.MustRegister("statsd", func(cfg *config.Config, src *config.Source, selector pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+		return sources.NewStatsdSource(*src.Statsd, selector)
+	})
+	sources.MustRegister("pubSub", func(cfg *config.Config, src *config.Source, selector pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+		gcp, err := cfg.Identities.ResolveGCP(src.PubSub.Identity)
+		if err != nil {
+			return nil, err
+		}
+		return sources.NewPubSubSource(*src.PubSub, selector, gcp)
+	})
+}
+
+// sourceShutdownTimeout bounds how long Build's returned Input waits for sources to drain during
+// shutdown before abandoning them.
+const sourceShutdownTimeout = 10 * time.Second
+
+// autodetectTimeout bounds how long Rebuild waits for the GCE metadata server to respond when
+// Config.Autodetect.GCE is enabled, so a non-GCE environment adds only a bounded delay to startup.
+const autodetectTimeout = 3 * time.Second
+
+// Built is the result of Build or Rebuild: the pipeline's top-level Input, plus the bookkeeping
+// Rebuild needs to decide which endpoints a later reload can carry over unchanged.
+type Built struct {
+	// Input is the pipeline's entry point, as returned by Build.
+	Input pipeline.Input
+
+	// Autodetected holds the result of GCE metadata autodetection, if Config.Autodetect.GCE was
+	// enabled and detection succeeded. It's the zero gcemetadata.Info otherwise.
+	Autodetected gcemetadata.Info
+
+	// cfg is the Config this Built was built from. A later Rebuild passes it to a reused sender's
+	// pipeline.Reconfigurable.ApplyConfig, if it implements that interface, as the "old" config.
+	cfg *config.Config
+
+	endpoints map[string]builtEndpoint
+}
+
+// builtEndpoint remembers the config.Endpoint.Hash a pipeline.Sender was built from, so a later
+// Rebuild can tell whether it's still current, along with the Hash computed with the endpoint's
+// RetryPolicy excluded (see config.Endpoint.HashWithoutRetry), so Rebuild can tell a RetryPolicy-only
+// change apart from one requiring a full rebuild.
+type builtEndpoint struct {
+	hash        string
+	hashNoRetry string
+	sender      pipeline.Sender
+}
+
+// Build builds a pipeline containing a configured Aggregator and all of the resources
 // (persistence, endpoints) behind it. It returns the pipeline.Input.
-func Build(cfg *config.Config, p persistence.Persistence, r stats.Recorder) (pipeline.Input, error) {
-	agentId, err := agentid.CreateOrGet(p)
+func Build(cfg *config.Config, p persistence.Persistence, r stats.Recorder, al auditlog.AuditLog) (pipeline.Input, error) {
+	built, err := Rebuild(nil, cfg, p, r, al)
 	if err != nil {
 		return nil, err
 	}
-	endpoints, err := createEndpoints(cfg, agentId)
+	return built.Input, nil
+}
+
+// Rebuild builds a pipeline from cfg, like Build, but reuses prev's already-constructed senders -
+// along with their in-flight buffers and disk-persisted retry queues - for any endpoint whose name
+// and config.Endpoint.Hash are unchanged from prev's config. prev may be nil, in which case Rebuild
+// behaves exactly like Build.
+//
+// Once the returned Built is in place, the caller should Release prev.Input. Because a reused
+// endpoint's sender is re-Use()'d by the new pipeline before that happens, pipeline.Component's
+// reference counting ensures the Release only tears down endpoints that were actually removed or
+// changed, leaving carried-over endpoints running undisturbed.
+func Rebuild(prev *Built, cfg *config.Config, p persistence.Persistence, r stats.Recorder, al auditlog.AuditLog) (*Built, error) {
+	var detected gcemetadata.Info
+	if cfg.Autodetect != nil && cfg.Autodetect.GCE {
+		if info, ok := gcemetadata.Detect(autodetectTimeout); ok {
+			detected = info
+			applyAutodetectedDefaults(cfg, detected)
+		} else {
+			glog.Warningf("builder: GCE metadata autodetect did not complete; continuing with static configuration")
+		}
+	}
+
+	agentId, err := agentid.CreateOrGet(p, detected.InstanceId)
 	if err != nil {
 		return nil, err
 	}
+
 	endpointSenders := make(map[string]pipeline.Sender)
-	for i := range endpoints {
-		endpointSenders[endpoints[i].Name()] = senders.NewRetryingSender(endpoints[i], p, r)
+	builtEndpoints := make(map[string]builtEndpoint)
+	for i := range cfg.Endpoints {
+		cfgep := &cfg.Endpoints[i]
+		hash, err := cfgep.Hash()
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %v: %v", cfgep.Name, err)
+		}
+		hashNoRetry, err := cfgep.HashWithoutRetry()
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %v: %v", cfgep.Name, err)
+		}
+		if prev != nil {
+			if existing, ok := prev.endpoints[cfgep.Name]; ok {
+				if existing.hash == hash {
+					existing.sender.Use()
+					endpointSenders[cfgep.Name] = existing.sender
+					builtEndpoints[cfgep.Name] = existing
+					continue
+				}
+				if existing.hashNoRetry == hashNoRetry && prev.cfg != nil {
+					if rc, ok := existing.sender.(pipeline.Reconfigurable); ok {
+						if err := rc.ApplyConfig(prev.cfg, cfg); err == nil {
+							existing.sender.Use()
+							endpointSenders[cfgep.Name] = existing.sender
+							builtEndpoints[cfgep.Name] = builtEndpoint{hash: hash, hashNoRetry: hashNoRetry, sender: existing.sender}
+							continue
+						}
+						glog.Warningf("builder: endpoint %v: applying retry policy change in place failed, rebuilding: %v", cfgep.Name, err)
+					}
+				}
+			}
+		}
+		sender, err := buildEndpointSender(cfg, cfgep, agentId, p, r, al)
+		if err != nil {
+			return nil, err
+		}
+		endpointSenders[cfgep.Name] = sender
+		builtEndpoints[cfgep.Name] = builtEndpoint{hash: hash, hashNoRetry: hashNoRetry, sender: sender}
 	}
 
 	// Inputs for the resultant Selector.
 	selectorInputs := make(map[string]pipeline.Input)
 	for _, metric := range cfg.Metrics {
-		var msenders []pipeline.Sender
-		for _, me := range metric.Endpoints {
-			msenders = append(msenders, endpointSenders[me.Name])
+		msenders, err := metricSenders(&metric, cfg, endpointSenders, agentId, p, r, al)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := senders.NewDispatchPolicyForMetric(&metric)
+		if err != nil {
+			return nil, fmt.Errorf("metric %v: %v", metric.Name, err)
 		}
-		di := &pipeline.InputAdapter{Sender: senders.NewDispatcher(msenders, r)}
+		var sender pipeline.Sender = senders.NewDispatcher(msenders, p, r, policy)
+		chain, err := filter.NewChain(cfg.Filters, metric.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("metric %v: %v", metric.Name, err)
+		}
+		if len(chain) > 0 {
+			sender = senders.NewFilteringSender(sender, chain)
+		}
+		di := &pipeline.InputAdapter{Sender: sender}
 		if metric.Aggregation != nil {
 			bufferTime := time.Duration(metric.Aggregation.BufferSeconds) * time.Second
-			selectorInputs[metric.Name] = inputs.NewAggregator(metric.Definition, bufferTime, di, p)
+			maxPersistInterval := time.Duration(metric.Aggregation.MaxPersistIntervalSeconds) * time.Second
+			latenessTolerance := time.Duration(metric.Aggregation.LatenessSeconds) * time.Second
+			selectorInputs[metric.Name] = inputs.NewAggregator(metric.Definition, bufferTime, metric.Aggregation.MaxBucketEntries, maxPersistInterval, latenessTolerance, metric.Aggregation.OnTimeConflict, metric.Aggregation.TenantLabel, di, p, r, nil)
 		} else if metric.Passthrough != nil {
 			selectorInputs[metric.Name] = di
 		}
 	}
-	selector := inputs.NewSelector(selectorInputs)
+	// Building an Input that routes incoming reports to the correct per-metric pipeline: a Router
+	// with one rule per configured Route if any are defined, or a plain by-name Selector otherwise.
+	var selector pipeline.Input
+	if len(cfg.Routes) > 0 {
+		rules := make([]inputs.RouteRule, len(cfg.Routes))
+		for i, route := range cfg.Routes {
+			targetNames := route.TargetMetrics()
+			targets := make([]pipeline.Input, len(targetNames))
+			for j, name := range targetNames {
+				targets[j] = selectorInputs[name]
+			}
+			rules[i] = inputs.RouteRule{
+				MetricPattern: route.MetricPattern,
+				MatchType:     route.MatchType,
+				Labels:        route.Labels,
+				Targets:       targets,
+				Stop:          route.Stop,
+			}
+		}
+		router, err := inputs.NewRouter(rules, nil)
+		if err != nil {
+			return nil, err
+		}
+		selector = router
+	} else {
+		selector = inputs.NewSelector(selectorInputs)
+	}
 
 	// Defined metric sources.
 	var sourcesList []pipeline.Source
-	for _, src := range cfg.Sources {
-		if src.Heartbeat != nil {
-			sourcesList = append(sourcesList, sources.NewHeartbeat(*src.Heartbeat, selector))
+	for i := range cfg.Sources {
+		src := &cfg.Sources[i]
+		kind, err := sourceKind(src)
+		if err != nil {
+			return nil, fmt.Errorf("source %v: %v", src.Name, err)
+		}
+		factory, ok := sources.Get(kind)
+		if !ok {
+			return nil, fmt.Errorf("source %v: unsupported kind: %v", src.Name, kind)
+		}
+		s, err := factory(cfg, src, selector, p)
+		if err != nil {
+			return nil, err
 		}
+		sourcesList = append(sourcesList, s)
 	}
 
 	cb := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), sourceShutdownTimeout)
+		defer cancel()
 		var err *multierror.Error
 		for _, src := range sourcesList {
-			err = multierror.Append(err, src.Shutdown())
+			err = multierror.Append(err, src.ShutdownContext(ctx))
 		}
 		return err.ErrorOrNil()
 	}
 
-	return inputs.NewCallbackInput(selector, cb), nil
+	return &Built{
+		Input:        inputs.NewCallbackInput(selector, cb),
+		Autodetected: detected,
+		cfg:          cfg,
+		endpoints:    builtEndpoints,
+	}, nil
 }
 
-func createEndpoints(config *config.Config, agentId string) ([]pipeline.Endpoint, error) {
-	var eps []pipeline.Endpoint
-	for _, cfgep := range config.Endpoints {
-		ep, err := createEndpoint(config, &cfgep, agentId)
-		if err != nil {
-			// TODO(volkman): close already-created endpoints in event of error?
-			return nil, err
+// applyAutodetectedDefaults fills in any ServiceControlEndpoint config left blank by the user with
+// values discovered from the GCE metadata server: an empty ConsumerId defaults to info's project,
+// and UserLabels gains an instance_id/zone/cluster_name/cluster_location entry for anything info
+// found that isn't already set. It has no effect on an endpoint whose fields are already populated.
+func applyAutodetectedDefaults(cfg *config.Config, info gcemetadata.Info) {
+	for i := range cfg.Endpoints {
+		sc := cfg.Endpoints[i].ServiceControl
+		if sc == nil {
+			continue
+		}
+		if sc.ConsumerId == "" && info.ProjectId != "" {
+			sc.ConsumerId = "project:" + info.ProjectId
 		}
-		eps = append(eps, ep)
+		if sc.UserLabels == nil {
+			sc.UserLabels = make(map[string]string)
+		}
+		setIfAbsent(sc.UserLabels, "instance_id", info.InstanceId)
+		setIfAbsent(sc.UserLabels, "zone", info.Zone)
+		setIfAbsent(sc.UserLabels, "cluster_name", info.ClusterName)
+		setIfAbsent(sc.UserLabels, "cluster_location", info.ClusterLocation)
 	}
-	return eps, nil
 }
 
-func createEndpoint(config *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
-	if cfgep.Disk != nil {
-		return disk.NewDiskEndpoint(
-			cfgep.Name,
-			cfgep.Disk.ReportDir,
-			time.Duration(cfgep.Disk.ExpireSeconds)*time.Second,
-		), nil
+// diskRotationIdentity returns the identity a disk endpoint's rotation upload should authenticate
+// with, or nil if rotation is unconfigured or its Destination doesn't name one (an http(s)://
+// Destination needs no identity at all).
+func diskRotationIdentity(cfg *config.Config, rotation *config.DiskRotation) *config.Identity {
+	if rotation == nil || rotation.Identity == "" {
+		return nil
 	}
-	if cfgep.ServiceControl != nil {
-		return servicecontrol.NewServiceControlEndpoint(
-			cfgep.Name,
-			cfgep.ServiceControl.ServiceName,
-			agentId,
-			cfgep.ServiceControl.ConsumerId,
-			config.Identities.Get(cfgep.ServiceControl.Identity).GCP.GetServiceAccountKey(),
-		)
+	return cfg.Identities.Get(rotation.Identity)
+}
+
+func setIfAbsent(m map[string]string, key, value string) {
+	if value == "" {
+		return
+	}
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+}
+
+// metricSenders returns the pipeline.Sender to dispatch metric's reports to, one per configured
+// endpoint, honoring metric.EffectiveMode():
+//   - config.ModeEnforce (the default) reuses endpointSenders as built by Rebuild's main loop.
+//   - config.ModeDryRun drops every non-Disk endpoint, so nothing leaves the agent while the
+//     pipeline is validated end-to-end; Disk endpoints still receive reports as usual.
+//   - config.ModeWarn builds a dedicated sender per endpoint, wrapping r in stats.NewWarnRecorder
+//     so a failed send there doesn't count toward CurrentFailureCount. These senders are rebuilt on
+//     every Rebuild rather than reusing endpointSenders, since they need a different recorder than
+//     any other metric sharing the same endpoint; an endpoint used only by ModeWarn metrics loses
+//     retry-queue continuity across a reload as a result.
+func metricSenders(metric *config.Metric, cfg *config.Config, endpointSenders map[string]pipeline.Sender, agentId string, p persistence.Persistence, r stats.Recorder, al auditlog.AuditLog) ([]pipeline.Sender, error) {
+	var msenders []pipeline.Sender
+	switch metric.EffectiveMode() {
+	case config.ModeDryRun:
+		var skipped []string
+		for _, me := range metric.Endpoints {
+			cfgep := cfg.Endpoints.Get(me.Name)
+			if cfgep != nil && cfgep.Disk != nil {
+				msenders = append(msenders, endpointSenders[me.Name])
+			} else {
+				skipped = append(skipped, me.Name)
+			}
+		}
+		if len(skipped) > 0 {
+			glog.Infof("builder: metric %v: dryrun mode: not dispatching to non-disk endpoints: %v", metric.Name, skipped)
+		}
+	case config.ModeWarn:
+		warnRecorder := stats.NewWarnRecorder(r)
+		for _, me := range metric.Endpoints {
+			sender, err := buildEndpointSender(cfg, cfg.Endpoints.Get(me.Name), agentId, p, warnRecorder, al)
+			if err != nil {
+				return nil, err
+			}
+			msenders = append(msenders, sender)
+		}
+	default:
+		for _, me := range metric.Endpoints {
+			msenders = append(msenders, endpointSenders[me.Name])
+		}
+	}
+	return msenders, nil
+}
+
+// buildEndpointSender constructs a fresh pipeline.Sender for cfgep: the underlying endpoint
+// (wrapped for auditing), a RetryingSender in front of it - or, if cfgep.Retry configures more
+// than one shard, a ShardedSender - configured to export a span per send attempt per cfg.Tracing,
+// a no-op if it's unset - and, if any filters apply, a FilteringSender in front of that.
+func buildEndpointSender(cfg *config.Config, cfgep *config.Endpoint, agentId string, p persistence.Persistence, r stats.Recorder, al auditlog.AuditLog) (pipeline.Sender, error) {
+	ep, err := createEndpoint(cfg, cfgep, agentId)
+	if err != nil {
+		return nil, err
+	}
+	auditedEp := auditlog.NewAuditingEndpoint(ep, al)
+	deadLetterSink, err := createDeadLetterSink(cfgep.DeadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint %v: %v", cfgep.Name, err)
+	}
+
+	var sender pipeline.Sender
+	if cfgep.Retry != nil && cfgep.Retry.MaxShards > 1 {
+		ss := senders.NewShardedSender(auditedEp, p, r, cfgep.Retry, deadLetterSink)
+		ss.SetTracer(tracing.NewTracer(cfg.Tracing))
+		sender = ss
+	} else {
+		rs := senders.NewRetryingSender(auditedEp, p, r, cfgep.Retry, deadLetterSink)
+		rs.SetTracer(tracing.NewTracer(cfg.Tracing))
+		sender = rs
+	}
+
+	chain, err := filter.NewChain(cfg.Filters, cfgep.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint %v: %v", cfgep.Name, err)
+	}
+	if len(chain) > 0 {
+		sender = senders.NewFilteringSender(sender, chain)
+	}
+	return sender, nil
+}
+
+func createEndpoint(cfg *config.Config, cfgep *config.Endpoint, agentId string) (pipeline.Endpoint, error) {
+	kind, err := endpointKind(cfgep)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint %v: %v", cfgep.Name, err)
+	}
+	factory, ok := endpoints.Get(kind)
+	if !ok {
+		return nil, fmt.Errorf("endpoint %v: unsupported kind: %v", cfgep.Name, kind)
+	}
+	return factory(cfg, cfgep, agentId)
+}
+
+// endpointKind returns the endpoints registry kind matching whichever oneof field of cfgep is set,
+// mirroring the exactly-one-of check Endpoint.Validate already enforced.
+func endpointKind(cfgep *config.Endpoint) (string, error) {
+	switch {
+	case cfgep.Disk != nil:
+		return "disk", nil
+	case cfgep.ServiceControl != nil:
+		return "servicecontrol", nil
+	case cfgep.AzureMarketplace != nil:
+		return "azureMarketplace", nil
+	case cfgep.OTLP != nil:
+		return "otlp", nil
+	case cfgep.CloudEvents != nil:
+		return "cloudEvents", nil
+	case cfgep.PromRemoteWrite != nil:
+		return "promRemoteWrite", nil
+	case cfgep.Prometheus != nil:
+		return "prometheus", nil
+	case cfgep.Stackdriver != nil:
+		return "stackdriver", nil
+	case cfgep.CloudWatch != nil:
+		return "cloudWatch", nil
+	case cfgep.AzureMonitor != nil:
+		return "azureMonitor", nil
+	case cfgep.StructuredLog != nil:
+		return "structuredLog", nil
+	case cfgep.PubSub != nil:
+		return "pubsub", nil
+	case cfgep.Custom != nil:
+		return cfgep.Custom.Kind, nil
+	default:
+		return "", errors.New("missing type configuration")
+	}
+}
+
+// sourceKind returns the sources registry kind matching whichever oneof field of src is set,
+// mirroring the exactly-one-of check Source.Validate already enforced.
+func sourceKind(src *config.Source) (string, error) {
+	switch {
+	case src.Heartbeat != nil:
+		return "heartbeat", nil
+	case src.PrometheusScrape != nil:
+		return "prometheusScrape", nil
+	case src.Scheduled != nil:
+		return "scheduled", nil
+	case src.CloudEvents != nil:
+		return "cloudEvents", nil
+	case src.Statsd != nil:
+		return "statsd", nil
+	case src.PubSub != nil:
+		return "pubSub", nil
+	case src.Custom != nil:
+		return src.Custom.Kind, nil
+	default:
+		return "", errors.New("missing type configuration")
+	}
+}
+
+// createDeadLetterSink builds the pipeline.DeadLetterSink described by cfgdl. It returns a nil
+// sink (not an error) when cfgdl is nil, so an endpoint without a DeadLetter policy continues to
+// only record gave-up reports via stats.Recorder.SendFailed, as before.
+func createDeadLetterSink(cfgdl *config.DeadLetterPolicy) (pipeline.DeadLetterSink, error) {
+	if cfgdl == nil {
+		return nil, nil
+	}
+	if cfgdl.Disk != nil {
+		sink, err := deadletter.NewFileSink(cfgdl.Disk.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return sink, nil
+	}
+	if cfgdl.HTTP != nil {
+		return deadletter.NewHTTPSink(cfgdl.HTTP.Endpoint, cfgdl.HTTP.Headers), nil
 	}
-	// TODO(volkman): support pubsub
-	return nil, errors.New("unsupported endpoint")
+	return nil, errors.New("unsupported deadLetter sink")
 }
@@ -15,6 +15,7 @@
 package pipeline
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 )
@@ -58,9 +59,13 @@ type Endpoint interface {
 	Name() string
 
 	// Send sends the given EndpointReport - previously built by this endpoint - to the reporting
-	// service.
+	// service. It's equivalent to SendContext(context.Background(), report).
 	Send(EndpointReport) error
 
+	// SendContext is equivalent to Send, but allows the caller to bound the operation with a
+	// context. Implementations should abort any in-flight remote call promptly once ctx is done.
+	SendContext(ctx context.Context, report EndpointReport) error
+
 	// BuildReport builds an EndpointReport from the given StampedMetricReport, optionally attaching
 	// context.
 	BuildReport(report metrics.StampedMetricReport) (EndpointReport, error)
@@ -69,3 +74,17 @@ type Endpoint interface {
 	// transient error and can be retried.
 	IsTransient(error) bool
 }
+
+// BatchEndpoint is implemented by Endpoints that can send multiple EndpointReports in a single
+// call - for example, an endpoint backed by a batch-oriented API where combining reports is
+// meaningfully more efficient than one call per report. A RetryingSender wrapping a BatchEndpoint
+// uses SendBatch, instead of one Send call per queued entry, when its RetryPolicy configures
+// BatchMaxReports.
+type BatchEndpoint interface {
+	Endpoint
+
+	// SendBatch sends every report in reports in a single call. A non-nil error fails the whole
+	// batch: there's no way to acknowledge individual reports within a failed batch, so a
+	// RetryingSender treats every report in it the same way it treats a single failed Send.
+	SendBatch(reports []EndpointReport) error
+}
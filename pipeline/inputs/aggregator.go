@@ -15,6 +15,7 @@
 package inputs
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -22,17 +23,38 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/events"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 	"github.com/GoogleCloudPlatform/ubbagent/persistence"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/stats"
 	"github.com/GoogleCloudPlatform/ubbagent/util"
 	"github.com/golang/glog"
 )
 
 const (
 	persistencePrefix = "aggregator/"
+
+	// addQueueCapacity bounds how many AddReport calls the Aggregator will buffer while its
+	// goroutine is busy elsewhere - most notably, blocked sending to a slow downstream Input while
+	// flushing a bucket. Once that buffer is full, AddReport returns ErrBackpressure immediately
+	// rather than blocking its caller for an unbounded amount of time.
+	addQueueCapacity = 64
 )
 
+// sharedQueueMaxItems bounds the shared queue a distributed Aggregator (see NewDistributedAggregator)
+// uses to hand reports from a non-holder replica off to whichever replica currently holds the lease.
+// It exists purely as a sanity backstop against an unbounded backlog if no replica acquires the lease
+// for a long time; it's deliberately generous since the queue is drained in full on every push.
+const sharedQueueMaxItems = 100000
+
+// ErrBackpressure is returned by Aggregator.AddReport when it cannot accept another report without
+// blocking its caller indefinitely - because its goroutine is still busy processing earlier reports
+// or flushing a bucket to a downstream Input that isn't keeping up. Callers can use errors.Is to
+// detect it and decide whether to drop the report or retry.
+var ErrBackpressure = errors.New("aggregator: backpressure: too many reports pending")
+
 type addMsg struct {
 	report metrics.MetricReport
 	result chan error
@@ -42,39 +64,103 @@ type addMsg struct {
 // client, buffers and aggregates for a configured amount of time, and sends them downstream.
 // See pipeline.Pipeline.
 type Aggregator struct {
-	clock         clock.Clock
-	metric        metrics.Definition
-	bufferTime    time.Duration
-	input         pipeline.Input
-	persistence   persistence.Persistence
-	currentBucket *bucket
-	pushTimer     *time.Timer
-	push          chan chan bool
-	add           chan addMsg
-	closed        bool
-	closeMutex    sync.RWMutex
-	wait          sync.WaitGroup
-	tracker       pipeline.UsageTracker
-}
-
-// NewAggregator creates a new Aggregator instance and starts its goroutine.
-func NewAggregator(metric metrics.Definition, bufferTime time.Duration, input pipeline.Input, persistence persistence.Persistence) *Aggregator {
-	return newAggregator(metric, bufferTime, input, persistence, clock.NewClock())
-}
-
-func newAggregator(metric metrics.Definition, bufferTime time.Duration, input pipeline.Input, persistence persistence.Persistence, clock clock.Clock) *Aggregator {
+	clock              clock.Clock
+	metric             metrics.Definition
+	bufferTime         time.Duration
+	maxBucketEntries   int64
+	maxPersistInterval time.Duration
+	latenessTolerance  time.Duration
+	onTimeConflict     string
+	tenantLabel        string
+	input              pipeline.Input
+	persistence        persistence.Persistence
+	recorder           stats.Recorder
+	ingestObserver     stats.IngestObserver
+	buckets            map[string]*bucket
+	tenants            map[string]bool
+	currentCreateTime  time.Time
+	pushTimer          *time.Timer
+	push               chan chan bool
+	add                chan addMsg
+	dirtyTenants       map[string]bool
+	nextPersistDue     time.Time
+	closed             bool
+	closeMutex         sync.RWMutex
+	wait               sync.WaitGroup
+	tracker            pipeline.UsageTracker
+
+	// lease, if non-nil, makes this a distributed Aggregator - see NewDistributedAggregator. Reports
+	// received while isHolder is false are diverted to sharedQueue instead of being merged into a
+	// local bucket, and only the current holder forwards aggregated buckets downstream.
+	lease       persistence.Lease
+	leaseTTL    time.Duration
+	sharedQueue persistence.Queue
+	isHolder    bool
+
+	// bus, if non-nil, receives events.Event notifications at each stage of a report's lifecycle.
+	// A nil bus is fine to publish to - see events.Bus.Publish - so this is always safe to use
+	// unconditionally.
+	bus *events.Bus
+}
+
+// NewAggregator creates a new Aggregator instance and starts its goroutine. If maxBucketEntries is
+// greater than 0, a tenant's bucket is flushed early, before bufferTime elapses, whenever the
+// number of distinct label combinations it holds reaches maxBucketEntries. If maxPersistInterval is
+// greater than 0, a bucket touched by AddReport is marked dirty and persisted at most once per
+// maxPersistInterval, rather than on every call; 0 persists synchronously on every call, as before
+// maxPersistInterval existed. latenessTolerance allows a report whose EndTime precedes its bucket's
+// creation time to still be merged in, as long as it isn't older than the tolerance; onTimeConflict
+// controls what happens to one that isn't - see bucket.addReport and config.Aggregation.OnTimeConflict.
+// tenantLabel, if non-empty, names a report label whose value multiplexes this metric's buffering
+// across independent per-tenant buckets - see tenantId - and is normally set from
+// config.Aggregation.TenantLabel. bus, if non-nil, receives events.Event notifications -
+// events.ReportAccepted, events.ReportAggregated, events.ReportPersisted, events.ReportSent,
+// events.ReportFailed, events.EndpointReleased - at each stage of a report's lifecycle.
+func NewAggregator(metric metrics.Definition, bufferTime time.Duration, maxBucketEntries int64, maxPersistInterval time.Duration, latenessTolerance time.Duration, onTimeConflict string, tenantLabel string, input pipeline.Input, persistence persistence.Persistence, recorder stats.Recorder, bus *events.Bus) *Aggregator {
+	return newAggregator(metric, bufferTime, maxBucketEntries, maxPersistInterval, latenessTolerance, onTimeConflict, tenantLabel, input, persistence, recorder, clock.NewClock(), nil, 0, bus)
+}
+
+// NewDistributedAggregator creates a new Aggregator exactly as NewAggregator does, except that it
+// coordinates with other Aggregators sharing persistence and lease - constructed, for example, with
+// the same persistence.NewLease name but distinct holder IDs - so that only the replica currently
+// holding lease forwards aggregated reports to the downstream Input. Reports accepted by a
+// non-holder are diverted into a persistence-backed shared queue instead of being merged into a
+// local bucket; the holder drains that queue, merging its contents into its own buckets, every time
+// it pushes. lease is renewed roughly every leaseTTL/2; if the current holder stops renewing it -
+// because it crashed or was partitioned from persistence - another replica takes over once leaseTTL
+// has elapsed since the holder's last successful TryAcquire, and resumes from the state persistence
+// already holds.
+func NewDistributedAggregator(metric metrics.Definition, bufferTime time.Duration, maxBucketEntries int64, maxPersistInterval time.Duration, latenessTolerance time.Duration, onTimeConflict string, tenantLabel string, input pipeline.Input, persistence persistence.Persistence, recorder stats.Recorder, lease persistence.Lease, leaseTTL time.Duration, bus *events.Bus) *Aggregator {
+	return newAggregator(metric, bufferTime, maxBucketEntries, maxPersistInterval, latenessTolerance, onTimeConflict, tenantLabel, input, persistence, recorder, clock.NewClock(), lease, leaseTTL, bus)
+}
+
+func newAggregator(metric metrics.Definition, bufferTime time.Duration, maxBucketEntries int64, maxPersistInterval time.Duration, latenessTolerance time.Duration, onTimeConflict string, tenantLabel string, input pipeline.Input, persistence persistence.Persistence, recorder stats.Recorder, clock clock.Clock, lease persistence.Lease, leaseTTL time.Duration, bus *events.Bus) *Aggregator {
+	ingestObserver, _ := recorder.(stats.IngestObserver)
 	agg := &Aggregator{
-		metric:      metric,
-		bufferTime:  bufferTime,
-		input:       input,
-		persistence: persistence,
-		clock:       clock,
-		push:        make(chan chan bool),
-		add:         make(chan addMsg),
+		metric:             metric,
+		bufferTime:         bufferTime,
+		maxBucketEntries:   maxBucketEntries,
+		maxPersistInterval: maxPersistInterval,
+		latenessTolerance:  latenessTolerance,
+		onTimeConflict:     onTimeConflict,
+		tenantLabel:        tenantLabel,
+		input:              input,
+		persistence:        persistence,
+		recorder:           recorder,
+		ingestObserver:     ingestObserver,
+		clock:              clock,
+		push:               make(chan chan bool),
+		add:                make(chan addMsg, addQueueCapacity),
+		dirtyTenants:       make(map[string]bool),
+		lease:              lease,
+		leaseTTL:           leaseTTL,
+		bus:                bus,
 	}
-	if !agg.loadState() {
-		agg.currentBucket = newBucket(clock.Now())
+	if lease != nil {
+		agg.sharedQueue = persistence.Queue(persistencePrefix + metric.Name + "/shared")
+		agg.isHolder, _ = lease.TryAcquire(clock.Now(), leaseTTL)
 	}
+	agg.loadState()
 	input.Use()
 	agg.wait.Add(1)
 	go agg.run()
@@ -82,24 +168,55 @@ func newAggregator(metric metrics.Definition, bufferTime time.Duration, input pi
 }
 
 // AddReport adds a report. Reports are aggregated when possible, during a time period defined by
-// the Aggregator's config object. Two reports can be aggregated if they have the same name, contain
-// the same labels, and don't contain overlapping time ranges denoted by StartTime and EndTme.
+// the Aggregator's config object. Two reports can be aggregated if they have the same name and
+// contain the same labels; a report whose EndTime precedes its bucket's creation time is merged in
+// as long as it's within the Aggregator's latenessTolerance, and otherwise handled according to the
+// Aggregator's onTimeConflict policy (see bucket.addReport). If tenantLabel is configured, report is
+// routed to the bucket for its tenantId, and is rejected outright if that resolves to a reserved
+// tenant ID (see config.IsReservedTenantId).
 func (h *Aggregator) AddReport(report metrics.MetricReport) error {
 	glog.V(2).Infof("aggregator: received report: %v", report.Name)
+	if h.ingestObserver != nil {
+		h.ingestObserver.ObserveReportReceived(h.metric.Name)
+	}
 	if err := report.Validate(h.metric); err != nil {
+		h.observeRejected("invalid")
 		return err
 	}
+	if h.tenantLabel != "" {
+		if tenantID := h.tenantId(report); config.IsReservedTenantId(tenantID) {
+			h.observeRejected("reserved_tenant")
+			return fmt.Errorf("aggregator: tenant id %q is reserved", tenantID)
+		}
+	}
 	h.closeMutex.RLock()
 	defer h.closeMutex.RUnlock()
 	if h.closed {
+		h.observeRejected("closed")
 		return errors.New("aggregator: AddReport called on closed aggregator")
 	}
 	msg := addMsg{
 		report: report,
 		result: make(chan error, 1),
 	}
-	h.add <- msg
-	return <-msg.result
+	select {
+	case h.add <- msg:
+	default:
+		h.observeRejected("backpressure")
+		return ErrBackpressure
+	}
+	if err := <-msg.result; err != nil {
+		h.observeRejected("too_late")
+		return err
+	}
+	return nil
+}
+
+// observeRejected reports a rejected AddReport call to h.ingestObserver, if configured.
+func (h *Aggregator) observeRejected(reason string) {
+	if h.ingestObserver != nil {
+		h.ingestObserver.ObserveReportRejected(h.metric.Name, reason)
+	}
 }
 
 // Use increments the Aggregator's usage count.
@@ -123,6 +240,13 @@ func (h *Aggregator) Release() error {
 		h.closeMutex.Unlock()
 		h.wait.Wait()
 
+		if h.lease != nil && h.isHolder {
+			if err := h.lease.Release(); err != nil {
+				glog.Errorf("aggregator: metric %v: releasing lease: %+v", h.metric.Name, err)
+			}
+		}
+		h.bus.Publish(events.Event{Kind: events.EndpointReleased, Metric: h.metric.Name})
+
 		// Cascade
 		return h.input.Release()
 	})
@@ -131,87 +255,365 @@ func (h *Aggregator) Release() error {
 func (h *Aggregator) run() {
 	running := true
 	for running {
-		// Set a timer to fire when the current bucket should be pushed.
+		// Set a timer to fire when the current generation of buckets should be pushed.
 		now := h.clock.Now()
-		nextFire := now.Add(h.bufferTime - now.Sub(h.currentBucket.CreateTime))
+		nextFire := now.Add(h.bufferTime - now.Sub(h.currentCreateTime))
 		timer := h.clock.NewTimerAt(nextFire)
+
+		// Set a timer to fire when any tenant's dirty bucket is next due to be persisted. It's left
+		// stopped - never firing - whenever nothing is dirty.
+		persistTimer := clock.NewStoppedTimer()
+		if len(h.dirtyTenants) > 0 {
+			persistTimer = h.clock.NewTimerAt(h.nextPersistDue)
+		}
+
+		// If this Aggregator is distributed (see NewDistributedAggregator), set a timer to renew its
+		// lease at roughly leaseTTL/2, well before the current holder's lease could expire. It's left
+		// stopped whenever lease isn't configured.
+		leaseTimer := clock.NewStoppedTimer()
+		if h.lease != nil {
+			leaseTimer = h.clock.NewTimerAt(now.Add(h.leaseTTL / 2))
+		}
+
 		select {
 		case msg, ok := <-h.add:
 			if ok {
-				err := h.currentBucket.addReport(msg.report)
+				if h.lease != nil && !h.isHolder {
+					err := h.sharedQueue.EnqueueWithLimit(msg.report, sharedQueueMaxItems, 0)
+					msg.result <- err
+					break
+				}
+				h.bus.Publish(events.Event{Kind: events.ReportAccepted, Metric: h.metric.Name, Report: &msg.report})
+				tenantID := h.tenantId(msg.report)
+				b := h.bucketFor(tenantID)
+				deadLettered, err := b.addReport(msg.report, h.metric, h.latenessTolerance, h.onTimeConflict)
 				if err == nil {
-					// TODO(volkman): possibly rate-limit persistence, or flush to disk at a defined interval.
-					// Perhaps a benchmark to determine whether eager persistence is a bottleneck.
-					h.persistState()
+					if deadLettered {
+						h.observeRejected("dead_lettered")
+						h.deadLetterLateReport(tenantID, msg.report)
+					} else {
+						h.markDirty(tenantID)
+						h.reportBufferBytes()
+						h.bus.Publish(events.Event{Kind: events.ReportAggregated, Metric: h.metric.Name, Report: &msg.report})
+					}
+				} else {
+					h.bus.Publish(events.Event{Kind: events.ReportFailed, Metric: h.metric.Name, Report: &msg.report, Err: err})
 				}
 				msg.result <- err
+				if err == nil && !deadLettered && h.maxBucketEntries > 0 && b.entryCount() >= h.maxBucketEntries {
+					glog.Warningf("aggregator: metric %v bucket reached maxBucketEntries (%d); flushing early", h.metric.Name, h.maxBucketEntries)
+					h.pushTenantBucket(tenantID, h.clock.Now())
+				}
 			} else {
 				running = false
 			}
 		case now := <-timer.GetC():
-			// Time to push the current bucket.
-			h.pushBucket(now)
+			// Time to push every tenant's bucket and start a new generation.
+			h.pushBuckets(now)
+		case <-persistTimer.GetC():
+			h.flushDirty()
+		case now := <-leaseTimer.GetC():
+			h.renewLease(now)
 		}
 		timer.Stop()
+		persistTimer.Stop()
+		leaseTimer.Stop()
 	}
-	h.pushBucket(h.clock.Now())
+	h.pushBuckets(h.clock.Now())
 	h.wait.Done()
 }
 
-func (h *Aggregator) loadState() bool {
-	err := h.persistence.Value(h.persistenceName()).Load(&h.currentBucket)
-	if err == persistence.ErrNotFound {
-		// Didn't find existing state to load.
-		return false
-	} else if err == nil {
-		// We loaded state.
-		return true
+// markDirty persists tenantID's bucket immediately if maxPersistInterval isn't configured,
+// preserving the Aggregator's original synchronous-persist-on-every-AddReport behavior. Otherwise,
+// it coalesces the persist: tenantID is recorded as dirty, scheduling a single persist of every
+// currently-dirty tenant no later than maxPersistInterval from the first of them to go dirty since
+// the last flushDirty.
+func (h *Aggregator) markDirty(tenantID string) {
+	if h.maxPersistInterval <= 0 {
+		h.persistState(tenantID)
+		return
+	}
+	if len(h.dirtyTenants) == 0 {
+		h.nextPersistDue = h.clock.Now().Add(h.maxPersistInterval)
+	}
+	h.dirtyTenants[tenantID] = true
+}
+
+// renewLease attempts to acquire or renew h.lease as of now, updating h.isHolder with the result.
+// A failed TryAcquire (e.g. a transient persistence error) leaves h.isHolder unchanged, so a
+// holder doesn't give up its role over a single blip; it will simply retry at the next renewal.
+func (h *Aggregator) renewLease(now time.Time) {
+	isHolder, err := h.lease.TryAcquire(now, h.leaseTTL)
+	if err != nil {
+		glog.Errorf("aggregator: metric %v: renewing lease: %+v", h.metric.Name, err)
+		return
 	}
-	// Some other error loading existing state.
-	panic(fmt.Sprintf("error loading aggregator state: %+v", err))
+	h.isHolder = isHolder
 }
 
-func (h *Aggregator) persistState() {
+// drainSharedQueue merges every report enqueued by a non-holder replica since the last drain into
+// this Aggregator's buckets, as if each had arrived via AddReport on the holder itself. It's called
+// before a holder pushes, so nothing a non-holder accepted is lost once this replica is, or becomes,
+// the holder.
+func (h *Aggregator) drainSharedQueue() {
+	for {
+		var reports []metrics.MetricReport
+		n, err := h.sharedQueue.PeekBatch(64, &reports)
+		if err == persistence.ErrNotFound {
+			return
+		}
+		if err != nil {
+			glog.Errorf("aggregator: metric %v: draining shared queue: %+v", h.metric.Name, err)
+			return
+		}
+		for _, report := range reports {
+			tenantID := h.tenantId(report)
+			b := h.bucketFor(tenantID)
+			deadLettered, err := b.addReport(report, h.metric, h.latenessTolerance, h.onTimeConflict)
+			if err != nil {
+				glog.Errorf("aggregator: metric %v: merging shared queue report: %+v", h.metric.Name, err)
+				continue
+			}
+			if deadLettered {
+				h.observeRejected("dead_lettered")
+				h.deadLetterLateReport(tenantID, report)
+			} else {
+				h.markDirty(tenantID)
+			}
+		}
+		if err := h.sharedQueue.DequeueN(n); err != nil && err != persistence.ErrNotFound {
+			glog.Errorf("aggregator: metric %v: dequeuing shared queue: %+v", h.metric.Name, err)
+			return
+		}
+	}
+}
+
+// flushDirty persists every tenant currently marked dirty by markDirty, and clears the dirty set.
+func (h *Aggregator) flushDirty() {
+	for tenantID := range h.dirtyTenants {
+		h.persistState(tenantID)
+	}
+	h.dirtyTenants = make(map[string]bool)
+}
+
+// tenantId returns the tenant ID that report belongs to, derived from its tenantLabel label. It
+// returns "" - the implicit default tenant, used for every report when tenant multiplexing isn't
+// configured - if tenantLabel is unset or report doesn't carry it.
+func (h *Aggregator) tenantId(report metrics.MetricReport) string {
+	if h.tenantLabel == "" {
+		return ""
+	}
+	return report.Labels[h.tenantLabel]
+}
+
+// bucketFor returns tenantID's current bucket, creating one - sharing the current generation's
+// creation time - if this is the first report seen for tenantID.
+func (h *Aggregator) bucketFor(tenantID string) *bucket {
+	b, ok := h.buckets[tenantID]
+	if !ok {
+		b = newBucket(h.currentCreateTime)
+		h.buckets[tenantID] = b
+	}
+	return b
+}
+
+// loadState populates h.buckets, h.tenants and h.currentCreateTime from persistence. When tenant
+// multiplexing isn't configured (h.tenantLabel == ""), it loads the single implicit tenant's
+// bucket from the metric's plain persistence key, exactly as a non-multi-tenant Aggregator always
+// has. Otherwise, it first loads the metric's persisted tenant index to learn which per-tenant
+// persistence.Value keys to load - see rememberTenant.
+func (h *Aggregator) loadState() {
+	h.buckets = make(map[string]*bucket)
+	h.tenants = make(map[string]bool)
+
+	if h.tenantLabel == "" {
+		var b bucket
+		err := h.persistence.Value(h.bucketPersistenceName("")).Load(&b)
+		if err == nil {
+			h.buckets[""] = &b
+			h.currentCreateTime = b.CreateTime
+			return
+		} else if err != persistence.ErrNotFound {
+			panic(fmt.Sprintf("error loading aggregator state: %+v", err))
+		}
+		h.currentCreateTime = h.clock.Now()
+		return
+	}
+
+	var tenantIDs []string
+	err := h.persistence.Value(h.tenantsPersistenceName()).Load(&tenantIDs)
+	if err != nil && err != persistence.ErrNotFound {
+		panic(fmt.Sprintf("error loading aggregator tenant index: %+v", err))
+	}
+	for _, tenantID := range tenantIDs {
+		var b bucket
+		if err := h.persistence.Value(h.bucketPersistenceName(tenantID)).Load(&b); err != nil {
+			if err == persistence.ErrNotFound {
+				continue
+			}
+			panic(fmt.Sprintf("error loading aggregator state for tenant %v: %+v", tenantID, err))
+		}
+		h.buckets[tenantID] = &b
+		h.tenants[tenantID] = true
+		h.currentCreateTime = b.CreateTime
+	}
+	if h.currentCreateTime.IsZero() {
+		h.currentCreateTime = h.clock.Now()
+	}
+}
+
+// persistState persists tenantID's current bucket, and records tenantID in the metric's persisted
+// tenant index if this is the first time it's been seen (see rememberTenant).
+func (h *Aggregator) persistState(tenantID string) {
 	// TODO(volkman): always persist a metric's previous end time, even if no bucket is persisted,
 	// so that the start time of the next report after a restart is validated.
-	if err := h.persistence.Value(h.persistenceName()).Store(h.currentBucket); err != nil {
+	if err := h.persistence.Value(h.bucketPersistenceName(tenantID)).Store(h.buckets[tenantID]); err != nil {
 		panic(fmt.Sprintf("error persisting aggregator state: %+v", err))
 	}
+	h.bus.Publish(events.Event{Kind: events.ReportPersisted, Metric: h.metric.Name})
+	h.rememberTenant(tenantID)
 }
 
-// pushBucket sends currently-aggregated metrics to the configured MetricSender and resets the
-// bucket.
-func (h *Aggregator) pushBucket(now time.Time) {
-	if h.currentBucket == nil {
-		h.currentBucket = newBucket(now)
+// rememberTenant adds tenantID to the metric's persisted tenant index, if it isn't already there,
+// so a restarted Aggregator knows which per-tenant persistence.Value keys to load in loadState.
+// It's a no-op when tenant multiplexing isn't configured: tenantID is always "" in that case, and
+// that bucket is persisted at the metric's plain, non-tenant-suffixed key instead of being tracked
+// in the index.
+func (h *Aggregator) rememberTenant(tenantID string) {
+	if h.tenantLabel == "" || h.tenants[tenantID] {
 		return
 	}
+	h.tenants[tenantID] = true
+	tenantIDs := make([]string, 0, len(h.tenants))
+	for t := range h.tenants {
+		tenantIDs = append(tenantIDs, t)
+	}
+	if err := h.persistence.Value(h.tenantsPersistenceName()).Store(tenantIDs); err != nil {
+		panic(fmt.Sprintf("error persisting aggregator tenant index: %+v", err))
+	}
+}
+
+// pushBuckets sends every tenant's currently-aggregated metrics to the configured downstream Input
+// and starts a new generation of buckets, sharing a fresh creation time, for all of them. If this
+// Aggregator is distributed (see NewDistributedAggregator), it first merges in anything a
+// non-holder replica diverted to the shared queue, and only actually forwards downstream - the rest
+// of this generation's bookkeeping still runs - if it currently holds the lease. Holdership is
+// re-verified right before sending rather than trusted from the last periodic renewal, since the
+// periodic renewal can be up to leaseTTL/2 stale by the time a push actually happens.
+func (h *Aggregator) pushBuckets(now time.Time) {
+	if h.lease != nil {
+		h.drainSharedQueue()
+		h.renewLease(now)
+	}
+	for tenantID, b := range h.buckets {
+		if h.lease == nil || h.isHolder {
+			h.sendBucket(b)
+		}
+		h.buckets[tenantID] = newBucket(now)
+	}
+	h.currentCreateTime = now
+	for tenantID := range h.buckets {
+		h.persistState(tenantID)
+	}
+	h.dirtyTenants = make(map[string]bool)
+	h.reportBufferBytes()
+}
+
+// pushTenantBucket flushes tenantID's bucket alone, independent of every other tenant's bucket and
+// of the overall generation timer. It's used when that one tenant's bucket reaches
+// maxBucketEntries before the rest of the current generation is due to be pushed. As with
+// pushBuckets, a distributed Aggregator re-verifies holdership immediately before sending rather
+// than trusting the last periodic renewal, and only forwards downstream while it holds the lease.
+func (h *Aggregator) pushTenantBucket(tenantID string, now time.Time) {
+	if h.lease != nil {
+		h.renewLease(now)
+	}
+	if h.lease == nil || h.isHolder {
+		h.sendBucket(h.buckets[tenantID])
+	}
+	h.buckets[tenantID] = newBucket(now)
+	h.persistState(tenantID)
+	delete(h.dirtyTenants, tenantID)
+	h.reportBufferBytes()
+}
+
+// sendBucket forwards every report aggregated in b to the downstream Input.
+func (h *Aggregator) sendBucket(b *bucket) {
 	var finishedReports []metrics.MetricReport
-	for _, namedReports := range h.currentBucket.Reports {
+	for _, namedReports := range b.Reports {
 		for _, report := range namedReports {
 			finishedReports = append(finishedReports, *report.metricReport())
 		}
 	}
-	if len(finishedReports) > 0 {
-		if len(finishedReports) == 1 {
-			glog.V(2).Infoln("aggregator: sending 1 report")
-		} else {
-			glog.V(2).Infof("aggregator: sending %v reports", len(finishedReports))
-		}
-		for _, r := range finishedReports {
-			err := h.input.AddReport(r)
-			if err != nil {
-				glog.Errorf("aggregator: error sending report: %+v", err)
-				continue
-			}
+	if len(finishedReports) == 0 {
+		return
+	}
+	if len(finishedReports) == 1 {
+		glog.V(2).Infoln("aggregator: sending 1 report")
+	} else {
+		glog.V(2).Infof("aggregator: sending %v reports", len(finishedReports))
+	}
+	for _, r := range finishedReports {
+		r := r // capture a stable copy for events published to async subscribers
+		if err := h.input.AddReport(r); err != nil {
+			glog.Errorf("aggregator: error sending report: %+v", err)
+			h.bus.Publish(events.Event{Kind: events.ReportFailed, Metric: h.metric.Name, Report: &r, Err: err})
+			continue
 		}
+		h.bus.Publish(events.Event{Kind: events.ReportSent, Metric: h.metric.Name, Report: &r})
+	}
+}
+
+// bucketPersistenceName returns the persistence.Value key for tenantID's bucket. The default
+// tenant ("", used whenever tenant multiplexing isn't configured) is persisted at the metric's
+// plain key, unchanged from before tenant multiplexing existed; every other tenant gets its own
+// key nested under it, so a crash mid-flush only replays that tenant's data.
+func (h *Aggregator) bucketPersistenceName(tenantID string) string {
+	if tenantID == "" {
+		return persistencePrefix + h.metric.Name
+	}
+	return persistencePrefix + h.metric.Name + "/" + tenantID
+}
+
+// tenantsPersistenceName returns the persistence.Value key for the metric's tenant index - the
+// list of tenant IDs loadState must check for persisted buckets.
+func (h *Aggregator) tenantsPersistenceName() string {
+	return persistencePrefix + h.metric.Name + "/tenants"
+}
+
+// deadLetterLateReport persists report - which arrived too late for tenantID's bucket and whose
+// metric's OnTimeConflict policy is config.OnTimeConflictDeadLetter - by appending it to a
+// persistence.Persistence-backed list, so an operator can recover and reprocess it later instead
+// of losing it silently.
+func (h *Aggregator) deadLetterLateReport(tenantID string, report metrics.MetricReport) {
+	value := h.persistence.Value(h.lateReportsPersistenceName(tenantID))
+	var reports []metrics.MetricReport
+	if err := value.Load(&reports); err != nil && err != persistence.ErrNotFound {
+		glog.Errorf("aggregator: metric %v: loading dead-lettered late reports: %+v", h.metric.Name, err)
+		return
+	}
+	reports = append(reports, report)
+	if err := value.Store(reports); err != nil {
+		glog.Errorf("aggregator: metric %v: persisting dead-lettered late report: %+v", h.metric.Name, err)
 	}
-	h.currentBucket = newBucket(now)
-	h.persistState()
 }
 
-func (h *Aggregator) persistenceName() string {
-	return persistencePrefix + h.metric.Name
+func (h *Aggregator) lateReportsPersistenceName(tenantID string) string {
+	return h.bucketPersistenceName(tenantID) + "/latereports"
+}
+
+// reportBufferBytes reports the approximate in-memory size of every tenant's current bucket,
+// combined, to h.ingestObserver, if configured. The size is computed the same way persistState
+// computes the size of the bucket it persists: by JSON-marshaling it.
+func (h *Aggregator) reportBufferBytes() {
+	if h.ingestObserver == nil {
+		return
+	}
+	b, err := json.Marshal(h.buckets)
+	if err != nil {
+		return
+	}
+	h.ingestObserver.ObserveBufferBytes(h.metric.Name, int64(len(b)))
 }
 
 type bucket struct {
@@ -222,25 +624,45 @@ type bucket struct {
 // aggregatedReport is an extension of MetricReport that supports operations for combining reports.
 type aggregatedReport metrics.MetricReport
 
-// accept possibly aggregates the given MetricReport into this aggregatedReport. Returns true
-// if the report was aggregated, or false if the labels or name don't match.
-func (ar *aggregatedReport) accept(mr metrics.MetricReport) (bool, error) {
+// accept possibly aggregates the given MetricReport into this aggregatedReport, combining values
+// according to kind (see metrics.KindSum, etc.). Returns true if the report was aggregated, or
+// false if the labels or name don't match. mr.Value must already have been normalized by
+// normalizeDistribution, so a distribution metric's observation always arrives here as a
+// *metrics.Distribution, never a bare scalar.
+//
+// If mr carries the same ClientId as the last report merged into ar, it's treated as a replay of
+// an observation ar has already aggregated - for example, one resubmitted by a client that retried
+// after losing the response to a successful AddReport call - and is dropped without being merged
+// again.
+func (ar *aggregatedReport) accept(mr metrics.MetricReport, kind string) (bool, error) {
 	if mr.Name != ar.Name || !reflect.DeepEqual(mr.Labels, ar.Labels) {
 		return false, nil
 	}
 
+	if mr.ClientId != "" && mr.ClientId == ar.ClientId {
+		return true, nil
+	}
+
 	// Only one of these values should be non-nil. We rely on prior validation to ensure the proper
 	// value (i.e., the one specified in the metrics.Definition) is provided.
 	if mr.Value.Int64Value != nil {
 		if ar.Value.Int64Value == nil {
-			ar.Value.Int64Value = util.NewInt64(0)
+			ar.Value.Int64Value = util.NewInt64(*mr.Value.Int64Value)
+		} else {
+			*ar.Value.Int64Value = combineInt64(kind, *ar.Value.Int64Value, *mr.Value.Int64Value)
 		}
-		*ar.Value.Int64Value += *mr.Value.Int64Value
 	} else if mr.Value.DoubleValue != nil {
 		if ar.Value.DoubleValue == nil {
-			ar.Value.DoubleValue = util.NewFloat64(0)
+			ar.Value.DoubleValue = util.NewFloat64(*mr.Value.DoubleValue)
+		} else {
+			*ar.Value.DoubleValue = combineFloat64(kind, *ar.Value.DoubleValue, *mr.Value.DoubleValue)
+		}
+	} else if mr.Value.DistributionValue != nil {
+		if ar.Value.DistributionValue == nil {
+			ar.Value.DistributionValue = mr.Value.DistributionValue
+		} else if err := ar.Value.DistributionValue.Merge(mr.Value.DistributionValue); err != nil {
+			return false, err
 		}
-		*ar.Value.DoubleValue += *mr.Value.DoubleValue
 	}
 
 	// Expand the aggregated start time if the given MetricReport has ealier start time.
@@ -248,9 +670,12 @@ func (ar *aggregatedReport) accept(mr metrics.MetricReport) (bool, error) {
 		ar.StartTime = mr.StartTime
 	}
 	// Expand the aggregated end time if the given MetricReport has later end time.
-	if mr.EndTime.After(ar.StartTime) {
+	if mr.EndTime.After(ar.EndTime) {
 		ar.EndTime = mr.EndTime
 	}
+	if mr.ClientId != "" {
+		ar.ClientId = mr.ClientId
+	}
 	return true, nil
 }
 
@@ -258,6 +683,47 @@ func (ar *aggregatedReport) metricReport() *metrics.MetricReport {
 	return (*metrics.MetricReport)(ar)
 }
 
+// combineInt64 combines acc (the currently-aggregated value) and val (the incoming report's
+// value) according to kind.
+func combineInt64(kind string, acc, val int64) int64 {
+	switch kind {
+	case metrics.KindMax:
+		if val > acc {
+			return val
+		}
+		return acc
+	case metrics.KindMin:
+		if val < acc {
+			return val
+		}
+		return acc
+	case metrics.KindLast:
+		return val
+	default:
+		return acc + val
+	}
+}
+
+// combineFloat64 is the float64 equivalent of combineInt64.
+func combineFloat64(kind string, acc, val float64) float64 {
+	switch kind {
+	case metrics.KindMax:
+		if val > acc {
+			return val
+		}
+		return acc
+	case metrics.KindMin:
+		if val < acc {
+			return val
+		}
+		return acc
+	case metrics.KindLast:
+		return val
+	default:
+		return acc + val
+	}
+}
+
 func newBucket(t time.Time) *bucket {
 	return &bucket{
 		Reports:    make(map[string][]*aggregatedReport),
@@ -265,17 +731,80 @@ func newBucket(t time.Time) *bucket {
 	}
 }
 
-func (b *bucket) addReport(mr metrics.MetricReport) error {
-	for _, ar := range b.Reports[mr.Name] {
-		accepted, err := ar.accept(mr)
-		if err != nil {
-			return err
+// entryCount returns the total number of distinct aggregatedReports currently buffered across all
+// metric names in the bucket.
+func (b *bucket) entryCount() int64 {
+	var count int64
+	for _, reports := range b.Reports {
+		count += int64(len(reports))
+	}
+	return count
+}
+
+// addReport merges mr into the bucket, creating a new aggregatedReport if none of the existing
+// entries for mr.Name accept it (see aggregatedReport.accept). A report whose EndTime precedes the
+// bucket's creation time - for example, one delayed by a retried RPC or a sidecar replaying
+// buffered events - is tolerated rather than rejected as long as it isn't older than
+// latenessTolerance. One that falls outside tolerance is handled according to onTimeConflict:
+// config.OnTimeConflictMerge tolerates it anyway and merges it as usual; config.OnTimeConflictSplit
+// tolerates it but always starts a new aggregatedReport rather than merging into one that might
+// already cover the same labels; config.OnTimeConflictDeadLetter leaves it out of the bucket
+// entirely and returns deadLettered=true so the caller can hand it off to a dead-letter sink
+// instead; anything else (including the empty string) rejects it with an error.
+func (b *bucket) addReport(mr metrics.MetricReport, metric metrics.Definition, latenessTolerance time.Duration, onTimeConflict string) (deadLettered bool, err error) {
+	forceNewEntry := false
+	if mr.EndTime.Before(b.CreateTime.Add(-latenessTolerance)) {
+		switch onTimeConflict {
+		case config.OnTimeConflictMerge:
+			// Tolerate the lateness; merge below as usual.
+		case config.OnTimeConflictSplit:
+			forceNewEntry = true
+		case config.OnTimeConflictDeadLetter:
+			return true, nil
+		default:
+			return false, fmt.Errorf("aggregator: report for metric %v is too late: EndTime %v precedes bucket creation time %v by more than the %v lateness tolerance", mr.Name, mr.EndTime, b.CreateTime, latenessTolerance)
 		}
-		if accepted {
-			return nil
+	}
+
+	normalizeDistribution(&mr, metric)
+	if !forceNewEntry {
+		for _, ar := range b.Reports[mr.Name] {
+			accepted, err := ar.accept(mr, metric.EffectiveKind())
+			if err != nil {
+				return false, err
+			}
+			if accepted {
+				return false, nil
+			}
 		}
 	}
 
 	b.Reports[mr.Name] = append(b.Reports[mr.Name], (*aggregatedReport)(&mr))
-	return nil
+	return false, nil
+}
+
+// normalizeDistribution converts a distribution metric's raw single-observation value
+// (Int64Value or DoubleValue) into a one-sample metrics.Distribution, so that every report
+// accepted into a distribution metric's bucket is represented as a Distribution, never a bare
+// scalar. A report that already carries a pre-merged Distribution (e.g. one forwarded from another
+// aggregation stage) is left untouched. It has no effect on non-distribution metrics.
+func normalizeDistribution(mr *metrics.MetricReport, metric metrics.Definition) {
+	if metric.Type != metrics.DistributionType || mr.Value.DistributionValue != nil {
+		return
+	}
+	mr.Value.DistributionValue = metrics.NewDistribution(metric.Distribution, scalarValue(mr.Value))
+	mr.Value.Int64Value = nil
+	mr.Value.DoubleValue = nil
+}
+
+// scalarValue returns v's single scalar observation as a float64, or 0 if neither Int64Value nor
+// DoubleValue is set.
+func scalarValue(v metrics.MetricValue) float64 {
+	if v.Int64Value != nil {
+		return float64(*v.Int64Value)
+	}
+	if v.DoubleValue != nil {
+		return *v.DoubleValue
+	}
+	return 0
 }
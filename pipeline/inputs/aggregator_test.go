@@ -0,0 +1,691 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inputs
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+	"github.com/GoogleCloudPlatform/ubbagent/stats"
+	"github.com/GoogleCloudPlatform/ubbagent/util"
+)
+
+// aggregatorRecorder is a minimal pipeline.Input that records every report it receives, used to
+// exercise Aggregator without depending on a full downstream pipeline. It's safe to share between
+// multiple Aggregators running concurrently, as a distributed Aggregator's downstream Input must be.
+type aggregatorRecorder struct {
+	mu      sync.Mutex
+	reports []metrics.MetricReport
+}
+
+func (r *aggregatorRecorder) AddReport(report metrics.MetricReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+func (r *aggregatorRecorder) Use() {}
+
+func (r *aggregatorRecorder) Release() error { return nil }
+
+func (r *aggregatorRecorder) Reports() []metrics.MetricReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]metrics.MetricReport(nil), r.reports...)
+}
+
+var distributionMetric = metrics.Definition{
+	Name: "latency",
+	Type: metrics.DistributionType,
+	Distribution: &metrics.DistributionOptions{
+		Base:       2,
+		NumBuckets: 10,
+	},
+}
+
+func TestAggregator_Distribution(t *testing.T) {
+	t.Run("merges observations into one histogram", func(t *testing.T) {
+		recorder := &aggregatorRecorder{}
+		agg := NewAggregator(distributionMetric, time.Hour, 0, 0, 0, "", "", recorder, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), nil)
+
+		now := time.Now()
+		reports := []metrics.MetricReport{
+			{Name: "latency", StartTime: now, EndTime: now.Add(time.Second), Value: metrics.MetricValue{DoubleValue: util.NewFloat64(1)}},
+			{Name: "latency", StartTime: now.Add(time.Second), EndTime: now.Add(2 * time.Second), Value: metrics.MetricValue{DoubleValue: util.NewFloat64(4)}},
+			{Name: "latency", StartTime: now.Add(2 * time.Second), EndTime: now.Add(3 * time.Second), Value: metrics.MetricValue{DoubleValue: util.NewFloat64(4)}},
+		}
+		for _, r := range reports {
+			if err := agg.AddReport(r); err != nil {
+				t.Fatalf("unexpected error adding report: %v", err)
+			}
+		}
+
+		if err := agg.Release(); err != nil {
+			t.Fatalf("unexpected error releasing aggregator: %v", err)
+		}
+
+		if len(recorder.reports) != 1 {
+			t.Fatalf("expected exactly one finished report, got %v", len(recorder.reports))
+		}
+		dv := recorder.reports[0].Value.DistributionValue
+		if dv == nil {
+			t.Fatal("expected a non-nil DistributionValue")
+		}
+		if dv.Count != 3 {
+			t.Fatalf("expected Count == 3, got %v", dv.Count)
+		}
+		if dv.Sum != 9 {
+			t.Fatalf("expected Sum == 9, got %v", dv.Sum)
+		}
+		if dv.Min != 1 || dv.Max != 4 {
+			t.Fatalf("expected Min == 1 and Max == 4, got %v and %v", dv.Min, dv.Max)
+		}
+		if len(dv.Buckets) != 10 {
+			t.Fatalf("expected 10 buckets, got %v", len(dv.Buckets))
+		}
+	})
+
+	t.Run("reloads persisted histogram state across restarts", func(t *testing.T) {
+		// Simulate a prior Aggregator instance that persisted a bucket containing one observation,
+		// then crashed before it could flush - the case loadState/persistState exist to handle. A
+		// graceful Release, by contrast, flushes and resets the persisted bucket, so it wouldn't
+		// exercise the reload path this test cares about.
+		p := persistence.NewMemoryPersistence()
+		now := time.Now()
+		priorBucket := newBucket(now)
+		priorReport := metrics.MetricReport{
+			Name:      "latency",
+			StartTime: now,
+			EndTime:   now.Add(time.Second),
+			Value:     metrics.MetricValue{DistributionValue: metrics.NewDistribution(distributionMetric.Distribution, 1)},
+		}
+		if _, err := priorBucket.addReport(priorReport, distributionMetric, 0, ""); err != nil {
+			t.Fatalf("unexpected error seeding prior bucket: %v", err)
+		}
+		if err := p.Value(persistencePrefix + distributionMetric.Name).Store(priorBucket); err != nil {
+			t.Fatalf("unexpected error seeding persistence: %v", err)
+		}
+
+		recorder := &aggregatorRecorder{}
+		agg := NewAggregator(distributionMetric, time.Hour, 0, 0, 0, "", "", recorder, p, stats.NewNoopRecorder(), nil)
+		if err := agg.AddReport(metrics.MetricReport{
+			Name:      "latency",
+			StartTime: now.Add(time.Second),
+			EndTime:   now.Add(2 * time.Second),
+			Value:     metrics.MetricValue{DoubleValue: util.NewFloat64(4)},
+		}); err != nil {
+			t.Fatalf("unexpected error adding report: %v", err)
+		}
+		if err := agg.Release(); err != nil {
+			t.Fatalf("unexpected error releasing aggregator: %v", err)
+		}
+
+		if len(recorder.reports) != 1 {
+			t.Fatalf("expected exactly one finished report, got %v", len(recorder.reports))
+		}
+		dv := recorder.reports[0].Value.DistributionValue
+		if dv == nil || dv.Count != 2 {
+			t.Fatalf("expected the reloaded histogram to include both the seeded and new observations, got %+v", dv)
+		}
+	})
+
+	t.Run("a late report is merged and its persisted state survives restart", func(t *testing.T) {
+		p := persistence.NewMemoryPersistence()
+		now := time.Now()
+		priorBucket := newBucket(now)
+		priorReport := metrics.MetricReport{
+			Name:      "latency",
+			StartTime: now,
+			EndTime:   now.Add(time.Second),
+			Value:     metrics.MetricValue{DistributionValue: metrics.NewDistribution(distributionMetric.Distribution, 1)},
+		}
+		if _, err := priorBucket.addReport(priorReport, distributionMetric, 0, ""); err != nil {
+			t.Fatalf("unexpected error seeding prior bucket: %v", err)
+		}
+		if err := p.Value(persistencePrefix + distributionMetric.Name).Store(priorBucket); err != nil {
+			t.Fatalf("unexpected error seeding persistence: %v", err)
+		}
+
+		recorder := &aggregatorRecorder{}
+		agg := NewAggregator(distributionMetric, time.Hour, 0, 0, 10*time.Second, "", "", recorder, p, stats.NewNoopRecorder(), nil)
+		// EndTime precedes the bucket's creation time, simulating a retried RPC that arrived after
+		// the bucket was already created, but it's within the 10-second lateness tolerance.
+		if err := agg.AddReport(metrics.MetricReport{
+			Name:      "latency",
+			StartTime: now.Add(-5 * time.Second),
+			EndTime:   now.Add(-3 * time.Second),
+			Value:     metrics.MetricValue{DoubleValue: util.NewFloat64(4)},
+		}); err != nil {
+			t.Fatalf("unexpected error adding late report: %v", err)
+		}
+
+		// Simulate a crash before the bucket is flushed, and confirm a fresh read of persistence - as
+		// a restarted Aggregator would perform - reflects the late-merged observation.
+		var reloaded bucket
+		if err := p.Value(persistencePrefix + distributionMetric.Name).Load(&reloaded); err != nil {
+			t.Fatalf("unexpected error reloading persisted state: %v", err)
+		}
+		if len(reloaded.Reports["latency"]) != 1 {
+			t.Fatalf("expected 1 aggregated report in persisted state, got %v", len(reloaded.Reports["latency"]))
+		}
+		dv := reloaded.Reports["latency"][0].Value.DistributionValue
+		if dv == nil || dv.Count != 2 {
+			t.Fatalf("expected the persisted histogram to include both the seeded and late-merged observations, got %+v", dv)
+		}
+	})
+}
+
+// ingestObserverRecorder is a stats.Recorder that also implements stats.IngestObserver, recording
+// every ingestion-side observation an Aggregator reports to it.
+type ingestObserverRecorder struct {
+	stats.Recorder
+	received    int
+	rejected    map[string]int
+	bufferBytes int64
+}
+
+func (r *ingestObserverRecorder) ObserveReportReceived(metric string) {
+	r.received++
+}
+
+func (r *ingestObserverRecorder) ObserveReportRejected(metric string, reason string) {
+	if r.rejected == nil {
+		r.rejected = make(map[string]int)
+	}
+	r.rejected[reason]++
+}
+
+func (r *ingestObserverRecorder) ObserveBufferBytes(metric string, bytes int64) {
+	r.bufferBytes = bytes
+}
+
+func TestAggregator_IngestObserver(t *testing.T) {
+	ir := &ingestObserverRecorder{Recorder: stats.NewNoopRecorder()}
+	recorder := &aggregatorRecorder{}
+	agg := NewAggregator(distributionMetric, time.Hour, 0, 0, 0, "", "", recorder, persistence.NewMemoryPersistence(), ir, nil)
+
+	now := time.Now()
+	reports := []metrics.MetricReport{
+		{Name: "latency", StartTime: now, EndTime: now.Add(time.Second), Value: metrics.MetricValue{DoubleValue: util.NewFloat64(1)}},
+		{Name: "latency", StartTime: now.Add(time.Second), EndTime: now.Add(2 * time.Second), Value: metrics.MetricValue{DoubleValue: util.NewFloat64(4)}},
+		{Name: "latency", StartTime: now.Add(2 * time.Second), EndTime: now.Add(3 * time.Second), Value: metrics.MetricValue{DoubleValue: util.NewFloat64(4)}},
+	}
+	for _, r := range reports {
+		if err := agg.AddReport(r); err != nil {
+			t.Fatalf("unexpected error adding report: %v", err)
+		}
+	}
+	if ir.received != 3 {
+		t.Fatalf("expected 3 reports received, got %v", ir.received)
+	}
+	if ir.bufferBytes == 0 {
+		t.Fatalf("expected a non-zero buffer size after adding reports")
+	}
+
+	// An invalid report - wrong metric name - should be rejected without reaching the aggregator
+	// goroutine.
+	if err := agg.AddReport(metrics.MetricReport{Name: "wrong-metric"}); err == nil {
+		t.Fatal("expected an error adding a report for the wrong metric")
+	}
+	if got := ir.rejected["invalid"]; got != 1 {
+		t.Fatalf("expected 1 invalid rejection, got %v", got)
+	}
+
+	if err := agg.Release(); err != nil {
+		t.Fatalf("unexpected error releasing aggregator: %v", err)
+	}
+	if ir.bufferBytes == 0 {
+		t.Fatalf("expected a non-zero buffer size even after the close-time flush resets the bucket")
+	}
+
+	if err := agg.AddReport(metrics.MetricReport{Name: "latency", StartTime: now, EndTime: now}); err == nil {
+		t.Fatal("expected an error adding a report to a closed aggregator")
+	}
+	if got := ir.rejected["closed"]; got != 1 {
+		t.Fatalf("expected 1 closed rejection, got %v", got)
+	}
+}
+
+var sumMetric = metrics.Definition{
+	Name: "requests",
+	Type: metrics.IntType,
+}
+
+func TestBucket_AddReport_Lateness(t *testing.T) {
+	t.Run("merges a late report within tolerance", func(t *testing.T) {
+		b := newBucket(time.Unix(100, 0))
+		if _, err := b.addReport(metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(90, 0),
+			EndTime:   time.Unix(96, 0),
+			Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+		}, sumMetric, 10*time.Second, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(b.Reports["requests"]) != 1 {
+			t.Fatalf("expected the report to be buffered, got %v entries", len(b.Reports["requests"]))
+		}
+	})
+
+	t.Run("rejects a report older than tolerance", func(t *testing.T) {
+		b := newBucket(time.Unix(100, 0))
+		_, err := b.addReport(metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(80, 0),
+			EndTime:   time.Unix(85, 0),
+			Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+		}, sumMetric, 10*time.Second, "")
+		if err == nil || !strings.Contains(err.Error(), "too late") {
+			t.Fatalf("expected a \"too late\" error, got: %v", err)
+		}
+	})
+}
+
+func TestBucket_AddReport_ClientIdDedup(t *testing.T) {
+	b := newBucket(time.Unix(0, 0))
+	report := metrics.MetricReport{
+		Name:      "requests",
+		StartTime: time.Unix(0, 0),
+		EndTime:   time.Unix(1, 0),
+		Value:     metrics.MetricValue{Int64Value: util.NewInt64(5)},
+		ClientId:  "req-1",
+	}
+	if _, err := b.addReport(report, sumMetric, 0, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Replay the exact same report, as a client that retried after losing the response to a
+	// successful AddReport call might.
+	if _, err := b.addReport(report, sumMetric, 0, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := *b.Reports["requests"][0].Value.Int64Value; got != 5 {
+		t.Fatalf("expected the replay to be dropped, leaving the value at 5, got %v", got)
+	}
+}
+
+func lateReport() metrics.MetricReport {
+	return metrics.MetricReport{
+		Name:      "requests",
+		StartTime: time.Unix(80, 0),
+		EndTime:   time.Unix(85, 0),
+		Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+	}
+}
+
+func TestBucket_AddReport_OnTimeConflict(t *testing.T) {
+	t.Run("merge tolerates and merges a report outside latenessTolerance", func(t *testing.T) {
+		b := newBucket(time.Unix(100, 0))
+		existing := metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(90, 0),
+			EndTime:   time.Unix(95, 0),
+			Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+		}
+		if _, err := b.addReport(existing, sumMetric, 10*time.Second, ""); err != nil {
+			t.Fatalf("unexpected error seeding bucket: %v", err)
+		}
+		if deadLettered, err := b.addReport(lateReport(), sumMetric, 10*time.Second, config.OnTimeConflictMerge); err != nil || deadLettered {
+			t.Fatalf("unexpected result: deadLettered=%v err=%v", deadLettered, err)
+		}
+		if len(b.Reports["requests"]) != 1 {
+			t.Fatalf("expected the late report to merge into the existing entry, got %v entries", len(b.Reports["requests"]))
+		}
+		if got := *b.Reports["requests"][0].Value.Int64Value; got != 2 {
+			t.Fatalf("expected the merged value to be 2, got %v", got)
+		}
+	})
+
+	t.Run("split tolerates but starts a new entry rather than merging", func(t *testing.T) {
+		b := newBucket(time.Unix(100, 0))
+		existing := metrics.MetricReport{
+			Name:      "requests",
+			StartTime: time.Unix(90, 0),
+			EndTime:   time.Unix(95, 0),
+			Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+		}
+		if _, err := b.addReport(existing, sumMetric, 10*time.Second, ""); err != nil {
+			t.Fatalf("unexpected error seeding bucket: %v", err)
+		}
+		if deadLettered, err := b.addReport(lateReport(), sumMetric, 10*time.Second, config.OnTimeConflictSplit); err != nil || deadLettered {
+			t.Fatalf("unexpected result: deadLettered=%v err=%v", deadLettered, err)
+		}
+		if len(b.Reports["requests"]) != 2 {
+			t.Fatalf("expected the late report to start a sibling entry, got %v entries", len(b.Reports["requests"]))
+		}
+	})
+
+	t.Run("dead-letter leaves the bucket untouched and reports deadLettered", func(t *testing.T) {
+		b := newBucket(time.Unix(100, 0))
+		deadLettered, err := b.addReport(lateReport(), sumMetric, 10*time.Second, config.OnTimeConflictDeadLetter)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !deadLettered {
+			t.Fatal("expected deadLettered to be true")
+		}
+		if len(b.Reports["requests"]) != 0 {
+			t.Fatalf("expected the bucket to remain empty, got %v entries", len(b.Reports["requests"]))
+		}
+	})
+}
+
+func TestAggregator_OnTimeConflictDeadLetter(t *testing.T) {
+	p := persistence.NewMemoryPersistence()
+	recorder := &aggregatorRecorder{}
+	ir := &ingestObserverRecorder{Recorder: stats.NewNoopRecorder()}
+	agg := newAggregator(sumMetric, time.Hour, 0, 0, 0, config.OnTimeConflictDeadLetter, "", recorder, p, ir, clock.NewClock(), nil, 0, nil)
+
+	if err := agg.AddReport(lateReport()); err != nil {
+		t.Fatalf("unexpected error adding a dead-lettered report: %v", err)
+	}
+	if got := ir.rejected["dead_lettered"]; got != 1 {
+		t.Fatalf("expected 1 dead_lettered rejection, got %v", got)
+	}
+
+	var deadLettered []metrics.MetricReport
+	if err := p.Value(persistencePrefix + sumMetric.Name + "/latereports").Load(&deadLettered); err != nil {
+		t.Fatalf("unexpected error loading dead-lettered reports: %v", err)
+	}
+	if len(deadLettered) != 1 || *deadLettered[0].Value.Int64Value != 1 {
+		t.Fatalf("expected the late report to be persisted, got %+v", deadLettered)
+	}
+
+	if err := agg.Release(); err != nil {
+		t.Fatalf("unexpected error releasing aggregator: %v", err)
+	}
+	if len(recorder.reports) != 0 {
+		t.Fatalf("expected no reports to reach the downstream input, got %v", len(recorder.reports))
+	}
+}
+
+func TestAggregator_TenantLabel(t *testing.T) {
+	t.Run("multiplexes aggregation across tenants sharing the same name and labels", func(t *testing.T) {
+		recorder := &aggregatorRecorder{}
+		agg := NewAggregator(sumMetric, time.Hour, 0, 0, 0, "", "tenant", recorder, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), nil)
+
+		now := time.Now()
+		for _, tenant := range []string{"a", "a", "b"} {
+			report := metrics.MetricReport{
+				Name:      "requests",
+				StartTime: now,
+				EndTime:   now.Add(time.Second),
+				Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+				Labels:    map[string]string{"tenant": tenant},
+			}
+			if err := agg.AddReport(report); err != nil {
+				t.Fatalf("unexpected error adding report for tenant %v: %v", tenant, err)
+			}
+		}
+
+		if err := agg.Release(); err != nil {
+			t.Fatalf("unexpected error releasing aggregator: %v", err)
+		}
+
+		if len(recorder.reports) != 2 {
+			t.Fatalf("expected 2 finished reports (one per tenant), got %v", len(recorder.reports))
+		}
+		byTenant := make(map[string]int64)
+		for _, r := range recorder.reports {
+			byTenant[r.Labels["tenant"]] = *r.Value.Int64Value
+		}
+		if byTenant["a"] != 2 {
+			t.Fatalf("expected tenant a's aggregated value to be 2, got %v", byTenant["a"])
+		}
+		if byTenant["b"] != 1 {
+			t.Fatalf("expected tenant b's aggregated value to be 1, got %v", byTenant["b"])
+		}
+	})
+
+	t.Run("rejects a reserved tenant id", func(t *testing.T) {
+		agg := NewAggregator(sumMetric, time.Hour, 0, 0, 0, "", "tenant", &aggregatorRecorder{}, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), nil)
+		defer agg.Release()
+
+		now := time.Now()
+		err := agg.AddReport(metrics.MetricReport{
+			Name:      "requests",
+			StartTime: now,
+			EndTime:   now.Add(time.Second),
+			Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+			Labels:    map[string]string{"tenant": config.SystemTenantId},
+		})
+		if err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Fatalf("expected a reserved tenant id error, got: %v", err)
+		}
+	})
+
+	t.Run("persists each tenant's bucket under a distinct key and reloads it after a restart", func(t *testing.T) {
+		p := persistence.NewMemoryPersistence()
+		recorder := &aggregatorRecorder{}
+		agg := NewAggregator(sumMetric, time.Hour, 0, 0, 0, "", "tenant", recorder, p, stats.NewNoopRecorder(), nil)
+
+		now := time.Now()
+		for _, tenant := range []string{"a", "b"} {
+			report := metrics.MetricReport{
+				Name:      "requests",
+				StartTime: now,
+				EndTime:   now.Add(time.Second),
+				Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+				Labels:    map[string]string{"tenant": tenant},
+			}
+			if err := agg.AddReport(report); err != nil {
+				t.Fatalf("unexpected error adding report for tenant %v: %v", tenant, err)
+			}
+		}
+
+		var bucketA bucket
+		if err := p.Value(persistencePrefix + sumMetric.Name + "/a").Load(&bucketA); err != nil {
+			t.Fatalf("unexpected error loading tenant a's persisted bucket: %v", err)
+		}
+		if len(bucketA.Reports["requests"]) != 1 {
+			t.Fatalf("expected tenant a's persisted bucket to hold 1 entry, got %v", len(bucketA.Reports["requests"]))
+		}
+
+		// Simulate a restart: a fresh Aggregator over the same persistence should reload both
+		// tenants' buckets via the persisted tenant index.
+		restarted := NewAggregator(sumMetric, time.Hour, 0, 0, 0, "", "tenant", &aggregatorRecorder{}, p, stats.NewNoopRecorder(), nil)
+		if len(restarted.buckets) != 2 {
+			t.Fatalf("expected 2 reloaded tenant buckets, got %v", len(restarted.buckets))
+		}
+		if err := restarted.Release(); err != nil {
+			t.Fatalf("unexpected error releasing restarted aggregator: %v", err)
+		}
+
+		if err := agg.Release(); err != nil {
+			t.Fatalf("unexpected error releasing aggregator: %v", err)
+		}
+	})
+}
+
+func TestAggregator_MaxPersistInterval(t *testing.T) {
+	p := persistence.NewMemoryPersistence()
+	recorder := &aggregatorRecorder{}
+	agg := NewAggregator(sumMetric, time.Hour, 0, time.Hour, 0, "", "", recorder, p, stats.NewNoopRecorder(), nil)
+
+	now := time.Now()
+	if err := agg.AddReport(metrics.MetricReport{
+		Name:      "requests",
+		StartTime: now,
+		EndTime:   now.Add(time.Second),
+		Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+	}); err != nil {
+		t.Fatalf("unexpected error adding report: %v", err)
+	}
+
+	// With a 1-hour maxPersistInterval, the bucket should still be dirty - not yet written to
+	// persistence - immediately after AddReport returns.
+	if err := p.Value(persistencePrefix + sumMetric.Name).Load(&bucket{}); err != persistence.ErrNotFound {
+		t.Fatalf("expected the dirty bucket to not be persisted yet, got err: %v", err)
+	}
+
+	// Release always flushes and persists regardless of maxPersistInterval.
+	if err := agg.Release(); err != nil {
+		t.Fatalf("unexpected error releasing aggregator: %v", err)
+	}
+	if len(recorder.reports) != 1 {
+		t.Fatalf("expected exactly one finished report, got %v", len(recorder.reports))
+	}
+}
+
+func TestAggregator_Backpressure(t *testing.T) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	var once sync.Once
+	blocking := &blockingInput{
+		addReport: func(metrics.MetricReport) error {
+			once.Do(func() { close(entered) })
+			<-unblock
+			return nil
+		},
+	}
+
+	// maxBucketEntries of 1 forces an early flush - and a blocking call into the downstream Input -
+	// on the very first report.
+	agg := newAggregator(sumMetric, time.Hour, 1, 0, 0, "", "", blocking, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), clock.NewClock(), nil, 0, nil)
+
+	now := time.Now()
+	firstReport := func(tenant string) metrics.MetricReport {
+		return metrics.MetricReport{
+			Name:      "requests",
+			StartTime: now,
+			EndTime:   now.Add(time.Second),
+			Value:     metrics.MetricValue{Int64Value: util.NewInt64(1)},
+		}
+	}
+	if err := agg.AddReport(firstReport("")); err != nil {
+		t.Fatalf("unexpected error adding the first report: %v", err)
+	}
+	<-entered // The aggregator's goroutine is now blocked inside the downstream Input.
+
+	// Flood the aggregator with more reports than it can buffer while its goroutine is stuck.
+	results := make(chan error, addQueueCapacity+5)
+	for i := 0; i < addQueueCapacity+5; i++ {
+		go func() {
+			results <- agg.AddReport(firstReport(""))
+		}()
+	}
+
+	// Give every goroutine a chance to attempt its send before we inspect the results; those that
+	// don't fit in the buffered add channel return ErrBackpressure immediately, without waiting.
+	time.Sleep(200 * time.Millisecond)
+	var backpressured int
+	for i := 0; i < addQueueCapacity+5; i++ {
+		select {
+		case err := <-results:
+			if err == ErrBackpressure {
+				backpressured++
+			}
+		default:
+		}
+	}
+	if backpressured != 5 {
+		t.Fatalf("expected exactly 5 reports rejected with ErrBackpressure, got %v", backpressured)
+	}
+
+	close(unblock)
+	// Drain the remaining results, now that the aggregator's goroutine is free to process them.
+	for i := 0; i < addQueueCapacity; i++ {
+		<-results
+	}
+	if err := agg.Release(); err != nil {
+		t.Fatalf("unexpected error releasing aggregator: %v", err)
+	}
+}
+
+// blockingInput is a pipeline.Input whose AddReport delegates to a caller-supplied function, used
+// to simulate a downstream Input that's slow to accept reports.
+type blockingInput struct {
+	addReport func(metrics.MetricReport) error
+}
+
+func (b *blockingInput) AddReport(report metrics.MetricReport) error { return b.addReport(report) }
+
+func (b *blockingInput) Use() {}
+
+func (b *blockingInput) Release() error { return nil }
+
+func TestAggregator_Distributed(t *testing.T) {
+	t.Run("only the lease holder forwards reports downstream, the other diverts into the shared queue", func(t *testing.T) {
+		p := persistence.NewMemoryPersistence()
+		recorder := &aggregatorRecorder{}
+
+		holder := newAggregator(sumMetric, time.Hour, 0, 0, 0, "", "", recorder, p, stats.NewNoopRecorder(), clock.NewClock(), persistence.NewLease(p, "lease", "a"), time.Minute, nil)
+		other := newAggregator(sumMetric, time.Hour, 0, 0, 0, "", "", recorder, p, stats.NewNoopRecorder(), clock.NewClock(), persistence.NewLease(p, "lease", "b"), time.Minute, nil)
+
+		if !holder.isHolder {
+			t.Fatalf("expected the first aggregator to acquire the lease")
+		}
+		if other.isHolder {
+			t.Fatalf("expected the second aggregator to not acquire the already-held lease")
+		}
+
+		now := time.Now()
+		report := func(v int64) metrics.MetricReport {
+			return metrics.MetricReport{
+				Name:      "requests",
+				StartTime: now,
+				EndTime:   now.Add(time.Second),
+				Value:     metrics.MetricValue{Int64Value: util.NewInt64(v)},
+			}
+		}
+		if err := holder.AddReport(report(1)); err != nil {
+			t.Fatalf("unexpected error adding report to holder: %v", err)
+		}
+		if err := other.AddReport(report(2)); err != nil {
+			t.Fatalf("unexpected error adding report to non-holder: %v", err)
+		}
+
+		if err := holder.Release(); err != nil {
+			t.Fatalf("unexpected error releasing holder: %v", err)
+		}
+		if reports := recorder.Reports(); len(reports) != 1 || *reports[0].Value.Int64Value != 3 {
+			t.Fatalf("expected a single aggregated report summing both replicas' reports, got %+v", reports)
+		}
+		if err := other.Release(); err != nil {
+			t.Fatalf("unexpected error releasing non-holder: %v", err)
+		}
+	})
+
+	t.Run("another replica takes over once the holder's lease expires and resumes from persisted state", func(t *testing.T) {
+		p := persistence.NewMemoryPersistence()
+		leaseA := persistence.NewLease(p, "lease", "a")
+		leaseB := persistence.NewLease(p, "lease", "b")
+
+		now := time.Now()
+		acquired, err := leaseA.TryAcquire(now, time.Minute)
+		if err != nil || !acquired {
+			t.Fatalf("expected replica a to acquire the lease, got acquired=%v err=%v", acquired, err)
+		}
+
+		// Replica b shouldn't be able to take over while replica a's lease is still unexpired.
+		acquired, err = leaseB.TryAcquire(now, time.Minute)
+		if err != nil || acquired {
+			t.Fatalf("expected replica b to fail to acquire the still-held lease, got acquired=%v err=%v", acquired, err)
+		}
+
+		// Once the lease's TTL has elapsed - simulating replica a crashing without calling Release -
+		// replica b should be able to take it over.
+		acquired, err = leaseB.TryAcquire(now.Add(time.Minute+time.Second), time.Minute)
+		if err != nil || !acquired {
+			t.Fatalf("expected replica b to take over the expired lease, got acquired=%v err=%v", acquired, err)
+		}
+	})
+}
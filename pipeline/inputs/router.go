@@ -0,0 +1,194 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inputs
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/hashicorp/go-multierror"
+)
+
+// RouteRule pairs a match predicate with the Inputs that a matching report is sent to. See Router.
+type RouteRule struct {
+	// MetricPattern, if non-empty, is matched against a report's metric name according to
+	// MatchType. Empty matches any metric name.
+	MetricPattern string
+
+	// MatchType selects how MetricPattern is interpreted. The zero value behaves like
+	// config.MatchGlob.
+	MatchType config.MatchType
+
+	// Labels, if non-empty, requires every entry here to equal the report's corresponding label.
+	Labels map[string]string
+
+	// Targets receive every report that matches this rule.
+	Targets []pipeline.Input
+
+	// Stop, if true, tells the owning Router not to evaluate any rule after this one once this rule
+	// has matched.
+	Stop bool
+
+	// regex holds MetricPattern precompiled, when MatchType is config.MatchRegex. It's populated by
+	// compile, once, before the rule is ever matched against a report - so a Router's steady-state
+	// AddReport path never pays regexp compilation cost.
+	regex *regexp.Regexp
+}
+
+// compile precompiles MetricPattern if MatchType requires it. It must be called once before the
+// rule is used; NewRouter does this for every rule it's given.
+func (r *RouteRule) compile() error {
+	if r.MatchType != config.MatchRegex || r.MetricPattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(r.MetricPattern)
+	if err != nil {
+		return fmt.Errorf("router: invalid regex %q: %v", r.MetricPattern, err)
+	}
+	r.regex = re
+	return nil
+}
+
+func (r *RouteRule) matches(report metrics.MetricReport) bool {
+	if r.MetricPattern != "" {
+		switch r.MatchType {
+		case config.MatchRegex:
+			if !r.regex.MatchString(report.Name) {
+				return false
+			}
+		case config.MatchExact:
+			if report.Name != r.MetricPattern {
+				return false
+			}
+		default: // config.MatchGlob, and "" for backward compatibility.
+			if ok, _ := path.Match(r.MetricPattern, report.Name); !ok {
+				return false
+			}
+		}
+	}
+	for k, v := range r.Labels {
+		if report.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Router is a pipeline.Input that routes a MetricReport to one or more other pipeline.Input
+// objects, based on an ordered list of match rules evaluated against the report's metric name and
+// labels. Every rule that matches delivers the report to its Targets; evaluation then continues to
+// the next rule, letting one report fan out across several rules, unless the matching rule has
+// Stop set, which ends evaluation there. A report matching no rule is sent to fallback, or -  if
+// fallback is nil - rejected with an error.
+//
+// This allows splitting a single metric stream across distinct downstream pipelines by pattern,
+// label, or both (for example, mirroring a metric to both an aggregator and a raw disk archive),
+// which a plain Selector - limited to routing by exact metric name to a single Input - cannot do.
+type Router struct {
+	rules    []RouteRule
+	fallback pipeline.Input
+	tracker  pipeline.UsageTracker
+}
+
+func (r *Router) AddReport(report metrics.MetricReport) error {
+	var matched bool
+	var errs *multierror.Error
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !rule.matches(report) {
+			continue
+		}
+		matched = true
+		for _, t := range rule.Targets {
+			errs = multierror.Append(errs, t.AddReport(report))
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	if !matched {
+		if r.fallback != nil {
+			return r.fallback.AddReport(report)
+		}
+		return fmt.Errorf("router: no route for metric: %v", report.Name)
+	}
+	return errs.ErrorOrNil()
+}
+
+// Use increments the Router's usage count.
+// See pipeline.Component.Use.
+func (r *Router) Use() {
+	r.tracker.Use()
+}
+
+// Release decrements the Router's usage count. If it reaches 0, Release releases every unique
+// downstream Input exactly once, concurrently, and waits for the operations to finish.
+// See pipeline.Component.Release.
+func (r *Router) Release() error {
+	return r.tracker.Release(func() error {
+		targets := r.targets()
+		errors := make([]error, len(targets))
+		wg := sync.WaitGroup{}
+		wg.Add(len(targets))
+		for i, t := range targets {
+			go func(i int, t pipeline.Input) {
+				errors[i] = t.Release()
+				wg.Done()
+			}(i, t)
+		}
+		wg.Wait()
+		return multierror.Append(nil, errors...).ErrorOrNil()
+	})
+}
+
+// targets returns the de-duplicated set of Inputs this Router can route to, including fallback.
+func (r *Router) targets() []pipeline.Input {
+	seen := make(map[pipeline.Input]bool)
+	var targets []pipeline.Input
+	for i := range r.rules {
+		for _, t := range r.rules[i].Targets {
+			if !seen[t] {
+				seen[t] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+	if r.fallback != nil && !seen[r.fallback] {
+		targets = append(targets, r.fallback)
+	}
+	return targets
+}
+
+// NewRouter creates a Router that evaluates rules, in order, against each incoming report,
+// fanning it out to every matching rule's Targets. Reports matching no rule are sent to fallback,
+// which may be nil. NewRouter precompiles every rule's regex (config.MatchRegex) pattern, and
+// returns an error if one fails to compile. It calls Use on every unique Target and on fallback.
+func NewRouter(rules []RouteRule, fallback pipeline.Input) (pipeline.Input, error) {
+	r := &Router{rules: rules, fallback: fallback}
+	for i := range r.rules {
+		if err := r.rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range r.targets() {
+		t.Use()
+	}
+	return r, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inputs
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+// routeRecorder is a minimal pipeline.Input that records every report it receives, used to
+// exercise Router without depending on a full Aggregator/InputAdapter stack.
+type routeRecorder struct {
+	reports  []metrics.MetricReport
+	used     int
+	released int
+}
+
+func (r *routeRecorder) AddReport(report metrics.MetricReport) error {
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+func (r *routeRecorder) Use() {
+	r.used++
+}
+
+func (r *routeRecorder) Release() error {
+	r.released++
+	return nil
+}
+
+func newRouter(t *testing.T, rules []RouteRule, fallback pipeline.Input) pipeline.Input {
+	t.Helper()
+	router, err := NewRouter(rules, fallback)
+	if err != nil {
+		t.Fatalf("NewRouter: unexpected error: %v", err)
+	}
+	return router
+}
+
+func TestRouter_StopEndsEvaluation(t *testing.T) {
+	tenantA := &routeRecorder{}
+	tenantB := &routeRecorder{}
+	fallback := &routeRecorder{}
+
+	router := newRouter(t, []RouteRule{
+		{MetricPattern: "requests", Labels: map[string]string{"tenant": "a"}, Targets: []pipeline.Input{tenantA}, Stop: true},
+		{MetricPattern: "requests", Labels: map[string]string{"tenant": "b"}, Targets: []pipeline.Input{tenantB}, Stop: true},
+	}, fallback)
+
+	if err := router.AddReport(metrics.MetricReport{Name: "requests", Labels: map[string]string{"tenant": "a"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.AddReport(metrics.MetricReport{Name: "requests", Labels: map[string]string{"tenant": "b"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.AddReport(metrics.MetricReport{Name: "requests", Labels: map[string]string{"tenant": "c"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tenantA.reports) != 1 || len(tenantB.reports) != 1 || len(fallback.reports) != 1 {
+		t.Fatalf("expected one report routed to each of tenantA, tenantB, and fallback; got %v, %v, %v",
+			len(tenantA.reports), len(tenantB.reports), len(fallback.reports))
+	}
+}
+
+func TestRouter_FanOutAcrossRules(t *testing.T) {
+	aggregator := &routeRecorder{}
+	archive := &routeRecorder{}
+
+	// Neither rule sets Stop, so a report matching both is delivered to both targets.
+	router := newRouter(t, []RouteRule{
+		{MetricPattern: "requests", Targets: []pipeline.Input{aggregator}},
+		{MetricPattern: "requests", Targets: []pipeline.Input{archive}},
+	}, nil)
+
+	if err := router.AddReport(metrics.MetricReport{Name: "requests"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(aggregator.reports) != 1 || len(archive.reports) != 1 {
+		t.Fatalf("expected the report fanned out to both targets; got %v, %v", len(aggregator.reports), len(archive.reports))
+	}
+}
+
+func TestRouter_FanOutWithinRule(t *testing.T) {
+	aggregator := &routeRecorder{}
+	archive := &routeRecorder{}
+
+	router := newRouter(t, []RouteRule{
+		{MetricPattern: "requests", Targets: []pipeline.Input{aggregator, archive}},
+	}, nil)
+
+	if err := router.AddReport(metrics.MetricReport{Name: "requests"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(aggregator.reports) != 1 || len(archive.reports) != 1 {
+		t.Fatalf("expected the report delivered to both targets of the one rule; got %v, %v", len(aggregator.reports), len(archive.reports))
+	}
+}
+
+func TestRouter_GlobMatching(t *testing.T) {
+	target := &routeRecorder{}
+	router := newRouter(t, []RouteRule{{MetricPattern: "http.*", Targets: []pipeline.Input{target}}}, nil)
+
+	if err := router.AddReport(metrics.MetricReport{Name: "http.requests"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.AddReport(metrics.MetricReport{Name: "grpc.requests"}); err == nil {
+		t.Fatal("expected an error for a report matching no route and no fallback")
+	}
+	if len(target.reports) != 1 {
+		t.Fatalf("expected one report routed to target, got %v", len(target.reports))
+	}
+}
+
+func TestRouter_ExactMatching(t *testing.T) {
+	target := &routeRecorder{}
+	router := newRouter(t, []RouteRule{
+		{MetricPattern: "http.requests", MatchType: config.MatchExact, Targets: []pipeline.Input{target}},
+	}, nil)
+
+	if err := router.AddReport(metrics.MetricReport{Name: "http.requests"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.AddReport(metrics.MetricReport{Name: "http.requestsx"}); err == nil {
+		t.Fatal("expected an error for a report matching no route and no fallback")
+	}
+	if len(target.reports) != 1 {
+		t.Fatalf("expected one report routed to target, got %v", len(target.reports))
+	}
+}
+
+func TestRouter_RegexMatching(t *testing.T) {
+	target := &routeRecorder{}
+	router := newRouter(t, []RouteRule{
+		{MetricPattern: `^billing\.`, MatchType: config.MatchRegex, Targets: []pipeline.Input{target}},
+	}, nil)
+
+	if err := router.AddReport(metrics.MetricReport{Name: "billing.usage"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.AddReport(metrics.MetricReport{Name: "other.billing.usage"}); err == nil {
+		t.Fatal("expected an error for a report matching no route and no fallback")
+	}
+	if len(target.reports) != 1 {
+		t.Fatalf("expected one report routed to target, got %v", len(target.reports))
+	}
+}
+
+func TestRouter_InvalidRegexReturnsError(t *testing.T) {
+	if _, err := NewRouter([]RouteRule{
+		{MetricPattern: "(", MatchType: config.MatchRegex, Targets: []pipeline.Input{&routeRecorder{}}},
+	}, nil); err == nil {
+		t.Fatal("expected an error for an invalid regex")
+	}
+}
+
+func TestRouter_NoFallbackReturnsError(t *testing.T) {
+	router := newRouter(t, nil, nil)
+	if err := router.AddReport(metrics.MetricReport{Name: "unrouted"}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// BenchmarkRouter_RegexMatching exercises the regex path to confirm MetricPattern's precompiled
+// regexp, not per-report compilation, is what AddReport evaluates.
+func BenchmarkRouter_RegexMatching(b *testing.B) {
+	target := &routeRecorder{}
+	router, err := NewRouter([]RouteRule{
+		{MetricPattern: `^billing\.[a-z]+\.usage$`, MatchType: config.MatchRegex, Targets: []pipeline.Input{target}},
+	}, nil)
+	if err != nil {
+		b.Fatalf("NewRouter: unexpected error: %v", err)
+	}
+	report := metrics.MetricReport{Name: "billing.storage.usage"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := router.AddReport(report); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestRouter_ReleasesEachTargetOnce(t *testing.T) {
+	shared := &routeRecorder{}
+	fallback := &routeRecorder{}
+
+	router := newRouter(t, []RouteRule{
+		{MetricPattern: "a", Targets: []pipeline.Input{shared}},
+		{MetricPattern: "b", Targets: []pipeline.Input{shared}},
+	}, fallback)
+
+	router.Use()
+	if shared.used != 1 {
+		t.Fatalf("expected shared target to be Used exactly once, got %v", shared.used)
+	}
+
+	if err := router.Release(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.Release(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if shared.released != 1 {
+		t.Fatalf("expected shared target to be Released exactly once, got %v", shared.released)
+	}
+	if fallback.released != 1 {
+		t.Fatalf("expected fallback to be Released exactly once, got %v", fallback.released)
+	}
+}
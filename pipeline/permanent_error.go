@@ -0,0 +1,55 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline
+
+import "errors"
+
+// PermanentError wraps an error to mark it as non-retryable - for example a 4xx response or a
+// schema-validation failure that will never succeed no matter how many times it's retried. An
+// Endpoint's IsTransient can check for one with IsPermanent, rather than repeating the same
+// protocol-specific classification at every call site that already knows the answer.
+type PermanentError struct {
+	cause error
+}
+
+// NewPermanentError wraps cause as a PermanentError. A nil cause returns nil, so callers can wrap
+// the result of a fallible operation without a separate nil check.
+func NewPermanentError(cause error) error {
+	if cause == nil {
+		return nil
+	}
+	return &PermanentError{cause: cause}
+}
+
+func (e *PermanentError) Error() string {
+	return e.cause.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.cause
+}
+
+// Temporary reports false, satisfying the net.Error-style "Temporary() bool" convention some HTTP
+// and gRPC client libraries check.
+func (e *PermanentError) Temporary() bool {
+	return false
+}
+
+// IsPermanent reports whether err - or any error in its Unwrap chain - was wrapped with
+// NewPermanentError.
+func IsPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pipeline_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+func TestPermanentError(t *testing.T) {
+	cause := errors.New("400 Bad Request")
+	err := pipeline.NewPermanentError(cause)
+
+	if err.Error() != cause.Error() {
+		t.Errorf("err.Error() = %v, want %v", err.Error(), cause.Error())
+	}
+	if !errors.Is(err, cause) {
+		t.Error("errors.Is(err, cause) = false, want true")
+	}
+	if !pipeline.IsPermanent(err) {
+		t.Error("IsPermanent(err) = false, want true")
+	}
+
+	var pe *pipeline.PermanentError
+	if !errors.As(err, &pe) {
+		t.Fatal("errors.As(err, &pe) = false, want true")
+	}
+	if pe.Temporary() {
+		t.Error("pe.Temporary() = true, want false")
+	}
+}
+
+func TestPermanentError_NilCause(t *testing.T) {
+	if err := pipeline.NewPermanentError(nil); err != nil {
+		t.Errorf("NewPermanentError(nil) = %v, want nil", err)
+	}
+}
+
+func TestIsPermanent_UnwrappedError(t *testing.T) {
+	if pipeline.IsPermanent(errors.New("some ordinary error")) {
+		t.Error("IsPermanent(ordinary error) = true, want false")
+	}
+	if pipeline.IsPermanent(nil) {
+		t.Error("IsPermanent(nil) = true, want false")
+	}
+}
@@ -17,15 +17,17 @@
 // A fully-constructed pipeline consists of an aggregator, a dispatcher, and one or more endpoints
 // wrapped in RetryingSender objects:
 //
-//                          -> RetryingSender -> Endpoint A
+//	-> RetryingSender -> Endpoint A
+//
 // Aggregator -> Dispatcher -> RetryingSender -> Endpoint B
-//                          -> RetryingSender -> Endpoint C
 //
+//	-> RetryingSender -> Endpoint C
 package pipeline
 
 import (
 	"sync"
 
+	"github.com/GoogleCloudPlatform/ubbagent/config"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 )
 
@@ -63,11 +65,24 @@ type Component interface {
 	Release() error
 }
 
+// Reconfigurable is an optional interface a Component may implement to accept certain config
+// changes in place, preserving whatever in-flight or buffered state it holds, rather than being
+// torn down and replaced with a freshly-built component. A config reload tries ApplyConfig, where
+// supported, before falling back to its usual rebuild-or-reuse decision.
+type Reconfigurable interface {
+	// ApplyConfig attempts to bring this component's configuration from old to new in place. It
+	// returns an error if some part of the change can't be applied this way - for example, one that
+	// would require constructing a different kind of component - in which case the caller should
+	// fall back to rebuilding the component instead. ApplyConfig leaves the component unchanged on
+	// error.
+	ApplyConfig(old, new *config.Config) error
+}
+
 // Type UsageTracker is a utility that helps track the usage of a Component. It provides Use and
 // Release methods, and calls a close function when Release decrements the usage count to 0.
 type UsageTracker struct {
 	count int
-	mu sync.Mutex
+	mu    sync.Mutex
 }
 
 func (u *UsageTracker) Use() {
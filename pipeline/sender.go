@@ -15,9 +15,25 @@
 package pipeline
 
 import (
+	"context"
+	"time"
+
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 )
 
+// PreparedSend is returned by Sender.Prepare and represents a report that has passed a sender's
+// pre-send validation/build step but has not yet been durably committed - see Sender.Prepare.
+type PreparedSend interface {
+	// Send durably commits the prepared report. For a RetryingSender, this enqueues the report
+	// (and syncs the change to disk) so that it survives a crash and is retried until delivered.
+	Send() error
+
+	// Payload returns the serialized form of this prepared send. It's persisted by a Dispatcher
+	// fanning out to multiple Senders, so that a crash between Prepare and Send can be resumed by
+	// passing it to the owning Sender's SendPrepared.
+	Payload() ([]byte, error)
+}
+
 // A Sender handles sending StampedMetricReports to remote endpoints.
 type Sender interface {
 	// Sender is a pipeline.Component.
@@ -26,7 +42,7 @@ type Sender interface {
 	// Send sends the report downstream. The behavior of the Send operation depends on the type of
 	// sender. Some implementations - the Dispatcher, for instance - simply forward the Send to
 	// subsequent Senders. Others - like the RetryingSender - may queue the report and attempt to
-	// send it at a later time.
+	// send it at a later time. Send is equivalent to calling Prepare and then Send on the result.
 	//
 	// An error indicates that something failed quickly, but it does not
 	// indicate that the operation failed completely (i.e., some senders behind a Dispatcher may have
@@ -34,8 +50,68 @@ type Sender interface {
 	// succeeded, due to the asynchronous nature of a RetryingSender.
 	Send(report metrics.StampedMetricReport) error
 
+	// Prepare splits Send into its two phases, similar to a two-phase commit: Prepare runs report
+	// through this sender's pre-send validation/build step without committing it, returning a
+	// PreparedSend whose Send method performs the actual commit. A caller fanning out to multiple
+	// Senders - see Dispatcher - can call Prepare on all of them before committing any of them, so
+	// that a failure preparing one sender doesn't leave another having already committed.
+	Prepare(report metrics.StampedMetricReport) (PreparedSend, error)
+
+	// SendPrepared resumes a Prepare/Send that was interrupted by a crash, committing the
+	// PreparedSend whose Payload was persisted as payload. It must be idempotent: calling it again
+	// for an already-committed payload is a no-op.
+	SendPrepared(ctx context.Context, payload []byte) error
+
 	// Endpoints returns the transitive list of endpoints that this sender will ultimately send to.
 	Endpoints() []string
+
+	// AddWatcher registers w to observe the outcome of every report this Sender, and any Senders
+	// or Endpoints behind it, handles from this point forward. There's no way to remove a watcher.
+	AddWatcher(w SendWatcher)
+}
+
+// SendWatcher observes the outcome of reports handled by a Sender or Endpoint, independent of the
+// stats.Recorder used for aggregate send statistics. It's intended for callers that need to react
+// to or audit individual send outcomes - for example, surfacing real-time send telemetry or
+// feeding an external audit sink - without modifying pipeline internals.
+type SendWatcher interface {
+	// OnSent is called after report is successfully sent to the named endpoint.
+	OnSent(report metrics.StampedMetricReport, endpoint string)
+
+	// OnFailed is called after report fails to send to the named endpoint and no further retries
+	// will be attempted. transient indicates whether the failure was classified as retryable (and,
+	// e.g., gave up only because its retry queue time was exhausted) rather than permanent.
+	OnFailed(report metrics.StampedMetricReport, endpoint string, err error, transient bool)
+
+	// OnExpired is called when report is discarded by the named endpoint without ever being sent -
+	// for example, a DiskEndpoint removing a file whose retention period has elapsed.
+	OnExpired(report metrics.StampedMetricReport, endpoint string)
+}
+
+// CallbackWatcher adapts ad-hoc functions to the SendWatcher interface, for callers that only care
+// about one or two of its methods. A nil field behaves as a no-op.
+type CallbackWatcher struct {
+	Sent    func(report metrics.StampedMetricReport, endpoint string)
+	Failed  func(report metrics.StampedMetricReport, endpoint string, err error, transient bool)
+	Expired func(report metrics.StampedMetricReport, endpoint string)
+}
+
+func (w CallbackWatcher) OnSent(report metrics.StampedMetricReport, endpoint string) {
+	if w.Sent != nil {
+		w.Sent(report, endpoint)
+	}
+}
+
+func (w CallbackWatcher) OnFailed(report metrics.StampedMetricReport, endpoint string, err error, transient bool) {
+	if w.Failed != nil {
+		w.Failed(report, endpoint, err, transient)
+	}
+}
+
+func (w CallbackWatcher) OnExpired(report metrics.StampedMetricReport, endpoint string) {
+	if w.Expired != nil {
+		w.Expired(report, endpoint)
+	}
 }
 
 // Type InputAdapter is a pipeline.Input that converts incoming reports to StampedMetricReport
@@ -55,3 +131,41 @@ func (a *InputAdapter) Use() {
 func (a *InputAdapter) Release() error {
 	return a.Sender.Release()
 }
+
+// AddWatcher registers w on the InputAdapter's underlying Sender, so that callers holding only the
+// pipeline.Input returned by a builder can still observe send outcomes - for example, to surface
+// real-time send telemetry through a status endpoint, or to feed an external audit sink.
+func (a *InputAdapter) AddWatcher(w SendWatcher) {
+	a.Sender.AddWatcher(w)
+}
+
+// DeadLetterSink receives reports that a RetryingSender has given up on - either because they
+// expired past MaxQueueTime or because the endpoint's Retryer classified the send failure as
+// non-retryable - so operators can recover and reprocess lost usage events instead of losing them
+// silently.
+type DeadLetterSink interface {
+	// DeadLetterSink is a pipeline.Component.
+	Component
+
+	// DeadLetter hands off entry. A RetryingSender logs, but otherwise ignores, an error returned
+	// here: a sink that's temporarily unavailable shouldn't block or crash the send path.
+	DeadLetter(entry DeadLetterEntry) error
+}
+
+// DeadLetterEntry describes a single report a RetryingSender has given up on.
+type DeadLetterEntry struct {
+	// Endpoint is the name of the endpoint the report was being sent to.
+	Endpoint string
+
+	// Report is the raw EndpointReport that was being sent, as built by the endpoint's BuildReport.
+	Report EndpointReport
+
+	// FirstSeen is when the report was first enqueued for sending.
+	FirstSeen time.Time
+
+	// LastError is the error message from the final send attempt.
+	LastError string
+
+	// Attempts is the number of send attempts made before giving up, including the final one.
+	Attempts int
+}
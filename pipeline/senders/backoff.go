@@ -0,0 +1,168 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+// BackoffStrategy computes the delay a RetryingSender waits before each retry attempt. It's
+// resolved once, from config.RetryPolicy's BackoffPolicy, when the sender is constructed - see
+// backoffStrategyFor.
+type BackoffStrategy interface {
+	// Next returns the delay to wait before the given attempt (1-indexed: the first retry is
+	// attempt 1). lastDelay is the delay Next returned for the previous attempt, or zero before the
+	// first.
+	Next(attempt int, lastDelay time.Duration) time.Duration
+
+	// Reset clears any state a strategy has accumulated across a run of failures, so the next Next
+	// call following a success behaves as if this were attempt 1 again. None of the strategies
+	// below hold such state - attempt and lastDelay already carry everything they need - but Reset
+	// is part of the interface so a future stateful strategy (e.g. one wrapping a third-party
+	// backoff library with its own internal clock) has somewhere to hook in.
+	Reset()
+}
+
+// backoffStrategyFor returns the BackoffStrategy named by policy (one of the config.Backoff*
+// constants), falling back to full jitter for an empty or unrecognized policy. min and max bound
+// every strategy's returned delay; rnd is the sender's own rand.Rand, so callers can supply a
+// seeded source for deterministic tests.
+func backoffStrategyFor(policy string, min, max time.Duration, rnd *rand.Rand) BackoffStrategy {
+	switch policy {
+	case config.BackoffNone:
+		return &noneBackoff{min: min, max: max}
+	case config.BackoffEqualJitter:
+		return &equalJitterBackoff{min: min, max: max, rnd: rnd}
+	case config.BackoffDecorrelatedJitter:
+		return &decorrelatedBackoff{min: min, max: max, rnd: rnd}
+	case config.BackoffConstant:
+		return &constantBackoff{delay: min}
+	case config.BackoffLinear:
+		return &linearBackoff{min: min, max: max}
+	default:
+		return &fullJitterBackoff{min: min, max: max, rnd: rnd}
+	}
+}
+
+// ceilingFor returns the doubling, capped backoff ceiling for attempt: min*2^attempt, bounded to
+// [min, max]. It's shared by every exponential strategy below.
+func ceilingFor(attempt int, min, max time.Duration) time.Duration {
+	if attempt <= 0 {
+		return min
+	}
+	// A large attempt could overflow time.Duration's int64 via shifting; bounded's max clamp makes
+	// the exact overflowed value irrelevant, but we still need the shift itself not to panic or
+	// produce a nonsensical negative ceiling.
+	const maxShift = 62
+	if attempt > maxShift {
+		return max
+	}
+	return bounded(min<<uint(attempt), min, max)
+}
+
+// fullJitterBackoff picks each delay uniformly at random from [min, ceiling]: spreading retries
+// across the whole backoff window, rather than adding a small amount of jitter on top of a
+// deterministic delay, avoids many queued entries retrying in lockstep. See
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.
+type fullJitterBackoff struct {
+	min, max time.Duration
+	rnd      *rand.Rand
+}
+
+func (b *fullJitterBackoff) Next(attempt int, lastDelay time.Duration) time.Duration {
+	ceiling := ceilingFor(attempt, b.min, b.max)
+	if ceiling <= b.min {
+		return b.min
+	}
+	return b.min + time.Duration(b.rnd.Int63n(int64(ceiling-b.min)+1))
+}
+
+func (b *fullJitterBackoff) Reset() {}
+
+// equalJitterBackoff picks each delay uniformly at random from [ceiling/2, ceiling]: it spreads
+// retries less widely than fullJitterBackoff, but never lets a delay drop below half the ceiling.
+type equalJitterBackoff struct {
+	min, max time.Duration
+	rnd      *rand.Rand
+}
+
+func (b *equalJitterBackoff) Next(attempt int, lastDelay time.Duration) time.Duration {
+	ceiling := ceilingFor(attempt, b.min, b.max)
+	half := ceiling / 2
+	if ceiling <= half {
+		return ceiling
+	}
+	return half + time.Duration(b.rnd.Int63n(int64(ceiling-half)+1))
+}
+
+func (b *equalJitterBackoff) Reset() {}
+
+// decorrelatedBackoff picks each delay uniformly at random from [min, lastDelay*3], capped at max.
+// Unlike the other jitter strategies, it doesn't reference the doubling ceiling at all: each delay
+// is derived from the previous one, so consecutive delays trend upward without following a fixed
+// doubling schedule. Before the first retry, lastDelay is zero; in that case the random range
+// starts from min, exactly as the other strategies do for their first attempt.
+type decorrelatedBackoff struct {
+	min, max time.Duration
+	rnd      *rand.Rand
+}
+
+func (b *decorrelatedBackoff) Next(attempt int, lastDelay time.Duration) time.Duration {
+	upper := lastDelay * 3
+	if upper < b.min {
+		upper = b.min
+	}
+	return bounded(b.min+time.Duration(b.rnd.Int63n(int64(upper-b.min)+1)), b.min, b.max)
+}
+
+func (b *decorrelatedBackoff) Reset() {}
+
+// noneBackoff disables jitter entirely: each delay is exactly the doubling, capped backoff
+// ceiling, with no randomization.
+type noneBackoff struct {
+	min, max time.Duration
+}
+
+func (b *noneBackoff) Next(attempt int, lastDelay time.Duration) time.Duration {
+	return ceilingFor(attempt, b.min, b.max)
+}
+
+func (b *noneBackoff) Reset() {}
+
+// constantBackoff always waits the same delay between attempts, with no growth and no jitter.
+type constantBackoff struct {
+	delay time.Duration
+}
+
+func (b *constantBackoff) Next(attempt int, lastDelay time.Duration) time.Duration {
+	return b.delay
+}
+
+func (b *constantBackoff) Reset() {}
+
+// linearBackoff grows the delay by min on every attempt, capped at max, rather than doubling it:
+// attempt 1 waits 2*min, attempt 2 waits 3*min, and so on.
+type linearBackoff struct {
+	min, max time.Duration
+}
+
+func (b *linearBackoff) Next(attempt int, lastDelay time.Duration) time.Duration {
+	return bounded(b.min*time.Duration(attempt+1), b.min, b.max)
+}
+
+func (b *linearBackoff) Reset() {}
@@ -0,0 +1,134 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+)
+
+func TestBackoffStrategyFor_Default(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	if _, ok := backoffStrategyFor("", time.Second, time.Minute, rnd).(*fullJitterBackoff); !ok {
+		t.Fatal("expected an empty policy to default to fullJitterBackoff")
+	}
+	if _, ok := backoffStrategyFor("bogus", time.Second, time.Minute, rnd).(*fullJitterBackoff); !ok {
+		t.Fatal("expected an unrecognized policy to default to fullJitterBackoff")
+	}
+}
+
+func TestFullJitterBackoff_BoundedAndSeeded(t *testing.T) {
+	min, max := time.Second, 10*time.Second
+
+	// Re-running with the same seed must reproduce the same sequence of delays, so a RetryingSender
+	// under test can assert exact values rather than just bounds.
+	b := backoffStrategyFor(config.BackoffFullJitter, min, max, rand.New(rand.NewSource(42)))
+	b2 := backoffStrategyFor(config.BackoffFullJitter, min, max, rand.New(rand.NewSource(42)))
+
+	var last, last2 time.Duration
+	for attempt := 1; attempt <= 5; attempt++ {
+		d := b.Next(attempt, last)
+		if d < min || d > max {
+			t.Fatalf("attempt %v: delay %v out of bounds [%v, %v]", attempt, d, min, max)
+		}
+		last = d
+
+		d2 := b2.Next(attempt, last2)
+		if d2 != d {
+			t.Fatalf("attempt %v: same-seed delay diverged: got %v, want %v", attempt, d2, d)
+		}
+		last2 = d2
+	}
+}
+
+func TestEqualJitterBackoff_Bounded(t *testing.T) {
+	min, max := time.Second, 10*time.Second
+	rnd := rand.New(rand.NewSource(7))
+	b := backoffStrategyFor(config.BackoffEqualJitter, min, max, rnd)
+
+	var last time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		ceiling := ceilingFor(attempt, min, max)
+		d := b.Next(attempt, last)
+		if d < ceiling/2 || d > ceiling {
+			t.Fatalf("attempt %v: delay %v out of bounds [%v, %v]", attempt, d, ceiling/2, ceiling)
+		}
+		last = d
+	}
+}
+
+func TestDecorrelatedBackoff_GrowsFromLastDelay(t *testing.T) {
+	min, max := time.Second, time.Minute
+	rnd := rand.New(rand.NewSource(7))
+	b := backoffStrategyFor(config.BackoffDecorrelatedJitter, min, max, rnd)
+
+	d := b.Next(1, 0)
+	if d < min || d > max {
+		t.Fatalf("first delay %v out of bounds [%v, %v]", d, min, max)
+	}
+	for attempt := 2; attempt <= 8; attempt++ {
+		next := b.Next(attempt, d)
+		upper := d * 3
+		if upper < min {
+			upper = min
+		}
+		if upper > max {
+			upper = max
+		}
+		if next < min || next > upper {
+			t.Fatalf("attempt %v: delay %v out of bounds [%v, %v]", attempt, next, min, upper)
+		}
+		d = next
+	}
+}
+
+func TestNoneBackoff_DeterministicDoubling(t *testing.T) {
+	min, max := time.Second, 10*time.Second
+	b := backoffStrategyFor(config.BackoffNone, min, max, nil)
+
+	wants := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, max, max}
+	var last time.Duration
+	for i, want := range wants {
+		got := b.Next(i+1, last)
+		if got != want {
+			t.Fatalf("attempt %v: want=%v, got=%v", i+1, want, got)
+		}
+		last = got
+	}
+}
+
+func TestConstantBackoff(t *testing.T) {
+	b := backoffStrategyFor(config.BackoffConstant, 5*time.Second, time.Minute, nil)
+	for attempt := 1; attempt <= 3; attempt++ {
+		if got := b.Next(attempt, 0); got != 5*time.Second {
+			t.Fatalf("attempt %v: want=5s, got=%v", attempt, got)
+		}
+	}
+}
+
+func TestLinearBackoff(t *testing.T) {
+	min, max := time.Second, 3500*time.Millisecond
+	b := backoffStrategyFor(config.BackoffLinear, min, max, nil)
+
+	wants := []time.Duration{2 * time.Second, 3 * time.Second, max, max}
+	for i, want := range wants {
+		if got := b.Next(i+1, 0); got != want {
+			t.Fatalf("attempt %v: want=%v, got=%v", i+1, want, got)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+// retryBudget is a token bucket that bounds how many retry attempts a RetryingSender may spend
+// relative to its recent successes, so a single pathological endpoint can't retry forever and
+// monopolize the persistent retry queue. It starts with a constant reserve of tokens, credits a
+// ratio of tokens per successful send, and spends one token per failed send attempt that would
+// otherwise be retried.
+//
+// retryBudget is not safe for concurrent use; RetryingSender only touches it from its single run
+// goroutine.
+type retryBudget struct {
+	tokens float64
+	ratio  float64
+	max    float64
+}
+
+// newRetryBudget creates a retryBudget seeded with constant tokens, crediting ratio tokens per
+// success and capping accumulated tokens at max.
+func newRetryBudget(constant, ratio, max float64) *retryBudget {
+	return &retryBudget{tokens: constant, ratio: ratio, max: max}
+}
+
+// credit adds a success's worth of tokens to the budget.
+func (b *retryBudget) credit() {
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// configure updates the budget's ratio and max in place, leaving its current token balance
+// untouched aside from clamping it to the new max. It's used by
+// RetryingSender.applyRetrySettings to pick up a config change without resetting an
+// already-accumulated budget back to its starting constant.
+func (b *retryBudget) configure(ratio, max float64) {
+	b.ratio = ratio
+	b.max = max
+	if b.tokens > max {
+		b.tokens = max
+	}
+}
+
+// withdraw spends one token on a failed send attempt. It returns whether a token was available
+// before the withdrawal; the token is spent either way, with tokens clamped at 0, so a run of
+// failures with no successes can't push the budget negative.
+func (b *retryBudget) withdraw() bool {
+	ok := b.tokens >= 1
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+	return ok
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"path"
+
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+)
+
+const (
+	// defaultDedupSize is the number of recent report IDs a RetryingSender remembers in order to
+	// swallow duplicate Send calls for a report it has already accepted.
+	defaultDedupSize = 1000
+
+	dedupPrefix = "epdedup"
+)
+
+// dedupPersistenceName returns the persistence name used to store a RetryingSender's dedup cache
+// for the given endpoint name.
+func dedupPersistenceName(name string) string {
+	return path.Join(dedupPrefix, name)
+}
+
+// isNotFoundErr reports whether err is persistence.ErrNotFound.
+func isNotFoundErr(err error) bool {
+	return err == persistence.ErrNotFound
+}
+
+// idCache is a small, bounded, JSON-persistable cache of recently-seen report IDs. It's used to
+// make RetryingSender.Send idempotent against duplicate submissions of the same
+// metrics.StampedMetricReport.Id - for example, a frontend that retries a Send call after losing
+// track of whether a prior call actually completed.
+//
+// idCache is not safe for concurrent use; callers are responsible for synchronization.
+type idCache struct {
+	// Ids holds seen report IDs in insertion order, oldest first. It's exported so that an idCache
+	// can be stored/loaded via persistence.Value; the set field below is rebuilt from it after load.
+	Ids []string
+
+	max int
+	set map[string]bool
+}
+
+// newIdCache creates an idCache that remembers at most max IDs.
+func newIdCache(max int) *idCache {
+	return &idCache{max: max, set: make(map[string]bool)}
+}
+
+// rebuild reconstructs the internal lookup set from Ids. It must be called after an idCache is
+// populated via persistence.Value.Load, since the set field itself isn't persisted.
+func (c *idCache) rebuild(max int) {
+	c.max = max
+	c.set = make(map[string]bool, len(c.Ids))
+	for _, id := range c.Ids {
+		c.set[id] = true
+	}
+}
+
+// seen returns whether id has already been added to the cache.
+func (c *idCache) seen(id string) bool {
+	return c.set[id]
+}
+
+// add records id as seen, evicting the oldest entries if the cache has grown beyond its max size.
+// It's a no-op if id has already been added.
+func (c *idCache) add(id string) {
+	if c.set[id] {
+		return
+	}
+	c.Ids = append(c.Ids, id)
+	c.set[id] = true
+	for len(c.Ids) > c.max {
+		oldest := c.Ids[0]
+		c.Ids = c.Ids[1:]
+		delete(c.set, oldest)
+	}
+}
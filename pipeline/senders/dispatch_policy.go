@@ -0,0 +1,155 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+// DispatchPolicy decides, for a single report, which of a Dispatcher's senders should receive it
+// and how. Route returns the senders - a subset of all, in the order they should be tried. If
+// Sequential is false, Dispatcher sends to every sender Route returns concurrently, as it always
+// did before DispatchPolicy existed. If Sequential is true, Dispatcher instead sends to them one
+// at a time, in the order returned, moving on to the next only if the previous attempt returns an
+// error, and stopping at the first one that doesn't.
+type DispatchPolicy interface {
+	Route(report metrics.StampedMetricReport, all []pipeline.Sender) []pipeline.Sender
+	Sequential() bool
+}
+
+// broadcastPolicy is the original, unconditional fan-out: every sender receives every report, in
+// parallel.
+type broadcastPolicy struct{}
+
+// NewBroadcastPolicy returns the default DispatchPolicy: every report is sent to every sender,
+// concurrently.
+func NewBroadcastPolicy() DispatchPolicy { return broadcastPolicy{} }
+
+func (broadcastPolicy) Route(_ metrics.StampedMetricReport, all []pipeline.Sender) []pipeline.Sender {
+	return all
+}
+
+func (broadcastPolicy) Sequential() bool { return false }
+
+// failoverPolicy tries senders in priority order, falling through to the next only if the
+// previous one returns an error.
+type failoverPolicy struct{}
+
+// NewFailoverPolicy returns a DispatchPolicy that sends each report to the first sender in
+// priority order, falling through to the next only if the previous attempt returns an error.
+func NewFailoverPolicy() DispatchPolicy { return failoverPolicy{} }
+
+func (failoverPolicy) Route(_ metrics.StampedMetricReport, all []pipeline.Sender) []pipeline.Sender {
+	return all
+}
+
+func (failoverPolicy) Sequential() bool { return true }
+
+// loadBalancePolicy sends each report to exactly one sender, chosen by weighted round-robin.
+type loadBalancePolicy struct {
+	weights map[int]int
+
+	once    sync.Once
+	entries []int // index into the Dispatcher's senders slice, repeated by weight
+	mu      sync.Mutex
+	next    int
+}
+
+// NewLoadBalancePolicy returns a DispatchPolicy that sends each report to exactly one of all's
+// senders, chosen by weighted round-robin. weights maps a sender's index in all to its relative
+// weight; a sender missing from weights gets weight 1.
+func NewLoadBalancePolicy(weights map[int]int) DispatchPolicy {
+	return &loadBalancePolicy{weights: weights}
+}
+
+func (p *loadBalancePolicy) Route(_ metrics.StampedMetricReport, all []pipeline.Sender) []pipeline.Sender {
+	p.once.Do(func() {
+		for i := 0; i < len(all); i++ {
+			weight := p.weights[i]
+			if weight <= 0 {
+				weight = 1
+			}
+			for j := 0; j < weight; j++ {
+				p.entries = append(p.entries, i)
+			}
+		}
+	})
+	if len(p.entries) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	i := p.entries[p.next%len(p.entries)]
+	p.next++
+	p.mu.Unlock()
+	return all[i : i+1]
+}
+
+func (p *loadBalancePolicy) Sequential() bool { return false }
+
+// shardPolicy sends each report to exactly one sender, chosen by hashing the report's Id, so the
+// same report always lands on the same sender.
+type shardPolicy struct{}
+
+// NewShardPolicy returns a DispatchPolicy that sends each report to exactly one of all's senders,
+// chosen by hashing report.Id.
+func NewShardPolicy() DispatchPolicy { return shardPolicy{} }
+
+func (shardPolicy) Route(report metrics.StampedMetricReport, all []pipeline.Sender) []pipeline.Sender {
+	if len(all) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(report.Id))
+	i := int(h.Sum32() % uint32(len(all)))
+	return all[i : i+1]
+}
+
+func (shardPolicy) Sequential() bool { return false }
+
+// NewDispatchPolicyForMetric returns the DispatchPolicy that metric's Dispatch configuration
+// describes, resolving a DispatchLoadBalance policy's weights against metric.Endpoints' order.
+// That order only matches the senders a Dispatcher built from this metric actually holds when the
+// metric's EffectiveMode is ModeEnforce; ModeDryRun and ModeWarn filter or rebuild that list, so a
+// weighted or sharded policy combined with either of those modes indexes by position rather than
+// by the endpoint it was meant for. Combining Dispatch with DryRun/Warn mode is not expected to be
+// common enough to warrant resolving weights by endpoint identity instead.
+func NewDispatchPolicyForMetric(metric *config.Metric) (DispatchPolicy, error) {
+	switch metric.Dispatch.EffectiveMode() {
+	case config.DispatchBroadcast:
+		return NewBroadcastPolicy(), nil
+	case config.DispatchFailover:
+		return NewFailoverPolicy(), nil
+	case config.DispatchLoadBalance:
+		w := make(map[int]int)
+		if metric.Dispatch != nil {
+			for i, e := range metric.Endpoints {
+				if weight, ok := metric.Dispatch.Weights[e.Name]; ok {
+					w[i] = weight
+				}
+			}
+		}
+		return NewLoadBalancePolicy(w), nil
+	case config.DispatchShard:
+		return NewShardPolicy(), nil
+	default:
+		return nil, fmt.Errorf("dispatch: unsupported mode: %v", metric.Dispatch.EffectiveMode())
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2017 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/stats"
+	"github.com/hashicorp/go-multierror"
+)
+
+// dispatchBatchPrefix namespaces a Dispatcher's persisted batches from the retry queues and dedup
+// caches that RetryingSenders keep in the same Persistence.
+const dispatchBatchPrefix = "dispatch_batches"
+
+// Dispatcher is a Sender that fans out to other Sender instances. Generally, this will be a
+// collection of Endpoints wrapped in RetryingSender objects, allowing the same report to be
+// delivered to Service Control, a disk archive, and other endpoints simultaneously. A failure on
+// one sub-sender does not prevent the others from being attempted.
+//
+// Which of its senders a given report actually reaches, and whether they're attempted
+// concurrently or one at a time, is up to its DispatchPolicy - by default, every report goes to
+// every sender in parallel, but NewDispatcher can be given a policy that instead fails over across
+// senders, load-balances across them, or shards reports across them by Id.
+type Dispatcher struct {
+	senders     []pipeline.Sender
+	byName      map[string]pipeline.Sender
+	policy      DispatchPolicy
+	tracker     pipeline.UsageTracker
+	recorder    stats.Recorder
+	persistence persistence.Persistence
+}
+
+// Send routes report to the senders its DispatchPolicy selects and returns any errors. It's
+// equivalent to calling Prepare and then Send on the result: every selected sender is Prepared
+// before any of them is committed, so a Prepare failure on one sender never leaves another having
+// already committed (see Prepare). For a non-Sequential policy (the default), the commit phase
+// fans out to every selected sender in parallel and blocks until they've all finished. For a
+// Sequential policy, it instead tries the selected senders one at a time, in the order given,
+// stopping at the first one that doesn't return an error.
+func (d *Dispatcher) Send(report metrics.StampedMetricReport) error {
+	ps, err := d.Prepare(report)
+	if err != nil {
+		return err
+	}
+	return ps.Send()
+}
+
+// Prepare routes report to the senders its DispatchPolicy selects and calls Prepare on every one
+// of them before returning. If any of them fails to prepare, Prepare returns the aggregated error
+// without having committed a send anywhere - the reports that did prepare successfully are simply
+// discarded. Otherwise, the batch of prepared sends is persisted as a single unit - keyed by
+// report.Id, under this Dispatcher's dispatch_batches bucket - before Prepare returns, so that a
+// crash between this point and the returned PreparedSend's Send call can be resumed via Recover.
+// See pipeline.Sender.Prepare.
+func (d *Dispatcher) Prepare(report metrics.StampedMetricReport) (pipeline.PreparedSend, error) {
+	targets := d.policy.Route(report, d.senders)
+
+	// Register that each report will be handled by exactly the endpoints its targets will actually
+	// reach, so stats bookkeeping reflects the chosen dispatch policy rather than every endpoint
+	// this Dispatcher could ever reach.
+	d.recorder.Register(report.Id, dedupEndpoints(targets))
+
+	sends := make([]pipeline.PreparedSend, len(targets))
+	for i, s := range targets {
+		ps, err := s.Prepare(report)
+		if err != nil {
+			return nil, fmt.Errorf("dispatcher: prepare failed for %v: %v", endpointKey(s), err)
+		}
+		sends[i] = ps
+	}
+
+	entries := make([]dispatchBatchEntry, len(targets))
+	for i, s := range targets {
+		payload, err := sends[i].Payload()
+		if err != nil {
+			return nil, fmt.Errorf("dispatcher: marshaling prepared send for %v: %v", endpointKey(s), err)
+		}
+		entries[i] = dispatchBatchEntry{Endpoint: endpointKey(s), Payload: payload}
+	}
+	batchValue := d.persistence.Value(dispatchBatchName(report.Id))
+	if err := batchValue.Store(&dispatchBatch{Entries: entries}); err != nil {
+		return nil, fmt.Errorf("dispatcher: persisting dispatch batch %v: %v", report.Id, err)
+	}
+
+	return &dispatcherSend{d: d, reportId: report.Id, sequential: d.policy.Sequential(), sends: sends, batchValue: batchValue}, nil
+}
+
+// SendPrepared resumes a batch persisted by Prepare, given the report ID it was keyed by (as
+// marshaled into payload by dispatcherSend.Payload). It commits every entry in the batch via its
+// owning sender's SendPrepared - which is itself idempotent - so calling SendPrepared again for an
+// already-committed (or already-cleared) batch is a no-op.
+// See pipeline.Sender.SendPrepared.
+func (d *Dispatcher) SendPrepared(ctx context.Context, payload []byte) error {
+	var reportId string
+	if err := json.Unmarshal(payload, &reportId); err != nil {
+		return err
+	}
+	return d.Recover(ctx, reportId)
+}
+
+// Recover resumes the dispatch batch persisted under reportId, if any, committing every entry to
+// its owning sender via SendPrepared and then clearing the batch. It's intended to be called for
+// every report ID whose outcome is unknown after a crash - e.g. one a caller's own durable input
+// buffer still shows as unacknowledged - since Persistence has no way to enumerate the batches a
+// Dispatcher has written. A missing batch (nothing was pending, or it was already resolved) is not
+// an error.
+func (d *Dispatcher) Recover(ctx context.Context, reportId string) error {
+	var batch dispatchBatch
+	if err := d.persistence.Value(dispatchBatchName(reportId)).Load(&batch); err != nil {
+		if err == persistence.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	var errs []error
+	for _, e := range batch.Entries {
+		s, ok := d.byName[e.Endpoint]
+		if !ok {
+			errs = append(errs, fmt.Errorf("dispatcher: recovering %v: unknown sender %v", reportId, e.Endpoint))
+			continue
+		}
+		if err := s.SendPrepared(ctx, e.Payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := multierror.Append(nil, errs...).ErrorOrNil(); err != nil {
+		return err
+	}
+	return d.persistence.Value(dispatchBatchName(reportId)).Remove()
+}
+
+// dispatchBatchEntry is one sender's contribution to a persisted dispatch batch: the Payload its
+// PreparedSend produced during Prepare, keyed by the sender's endpointKey so Recover can find it
+// again after a restart.
+type dispatchBatchEntry struct {
+	Endpoint string
+	Payload  []byte
+}
+
+// dispatchBatch is the unit a Dispatcher persists before committing any sender in a Prepare call.
+type dispatchBatch struct {
+	Entries []dispatchBatchEntry
+}
+
+// dispatchBatchName returns the persistence name used to store the dispatch batch for reportId.
+func dispatchBatchName(reportId string) string {
+	return path.Join(dispatchBatchPrefix, reportId)
+}
+
+// endpointKey identifies s among a Dispatcher's senders by its transitive endpoint names, which -
+// unlike its position in the senders slice - stay stable across restarts and across DispatchPolicy
+// changes.
+func endpointKey(s pipeline.Sender) string {
+	return strings.Join(s.Endpoints(), "+")
+}
+
+// dispatcherSend is the pipeline.PreparedSend returned by Dispatcher.Prepare.
+type dispatcherSend struct {
+	d          *Dispatcher
+	reportId   string
+	sequential bool
+	sends      []pipeline.PreparedSend
+	batchValue persistence.Value
+}
+
+// Send commits every sender this batch was prepared for - in parallel for a non-Sequential
+// policy, or one at a time until the first success for a Sequential one - then clears the
+// persisted batch regardless of the outcome, since each committed sender now owns its own durable
+// retry state.
+func (ds *dispatcherSend) Send() error {
+	defer ds.batchValue.Remove()
+
+	if !ds.sequential {
+		errs := make([]error, len(ds.sends))
+		wg := sync.WaitGroup{}
+		wg.Add(len(ds.sends))
+		for i, ps := range ds.sends {
+			go func(i int, ps pipeline.PreparedSend) {
+				// If the send generates an error, we assume that the downstream sender will register that
+				// error with the stats recorder.
+				errs[i] = ps.Send()
+				wg.Done()
+			}(i, ps)
+		}
+		wg.Wait()
+		return multierror.Append(nil, errs...).ErrorOrNil()
+	}
+
+	// Sequential (e.g. failover): try each target in order, stopping at the first success.
+	var errs []error
+	for _, ps := range ds.sends {
+		if err := ps.Send(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	return multierror.Append(nil, errs...).ErrorOrNil()
+}
+
+// Payload returns the report ID this batch was persisted under, so a Dispatcher nested behind
+// another Dispatcher can be resumed via SendPrepared.
+func (ds *dispatcherSend) Payload() ([]byte, error) {
+	return json.Marshal(ds.reportId)
+}
+
+// Use increments the Dispatcher's usage count.
+// See pipeline.Component.Use.
+func (d *Dispatcher) Use() {
+	d.tracker.Use()
+}
+
+// Release decrements the Dispatcher's usage count. If it reaches 0, Release releases all of the
+// underlying senders concurrently and waits for the operations to finish.
+// See pipeline.Component.Release.
+func (d *Dispatcher) Release() error {
+	return d.tracker.Release(func() error {
+		errors := make([]error, len(d.senders))
+		wg := sync.WaitGroup{}
+		wg.Add(len(d.senders))
+		for i, s := range d.senders {
+			go func(i int, s pipeline.Sender) {
+				errors[i] = s.Release()
+				wg.Done()
+			}(i, s)
+		}
+		wg.Wait()
+		return multierror.Append(nil, errors...).ErrorOrNil()
+	})
+}
+
+// Endpoints returns the de-duplicated, transitive list of every endpoint this Dispatcher could
+// send to - i.e., across all of its senders, regardless of DispatchPolicy. A given report's own
+// Register call reflects only the endpoints its policy actually routes it to; see Send.
+func (d *Dispatcher) Endpoints() []string {
+	return dedupEndpoints(d.senders)
+}
+
+// dedupEndpoints returns the de-duplicated, transitive list of endpoints that senders will
+// ultimately send to.
+func dedupEndpoints(senders []pipeline.Sender) (handlers []string) {
+	seen := make(map[string]bool)
+	for _, s := range senders {
+		for _, e := range s.Endpoints() {
+			if _, exists := seen[e]; !exists {
+				seen[e] = true
+				handlers = append(handlers, e)
+			}
+		}
+	}
+	return
+}
+
+// AddWatcher registers w on every sender this Dispatcher fans out to.
+// See pipeline.Sender.AddWatcher.
+func (d *Dispatcher) AddWatcher(w pipeline.SendWatcher) {
+	for _, s := range d.senders {
+		s.AddWatcher(w)
+	}
+}
+
+// NewDispatcher creates a new Dispatcher that dispatches to senders according to policy, using p
+// to persist batches prepared across multiple senders so they can be resumed via Recover after a
+// crash. It calls Use on each of the given senders.
+func NewDispatcher(senders []pipeline.Sender, p persistence.Persistence, recorder stats.Recorder, policy DispatchPolicy) *Dispatcher {
+	byName := make(map[string]pipeline.Sender, len(senders))
+	for _, s := range senders {
+		s.Use()
+		byName[endpointKey(s)] = s
+	}
+	return &Dispatcher{senders: senders, byName: byName, recorder: recorder, policy: policy, persistence: p}
+}
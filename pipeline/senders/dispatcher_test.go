@@ -15,6 +15,7 @@
 package senders
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"strings"
@@ -22,6 +23,7 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
 	"github.com/GoogleCloudPlatform/ubbagent/stats"
 	"github.com/GoogleCloudPlatform/ubbagent/testlib"
@@ -41,7 +43,7 @@ func TestDispatcher(t *testing.T) {
 	t.Run("all sub-senders are invoked", func(t *testing.T) {
 		ms1 := testlib.NewMockSender("ms1")
 		ms2 := testlib.NewMockSender("ms2")
-		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, stats.NewNoopRecorder())
+		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), NewBroadcastPolicy())
 		if err := ds.Send(report); err != nil {
 			t.Fatalf("Unexpected send error: %+v", err)
 		}
@@ -57,7 +59,7 @@ func TestDispatcher(t *testing.T) {
 		ms1 := testlib.NewMockSender("ms1")
 		ms2 := testlib.NewMockSender("ms2")
 		ms2.SetSendError(errors.New("testabcd"))
-		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, stats.NewNoopRecorder())
+		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), NewBroadcastPolicy())
 		err := ds.Send(report)
 		if ms1.Calls() == 0 {
 			t.Fatal("ms1.Calls() == 0")
@@ -76,7 +78,7 @@ func TestDispatcher(t *testing.T) {
 	t.Run("dispatcher returns aggregated endpoints", func(t *testing.T) {
 		ms1 := testlib.NewMockSender("ms1")
 		ms2 := testlib.NewMockSender("ms2")
-		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, stats.NewNoopRecorder())
+		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), NewBroadcastPolicy())
 
 		if want, got := []string{"ms1", "ms2"}, ds.Endpoints(); !reflect.DeepEqual(want, got) {
 			t.Fatalf("ds.Endpoints(): expected %+v, got %+v", want, got)
@@ -85,7 +87,7 @@ func TestDispatcher(t *testing.T) {
 
 	t.Run("multiple usages", func(t *testing.T) {
 		s := testlib.NewMockSender("sender")
-		ds := NewDispatcher([]pipeline.Sender{s}, stats.NewNoopRecorder())
+		ds := NewDispatcher([]pipeline.Sender{s}, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), NewBroadcastPolicy())
 
 		// Test multiple usages of the Dispatcher.
 		ds.Use()
@@ -107,7 +109,7 @@ func TestDispatcher(t *testing.T) {
 		ms1 := testlib.NewMockSender("sender1")
 		ms2 := testlib.NewMockSender("sender2")
 		msr := testlib.NewMockStatsRecorder()
-		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, msr)
+		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, persistence.NewMemoryPersistence(), msr, NewBroadcastPolicy())
 
 		r1 := metrics.StampedMetricReport{
 			Id: "r1",
@@ -149,4 +151,69 @@ func TestDispatcher(t *testing.T) {
 			t.Fatalf("Recorded stats entries: got=%+v, want=%+v", got, want)
 		}
 	})
+
+	t.Run("Prepare failure on one sender sends to none", func(t *testing.T) {
+		ms1 := testlib.NewMockSender("ms1")
+		ms2 := testlib.NewMockSender("ms2")
+		ms2.SetPrepareError(errors.New("prepare failed"))
+		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), NewBroadcastPolicy())
+
+		if err := ds.Send(report); err == nil || !strings.Contains(err.Error(), "prepare failed") {
+			t.Fatalf("Expected prepare error, got: %v", err)
+		}
+		if ms1.SendCalls() != 0 {
+			t.Fatalf("ms1.SendCalls() = %v, want 0 (ms1 must not commit when ms2 fails to prepare)", ms1.SendCalls())
+		}
+		if ms2.SendCalls() != 0 {
+			t.Fatalf("ms2.SendCalls() = %v, want 0", ms2.SendCalls())
+		}
+	})
+
+	t.Run("every sender is Prepared before any sender is Sent", func(t *testing.T) {
+		ms1 := testlib.NewMockSender("ms1")
+		ms2 := testlib.NewMockSender("ms2")
+		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, persistence.NewMemoryPersistence(), stats.NewNoopRecorder(), NewBroadcastPolicy())
+
+		if err := ds.Send(report); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		if ms1.PrepareCalls() != 1 || ms2.PrepareCalls() != 1 {
+			t.Fatalf("expected one Prepare call per sender, got ms1=%v ms2=%v", ms1.PrepareCalls(), ms2.PrepareCalls())
+		}
+		if ms1.SendCalls() != 1 || ms2.SendCalls() != 1 {
+			t.Fatalf("expected one Send call per sender, got ms1=%v ms2=%v", ms1.SendCalls(), ms2.SendCalls())
+		}
+	})
+
+	t.Run("Recover resumes a batch persisted by Prepare", func(t *testing.T) {
+		ms1 := testlib.NewMockSender("ms1")
+		ms2 := testlib.NewMockSender("ms2")
+		p := persistence.NewMemoryPersistence()
+		ds := NewDispatcher([]pipeline.Sender{ms1, ms2}, p, stats.NewNoopRecorder(), NewBroadcastPolicy())
+
+		if _, err := ds.Prepare(report); err != nil {
+			t.Fatalf("Unexpected prepare error: %+v", err)
+		}
+		// Simulate a crash between Prepare persisting the batch and any sender being Sent: neither
+		// mock sender has been committed to yet.
+		if ms1.SendCalls() != 0 || ms2.SendCalls() != 0 {
+			t.Fatalf("expected no Send calls before Recover, got ms1=%v ms2=%v", ms1.SendCalls(), ms2.SendCalls())
+		}
+
+		ds2 := NewDispatcher([]pipeline.Sender{ms1, ms2}, p, stats.NewNoopRecorder(), NewBroadcastPolicy())
+		if err := ds2.Recover(context.Background(), report.Id); err != nil {
+			t.Fatalf("Unexpected recover error: %+v", err)
+		}
+		if ms1.SendPreparedCalls() != 1 || ms2.SendPreparedCalls() != 1 {
+			t.Fatalf("expected one SendPrepared call per sender, got ms1=%v ms2=%v", ms1.SendPreparedCalls(), ms2.SendPreparedCalls())
+		}
+
+		// Recovering the same batch again is a no-op: the batch was cleared by the first Recover.
+		if err := ds2.Recover(context.Background(), report.Id); err != nil {
+			t.Fatalf("Unexpected recover error: %+v", err)
+		}
+		if ms1.SendPreparedCalls() != 1 || ms2.SendPreparedCalls() != 1 {
+			t.Fatalf("expected no additional SendPrepared calls on a repeat Recover, got ms1=%v ms2=%v", ms1.SendPreparedCalls(), ms2.SendPreparedCalls())
+		}
+	})
 }
@@ -0,0 +1,105 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/filter"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+)
+
+// FilteringSender wraps a Sender, running every report through a filter.Chain before forwarding
+// it. A report dropped by the chain is discarded without being sent and without an error.
+type FilteringSender struct {
+	sender pipeline.Sender
+	chain  filter.Chain
+}
+
+// NewFilteringSender creates a FilteringSender that applies chain to every report before passing
+// it to sender.
+func NewFilteringSender(sender pipeline.Sender, chain filter.Chain) *FilteringSender {
+	return &FilteringSender{sender: sender, chain: chain}
+}
+
+func (s *FilteringSender) Send(report metrics.StampedMetricReport) error {
+	ps, err := s.Prepare(report)
+	if err != nil {
+		return err
+	}
+	return ps.Send()
+}
+
+// Prepare applies the chain and, if it keeps the report, delegates to the wrapped sender's
+// Prepare. A dropped report short-circuits to a no-op PreparedSend, so it's never persisted as
+// part of a Dispatcher's batch. See pipeline.Sender.Prepare.
+func (s *FilteringSender) Prepare(report metrics.StampedMetricReport) (pipeline.PreparedSend, error) {
+	report, keep := s.chain.Apply(report)
+	if !keep {
+		return noopPreparedSend{}, nil
+	}
+	return s.sender.Prepare(report)
+}
+
+// SendPrepared is delegated directly to the wrapped sender: a payload persisted via Prepare was
+// already produced by the wrapped sender (or is noopPreparedSend's, which SendPrepared is never
+// called for, since nothing is persisted for a dropped report).
+// See pipeline.Sender.SendPrepared.
+func (s *FilteringSender) SendPrepared(ctx context.Context, payload []byte) error {
+	return s.sender.SendPrepared(ctx, payload)
+}
+
+// noopPreparedSend is the PreparedSend returned for a report the filter chain drops: committing it
+// is a no-op, and there's nothing to persist.
+type noopPreparedSend struct{}
+
+func (noopPreparedSend) Send() error              { return nil }
+func (noopPreparedSend) Payload() ([]byte, error) { return nil, nil }
+
+// Use is delegated directly to the wrapped sender. See pipeline.Component.Use.
+func (s *FilteringSender) Use() {
+	s.sender.Use()
+}
+
+// Release is delegated directly to the wrapped sender. See pipeline.Component.Release.
+func (s *FilteringSender) Release() error {
+	return s.sender.Release()
+}
+
+// Endpoints is delegated directly to the wrapped sender.
+func (s *FilteringSender) Endpoints() []string {
+	return s.sender.Endpoints()
+}
+
+// AddWatcher is delegated directly to the wrapped sender. A watcher sees only the reports the
+// chain keeps; dropped reports don't generate a SendWatcher event.
+// See pipeline.Sender.AddWatcher.
+func (s *FilteringSender) AddWatcher(w pipeline.SendWatcher) {
+	s.sender.AddWatcher(w)
+}
+
+// ApplyConfig is delegated directly to the wrapped sender if it implements pipeline.Reconfigurable,
+// so a FilteringSender doesn't itself need to be rebuilt for a change its wrapped sender can apply
+// in place. It returns an error if the wrapped sender doesn't implement the interface.
+func (s *FilteringSender) ApplyConfig(old, new *config.Config) error {
+	rc, ok := s.sender.(pipeline.Reconfigurable)
+	if !ok {
+		return fmt.Errorf("FilteringSender: wrapped sender is not Reconfigurable")
+	}
+	return rc.ApplyConfig(old, new)
+}
@@ -15,47 +15,183 @@
 package senders
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"math/rand"
 	"path"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 	"github.com/GoogleCloudPlatform/ubbagent/persistence"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
 	"github.com/GoogleCloudPlatform/ubbagent/stats"
+	"github.com/GoogleCloudPlatform/ubbagent/tracing"
 	"github.com/golang/glog"
+	"github.com/hashicorp/go-multierror"
 )
 
 const (
 	persistPrefix = "epqueue"
+
+	// defaultCircuitBreakerThreshold is the number of consecutive transient failures after which the
+	// circuit breaker opens by default.
+	defaultCircuitBreakerThreshold = 8
+
+	// defaultCircuitBreakerCooldown is how long the circuit breaker stays open by default before a
+	// half-open probe is allowed.
+	defaultCircuitBreakerCooldown = 30 * time.Second
+
+	// defaultCircuitBreakerProbeCount is the number of consecutive successful half-open probes
+	// required by default to close the circuit breaker again.
+	defaultCircuitBreakerProbeCount = 1
+
+	// defaultRetryBudgetConstant and defaultRetryBudgetRatio seed and replenish the default retry
+	// budget: a small constant reserve plus roughly 10% of successful sends.
+	defaultRetryBudgetConstant = 10.0
+	defaultRetryBudgetRatio    = 0.1
+
+	// retryBudgetMax caps a retry budget's accumulated tokens, so a long-running, mostly-successful
+	// endpoint doesn't bank an unbounded reserve.
+	retryBudgetMax = 1000.0
+
+	// compactInterval is how often a RetryingSender with a configured queue size limit scans its
+	// retry queue for adjacent entries to merge. See compactQueue.
+	compactInterval = 5 * time.Minute
 )
 
 var minRetryDelay = flag.Duration("min_retry_delay", 2*time.Second, "minimum exponential backoff delay")
 var maxRetryDelay = flag.Duration("max_retry_delay", 60*time.Second, "maximum exponential backoff delay")
 var maxQueueTime = flag.Duration("max_queue_time", 3*time.Hour, "maximum amount of time to keep an entry in the retry queue")
 
+// RetryAction describes how a RetryingSender should handle a failed send, as determined by a
+// Retryer.
+type RetryAction int
+
+const (
+	// RetryActionRetry leaves the entry in the queue and schedules another attempt.
+	RetryActionRetry RetryAction = iota
+	// RetryActionDrop removes the entry from the queue without recording a failure. This is
+	// intended for errors that indicate the report is no longer relevant (e.g. it was superseded).
+	RetryActionDrop
+	// RetryActionFail removes the entry from the queue and records it as a failure immediately,
+	// without waiting for MaxQueueTime to elapse.
+	RetryActionFail
+)
+
+// RetryDecision is returned by a Retryer to describe how a send failure should be handled.
+type RetryDecision struct {
+	Action RetryAction
+
+	// Delay, when non-zero and Action is RetryActionRetry, overrides the sender's exponential
+	// backoff for this attempt (e.g. to honor a server-supplied Retry-After hint).
+	Delay time.Duration
+}
+
+// Retryer classifies an error returned by Endpoint.Send into a RetryDecision. Endpoints that need
+// endpoint-specific retry behavior - for example, treating a quota/ResourceExhausted error as
+// non-retryable rather than burning the retry budget until MaxQueueTime expires - can implement
+// Retryer in addition to pipeline.Endpoint.
+type Retryer interface {
+	Classify(err error) RetryDecision
+}
+
+// defaultRetryer adapts an Endpoint's IsTransient method to the Retryer interface, preserving
+// RetryingSender's original retry/fail behavior for endpoints that don't implement Retryer
+// themselves.
+type defaultRetryer struct {
+	endpoint pipeline.Endpoint
+}
+
+func (d *defaultRetryer) Classify(err error) RetryDecision {
+	// An error explicitly wrapped with pipeline.NewPermanentError is never retried, regardless of
+	// what the endpoint's own IsTransient would say - this gives an endpoint (or shared code it
+	// calls into) a way to flag a known-permanent failure without every IsTransient implementation
+	// having to duplicate that classification itself.
+	if pipeline.IsPermanent(err) || !d.endpoint.IsTransient(err) {
+		return RetryDecision{Action: RetryActionFail}
+	}
+	return RetryDecision{Action: RetryActionRetry}
+}
+
+// retryerFor returns endpoint's own Retryer if it implements one, or a defaultRetryer based on
+// IsTransient otherwise.
+func retryerFor(endpoint pipeline.Endpoint) Retryer {
+	if r, ok := endpoint.(Retryer); ok {
+		return r
+	}
+	return &defaultRetryer{endpoint: endpoint}
+}
+
+// watchableEndpoint is implemented by Endpoints - such as disk.DiskEndpoint - that fire their own
+// SendWatcher events (e.g. OnExpired) and so need to be handed every watcher registered on the
+// RetryingSender wrapping them.
+type watchableEndpoint interface {
+	AddWatcher(w pipeline.SendWatcher)
+}
+
 // RetryingSender is a Sender handles sending reports to remote endpoints.
-// It buffers reports and retries in the event of a send failure, using exponential backoff between
-// retry attempts. Minimum and maximum delays are configurable via the "retrymin" and "retrymax"
-// flags.
+// It buffers reports and retries in the event of a send failure, with the delay between attempts
+// computed by a pluggable BackoffStrategy (see backoffStrategyFor). Minimum and maximum delays
+// default to the "min_retry_delay" and "max_retry_delay" flags, but can be overridden per-endpoint
+// via config.RetryPolicy, as can the backoff policy, circuit breaker, and retry budget that also
+// guard the endpoint.
 type RetryingSender struct {
-	endpoint    pipeline.Endpoint
-	queue       persistence.Queue
-	recorder    stats.Recorder
-	clock       clock.Clock
-	lastAttempt time.Time
-	delay       time.Duration
-	minDelay    time.Duration
-	maxDelay    time.Duration
-	add         chan addMsg
-	closed      bool
-	closeMutex  sync.RWMutex
-	wait        sync.WaitGroup
-	tracker     pipeline.UsageTracker
+	endpoint         pipeline.Endpoint
+	retryer          Retryer
+	persistence      persistence.Persistence
+	queue            persistence.Queue
+	recorder         stats.Recorder
+	clock            clock.Clock
+	lastAttempt      time.Time
+	delay            time.Duration
+	minDelay         time.Duration
+	maxDelay         time.Duration
+	backoff          BackoffStrategy
+	maxQueueTime     time.Duration
+	maxAttempts      int
+	breaker          *circuitBreaker
+	breakerObs       stats.BreakerObserver
+	lastBreakerState breakerState
+	backoffObs       stats.BackoffObserver
+	budget           *retryBudget
+	deadLetter       pipeline.DeadLetterSink
+	deadLetterObs    stats.DeadLetterObserver
+	batchEndpoint    pipeline.BatchEndpoint
+	batchMaxReports  int
+	batchMaxBytes    int64
+	maxQueueItems    int
+	maxQueueBytes    int64
+	queueFullPolicy  string
+	enqueueTimeout   time.Duration
+	sendTimeout      time.Duration
+	ctx              context.Context
+	cancel           context.CancelFunc
+	curId            string
+	curAttempts      int
+	rnd              *rand.Rand
+	add              chan addMsg
+	reconfig         chan reconfigMsg
+	stopCompact      chan struct{}
+	closed           bool
+	closeMutex       sync.RWMutex
+	wait             sync.WaitGroup
+	tracker          pipeline.UsageTracker
+
+	dedup      *idCache
+	dedupValue persistence.Value
+	dedupMutex sync.Mutex
+
+	watchers  []pipeline.SendWatcher
+	watcherMu sync.Mutex
+
+	tracer *tracing.Tracer
 }
 
 type addMsg struct {
@@ -63,55 +199,328 @@ type addMsg struct {
 	result chan error
 }
 
+// reconfigMsg asks RetryingSender's run loop to apply a new retrySettings in place. It's handled
+// there, rather than by locking the fields it touches, because run is the only goroutine that
+// reads or writes them - see circuitBreaker's and retryBudget's own concurrency comments.
+type reconfigMsg struct {
+	settings retrySettings
+	result   chan error
+}
+
 type queueEntry struct {
 	Report   pipeline.EndpointReport
 	SendTime time.Time
 }
 
-// NewRetryingSender creates a new RetryingSender for endpoint, storing state in persistence.
-func NewRetryingSender(endpoint pipeline.Endpoint, persistence persistence.Persistence, recorder stats.Recorder) *RetryingSender {
-	return newRetryingSender(endpoint, persistence, recorder, clock.NewClock(), *minRetryDelay, *maxRetryDelay)
+// retrySettings holds the effective, default-resolved values derived from a *config.RetryPolicy.
+// It's computed once by resolveRetrySettings and used both by NewRetryingSender, to build a new
+// RetryingSender, and by ApplyConfig, to update one in place.
+type retrySettings struct {
+	minDelay, maxDelay, maxQueue time.Duration
+	maxAttempts                  int
+	breakerThreshold             int
+	breakerCooldown              time.Duration
+	breakerProbeCount            int
+	budgetRatio, budgetConstant  float64
+	batchMaxReports              int
+	batchMaxBytes                int64
+	maxQueueItems                int
+	maxQueueBytes                int64
+	queueFullPolicy              string
+	backoffPolicy                string
+	enqueueTimeout               time.Duration
+	sendTimeout                  time.Duration
+}
+
+// resolveRetrySettings applies policy's non-zero fields over RetryingSender's built-in defaults.
+// A nil policy returns the defaults unchanged.
+func resolveRetrySettings(policy *config.RetryPolicy) retrySettings {
+	s := retrySettings{
+		minDelay:          *minRetryDelay,
+		maxDelay:          *maxRetryDelay,
+		maxQueue:          *maxQueueTime,
+		breakerThreshold:  defaultCircuitBreakerThreshold,
+		breakerCooldown:   defaultCircuitBreakerCooldown,
+		breakerProbeCount: defaultCircuitBreakerProbeCount,
+		budgetRatio:       defaultRetryBudgetRatio,
+		budgetConstant:    defaultRetryBudgetConstant,
+		queueFullPolicy:   config.QueueFullBlock,
+		backoffPolicy:     config.BackoffFullJitter,
+	}
+	if policy == nil {
+		return s
+	}
+	if policy.MinDelaySeconds > 0 {
+		s.minDelay = time.Duration(policy.MinDelaySeconds) * time.Second
+	}
+	if policy.MaxDelaySeconds > 0 {
+		s.maxDelay = time.Duration(policy.MaxDelaySeconds) * time.Second
+	}
+	if policy.MaxQueueSeconds > 0 {
+		s.maxQueue = time.Duration(policy.MaxQueueSeconds) * time.Second
+	}
+	if policy.MaxAttempts > 0 {
+		s.maxAttempts = policy.MaxAttempts
+	}
+	if policy.CircuitBreakerThreshold > 0 {
+		s.breakerThreshold = policy.CircuitBreakerThreshold
+	}
+	if policy.CircuitBreakerCooldownSeconds > 0 {
+		s.breakerCooldown = time.Duration(policy.CircuitBreakerCooldownSeconds) * time.Second
+	}
+	if policy.CircuitBreakerProbeCount > 0 {
+		s.breakerProbeCount = policy.CircuitBreakerProbeCount
+	}
+	if policy.RetryBudgetRatio > 0 {
+		s.budgetRatio = policy.RetryBudgetRatio
+	}
+	if policy.RetryBudgetConstant > 0 {
+		s.budgetConstant = policy.RetryBudgetConstant
+	}
+	if policy.BatchMaxReports > 0 {
+		s.batchMaxReports = policy.BatchMaxReports
+	}
+	if policy.BatchMaxBytes > 0 {
+		s.batchMaxBytes = policy.BatchMaxBytes
+	}
+	if policy.MaxQueueItems > 0 {
+		s.maxQueueItems = policy.MaxQueueItems
+	}
+	if policy.MaxQueueBytes > 0 {
+		s.maxQueueBytes = policy.MaxQueueBytes
+	}
+	if policy.QueueFullPolicy != "" {
+		s.queueFullPolicy = policy.QueueFullPolicy
+	}
+	if policy.EnqueueTimeoutSeconds > 0 {
+		s.enqueueTimeout = time.Duration(policy.EnqueueTimeoutSeconds) * time.Second
+	}
+	if policy.SendTimeoutSeconds > 0 {
+		s.sendTimeout = time.Duration(policy.SendTimeoutSeconds) * time.Second
+	}
+	if policy.BackoffPolicy != "" {
+		s.backoffPolicy = policy.BackoffPolicy
+	}
+	return s
+}
+
+// retrySenderOptions bundles every parameter newRetryingSenderFull needs to build a
+// RetryingSender. retrySettings already carries every policy-derived field; this adds the
+// handful of construction-only ones - endpoint, persistence, recorder, clock, rnd, deadLetter -
+// alongside it, replacing what had grown into an unwieldy positional parameter list where
+// adjacent same-typed parameters (minDelay/maxDelay/maxQueue, enqueueTimeout/sendTimeout) could be
+// silently transposed at a call site with no compiler error.
+type retrySenderOptions struct {
+	endpoint    pipeline.Endpoint
+	persistence persistence.Persistence
+	recorder    stats.Recorder
+	clock       clock.Clock
+	rnd         *rand.Rand
+	deadLetter  pipeline.DeadLetterSink
+	retrySettings
+}
+
+// NewRetryingSender creates a new RetryingSender for endpoint, storing state in persistence. If
+// policy is non-nil, its non-zero fields override the sender's default min/max delay, max queue
+// time, max attempts, circuit breaker, retry budget, and backoff policy settings. deadLetter, if
+// non-nil, receives reports the sender gives up on; see pipeline.DeadLetterSink.
+func NewRetryingSender(endpoint pipeline.Endpoint, persistence persistence.Persistence, recorder stats.Recorder, policy *config.RetryPolicy, deadLetter pipeline.DeadLetterSink) *RetryingSender {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return newRetryingSenderFull(retrySenderOptions{
+		endpoint:      endpoint,
+		persistence:   persistence,
+		recorder:      recorder,
+		clock:         clock.NewClock(),
+		rnd:           rnd,
+		deadLetter:    deadLetter,
+		retrySettings: resolveRetrySettings(policy),
+	})
+}
+
+func newRetryingSender(endpoint pipeline.Endpoint, persistence persistence.Persistence, recorder stats.Recorder, clock clock.Clock, rnd *rand.Rand, minDelay, maxDelay time.Duration) *RetryingSender {
+	return newRetryingSenderFull(retrySenderOptions{
+		endpoint:    endpoint,
+		persistence: persistence,
+		recorder:    recorder,
+		clock:       clock,
+		rnd:         rnd,
+		retrySettings: retrySettings{
+			minDelay:          minDelay,
+			maxDelay:          maxDelay,
+			maxQueue:          *maxQueueTime,
+			breakerThreshold:  defaultCircuitBreakerThreshold,
+			breakerCooldown:   defaultCircuitBreakerCooldown,
+			breakerProbeCount: defaultCircuitBreakerProbeCount,
+			budgetRatio:       defaultRetryBudgetRatio,
+			budgetConstant:    defaultRetryBudgetConstant,
+			queueFullPolicy:   config.QueueFullBlock,
+			backoffPolicy:     config.BackoffFullJitter,
+		},
+	})
 }
 
-func newRetryingSender(endpoint pipeline.Endpoint, persistence persistence.Persistence, recorder stats.Recorder, clock clock.Clock, minDelay, maxDelay time.Duration) *RetryingSender {
+func newRetryingSenderFull(opts retrySenderOptions) *RetryingSender {
+	endpoint := opts.endpoint
+	persistence := opts.persistence
+
+	dedup := newIdCache(defaultDedupSize)
+	dedupValue := persistence.Value(dedupPersistenceName(endpoint.Name()))
+	if err := dedupValue.Load(dedup); err != nil && !isNotFoundErr(err) {
+		panic("RetryingSender: loading dedup cache: " + err.Error())
+	}
+	dedup.rebuild(defaultDedupSize)
+
+	ctx, cancel := context.WithCancel(context.Background())
 	rs := &RetryingSender{
-		endpoint: endpoint,
-		queue:    persistence.Queue(persistenceName(endpoint.Name())),
-		recorder: recorder,
-		clock:    clock,
-		minDelay: minDelay,
-		maxDelay: maxDelay,
-		add:      make(chan addMsg, 1),
+		endpoint:        endpoint,
+		retryer:         retryerFor(endpoint),
+		persistence:     persistence,
+		queue:           persistence.Queue(persistenceName(endpoint.Name())),
+		recorder:        opts.recorder,
+		clock:           opts.clock,
+		minDelay:        opts.minDelay,
+		maxDelay:        opts.maxDelay,
+		backoff:         backoffStrategyFor(opts.backoffPolicy, opts.minDelay, opts.maxDelay, opts.rnd),
+		maxQueueTime:    opts.maxQueue,
+		maxAttempts:     opts.maxAttempts,
+		breaker:         newCircuitBreaker(opts.breakerThreshold, opts.breakerCooldown, opts.breakerProbeCount),
+		budget:          newRetryBudget(opts.budgetConstant, opts.budgetRatio, retryBudgetMax),
+		deadLetter:      opts.deadLetter,
+		batchMaxReports: opts.batchMaxReports,
+		batchMaxBytes:   opts.batchMaxBytes,
+		maxQueueItems:   opts.maxQueueItems,
+		maxQueueBytes:   opts.maxQueueBytes,
+		queueFullPolicy: opts.queueFullPolicy,
+		enqueueTimeout:  opts.enqueueTimeout,
+		sendTimeout:     opts.sendTimeout,
+		ctx:             ctx,
+		cancel:          cancel,
+		rnd:             opts.rnd,
+		add:             make(chan addMsg, 1),
+		reconfig:        make(chan reconfigMsg),
+		stopCompact:     make(chan struct{}),
+		dedup:           dedup,
+		dedupValue:      dedupValue,
+		tracer:          tracing.NewTracer(nil),
+	}
+	if be, ok := endpoint.(pipeline.BatchEndpoint); ok && opts.batchMaxReports > 1 {
+		rs.batchEndpoint = be
+	}
+	if observer, ok := opts.recorder.(stats.QueueObserver); ok {
+		observer.ObserveQueue(endpoint.Name(), rs.queue)
+	}
+	if observer, ok := opts.recorder.(stats.DeadLetterObserver); ok {
+		rs.deadLetterObs = observer
+	}
+	if observer, ok := opts.recorder.(stats.BreakerObserver); ok {
+		rs.breakerObs = observer
 	}
+	if observer, ok := opts.recorder.(stats.BackoffObserver); ok {
+		rs.backoffObs = observer
+	}
+
 	endpoint.Use()
+	if opts.deadLetter != nil {
+		opts.deadLetter.Use()
+	}
+	rs.wait.Add(1)
+	go rs.run(opts.clock.Now())
 	rs.wait.Add(1)
-	go rs.run(clock.Now())
+	go rs.compactLoop(opts.clock.Now())
 	return rs
 }
 
 func (rs *RetryingSender) Send(report metrics.StampedMetricReport) error {
-	rs.closeMutex.RLock()
-	defer rs.closeMutex.RUnlock()
-	if rs.closed {
-		return errors.New("RetryingSender: Send called on closed sender")
+	ps, err := rs.Prepare(report)
+	if err != nil {
+		return err
+	}
+	return ps.Send()
+}
+
+// Prepare runs report through BuildReport - the only step of a send that can fail without having
+// changed any durable state - and returns a retrySend that commits the result. See
+// pipeline.Sender.Prepare.
+func (rs *RetryingSender) Prepare(report metrics.StampedMetricReport) (pipeline.PreparedSend, error) {
+	if rs.alreadySeen(report.Id) {
+		// We've already durably queued a report with this ID - this is a duplicate submission (e.g.
+		// a frontend retry after losing track of an earlier, successful call). Swallow it rather than
+		// queuing - and potentially sending - it again.
+		return &retrySend{rs: rs, report: report, duplicate: true}, nil
 	}
 
 	epr, err := rs.endpoint.BuildReport(report)
 	if err != nil {
 		rs.recorder.SendFailed(report.Id, rs.endpoint.Name())
+		rs.notifyFailed(report, err, false)
+		return nil, err
+	}
+	return &retrySend{rs: rs, report: report, epr: epr}, nil
+}
+
+// SendPrepared resumes a Prepare/Send that was interrupted by a crash, re-committing the
+// EndpointReport marshaled into payload by a prior retrySend.Payload. Like Send, it's a no-op for
+// a report ID this sender has already durably queued.
+// See pipeline.Sender.SendPrepared.
+func (rs *RetryingSender) SendPrepared(ctx context.Context, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var epr pipeline.EndpointReport
+	if err := json.Unmarshal(payload, &epr); err != nil {
 		return err
 	}
+	if rs.alreadySeen(epr.Id) {
+		return nil
+	}
+	return rs.commit(epr.StampedMetricReport, epr)
+}
+
+// retrySend is the pipeline.PreparedSend returned by RetryingSender.Prepare.
+type retrySend struct {
+	rs        *RetryingSender
+	report    metrics.StampedMetricReport
+	epr       pipeline.EndpointReport
+	duplicate bool
+}
+
+func (s *retrySend) Send() error {
+	if s.duplicate {
+		glog.V(2).Infof("RetryingSender.Send: swallowing duplicate report id: %v", s.report.Id)
+		return nil
+	}
+	return s.rs.commit(s.report, s.epr)
+}
+
+func (s *retrySend) Payload() ([]byte, error) {
+	if s.duplicate {
+		return nil, nil
+	}
+	return json.Marshal(s.epr)
+}
+
+// commit durably enqueues epr - the EndpointReport built from report during Prepare - blocking
+// until it's been persisted (and synced to disk) or failed to be.
+func (rs *RetryingSender) commit(report metrics.StampedMetricReport, epr pipeline.EndpointReport) error {
+	rs.closeMutex.RLock()
+	defer rs.closeMutex.RUnlock()
+	if rs.closed {
+		return errors.New("RetryingSender: Send called on closed sender")
+	}
 
 	msg := addMsg{
 		entry:  queueEntry{epr, rs.clock.Now()},
 		result: make(chan error),
 	}
 	rs.add <- msg
-	err = <-msg.result
+	err := <-msg.result
 
-	if err != nil {
+	if err == nil {
+		rs.markSeen(report.Id)
+	} else {
 		// Record this immediate failure.
 		rs.recorder.SendFailed(report.Id, rs.endpoint.Name())
+		rs.notifyFailed(report, err, false)
 	}
 	return err
 }
@@ -120,6 +529,61 @@ func (rs *RetryingSender) Endpoints() []string {
 	return []string{rs.endpoint.Name()}
 }
 
+// AddWatcher registers w to observe every report this RetryingSender's endpoint handles from this
+// point forward. If the wrapped endpoint fires its own watcher events (see watchableEndpoint), w
+// is also registered directly with it.
+// See pipeline.Sender.AddWatcher.
+func (rs *RetryingSender) AddWatcher(w pipeline.SendWatcher) {
+	rs.watcherMu.Lock()
+	rs.watchers = append(rs.watchers, w)
+	rs.watcherMu.Unlock()
+	if watchable, ok := rs.endpoint.(watchableEndpoint); ok {
+		watchable.AddWatcher(w)
+	}
+}
+
+// SetTracer configures rs to emit an OpenTelemetry span for every send attempt from this point
+// forward, exported per t's configuration. Without a call to SetTracer, rs's tracer is a no-op, so
+// tracing is opt-in with no overhead when unconfigured.
+func (rs *RetryingSender) SetTracer(t *tracing.Tracer) {
+	rs.tracer = t
+}
+
+func (rs *RetryingSender) notifySent(report metrics.StampedMetricReport) {
+	rs.watcherMu.Lock()
+	watchers := rs.watchers
+	rs.watcherMu.Unlock()
+	for _, w := range watchers {
+		w.OnSent(report, rs.endpoint.Name())
+	}
+}
+
+func (rs *RetryingSender) notifyFailed(report metrics.StampedMetricReport, err error, transient bool) {
+	rs.watcherMu.Lock()
+	watchers := rs.watchers
+	rs.watcherMu.Unlock()
+	for _, w := range watchers {
+		w.OnFailed(report, rs.endpoint.Name(), err, transient)
+	}
+}
+
+// alreadySeen returns whether id has already been accepted by a prior Send call.
+func (rs *RetryingSender) alreadySeen(id string) bool {
+	rs.dedupMutex.Lock()
+	defer rs.dedupMutex.Unlock()
+	return rs.dedup.seen(id)
+}
+
+// markSeen records id as accepted, persisting the updated dedup cache.
+func (rs *RetryingSender) markSeen(id string) {
+	rs.dedupMutex.Lock()
+	defer rs.dedupMutex.Unlock()
+	rs.dedup.add(id)
+	if err := rs.dedupValue.Store(rs.dedup); err != nil {
+		glog.Errorf("RetryingSender.markSeen: persisting dedup cache: %+v", err)
+	}
+}
+
 // Use increments the RetryingSender's usage count.
 // See pipeline.Component.Use.
 func (rs *RetryingSender) Use() {
@@ -136,11 +600,17 @@ func (rs *RetryingSender) Release() error {
 		rs.closeMutex.Lock()
 		if !rs.closed {
 			close(rs.add)
+			close(rs.stopCompact)
 			rs.closed = true
 		}
 		rs.closeMutex.Unlock()
+		rs.cancel()
 		rs.wait.Wait()
-		return rs.endpoint.Release()
+		err := rs.endpoint.Release()
+		if rs.deadLetter != nil {
+			err = multierror.Append(nil, err, rs.deadLetter.Release()).ErrorOrNil()
+		}
+		return err
 	})
 }
 
@@ -154,16 +624,21 @@ func (rs *RetryingSender) run(start time.Time) {
 			// report is sent.
 			timer = clock.NewStoppedTimer()
 		} else {
-			// Compute the next retry time, which is the current time + current delay + [0,1000) ms jitter
+			// Compute the next retry time. rs.delay was itself chosen via full jitter (see
+			// fullJitter), so no further randomization is needed here.
 			now := rs.clock.Now()
-			jitter := time.Duration(rand.Int63n(1000)) * time.Millisecond
-			nextFire := now.Add(rs.delay - now.Sub(rs.lastAttempt)).Add(jitter)
+			nextFire := now.Add(rs.delay - now.Sub(rs.lastAttempt))
 			timer = rs.clock.NewTimerAt(nextFire)
 		}
 		select {
 		case msg, ok := <-rs.add:
 			if ok {
-				err := rs.queue.Enqueue(msg.entry)
+				err := rs.enqueueBounded(msg.entry)
+				if err == nil {
+					// Force the enqueued entry to survive a crash before acknowledging Send, even
+					// if the underlying Persistence buffers durability until Sync (e.g. WAL mode).
+					err = rs.persistence.Sync()
+				}
 				if err != nil {
 					msg.result <- err
 					break
@@ -179,11 +654,57 @@ func (rs *RetryingSender) run(start time.Time) {
 			}
 		case now := <-timer.GetC():
 			rs.maybeSend(now)
+		case msg := <-rs.reconfig:
+			rs.applyRetrySettings(msg.settings)
+			msg.result <- nil
 		}
 		timer.Stop()
 	}
 }
 
+// applyRetrySettings updates rs's policy-derived fields from s in place. It's only ever called
+// from rs.run, so it needs no locking of its own; the breaker and budget are likewise updated via
+// their own configure methods rather than being replaced outright, so a retry already underway or
+// an accumulated budget carries over across the change.
+func (rs *RetryingSender) applyRetrySettings(s retrySettings) {
+	rs.minDelay = s.minDelay
+	rs.maxDelay = s.maxDelay
+	rs.backoff = backoffStrategyFor(s.backoffPolicy, s.minDelay, s.maxDelay, rs.rnd)
+	rs.maxQueueTime = s.maxQueue
+	rs.maxAttempts = s.maxAttempts
+	rs.breaker.configure(s.breakerThreshold, s.breakerCooldown, s.breakerProbeCount)
+	rs.budget.configure(s.budgetRatio, retryBudgetMax)
+	rs.batchMaxReports = s.batchMaxReports
+	rs.batchMaxBytes = s.batchMaxBytes
+	rs.maxQueueItems = s.maxQueueItems
+	rs.maxQueueBytes = s.maxQueueBytes
+	rs.queueFullPolicy = s.queueFullPolicy
+	rs.enqueueTimeout = s.enqueueTimeout
+	rs.sendTimeout = s.sendTimeout
+}
+
+// ApplyConfig implements pipeline.Reconfigurable. It looks up rs's own endpoint by name in new,
+// and - if found - applies its RetryPolicy in place via rs's run loop, preserving the sender's
+// in-flight retry queue, circuit breaker state, and retry budget rather than rebuilding the sender
+// from scratch. It returns an error, leaving rs unchanged, if rs's endpoint is no longer present in
+// new (the caller should tear the sender down instead).
+func (rs *RetryingSender) ApplyConfig(old, new *config.Config) error {
+	cfgep := new.Endpoints.Get(rs.endpoint.Name())
+	if cfgep == nil {
+		return fmt.Errorf("RetryingSender: ApplyConfig: endpoint %v no longer present", rs.endpoint.Name())
+	}
+
+	rs.closeMutex.RLock()
+	defer rs.closeMutex.RUnlock()
+	if rs.closed {
+		return errors.New("RetryingSender: ApplyConfig called on closed sender")
+	}
+
+	msg := reconfigMsg{settings: resolveRetrySettings(cfgep.Retry), result: make(chan error)}
+	rs.reconfig <- msg
+	return <-msg.result
+}
+
 // maybeSend retries a pending send if the required time delay has elapsed.
 func (rs *RetryingSender) maybeSend(now time.Time) {
 	if now.Before(rs.lastAttempt.Add(rs.delay)) {
@@ -191,46 +712,395 @@ func (rs *RetryingSender) maybeSend(now time.Time) {
 		return
 	}
 	for {
-		entry := &queueEntry{}
-		if loaderr := rs.queue.Peek(entry); loaderr == persistence.ErrNotFound {
+		entries, loaderr := rs.peekNext()
+		if loaderr == persistence.ErrNotFound {
 			break
 		} else if loaderr != nil {
 			// We failed to load from the persistent queue. This isn't recoverable.
 			panic("RetryingSender.maybeSend: loading from retry queue: " + loaderr.Error())
 		}
-		if senderr := rs.endpoint.Send(entry.Report); senderr != nil {
-			// We've encountered a send error. If the error is considered transient and the entry hasn't
-			// reached its maximum queue time, we'll leave it in the queue and retry. Otherwise it's
-			// removed from the queue, logged, and recorded as a failure.
-			expired := rs.clock.Now().Sub(entry.SendTime) > *maxQueueTime
-			if !expired && rs.endpoint.IsTransient(senderr) {
-				// Set next attempt
+		head := entries[0]
+
+		allowed := rs.breaker.allow(now)
+		rs.observeBreakerState()
+		if !allowed {
+			// The circuit breaker is open: leave the entries queued and reschedule without touching
+			// the endpoint.
+			rs.lastAttempt = now
+			rs.delay = rs.breaker.retryAfter(now)
+			rs.observeBackoffDelay()
+			break
+		}
+
+		if head.Report.Id != rs.curId {
+			// A new entry has reached the head of the queue; start counting its attempts afresh.
+			// This count is in-memory only, like rs.delay, so a crash mid-retry resets it.
+			rs.curId = head.Report.Id
+			rs.curAttempts = 0
+		}
+		rs.curAttempts++
+
+		span := rs.tracer.StartSpan("retryingsender.send", tracing.TraceIDFromReportID(head.Report.Id), map[string]string{
+			"endpoint": rs.endpoint.Name(),
+			"attempt":  strconv.Itoa(rs.curAttempts),
+			"backoff":  rs.delay.String(),
+		})
+		senderr := rs.send(entries)
+		span.End(senderr)
+		if senderr != nil {
+			// We've encountered a send error. The endpoint's Retryer classifies it as retryable,
+			// droppable, or an immediate failure. A retryable error is still subject to MaxQueueTime
+			// and the retry budget, after either of which it's treated the same as an immediate
+			// failure. When entries was sent via BatchEndpoint.SendBatch, there's no way to
+			// acknowledge individual reports within the failed batch, so every entry in it gets the
+			// same classification.
+			decision := rs.retryer.Classify(senderr)
+			expired := rs.clock.Now().Sub(head.SendTime) > rs.maxQueueTime ||
+				(rs.maxAttempts > 0 && rs.curAttempts >= rs.maxAttempts)
+			// RetryActionDrop indicates the report is no longer relevant rather than a failure, so
+			// it doesn't spend retry budget. Every other classification - whether ultimately
+			// retried, expired, or immediately non-retryable - does.
+			budgetOK := true
+			if decision.Action != RetryActionDrop {
+				budgetOK = rs.budget.withdraw()
+			}
+			retrying := decision.Action == RetryActionRetry && !expired && budgetOK
+			if retrying {
+				rs.breaker.recordFailure(now)
+				rs.observeBreakerState()
+				// Set next attempt, choosing the delay via rs.backoff. This spreads retries across
+				// the backoff window, rather than clustering them near a single deterministic delay,
+				// to avoid retry storms across many agents.
 				rs.lastAttempt = now
-				rs.delay = bounded(rs.delay*2, rs.minDelay, rs.maxDelay)
+				delay := decision.Delay
+				if delay == 0 {
+					delay = rs.backoff.Next(rs.curAttempts, rs.delay)
+				}
+				rs.delay = delay
+				rs.observeBackoffDelay()
 				glog.Warningf("RetryingSender.maybeSend [%[1]T - transient; will retry]: %[1]s", senderr)
 				break
-			} else if expired {
-				glog.Errorf("RetryingSender.maybeSend [%[1]T - retry expired]: %[1]s", senderr)
-				rs.recorder.SendFailed(entry.Report.Id, rs.endpoint.Name())
+			} else if decision.Action == RetryActionDrop {
+				glog.Warningf("RetryingSender.maybeSend [%[1]T - dropping without retry]: %[1]s", senderr)
+				for _, e := range entries {
+					rs.notifyFailed(e.Report.StampedMetricReport, senderr, false)
+				}
 			} else {
-				glog.Errorf("RetryingSender.maybeSend [%[1]T - will NOT retry]: %[1]s", senderr)
-				rs.recorder.SendFailed(entry.Report.Id, rs.endpoint.Name())
+				if decision.Action == RetryActionRetry {
+					// Either the retry queue time expired or the retry budget is exhausted.
+					rs.breaker.recordFailure(now)
+					rs.observeBreakerState()
+					if !budgetOK {
+						glog.Warningf("RetryingSender.maybeSend [%[1]T - retry budget exhausted; will NOT retry]: %[1]s", senderr)
+					} else {
+						glog.Errorf("RetryingSender.maybeSend [%[1]T - retry expired]: %[1]s", senderr)
+					}
+				} else {
+					glog.Errorf("RetryingSender.maybeSend [%[1]T - will NOT retry]: %[1]s", senderr)
+				}
+				for i := range entries {
+					e := entries[i]
+					rs.recorder.SendFailed(e.Report.Id, rs.endpoint.Name())
+					rs.notifyFailed(e.Report.StampedMetricReport, senderr, decision.Action == RetryActionRetry)
+					rs.deadLetterReport(&e, senderr)
+				}
 			}
 		} else {
 			// Send was successful.
-			rs.recorder.SendSucceeded(entry.Report.Id, rs.endpoint.Name())
+			rs.breaker.recordSuccess()
+			rs.observeBreakerState()
+			rs.budget.credit()
+			rs.backoff.Reset()
+			for _, e := range entries {
+				rs.recorder.SendSucceeded(e.Report.Id, rs.endpoint.Name())
+				rs.notifySent(e.Report.StampedMetricReport)
+			}
 		}
 
-		// At this point we've either successfully sent the report or encountered a non-transient error.
-		// In either scenario, the report is removed from the queue and the retry delay is reset.
-		if poperr := rs.queue.Dequeue(nil); poperr != nil {
-			// We failed to pop the sent entry off the queue. This isn't recoverable.
-			glog.Errorf("RetryingSender.maybeSend: dequeuing from retry queue: " + poperr.Error() + " we've either successfully sent the report or encountered a non-transient error")
+		// Whether sent or given up on, head.Report.Id is no longer the queue head; the next peek
+		// will see a different entry (or none), so forget its attempt count.
+		rs.curId = ""
+		rs.curAttempts = 0
+
+		// At this point we've either successfully sent the report(s) or encountered a non-transient
+		// error. In either scenario, the entries are removed from the queue and the retry delay is
+		// reset.
+		if poperr := rs.queue.DequeueN(len(entries)); poperr != nil {
+			// We failed to pop the sent entries off the queue. This isn't recoverable.
+			glog.Errorf("RetryingSender.maybeSend: dequeuing from retry queue: " + poperr.Error() + " we've either successfully sent the report(s) or encountered a non-transient error")
 			return
 		}
 
 		rs.lastAttempt = now
 		rs.delay = 0
+		rs.observeBackoffDelay()
+	}
+}
+
+// peekNext loads the next entries to attempt: a single entry, or - when rs.batchEndpoint is set
+// and configured with a BatchMaxReports greater than 1 - up to that many entries, further trimmed
+// to rs.batchMaxBytes. The returned slice always has at least one entry on a nil error.
+func (rs *RetryingSender) peekNext() ([]queueEntry, error) {
+	max := 1
+	if rs.batchEndpoint != nil && rs.batchMaxReports > 1 {
+		max = rs.batchMaxReports
+	}
+	var entries []queueEntry
+	if _, err := rs.queue.PeekBatch(max, &entries); err != nil {
+		return nil, err
+	}
+	return trimToByteLimit(entries, rs.batchMaxBytes), nil
+}
+
+// trimToByteLimit trims entries to a prefix whose total marshaled size doesn't exceed maxBytes,
+// always keeping at least one entry so a single oversized entry still gets sent rather than
+// stalling the queue behind a limit it can never satisfy. maxBytes <= 0 disables the cap.
+func trimToByteLimit(entries []queueEntry, maxBytes int64) []queueEntry {
+	if maxBytes <= 0 || len(entries) <= 1 {
+		return entries
+	}
+	var total int64
+	for i, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		total += int64(len(b))
+		if total > maxBytes {
+			if i == 0 {
+				return entries[:1]
+			}
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+// send sends entries via rs.batchEndpoint.SendBatch if it's configured and there's more than one,
+// or via the regular Endpoint.SendContext otherwise. The single-report path runs under a context
+// derived from rs.ctx, bounded by rs.sendTimeout if set; rs.ctx is canceled by Release, so a send
+// blocked in a misbehaving endpoint doesn't keep Release waiting on it forever. SendBatch doesn't
+// take a context, so a batched send isn't interruptible this way.
+func (rs *RetryingSender) send(entries []queueEntry) error {
+	if rs.batchEndpoint != nil && len(entries) > 1 {
+		reports := make([]pipeline.EndpointReport, len(entries))
+		for i, e := range entries {
+			reports[i] = e.Report
+		}
+		return rs.batchEndpoint.SendBatch(reports)
+	}
+	ctx := rs.ctx
+	if rs.sendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rs.sendTimeout)
+		defer cancel()
+	}
+	return rs.endpoint.SendContext(ctx, entries[0].Report)
+}
+
+// enqueueBounded enqueues entry, honoring rs.maxQueueItems/rs.maxQueueBytes and rs.queueFullPolicy
+// if either limit is configured; with no limit configured it falls back to a plain Enqueue,
+// preserving the traditional unbounded behavior. It's called only from run()'s own goroutine, so
+// it's free to block that goroutine (applying backpressure to Send) or to dequeue entries
+// (eviction) without any extra synchronization.
+func (rs *RetryingSender) enqueueBounded(entry queueEntry) error {
+	if rs.maxQueueItems <= 0 && rs.maxQueueBytes <= 0 {
+		return rs.queue.Enqueue(entry)
+	}
+	if rs.queueFullPolicy == config.QueueFullDropOldest {
+		return rs.enqueueDropOldest(entry)
+	}
+	return rs.enqueueBlocking(entry)
+}
+
+// enqueueBlocking implements config.QueueFullBlock: if the queue is full, it waits once for up to
+// rs.enqueueTimeout - giving a scheduled retry (see maybeSend) a chance to free room - then makes a
+// single further attempt before giving up with a *persistence.ErrQueueFull. A zero rs.enqueueTimeout
+// (the default) fails immediately without waiting at all. This is a deliberately simple form of
+// backpressure: one bounded wait rather than an unbounded or continuously-polled block, so it stays
+// easy to reason about and to drive deterministically under a mocked clock.
+func (rs *RetryingSender) enqueueBlocking(entry queueEntry) error {
+	err := rs.queue.EnqueueWithLimit(entry, rs.maxQueueItems, rs.maxQueueBytes)
+	if _, full := err.(*persistence.ErrQueueFull); !full {
+		return err
+	}
+	if rs.enqueueTimeout <= 0 {
+		return err
+	}
+	timer := rs.clock.NewTimerAt(rs.clock.Now().Add(rs.enqueueTimeout))
+	<-timer.GetC()
+	timer.Stop()
+	rs.maybeSend(rs.clock.Now())
+	return rs.queue.EnqueueWithLimit(entry, rs.maxQueueItems, rs.maxQueueBytes)
+}
+
+// enqueueDropOldest implements config.QueueFullDropOldest: while the queue is too full for entry,
+// it evicts the entry at the head of the queue - recording it as a failed send, as if the endpoint
+// had rejected it - to make room. Eviction is naturally bounded: each iteration removes one entry,
+// so it terminates once the queue is empty (EnqueueWithLimit then succeeds against zero existing
+// entries, or Peek reports the queue empty and we give up with the original error).
+func (rs *RetryingSender) enqueueDropOldest(entry queueEntry) error {
+	for {
+		err := rs.queue.EnqueueWithLimit(entry, rs.maxQueueItems, rs.maxQueueBytes)
+		if _, full := err.(*persistence.ErrQueueFull); !full {
+			return err
+		}
+		var victim queueEntry
+		if peekErr := rs.queue.Peek(&victim); peekErr != nil {
+			return err
+		}
+		if derr := rs.queue.Dequeue(nil); derr != nil {
+			return derr
+		}
+		rs.recorder.SendFailed(victim.Report.Id, rs.endpoint.Name())
+		rs.notifyFailed(victim.Report.StampedMetricReport, err, false)
+		glog.Warningf("RetryingSender.enqueueDropOldest: evicting queued report %v to make room", victim.Report.Id)
+	}
+}
+
+// compactLoop periodically merges adjacent retry-queue entries to reduce the disk space a long
+// outage's backlog occupies. It runs independently of run()'s send loop: compaction only rewrites
+// entries that are already durably queued, and doesn't change their order. Like run(), it
+// reschedules its own wakeup via NewTimerAt off a running "next" time rather than using a Ticker,
+// so a single long pause between wakeups (e.g. a test jumping its mock clock far forward) triggers
+// one prompt compaction instead of a Ticker's catch-up semantics replaying every interval it missed.
+func (rs *RetryingSender) compactLoop(start time.Time) {
+	defer rs.wait.Done()
+	next := start.Add(compactInterval)
+	for {
+		timer := rs.clock.NewTimerAt(next)
+		select {
+		case now := <-timer.GetC():
+			rs.compactQueue()
+			next = now.Add(compactInterval)
+		case <-rs.stopCompact:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// compactQueue merges adjacent queue entries that report the same metric and labels, analogous to
+// the merge/rollup strategy inputs.Aggregator applies before persistence, but applied here after
+// the fact so it also shrinks a backlog that's accumulated during an outage rather than only once
+// it's drained. A merged entry keeps its earlier constituent's SendTime and Id - so MaxQueueSeconds
+// expiry and queue-age stats still reflect the oldest data it contains - summing the two values and
+// extending EndTime to the later constituent's. The earlier constituent's send outcome is no longer
+// reported individually once merged into another entry.
+func (rs *RetryingSender) compactQueue() {
+	if err := rs.queue.Compact(mergeAdjacentEntries); err != nil && err != persistence.ErrNotFound {
+		glog.Errorf("RetryingSender.compactQueue: %v", err)
+	}
+}
+
+// mergeAdjacentEntries folds each run of mergeableEntries in raw down to a single entry.
+func mergeAdjacentEntries(raw []json.RawMessage) ([]json.RawMessage, error) {
+	var merged []queueEntry
+	for _, r := range raw {
+		var e queueEntry
+		if err := json.Unmarshal(r, &e); err != nil {
+			return nil, err
+		}
+		if n := len(merged); n > 0 && mergeableEntries(merged[n-1], e) {
+			merged[n-1] = mergeEntries(merged[n-1], e)
+			continue
+		}
+		merged = append(merged, e)
+	}
+	out := make([]json.RawMessage, len(merged))
+	for i, e := range merged {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// mergeableEntries returns whether b can be folded into a: the same metric name and label set, and
+// values reported as the same kind (both integer or both double).
+func mergeableEntries(a, b queueEntry) bool {
+	if a.Report.Name != b.Report.Name {
+		return false
+	}
+	if (a.Report.Value.Int64Value != nil) != (b.Report.Value.Int64Value != nil) {
+		return false
+	}
+	return labelsEqual(a.Report.Labels, b.Report.Labels)
+}
+
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeEntries folds b into a, summing their reported values and extending a's EndTime to b's if
+// later. a otherwise keeps its own fields, including SendTime and Id.
+func mergeEntries(a, b queueEntry) queueEntry {
+	if a.Report.Value.Int64Value != nil {
+		sum := *a.Report.Value.Int64Value + *b.Report.Value.Int64Value
+		a.Report.Value.Int64Value = &sum
+	} else if a.Report.Value.DoubleValue != nil {
+		sum := *a.Report.Value.DoubleValue + *b.Report.Value.DoubleValue
+		a.Report.Value.DoubleValue = &sum
+	}
+	if b.Report.EndTime.After(a.Report.EndTime) {
+		a.Report.EndTime = b.Report.EndTime
+	}
+	return a
+}
+
+// deadLetterReport records that rs is giving up on entry, reporting it to rs.deadLetterObs (if
+// rs's configured Recorder implements stats.DeadLetterObserver) and handing it off to rs's
+// configured DeadLetterSink, if any. A sink error is logged but otherwise ignored, consistent with
+// pipeline.DeadLetterSink's contract.
+func (rs *RetryingSender) deadLetterReport(entry *queueEntry, sendErr error) {
+	if rs.deadLetterObs != nil {
+		rs.deadLetterObs.ObserveDeadLetter(rs.endpoint.Name())
+	}
+	if rs.deadLetter == nil {
+		return
+	}
+	if err := rs.deadLetter.DeadLetter(pipeline.DeadLetterEntry{
+		Endpoint:  rs.endpoint.Name(),
+		Report:    entry.Report,
+		FirstSeen: entry.SendTime,
+		LastError: sendErr.Error(),
+		Attempts:  rs.curAttempts,
+	}); err != nil {
+		glog.Errorf("RetryingSender.maybeSend: dead-letter sink: %v", err)
+	}
+}
+
+// observeBreakerState reports rs.breaker's current state to rs.breakerObs (if rs's configured
+// Recorder implements stats.BreakerObserver), but only when it's changed since the last call - the
+// breaker's allow/recordSuccess/recordFailure methods are called on every send attempt regardless
+// of whether they actually transition state.
+func (rs *RetryingSender) observeBreakerState() {
+	state := rs.breaker.State()
+	if state == rs.lastBreakerState {
+		return
+	}
+	rs.lastBreakerState = state
+	if rs.breakerObs != nil {
+		rs.breakerObs.ObserveBreakerStateChange(rs.endpoint.Name(), state.String())
+	}
+}
+
+// observeBackoffDelay reports rs's current retry delay to rs.backoffObs, if rs's configured
+// Recorder implements stats.BackoffObserver. Unlike observeBreakerState, this always reports -
+// every call site already only runs when rs.delay has actually changed.
+func (rs *RetryingSender) observeBackoffDelay() {
+	if rs.backoffObs != nil {
+		rs.backoffObs.ObserveBackoffDelay(rs.endpoint.Name(), rs.delay)
 	}
 }
 
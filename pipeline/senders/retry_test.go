@@ -15,21 +15,112 @@
 package senders
 
 import (
+	"context"
 	"errors"
+	"math/rand"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/ubbagent/config"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
 	"github.com/GoogleCloudPlatform/ubbagent/testlib"
 )
 
+// newTestRand returns a freshly seeded rand.Rand. Tests that exercise a randomized backoff policy
+// use a fixed seed so a failure is reproducible; it's recreated per sender rather than shared so
+// one test's consumption of random values can't shift another's.
+func newTestRand() *rand.Rand {
+	return rand.New(rand.NewSource(1))
+}
+
 const (
 	testMinDelay = 2 * time.Second
 	testMaxDelay = 60 * time.Second
 )
 
+// mockDeadLetterSink is a pipeline.DeadLetterSink that records every entry handed to it.
+type mockDeadLetterSink struct {
+	mu      sync.Mutex
+	entries []pipeline.DeadLetterEntry
+}
+
+func (s *mockDeadLetterSink) DeadLetter(entry pipeline.DeadLetterEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *mockDeadLetterSink) Entries() []pipeline.DeadLetterEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries
+}
+
+func (s *mockDeadLetterSink) Use()           {}
+func (s *mockDeadLetterSink) Release() error { return nil }
+
+// mockBatchEndpoint wraps a testlib.MockEndpoint to additionally implement
+// pipeline.BatchEndpoint, recording the reports passed to each SendBatch call. SetSendErr governs
+// both Send and SendBatch, mirroring how a real BatchEndpoint would use one send path's error
+// handling for both.
+type mockBatchEndpoint struct {
+	*testlib.MockEndpoint
+	mu      sync.Mutex
+	sendErr error
+	batches [][]pipeline.EndpointReport
+}
+
+func newMockBatchEndpoint(name string) *mockBatchEndpoint {
+	return &mockBatchEndpoint{MockEndpoint: testlib.NewMockEndpoint(name)}
+}
+
+func (ep *mockBatchEndpoint) SetSendErr(err error) {
+	ep.mu.Lock()
+	ep.sendErr = err
+	ep.mu.Unlock()
+	ep.MockEndpoint.SetSendErr(err)
+}
+
+func (ep *mockBatchEndpoint) SendBatch(reports []pipeline.EndpointReport) error {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.sendErr != nil {
+		return ep.sendErr
+	}
+	ep.batches = append(ep.batches, reports)
+	return nil
+}
+
+// blockingEndpoint wraps a testlib.MockEndpoint so SendContext hangs until the context passed to
+// it is canceled, rather than returning on its own - simulating an HTTP call that never completes.
+// started signals once the blocked call is underway, so a test can wait for it before measuring
+// how long Release takes to interrupt it.
+type blockingEndpoint struct {
+	*testlib.MockEndpoint
+	started chan struct{}
+}
+
+func newBlockingEndpoint(name string) *blockingEndpoint {
+	return &blockingEndpoint{MockEndpoint: testlib.NewMockEndpoint(name), started: make(chan struct{}, 1)}
+}
+
+func (ep *blockingEndpoint) SendContext(ctx context.Context, report pipeline.EndpointReport) error {
+	ep.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (ep *mockBatchEndpoint) Batches() [][]pipeline.EndpointReport {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.batches
+}
+
 func TestRetryingSender(t *testing.T) {
 	report1 := metrics.StampedMetricReport{
 		Id: "report1",
@@ -63,7 +154,7 @@ func TestRetryingSender(t *testing.T) {
 		persist := persistence.NewMemoryPersistence()
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
-		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
 		buildErr := errors.New("build failure")
 		ep.SetBuildErr(buildErr)
 		err := rs.Send(report1)
@@ -76,7 +167,7 @@ func TestRetryingSender(t *testing.T) {
 		persist := persistence.NewMemoryPersistence()
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
-		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
 		mc.SetNow(time.Unix(2000, 0))
 		ep.DoAndWait(t, 1, func() {
 			if err := rs.Send(report1); err != nil {
@@ -89,39 +180,510 @@ func TestRetryingSender(t *testing.T) {
 		}
 	})
 
-	t.Run("failed send is retried with exponential backoff", func(t *testing.T) {
+	t.Run("failed send is retried with full-jitter backoff", func(t *testing.T) {
 		persist := persistence.NewMemoryPersistence()
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
 		ep.SetSendErr(errors.New("send failure"))
-		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
 		now := time.Unix(3000, 0)
 		mc.SetNow(now)
 		if err := rs.Send(report1); err != nil {
 			t.Fatalf("Unexpected send error: %+v", err)
 		}
-		// Exponential delay minimum is 2 seconds (defined above as testMinDelay)
-		var expectedDelays = []time.Duration{2, 4, 8, 16, 32}
-		for _, delay := range expectedDelays {
-			expectedNext := now.Add(delay * time.Second)
-			now = waitForNewTimer(mc, expectedNext, expectedNext.Add(1*time.Second), t)
+		// Each retry's delay is chosen via full jitter - uniformly at random in [testMinDelay,
+		// ceiling] - where ceiling doubles (capped at testMaxDelay) after every failure.
+		var ceilings = []time.Duration{2, 4, 8, 16, 32}
+		for _, ceiling := range ceilings {
+			lower := now.Add(testMinDelay)
+			upper := now.Add(ceiling * time.Second).Add(time.Nanosecond)
+			now = waitForNewTimer(mc, lower, upper, t)
 			mc.SetNow(now)
 		}
 
-		// Wait for the last one.
-		expectedNext := now.Add(testMaxDelay)
-		waitForNewTimer(mc, expectedNext, expectedNext.Add(1*time.Second), t)
+		// Wait for the last one: after the 6th failure, the ceiling is capped at testMaxDelay.
+		lower := now.Add(testMinDelay)
+		upper := now.Add(testMaxDelay).Add(time.Nanosecond)
+		waitForNewTimer(mc, lower, upper, t)
 
 		if want, got := int32(6), ep.Calls(); want != got {
 			t.Fatalf("Expected %v send calls, got: %v", want, got)
 		}
 	})
 
+	t.Run("BackoffNone retries with the deterministic doubling ceiling and no jitter", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  defaultCircuitBreakerThreshold,
+				breakerCooldown:   defaultCircuitBreakerCooldown,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    defaultRetryBudgetConstant,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffNone,
+			},
+		})
+		now := time.Unix(3000, 0)
+		mc.SetNow(now)
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		var ceilings = []time.Duration{2, 4, 8}
+		for _, ceiling := range ceilings {
+			want := now.Add(ceiling * time.Second)
+			now = waitForNewTimer(mc, want, want.Add(time.Nanosecond), t)
+			mc.SetNow(now)
+		}
+		if want, got := int32(4), ep.Calls(); want != got {
+			t.Fatalf("Expected %v send calls, got: %v", want, got)
+		}
+	})
+
+	t.Run("BackoffEqualJitter retries within [ceiling/2, ceiling]", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  defaultCircuitBreakerThreshold,
+				breakerCooldown:   defaultCircuitBreakerCooldown,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    defaultRetryBudgetConstant,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffEqualJitter,
+			},
+		})
+		now := time.Unix(3000, 0)
+		mc.SetNow(now)
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		var ceilings = []time.Duration{2, 4, 8}
+		for _, ceiling := range ceilings {
+			lower := now.Add(ceiling * time.Second / 2)
+			upper := now.Add(ceiling * time.Second).Add(time.Nanosecond)
+			now = waitForNewTimer(mc, lower, upper, t)
+			mc.SetNow(now)
+		}
+		if want, got := int32(4), ep.Calls(); want != got {
+			t.Fatalf("Expected %v send calls, got: %v", want, got)
+		}
+	})
+
+	t.Run("BackoffDecorrelatedJitter retries within [min, prev*3] capped at maxDelay", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  defaultCircuitBreakerThreshold,
+				breakerCooldown:   defaultCircuitBreakerCooldown,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    defaultRetryBudgetConstant,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffDecorrelatedJitter,
+			},
+		})
+		now := time.Unix(3000, 0)
+		mc.SetNow(now)
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		prev := testMinDelay
+		for i := 0; i < 4; i++ {
+			lower := now.Add(testMinDelay)
+			upperDelay := prev * 3
+			if upperDelay > testMaxDelay {
+				upperDelay = testMaxDelay
+			}
+			upper := now.Add(upperDelay).Add(time.Nanosecond)
+			next := waitForNewTimer(mc, lower, upper, t)
+			prev = next.Sub(now)
+			now = next
+			mc.SetNow(now)
+		}
+		if want, got := int32(5), ep.Calls(); want != got {
+			t.Fatalf("Expected %v send calls, got: %v", want, got)
+		}
+	})
+
+	t.Run("BackoffConstant retries at a fixed delay", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  defaultCircuitBreakerThreshold,
+				breakerCooldown:   defaultCircuitBreakerCooldown,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    defaultRetryBudgetConstant,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffConstant,
+			},
+		})
+		now := time.Unix(3000, 0)
+		mc.SetNow(now)
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		for i := 0; i < 3; i++ {
+			want := now.Add(testMinDelay)
+			now = waitForNewTimer(mc, want, want.Add(time.Nanosecond), t)
+			mc.SetNow(now)
+		}
+		if want, got := int32(4), ep.Calls(); want != got {
+			t.Fatalf("Expected %v send calls, got: %v", want, got)
+		}
+	})
+
+	t.Run("BackoffLinear grows the retry delay by minDelay each attempt", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  defaultCircuitBreakerThreshold,
+				breakerCooldown:   defaultCircuitBreakerCooldown,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    defaultRetryBudgetConstant,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffLinear,
+			},
+		})
+		now := time.Unix(3000, 0)
+		mc.SetNow(now)
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		for _, steps := range []time.Duration{2, 3, 4} {
+			want := now.Add(testMinDelay * steps)
+			now = waitForNewTimer(mc, want, want.Add(time.Nanosecond), t)
+			mc.SetNow(now)
+		}
+		if want, got := int32(4), ep.Calls(); want != got {
+			t.Fatalf("Expected %v send calls, got: %v", want, got)
+		}
+	})
+
+	t.Run("circuit breaker opens after repeated transient failures", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		// A threshold of 2 and a generous retry budget isolate the breaker's own behavior from
+		// backoff timing and budget exhaustion.
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  2,
+				breakerCooldown:   10 * time.Second,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    100,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffFullJitter,
+			},
+		})
+		now := time.Unix(6000, 0)
+		mc.SetNow(now)
+		ep.DoAndWait(t, 1, func() {
+			if err := rs.Send(report1); err != nil {
+				t.Fatalf("Unexpected send error: %+v", err)
+			}
+		})
+
+		// The first failure (call 1) leaves the breaker closed; wait for its scheduled retry.
+		next := waitForNewTimer(mc, now.Add(testMinDelay), now.Add(4*time.Second), t)
+		ep.DoAndWait(t, 2, func() {
+			mc.SetNow(next)
+		})
+
+		// The second failure (call 2) reaches the threshold of 2 and opens the breaker.
+		if want, got := int32(2), ep.Calls(); want != got {
+			t.Fatalf("Expected %v send calls before the breaker opens, got: %v", want, got)
+		}
+
+		// Clear the send error and advance well past the cool-down. The breaker allows exactly one
+		// half-open probe, which succeeds and closes it.
+		ep.DoAndWait(t, 3, func() {
+			ep.SetSendErr(nil)
+			mc.SetNow(next.Add(20 * time.Second))
+		})
+		if want, got := 1, len(ep.Reports()); want != got {
+			t.Fatalf("Expected %v report sent after the breaker closed, got: %v", want, got)
+		}
+	})
+
+	t.Run("CircuitBreakerProbeCount requires more than one successful probe to close the breaker", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		// As above, a threshold of 2 and a generous retry budget isolate the breaker's own behavior.
+		// With CircuitBreakerProbeCount 2, a single successful half-open probe isn't enough to close
+		// the breaker - it stays half-open until a second consecutive probe succeeds.
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  2,
+				breakerCooldown:   10 * time.Second,
+				breakerProbeCount: 2,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    100,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffFullJitter,
+			},
+		})
+		now := time.Unix(6500, 0)
+		mc.SetNow(now)
+		ep.DoAndWait(t, 1, func() {
+			if err := rs.Send(report1); err != nil {
+				t.Fatalf("Unexpected send error: %+v", err)
+			}
+		})
+
+		next := waitForNewTimer(mc, now.Add(testMinDelay), now.Add(4*time.Second), t)
+		ep.DoAndWait(t, 2, func() {
+			mc.SetNow(next)
+		})
+
+		// The second failure opens the breaker.
+		opened := next
+
+		// Clear the send error and advance well past the cool-down: the first half-open probe
+		// succeeds, but that alone isn't enough to close the breaker.
+		ep.DoAndWait(t, 3, func() {
+			ep.SetSendErr(nil)
+			mc.SetNow(opened.Add(20 * time.Second))
+		})
+		if want, got := 1, len(ep.Reports()); want != got {
+			t.Fatalf("Expected %v report sent after the first probe, got: %v", want, got)
+		}
+
+		// A second send, right away, is accepted as a further probe rather than blocked by the
+		// cool-down - the breaker must still be half-open, not fully closed - but this one fails.
+		probe2 := opened.Add(20 * time.Second)
+		ep.SetSendErr(errors.New("send failure"))
+		ep.DoAndWait(t, 4, func() {
+			if err := rs.Send(report2); err != nil {
+				t.Fatalf("Unexpected send error: %+v", err)
+			}
+		})
+
+		// Because the breaker was still half-open, this failed probe reopens it immediately, so the
+		// next attempt is gated by the full cool-down rather than by the (much shorter) backoff delay
+		// a merely-closed breaker would have scheduled.
+		waitForNewTimer(mc, probe2.Add(8*time.Second), probe2.Add(10*time.Second).Add(time.Nanosecond), t)
+	})
+
+	t.Run("retry budget exhaustion fails immediately", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		sr := testlib.NewMockStatsRecorder()
+		ep.SetSendErr(errors.New("send failure"))
+		// A budget of exactly one token (no per-success credit) lets the first failure be retried,
+		// but is exhausted by the second - isolated from the circuit breaker via a high threshold.
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    sr,
+			clock:       mc,
+			rnd:         newTestRand(),
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  100,
+				breakerCooldown:   defaultCircuitBreakerCooldown,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       0,
+				budgetConstant:    1,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffFullJitter,
+			},
+		})
+		now := time.Unix(7000, 0)
+		mc.SetNow(now)
+		ep.DoAndWait(t, 1, func() {
+			if err := rs.Send(report1); err != nil {
+				t.Fatalf("Unexpected send error: %+v", err)
+			}
+		})
+
+		next := waitForNewTimer(mc, now.Add(testMinDelay), now.Add(4*time.Second), t)
+		sr.DoAndWait(t, 1, func() {
+			mc.SetNow(next)
+		})
+
+		if want, got := []testlib.RecordedEntry{{Id: report1.Id, Handler: "mockep"}}, sr.Failed(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("sr.failed: want=%+v, got=%+v", want, got)
+		}
+		if want, got := int32(2), ep.Calls(); want != got {
+			t.Fatalf("Expected %v send calls, got: %v", want, got)
+		}
+	})
+
+	t.Run("non-retryable failure hands the report to the dead letter sink", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		sink := &mockDeadLetterSink{}
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:    ep,
+			persistence: persist,
+			recorder:    testlib.NewMockStatsRecorder(),
+			clock:       mc,
+			rnd:         newTestRand(),
+			deadLetter:  sink,
+			retrySettings: retrySettings{
+				minDelay:          testMinDelay,
+				maxDelay:          testMaxDelay,
+				maxQueue:          *maxQueueTime,
+				breakerThreshold:  100,
+				breakerCooldown:   defaultCircuitBreakerCooldown,
+				breakerProbeCount: defaultCircuitBreakerProbeCount,
+				budgetRatio:       defaultRetryBudgetRatio,
+				budgetConstant:    defaultRetryBudgetConstant,
+				queueFullPolicy:   config.QueueFullBlock,
+				backoffPolicy:     config.BackoffFullJitter,
+			},
+		})
+		ep.SetSendErr(errors.New("FATAL"))
+		now := time.Unix(8000, 0)
+		mc.SetNow(now)
+
+		ep.DoAndWait(t, 1, func() {
+			if err := rs.Send(report1); err != nil {
+				t.Fatalf("Unexpected send error: %+v", err)
+			}
+		})
+
+		entries := sink.Entries()
+		if len(entries) != 1 {
+			t.Fatalf("Expected 1 dead-lettered entry, got: %v", len(entries))
+		}
+		if entries[0].Endpoint != "mockep" {
+			t.Fatalf("Expected endpoint mockep, got: %v", entries[0].Endpoint)
+		}
+		if entries[0].Report.Id != report1.Id {
+			t.Fatalf("Expected report %v, got: %v", report1.Id, entries[0].Report.Id)
+		}
+		if entries[0].Attempts != 1 {
+			t.Fatalf("Expected 1 attempt, got: %v", entries[0].Attempts)
+		}
+		if entries[0].LastError != "FATAL" {
+			t.Fatalf("Expected LastError FATAL, got: %v", entries[0].LastError)
+		}
+	})
+
+	t.Run("reports are grouped into a single SendBatch call when BatchMaxReports allows it", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := newMockBatchEndpoint("mockbatchep")
+		ep.SetSendErr(errors.New("not yet"))
+		sr := testlib.NewMockStatsRecorder()
+		policy := &config.RetryPolicy{BatchMaxReports: 2}
+		rs := NewRetryingSender(ep, persist, sr, policy, nil)
+		mc.SetNow(time.Unix(9000, 0))
+
+		// The first report is sent (and fails) alone, since it's the only one queued so far; this
+		// also schedules the retry that picks up both reports together below.
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		if err := rs.Send(report2); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+
+		sr.DoAndWait(t, 2, func() {
+			ep.SetSendErr(nil)
+			mc.SetNow(time.Unix(9300, 0))
+		})
+
+		batches := ep.Batches()
+		if len(batches) != 1 {
+			t.Fatalf("Expected 1 SendBatch call, got: %v", len(batches))
+		}
+		if len(batches[0]) != 2 {
+			t.Fatalf("Expected 2 reports in the batch, got: %v", len(batches[0]))
+		}
+		if batches[0][0].Id != report1.Id || batches[0][1].Id != report2.Id {
+			t.Fatalf("Unexpected batch contents: %+v", batches[0])
+		}
+	})
+
 	t.Run("queue is cleared after success", func(t *testing.T) {
 		persist := persistence.NewMemoryPersistence()
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
-		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
 		ep.SetSendErr(errors.New("send failure"))
 		mc.SetNow(time.Unix(4000, 0))
 
@@ -158,7 +720,7 @@ func TestRetryingSender(t *testing.T) {
 		persist := persistence.NewMemoryPersistence()
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
-		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
 		ep.SetSendErr(errors.New("non-fatal"))
 		mc.SetNow(time.Unix(4000, 0))
 
@@ -210,7 +772,7 @@ func TestRetryingSender(t *testing.T) {
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
 		sr := testlib.NewMockStatsRecorder()
-		rs := newRetryingSender(ep, persist, sr, mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, sr, mc, newTestRand(), testMinDelay, testMaxDelay)
 		ep.SetSendErr(errors.New("send failure"))
 		mc.SetNow(time.Unix(4000, 0))
 
@@ -258,7 +820,7 @@ func TestRetryingSender(t *testing.T) {
 		persist := persistence.NewMemoryPersistence()
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
-		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
 		ep.SetSendErr(errors.New("send failure"))
 		mc.SetNow(time.Unix(5000, 0))
 
@@ -274,7 +836,7 @@ func TestRetryingSender(t *testing.T) {
 		ep = testlib.NewMockEndpoint("mockep")
 		ep.DoAndWait(t, 1, func() {
 			mc.SetNow(time.Unix(5500, 0))
-			rs = newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, testMinDelay, testMaxDelay)
+			rs = newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
 		})
 
 		// The sender should have cleared its queue. Our sent chan should be length 2.
@@ -283,12 +845,85 @@ func TestRetryingSender(t *testing.T) {
 		}
 	})
 
+	t.Run("a batch enqueued for retry survives restart and keeps FIFO order", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
+		ep.SetSendErr(errors.New("send failure"))
+		mc.SetNow(time.Unix(6000, 0))
+
+		// report1 and report2 share a metric (int-metric) and accumulate in the retry queue rather
+		// than being re-aggregated, since the aggregator has already handed each of them to the
+		// sender as its own batch.
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		if err := rs.Send(report2); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		ep.DoAndWait(t, 2, func() {}) // Wait for both initial (failing) send attempts.
+		rs.Release()
+
+		// Restart against the same persistence, now with a working endpoint.
+		ep = testlib.NewMockEndpoint("mockep")
+		ep.DoAndWait(t, 2, func() {
+			mc.SetNow(time.Unix(6500, 0))
+			rs = newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
+		})
+		rs.Release()
+
+		reports := ep.Reports()
+		if len(reports) != 2 {
+			t.Fatalf("Expected 2 reports sent after restart, got: %v", len(reports))
+		}
+		if reports[0].Id != report1.Id || reports[1].Id != report2.Id {
+			t.Fatalf("Expected reports sent in FIFO order [%v, %v], got: [%v, %v]",
+				report1.Id, report2.Id, reports[0].Id, reports[1].Id)
+		}
+	})
+
+	t.Run("maxAttempts gives up and dead-letters after the configured number of attempts", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		sr := testlib.NewMockStatsRecorder()
+		sink := &mockDeadLetterSink{}
+		policy := &config.RetryPolicy{MaxAttempts: 2}
+		rs := NewRetryingSender(ep, persist, sr, policy, sink)
+		ep.SetSendErr(errors.New("send failure"))
+		now := time.Unix(7000, 0)
+		mc.SetNow(now)
+
+		ep.DoAndWait(t, 1, func() {
+			if err := rs.Send(report1); err != nil {
+				t.Fatalf("Unexpected send error: %+v", err)
+			}
+		})
+
+		// The first failure (attempt 1) is still within maxAttempts, so it's retried.
+		next := waitForNewTimer(mc, now.Add(testMinDelay), now.Add(4*time.Second), t)
+		ep.DoAndWait(t, 2, func() {
+			mc.SetNow(next)
+		})
+
+		// The second failure (attempt 2) reaches maxAttempts, giving up instead of retrying again.
+		sr.DoAndWait(t, 1, func() {})
+
+		if want, got := []testlib.RecordedEntry{{Id: report1.Id, Handler: "mockep"}}, sr.Failed(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("sr.failed: want=%+v, got=%+v", want, got)
+		}
+		if entries := sink.Entries(); len(entries) != 1 || entries[0].Report.Id != report1.Id {
+			t.Fatalf("Expected report1 to be dead-lettered, got: %+v", entries)
+		}
+	})
+
 	t.Run("send stats are registered", func(t *testing.T) {
 		persist := persistence.NewMemoryPersistence()
 		mc := testlib.NewMockClock()
 		ep := testlib.NewMockEndpoint("mockep")
 		sr := testlib.NewMockStatsRecorder()
-		rs := newRetryingSender(ep, persist, sr, mc, testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persist, sr, mc, newTestRand(), testMinDelay, testMaxDelay)
 		mc.SetNow(time.Unix(4000, 0))
 
 		if err := rs.Send(report1); err != nil {
@@ -332,10 +967,75 @@ func TestRetryingSender(t *testing.T) {
 		}
 	})
 
+	t.Run("queueFullPolicy dropOldest evicts the oldest entry to make room", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		sr := testlib.NewMockStatsRecorder()
+		ep.SetSendErr(errors.New("send failure"))
+		policy := &config.RetryPolicy{MaxQueueItems: 1, QueueFullPolicy: config.QueueFullDropOldest}
+		rs := NewRetryingSender(ep, persist, sr, policy, nil)
+		mc.SetNow(time.Unix(10000, 0))
+
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		sr.DoAndWait(t, 1, func() {
+			// report1's own send attempt has failed and is back in the queue; report2 now has to
+			// evict it to fit within MaxQueueItems.
+			if err := rs.Send(report2); err != nil {
+				t.Fatalf("Unexpected send error: %+v", err)
+			}
+		})
+
+		if want, got := []testlib.RecordedEntry{{Id: report1.Id, Handler: "mockep"}}, sr.Failed(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("sr.failed: want=%+v, got=%+v", want, got)
+		}
+		if length, err := rs.queue.Len(); err != nil || length != 1 {
+			t.Fatalf("Expected queue length 1, got: %v, err: %v", length, err)
+		}
+	})
+
+	t.Run("compactQueue merges adjacent entries with the same metric and labels", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := testlib.NewMockEndpoint("mockep")
+		ep.SetSendErr(errors.New("send failure"))
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
+		mc.SetNow(time.Unix(11000, 0))
+
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		if err := rs.Send(report2); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+
+		rs.compactQueue()
+
+		var entries []queueEntry
+		if _, err := rs.queue.PeekBatch(0, &entries); err != nil {
+			t.Fatalf("Unexpected error peeking compacted queue: %+v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("Expected 1 merged entry, got: %v", len(entries))
+		}
+		merged := entries[0]
+		if merged.Report.Id != report1.Id {
+			t.Fatalf("Expected merged entry to keep the earlier id %v, got: %v", report1.Id, merged.Report.Id)
+		}
+		if got := *merged.Report.Value.Int64Value; got != 40 {
+			t.Fatalf("Expected merged value 40, got: %v", got)
+		}
+		if !merged.Report.EndTime.Equal(report2.EndTime) {
+			t.Fatalf("Expected merged EndTime %v, got: %v", report2.EndTime, merged.Report.EndTime)
+		}
+	})
+
 	t.Run("multiple usages", func(t *testing.T) {
 		ep := testlib.NewMockEndpoint("mockep")
 		sr := testlib.NewMockStatsRecorder()
-		rs := newRetryingSender(ep, persistence.NewMemoryPersistence(), sr, testlib.NewMockClock(), testMinDelay, testMaxDelay)
+		rs := newRetryingSender(ep, persistence.NewMemoryPersistence(), sr, testlib.NewMockClock(), newTestRand(), testMinDelay, testMaxDelay)
 
 		// Test multiple usages of the RetryingSender.
 		rs.Use()
@@ -352,6 +1052,30 @@ func TestRetryingSender(t *testing.T) {
 		}
 	})
 
+	t.Run("Release interrupts a send blocked on the endpoint", func(t *testing.T) {
+		persist := persistence.NewMemoryPersistence()
+		mc := testlib.NewMockClock()
+		ep := newBlockingEndpoint("mockep")
+		rs := newRetryingSender(ep, persist, testlib.NewMockStatsRecorder(), mc, newTestRand(), testMinDelay, testMaxDelay)
+		mc.SetNow(time.Unix(12000, 0))
+
+		if err := rs.Send(report1); err != nil {
+			t.Fatalf("Unexpected send error: %+v", err)
+		}
+		<-ep.started
+
+		released := make(chan error, 1)
+		go func() { released <- rs.Release() }()
+
+		select {
+		case err := <-released:
+			if err != nil {
+				t.Fatalf("Unexpected error from Release: %+v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Release did not return while a send was blocked on the endpoint")
+		}
+	})
 }
 
 // waitForNewTimer waits for up to ~5 seconds for a timer to be set on mc with a time between [lower,upper).
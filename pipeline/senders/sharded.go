@@ -0,0 +1,423 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/stats"
+	"github.com/GoogleCloudPlatform/ubbagent/tracing"
+	"github.com/golang/glog"
+	"github.com/hashicorp/go-multierror"
+)
+
+// defaultResizeInterval is how often a ShardedSender resamples incoming/outgoing throughput to
+// decide whether to scale its active shard count.
+const defaultResizeInterval = 10 * time.Second
+
+// ShardedSender is a pipeline.Sender that fronts a single Endpoint with up to config.RetryPolicy's
+// MaxShards parallel RetryingSender workers ("shards"), so a slow or high-latency endpoint doesn't
+// serialize every report behind one in-flight Send - the bottleneck a plain RetryingSender runs
+// into under high report rates. Every report is routed to exactly one shard by a stable hash of
+// its Id, so reports belonging to the same metric - which reuse the same Id across retries, but
+// never appear in two reports in flight at once - always land on the same shard and are therefore
+// still sent in relative order; only reports for different metrics, which have no ordering
+// relationship to begin with, are ever in flight concurrently.
+//
+// The number of shards is fixed at MaxShards for ShardedSender's lifetime: every shard owns its
+// own persisted retry queue and its own RetryingSender, and all of them run for as long as the
+// ShardedSender does. What actually scales between MinShards and MaxShards, via resizeLoop, is how
+// many shards are currently allowed to have a send in flight to the real endpoint at once - see
+// sem. Shrinking never tears a shard down or discards its backlog: a throttled shard's
+// RetryingSender keeps running and keeps draining its own queue, just with less concurrency
+// alongside it, and regains full concurrency the moment the shard count scales back up. This
+// sidesteps the usual problem with resizing a hash-mod-N router - that changing N reshuffles
+// nearly every key - without ever needing to physically move a persisted entry between queues.
+type ShardedSender struct {
+	endpoint pipeline.Endpoint
+	shards   []*RetryingSender
+	sem      *boundedSemaphore
+
+	min, max int
+	active   int32 // atomic; current shard count, kept in lockstep with sem's limit
+
+	incoming, outgoing int64 // atomic; reset every resizeInterval by resizeLoop
+	belowStreak        int   // consecutive samples where incoming outpaced outgoing; resizeLoop only
+
+	clock          clock.Clock
+	resizeInterval time.Duration
+	stopResize     chan struct{}
+	wait           sync.WaitGroup
+
+	tracker pipeline.UsageTracker
+}
+
+// NewShardedSender creates a ShardedSender for endpoint, storing each shard's state in
+// persistence. policy's MinShards/MaxShards/MaxSamplesPerSend configure the shard bounds and
+// per-shard batching; its other fields configure each shard's RetryingSender exactly as
+// NewRetryingSender would. deadLetter, if non-nil, receives reports any shard gives up on.
+func NewShardedSender(endpoint pipeline.Endpoint, persist persistence.Persistence, recorder stats.Recorder, policy *config.RetryPolicy, deadLetter pipeline.DeadLetterSink) *ShardedSender {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return newShardedSender(endpoint, persist, recorder, policy, deadLetter, clock.NewClock(), rnd, defaultResizeInterval)
+}
+
+func newShardedSender(endpoint pipeline.Endpoint, persist persistence.Persistence, recorder stats.Recorder, policy *config.RetryPolicy, deadLetter pipeline.DeadLetterSink, clk clock.Clock, rnd *rand.Rand, resizeInterval time.Duration) *ShardedSender {
+	min, max := shardBounds(policy)
+	s := resolveRetrySettings(policy)
+	if policy != nil && policy.MaxSamplesPerSend > 0 {
+		s.batchMaxReports = policy.MaxSamplesPerSend
+	}
+
+	ss := &ShardedSender{
+		endpoint:       endpoint,
+		sem:            newBoundedSemaphore(min),
+		min:            min,
+		max:            max,
+		active:         int32(min),
+		clock:          clk,
+		resizeInterval: resizeInterval,
+		stopResize:     make(chan struct{}),
+	}
+
+	countingRecorder := &shardRecorder{Recorder: recorder, outgoing: &ss.outgoing}
+	for i := 0; i < max; i++ {
+		shardEp := &shardEndpoint{Endpoint: endpoint, name: shardName(endpoint.Name(), i), sem: ss.sem}
+		shardRand := rand.New(rand.NewSource(rnd.Int63() + int64(i)))
+		rs := newRetryingSenderFull(retrySenderOptions{
+			endpoint:      shardEp,
+			persistence:   persist,
+			recorder:      countingRecorder,
+			clock:         clk,
+			rnd:           shardRand,
+			deadLetter:    deadLetter,
+			retrySettings: s,
+		})
+		ss.shards = append(ss.shards, rs)
+	}
+
+	ss.wait.Add(1)
+	go ss.resizeLoop(clk.Now())
+	return ss
+}
+
+// shardBounds resolves policy's MinShards/MaxShards into a valid (min, max) pair: max defaults to,
+// and is floored at, 1 (a single-shard ShardedSender behaves like a plain RetryingSender split
+// across one queue); min defaults to 1 and is capped at max.
+func shardBounds(policy *config.RetryPolicy) (min, max int) {
+	max = 1
+	min = 1
+	if policy != nil {
+		if policy.MaxShards > 1 {
+			max = policy.MaxShards
+		}
+		if policy.MinShards > 0 {
+			min = policy.MinShards
+		}
+	}
+	if min > max {
+		min = max
+	}
+	return min, max
+}
+
+// shardName returns the persistence/stats identity used for endpointName's i'th shard. Each
+// shard's RetryingSender derives its persisted queue name, dedup cache, and stats.Recorder calls
+// from this, so distinct shards never collide with one another - but a caller that only cares
+// about the endpoint as a whole, such as a pipeline.SendWatcher registered via AddWatcher, sees
+// endpointName unchanged; see shardWatcherAdapter.
+func shardName(endpointName string, i int) string {
+	return fmt.Sprintf("%s#shard%d", endpointName, i)
+}
+
+// shardOf returns the index, in [0, n), of the shard responsible for id. It's a pure function of
+// id and n (ShardedSender always calls it with n == len(ss.shards), which never changes), so it's
+// safe to recompute after a crash or restart - unlike the active shard count, which isn't
+// persisted and resets to min on restart - without risking a report resolving to a different
+// shard than the one it was actually durably queued under.
+func shardOf(id string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(n))
+}
+
+// Send sends report through whichever shard shardOf selects for it. See pipeline.Sender.Send.
+func (ss *ShardedSender) Send(report metrics.StampedMetricReport) error {
+	ps, err := ss.Prepare(report)
+	if err != nil {
+		return err
+	}
+	return ps.Send()
+}
+
+// Prepare routes report to its shard and prepares it there. See pipeline.Sender.Prepare.
+func (ss *ShardedSender) Prepare(report metrics.StampedMetricReport) (pipeline.PreparedSend, error) {
+	atomic.AddInt64(&ss.incoming, 1)
+	return ss.shards[shardOf(report.Id, len(ss.shards))].Prepare(report)
+}
+
+// SendPrepared resumes a Prepare/Send that was interrupted by a crash. It recovers the report Id
+// from payload and re-derives its shard via shardOf, the same way Prepare originally chose it, so
+// this works even if ShardedSender was restarted (and its active shard count reset) in between.
+// See pipeline.Sender.SendPrepared.
+func (ss *ShardedSender) SendPrepared(ctx context.Context, payload []byte) error {
+	var epr pipeline.EndpointReport
+	if err := json.Unmarshal(payload, &epr); err != nil {
+		return err
+	}
+	return ss.shards[shardOf(epr.Id, len(ss.shards))].SendPrepared(ctx, payload)
+}
+
+// Endpoints returns the name of the single Endpoint this ShardedSender wraps.
+func (ss *ShardedSender) Endpoints() []string {
+	return []string{ss.endpoint.Name()}
+}
+
+// AddWatcher registers w on every shard, rewriting each shard's own (suffixed) endpoint name back
+// to ss.endpoint.Name() first, so w sees exactly the endpoint identity it would without sharding.
+// See pipeline.Sender.AddWatcher.
+func (ss *ShardedSender) AddWatcher(w pipeline.SendWatcher) {
+	adapted := &shardWatcherAdapter{w: w, name: ss.endpoint.Name()}
+	for _, shard := range ss.shards {
+		shard.AddWatcher(adapted)
+	}
+}
+
+// SetTracer configures every shard to emit an OpenTelemetry span for every send attempt from this
+// point forward. See RetryingSender.SetTracer.
+func (ss *ShardedSender) SetTracer(t *tracing.Tracer) {
+	for _, shard := range ss.shards {
+		shard.SetTracer(t)
+	}
+}
+
+// ActiveShards returns the number of shards currently allowed to have a send in flight at once,
+// as last set by resizeLoop. It's exposed for monitoring and tests; it's not a count of how many
+// shards exist (that's always MaxShards) or how many have queued work.
+func (ss *ShardedSender) ActiveShards() int {
+	return int(atomic.LoadInt32(&ss.active))
+}
+
+// Use increments the ShardedSender's usage count. See pipeline.Component.Use.
+func (ss *ShardedSender) Use() {
+	ss.tracker.Use()
+}
+
+// Release decrements the ShardedSender's usage count. If it reaches 0, Release stops resizeLoop
+// and releases every shard in turn, blocking until all of them have gracefully shut down.
+// See pipeline.Component.Release.
+func (ss *ShardedSender) Release() error {
+	return ss.tracker.Release(func() error {
+		close(ss.stopResize)
+		ss.wait.Wait()
+		var result error
+		for _, shard := range ss.shards {
+			if err := shard.Release(); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+		return result
+	})
+}
+
+// resizeLoop periodically samples incoming/outgoing throughput and adjusts the active shard count
+// between min and max. It reschedules its own wakeup off a running "next" time, the same way
+// RetryingSender.run and RetryingSender.compactLoop do, so a single long pause between wakeups
+// (e.g. a test jumping its mock clock far forward) triggers one prompt resize check instead of
+// replaying every interval a Ticker would have missed.
+func (ss *ShardedSender) resizeLoop(start time.Time) {
+	defer ss.wait.Done()
+	next := start.Add(ss.resizeInterval)
+	for {
+		timer := ss.clock.NewTimerAt(next)
+		select {
+		case now := <-timer.GetC():
+			ss.resize()
+			next = now.Add(ss.resizeInterval)
+		case <-ss.stopResize:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// resize applies one round of the scaling heuristic: if incoming throughput has outpaced outgoing
+// for two consecutive samples, it scales up by one shard, toward max; if this sample saw no
+// traffic at all - the queue has drained and outgoing capacity is idle - it scales down by one
+// shard, toward min. Each call only ever steps by one shard, so a sustained imbalance takes
+// multiple resizeInterval periods to reach max or min, rather than jumping there in one step.
+func (ss *ShardedSender) resize() {
+	in := atomic.SwapInt64(&ss.incoming, 0)
+	out := atomic.SwapInt64(&ss.outgoing, 0)
+	active := int(atomic.LoadInt32(&ss.active))
+
+	if in > out {
+		ss.belowStreak++
+	} else {
+		ss.belowStreak = 0
+	}
+
+	switch {
+	case ss.belowStreak >= 2 && active < ss.max:
+		active++
+		ss.belowStreak = 0
+		ss.setActive(active)
+		glog.Infof("ShardedSender: scaling %v up to %d shards (incoming=%d outgoing=%d)", ss.endpoint.Name(), active, in, out)
+	case in == 0 && out == 0 && active > ss.min:
+		active--
+		ss.setActive(active)
+		glog.Infof("ShardedSender: scaling %v down to %d shards", ss.endpoint.Name(), active)
+	}
+}
+
+func (ss *ShardedSender) setActive(n int) {
+	atomic.StoreInt32(&ss.active, int32(n))
+	ss.sem.setLimit(n)
+}
+
+// shardEndpoint wraps a pipeline.Endpoint, renaming it so that a ShardedSender's per-shard
+// RetryingSenders - which each derive their persisted queue name, dedup cache, and
+// stats.Recorder identity from Endpoint.Name - get distinct, stable identities instead of
+// colliding on the same one. SendContext acquires sem before calling through to the wrapped
+// endpoint, so the ShardedSender's current active shard count actually bounds how many shards
+// may have a real send in flight at once, rather than just how many shards exist. Everything
+// else is delegated directly to the wrapped endpoint, following the same pattern
+// auditlog.AuditingEndpoint uses - and, like AuditingEndpoint, it doesn't forward BatchEndpoint:
+// a shard sends one report (or one already-batched group, if its RetryingSender is itself
+// configured with BatchMaxReports) at a time.
+type shardEndpoint struct {
+	pipeline.Endpoint
+	name string
+	sem  *boundedSemaphore
+}
+
+func (e *shardEndpoint) Name() string {
+	return e.name
+}
+
+func (e *shardEndpoint) Send(report pipeline.EndpointReport) error {
+	return e.SendContext(context.Background(), report)
+}
+
+func (e *shardEndpoint) SendContext(ctx context.Context, report pipeline.EndpointReport) error {
+	if err := e.sem.acquire(ctx); err != nil {
+		return err
+	}
+	defer e.sem.release()
+	return e.Endpoint.SendContext(ctx, report)
+}
+
+// shardWatcherAdapter rewrites the suffixed per-shard endpoint name a shard's RetryingSender
+// reports back to name - ShardedSender's real, unsuffixed endpoint name - before forwarding to w.
+type shardWatcherAdapter struct {
+	w    pipeline.SendWatcher
+	name string
+}
+
+func (a *shardWatcherAdapter) OnSent(report metrics.StampedMetricReport, _ string) {
+	a.w.OnSent(report, a.name)
+}
+
+func (a *shardWatcherAdapter) OnFailed(report metrics.StampedMetricReport, _ string, err error, transient bool) {
+	a.w.OnFailed(report, a.name, err, transient)
+}
+
+func (a *shardWatcherAdapter) OnExpired(report metrics.StampedMetricReport, _ string) {
+	a.w.OnExpired(report, a.name)
+}
+
+// shardRecorder wraps a stats.Recorder, counting every completed send - success or failure, either
+// of which frees up a shard to pick up its next queued entry - so its owning ShardedSender's
+// resizeLoop can sample outgoing throughput across all shards. Like stats.NewWarnRecorder, it only
+// forwards the base Recorder interface: a recorder passed through it loses any QueueObserver,
+// BreakerObserver, or DeadLetterObserver capability it would otherwise be checked for.
+type shardRecorder struct {
+	stats.Recorder
+	outgoing *int64
+}
+
+func (r *shardRecorder) SendSucceeded(id, handler string) {
+	atomic.AddInt64(r.outgoing, 1)
+	r.Recorder.SendSucceeded(id, handler)
+}
+
+func (r *shardRecorder) SendFailed(id, handler string) {
+	atomic.AddInt64(r.outgoing, 1)
+	r.Recorder.SendFailed(id, handler)
+}
+
+// semaphorePollInterval bounds how long acquire can take to notice a newly freed permit once it's
+// blocked; it doesn't affect how quickly acquire notices ctx being done, which it selects on
+// directly.
+const semaphorePollInterval = 10 * time.Millisecond
+
+// boundedSemaphore is a counting semaphore whose limit can be lowered or raised after creation, so
+// ShardedSender's resizeLoop can throttle concurrent in-flight sends across all of its shards
+// without tearing any of them down.
+type boundedSemaphore struct {
+	mu    sync.Mutex
+	limit int
+	held  int
+}
+
+func newBoundedSemaphore(limit int) *boundedSemaphore {
+	return &boundedSemaphore{limit: limit}
+}
+
+// acquire blocks until a permit is available or ctx is done, whichever comes first. It polls
+// rather than waiting on a condition variable so that a blocked acquire can still observe ctx
+// being canceled - e.g. by RetryingSender.Release, per the contract documented at
+// RetryingSender.send - instead of only waking up on the next release/setLimit call, which may
+// never come if the endpoint a shard is stuck sending to never returns.
+func (s *boundedSemaphore) acquire(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.held < s.limit {
+			s.held++
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(semaphorePollInterval):
+		}
+	}
+}
+
+func (s *boundedSemaphore) release() {
+	s.mu.Lock()
+	s.held--
+	s.mu.Unlock()
+}
+
+func (s *boundedSemaphore) setLimit(n int) {
+	s.mu.Lock()
+	s.limit = n
+	s.mu.Unlock()
+}
@@ -0,0 +1,180 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package senders
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/testlib"
+)
+
+// latencyEndpoint wraps a testlib.MockEndpoint so SendContext blocks until the test explicitly
+// releases it, simulating a slow remote endpoint without a real sleep. release is buffered deep
+// enough that the test can pre-authorize every send it expects before triggering them.
+type latencyEndpoint struct {
+	*testlib.MockEndpoint
+	release chan struct{}
+}
+
+func newLatencyEndpoint(name string) *latencyEndpoint {
+	return &latencyEndpoint{MockEndpoint: testlib.NewMockEndpoint(name), release: make(chan struct{}, 1000)}
+}
+
+func (ep *latencyEndpoint) SendContext(ctx context.Context, report pipeline.EndpointReport) error {
+	select {
+	case <-ep.release:
+		return ep.MockEndpoint.SendContext(ctx, report)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func testReport(id string) metrics.StampedMetricReport {
+	return metrics.StampedMetricReport{
+		Id: id,
+		MetricReport: metrics.MetricReport{
+			Name:      "int-metric",
+			Value:     metrics.MetricValue{Int64Value: testlib.Int64Ptr(1)},
+			StartTime: time.Unix(0, 0),
+			EndTime:   time.Unix(1, 0),
+		},
+	}
+}
+
+// TestShardedSender_ScalesUpUnderSustainedLoad sends enough concurrent reports to keep every shard
+// busy across several resize ticks, and expects ActiveShards to climb from MinShards to MaxShards.
+func TestShardedSender_ScalesUpUnderSustainedLoad(t *testing.T) {
+	persist := persistence.NewMemoryPersistence()
+	mc := testlib.NewMockClock()
+	ep := newLatencyEndpoint("mockep")
+	policy := &config.RetryPolicy{MinShards: 1, MaxShards: 4}
+	ss := newShardedSender(ep, persist, testlib.NewMockStatsRecorder(), policy, nil, mc, newTestRand(), time.Second)
+
+	// Keep many distinct reports in flight at once (each hashes to some shard) without ever
+	// releasing their sends, so every active shard stays saturated while resizeLoop samples
+	// incoming > outgoing on every tick.
+	for i := 0; i < 40; i++ {
+		go ss.Send(testReport(fmt.Sprintf("report-%d", i)))
+	}
+
+	now := mc.Now()
+	for i := 0; i < 4 && ss.ActiveShards() < policy.MaxShards; i++ {
+		now = now.Add(time.Second)
+		mc.SetNow(now)
+	}
+
+	if got := ss.ActiveShards(); got != policy.MaxShards {
+		t.Fatalf("ActiveShards() = %v, want %v (MaxShards)", got, policy.MaxShards)
+	}
+}
+
+// TestShardedSender_ScalesDownWhenIdle starts a ShardedSender already scaled up to MaxShards and
+// expects ActiveShards to shrink by one shard once a resize tick observes no incoming or outgoing
+// traffic at all.
+func TestShardedSender_ScalesDownWhenIdle(t *testing.T) {
+	persist := persistence.NewMemoryPersistence()
+	mc := testlib.NewMockClock()
+	ep := newLatencyEndpoint("mockep")
+	policy := &config.RetryPolicy{MinShards: 1, MaxShards: 4}
+	ss := newShardedSender(ep, persist, testlib.NewMockStatsRecorder(), policy, nil, mc, newTestRand(), time.Second)
+	ss.setActive(policy.MaxShards)
+
+	now := mc.Now()
+	now = now.Add(time.Second)
+	mc.SetNow(now)
+
+	if got := ss.ActiveShards(); got != policy.MaxShards-1 {
+		t.Fatalf("ActiveShards() after one idle tick = %v, want %v", got, policy.MaxShards-1)
+	}
+}
+
+// TestShardedSender_ReleaseUnderContention asserts that Release doesn't hang when one shard is
+// mid-send (holding the ShardedSender's one permit, per MinShards==1) and another shard is
+// blocked in boundedSemaphore.acquire contending for that same permit. Before acquire respected
+// ctx, the contending shard's Release had no way to interrupt it, so a single busy shard could
+// wedge ShardedSender.Release() forever.
+func TestShardedSender_ReleaseUnderContention(t *testing.T) {
+	persist := persistence.NewMemoryPersistence()
+	mc := testlib.NewMockClock()
+	ep := newLatencyEndpoint("mockep")
+	policy := &config.RetryPolicy{MinShards: 1, MaxShards: 2}
+	ss := newShardedSender(ep, persist, testlib.NewMockStatsRecorder(), policy, nil, mc, newTestRand(), time.Second)
+
+	// Find report Ids that land on shard 0 and shard 1 respectively, so the contention is over
+	// ShardedSender's shared semaphore (limited to MinShards==1 permit), not either shard's own
+	// internal queue.
+	var idA, idB string
+	for i := 0; idA == "" || idB == ""; i++ {
+		id := fmt.Sprintf("report-%d", i)
+		switch shardOf(id, 2) {
+		case 0:
+			if idA == "" {
+				idA = id
+			}
+		case 1:
+			if idB == "" {
+				idB = id
+			}
+		}
+	}
+
+	if err := ss.Send(testReport(idA)); err != nil {
+		t.Fatalf("Send(idA): %+v", err)
+	}
+	// Give shard 0's send a moment to reach the endpoint and claim the one permit; it's never
+	// released, simulating a shard stuck mid-send.
+	time.Sleep(50 * time.Millisecond)
+
+	if err := ss.Send(testReport(idB)); err != nil {
+		t.Fatalf("Send(idB): %+v", err)
+	}
+	// Give shard 1's send a moment to reach shardEndpoint.SendContext and start blocking in
+	// boundedSemaphore.acquire, contending for the permit shard 0 holds.
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- ss.Release() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Release() = %+v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ShardedSender.Release() did not return: a shard contending for the semaphore never observed ctx cancellation")
+	}
+}
+
+// TestShardedSender_SameIdRoutesToSameShard asserts that shardOf is a pure function of the report
+// ID and shard count - the property SendPrepared relies on to recompute a crash-interrupted send's
+// shard without reference to ShardedSender's current (unpersisted) ActiveShards.
+func TestShardedSender_SameIdRoutesToSameShard(t *testing.T) {
+	const n = 8
+	for _, id := range []string{"a", "some-report-id", "another-one"} {
+		first := shardOf(id, n)
+		for i := 0; i < 10; i++ {
+			if got := shardOf(id, n); got != first {
+				t.Fatalf("shardOf(%q, %v) = %v on attempt %v, want %v (stable)", id, n, got, i, first)
+			}
+		}
+	}
+}
@@ -0,0 +1,471 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/util"
+	"github.com/golang/glog"
+)
+
+// batchContentType is the media type a CloudEvents 1.0 sender uses to POST a JSON array of
+// structured-mode events in a single request.
+const batchContentType = "application/cloudevents-batch+json"
+
+// seenIdsName is the persistence.Value name a cloudEventsSource stores its recently-seen event IDs
+// under, to survive a restart without re-processing an event a producer retries.
+const seenIdsName = "cloudevents-seen-ids"
+
+// maxSeenIds bounds how many recent event IDs a cloudEventsSource remembers for dedup purposes.
+const maxSeenIds = 1000
+
+// cloudEventAttrs are the CloudEvents 1.0 context attributes this source treats as fixed fields
+// rather than extension attributes.
+var cloudEventAttrs = map[string]bool{
+	"id": true, "source": true, "specversion": true, "type": true,
+	"time": true, "subject": true, "data": true, "datacontenttype": true,
+}
+
+// cloudEvent is the subset of the CNCF CloudEvents 1.0 envelope this source understands, plus any
+// extension context attributes it carried.
+type cloudEvent struct {
+	Id          string
+	Source      string
+	SpecVersion string
+	Type        string
+	Time        string
+	Subject     string
+	Data        json.RawMessage
+
+	// Extensions holds any context attribute not listed above, keyed by attribute name.
+	Extensions map[string]string
+}
+
+// cloudEventFromMap builds a cloudEvent from a structured-mode event's decoded JSON object,
+// separating the fixed CloudEvents attributes from any extension attributes.
+func cloudEventFromMap(m map[string]json.RawMessage) (cloudEvent, error) {
+	var ev cloudEvent
+	for k, raw := range m {
+		switch k {
+		case "id":
+			if err := json.Unmarshal(raw, &ev.Id); err != nil {
+				return cloudEvent{}, fmt.Errorf("cloudEvents: invalid id: %v", err)
+			}
+		case "source":
+			json.Unmarshal(raw, &ev.Source)
+		case "specversion":
+			json.Unmarshal(raw, &ev.SpecVersion)
+		case "type":
+			if err := json.Unmarshal(raw, &ev.Type); err != nil {
+				return cloudEvent{}, fmt.Errorf("cloudEvents: invalid type: %v", err)
+			}
+		case "time":
+			json.Unmarshal(raw, &ev.Time)
+		case "subject":
+			json.Unmarshal(raw, &ev.Subject)
+		case "data", "datacontenttype":
+			if k == "data" {
+				ev.Data = raw
+			}
+		default:
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				// Non-string extension attributes (CloudEvents allows booleans, integers, etc.) are
+				// carried through using their raw JSON text.
+				s = string(raw)
+			}
+			if ev.Extensions == nil {
+				ev.Extensions = make(map[string]string)
+			}
+			ev.Extensions[k] = s
+		}
+	}
+	return ev, nil
+}
+
+// cloudEventData is the shape this source expects in a CloudEvent's "data" payload.
+type cloudEventData struct {
+	Value     json.Number `json:"value"`
+	StartTime string      `json:"start_time"`
+}
+
+// cloudEventsSource runs an HTTP receiver that accepts CloudEvents (structured, binary, or a
+// structured batch) and maps each one to a MetricReport fed into the pipeline.
+type cloudEventsSource struct {
+	ce    config.CloudEventsSource
+	input pipeline.Input
+
+	seen   persistence.Value
+	seenMu sync.Mutex
+
+	server *http.Server
+	sdOnce sync.Once
+}
+
+func (s *cloudEventsSource) Shutdown() (err error) {
+	return s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is equivalent to Shutdown, but aborts the HTTP server's graceful drain once ctx
+// is done.
+func (s *cloudEventsSource) ShutdownContext(ctx context.Context) (err error) {
+	s.sdOnce.Do(func() {
+		err = s.server.Shutdown(ctx)
+		if relErr := s.input.Release(); err == nil {
+			err = relErr
+		}
+	})
+	return
+}
+
+func (s *cloudEventsSource) healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func (s *cloudEventsSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "cloudEvents: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cloudEvents: error reading body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !s.authorized(r, body) {
+		http.Error(w, "cloudEvents: unauthorized", http.StatusUnauthorized)
+		return
+	}
+	events, err := s.parse(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, ev := range events {
+		if s.alreadySeen(ev.Id) {
+			continue
+		}
+		report, err := s.toReport(ev)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.input.AddReport(report); err != nil {
+			glog.Errorf("cloudEvents: error sending report for %v: %+v", report.Name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// authorized reports whether r's Authorization header carries body's HMAC-SHA256, hex-encoded and
+// keyed by s.ce.AuthSecret - the same scheme used by common webhook signature headers. It always
+// returns true when s.ce.AuthSecret is empty.
+func (s *cloudEventsSource) authorized(r *http.Request, body []byte) bool {
+	if s.ce.AuthSecret == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.ce.AuthSecret))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// alreadySeen reports whether id has already been processed, recording it as seen if not. An empty
+// id is never deduplicated, since producers aren't required to set one.
+func (s *cloudEventsSource) alreadySeen(id string) bool {
+	if id == "" || s.seen == nil {
+		return false
+	}
+	s.seenMu.Lock()
+	defer s.seenMu.Unlock()
+
+	var ids []string
+	if err := s.seen.Load(&ids); err != nil && err != persistence.ErrNotFound {
+		glog.Errorf("cloudEvents: error loading seen ids: %+v", err)
+	}
+	for _, seenId := range ids {
+		if seenId == id {
+			return true
+		}
+	}
+	ids = append(ids, id)
+	if len(ids) > maxSeenIds {
+		ids = ids[len(ids)-maxSeenIds:]
+	}
+	if err := s.seen.Store(ids); err != nil {
+		glog.Errorf("cloudEvents: error storing seen ids: %+v", err)
+	}
+	return false
+}
+
+// parse extracts one or more cloudEvents from the request body, according to whichever of the
+// three CloudEvents 1.0 HTTP encodings (structured, binary, or structured batch) the request uses.
+func (s *cloudEventsSource) parse(r *http.Request, body []byte) ([]cloudEvent, error) {
+	switch {
+	case strings.HasPrefix(r.Header.Get("Content-Type"), batchContentType):
+		var maps []map[string]json.RawMessage
+		if err := json.Unmarshal(body, &maps); err != nil {
+			return nil, fmt.Errorf("cloudEvents: invalid batch payload: %v", err)
+		}
+		events := make([]cloudEvent, 0, len(maps))
+		for _, m := range maps {
+			ev, err := cloudEventFromMap(m)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, ev)
+		}
+		return events, nil
+	case r.Header.Get("ce-type") != "":
+		ev := cloudEvent{
+			Id:          r.Header.Get("ce-id"),
+			Source:      r.Header.Get("ce-source"),
+			SpecVersion: r.Header.Get("ce-specversion"),
+			Type:        r.Header.Get("ce-type"),
+			Time:        r.Header.Get("ce-time"),
+			Subject:     r.Header.Get("ce-subject"),
+		}
+		for name, values := range r.Header {
+			lower := strings.ToLower(name)
+			if !strings.HasPrefix(lower, "ce-") {
+				continue
+			}
+			attr := strings.TrimPrefix(lower, "ce-")
+			if cloudEventAttrs[attr] || len(values) == 0 {
+				continue
+			}
+			if ev.Extensions == nil {
+				ev.Extensions = make(map[string]string)
+			}
+			ev.Extensions[attr] = values[0]
+		}
+		if len(body) > 0 {
+			ev.Data = json.RawMessage(body)
+		}
+		return []cloudEvent{ev}, nil
+	default:
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("cloudEvents: invalid event payload: %v", err)
+		}
+		ev, err := cloudEventFromMap(m)
+		if err != nil {
+			return nil, err
+		}
+		return []cloudEvent{ev}, nil
+	}
+}
+
+// toReport converts a parsed cloudEvent to a MetricReport, applying this source's TypeToMetric,
+// TypePrefix, and AllowedTypes restrictions to the event's type.
+func (s *cloudEventsSource) toReport(ev cloudEvent) (metrics.MetricReport, error) {
+	name, ok := s.ce.TypeToMetric[ev.Type]
+	if !ok {
+		name = ev.Type
+		if s.ce.TypePrefix != "" {
+			if !strings.HasPrefix(name, s.ce.TypePrefix) {
+				return metrics.MetricReport{}, fmt.Errorf("cloudEvents: event type %q missing prefix %q", ev.Type, s.ce.TypePrefix)
+			}
+			name = strings.TrimPrefix(name, s.ce.TypePrefix)
+		}
+		if len(s.ce.AllowedTypes) > 0 && !containsString(s.ce.AllowedTypes, name) {
+			return metrics.MetricReport{}, fmt.Errorf("cloudEvents: event type not allowed: %v", name)
+		}
+	}
+
+	var data cloudEventData
+	if len(ev.Data) > 0 {
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			return metrics.MetricReport{}, fmt.Errorf("cloudEvents: invalid data payload: %v", err)
+		}
+	}
+
+	endTime := time.Now().UTC()
+	if ev.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, ev.Time)
+		if err != nil {
+			return metrics.MetricReport{}, fmt.Errorf("cloudEvents: invalid time: %v", err)
+		}
+		endTime = t
+	}
+
+	startTime := endTime
+	switch {
+	case s.ce.StartTimeExtension != "":
+		if raw, ok := ev.Extensions[s.ce.StartTimeExtension]; ok {
+			t, err := time.Parse(time.RFC3339Nano, strings.Trim(raw, `"`))
+			if err != nil {
+				return metrics.MetricReport{}, fmt.Errorf("cloudEvents: invalid %v extension: %v", s.ce.StartTimeExtension, err)
+			}
+			startTime = t
+		}
+	case data.StartTime != "":
+		t, err := time.Parse(time.RFC3339Nano, data.StartTime)
+		if err != nil {
+			return metrics.MetricReport{}, fmt.Errorf("cloudEvents: invalid data.start_time: %v", err)
+		}
+		startTime = t
+	}
+
+	value, err := cloudEventValue(data.Value)
+	if err != nil {
+		return metrics.MetricReport{}, err
+	}
+
+	var labels map[string]string
+	if ev.Subject != "" {
+		labels = map[string]string{"subject": ev.Subject}
+	}
+
+	return metrics.MetricReport{
+		Name:      name,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Labels:    labels,
+		Value:     value,
+	}, nil
+}
+
+// cloudEventValue converts a CloudEvent data payload's "value" number to a MetricValue, reporting
+// it as a double if its literal representation carries a fractional or exponent part, and as an
+// int64 otherwise.
+func cloudEventValue(n json.Number) (metrics.MetricValue, error) {
+	if n == "" {
+		return metrics.MetricValue{}, errors.New("cloudEvents: missing data.value")
+	}
+	if strings.ContainsAny(string(n), ".eE") {
+		f, err := n.Float64()
+		if err != nil {
+			return metrics.MetricValue{}, fmt.Errorf("cloudEvents: invalid data.value: %v", err)
+		}
+		return metrics.MetricValue{DoubleValue: util.NewFloat64(f)}, nil
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return metrics.MetricValue{}, fmt.Errorf("cloudEvents: invalid data.value: %v", err)
+	}
+	return metrics.MetricValue{Int64Value: util.NewInt64(i)}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newCloudEventsSource(ce config.CloudEventsSource, input pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+	input.Use()
+	path := ce.Path
+	if path == "" {
+		path = "/"
+	}
+	s := &cloudEventsSource{ce: ce, input: input}
+	if p != nil {
+		s.seen = p.Value(seenIdsName)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, s)
+	mux.HandleFunc("/healthz", s.healthz)
+	s.server = &http.Server{Addr: fmt.Sprintf(":%d", ce.Port), Handler: mux}
+
+	useTLS := ce.TLSConfig != nil
+	if useTLS {
+		tlsConfig, err := buildTLSConfig(*ce.TLSConfig)
+		if err != nil {
+			input.Release()
+			return nil, fmt.Errorf("cloudEvents: %v", err)
+		}
+		s.server.TLSConfig = tlsConfig
+	}
+
+	go func() {
+		var err error
+		if useTLS {
+			// The certificate is already loaded into s.server.TLSConfig.Certificates, so no cert/key
+			// file paths are needed here.
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			glog.Errorf("cloudEvents: server error: %+v", err)
+		}
+	}()
+	return s, nil
+}
+
+// buildTLSConfig constructs a *tls.Config implementing tc, loading its certificate, key, and
+// (if set) client CA bundle from disk. tc must have already passed config.TLSConfig.Validate.
+func buildTLSConfig(tc config.TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tlsConfig: error loading certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tc.MinVersionOrDefault(),
+		CipherSuites: tc.CipherSuiteIDs(),
+	}
+	if tc.ClientCAFile != "" {
+		caBytes, err := ioutil.ReadFile(tc.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tlsConfig: error reading clientCaFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("tlsConfig: no certificates found in clientCaFile: %v", tc.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// NewCloudEventsSource creates a pipeline.Source that runs an HTTP receiver on ce.Port and feeds
+// reports decoded from incoming CloudEvents into input. p, if non-nil, is used to persist
+// recently-seen event IDs so a producer's retried delivery isn't processed twice across restarts.
+// If ce.TLSConfig is set, the receiver serves TLS - optionally mutual TLS - instead of plaintext.
+func NewCloudEventsSource(ce config.CloudEventsSource, input pipeline.Input, p persistence.Persistence) (pipeline.Source, error) {
+	return newCloudEventsSource(ce, input, p)
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+)
+
+// tlsTestInput is a minimal pipeline.Input used to exercise cloudEventsSource without a full
+// downstream pipeline.
+type tlsTestInput struct{}
+
+func (*tlsTestInput) AddReport(metrics.MetricReport) error { return nil }
+func (*tlsTestInput) Use()                                 {}
+func (*tlsTestInput) Release() error                       { return nil }
+
+// writeSelfSignedCert generates a self-signed ECDSA certificate for "localhost" and writes its PEM
+// cert and key to certFile/keyFile.
+func writeSelfSignedCert(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshaling key: %v", err)
+	}
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyBytes)
+}
+
+func writePEM(t *testing.T, path, blockType string, bytes []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("error creating %v: %v", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: bytes}); err != nil {
+		t.Fatalf("error writing %v: %v", path, err)
+	}
+}
+
+// freePort asks the OS for an unused TCP port by briefly listening and closing.
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("error finding a free port: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestCloudEventsSource_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile)
+
+	port := freePort(t)
+	ce := config.CloudEventsSource{
+		Port: port,
+		TLSConfig: &config.TLSConfig{
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			MinVersion: "VersionTLS12",
+		},
+	}
+	s, err := newCloudEventsSource(ce, &tlsTestInput{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating source: %v", err)
+	}
+	defer s.ShutdownContext(context.Background())
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	waitForListener(t, addr)
+
+	t.Run("accepts a handshake at or above MinVersion", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			t.Fatalf("expected a successful handshake, got: %v", err)
+		}
+		conn.Close()
+	})
+
+	t.Run("rejects a handshake below MinVersion", func(t *testing.T) {
+		_, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11})
+		if err == nil {
+			t.Fatal("expected the handshake to fail below the server's MinVersion")
+		}
+	})
+}
+
+// waitForListener polls addr until a TCP connection succeeds or the timeout elapses, since the
+// server in newCloudEventsSource starts listening from a background goroutine.
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for listener on %v", addr)
+}
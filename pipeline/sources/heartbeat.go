@@ -15,6 +15,7 @@
 package sources
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -35,10 +36,25 @@ type heartbeat struct {
 }
 
 func (h *heartbeat) Shutdown() (err error) {
+	return h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is equivalent to Shutdown, but aborts waiting for the heartbeat's goroutine to
+// drain once ctx is done, returning ctx.Err() in that case without releasing the downstream input.
+func (h *heartbeat) ShutdownContext(ctx context.Context) (err error) {
 	h.sdOnce.Do(func() {
 		h.close <- true
-		h.wait.Wait()
-		err = h.input.Release()
+		drained := make(chan struct{})
+		go func() {
+			h.wait.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+			err = h.input.Release()
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
 	})
 	return
 }
@@ -47,13 +63,13 @@ func (h *heartbeat) run(start time.Time) {
 	interval := time.Duration(h.hb.IntervalSeconds) * time.Second
 	end := start.Add(interval)
 
+	ticker := h.clock.NewTicker(interval)
+	defer ticker.Stop()
+
 	running := true
 	for running {
-		now := h.clock.Now()
-		nextFire := now.Add(end.Sub(now))
-		timer := h.clock.NewTimerAt(nextFire)
 		select {
-		case <-timer.GetC():
+		case <-ticker.Chan():
 			report := metrics.MetricReport{
 				Name:      h.hb.Metric,
 				StartTime: start,
@@ -70,7 +86,6 @@ func (h *heartbeat) run(start time.Time) {
 		case <-h.close:
 			running = false
 		}
-		timer.Stop()
 	}
 	h.wait.Done()
 }
@@ -0,0 +1,386 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/util"
+	"github.com/golang/glog"
+)
+
+// defaultPrometheusScrapeTimeout bounds a single scrape request when
+// config.PrometheusScrape.TimeoutSeconds is left at zero.
+const defaultPrometheusScrapeTimeout = 10 * time.Second
+
+// promSample is a single parsed series from a scrape: a metric name, its labels, and its value.
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// lastValue is the most recently scraped value of a "counter" or "rate"-kind series, along with
+// the time it was observed, so a later scrape can compute either a delta or a per-second rate.
+type lastValue struct {
+	value float64
+	time  time.Time
+}
+
+// prometheusScrape periodically scrapes a Prometheus text-exposition-format (or OpenMetrics)
+// endpoint and feeds the configured series into the pipeline as MetricReports.
+type prometheusScrape struct {
+	ps     config.PrometheusScrape
+	input  pipeline.Input
+	clock  clock.Clock
+	client *http.Client
+
+	// last holds the most recently scraped value for each "counter"- or "rate"-kind series (keyed
+	// by seriesKey), so that successive scrapes can be reported as a delta (or rate) rather than a
+	// running total.
+	last map[string]lastValue
+
+	close  chan bool
+	wait   sync.WaitGroup
+	sdOnce sync.Once
+}
+
+func (p *prometheusScrape) Shutdown() (err error) {
+	return p.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is equivalent to Shutdown, but aborts waiting for the scraper's goroutine to
+// drain once ctx is done, returning ctx.Err() in that case without releasing the downstream input.
+func (p *prometheusScrape) ShutdownContext(ctx context.Context) (err error) {
+	p.sdOnce.Do(func() {
+		p.close <- true
+		drained := make(chan struct{})
+		go func() {
+			p.wait.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+			err = p.input.Release()
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	return
+}
+
+func (p *prometheusScrape) run(start time.Time) {
+	interval := time.Duration(p.ps.IntervalSeconds) * time.Second
+	end := start.Add(interval)
+
+	running := true
+	for running {
+		now := p.clock.Now()
+		nextFire := now.Add(end.Sub(now))
+		timer := p.clock.NewTimerAt(nextFire)
+		select {
+		case <-timer.GetC():
+			p.scrape(start, end)
+			start = end
+			end = end.Add(interval)
+		case <-p.close:
+			running = false
+		}
+		timer.Stop()
+	}
+	p.wait.Done()
+}
+
+func (p *prometheusScrape) scrape(start, end time.Time) {
+	samples, err := p.fetch()
+	if err != nil {
+		glog.Errorf("prometheusScrape: error scraping %v: %+v", p.ps.URL, err)
+		return
+	}
+	for _, m := range p.ps.Metrics {
+		for _, s := range samples {
+			if s.name != m.SourceMetric || !labelsMatch(s.labels, m.LabelMatchers) {
+				continue
+			}
+			report := metrics.MetricReport{
+				Name:      m.Metric,
+				StartTime: start,
+				EndTime:   end,
+				Labels:    reportedLabels(s.labels, m.LabelsToReport),
+			}
+			key := seriesKey(m.SourceMetric, s.labels)
+			switch m.Kind {
+			case config.PrometheusKindCounter:
+				prev, seen := p.last[key]
+				p.last[key] = lastValue{value: s.value, time: end}
+				if !seen {
+					// We don't have a prior value to diff against yet; wait for the next scrape.
+					continue
+				}
+				delta := s.value - prev.value
+				if delta < 0 {
+					// The counter reset (e.g. the process restarted); treat this scrape as the new baseline.
+					continue
+				}
+				report.Value = metrics.MetricValue{Int64Value: util.NewInt64(int64(delta))}
+			case config.PrometheusKindGauge:
+				report.Value = metrics.MetricValue{DoubleValue: util.NewFloat64(s.value)}
+			case config.PrometheusKindRate:
+				prev, seen := p.last[key]
+				p.last[key] = lastValue{value: s.value, time: end}
+				if !seen {
+					continue
+				}
+				delta := s.value - prev.value
+				if delta < 0 {
+					continue
+				}
+				elapsed := end.Sub(prev.time).Seconds()
+				if elapsed <= 0 {
+					continue
+				}
+				report.Value = metrics.MetricValue{DoubleValue: util.NewFloat64(delta / elapsed)}
+			}
+			if err := p.input.AddReport(report); err != nil {
+				glog.Errorf("prometheusScrape: error sending report for %v: %+v", m.Metric, err)
+			}
+		}
+	}
+}
+
+// reportedLabels returns sample restricted to keep, or sample unchanged if keep is empty.
+func reportedLabels(sample map[string]string, keep []string) map[string]string {
+	if len(keep) == 0 {
+		return sample
+	}
+	labels := make(map[string]string, len(keep))
+	for _, k := range keep {
+		if v, ok := sample[k]; ok {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// labelsMatch returns whether sample contains every label in matchers with an equal value. An
+// empty matchers always matches.
+func labelsMatch(sample, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if sample[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesKey returns a string uniquely identifying a series by its metric name and labels, used to
+// track counter state per label combination rather than per bare metric name.
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteByte('\x00')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
+
+// fetch retrieves and parses the scrape target's response body, returning every series found.
+func (p *prometheusScrape) fetch() ([]promSample, error) {
+	req, err := http.NewRequest(http.MethodGet, p.ps.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.ps.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.ps.BearerToken)
+	} else if p.ps.BasicAuth != nil {
+		req.SetBasicAuth(p.ps.BasicAuth.Username, p.ps.BasicAuth.Password)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var samples []promSample
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample, ok := parseSample(line)
+		if !ok {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// parseSample parses a single line of Prometheus text-exposition (or OpenMetrics) format, of the
+// form `metric_name{label="value",...} 1.23 [timestamp]`.
+func parseSample(line string) (promSample, bool) {
+	name := line
+	var labels map[string]string
+	rest := line
+	if idx := strings.IndexByte(line, '{'); idx >= 0 {
+		end := strings.IndexByte(line, '}')
+		if end < idx {
+			return promSample{}, false
+		}
+		name = line[:idx]
+		labels = parseLabels(line[idx+1 : end])
+		rest = line[end+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return promSample{}, false
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 {
+		return promSample{}, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return promSample{}, false
+	}
+	return promSample{name: name, labels: labels, value: value}, true
+}
+
+// parseLabels parses the comma-separated `key="value"` pairs inside a sample's braces.
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, part := range splitUnquoted(s, ',') {
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			continue
+		}
+		key := strings.TrimSpace(part[:eq])
+		val := strings.TrimSpace(part[eq+1:])
+		val = strings.Trim(val, `"`)
+		if key != "" {
+			labels[key] = val
+		}
+	}
+	return labels
+}
+
+// splitUnquoted splits s on sep, ignoring occurrences of sep inside double-quoted spans.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case sep:
+			if !inQuotes {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if start <= len(s) {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+func newPrometheusScrape(ps config.PrometheusScrape, input pipeline.Input, clock clock.Clock) (pipeline.Source, error) {
+	input.Use()
+	timeout := time.Duration(ps.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultPrometheusScrapeTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	if ps.InsecureSkipVerify || ps.ClientTLS != nil {
+		tlsConfig, err := prometheusScrapeTLSConfig(ps)
+		if err != nil {
+			input.Release()
+			return nil, err
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	p := &prometheusScrape{
+		ps:     ps,
+		input:  input,
+		clock:  clock,
+		client: client,
+		last:   make(map[string]lastValue),
+		close:  make(chan bool, 1),
+	}
+	p.wait.Add(1)
+	go p.run(clock.Now().UTC().Round(1 * time.Second))
+	return p, nil
+}
+
+// prometheusScrapeTLSConfig builds the tls.Config used for an https scrape target, applying
+// ps.InsecureSkipVerify and, if set, ps.ClientTLS's client certificate and CA bundle.
+func prometheusScrapeTLSConfig(ps config.PrometheusScrape) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: ps.InsecureSkipVerify}
+	if ps.ClientTLS == nil {
+		return tlsConfig, nil
+	}
+	if ps.ClientTLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(ps.ClientTLS.CertFile, ps.ClientTLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("prometheusScrape: loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if ps.ClientTLS.CAFile != "" {
+		ca, err := ioutil.ReadFile(ps.ClientTLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("prometheusScrape: reading caFile: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("prometheusScrape: caFile contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// NewPrometheusScrape creates a pipeline.Source that periodically scrapes ps.URL and feeds the
+// configured series into input as MetricReports.
+func NewPrometheusScrape(ps config.PrometheusScrape, input pipeline.Input) (pipeline.Source, error) {
+	return newPrometheusScrape(ps, input, clock.NewClock())
+}
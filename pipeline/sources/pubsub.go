@@ -0,0 +1,246 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/identity"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/util"
+	"github.com/golang/glog"
+	"google.golang.org/api/option"
+)
+
+// pubsubScope is the OAuth2 scope requested for Pub/Sub subscriber calls.
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+
+// pubsubBasePath is the audience presented when the configured identity's SelfSignedJWT is set;
+// it must match the base URL the client library dials.
+const pubsubBasePath = "https://pubsub.googleapis.com/"
+
+// pubsubSource runs a Pub/Sub streaming pull against a single subscription, mapping each message
+// to a MetricReport and acking it only once the report is accepted by the pipeline.
+type pubsubSource struct {
+	ps    config.PubSubSource
+	input pipeline.Input
+
+	client *pubsub.Client
+	cancel context.CancelFunc
+	done   chan error
+	sdOnce sync.Once
+}
+
+func (s *pubsubSource) Shutdown() (err error) {
+	return s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is equivalent to Shutdown, but aborts waiting for the streaming pull to drain
+// once ctx is done, returning ctx.Err() in that case without releasing the downstream input.
+func (s *pubsubSource) ShutdownContext(ctx context.Context) (err error) {
+	s.sdOnce.Do(func() {
+		s.cancel()
+		select {
+		case rerr := <-s.done:
+			s.client.Close()
+			if rerr != nil {
+				err = rerr
+			}
+			if relErr := s.input.Release(); err == nil {
+				err = relErr
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	return
+}
+
+// handle is a pubsub.MessageHandler: it acks msg once toReport and AddReport both succeed, and
+// nacks it otherwise so Pub/Sub redelivers it rather than losing the usage event.
+func (s *pubsubSource) handle(ctx context.Context, msg *pubsub.Message) {
+	report, err := s.toReport(msg)
+	if err != nil {
+		glog.Errorf("pubsub: %v: invalid message: %+v", s.ps.Subscription, err)
+		msg.Nack()
+		return
+	}
+	if err := s.input.AddReport(report); err != nil {
+		glog.Errorf("pubsub: %v: error sending report for %v: %+v", s.ps.Subscription, report.Name, err)
+		msg.Nack()
+		return
+	}
+	msg.Ack()
+}
+
+// toReport converts msg to a MetricReport according to s.ps.Mapping.
+func (s *pubsubSource) toReport(msg *pubsub.Message) (metrics.MetricReport, error) {
+	m := s.ps.Mapping
+
+	name := m.Metric
+	if m.MetricAttribute != "" {
+		name = msg.Attributes[m.MetricAttribute]
+		if name == "" {
+			return metrics.MetricReport{}, fmt.Errorf("missing attribute %v", m.MetricAttribute)
+		}
+	}
+
+	var body map[string]json.RawMessage
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &body); err != nil {
+			return metrics.MetricReport{}, fmt.Errorf("invalid message body: %v", err)
+		}
+	}
+
+	value, err := pubsubValue(body[m.ValueField])
+	if err != nil {
+		return metrics.MetricReport{}, fmt.Errorf("%v: %v", m.ValueField, err)
+	}
+
+	endTime := msg.PublishTime.UTC()
+	if t, ok, err := pubsubTime(body, m.EndTimeField); err != nil {
+		return metrics.MetricReport{}, err
+	} else if ok {
+		endTime = t
+	}
+
+	startTime := endTime
+	if t, ok, err := pubsubTime(body, m.StartTimeField); err != nil {
+		return metrics.MetricReport{}, err
+	} else if ok {
+		startTime = t
+	}
+
+	var labels map[string]string
+	if len(m.LabelFields) > 0 || len(m.LabelAttributes) > 0 {
+		labels = make(map[string]string)
+		for _, f := range m.LabelFields {
+			raw, ok := body[f]
+			if !ok {
+				continue
+			}
+			var v string
+			if json.Unmarshal(raw, &v) != nil {
+				v = string(raw)
+			}
+			labels[f] = v
+		}
+		for _, a := range m.LabelAttributes {
+			if v, ok := msg.Attributes[a]; ok {
+				labels[a] = v
+			}
+		}
+	}
+
+	return metrics.MetricReport{
+		Name:      name,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Labels:    labels,
+		Value:     value,
+	}, nil
+}
+
+// pubsubTime reads field from body as an RFC 3339 timestamp. It returns ok=false, rather than an
+// error, when field is empty or absent from body, letting the caller fall back to a default.
+func pubsubTime(body map[string]json.RawMessage, field string) (time.Time, bool, error) {
+	if field == "" {
+		return time.Time{}, false, nil
+	}
+	raw, ok := body[field]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return time.Time{}, false, fmt.Errorf("%v: %v", field, err)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%v: %v", field, err)
+	}
+	return t, true, nil
+}
+
+// pubsubValue converts a message body's value field to a MetricValue, reporting it as a double if
+// its literal representation carries a fractional or exponent part, and as an int64 otherwise.
+func pubsubValue(raw json.RawMessage) (metrics.MetricValue, error) {
+	if len(raw) == 0 {
+		return metrics.MetricValue{}, errors.New("missing value")
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return metrics.MetricValue{}, err
+	}
+	if strings.ContainsAny(string(n), ".eE") {
+		f, err := n.Float64()
+		if err != nil {
+			return metrics.MetricValue{}, err
+		}
+		return metrics.MetricValue{DoubleValue: util.NewFloat64(f)}, nil
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return metrics.MetricValue{}, err
+	}
+	return metrics.MetricValue{Int64Value: util.NewInt64(i)}, nil
+}
+
+func newPubSubSource(ps config.PubSubSource, input pipeline.Input, gcp *config.GCPIdentity) (pipeline.Source, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts, err := identity.NewTokenSource(ctx, gcp, pubsubBasePath, pubsubScope)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	client, err := pubsub.NewClient(ctx, ps.ProjectId, option.WithTokenSource(ts))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	sub := client.Subscription(ps.Subscription)
+	if ps.MaxOutstandingMessages > 0 {
+		sub.ReceiveSettings.MaxOutstandingMessages = ps.MaxOutstandingMessages
+	}
+
+	input.Use()
+	s := &pubsubSource{ps: ps, input: input, client: client, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		err := sub.Receive(ctx, s.handle)
+		if err != nil && ctx.Err() == nil {
+			glog.Errorf("pubsub: %v: receive: %+v", ps.Subscription, err)
+		}
+		s.done <- err
+	}()
+	return s, nil
+}
+
+// NewPubSubSource creates a pipeline.Source that runs a streaming pull against ps.Subscription
+// (under ps.ProjectId, authenticated as gcp) and feeds each message into input as a MetricReport
+// built according to ps.Mapping. A message is only acked once the resulting AddReport call
+// succeeds; any other outcome nacks it so Pub/Sub redelivers it.
+func NewPubSubSource(ps config.PubSubSource, input pipeline.Input, gcp *config.GCPIdentity) (pipeline.Source, error) {
+	return newPubSubSource(ps, input, gcp)
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/cron"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/golang/glog"
+)
+
+// scheduled emits a report each time a cron.Schedule fires, rather than at a fixed interval.
+type scheduled struct {
+	sc       config.Scheduled
+	schedule *cron.Schedule
+	input    pipeline.Input
+	clock    clock.Clock
+	close    chan bool
+	wait     sync.WaitGroup
+	sdOnce   sync.Once
+}
+
+func (s *scheduled) Shutdown() (err error) {
+	return s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is equivalent to Shutdown, but aborts waiting for the scheduled source's
+// goroutine to drain once ctx is done, returning ctx.Err() in that case without releasing the
+// downstream input.
+func (s *scheduled) ShutdownContext(ctx context.Context) (err error) {
+	s.sdOnce.Do(func() {
+		s.close <- true
+		drained := make(chan struct{})
+		go func() {
+			s.wait.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+			err = s.input.Release()
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	return
+}
+
+func (s *scheduled) run(start time.Time) {
+	end, err := s.schedule.Next(start)
+	if err != nil {
+		glog.Errorf("scheduled: computing first fire time: %+v", err)
+		s.wait.Done()
+		return
+	}
+
+	running := true
+	for running {
+		timer := s.clock.NewTimerAt(end)
+		select {
+		case <-timer.GetC():
+			report := metrics.MetricReport{
+				Name:      s.sc.Metric,
+				StartTime: start,
+				EndTime:   end,
+				Value:     s.sc.Value,
+				Labels:    s.sc.Labels,
+			}
+			if err := s.input.AddReport(report); err != nil {
+				glog.Errorf("scheduled: error sending report: %+v", err)
+			}
+			start = end
+			end, err = s.schedule.Next(start)
+			if err != nil {
+				glog.Errorf("scheduled: computing next fire time: %+v", err)
+				running = false
+			}
+		case <-s.close:
+			running = false
+		}
+		timer.Stop()
+	}
+	s.wait.Done()
+}
+
+func newScheduled(sc config.Scheduled, input pipeline.Input, clk clock.Clock) (pipeline.Source, error) {
+	schedule, err := cron.Parse(sc.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	input.Use()
+	s := &scheduled{sc: sc, schedule: schedule, input: input, clock: clk, close: make(chan bool, 1)}
+	s.wait.Add(1)
+	go s.run(clk.Now().UTC())
+	return s, nil
+}
+
+// NewScheduled creates a pipeline.Source that emits a report for sc.Metric each time sc.Schedule
+// fires, with each report's interval running from the previous fire time to the current one
+// (closing the same no-coverage-gap invariant Heartbeat maintains for fixed intervals).
+func NewScheduled(sc config.Scheduled, input pipeline.Input) (pipeline.Source, error) {
+	return newScheduled(sc, input, clock.NewClock())
+}
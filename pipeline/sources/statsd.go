@@ -0,0 +1,295 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
+	"github.com/GoogleCloudPlatform/ubbagent/util"
+	"github.com/golang/glog"
+)
+
+// defaultStatsdBufferSize is the UDP read buffer size used when StatsdSource.BufferSizeBytes is 0.
+const defaultStatsdBufferSize = 65536
+
+// statsdSample is a single parsed StatsD line.
+type statsdSample struct {
+	name  string
+	typ   string
+	value float64
+	tags  map[string]string
+}
+
+// statsdSource listens for StatsD protocol samples over UDP - and, if configured, TCP - and feeds
+// the configured subset into the pipeline as MetricReports.
+type statsdSource struct {
+	sd      config.StatsdSource
+	input   pipeline.Input
+	metrics map[string]string // sourceMetric -> ubbagent metric name
+
+	udpConn *net.UDPConn
+	tcpLis  net.Listener
+
+	wait   sync.WaitGroup
+	sdOnce sync.Once
+}
+
+func (s *statsdSource) Shutdown() (err error) {
+	return s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext is equivalent to Shutdown, but aborts waiting for the listener goroutines to
+// drain once ctx is done, returning ctx.Err() in that case without releasing the downstream input.
+func (s *statsdSource) ShutdownContext(ctx context.Context) (err error) {
+	s.sdOnce.Do(func() {
+		if s.udpConn != nil {
+			err = s.udpConn.Close()
+		}
+		if s.tcpLis != nil {
+			if cerr := s.tcpLis.Close(); err == nil {
+				err = cerr
+			}
+		}
+		drained := make(chan struct{})
+		go func() {
+			s.wait.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+			if relErr := s.input.Release(); err == nil {
+				err = relErr
+			}
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+		}
+	})
+	return
+}
+
+func (s *statsdSource) bufferSize() int {
+	if s.sd.BufferSizeBytes > 0 {
+		return s.sd.BufferSizeBytes
+	}
+	return defaultStatsdBufferSize
+}
+
+func (s *statsdSource) serveUDP() {
+	defer s.wait.Done()
+	buf := make([]byte, s.bufferSize())
+	for {
+		n, _, err := s.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			return // The connection was closed by Shutdown.
+		}
+		s.handlePacket(buf[:n])
+	}
+}
+
+func (s *statsdSource) serveTCP() {
+	defer s.wait.Done()
+	for {
+		conn, err := s.tcpLis.Accept()
+		if err != nil {
+			return // The listener was closed by Shutdown.
+		}
+		s.wait.Add(1)
+		go s.serveTCPConn(conn)
+	}
+}
+
+func (s *statsdSource) serveTCPConn(conn net.Conn) {
+	defer s.wait.Done()
+	defer conn.Close()
+	buf := make([]byte, s.bufferSize())
+	var pending strings.Builder
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			pending.Write(buf[:n])
+			lines := strings.Split(pending.String(), "\n")
+			for _, line := range lines[:len(lines)-1] {
+				s.handleLine(line)
+			}
+			pending.Reset()
+			pending.WriteString(lines[len(lines)-1])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handlePacket processes every newline-delimited StatsD line in a single UDP packet.
+func (s *statsdSource) handlePacket(packet []byte) {
+	for _, line := range strings.Split(string(packet), "\n") {
+		s.handleLine(line)
+	}
+}
+
+func (s *statsdSource) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	sample, err := parseStatsdLine(line)
+	if err != nil {
+		glog.Warningf("statsd: %v", err)
+		return
+	}
+	name, ok := s.metrics[sample.name]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	report := metrics.MetricReport{
+		Name:      name,
+		StartTime: now,
+		EndTime:   now,
+		Labels:    sample.tags,
+		Value:     statsdValue(sample),
+	}
+	if err := s.input.AddReport(report); err != nil {
+		glog.Errorf("statsd: error sending report for %v: %+v", name, err)
+	}
+}
+
+// statsdValue converts a parsed statsdSample to a MetricValue. A counter ("c") reports as an
+// int64, matching an additive metric's usual type; a gauge ("g") or timer/histogram ("ms", "h")
+// reports as a double, since it isn't necessarily a whole number. A timer/histogram sample
+// reaching a metric whose Definition.Type is DistributionType is automatically turned into a
+// one-observation Distribution once it reaches that metric's Aggregator; see
+// pipeline/inputs.normalizeDistribution.
+func statsdValue(sample statsdSample) metrics.MetricValue {
+	if sample.typ == "c" {
+		return metrics.MetricValue{Int64Value: util.NewInt64(int64(sample.value))}
+	}
+	return metrics.MetricValue{DoubleValue: util.NewFloat64(sample.value)}
+}
+
+// parseStatsdLine parses a single StatsD protocol line of the form
+// "name:value|type[|@sampleRate][|#tag1:val1,tag2:val2]", including the DogStatsD "#tags"
+// extension. Supported types are "c" (counter), "g" (gauge), "ms" (timer), and "h" (histogram).
+func parseStatsdLine(line string) (statsdSample, error) {
+	parts := strings.Split(line, "|")
+	if len(parts) < 2 {
+		return statsdSample{}, fmt.Errorf("malformed line: %v", line)
+	}
+	nameValue := strings.SplitN(parts[0], ":", 2)
+	if len(nameValue) != 2 {
+		return statsdSample{}, fmt.Errorf("malformed line: %v", line)
+	}
+	name := nameValue[0]
+	rawValue, err := strconv.ParseFloat(nameValue[1], 64)
+	if err != nil {
+		return statsdSample{}, fmt.Errorf("invalid value in %q: %v", line, err)
+	}
+
+	statType := parts[1]
+	switch statType {
+	case "c", "g", "ms", "h":
+	default:
+		return statsdSample{}, fmt.Errorf("unsupported stat type %q in %q", statType, line)
+	}
+
+	rate := 1.0
+	var tags map[string]string
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			r, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil {
+				return statsdSample{}, fmt.Errorf("invalid sample rate in %q: %v", line, err)
+			}
+			rate = r
+		case strings.HasPrefix(part, "#"):
+			tags = parseStatsdTags(part[1:])
+		}
+	}
+	if rate <= 0 || rate > 1 {
+		return statsdSample{}, fmt.Errorf("sample rate out of range in %q: %v", line, rate)
+	}
+
+	return statsdSample{name: name, typ: statType, value: rawValue / rate, tags: tags}, nil
+}
+
+// parseStatsdTags parses the comma-separated "key:value" pairs of the DogStatsD "#tags" extension.
+func parseStatsdTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+	return tags
+}
+
+func newStatsdSource(sd config.StatsdSource, input pipeline.Input) (pipeline.Source, error) {
+	input.Use()
+	metricNames := make(map[string]string, len(sd.Metrics))
+	for _, m := range sd.Metrics {
+		metricNames[m.SourceMetric] = m.Metric
+	}
+	s := &statsdSource{sd: sd, input: input, metrics: metricNames}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", sd.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: invalid listenAddress: %v", err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	s.udpConn = conn
+	s.wait.Add(1)
+	go s.serveUDP()
+
+	if sd.TCPListenAddress != "" {
+		lis, err := net.Listen("tcp", sd.TCPListenAddress)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("statsd: %v", err)
+		}
+		s.tcpLis = lis
+		s.wait.Add(1)
+		go s.serveTCP()
+	}
+
+	return s, nil
+}
+
+// NewStatsdSource creates a pipeline.Source that listens for StatsD protocol samples on
+// sd.ListenAddress - and, if set, sd.TCPListenAddress - and feeds each sample named in sd.Metrics
+// into input as a MetricReport for the mapped ubbagent metric.
+func NewStatsdSource(sd config.StatsdSource, input pipeline.Input) (pipeline.Source, error) {
+	return newStatsdSource(sd, input)
+}
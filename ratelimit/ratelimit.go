@@ -0,0 +1,87 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides a simple, clock.Clock-driven rate limiter suitable for pacing calls
+// to quota-limited remote services.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+)
+
+// Limiter paces calls to no more than some configured rate.
+type Limiter interface {
+	// Take blocks until the caller is allowed to proceed, and returns the time at which it did so.
+	Take() time.Time
+}
+
+// limiter is a leaky-bucket style Limiter: it tracks the timestamp of the last request it allowed
+// and requires perRequest to have elapsed before allowing the next one. maxSlack bounds how much
+// unused capacity from an idle period can be spent on a burst, so a limiter that's been idle for
+// an hour doesn't then allow an hour's worth of requests through instantly.
+type limiter struct {
+	clock      clock.Clock
+	mu         sync.Mutex
+	perRequest time.Duration
+	maxSlack   time.Duration
+	last       time.Time
+}
+
+// New creates a Limiter that allows at most rate requests per second, using clock to determine and
+// wait for the current time. Up to maxSlack of idle capacity may be spent on a burst of requests
+// without each being individually paced.
+func New(clock clock.Clock, rate float64, maxSlack time.Duration) Limiter {
+	return &limiter{
+		clock:      clock,
+		perRequest: time.Duration(float64(time.Second) / rate),
+		maxSlack:   maxSlack,
+	}
+}
+
+// NewLimiter creates a Limiter backed by the real clock. See New.
+func NewLimiter(rate float64, maxSlack time.Duration) Limiter {
+	return New(clock.NewClock(), rate, maxSlack)
+}
+
+func (l *limiter) Take() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if l.last.IsZero() {
+		l.last = now
+		return now
+	}
+
+	next := l.last.Add(l.perRequest)
+	if next.Before(now) {
+		// We're behind schedule, likely due to an idle period. Allow at most maxSlack of that idle
+		// capacity to be spent on this burst, rather than remembering it indefinitely.
+		if slack := now.Sub(next); slack > l.maxSlack {
+			next = now.Add(-l.maxSlack)
+		} else {
+			next = now
+		}
+	}
+	if wait := next.Sub(now); wait > 0 {
+		timer := l.clock.NewTimerAt(next)
+		<-timer.GetC()
+		timer.Stop()
+	}
+	l.last = next
+	return next
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retrypolicy provides pluggable strategies for deciding whether a failed attempt of some
+// operation should be retried, and how long to wait before the next attempt.
+package retrypolicy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides, for each failed attempt of a retryable operation, whether it should be
+// retried and how long to wait before the next attempt.
+type RetryPolicy interface {
+	// NextBackoff returns the delay to wait before making the given attempt (1-indexed) again,
+	// after it failed with err, and whether it should be retried at all. A false return means the
+	// caller should give up.
+	NextBackoff(attempt int, err error) (time.Duration, bool)
+}
+
+// DecorrelatedJitter is a RetryPolicy implementing the "decorrelated jitter" backoff algorithm:
+// each delay is chosen uniformly at random between Base and 3x the previous delay, capped at Cap.
+// This spreads out retries from many simultaneously-failing callers better than plain exponential
+// backoff with symmetric jitter.
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.
+type DecorrelatedJitter struct {
+	Base        time.Duration
+	Cap         time.Duration
+	MaxAttempts int // 0 means unlimited.
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	prev time.Duration
+}
+
+// NewDecorrelatedJitter creates a DecorrelatedJitter policy with the given base delay, delay cap,
+// and maximum number of attempts. A maxAttempts of 0 means unlimited attempts.
+func NewDecorrelatedJitter(base, cap time.Duration, maxAttempts int) *DecorrelatedJitter {
+	return newDecorrelatedJitter(base, cap, maxAttempts, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+func newDecorrelatedJitter(base, cap time.Duration, maxAttempts int, rng *rand.Rand) *DecorrelatedJitter {
+	return &DecorrelatedJitter{Base: base, Cap: cap, MaxAttempts: maxAttempts, rand: rng}
+}
+
+func (p *DecorrelatedJitter) NextBackoff(attempt int, err error) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt > p.MaxAttempts {
+		return 0, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prev := p.prev
+	if attempt <= 1 || prev < p.Base {
+		prev = p.Base
+	}
+	upper := prev * 3
+	delay := p.Base + time.Duration(p.rand.Int63n(int64(upper-p.Base)+1))
+	if delay > p.Cap {
+		delay = p.Cap
+	}
+	p.prev = delay
+	return delay, true
+}
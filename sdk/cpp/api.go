@@ -34,7 +34,10 @@ struct CurrentStatus {
 	int total_failure_count;
 	// Unix time UTC
 	long last_report_success;
-	// error_message indicates whether there was an error getting the status of the ubbagent. 
+	// The hash of the most recently appended audit log entry, or an empty string if the agent has
+	// no audit log.
+	char* audit_chain_head;
+	// error_message indicates whether there was an error getting the status of the ubbagent.
 	char* error_message;
 };
 */
@@ -130,7 +133,8 @@ func AgentGetStatus(agent_id C.int) C.struct_CurrentStatus {
 
 	return C.struct_CurrentStatus{ current_failure_count: C.int(stats.CurrentFailureCount),
 								   total_failure_count: C.int(stats.TotalFailureCount),
-								   last_report_success: C.long(stats.LastReportSuccess.Unix()) }
+								   last_report_success: C.long(stats.LastReportSuccess.Unix()),
+								   audit_chain_head: C.CString(stats.AuditChainHead) }
 }
 
 // Required empty func
@@ -15,27 +15,74 @@
 package sdk
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/GoogleCloudPlatform/ubbagent/auditlog"
 	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/GoogleCloudPlatform/ubbagent/gcemetadata"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
 	"github.com/GoogleCloudPlatform/ubbagent/persistence"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
 	"github.com/GoogleCloudPlatform/ubbagent/pipeline/builder"
 	"github.com/GoogleCloudPlatform/ubbagent/stats"
+	"github.com/golang/glog"
 )
 
+// auditLogDirName is the subdirectory of an agent's state directory holding its audit log.
+const auditLogDirName = "audit"
+
 // Agent is a convenience type that encapsulates a pipeline.Input and a stats.Provider and provides
 // programmatic interfaces similar to those provided by the standalone agent: init, add report,
 // get status, shutdown. Agent is used by the various language-specific SDK implementations
 // contained under this package.
 type Agent struct {
-	input    pipeline.Input
 	provider stats.Provider
+	auditLog auditlog.AuditLog
+
+	// p and recorder are retained (alongside cfg and built) so that Reload can call
+	// builder.Rebuild without the caller having to supply them again.
+	p        persistence.Persistence
+	recorder stats.Recorder
+
+	mu    sync.Mutex
+	cfg   *config.Config
+	built *builder.Built
+	input pipeline.Input
+
+	// reloadGeneration, lastReloadTime, and lastReloadErr track the most recent reload attempt -
+	// whether triggered by Reload, WatchConfigFile, or a config.Watcher started some other way -
+	// for GetStatus to surface over the agent's /status endpoint.
+	reloadGeneration int64
+	lastReloadTime   time.Time
+	lastReloadErr    error
+
+	// onConfigChange holds callbacks registered via OnConfigChange, invoked in registration order
+	// after a reload is successfully applied.
+	onConfigChange []func(old, new *config.Config)
+}
+
+// OnConfigChange registers cb to be called, with the Agent's previous and newly-applied Config,
+// every time a reload (via Reload, WatchConfigFile, or any other caller of applyConfig) succeeds.
+// Callbacks are called synchronously, in registration order, while agent.mu is not held; a reload
+// that occurs while a callback is still running waits for it to return. cb is not called for a
+// reload that's rejected or fails.
+func (agent *Agent) OnConfigChange(cb func(old, new *config.Config)) {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	agent.onConfigChange = append(agent.onConfigChange, cb)
 }
 
 // NewAgent creates a new Agent. The configuration is passed as YAML or JSON in configData. The
-// state directory is passed as stateDir. If stateDir is empty, state will not be persisted.
+// state directory is passed as stateDir. If stateDir is empty, state will not be persisted, and
+// the agent's audit log will be a no-op.
 func NewAgent(configData []byte, stateDir string) (*Agent, error) {
 	cfg, err := parseConfig(configData)
 	if err != nil {
@@ -43,37 +90,259 @@ func NewAgent(configData []byte, stateDir string) (*Agent, error) {
 	}
 
 	var p persistence.Persistence
+	var al auditlog.AuditLog
 	if stateDir == "" {
 		p = persistence.NewMemoryPersistence()
+		al = auditlog.NewNoopLog()
 	} else {
 		var err error
-		p, err = persistence.NewDiskPersistence(stateDir)
+		p, err = newPersistence(cfg.Persistence, stateDir)
+		if err != nil {
+			return nil, err
+		}
+		var key []byte
+		if cfg.AuditLog != nil {
+			key, err = ioutil.ReadFile(cfg.AuditLog.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("sdk: reading audit log keyFile: %v", err)
+			}
+			key = bytes.TrimSpace(key)
+		}
+		al, err = auditlog.NewLog(filepath.Join(stateDir, auditLogDirName), key)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	basic := stats.NewBasic()
-	input, err := builder.Build(cfg, p, basic)
+	var recorder stats.Recorder
+	var provider stats.Provider
+	var prom *stats.PrometheusRecorder
+	if cfg.Server != nil && cfg.Server.MetricsAddress != "" {
+		prom = stats.NewPrometheusRecorder()
+		recorder, provider = prom, prom
+	} else {
+		basic := stats.NewBasic()
+		recorder, provider = basic, basic
+	}
+
+	built, err := builder.Rebuild(nil, cfg, p, recorder, al)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Agent{input, basic}, nil
+	if prom != nil {
+		startMetricsServer(cfg.Server.MetricsAddress, prom, built.Autodetected)
+	}
+
+	return &Agent{
+		provider: provider,
+		auditLog: al,
+		p:        p,
+		recorder: recorder,
+		cfg:      cfg,
+		built:    built,
+		input:    built.Input,
+	}, nil
 }
 
-// Shutdown terminates this agent.
-func (agent *Agent) Shutdown() error {
-	err := agent.input.Release()
-	if err != nil {
-		return err
+// newPersistence constructs the Persistence backend described by cfg, storing data under
+// stateDir. A nil cfg falls back to NewDiskPersistence, matching NewAgent's pre-existing default
+// for a non-empty stateDir.
+func newPersistence(cfg *config.Persistence, stateDir string) (persistence.Persistence, error) {
+	if cfg == nil {
+		return persistence.NewDiskPersistence(stateDir)
+	}
+	switch cfg.Type {
+	case config.PersistenceMemory:
+		return persistence.NewMemoryPersistence(), nil
+	case config.PersistenceDisk:
+		dir := cfg.Directory
+		if dir == "" {
+			dir = stateDir
+		}
+		if err := checkAutoCreate(cfg, dir); err != nil {
+			return nil, err
+		}
+		return persistence.NewDiskPersistence(dir)
+	case config.PersistenceKV:
+		dir := cfg.Directory
+		if dir == "" {
+			dir = stateDir
+		}
+		if err := checkAutoCreate(cfg, dir); err != nil {
+			return nil, err
+		}
+		return persistence.NewKVPersistence(dir)
+	case config.PersistenceBolt:
+		path := cfg.Path
+		if path == "" {
+			path = filepath.Join(stateDir, "ubbagent.db")
+		}
+		if err := checkAutoCreate(cfg, filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		return persistence.NewBoltPersistence(path)
+	case config.PersistenceRedis:
+		return persistence.NewRedisPersistence(cfg.Addr)
+	default:
+		return nil, fmt.Errorf("sdk: unsupported persistence type: %v", cfg.Type)
+	}
+}
+
+// checkAutoCreate fails with an error if cfg.AutoCreate is false and dir does not already exist.
+func checkAutoCreate(cfg *config.Persistence, dir string) error {
+	if cfg.AutoCreate {
+		return nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("sdk: persistence directory does not exist and autoCreate is false: %v", dir)
 	}
 	return nil
 }
 
+// startMetricsServer starts an HTTP server on addr that serves h's Prometheus-format metrics at
+// /metrics, a liveness check at /healthz, and detected's GCE metadata (if any) at /metadata. It
+// runs for the lifetime of the process; NewAgent has no corresponding Shutdown hook for it,
+// matching the agent's other best-effort background goroutines (e.g. pipeline sources).
+//
+// By the time this server is reachable, startup - including any GCE metadata autodetection - has
+// already run to completion inside builder.Rebuild, so /healthz has nothing further to wait on.
+func startMetricsServer(addr string, h http.Handler, detected gcemetadata.Info) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/metadata", handleMetadata(detected))
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("sdk: metrics server error: %+v", err)
+		}
+	}()
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// handleMetadata returns a handler that serves detected as JSON, for diagnosing whether GCE
+// metadata autodetection found anything useful.
+func handleMetadata(detected gcemetadata.Info) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(detected); err != nil {
+			glog.Errorf("sdk: encoding /metadata response: %+v", err)
+		}
+	}
+}
+
+// Shutdown terminates this agent.
+func (agent *Agent) Shutdown() error {
+	agent.mu.Lock()
+	input := agent.input
+	agent.mu.Unlock()
+	return input.Release()
+}
+
 // AddReport adds a new usage report.
 func (agent *Agent) AddReport(report metrics.MetricReport) error {
-	return agent.input.AddReport(report.Copy())
+	agent.mu.Lock()
+	input := agent.input
+	agent.mu.Unlock()
+	return input.AddReport(report.Copy())
+}
+
+// Recorder returns the stats.Recorder this Agent's pipeline reports send results to. It's exposed
+// so an additional RPC interface - such as the grpc package's GRPCInterface - can observe its own
+// activity (e.g. in-flight streams) through whatever optional Recorder interfaces it implements,
+// the same way the rest of the pipeline does.
+func (agent *Agent) Recorder() stats.Recorder {
+	return agent.recorder
+}
+
+// Config returns the config.Config this Agent is currently running, reflecting the most recent
+// successful Reload (or WatchConfigFile-triggered reload) if any. Callers must not mutate it.
+func (agent *Agent) Config() *config.Config {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return agent.cfg
+}
+
+// Reload parses and validates new config data (in the format accepted by NewAgent) and, unless
+// doing so would change a metric's type - which would corrupt that metric's existing aggregation
+// buffer - atomically replaces the running pipeline with one built from it. Endpoints whose name
+// and configuration are unchanged keep their in-flight buffers and disk-persisted retry queues;
+// removed or changed endpoints are drained and closed; new endpoints start cold. It returns the
+// config.ChangeSet describing what changed, even when the reload is rejected.
+func (agent *Agent) Reload(configData []byte) (config.ChangeSet, error) {
+	cfg, err := parseConfig(configData)
+	if err != nil {
+		return config.ChangeSet{}, err
+	}
+	return agent.applyConfig(cfg)
+}
+
+// WatchConfigFile starts a config.Watcher on path, initialized from the Agent's current config,
+// that calls Reload with the file's new contents whenever it receives SIGHUP - the file-based
+// counterpart to calling Reload directly (e.g. from an RPC or HTTP endpoint). It's how the
+// standalone agent (main.go) picks up config changes without restarting the process. Close the
+// returned config.Watcher to stop watching; doing so has no effect on the Agent itself.
+func (agent *Agent) WatchConfigFile(path string) *config.Watcher {
+	agent.mu.Lock()
+	cfg := agent.cfg
+	agent.mu.Unlock()
+	return config.NewWatcher(path, cfg, func(newCfg *config.Config, _ config.ChangeSet) error {
+		_, err := agent.applyConfig(newCfg)
+		return err
+	})
+}
+
+// applyConfig validates cfg against the Agent's current config and, unless doing so would change a
+// metric's type, atomically replaces the running pipeline with one built from it. It's the common
+// implementation behind Reload and WatchConfigFile.
+func (agent *Agent) applyConfig(cfg *config.Config) (cs config.ChangeSet, err error) {
+	agent.mu.Lock()
+
+	var oldCfg *config.Config
+	var callbacks []func(old, new *config.Config)
+	defer func() {
+		agent.reloadGeneration++
+		agent.lastReloadTime = time.Now()
+		agent.lastReloadErr = err
+		agent.mu.Unlock()
+		if err == nil {
+			for _, cb := range callbacks {
+				cb(oldCfg, cfg)
+			}
+		}
+	}()
+
+	cs, err = config.Diff(agent.cfg, cfg)
+	if err != nil {
+		return config.ChangeSet{}, err
+	}
+	if len(cs.MetricTypeChanges) > 0 {
+		err = fmt.Errorf("sdk: reload rejected: metric type changed: %v", cs.MetricTypeChanges)
+		return cs, err
+	}
+
+	built, err := builder.Rebuild(agent.built, cfg, agent.p, agent.recorder, agent.auditLog)
+	if err != nil {
+		return cs, err
+	}
+
+	prev := agent.built
+	oldCfg = agent.cfg
+	agent.built = built
+	agent.input = built.Input
+	agent.cfg = cfg
+	callbacks = agent.onConfigChange
+
+	if err = prev.Input.Release(); err != nil {
+		err = fmt.Errorf("sdk: reload: releasing previous pipeline: %v", err)
+		return cs, err
+	}
+	return cs, nil
 }
 
 // AddReportJson adds a new usage report after fist unmarshalling it from JSON.
@@ -87,7 +356,18 @@ func (agent *Agent) AddReportJson(reportData []byte) error {
 
 // GetStatus returns a stats.Snapshot object containing current agent status.
 func (agent *Agent) GetStatus() stats.Snapshot {
-	return agent.provider.Snapshot()
+	snap := agent.provider.Snapshot()
+	snap.AuditChainHead = agent.auditLog.Head()
+
+	agent.mu.Lock()
+	snap.LastReloadGeneration = agent.reloadGeneration
+	snap.LastReloadTime = agent.lastReloadTime
+	if agent.lastReloadErr != nil {
+		snap.LastReloadError = agent.lastReloadErr.Error()
+	}
+	agent.mu.Unlock()
+
+	return snap
 }
 
 // GetStatusJson returns a stats.Snapshot object serialized as JSON.
@@ -93,6 +93,19 @@ func (s *Basic) SendFailed(id string, handler string) {
 	}
 }
 
+// Warn records that handler's send of id failed but, unlike SendFailed, counts only toward
+// WarningCount rather than CurrentFailureCount or TotalFailureCount. See WarnObserver.
+func (s *Basic) Warn(id string, handler string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.pending[id]; exists {
+		delete(s.pending, id)
+		s.current.WarningCount++
+	} else {
+		glog.Warningf("stats.Basic: ignoring Warn from handler %v of unknown report id %v", handler, id)
+	}
+}
+
 func (s *Basic) Snapshot() Snapshot {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -0,0 +1,451 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/clock"
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+)
+
+// QueueObserver is implemented by a Recorder that wants to report the depth of a RetryingSender's
+// persistence.Queue. A RetryingSender passes its own queue to ObserveQueue, keyed by endpoint
+// name, once at construction time if its configured Recorder implements this interface.
+type QueueObserver interface {
+	ObserveQueue(endpoint string, q persistence.Queue)
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the histogram buckets PrometheusRecorder
+// reports send latency in, in addition to the _sum and _count series every Prometheus histogram
+// has. An observation's bucket counts are cumulative, per the Prometheus histogram convention; the
+// implicit "+Inf" bucket is handlerStats.latencyCount.
+var latencyBuckets = []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60}
+
+// handlerStats tracks the send counters and latency totals PrometheusRecorder reports for a single
+// handler (endpoint name).
+type handlerStats struct {
+	successCount   int64
+	failureCount   int64
+	warningCount   int64
+	latencyCount   int64
+	latencySum     float64 // seconds
+	latencyBuckets []int64 // cumulative counts, parallel to the package-level latencyBuckets
+	lastSuccess    time.Time
+}
+
+// registration tracks the outstanding handlers of a single Register call, so that
+// PrometheusRecorder can compute each handler's send latency once it reports success or failure.
+type registration struct {
+	start    time.Time
+	handlers map[string]bool
+}
+
+// ingestStats tracks the report-ingestion counters and buffer size PrometheusRecorder reports for
+// a single metric, via IngestObserver.
+type ingestStats struct {
+	received    int64
+	rejected    map[string]int64 // reason -> count
+	bufferBytes int64
+}
+
+// PrometheusRecorder is a Recorder and Provider that exposes agent-internal telemetry in
+// Prometheus text exposition format via its ServeHTTP method, in addition to the same
+// stats.Snapshot a Basic provides. It wraps a Basic to avoid duplicating the pending-send and
+// snapshot bookkeeping used for GetStatus. It also implements IngestObserver and
+// DeadLetterObserver, so an Aggregator and RetryingSender configured with a PrometheusRecorder
+// report their ingestion-side and dead-letter counters alongside the send-side ones above.
+type PrometheusRecorder struct {
+	*Basic
+
+	clock clock.Clock
+
+	mu          sync.Mutex
+	registered  map[string]*registration
+	handlers    map[string]*handlerStats
+	queues      map[string]persistence.Queue
+	ingest      map[string]*ingestStats
+	deadLetters map[string]int64
+	streams     map[string]int64
+	backoff     map[string]time.Duration
+}
+
+// NewPrometheusRecorder creates a new PrometheusRecorder.
+func NewPrometheusRecorder() *PrometheusRecorder {
+	return newPrometheusRecorder(clock.NewClock())
+}
+
+func newPrometheusRecorder(clk clock.Clock) *PrometheusRecorder {
+	return &PrometheusRecorder{
+		Basic:       newBasic(clk),
+		clock:       clk,
+		registered:  make(map[string]*registration),
+		handlers:    make(map[string]*handlerStats),
+		queues:      make(map[string]persistence.Queue),
+		ingest:      make(map[string]*ingestStats),
+		deadLetters: make(map[string]int64),
+		streams:     make(map[string]int64),
+		backoff:     make(map[string]time.Duration),
+	}
+}
+
+func (p *PrometheusRecorder) Register(id string, handlers []string) {
+	p.Basic.Register(id, handlers)
+	hm := make(map[string]bool, len(handlers))
+	for _, h := range handlers {
+		hm[h] = true
+	}
+	p.mu.Lock()
+	p.registered[id] = &registration{start: p.clock.Now(), handlers: hm}
+	p.mu.Unlock()
+}
+
+func (p *PrometheusRecorder) SendSucceeded(id string, handler string) {
+	p.Basic.SendSucceeded(id, handler)
+	p.recordResult(id, handler, true)
+}
+
+func (p *PrometheusRecorder) SendFailed(id string, handler string) {
+	p.Basic.SendFailed(id, handler)
+	p.recordResult(id, handler, false)
+}
+
+// recordResult updates handler's counters and latency totals, and forgets id's registration once
+// every one of its handlers has reported a result.
+func (p *PrometheusRecorder) recordResult(id string, handler string, success bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hs, ok := p.handlers[handler]
+	if !ok {
+		hs = &handlerStats{latencyBuckets: make([]int64, len(latencyBuckets))}
+		p.handlers[handler] = hs
+	}
+	if success {
+		hs.successCount++
+		hs.lastSuccess = p.clock.Now()
+	} else {
+		hs.failureCount++
+	}
+
+	reg, ok := p.registered[id]
+	if !ok {
+		// Register was never observed for this id (e.g. it was trimmed from Basic's pending set).
+		// Counters above still apply; there's nothing to measure latency against.
+		return
+	}
+	latency := p.clock.Now().Sub(reg.start).Seconds()
+	hs.latencyCount++
+	hs.latencySum += latency
+	for i, le := range latencyBuckets {
+		if latency <= le {
+			hs.latencyBuckets[i]++
+		}
+	}
+	delete(reg.handlers, handler)
+	if len(reg.handlers) == 0 {
+		delete(p.registered, id)
+	}
+}
+
+// Warn records handler's result for id as a warning rather than a hard failure - see WarnObserver -
+// clearing its latency bookkeeping the same way recordResult does, but without counting toward
+// failureCount.
+func (p *PrometheusRecorder) Warn(id string, handler string) {
+	p.Basic.Warn(id, handler)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hs, ok := p.handlers[handler]
+	if !ok {
+		hs = &handlerStats{latencyBuckets: make([]int64, len(latencyBuckets))}
+		p.handlers[handler] = hs
+	}
+	hs.warningCount++
+
+	reg, ok := p.registered[id]
+	if !ok {
+		return
+	}
+	delete(reg.handlers, handler)
+	if len(reg.handlers) == 0 {
+		delete(p.registered, id)
+	}
+}
+
+// ObserveQueue registers q to be reported as a gauge of queue length for endpoint. See
+// QueueObserver.
+func (p *PrometheusRecorder) ObserveQueue(endpoint string, q persistence.Queue) {
+	p.mu.Lock()
+	p.queues[endpoint] = q
+	p.mu.Unlock()
+}
+
+// ObserveReportReceived increments the total count of reports received for metric. See
+// IngestObserver.
+func (p *PrometheusRecorder) ObserveReportReceived(metric string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ingestFor(metric).received++
+}
+
+// ObserveReportRejected increments the count of reports rejected for metric, by reason. See
+// IngestObserver.
+func (p *PrometheusRecorder) ObserveReportRejected(metric string, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	is := p.ingestFor(metric)
+	if is.rejected == nil {
+		is.rejected = make(map[string]int64)
+	}
+	is.rejected[reason]++
+}
+
+// ObserveBufferBytes records the current in-memory size of metric's aggregation bucket. See
+// IngestObserver.
+func (p *PrometheusRecorder) ObserveBufferBytes(metric string, bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ingestFor(metric).bufferBytes = bytes
+}
+
+// ObserveDeadLetter increments the count of reports given up on for endpoint. See
+// DeadLetterObserver.
+func (p *PrometheusRecorder) ObserveDeadLetter(endpoint string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadLetters[endpoint]++
+}
+
+// ObserveBackoffDelay records endpoint's current retry delay. See BackoffObserver.
+func (p *PrometheusRecorder) ObserveBackoffDelay(endpoint string, delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backoff[endpoint] = delay
+}
+
+// ObserveStreamStarted increments the count of in-flight streams for method. See StreamObserver.
+func (p *PrometheusRecorder) ObserveStreamStarted(method string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams[method]++
+}
+
+// ObserveStreamEnded decrements the count of in-flight streams for method. See StreamObserver.
+func (p *PrometheusRecorder) ObserveStreamEnded(method string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams[method]--
+}
+
+// ingestFor returns metric's ingestStats, creating it if this is the first observation for metric.
+// Callers must hold p.mu.
+func (p *PrometheusRecorder) ingestFor(metric string) *ingestStats {
+	is, ok := p.ingest[metric]
+	if !ok {
+		is = &ingestStats{}
+		p.ingest[metric] = is
+	}
+	return is
+}
+
+// ServeHTTP writes this recorder's metrics in Prometheus text exposition format.
+func (p *PrometheusRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	p.mu.Lock()
+	handlerNames := make([]string, 0, len(p.handlers))
+	for name := range p.handlers {
+		handlerNames = append(handlerNames, name)
+	}
+	sort.Strings(handlerNames)
+	handlers := make(map[string]handlerStats, len(p.handlers))
+	for _, name := range handlerNames {
+		hs := *p.handlers[name]
+		hs.latencyBuckets = append([]int64(nil), hs.latencyBuckets...)
+		handlers[name] = hs
+	}
+	queueNames := make([]string, 0, len(p.queues))
+	for name := range p.queues {
+		queueNames = append(queueNames, name)
+	}
+	sort.Strings(queueNames)
+	queues := make(map[string]persistence.Queue, len(p.queues))
+	for _, name := range queueNames {
+		queues[name] = p.queues[name]
+	}
+	metricNames := make([]string, 0, len(p.ingest))
+	for name := range p.ingest {
+		metricNames = append(metricNames, name)
+	}
+	sort.Strings(metricNames)
+	ingest := make(map[string]ingestStats, len(p.ingest))
+	for _, name := range metricNames {
+		is := *p.ingest[name]
+		rejected := make(map[string]int64, len(is.rejected))
+		for reason, count := range is.rejected {
+			rejected[reason] = count
+		}
+		is.rejected = rejected
+		ingest[name] = is
+	}
+	deadLetterNames := make([]string, 0, len(p.deadLetters))
+	for name := range p.deadLetters {
+		deadLetterNames = append(deadLetterNames, name)
+	}
+	sort.Strings(deadLetterNames)
+	deadLetters := make(map[string]int64, len(p.deadLetters))
+	for _, name := range deadLetterNames {
+		deadLetters[name] = p.deadLetters[name]
+	}
+	streamNames := make([]string, 0, len(p.streams))
+	for name := range p.streams {
+		streamNames = append(streamNames, name)
+	}
+	sort.Strings(streamNames)
+	streams := make(map[string]int64, len(p.streams))
+	for _, name := range streamNames {
+		streams[name] = p.streams[name]
+	}
+	backoffNames := make([]string, 0, len(p.backoff))
+	for name := range p.backoff {
+		backoffNames = append(backoffNames, name)
+	}
+	sort.Strings(backoffNames)
+	backoff := make(map[string]time.Duration, len(p.backoff))
+	for _, name := range backoffNames {
+		backoff[name] = p.backoff[name]
+	}
+	p.mu.Unlock()
+
+	snap := p.Snapshot()
+	now := p.clock.Now()
+
+	fmt.Fprintln(w, "# HELP ubbagent_send_total Reports sent to an endpoint, by result.")
+	fmt.Fprintln(w, "# TYPE ubbagent_send_total counter")
+	for _, name := range handlerNames {
+		hs := handlers[name]
+		fmt.Fprintf(w, "ubbagent_send_total{endpoint=%q,result=\"success\"} %d\n", name, hs.successCount)
+		fmt.Fprintf(w, "ubbagent_send_total{endpoint=%q,result=\"failure\"} %d\n", name, hs.failureCount)
+		fmt.Fprintf(w, "ubbagent_send_total{endpoint=%q,result=\"warning\"} %d\n", name, hs.warningCount)
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_send_latency_seconds Time from Register to a handler reporting a result.")
+	fmt.Fprintln(w, "# TYPE ubbagent_send_latency_seconds histogram")
+	for _, name := range handlerNames {
+		hs := handlers[name]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "ubbagent_send_latency_seconds_bucket{endpoint=%q,le=%q} %d\n", name, fmt.Sprintf("%v", le), hs.latencyBuckets[i])
+		}
+		fmt.Fprintf(w, "ubbagent_send_latency_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d\n", name, hs.latencyCount)
+		fmt.Fprintf(w, "ubbagent_send_latency_seconds_sum{endpoint=%q} %v\n", name, hs.latencySum)
+		fmt.Fprintf(w, "ubbagent_send_latency_seconds_count{endpoint=%q} %d\n", name, hs.latencyCount)
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_current_failures Consecutive send failures since the last success.")
+	fmt.Fprintln(w, "# TYPE ubbagent_current_failures gauge")
+	fmt.Fprintf(w, "ubbagent_current_failures %d\n", snap.CurrentFailureCount)
+
+	fmt.Fprintln(w, "# HELP ubbagent_total_failures_total Send failures recorded over the agent's lifetime.")
+	fmt.Fprintln(w, "# TYPE ubbagent_total_failures_total counter")
+	fmt.Fprintf(w, "ubbagent_total_failures_total %d\n", snap.TotalFailureCount)
+
+	fmt.Fprintln(w, "# HELP ubbagent_last_success_seconds Time since an endpoint's last successful send.")
+	fmt.Fprintln(w, "# TYPE ubbagent_last_success_seconds gauge")
+	for _, name := range handlerNames {
+		hs := handlers[name]
+		if hs.lastSuccess.IsZero() {
+			continue
+		}
+		fmt.Fprintf(w, "ubbagent_last_success_seconds{endpoint=%q} %v\n", name, now.Sub(hs.lastSuccess).Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_queue_length Number of reports currently queued for an endpoint.")
+	fmt.Fprintln(w, "# TYPE ubbagent_queue_length gauge")
+	for _, name := range queueNames {
+		length, err := queues[name].Len()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "ubbagent_queue_length{endpoint=%q} %d\n", name, length)
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_queue_oldest_age_seconds Age of the oldest report currently queued for an endpoint.")
+	fmt.Fprintln(w, "# TYPE ubbagent_queue_oldest_age_seconds gauge")
+	for _, name := range queueNames {
+		// queueHead's SendTime tag matches the "SendTime" field persisted by every queue entry
+		// RetryingSender enqueues; Peek ignores JSON fields it doesn't name, so this works without
+		// either package depending on the other's entry type.
+		var head queueHead
+		if err := queues[name].Peek(&head); err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "ubbagent_queue_oldest_age_seconds{endpoint=%q} %v\n", name, now.Sub(head.SendTime).Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_reports_received_total Reports an Aggregator's AddReport was called with, by metric.")
+	fmt.Fprintln(w, "# TYPE ubbagent_reports_received_total counter")
+	for _, name := range metricNames {
+		fmt.Fprintf(w, "ubbagent_reports_received_total{metric=%q} %d\n", name, ingest[name].received)
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_reports_rejected_total Reports an Aggregator's AddReport rejected, by metric and reason.")
+	fmt.Fprintln(w, "# TYPE ubbagent_reports_rejected_total counter")
+	for _, name := range metricNames {
+		reasons := make([]string, 0, len(ingest[name].rejected))
+		for reason := range ingest[name].rejected {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "ubbagent_reports_rejected_total{metric=%q,reason=%q} %d\n", name, reason, ingest[name].rejected[reason])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_aggregator_buffer_bytes Approximate in-memory size of a metric's current aggregation bucket.")
+	fmt.Fprintln(w, "# TYPE ubbagent_aggregator_buffer_bytes gauge")
+	for _, name := range metricNames {
+		fmt.Fprintf(w, "ubbagent_aggregator_buffer_bytes{metric=%q} %d\n", name, ingest[name].bufferBytes)
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_dead_letter_total Reports a RetryingSender gave up retrying, by endpoint.")
+	fmt.Fprintln(w, "# TYPE ubbagent_dead_letter_total counter")
+	for _, name := range deadLetterNames {
+		fmt.Fprintf(w, "ubbagent_dead_letter_total{endpoint=%q} %d\n", name, deadLetters[name])
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_backoff_delay_seconds Current retry delay before an endpoint's next send attempt.")
+	fmt.Fprintln(w, "# TYPE ubbagent_backoff_delay_seconds gauge")
+	for _, name := range backoffNames {
+		fmt.Fprintf(w, "ubbagent_backoff_delay_seconds{endpoint=%q} %v\n", name, backoff[name].Seconds())
+	}
+
+	fmt.Fprintln(w, "# HELP ubbagent_grpc_streams_in_flight Streaming RPCs currently open, by full method name.")
+	fmt.Fprintln(w, "# TYPE ubbagent_grpc_streams_in_flight gauge")
+	for _, name := range streamNames {
+		fmt.Fprintf(w, "ubbagent_grpc_streams_in_flight{method=%q} %d\n", name, streams[name])
+	}
+}
+
+// queueHead is the subset of a RetryingSender's persisted queue entry that ServeHTTP needs in
+// order to report the age of the oldest queued report.
+type queueHead struct {
+	SendTime time.Time
+}
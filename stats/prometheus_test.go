@@ -0,0 +1,105 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/persistence"
+	"github.com/GoogleCloudPlatform/ubbagent/testlib"
+)
+
+func TestPrometheusRecorder(t *testing.T) {
+	mc := testlib.NewMockClock()
+	mc.SetNow(time.Unix(1000, 0))
+	p := newPrometheusRecorder(mc)
+
+	p.Register("report1", []string{"handler1", "handler2"})
+	mc.SetNow(time.Unix(1001, 0))
+	p.SendSucceeded("report1", "handler1")
+	p.SendFailed("report1", "handler2")
+
+	p.ObserveReportReceived("metric1")
+	p.ObserveReportReceived("metric1")
+	p.ObserveReportRejected("metric1", "out-of-order")
+	p.ObserveBufferBytes("metric1", 128)
+	p.ObserveDeadLetter("handler2")
+	p.ObserveStreamStarted("AddReportStream")
+	p.ObserveBackoffDelay("handler2", 4*time.Second)
+
+	q := persistence.NewMemoryPersistence().Queue("handler1")
+	if err := q.Enqueue(struct{ SendTime time.Time }{SendTime: time.Unix(500, 0)}); err != nil {
+		t.Fatalf("error enqueueing: %+v", err)
+	}
+	p.ObserveQueue("handler1", q)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type: want text/plain prefix, got %v", ct)
+	}
+
+	body := rec.Body.String()
+	wantContains := []string{
+		`ubbagent_send_total{endpoint="handler1",result="success"} 1`,
+		`ubbagent_send_total{endpoint="handler2",result="failure"} 1`,
+		`ubbagent_send_latency_seconds_count{endpoint="handler1"} 1`,
+		`ubbagent_current_failures 1`,
+		`ubbagent_total_failures_total 1`,
+		`ubbagent_reports_received_total{metric="metric1"} 2`,
+		`ubbagent_reports_rejected_total{metric="metric1",reason="out-of-order"} 1`,
+		`ubbagent_aggregator_buffer_bytes{metric="metric1"} 128`,
+		`ubbagent_dead_letter_total{endpoint="handler2"} 1`,
+		`ubbagent_backoff_delay_seconds{endpoint="handler2"} 4`,
+		`ubbagent_grpc_streams_in_flight{method="AddReportStream"} 1`,
+		`ubbagent_queue_length{endpoint="handler1"} 1`,
+		`ubbagent_queue_oldest_age_seconds{endpoint="handler1"} 501`,
+	}
+	for _, want := range wantContains {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics output to contain %q, got:\n%v", want, body)
+		}
+	}
+
+	// Snapshot should still reflect the same underlying Basic state PrometheusRecorder wraps.
+	snap := p.Snapshot()
+	if want, got := 1, snap.CurrentFailureCount; want != got {
+		t.Fatalf("snap.CurrentFailureCount: want=%v, got=%v", want, got)
+	}
+}
+
+func TestPrometheusRecorder_Warn(t *testing.T) {
+	mc := testlib.NewMockClock()
+	p := newPrometheusRecorder(mc)
+
+	p.Register("report1", []string{"handler1"})
+	p.Warn("report1", "handler1")
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `ubbagent_send_total{endpoint="handler1",result="warning"} 1`) {
+		t.Errorf("expected a warning count for handler1, got:\n%v", body)
+	}
+	if !strings.Contains(body, `ubbagent_send_total{endpoint="handler1",result="failure"} 0`) {
+		t.Errorf("expected Warn not to count as a failure for handler1, got:\n%v", body)
+	}
+}
@@ -19,12 +19,12 @@ import "time"
 // A Recorder records the result of sending a metrics.StampedMetricReport to one or more endpoints.
 //
 // A Recorder expects the following flow:
-// 1. The Register method is called prior to performing a send. The method is passed the ID of the
-//    StampedMetricReport being sent and a list of the handlers that will perform the operation.
-//    Register is called by the first Sender in a pipeline, generally a sender.Dispatcher.
-// 2. As each handler succeeds or fails in performing its portion of the overall operation, it
-//    registers the result using the SendSucceeded and SendFailed methods. The handlers are
-//    generally instances of sender.RetryingSender, wrapping endpoints.
+//  1. The Register method is called prior to performing a send. The method is passed the ID of the
+//     StampedMetricReport being sent and a list of the handlers that will perform the operation.
+//     Register is called by the first Sender in a pipeline, generally a sender.Dispatcher.
+//  2. As each handler succeeds or fails in performing its portion of the overall operation, it
+//     registers the result using the SendSucceeded and SendFailed methods. The handlers are
+//     generally instances of sender.RetryingSender, wrapping endpoints.
 //
 // The id value should be set to the value of a StampedMetricReport.Id. A handler should generally
 // be set to the name of an endpoint handling part of the send operation.
@@ -40,6 +40,74 @@ type Provider interface {
 	Snapshot() Snapshot
 }
 
+// IngestObserver is implemented by a Recorder that wants to observe the ingestion side of a
+// metric's lifecycle at an Aggregator - reports received, reports rejected (with a reason), and
+// the in-memory size of the aggregation buffer - complementing Recorder/QueueObserver, which only
+// observe the send side. An Aggregator calls these if its configured Recorder implements this
+// interface.
+type IngestObserver interface {
+	// ObserveReportReceived records that AddReport was called for metric.
+	ObserveReportReceived(metric string)
+
+	// ObserveReportRejected records that AddReport for metric returned an error, classified by
+	// reason (e.g. "invalid", "too_late", "closed").
+	ObserveReportRejected(metric string, reason string)
+
+	// ObserveBufferBytes records the approximate in-memory size, in bytes, of metric's current
+	// aggregation bucket.
+	ObserveBufferBytes(metric string, bytes int64)
+}
+
+// DeadLetterObserver is implemented by a Recorder that wants to count reports a RetryingSender
+// gives up on retrying, whether or not a pipeline.DeadLetterSink is also configured to persist
+// them. A RetryingSender calls this if its configured Recorder implements this interface.
+type DeadLetterObserver interface {
+	// ObserveDeadLetter records that endpoint gave up on a report after exhausting its retries.
+	ObserveDeadLetter(endpoint string)
+}
+
+// BreakerObserver is implemented by a Recorder that wants to observe a RetryingSender's circuit
+// breaker state transitions for its endpoint. A RetryingSender calls this if its configured
+// Recorder implements this interface.
+type BreakerObserver interface {
+	// ObserveBreakerStateChange records that endpoint's circuit breaker transitioned to state (one
+	// of "closed", "open", "halfOpen").
+	ObserveBreakerStateChange(endpoint string, state string)
+}
+
+// BackoffObserver is implemented by a Recorder that wants to observe a RetryingSender's current
+// retry delay for its endpoint. A RetryingSender calls this if its configured Recorder implements
+// this interface.
+type BackoffObserver interface {
+	// ObserveBackoffDelay records endpoint's current retry delay - how long maybeSend will wait
+	// before its next attempt. It's reported as zero once a send succeeds or a report is given up
+	// on, since there's then nothing pending to back off.
+	ObserveBackoffDelay(endpoint string, delay time.Duration)
+}
+
+// StreamObserver is implemented by a Recorder that wants to track streaming RPCs in flight, such
+// as a grpc interface's AddReportStream. The RPC server calls these if its configured Recorder
+// implements this interface.
+type StreamObserver interface {
+	// ObserveStreamStarted records that a stream identified by method (its full gRPC method name)
+	// began.
+	ObserveStreamStarted(method string)
+
+	// ObserveStreamEnded records that a stream previously passed to ObserveStreamStarted with the
+	// same method completed, however it completed.
+	ObserveStreamEnded(method string)
+}
+
+// WarnObserver is implemented by a Recorder that wants a handler's failure recorded as a warning
+// rather than a hard failure - counted in Snapshot.WarningCount instead of CurrentFailureCount or
+// TotalFailureCount. It's used by stats.NewWarnRecorder to wrap the Recorder passed to an endpoint
+// sender built for a metric configured with config.ModeWarn.
+type WarnObserver interface {
+	// Warn records that handler's send of the report identified by id failed, but that the failure
+	// should count as a warning instead of incrementing the failure counts SendFailed would.
+	Warn(id string, handler string)
+}
+
 // Snapshot encapsulates a point-in-time snapshot of agent send stats.
 type Snapshot struct {
 	// The last time a send succeeded.
@@ -50,6 +118,27 @@ type Snapshot struct {
 
 	// The number of failures since the last success.
 	TotalFailureCount int `json:"totalFailureCount"`
+
+	// WarningCount is the number of sends that failed while dispatched through a metric configured
+	// with config.ModeWarn, via WarnObserver. Unlike CurrentFailureCount and TotalFailureCount, it
+	// never resets and doesn't by itself indicate the pipeline is unhealthy.
+	WarningCount int64 `json:"warningCount,omitempty"`
+
+	// AuditChainHead is the hash of the most recently appended auditlog entry, or empty if the agent
+	// has no audit log (see auditlog.AuditLog.Head).
+	AuditChainHead string `json:"auditChainHead,omitempty"`
+
+	// LastReloadGeneration counts config reload attempts - via sdk.Agent.Reload, WatchConfigFile, or
+	// any other caller of applyConfig - successful or not. Zero means no reload has been attempted
+	// since startup.
+	LastReloadGeneration int64 `json:"lastReloadGeneration,omitempty"`
+
+	// LastReloadTime is when the most recently attempted reload completed.
+	LastReloadTime time.Time `json:"lastReloadTime,omitempty"`
+
+	// LastReloadError is the error from the most recently attempted reload, or empty if it
+	// succeeded (or no reload has been attempted).
+	LastReloadError string `json:"lastReloadError,omitempty"`
 }
 
 // NewNoopRecorder returns a Recorder that does nothing.
@@ -62,3 +151,21 @@ type noopRecorder struct{}
 func (*noopRecorder) Register(string, []string)    {}
 func (*noopRecorder) SendSucceeded(string, string) {}
 func (*noopRecorder) SendFailed(string, string)    {}
+
+// NewWarnRecorder wraps r so that a SendFailed call is recorded as a warning - via WarnObserver -
+// instead of a hard failure. Register and SendSucceeded are forwarded to r unchanged.
+func NewWarnRecorder(r Recorder) Recorder {
+	return &warnRecorder{r}
+}
+
+type warnRecorder struct {
+	Recorder
+}
+
+func (w *warnRecorder) SendFailed(id string, handler string) {
+	if obs, ok := w.Recorder.(WarnObserver); ok {
+		obs.Warn(id, handler)
+		return
+	}
+	w.Recorder.SendFailed(id, handler)
+}
@@ -71,22 +71,24 @@ type MockClock interface {
 	clock.Clock
 	SetNow(time.Time)
 
-	// GetNextFireTime returns the time that the next Timer will fire, or the zero value if no timers
-	// are set.
+	// GetNextFireTime returns the time that the next Timer or Ticker will fire, or the zero value if
+	// none are set.
 	GetNextFireTime() time.Time
 }
 
 // NewMockClock creates a new MockClock instance that initially returns time zero.
 func NewMockClock() MockClock {
 	return &mockClock{
-		timers: make(map[*mockTimer]bool),
+		timers:  make(map[*mockTimer]bool),
+		tickers: make(map[*mockTicker]bool),
 	}
 }
 
 type mockClock struct {
-	mutex  sync.Mutex
-	now    time.Time
-	timers map[*mockTimer]bool
+	mutex   sync.Mutex
+	now     time.Time
+	timers  map[*mockTimer]bool
+	tickers map[*mockTicker]bool
 }
 
 func (mc *mockClock) Now() time.Time {
@@ -103,6 +105,9 @@ func (mc *mockClock) SetNow(now time.Time) {
 		// this call might result in the timer being removed from the set.
 		mt.maybeFire(now)
 	}
+	for mt := range mc.tickers {
+		mt.maybeFire(now)
+	}
 }
 
 func (mc *mockClock) GetNextFireTime() time.Time {
@@ -114,6 +119,11 @@ func (mc *mockClock) GetNextFireTime() time.Time {
 			earliest = mt.fireAt
 		}
 	}
+	for mt := range mc.tickers {
+		if !mt.done && (earliest.IsZero() || mt.fireAt.Before(earliest)) {
+			earliest = mt.fireAt
+		}
+	}
 	return earliest
 }
 
@@ -130,6 +140,19 @@ func (mc *mockClock) NewTimerAt(at time.Time) clock.Timer {
 	return mc.newTimer(at)
 }
 
+func (mc *mockClock) NewTicker(d time.Duration) clock.Ticker {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+	mt := &mockTicker{
+		c:        make(chan time.Time, 1),
+		owner:    mc,
+		interval: d,
+		fireAt:   mc.now.Add(d),
+	}
+	mc.tickers[mt] = true
+	return mt
+}
+
 // Assumes mc.mutex is held.
 func (mc *mockClock) newTimer(at time.Time) clock.Timer {
 	c := make(chan time.Time, 1)
@@ -183,3 +206,43 @@ func (mt *mockTimer) maybeFire(t time.Time) {
 func (mt *mockTimer) remove() {
 	delete(mt.owner.timers, mt)
 }
+
+type mockTicker struct {
+	c        chan time.Time
+	owner    *mockClock
+	interval time.Duration
+	fireAt   time.Time
+	done     bool
+}
+
+func (mt *mockTicker) Chan() <-chan time.Time {
+	return mt.c
+}
+
+func (mt *mockTicker) Stop() {
+	mt.owner.mutex.Lock()
+	defer mt.owner.mutex.Unlock()
+	if mt.done {
+		return
+	}
+	mt.done = true
+	delete(mt.owner.tickers, mt)
+}
+
+// maybeFire delivers a tick if mock time has reached or passed fireAt, then advances fireAt to the
+// next boundary after t - catching up any boundaries that were skipped over - so a subsequent
+// maybeFire only ever delivers a single pending tick, the same as a real time.Ticker. Assumes that
+// mt.owner.mutex is held.
+func (mt *mockTicker) maybeFire(t time.Time) {
+	if mt.done || mt.fireAt.After(t) {
+		return
+	}
+	select {
+	case mt.c <- mt.fireAt:
+	default:
+		// The channel already holds an undelivered tick; drop this one, as a real Ticker would.
+	}
+	for !mt.fireAt.After(t) {
+		mt.fireAt = mt.fireAt.Add(mt.interval)
+	}
+}
@@ -15,6 +15,8 @@
 package testlib
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -22,8 +24,21 @@ import (
 
 	"github.com/GoogleCloudPlatform/ubbagent/endpoint"
 	"github.com/GoogleCloudPlatform/ubbagent/metrics"
+	"github.com/GoogleCloudPlatform/ubbagent/pipeline"
 )
 
+// Int64Ptr returns a pointer to v, for convenience when populating metrics.MetricValue literals
+// in tests.
+func Int64Ptr(v int64) *int64 {
+	return &v
+}
+
+// Float64Ptr returns a pointer to v, for convenience when populating metrics.MetricValue literals
+// in tests.
+func Float64Ptr(v float64) *float64 {
+	return &v
+}
+
 // Type waitForCalls is a base type that provides a doAndWait function.
 type waitForCalls struct {
 	calls    int32
@@ -61,23 +76,88 @@ type MockSender struct {
 	Used     bool
 	Released bool
 
-	reports   []metrics.MetricReport // must hold mu to read/write
-	sendErr   error
-	mu        sync.Mutex
-	endpoints []string
+	reports       []metrics.MetricReport // must hold mu to read/write
+	sendErr       error
+	prepareErr    error
+	mu            sync.Mutex
+	endpoints     []string
+	watchers      []pipeline.SendWatcher
+	prepareCalls  int32
+	sendCalls     int32
+	preparedCalls int32 // SendPrepared calls
 }
 
 func (s *MockSender) Send(report metrics.StampedMetricReport) error {
-	s.mu.Lock()
-	err := s.sendErr
+	ps, err := s.Prepare(report)
+	if err != nil {
+		return err
+	}
+	return ps.Send()
+}
+
+// Prepare records a Prepare call separately from the Send calls it returns a mockPreparedSend for,
+// so tests can assert that a Dispatcher calls Prepare on every sender before Send on any of them.
+func (s *MockSender) Prepare(report metrics.StampedMetricReport) (pipeline.PreparedSend, error) {
+	atomic.AddInt32(&s.prepareCalls, 1)
+	if s.prepareErr != nil {
+		return nil, s.prepareErr
+	}
+	return &mockPreparedSend{s: s, report: report}, nil
+}
+
+// SendPrepared replays a report previously returned by Prepare, as if resuming after a crash.
+func (s *MockSender) SendPrepared(ctx context.Context, payload []byte) error {
+	atomic.AddInt32(&s.preparedCalls, 1)
+	var report metrics.StampedMetricReport
+	if err := json.Unmarshal(payload, &report); err != nil {
+		return err
+	}
+	return (&mockPreparedSend{s: s, report: report}).Send()
+}
+
+// PrepareCalls returns the number of times Prepare has been called.
+func (s *MockSender) PrepareCalls() int32 {
+	return atomic.LoadInt32(&s.prepareCalls)
+}
+
+// SendCalls returns the number of times a PreparedSend returned by Prepare has had Send called.
+func (s *MockSender) SendCalls() int32 {
+	return atomic.LoadInt32(&s.sendCalls)
+}
+
+// SendPreparedCalls returns the number of times SendPrepared has been called.
+func (s *MockSender) SendPreparedCalls() int32 {
+	return atomic.LoadInt32(&s.preparedCalls)
+}
+
+// SetPrepareError sets the error Prepare will return. A non-nil error here means Send never
+// reaches the underlying sendErr check.
+func (s *MockSender) SetPrepareError(err error) {
+	s.prepareErr = err
+}
+
+// mockPreparedSend is the pipeline.PreparedSend returned by MockSender.Prepare.
+type mockPreparedSend struct {
+	s      *MockSender
+	report metrics.StampedMetricReport
+}
+
+func (ps *mockPreparedSend) Send() error {
+	atomic.AddInt32(&ps.s.sendCalls, 1)
+	ps.s.mu.Lock()
+	err := ps.s.sendErr
 	if err == nil {
-		s.reports = append(s.reports, report.MetricReport)
+		ps.s.reports = append(ps.s.reports, ps.report.MetricReport)
 	}
-	s.mu.Unlock()
-	s.called()
+	ps.s.mu.Unlock()
+	ps.s.called()
 	return err
 }
 
+func (ps *mockPreparedSend) Payload() ([]byte, error) {
+	return json.Marshal(ps.report)
+}
+
 func (s *MockSender) Endpoints() []string {
 	return s.endpoints
 }
@@ -103,6 +183,12 @@ func (s *MockSender) SetSendError(err error) {
 	s.sendErr = err
 }
 
+func (s *MockSender) AddWatcher(w pipeline.SendWatcher) {
+	s.mu.Lock()
+	s.watchers = append(s.watchers, w)
+	s.mu.Unlock()
+}
+
 // NewMockSender creates a new MockSender with the given endpoint IDs.
 func NewMockSender(endpoints ...string) *MockSender {
 	ms := &MockSender{endpoints: endpoints}
@@ -129,6 +215,13 @@ func (ep *MockEndpoint) Name() string {
 }
 
 func (ep *MockEndpoint) Send(report endpoint.EndpointReport) error {
+	return ep.SendContext(context.Background(), report)
+}
+
+func (ep *MockEndpoint) SendContext(ctx context.Context, report endpoint.EndpointReport) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	ep.mu.Lock()
 	err := ep.sendErr
 	if err == nil {
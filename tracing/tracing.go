@@ -0,0 +1,194 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing lets the pipeline emit OpenTelemetry spans - one per endpoint send attempt -
+// over OTLP/HTTP, so an operator can follow a single report's Id across queueing delay, attempt
+// number, backoff, and the endpoint's own latency. Unlike endpoint/otlp, which ships the full
+// go.opentelemetry.io/otel SDK's worth of functionality for metrics, tracing here is deliberately
+// minimal: a Tracer produces Spans and exports them best-effort, with no batching, sampling
+// beyond a fixed ratio, or retrying - a dropped span shouldn't cost a pipeline retry.
+package tracing
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const exportTimeout = 5 * time.Second
+
+// Tracer starts Spans and, if cfg is non-nil, exports their Export on a best-effort basis to
+// cfg.Endpoint. A Tracer is safe for concurrent use.
+type Tracer struct {
+	cfg        *config.Tracing
+	resource   *resourcepb.Resource
+	httpClient *http.Client
+}
+
+// NewTracer creates a Tracer from cfg. A nil cfg is valid and produces a Tracer whose Spans are
+// never exported - every StartSpan/End call still works, so callers never need a nil check.
+func NewTracer(cfg *config.Tracing) *Tracer {
+	t := &Tracer{cfg: cfg}
+	if cfg == nil {
+		return t
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "ubbagent"
+	}
+	t.resource = &resourcepb.Resource{
+		Attributes: []*commonpb.KeyValue{
+			{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: serviceName}}},
+		},
+	}
+	t.httpClient = &http.Client{Timeout: exportTimeout}
+	return t
+}
+
+// Span represents a single traced operation - one RetryingSender attempt, for example - between a
+// StartSpan and End call. Its zero value is not usable; create one via Tracer.StartSpan.
+type Span struct {
+	t          *Tracer
+	traceID    []byte
+	spanID     []byte
+	name       string
+	start      time.Time
+	attributes []*commonpb.KeyValue
+}
+
+// StartSpan begins a new Span named name, associated with traceID (typically a
+// metrics.StampedMetricReport.Id, padded/hashed to 16 bytes by TraceIDFromReportID). attrs are
+// attached as span attributes; SetAttribute can add more before End.
+func (t *Tracer) StartSpan(name string, traceID []byte, attrs map[string]string) *Span {
+	s := &Span{t: t, traceID: traceID, spanID: newSpanID(), name: name, start: time.Now()}
+	for k, v := range attrs {
+		s.SetAttribute(k, v)
+	}
+	return s
+}
+
+// SetAttribute attaches an additional string attribute to s. It must be called before End.
+func (s *Span) SetAttribute(key, value string) {
+	s.attributes = append(s.attributes, &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	})
+}
+
+// End finishes s and, if its Tracer is configured with an exporter, ships it to the configured
+// OTLP/HTTP collector in a new goroutine. err, if non-nil, marks the span's status as an error and
+// is recorded as an "error.message" attribute. A failed export is logged and otherwise ignored:
+// losing a span must never affect the send it describes.
+func (s *Span) End(err error) {
+	if s.t.cfg == nil {
+		return
+	}
+	status := &tracepb.Status{Code: tracepb.Status_STATUS_CODE_OK}
+	if err != nil {
+		status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: err.Error()}
+		s.SetAttribute("error.message", err.Error())
+	}
+	span := &tracepb.Span{
+		TraceId:           s.traceID,
+		SpanId:            s.spanID,
+		Name:              s.name,
+		Kind:              tracepb.Span_SPAN_KIND_CLIENT,
+		StartTimeUnixNano: uint64(s.start.UnixNano()),
+		EndTimeUnixNano:   uint64(time.Now().UnixNano()),
+		Attributes:        s.attributes,
+		Status:            status,
+	}
+	go s.t.export(span)
+}
+
+// export POSTs a single ExportTraceServiceRequest containing span to t.cfg.Endpoint.
+func (t *Tracer) export(span *tracepb.Span) {
+	req := &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{
+			{
+				Resource:   t.resource,
+				ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{span}}},
+			},
+		},
+	}
+	body, err := proto.Marshal(req)
+	if err != nil {
+		glog.Warningf("tracing: marshaling span export: %v", err)
+		return
+	}
+	scheme := "https://"
+	if t.cfg.Insecure {
+		scheme = "http://"
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, scheme+t.cfg.Endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		glog.Warningf("tracing: building span export request: %v", err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := t.httpClient.Do(httpReq)
+	if err != nil {
+		glog.Warningf("tracing: exporting span: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		glog.Warningf("tracing: span export rejected: status %v", resp.StatusCode)
+	}
+}
+
+// newSpanID returns a random 8-byte OTLP span ID, taken from the low 8 bytes of a random UUID. If
+// uuid.NewRandom fails - e.g. a transient crypto/rand read error - a dropped span shouldn't cost a
+// pipeline retry any more than a failed export does, so this logs and falls back to a
+// math/rand-sourced ID instead of panicking.
+func newSpanID() []byte {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		glog.Warningf("tracing: generating span ID, falling back to a weaker random source: %v", err)
+		fallback := make([]byte, 8)
+		rand.Read(fallback)
+		return fallback
+	}
+	return id[8:]
+}
+
+// TraceIDFromReportID derives a 16-byte OTLP trace ID from a report ID, so every span produced for
+// the same report - across every RetryingSender attempt - shares one trace. It's a simple FNV-1a
+// expansion rather than a hash chosen for collision resistance: report IDs are already
+// agent-generated UUIDs, so the input space is already well distributed.
+func TraceIDFromReportID(reportID string) []byte {
+	var h1, h2 uint64 = 14695981039346656037, 14695981039346656037 ^ 0x9e3779b97f4a7c15
+	for i := 0; i < len(reportID); i++ {
+		b := reportID[i]
+		h1 = (h1 ^ uint64(b)) * 1099511628211
+		h2 = (h2 ^ uint64(b)) * 1099511628211
+	}
+	id := make([]byte, 16)
+	for i := 0; i < 8; i++ {
+		id[i] = byte(h1 >> (8 * i))
+		id[8+i] = byte(h2 >> (8 * i))
+	}
+	return id
+}
@@ -0,0 +1,100 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/ubbagent/config"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracer_Disabled(t *testing.T) {
+	tr := NewTracer(nil)
+	span := tr.StartSpan("send", TraceIDFromReportID("report1"), map[string]string{"attempt": "1"})
+	// With no cfg, End must not attempt any network I/O; this should return immediately.
+	span.End(nil)
+}
+
+func TestTracer_ExportsSpan(t *testing.T) {
+	received := make(chan *coltracepb.ExportTraceServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading export body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Errorf("unmarshaling export body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- &req
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Tracing{Endpoint: strings.TrimPrefix(srv.URL, "http://"), Insecure: true, ServiceName: "test-agent"}
+	tr := NewTracer(cfg)
+
+	traceID := TraceIDFromReportID("report1")
+	span := tr.StartSpan("retryingsender.send", traceID, map[string]string{"attempt": "2"})
+	span.End(errors.New("boom"))
+
+	select {
+	case req := <-received:
+		if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+			t.Fatalf("unexpected export shape: %+v", req)
+		}
+		got := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+		if got.Name != "retryingsender.send" {
+			t.Errorf("Name = %v, want retryingsender.send", got.Name)
+		}
+		if string(got.TraceId) != string(traceID) {
+			t.Errorf("TraceId = %v, want %v", got.TraceId, traceID)
+		}
+		if got.Status.Code != tracepb.Status_STATUS_CODE_ERROR {
+			t.Errorf("Status.Code = %v, want STATUS_CODE_ERROR", got.Status.Code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for span export")
+	}
+}
+
+func TestTraceIDFromReportID_Deterministic(t *testing.T) {
+	a := TraceIDFromReportID("report1")
+	b := TraceIDFromReportID("report1")
+	c := TraceIDFromReportID("report2")
+	if string(a) != string(b) {
+		t.Error("same report ID produced different trace IDs")
+	}
+	if string(a) == string(c) {
+		t.Error("different report IDs produced the same trace ID")
+	}
+	if len(a) != 16 {
+		t.Errorf("len(traceID) = %v, want 16", len(a))
+	}
+}